@@ -2,17 +2,37 @@ package main
 
 import (
 	"context"
+	"crypto/ed25519"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
+	"github.com/theshovonaha/mini-pam/internal/audit"
+	"github.com/theshovonaha/mini-pam/internal/auth"
 	"github.com/theshovonaha/mini-pam/internal/database"
+	"github.com/theshovonaha/mini-pam/internal/expiry"
+	"github.com/theshovonaha/mini-pam/internal/mail"
+	"github.com/theshovonaha/mini-pam/internal/models"
+	"github.com/theshovonaha/mini-pam/internal/notify"
+	"github.com/theshovonaha/mini-pam/internal/objectstore"
+	"github.com/theshovonaha/mini-pam/internal/pgproxy"
+	"github.com/theshovonaha/mini-pam/internal/recording"
 	"github.com/theshovonaha/mini-pam/internal/server"
+	"github.com/theshovonaha/mini-pam/internal/siem"
+	"github.com/theshovonaha/mini-pam/internal/sshproxy"
+	"github.com/theshovonaha/mini-pam/internal/webhook"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
 )
 
 func main() {
@@ -26,7 +46,88 @@ func main() {
 		dbPassword  = flag.String("db-password", "postgres", "Database password")
 		dbName      = flag.String("db-name", "securevault", "Database name")
 		dbSSLMode   = flag.String("db-sslmode", "disable", "Database SSL mode")
+		sessionTTL  = flag.Duration("session-ttl", 8*time.Hour, "Lifetime of issued bearer tokens")
+		mfaFresh    = flag.Duration("mfa-freshness", 5*time.Minute, "Maximum age of a step-up MFA verification for sensitive operations")
+		authBackend = flag.String("auth-backend", "local", "Login backend (local|ldap)")
+		ldapURL     = flag.String("ldap-url", "", "LDAP server URL (ldaps://, or ldap:// upgraded with StartTLS)")
+		ldapBindDN  = flag.String("ldap-bind-dn", "", "LDAP service account DN used to search for users")
+		ldapBindPw  = flag.String("ldap-bind-password", "", "LDAP service account password")
+		ldapBaseDN  = flag.String("ldap-base-dn", "", "LDAP subtree searched for users")
+		ldapFilter  = flag.String("ldap-user-filter", "(sAMAccountName=%s)", "LDAP user filter, %s is replaced by the username")
+		ldapGroups  = flag.String("ldap-group-attr", "memberOf", "LDAP attribute listing a user's groups")
+		ldapRoleMap = flag.String("ldap-group-map", "", "Path to a JSON file mapping LDAP group DNs to role names")
+		ldapCA      = flag.String("ldap-ca", "", "PEM bundle of CAs trusted for the LDAP server (system roots when empty)")
+		ldapPlain   = flag.Bool("ldap-insecure", false, "Skip StartTLS on ldap:// URLs, sending directory passwords in cleartext")
+		oidcIssuer  = flag.String("oidc-issuer", "", "OpenID Connect issuer URL (enables SSO when set)")
+		oidcClient  = flag.String("oidc-client-id", "", "OpenID Connect client ID")
+		oidcSecret  = flag.String("oidc-client-secret", "", "OpenID Connect client secret")
+		oidcRedir   = flag.String("oidc-redirect-url", "", "OpenID Connect redirect URL (.../api/v1/auth/oidc/callback)")
+		oidcUser    = flag.String("oidc-username-claim", "preferred_username", "ID token claim used as the username")
+		oidcGroups  = flag.String("oidc-groups-claim", "groups", "ID token claim listing the user's groups")
+		oidcRoleMap = flag.String("oidc-group-map", "", "Path to a JSON file mapping OIDC groups to role names")
+		scimToken   = flag.String("scim-token", os.Getenv("SCIM_TOKEN"), "Bearer token for the SCIM provisioning API (disabled when empty)")
+		tlsCert     = flag.String("tls-cert", "", "PEM server certificate (enables HTTPS when set)")
+		tlsKey      = flag.String("tls-key", "", "PEM server private key")
+		tlsClientCA = flag.String("tls-client-ca", "", "PEM bundle of CAs trusted to issue client certificates")
+		tlsRequire  = flag.Bool("tls-require-client-cert", false, "Reject TLS connections without a valid client certificate")
+		lockMax     = flag.Int("lockout-threshold", 5, "Failed logins before an account is locked (0 disables)")
+		lockIPMax   = flag.Int("lockout-ip-threshold", 50, "Failed logins before a source IP is locked (0 disables)")
+		lockFor     = flag.Duration("lockout-duration", 15*time.Minute, "How long a lockout lasts")
+		lockWindow  = flag.Duration("lockout-window", 15*time.Minute, "Quiet period after which failed login counters reset")
+		pwMinLen    = flag.Int("password-min-length", 12, "Minimum length of local passwords")
+		pwClasses   = flag.Int("password-min-classes", 3, "Character classes (lower, upper, digit, symbol) a password must mix")
+		pwBanned    = flag.String("password-banned-list", "", "Path to a file of banned passwords, one per line")
+		pwHistory   = flag.Int("password-history", 5, "Number of previous passwords that may not be reused")
+		pwMaxAge    = flag.Duration("password-max-age", 0, "Age after which a password must be changed at next login (0 disables)")
+		smtpAddr    = flag.String("smtp-addr", "", "SMTP relay host:port (enables password reset emails when set)")
+		smtpFrom    = flag.String("smtp-from", "mini-pam@localhost", "Sender address for outgoing email")
+		smtpUser    = flag.String("smtp-username", "", "SMTP username")
+		smtpPass    = flag.String("smtp-password", os.Getenv("SMTP_PASSWORD"), "SMTP password")
+		resetURL    = flag.String("password-reset-url", "", "Page that receives password reset tokens as ?token=")
+		resetTTL    = flag.Duration("password-reset-ttl", 30*time.Minute, "Lifetime of emailed password reset tokens")
+		s3Endpoint  = flag.String("s3-endpoint", "", "S3-compatible object store endpoint URL")
+		s3Region    = flag.String("s3-region", "us-east-1", "S3 region")
+		s3AccessKey = flag.String("s3-access-key", os.Getenv("AWS_ACCESS_KEY_ID"), "S3 access key ID")
+		s3SecretKey = flag.String("s3-secret-key", os.Getenv("AWS_SECRET_ACCESS_KEY"), "S3 secret access key")
+		ckptKey     = flag.String("audit-checkpoint-key", "", "PEM Ed25519 private key signing audit checkpoints (enables checkpoints when set)")
+		ckptEvery   = flag.Duration("audit-checkpoint-interval", 15*time.Minute, "How often audit checkpoints are written")
+		ckptDir     = flag.String("audit-checkpoint-dir", "", "Directory receiving audit checkpoints")
+		ckptBucket  = flag.String("audit-checkpoint-bucket", "", "S3 bucket receiving audit checkpoints (used when no directory is set)")
+		ckptPrefix  = flag.String("audit-checkpoint-prefix", "audit-checkpoints/", "Key prefix of audit checkpoints")
+		retention   = flag.Int("audit-retention-months", 0, "Full months of audit log kept in the database besides the current one; older months are archived (0 keeps everything)")
+		archiveDir  = flag.String("audit-archive-dir", "", "Directory receiving archived audit log months")
+		archiveKey  = flag.String("audit-archive-key", "", "PEM Ed25519 private key signing audit archives (defaults to -audit-checkpoint-key)")
+		archiveInt  = flag.Duration("audit-archive-interval", 24*time.Hour, "How often audit log partitions are maintained and expired months archived")
+		siemCA      = flag.String("siem-tls-ca", "", "PEM bundle of CAs trusted for TLS SIEM sinks (system roots when empty)")
+		webhookPoll = flag.Duration("webhook-poll-interval", 5*time.Second, "How often the webhook delivery queue is polled (0 disables delivery)")
+		sshAddr     = flag.String("ssh-addr", "", "SSH proxy listen address, e.g. :2222 (disabled when empty)")
+		sshHostKey  = flag.String("ssh-host-key", "", "PEM or OpenSSH private key identifying the SSH proxy")
+		sshKnown    = flag.String("ssh-known-hosts", "", "known_hosts file verifying the host keys of SSH targets")
+		recKey      = flag.String("recording-key", "", "File holding the 32 byte AES key, hex or base64, encrypting SSH session recordings (enables recording when set)")
+		recDir      = flag.String("recording-dir", "", "Directory receiving SSH session recordings")
+		recBucket   = flag.String("recording-bucket", "", "S3 bucket receiving SSH session recordings (used when no directory is set)")
+		recPrefix   = flag.String("recording-prefix", "recordings/", "Key prefix of SSH session recordings")
+		recInput    = flag.Bool("recording-input", false, "Record keystrokes in SSH sessions as well as output")
+		pgAddr      = flag.String("pg-addr", "", "Postgres proxy listen address, e.g. :6432 (disabled when empty)")
+		pgTLSCert   = flag.String("pg-tls-cert", "", "PEM certificate offered to Postgres proxy clients (TLS is then required of them)")
+		pgTLSKey    = flag.String("pg-tls-key", "", "PEM private key of -pg-tls-cert")
+		pgUpstream  = flag.String("pg-upstream-tls", "verify", "TLS to target databases: disable, require (unverified) or verify")
+		pgUpCA      = flag.String("pg-upstream-ca", "", "PEM bundle of CAs trusted for target databases with -pg-upstream-tls=verify (system roots when empty)")
+		pgWeakAuth  = flag.Bool("pg-upstream-weak-auth", false, "Answer cleartext and MD5 password requests from target databases not reached over verified TLS")
+		webDomain   = flag.String("web-proxy-domain", "", "Parent domain serving each web console on a host of its own, <credential ID>.<domain>, which needs wildcard DNS and certificate (web consoles are disabled when empty)")
+		webTTL      = flag.Duration("web-proxy-ttl", time.Hour, "Lifetime of web console sessions")
+		webCA       = flag.String("web-proxy-ca", "", "PEM bundle of CAs trusted for proxied web consoles (system roots when empty)")
+		expWindows  = flag.String("expiry-windows", "30,7,1", "Days before a credential expires at which its owner is warned, comma separated")
+		expEvery    = flag.Duration("expiry-check-interval", 24*time.Hour, "How often credentials are checked for upcoming expiry (0 disables warnings)")
+		notifyHook  = flag.String("notify-webhook-url", "", "HTTP endpoint receiving notifications as signed JSON")
+		notifySecr  = flag.String("notify-webhook-secret", os.Getenv("NOTIFY_WEBHOOK_SECRET"), "Secret signing notifications posted to -notify-webhook-url (unsigned when empty)")
+		notifySlack = flag.String("notify-slack-webhook", os.Getenv("NOTIFY_SLACK_WEBHOOK"), "Slack-compatible incoming webhook URL receiving notifications")
+		notifyTeams = flag.String("notify-teams-webhook", os.Getenv("NOTIFY_TEAMS_WEBHOOK"), "Microsoft Teams incoming webhook URL receiving notifications")
+		notifyTmpl  = flag.String("notify-templates", "", "Directory of <event>.tmpl files overriding the built-in notification templates")
+		notifyPoll  = flag.Duration("notify-poll-interval", 5*time.Second, "How often the notification outbox is polled")
+		siemSinks   stringList
 	)
+	flag.Var(&siemSinks, "siem-sink", "SIEM destination as format+transport://host:port, e.g. cef+tls://siem:6514 (repeatable)")
 	flag.Parse()
 
 	// Initialize the logger
@@ -46,8 +147,353 @@ func main() {
 	}
 	defer db.Close()
 
+	// Select the login backend
+	var authenticator auth.Authenticator
+	switch *authBackend {
+	case "local":
+		// The server defaults to the users table
+	case "ldap":
+		groupRoles, err := loadGroupMap(*ldapRoleMap)
+		if err != nil {
+			logger.Fatalf("Failed to load LDAP group map: %v", err)
+		}
+
+		var ldapTLS *tls.Config
+		if *ldapCA != "" {
+			pem, err := os.ReadFile(*ldapCA)
+			if err != nil {
+				logger.Fatalf("Failed to read LDAP CA bundle: %v", err)
+			}
+			rootCAs := x509.NewCertPool()
+			if !rootCAs.AppendCertsFromPEM(pem) {
+				logger.Fatalf("No certificates found in %s", *ldapCA)
+			}
+			ldapTLS = &tls.Config{RootCAs: rootCAs, MinVersion: tls.VersionTLS12}
+		}
+		if *ldapPlain {
+			logger.Printf("LDAP StartTLS is disabled; directory passwords are sent in cleartext")
+		}
+
+		authenticator = auth.NewLDAPAuthenticator(auth.LDAPConfig{
+			URL:            *ldapURL,
+			BindDN:         *ldapBindDN,
+			BindPassword:   *ldapBindPw,
+			BaseDN:         *ldapBaseDN,
+			UserFilter:     *ldapFilter,
+			GroupAttribute: *ldapGroups,
+			GroupRoles:     groupRoles,
+			TLSConfig:      ldapTLS,
+			Insecure:       *ldapPlain,
+		})
+	default:
+		logger.Fatalf("Unknown auth backend %q", *authBackend)
+	}
+
+	// Configure single sign-on
+	var oidc *auth.OIDCProvider
+	if *oidcIssuer != "" {
+		groupRoles, err := loadGroupMap(*oidcRoleMap)
+		if err != nil {
+			logger.Fatalf("Failed to load OIDC group map: %v", err)
+		}
+
+		oidc = auth.NewOIDCProvider(auth.OIDCConfig{
+			Issuer:        *oidcIssuer,
+			ClientID:      *oidcClient,
+			ClientSecret:  *oidcSecret,
+			RedirectURL:   *oidcRedir,
+			Scopes:        []string{"profile", "email"},
+			UsernameClaim: *oidcUser,
+			GroupsClaim:   *oidcGroups,
+			GroupRoles:    groupRoles,
+		})
+	}
+
+	// Throttle password guessing
+	lockout := auth.DefaultLockoutPolicy()
+	lockout.Threshold = *lockMax
+	lockout.IPThreshold = *lockIPMax
+	lockout.Duration = *lockFor
+	lockout.Window = *lockWindow
+
+	// Enforce the local password policy
+	passwordPolicy := auth.PasswordPolicy{
+		MinLength:    *pwMinLen,
+		MinCharClass: *pwClasses,
+		HistorySize:  *pwHistory,
+		MaxAge:       *pwMaxAge,
+	}
+	if *pwBanned != "" {
+		passwordPolicy.Banned, err = auth.LoadBannedPasswords(*pwBanned)
+		if err != nil {
+			logger.Fatalf("Failed to load banned password list: %v", err)
+		}
+	}
+
+	// Configure outgoing email
+	var mailer mail.Mailer
+	if *smtpAddr != "" {
+		mailer = mail.NewSMTPMailer(mail.SMTPConfig{
+			Addr:     *smtpAddr,
+			From:     *smtpFrom,
+			Username: *smtpUser,
+			Password: *smtpPass,
+		})
+	}
+
+	// Notify users through email and any configured webhooks
+	var notifyChannels []notify.Channel
+	if mailer != nil {
+		notifyChannels = append(notifyChannels, &notify.EmailChannel{Mailer: mailer})
+	}
+	if *notifyHook != "" {
+		notifyChannels = append(notifyChannels, notify.NewWebhookChannel(*notifyHook, *notifySecr))
+	}
+	if *notifySlack != "" {
+		notifyChannels = append(notifyChannels, notify.NewSlackChannel(*notifySlack))
+	}
+	if *notifyTeams != "" {
+		notifyChannels = append(notifyChannels, notify.NewTeamsChannel(*notifyTeams))
+	}
+	var notifier *notify.Notifier
+	if len(notifyChannels) > 0 {
+		templates, err := notify.LoadTemplates(*notifyTmpl)
+		if err != nil {
+			logger.Fatalf("Failed to load notification templates: %v", err)
+		}
+		notifications := models.NewNotificationRepository(db)
+		notifier = notify.New(notifications, notifications, templates, notifyChannels, logger)
+	}
+
+	// Warn owners ahead of credential expiry
+	expiryWindows, err := parseDays(*expWindows)
+	if err != nil {
+		logger.Fatalf("Invalid -expiry-windows: %v", err)
+	}
+	if *expEvery > 0 && notifier == nil {
+		logger.Printf("No notification channel configured; credential expiry warnings are disabled")
+	}
+
+	// Sign periodic audit checkpoints and store them outside the database
+	var checkpointer *audit.Checkpointer
+	if *ckptKey != "" {
+		key, err := audit.LoadSigningKey(*ckptKey)
+		if err != nil {
+			logger.Fatalf("Failed to load audit checkpoint key: %v", err)
+		}
+
+		var store objectstore.Store
+		switch {
+		case *ckptDir != "":
+			store, err = objectstore.NewDirStore(*ckptDir)
+			if err != nil {
+				logger.Fatalf("Failed to open audit checkpoint directory: %v", err)
+			}
+		case *ckptBucket != "":
+			store = objectstore.NewS3Store(objectstore.S3Config{
+				Endpoint:        *s3Endpoint,
+				Region:          *s3Region,
+				Bucket:          *ckptBucket,
+				AccessKeyID:     *s3AccessKey,
+				SecretAccessKey: *s3SecretKey,
+			})
+		default:
+			logger.Fatalf("Audit checkpoints need -audit-checkpoint-dir or -audit-checkpoint-bucket")
+		}
+
+		checkpointer = audit.NewCheckpointer(store, *ckptPrefix, key, models.NewAuditLogRepository(db).ListAfter)
+	}
+
+	// Keep the audit log partitioned by month and archive expired months
+	var archiveSigner ed25519.PrivateKey
+	if *retention > 0 {
+		if *archiveDir == "" {
+			logger.Fatalf("Audit log retention needs -audit-archive-dir")
+		}
+		if *archiveKey == "" {
+			*archiveKey = *ckptKey
+		}
+		if *archiveKey == "" {
+			logger.Fatalf("Audit log retention needs -audit-archive-key or -audit-checkpoint-key")
+		}
+		archiveSigner, err = audit.LoadSigningKey(*archiveKey)
+		if err != nil {
+			logger.Fatalf("Failed to load audit archive key: %v", err)
+		}
+		if err := os.MkdirAll(*archiveDir, 0o700); err != nil {
+			logger.Fatalf("Failed to open audit archive directory: %v", err)
+		}
+	}
+	archiver := audit.NewArchiver(models.NewAuditLogRepository(db), models.NewAuditArchiveRepository(db),
+		*archiveDir, archiveSigner, *retention)
+
+	// Keep encrypted recordings of SSH sessions
+	var recordings *recording.Store
+	if *recKey != "" {
+		key, err := recording.LoadKey(*recKey)
+		if err != nil {
+			logger.Fatalf("Failed to load recording key: %v", err)
+		}
+
+		var store objectstore.Store
+		switch {
+		case *recDir != "":
+			store, err = objectstore.NewDirStore(*recDir)
+			if err != nil {
+				logger.Fatalf("Failed to open recording directory: %v", err)
+			}
+		case *recBucket != "":
+			store = objectstore.NewS3Store(objectstore.S3Config{
+				Endpoint:        *s3Endpoint,
+				Region:          *s3Region,
+				Bucket:          *recBucket,
+				AccessKeyID:     *s3AccessKey,
+				SecretAccessKey: *s3SecretKey,
+			})
+		default:
+			logger.Fatalf("Session recording needs -recording-dir or -recording-bucket")
+		}
+
+		recordings, err = recording.NewStore(store, *recPrefix, key)
+		if err != nil {
+			logger.Fatalf("Failed to configure session recording: %v", err)
+		}
+	}
+
+	// Forward audit events to the SIEM
+	var events *siem.Pipeline
+	if len(siemSinks) > 0 {
+		var rootCAs *x509.CertPool
+		if *siemCA != "" {
+			pem, err := os.ReadFile(*siemCA)
+			if err != nil {
+				logger.Fatalf("Failed to read SIEM CA bundle: %v", err)
+			}
+			rootCAs = x509.NewCertPool()
+			if !rootCAs.AppendCertsFromPEM(pem) {
+				logger.Fatalf("No certificates found in %s", *siemCA)
+			}
+		}
+
+		configs := []siem.SinkConfig{}
+		for _, raw := range siemSinks {
+			config, err := siem.ParseSinkURL(raw)
+			if err != nil {
+				logger.Fatalf("Invalid SIEM sink: %v", err)
+			}
+			if config.Network == "tls" {
+				config.TLS = &tls.Config{RootCAs: rootCAs, MinVersion: tls.VersionTLS12}
+			}
+			configs = append(configs, config)
+		}
+		events = siem.NewPipeline(configs, logger)
+	}
+
+	// Trust the web consoles' own CAs when given
+	var webTLS *tls.Config
+	if *webCA != "" {
+		pem, err := os.ReadFile(*webCA)
+		if err != nil {
+			logger.Fatalf("Failed to read web console CA bundle: %v", err)
+		}
+		rootCAs := x509.NewCertPool()
+		if !rootCAs.AppendCertsFromPEM(pem) {
+			logger.Fatalf("No certificates found in %s", *webCA)
+		}
+		webTLS = &tls.Config{RootCAs: rootCAs, MinVersion: tls.VersionTLS12}
+	}
+
 	// Create a new server instance
-	srv := server.NewServer(*environment, logger, db)
+	srv := server.NewServer(server.Config{
+		Environment:      *environment,
+		SessionTTL:       *sessionTTL,
+		MFAFreshness:     *mfaFresh,
+		Authenticator:    authenticator,
+		OIDC:             oidc,
+		SCIMToken:        *scimToken,
+		Lockout:          lockout,
+		PasswordPolicy:   passwordPolicy,
+		Mailer:           mailer,
+		PasswordResetTTL: *resetTTL,
+		PasswordResetURL: *resetURL,
+		Events:           events,
+		Recordings:       recordings,
+		RecordInput:      *recInput,
+		Notifier:         notifier,
+		WebProxyDomain:   *webDomain,
+		WebProxyTTL:      *webTTL,
+		WebProxyTLS:      webTLS,
+	}, logger, db)
+
+	// Relay SSH users to their targets with vaulted credentials
+	var sshProxy *sshproxy.Proxy
+	if *sshAddr != "" {
+		if *sshHostKey == "" || *sshKnown == "" {
+			logger.Fatalf("The SSH proxy needs -ssh-host-key and -ssh-known-hosts")
+		}
+		pem, err := os.ReadFile(*sshHostKey)
+		if err != nil {
+			logger.Fatalf("Failed to read SSH host key: %v", err)
+		}
+		hostKey, err := ssh.ParsePrivateKey(pem)
+		if err != nil {
+			logger.Fatalf("Failed to parse SSH host key: %v", err)
+		}
+		hostKeyCallback, err := knownhosts.New(*sshKnown)
+		if err != nil {
+			logger.Fatalf("Failed to load SSH known hosts: %v", err)
+		}
+
+		sshProxy, err = sshproxy.NewProxy(srv.SSHBackend(), sshproxy.Config{
+			HostKey:         hostKey,
+			HostKeyCallback: hostKeyCallback,
+		}, logger)
+		if err != nil {
+			logger.Fatalf("Failed to configure SSH proxy: %v", err)
+		}
+	}
+
+	// Relay Postgres users to their target databases with vaulted credentials
+	var pgProxy *pgproxy.Proxy
+	if *pgAddr != "" {
+		var config pgproxy.Config
+		if *pgTLSCert != "" {
+			config.TLS, err = server.NewTLSConfig(server.TLSConfig{CertFile: *pgTLSCert, KeyFile: *pgTLSKey})
+			if err != nil {
+				logger.Fatalf("Failed to configure Postgres proxy TLS: %v", err)
+			}
+		}
+		switch *pgUpstream {
+		case "disable":
+		case "require":
+			// Encrypts traffic to targets whose certificates cannot be verified
+			config.UpstreamTLS = &tls.Config{InsecureSkipVerify: true, MinVersion: tls.VersionTLS12}
+		case "verify":
+			var rootCAs *x509.CertPool
+			if *pgUpCA != "" {
+				pem, err := os.ReadFile(*pgUpCA)
+				if err != nil {
+					logger.Fatalf("Failed to read Postgres upstream CA bundle: %v", err)
+				}
+				rootCAs = x509.NewCertPool()
+				if !rootCAs.AppendCertsFromPEM(pem) {
+					logger.Fatalf("No certificates found in %s", *pgUpCA)
+				}
+			}
+			config.UpstreamTLS = &tls.Config{RootCAs: rootCAs, MinVersion: tls.VersionTLS12}
+		default:
+			logger.Fatalf("Unknown -pg-upstream-tls mode %q", *pgUpstream)
+		}
+		config.AllowWeakAuth = *pgWeakAuth
+		if *pgWeakAuth && *pgUpstream != "verify" {
+			logger.Printf("Postgres proxy answers cleartext and MD5 password requests from unverified targets")
+		}
+
+		pgProxy, err = pgproxy.NewProxy(srv.PGBackend(), config, logger)
+		if err != nil {
+			logger.Fatalf("Failed to configure Postgres proxy: %v", err)
+		}
+	}
 
 	// Start the HTTP server
 	httpServer := &http.Server{
@@ -58,13 +504,101 @@ func main() {
 		WriteTimeout: 30 * time.Second,
 	}
 
+	// Enable TLS, and client certificate verification for machine clients
+	if *tlsCert != "" {
+		tlsConfig, err := server.NewTLSConfig(server.TLSConfig{
+			CertFile:          *tlsCert,
+			KeyFile:           *tlsKey,
+			ClientCAFile:      *tlsClientCA,
+			RequireClientCert: *tlsRequire,
+		})
+		if err != nil {
+			logger.Fatalf("Failed to configure TLS: %v", err)
+		}
+		httpServer.TLSConfig = tlsConfig
+	}
+
 	// Start the server in a goroutine so that it doesn't block
 	serverErrors := make(chan error, 1)
 	go func() {
+		if httpServer.TLSConfig != nil {
+			logger.Printf("Starting TLS server on port %d in %s mode", *port, *environment)
+			serverErrors <- httpServer.ListenAndServeTLS("", "")
+			return
+		}
 		logger.Printf("Starting server on port %d in %s mode", *port, *environment)
 		serverErrors <- httpServer.ListenAndServe()
 	}()
 
+	// Start the SSH proxy
+	if sshProxy != nil {
+		go func() {
+			logger.Printf("Starting SSH proxy on %s", *sshAddr)
+			if err := sshProxy.ListenAndServe(*sshAddr); err != nil {
+				serverErrors <- err
+			}
+		}()
+	}
+
+	// Start the Postgres proxy
+	if pgProxy != nil {
+		go func() {
+			logger.Printf("Starting Postgres proxy on %s", *pgAddr)
+			if err := pgProxy.ListenAndServe(*pgAddr); err != nil {
+				serverErrors <- err
+			}
+		}()
+	}
+
+	// Start writing audit checkpoints
+	checkpointCtx, stopCheckpoints := context.WithCancel(context.Background())
+	checkpointsDone := make(chan struct{})
+	go func() {
+		defer close(checkpointsDone)
+		if checkpointer != nil {
+			checkpointer.Run(checkpointCtx, *ckptEvery, logger)
+		}
+	}()
+
+	// Maintain audit log partitions
+	archiveCtx, stopArchiver := context.WithCancel(context.Background())
+	archiverDone := make(chan struct{})
+	go func() {
+		defer close(archiverDone)
+		archiver.Run(archiveCtx, *archiveInt, logger)
+	}()
+
+	// Deliver queued webhooks
+	webhookCtx, stopWebhooks := context.WithCancel(context.Background())
+	webhooksDone := make(chan struct{})
+	go func() {
+		defer close(webhooksDone)
+		if *webhookPoll > 0 {
+			webhook.NewDispatcher(models.NewWebhookRepository(db), logger).Run(webhookCtx, *webhookPoll)
+		}
+	}()
+
+	// Deliver queued notifications
+	notifyCtx, stopNotify := context.WithCancel(context.Background())
+	notifyDone := make(chan struct{})
+	go func() {
+		defer close(notifyDone)
+		if notifier != nil {
+			notifier.Run(notifyCtx, *notifyPoll)
+		}
+	}()
+
+	// Warn owners of expiring credentials
+	expiryCtx, stopExpiry := context.WithCancel(context.Background())
+	expiryDone := make(chan struct{})
+	go func() {
+		defer close(expiryDone)
+		if *expEvery > 0 && notifier != nil {
+			checker := expiry.NewChecker(models.NewCredentialRepository(db), models.NewUserRepository(db), notifier, expiryWindows)
+			checker.Run(expiryCtx, *expEvery, logger)
+		}
+	}()
+
 	// Create a channel to listen for OS signals
 	shutdown := make(chan os.Signal, 1)
 	signal.Notify(shutdown, os.Interrupt, syscall.SIGINT, syscall.SIGTERM)
@@ -86,6 +620,77 @@ func main() {
 			httpServer.Close()
 		}
 
+		// Disconnect proxy sessions before the final audit entries are written
+		if sshProxy != nil {
+			sshProxy.Close()
+		}
+		if pgProxy != nil {
+			pgProxy.Close()
+		}
+
+		// Cover the final requests with one last checkpoint
+		stopCheckpoints()
+		<-checkpointsDone
+
+		// Let a running archive finish rather than leave a half-written file
+		stopArchiver()
+		<-archiverDone
+
+		// Stop delivering webhooks; in-flight deliveries are retried on restart
+		stopWebhooks()
+		<-webhooksDone
+		stopExpiry()
+		<-expiryDone
+
+		// Stop delivering notifications; unsent ones wait in the outbox
+		stopNotify()
+		<-notifyDone
+
+		// Give the SIEM sinks a moment to deliver buffered events
+		events.Close(5 * time.Second)
+
 		logger.Println("Server stopped")
 	}
 }
+
+// stringList collects the values of a repeatable flag
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// parseDays parses a comma separated list of positive day counts
+func parseDays(list string) ([]int, error) {
+	days := []int{}
+	for _, field := range strings.Split(list, ",") {
+		d, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("%q is not a positive number of days", field)
+		}
+		days = append(days, d)
+	}
+	return days, nil
+}
+
+// loadGroupMap reads a JSON object mapping group names to role names
+func loadGroupMap(path string) (map[string]string, error) {
+	groupRoles := map[string]string{}
+	if path == "" {
+		return groupRoles, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &groupRoles); err != nil {
+		return nil, err
+	}
+	return groupRoles, nil
+}
@@ -0,0 +1,314 @@
+// Command pamctl is the operator tool for mini-pam. It talks to the
+// database directly so it can check the server's work independently.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/theshovonaha/mini-pam/internal/audit"
+	"github.com/theshovonaha/mini-pam/internal/database"
+	"github.com/theshovonaha/mini-pam/internal/models"
+	"github.com/theshovonaha/mini-pam/internal/objectstore"
+)
+
+const usage = `Usage: pamctl <command> [flags]
+
+Commands:
+  audit verify               Walk the audit log hash chain and report the first broken link
+  audit verify-checkpoints   Check signed audit checkpoints against the database
+  audit restore              Re-import an archived month of audit log for an investigation
+
+Run "pamctl <command> -h" for the flags of a command.
+`
+
+func main() {
+	if len(os.Args) < 3 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	command, args := os.Args[1]+" "+os.Args[2], os.Args[3:]
+	switch command {
+	case "audit verify":
+		os.Exit(auditVerify(args))
+	case "audit verify-checkpoints":
+		os.Exit(auditVerifyCheckpoints(args))
+	case "audit restore":
+		os.Exit(auditRestore(args))
+	default:
+		fmt.Fprintf(os.Stderr, "pamctl: unknown command %q\n\n%s", command, usage)
+		os.Exit(2)
+	}
+}
+
+// databaseFlags registers the connection flags shared by every command
+func databaseFlags(fs *flag.FlagSet) *database.Config {
+	cfg := &database.Config{}
+	fs.StringVar(&cfg.Host, "db-host", "localhost", "Database host")
+	fs.IntVar(&cfg.Port, "db-port", 5432, "Database port")
+	fs.StringVar(&cfg.User, "db-user", "postgres", "Database user")
+	fs.StringVar(&cfg.Password, "db-password", os.Getenv("PGPASSWORD"), "Database password")
+	fs.StringVar(&cfg.DBName, "db-name", "securevault", "Database name")
+	fs.StringVar(&cfg.SSLMode, "db-sslmode", "disable", "Database SSL mode")
+	return cfg
+}
+
+// storeFlags registers the flags selecting an object store; open returns
+// the store once the flags are parsed
+func storeFlags(fs *flag.FlagSet, name string) (open func() (objectstore.Store, error)) {
+	dir := fs.String(name+"-dir", "", "Directory holding the objects")
+	bucket := fs.String(name+"-bucket", "", "S3 bucket holding the objects (used when no directory is set)")
+	s3 := objectstore.S3Config{}
+	fs.StringVar(&s3.Endpoint, "s3-endpoint", "", "S3-compatible object store endpoint URL")
+	fs.StringVar(&s3.Region, "s3-region", "us-east-1", "S3 region")
+	fs.StringVar(&s3.AccessKeyID, "s3-access-key", os.Getenv("AWS_ACCESS_KEY_ID"), "S3 access key ID")
+	fs.StringVar(&s3.SecretAccessKey, "s3-secret-key", os.Getenv("AWS_SECRET_ACCESS_KEY"), "S3 secret access key")
+
+	return func() (objectstore.Store, error) {
+		switch {
+		case *dir != "":
+			return objectstore.NewDirStore(*dir)
+		case *bucket != "":
+			s3.Bucket = *bucket
+			return objectstore.NewS3Store(s3), nil
+		}
+		return nil, fmt.Errorf("-%s-dir or -%s-bucket is required", name, name)
+	}
+}
+
+// listFunc pages through the audit log in ID order, like AuditLogRepository.ListAfter
+type listFunc = func(afterID, limit int) ([]*models.AuditLog, error)
+
+// archiveFlags registers the flags that read archived audit log months;
+// wrap extends a database list function with the archives once the flags
+// are parsed, or returns nil if no archive directory was given
+func archiveFlags(fs *flag.FlagSet) (wrap func(db listFunc) (listFunc, error)) {
+	dir := fs.String("archive-dir", "", "Directory of audit archives to verify along with the database")
+	keyPath := fs.String("archive-public-key", "", "PEM Ed25519 public key of the archive signer")
+
+	return func(db listFunc) (listFunc, error) {
+		if *dir == "" {
+			return nil, nil
+		}
+		if *keyPath == "" {
+			return nil, errors.New("-archive-public-key is required with -archive-dir")
+		}
+		key, err := audit.LoadVerifyKey(*keyPath)
+		if err != nil {
+			return nil, err
+		}
+		return audit.WithArchives(*dir, key, db)
+	}
+}
+
+// connect opens the database without the server's connection logging
+func connect(cfg *database.Config) (*database.Connection, error) {
+	return database.NewConnection(*cfg, log.New(io.Discard, "", 0))
+}
+
+// auditVerify implements "pamctl audit verify"; it exits 1 when the chain is broken
+func auditVerify(args []string) int {
+	fs := flag.NewFlagSet("audit verify", flag.ExitOnError)
+	dbConfig := databaseFlags(fs)
+	withArchives := archiveFlags(fs)
+	fs.Parse(args)
+
+	db, err := connect(dbConfig)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "pamctl: %v\n", err)
+		return 2
+	}
+	defer db.Close()
+
+	// Walk the archives too when given, otherwise start after the newest one
+	list, err := withArchives(models.NewAuditLogRepository(db).ListAfter)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "pamctl: %v\n", err)
+		return 2
+	}
+	var report *audit.ChainReport
+	if list != nil {
+		report, err = audit.VerifyChain(list)
+	} else {
+		archive, latestErr := models.NewAuditArchiveRepository(db).Latest()
+		if latestErr != nil && !errors.Is(latestErr, models.ErrRecordNotFound) {
+			fmt.Fprintf(os.Stderr, "pamctl: failed to read audit archives: %v\n", latestErr)
+			return 2
+		}
+		report, err = audit.VerifyChainAfter(archive, models.NewAuditLogRepository(db).ListAfter)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "pamctl: failed to read audit log: %v\n", err)
+		return 2
+	}
+
+	if report.Unchained > 0 {
+		fmt.Printf("%d entries predate the hash chain and were not verified\n", report.Unchained)
+	}
+	if report.Archived > 0 {
+		fmt.Printf("Entries up to %d are archived and were not verified; pass -archive-dir to include them\n", report.Archived)
+	}
+	if !report.Valid {
+		fmt.Printf("Audit chain BROKEN at entry %d: %s\n", report.FirstBroken.ID, report.FirstBroken.Reason)
+		fmt.Printf("%d entries verified before the break\n", report.Checked)
+		return 1
+	}
+
+	fmt.Printf("Audit chain intact: %d entries verified", report.Checked)
+	if report.LastID != 0 {
+		fmt.Printf(", last entry %d hash %s", report.LastID, report.LastHash)
+	}
+	fmt.Println()
+	return 0
+}
+
+// auditVerifyCheckpoints implements "pamctl audit verify-checkpoints"; it
+// exits 1 when a checkpoint does not match the database
+func auditVerifyCheckpoints(args []string) int {
+	fs := flag.NewFlagSet("audit verify-checkpoints", flag.ExitOnError)
+	dbConfig := databaseFlags(fs)
+	openStore := storeFlags(fs, "checkpoint")
+	prefix := fs.String("checkpoint-prefix", "audit-checkpoints/", "Key prefix of the checkpoints")
+	keyPath := fs.String("public-key", "", "PEM Ed25519 public key of the checkpoint signer")
+	withArchives := archiveFlags(fs)
+	fs.Parse(args)
+
+	if *keyPath == "" {
+		fmt.Fprintln(os.Stderr, "pamctl: -public-key is required")
+		return 2
+	}
+	key, err := audit.LoadVerifyKey(*keyPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "pamctl: %v\n", err)
+		return 2
+	}
+
+	store, err := openStore()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "pamctl: %v\n", err)
+		return 2
+	}
+	checkpoints, err := audit.LoadCheckpoints(store, *prefix)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "pamctl: failed to read checkpoints: %v\n", err)
+		return 2
+	}
+
+	db, err := connect(dbConfig)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "pamctl: %v\n", err)
+		return 2
+	}
+	defer db.Close()
+
+	// Checkpoints cover archived months as well; read those from the archives
+	list, err := withArchives(models.NewAuditLogRepository(db).ListAfter)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "pamctl: %v\n", err)
+		return 2
+	}
+	if list == nil {
+		list = models.NewAuditLogRepository(db).ListAfter
+	}
+
+	report, err := audit.VerifyCheckpoints(checkpoints, key, list)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "pamctl: failed to read audit log: %v\n", err)
+		return 2
+	}
+
+	if !report.Valid {
+		fmt.Printf("Checkpoint %d FAILED: %s\n", report.FirstFailure.Sequence, report.FirstFailure.Reason)
+		fmt.Printf("%d checkpoints covering %d entries verified before the failure\n", report.Checkpoints, report.Entries)
+		return 1
+	}
+
+	fmt.Printf("%d checkpoints verified covering %d entries; %d newer entries await the next checkpoint\n",
+		report.Checkpoints, report.Entries, report.Uncovered)
+	return 0
+}
+
+// restoreBatchSize is the number of entries inserted per transaction during a restore
+const restoreBatchSize = 1000
+
+// auditRestore implements "pamctl audit restore". It verifies an archived
+// month and imports it back into its partition, where the audit log API can
+// query it; the server drops it again once the restore hold ends.
+func auditRestore(args []string) int {
+	fs := flag.NewFlagSet("audit restore", flag.ExitOnError)
+	dbConfig := databaseFlags(fs)
+	dir := fs.String("archive-dir", "", "Directory holding the audit archives")
+	keyPath := fs.String("public-key", "", "PEM Ed25519 public key of the archive signer")
+	month := fs.String("month", "", "Month to restore, as YYYY-MM")
+	fs.Parse(args)
+
+	if *dir == "" || *keyPath == "" || *month == "" {
+		fmt.Fprintln(os.Stderr, "pamctl: -archive-dir, -public-key and -month are required")
+		return 2
+	}
+	key, err := audit.LoadVerifyKey(*keyPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "pamctl: %v\n", err)
+		return 2
+	}
+
+	reader, err := audit.OpenArchive(*dir, *month, key)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "pamctl: %v\n", err)
+		return 1
+	}
+	defer reader.Close()
+	record := reader.Manifest.Record()
+
+	db, err := connect(dbConfig)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "pamctl: %v\n", err)
+		return 2
+	}
+	defer db.Close()
+	logs := models.NewAuditLogRepository(db)
+
+	if err := logs.CreatePartition(models.AuditPartitionFor(record.Month)); err != nil {
+		fmt.Fprintf(os.Stderr, "pamctl: failed to create partition: %v\n", err)
+		return 2
+	}
+
+	// Entries are checked against the signed manifest as they are read; a
+	// failure part way leaves the imported batches, which a rerun skips
+	restored := 0
+	batch := []*models.AuditLog{}
+	for {
+		entry, err := reader.Next()
+		if err != nil && !errors.Is(err, io.EOF) {
+			fmt.Fprintf(os.Stderr, "pamctl: %v\n", err)
+			return 1
+		}
+		if entry != nil {
+			batch = append(batch, entry)
+		}
+		if len(batch) == restoreBatchSize || (errors.Is(err, io.EOF) && len(batch) > 0) {
+			if err := logs.Import(batch); err != nil {
+				fmt.Fprintf(os.Stderr, "pamctl: failed to import entries: %v\n", err)
+				return 2
+			}
+			restored += len(batch)
+			batch = batch[:0]
+		}
+		if errors.Is(err, io.EOF) {
+			break
+		}
+	}
+
+	if err := models.NewAuditArchiveRepository(db).MarkRestored(record); err != nil {
+		fmt.Fprintf(os.Stderr, "pamctl: failed to record the restore: %v\n", err)
+		return 2
+	}
+
+	fmt.Printf("Restored %d audit entries from %s (IDs %d-%d)\n", restored, *month, record.FirstID, record.LastID)
+	return 0
+}
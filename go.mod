@@ -5,9 +5,6 @@ go 1.24.2
 require (
 	github.com/gorilla/mux v1.8.1
 	github.com/lib/pq v1.10.9
-)
-
-require (
-	golang.org/x/crypto v0.36.0 // indirect
-	golang.org/x/time v0.11.0 // indirect
+	golang.org/x/crypto v0.36.0
+	golang.org/x/time v0.11.0
 )
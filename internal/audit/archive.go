@@ -0,0 +1,401 @@
+package audit
+
+import (
+	"bufio"
+	"compress/gzip"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/theshovonaha/mini-pam/internal/models"
+)
+
+// ArchiveManifest describes and signs one archived month. The entries live
+// next to it in a gzipped JSON-lines file whose SHA-256 the manifest covers.
+type ArchiveManifest struct {
+	Month         string    `json:"month"` // YYYY-MM
+	File          string    `json:"file"`
+	SHA256        string    `json:"sha256"`
+	Size          int64     `json:"size"`
+	FirstID       int       `json:"first_id"`
+	LastID        int       `json:"last_id"`
+	Count         int       `json:"count"`
+	FirstPrevHash string    `json:"first_prev_hash"` // links the archive to the month before it
+	LastHash      string    `json:"last_hash"`       // links the month after it to this archive
+	CreatedAt     time.Time `json:"created_at"`
+	KeyID         string    `json:"key_id"`
+	Signature     string    `json:"signature"`
+}
+
+// signedPayload is the exact byte string the manifest signature covers
+func (m *ArchiveManifest) signedPayload() []byte {
+	return []byte(fmt.Sprintf("mini-pam audit archive v1\n%s\n%s\n%s\n%d\n%d\n%d\n%d\n%s\n%s\n%s\n%s\n",
+		m.Month, m.File, m.SHA256, m.Size, m.FirstID, m.LastID, m.Count,
+		m.FirstPrevHash, m.LastHash, m.CreatedAt.UTC().Format(time.RFC3339Nano), m.KeyID))
+}
+
+// Verify checks the manifest's signature against key
+func (m *ArchiveManifest) Verify(key ed25519.PublicKey) error {
+	if m.KeyID != KeyID(key) {
+		return fmt.Errorf("archive %s was signed by key %s, not %s", m.Month, m.KeyID, KeyID(key))
+	}
+	signature, err := base64.StdEncoding.DecodeString(m.Signature)
+	if err != nil || !ed25519.Verify(key, m.signedPayload(), signature) {
+		return fmt.Errorf("archive %s has an invalid signature", m.Month)
+	}
+	return nil
+}
+
+// Record converts the manifest to the database record of the archive
+func (m *ArchiveManifest) Record() *models.AuditArchive {
+	month, _ := time.Parse("2006-01", m.Month)
+	return &models.AuditArchive{
+		Month:    month,
+		FileName: m.File,
+		SHA256:   m.SHA256,
+		FirstID:  m.FirstID,
+		LastID:   m.LastID,
+		Count:    m.Count,
+		LastHash: m.LastHash,
+	}
+}
+
+// archiveFiles returns the entry and manifest file names of a month
+func archiveFiles(month time.Time) (entries, manifest string) {
+	base := "audit-" + month.UTC().Format("2006-01")
+	return base + ".jsonl.gz", base + ".manifest.json"
+}
+
+// WriteArchive exports one partition through list, which returns the
+// partition's entries with IDs greater than afterID in ID order, to a signed
+// archive in dir. An existing archive of the month is kept if it holds
+// exactly the same entries, so an interrupted archiver can run again; if it
+// differs WriteArchive fails rather than overwrite it.
+func WriteArchive(dir string, partition models.AuditPartition, key ed25519.PrivateKey,
+	list func(afterID, limit int) ([]*models.AuditLog, error)) (*ArchiveManifest, error) {
+	entriesName, manifestName := archiveFiles(partition.Month)
+
+	tmp, err := os.CreateTemp(dir, "."+entriesName+".*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	// Gzip output is deterministic, so identical entries give identical files
+	fileHash := sha256.New()
+	counter := &countingWriter{w: io.MultiWriter(tmp, fileHash)}
+	zw := gzip.NewWriter(counter)
+	encoder := json.NewEncoder(zw)
+
+	manifest := &ArchiveManifest{Month: partition.Month.Format("2006-01"), File: entriesName}
+	it := &entryIterator{list: list}
+	for {
+		entry, err := it.peek()
+		if err != nil {
+			return nil, err
+		}
+		if entry == nil {
+			break
+		}
+		if manifest.Count == 0 {
+			manifest.FirstID = entry.ID
+			manifest.FirstPrevHash = entry.PrevHash
+		}
+		if err := encoder.Encode(entry); err != nil {
+			return nil, err
+		}
+		manifest.LastID = entry.ID
+		manifest.LastHash = entry.Hash
+		manifest.Count++
+		it.next()
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	if err := tmp.Sync(); err != nil {
+		return nil, err
+	}
+	manifest.SHA256 = hex.EncodeToString(fileHash.Sum(nil))
+	manifest.Size = counter.n
+
+	// Reuse an identical archive left by an earlier run
+	manifestPath := filepath.Join(dir, manifestName)
+	if existing, err := readManifest(manifestPath); err == nil {
+		if err := existing.Verify(key.Public().(ed25519.PublicKey)); err != nil {
+			return nil, err
+		}
+		if existing.SHA256 != manifest.SHA256 {
+			return nil, fmt.Errorf("archive %s already exists with different entries", manifestPath)
+		}
+		return existing, nil
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(dir, entriesName)); err != nil {
+		return nil, err
+	}
+
+	manifest.CreatedAt = time.Now().UTC()
+	manifest.KeyID = KeyID(key.Public().(ed25519.PublicKey))
+	manifest.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(key, manifest.signedPayload()))
+
+	// The manifest is written last; an entries file without one is incomplete
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := writeFileAtomic(manifestPath, data); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// countingWriter counts the bytes written through it
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// writeFileAtomic writes data to path through a synced temporary file
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	if _, err := tmp.Write(data); err != nil {
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func readManifest(path string) (*ArchiveManifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var manifest ArchiveManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &manifest, nil
+}
+
+// LoadArchiveManifests reads and verifies every archive manifest in dir,
+// ordered by entry ID
+func LoadArchiveManifests(dir string, key ed25519.PublicKey) ([]*ArchiveManifest, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "audit-*.manifest.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	manifests := []*ArchiveManifest{}
+	for _, path := range paths {
+		manifest, err := readManifest(path)
+		if err != nil {
+			return nil, err
+		}
+		if err := manifest.Verify(key); err != nil {
+			return nil, err
+		}
+		manifests = append(manifests, manifest)
+	}
+
+	sort.Slice(manifests, func(i, j int) bool { return manifests[i].FirstID < manifests[j].FirstID })
+	return manifests, nil
+}
+
+// OpenArchive verifies the archive of a month in dir and returns a reader
+// over its entries
+func OpenArchive(dir, month string, key ed25519.PublicKey) (*ArchiveReader, error) {
+	if _, err := time.Parse("2006-01", month); err != nil {
+		return nil, fmt.Errorf("invalid month %q, expected YYYY-MM", month)
+	}
+	manifest, err := readManifest(filepath.Join(dir, "audit-"+month+".manifest.json"))
+	if err != nil {
+		return nil, err
+	}
+	if err := manifest.Verify(key); err != nil {
+		return nil, err
+	}
+	return openArchive(dir, manifest)
+}
+
+// openArchive checks the entries file against a verified manifest and opens it
+func openArchive(dir string, manifest *ArchiveManifest) (*ArchiveReader, error) {
+	// The manifest names the file it signs; never follow it out of dir
+	if manifest.File != filepath.Base(manifest.File) || !strings.HasSuffix(manifest.File, ".jsonl.gz") {
+		return nil, fmt.Errorf("archive %s names an invalid file %q", manifest.Month, manifest.File)
+	}
+	file, err := os.Open(filepath.Join(dir, manifest.File))
+	if err != nil {
+		return nil, err
+	}
+
+	// Check the whole file before decoding any of it
+	h := sha256.New()
+	size, err := io.Copy(h, file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	if size != manifest.Size || hex.EncodeToString(h.Sum(nil)) != manifest.SHA256 {
+		file.Close()
+		return nil, fmt.Errorf("archive %s does not match its manifest; the file was modified", manifest.Month)
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	zr, err := gzip.NewReader(bufio.NewReader(file))
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &ArchiveReader{Manifest: manifest, file: file, decoder: json.NewDecoder(zr)}, nil
+}
+
+// ArchiveReader returns the entries of a verified archive in ID order.
+// Each entry is checked against the manifest and the hash chain as it is
+// read, so a reader that reaches the end without error has seen exactly the
+// signed entries.
+type ArchiveReader struct {
+	Manifest *ArchiveManifest
+
+	file     *os.File
+	decoder  *json.Decoder
+	count    int
+	lastID   int
+	lastHash string
+}
+
+// Next returns the next entry, or io.EOF after the last one
+func (r *ArchiveReader) Next() (*models.AuditLog, error) {
+	var entry models.AuditLog
+	if err := r.decoder.Decode(&entry); err != nil {
+		if errors.Is(err, io.EOF) {
+			if r.count != r.Manifest.Count || r.lastID != r.Manifest.LastID || r.lastHash != r.Manifest.LastHash {
+				return nil, fmt.Errorf("archive %s ended after %d of %d entries", r.Manifest.Month, r.count, r.Manifest.Count)
+			}
+			return nil, io.EOF
+		}
+		return nil, fmt.Errorf("archive %s: %w", r.Manifest.Month, err)
+	}
+
+	prevHash := r.lastHash
+	if r.count == 0 {
+		prevHash = r.Manifest.FirstPrevHash
+	}
+	switch {
+	case r.count == r.Manifest.Count:
+		return nil, fmt.Errorf("archive %s holds more entries than its manifest", r.Manifest.Month)
+	case r.count == 0 && entry.ID != r.Manifest.FirstID, r.count > 0 && entry.ID <= r.lastID:
+		return nil, fmt.Errorf("archive %s entry %d is out of order", r.Manifest.Month, entry.ID)
+	case entry.Hash != "" && (entry.PrevHash != prevHash || entry.ComputeHash() != entry.Hash):
+		return nil, fmt.Errorf("archive %s entry %d does not match the hash chain", r.Manifest.Month, entry.ID)
+	}
+
+	r.count++
+	r.lastID = entry.ID
+	r.lastHash = entry.Hash
+	return &entry, nil
+}
+
+// Close releases the archive file
+func (r *ArchiveReader) Close() error {
+	return r.file.Close()
+}
+
+// WithArchives returns a list function over the whole audit log: entries up
+// to the newest archive in dir are read from the verified archives, later
+// ones through list. Chain and checkpoint verification then cover archived
+// months as well. The returned function must be called with increasing
+// afterIDs, as VerifyChain and VerifyCheckpoints do.
+func WithArchives(dir string, key ed25519.PublicKey,
+	list func(afterID, limit int) ([]*models.AuditLog, error)) (func(afterID, limit int) ([]*models.AuditLog, error), error) {
+	manifests, err := LoadArchiveManifests(dir, key)
+	if err != nil {
+		return nil, err
+	}
+	horizon := 0
+	if n := len(manifests); n > 0 {
+		horizon = manifests[n-1].LastID
+	}
+
+	var reader *ArchiveReader
+	return func(afterID, limit int) ([]*models.AuditLog, error) {
+		if afterID >= horizon {
+			if reader != nil {
+				reader.Close()
+				reader = nil
+			}
+			return list(max(afterID, horizon), limit)
+		}
+
+		entries := []*models.AuditLog{}
+		for len(entries) < limit {
+			if reader == nil {
+				if len(manifests) == 0 {
+					break
+				}
+				if reader, err = openArchive(dir, manifests[0]); err != nil {
+					return nil, err
+				}
+				manifests = manifests[1:]
+			}
+			entry, err := reader.Next()
+			if errors.Is(err, io.EOF) {
+				reader.Close()
+				reader = nil
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			if entry.ID > afterID {
+				entries = append(entries, entry)
+			}
+		}
+
+		// Top up the last page from the database so a short page still
+		// means the end of the log
+		if len(entries) < limit {
+			rest, err := list(horizon, limit-len(entries))
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, rest...)
+		}
+		return entries, nil
+	}, nil
+}
@@ -0,0 +1,268 @@
+package audit
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/theshovonaha/mini-pam/internal/models"
+	"github.com/theshovonaha/mini-pam/internal/objectstore"
+)
+
+// memoryPartitions is a PartitionStore over an in-memory log
+type memoryPartitions struct {
+	entries    []*models.AuditLog
+	partitions map[string]models.AuditPartition
+}
+
+func (m *memoryPartitions) ListPartitions() ([]models.AuditPartition, error) {
+	partitions := []models.AuditPartition{}
+	for _, partition := range m.partitions {
+		partitions = append(partitions, partition)
+	}
+	sort.Slice(partitions, func(i, j int) bool { return partitions[i].Month.Before(partitions[j].Month) })
+	return partitions, nil
+}
+
+func (m *memoryPartitions) CreatePartition(partition models.AuditPartition) error {
+	m.partitions[partition.Name] = partition
+	return nil
+}
+
+func (m *memoryPartitions) DropPartition(partition models.AuditPartition) error {
+	kept := []*models.AuditLog{}
+	for _, entry := range m.entries {
+		if entry.Timestamp.Before(partition.Month) || !entry.Timestamp.Before(partition.End()) {
+			kept = append(kept, entry)
+		}
+	}
+	m.entries = kept
+	delete(m.partitions, partition.Name)
+	return nil
+}
+
+func (m *memoryPartitions) ListPartitionAfter(partition models.AuditPartition, afterID, limit int) ([]*models.AuditLog, error) {
+	page := []*models.AuditLog{}
+	for _, entry := range m.entries {
+		if entry.ID > afterID && !entry.Timestamp.Before(partition.Month) && entry.Timestamp.Before(partition.End()) && len(page) < limit {
+			page = append(page, entry)
+		}
+	}
+	return page, nil
+}
+
+// memoryRecords is an in-memory ArchiveRecords
+type memoryRecords map[time.Time]*models.AuditArchive
+
+func (m memoryRecords) GetByMonth(month time.Time) (*models.AuditArchive, error) {
+	if record, ok := m[month]; ok {
+		return record, nil
+	}
+	return nil, models.ErrRecordNotFound
+}
+
+func (m memoryRecords) Create(archive *models.AuditArchive) error {
+	archive.ID = len(m) + 1
+	m[archive.Month] = archive
+	return nil
+}
+
+func (m memoryRecords) ClearRestored(id int) error {
+	for _, record := range m {
+		if record.ID == id {
+			record.RestoredAt = nil
+		}
+	}
+	return nil
+}
+
+func (m memoryRecords) latest() *models.AuditArchive {
+	var latest *models.AuditArchive
+	for _, record := range m {
+		if latest == nil || record.LastID > latest.LastID {
+			latest = record
+		}
+	}
+	return latest
+}
+
+// buildMonths returns a chain of entries written every six hours from January 2026
+func buildMonths(n int) []*models.AuditLog {
+	entries := buildChain(0, n)
+	prev := ""
+	for i, entry := range entries {
+		entry.Timestamp = time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC).Add(time.Duration(i) * 6 * time.Hour)
+		entry.PrevHash = prev
+		entry.Hash = entry.ComputeHash()
+		prev = entry.Hash
+	}
+	return entries
+}
+
+func TestArchiver(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+
+	// 100 days of entries, checkpointed before anything is archived
+	entries := buildMonths(400)
+	store, _ := objectstore.NewDirStore(t.TempDir())
+	if _, err := NewCheckpointer(store, "", private, listFrom(entries)).Checkpoint(); err != nil {
+		t.Fatal(err)
+	}
+
+	partitions := &memoryPartitions{entries: entries, partitions: map[string]models.AuditPartition{}}
+	for month := 1; month <= 4; month++ {
+		partitions.CreatePartition(models.AuditPartitionFor(time.Date(2026, time.Month(month), 1, 0, 0, 0, 0, time.UTC)))
+	}
+	records := memoryRecords{}
+	archiver := NewArchiver(partitions, records, dir, private, 1)
+	now := time.Date(2026, 4, 15, 12, 0, 0, 0, time.UTC)
+	archiver.now = func() time.Time { return now }
+
+	// Keeping one full month in April archives January and February
+	manifests, err := archiver.Maintain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(manifests) != 2 || manifests[0].Month != "2026-01" || manifests[0].Count != 124 || manifests[1].FirstID != 125 {
+		t.Fatalf("manifests: %+v", manifests)
+	}
+	names := []string{}
+	for name := range partitions.partitions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if want := []string{"audit_logs_p202603", "audit_logs_p202604", "audit_logs_p202605", "audit_logs_p202606"}; !equal(names, want) {
+		t.Fatalf("partitions after archiving: got %v, want %v", names, want)
+	}
+	remaining := partitions.entries
+	if remaining[0].ID != 237 {
+		t.Fatalf("first remaining entry: got %d", remaining[0].ID)
+	}
+
+	// A second run has nothing left to archive
+	if manifests, err := archiver.Maintain(); err != nil || len(manifests) != 0 {
+		t.Fatalf("second run: got %v, %v", manifests, err)
+	}
+
+	// The remaining chain links to the newest archive
+	report, err := VerifyChainAfter(records.latest(), listFrom(remaining))
+	if err != nil || !report.Valid || report.Archived != 236 || report.Checked != 164 {
+		t.Fatalf("chain after archive: got %+v, %v", report, err)
+	}
+	if report, _ := VerifyChain(listFrom(remaining)); report.Valid {
+		t.Fatal("chain without the archive anchor should not verify")
+	}
+
+	// With the archives the whole log verifies, checkpoints included
+	full, err := WithArchives(dir, public, listFrom(remaining))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report, err := VerifyChain(full); err != nil || !report.Valid || report.Checked != 400 {
+		t.Fatalf("chain with archives: got %+v, %v", report, err)
+	}
+	checkpoints, _ := LoadCheckpoints(store, "")
+	full, _ = WithArchives(dir, public, listFrom(remaining))
+	if report, err := VerifyCheckpoints(checkpoints, public, full); err != nil || !report.Valid || report.Entries != 400 {
+		t.Fatalf("checkpoints with archives: got %+v, %v", report, err)
+	}
+
+	// Restoring reads back exactly the archived entries
+	reader, err := OpenArchive(dir, "2026-02", public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored := []*models.AuditLog{}
+	for {
+		entry, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		restored = append(restored, entry)
+	}
+	reader.Close()
+	if len(restored) != 112 || restored[0].ID != 125 || restored[111].Hash != entries[235].Hash {
+		t.Fatalf("restored %d entries", len(restored))
+	}
+
+	// A restored month is held for investigation, then dropped again
+	february := models.AuditPartitionFor(time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC))
+	partitions.CreatePartition(february)
+	partitions.entries = append(restored, remaining...)
+	restoredAt := now
+	records[february.Month].RestoredAt = &restoredAt
+	if _, err := archiver.Maintain(); err != nil || len(partitions.entries) != 276 {
+		t.Fatalf("restored month dropped early: %d entries, %v", len(partitions.entries), err)
+	}
+	// Once the hold ends February goes again, with March, which expired meanwhile
+	now = now.Add(restoreHold + time.Hour)
+	if _, err := archiver.Maintain(); err != nil || partitions.entries[0].ID != 361 || records[february.Month].RestoredAt != nil {
+		t.Fatalf("restored month kept too long: first entry %d, %v", partitions.entries[0].ID, err)
+	}
+}
+
+func TestArchiveTampering(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	entries := buildMonths(100)
+	january := models.AuditPartitionFor(entries[0].Timestamp)
+	partitions := &memoryPartitions{entries: entries}
+
+	list := func(afterID, limit int) ([]*models.AuditLog, error) {
+		return partitions.ListPartitionAfter(january, afterID, limit)
+	}
+	if _, err := WriteArchive(dir, january, private, list); err != nil {
+		t.Fatal(err)
+	}
+
+	// Writing the same month again keeps the identical archive...
+	if _, err := WriteArchive(dir, january, private, list); err != nil {
+		t.Fatalf("rewriting an identical archive: %v", err)
+	}
+	// ...but refuses to replace it with different entries
+	entries[3].Details = "changed"
+	if _, err := WriteArchive(dir, january, private, list); err == nil {
+		t.Fatal("expected an error replacing an archive with different entries")
+	}
+
+	other, _, _ := ed25519.GenerateKey(rand.Reader)
+	if _, err := OpenArchive(dir, "2026-01", other); err == nil {
+		t.Error("expected an error verifying with the wrong key")
+	}
+
+	path := filepath.Join(dir, "audit-2026-01.jsonl.gz")
+	data, _ := os.ReadFile(path)
+	data[len(data)/2] ^= 0xff
+	os.WriteFile(path, data, 0o600)
+	if _, err := OpenArchive(dir, "2026-01", public); err == nil {
+		t.Error("expected an error opening a modified archive")
+	}
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,174 @@
+package audit
+
+import (
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/theshovonaha/mini-pam/internal/models"
+)
+
+// Partition maintenance settings
+const (
+	// partitionsAhead is how many months past the current one get a
+	// partition in advance, so inserts never find their month missing
+	partitionsAhead = 2
+	// restoreHold is how long a restored month stays in the database
+	// before the archiver drops it again
+	restoreHold = 30 * 24 * time.Hour
+)
+
+// PartitionStore is the partition maintenance the archiver performs,
+// implemented by models.AuditLogRepository
+type PartitionStore interface {
+	ListPartitions() ([]models.AuditPartition, error)
+	CreatePartition(partition models.AuditPartition) error
+	DropPartition(partition models.AuditPartition) error
+	ListPartitionAfter(partition models.AuditPartition, afterID, limit int) ([]*models.AuditLog, error)
+}
+
+// ArchiveRecords tracks archived months, implemented by models.AuditArchiveRepository
+type ArchiveRecords interface {
+	GetByMonth(month time.Time) (*models.AuditArchive, error)
+	Create(archive *models.AuditArchive) error
+	ClearRestored(id int) error
+}
+
+// Archiver keeps the audit log partitioned by month: it creates upcoming
+// partitions and, with a retention policy, exports months older than the
+// retention period to signed archives before dropping them
+type Archiver struct {
+	partitions PartitionStore
+	records    ArchiveRecords
+	dir        string
+	key        ed25519.PrivateKey
+	retention  int
+	now        func() time.Time
+}
+
+// NewArchiver creates an archiver keeping retention full months besides the
+// current one. With retention 0 nothing is archived and the archiver only
+// creates partitions; otherwise dir and key must be set.
+func NewArchiver(partitions PartitionStore, records ArchiveRecords, dir string, key ed25519.PrivateKey, retention int) *Archiver {
+	return &Archiver{
+		partitions: partitions,
+		records:    records,
+		dir:        dir,
+		key:        key,
+		retention:  retention,
+		now:        time.Now,
+	}
+}
+
+// Cutoff returns the start of the oldest month kept in the database; older
+// months are archived. It is zero when nothing is archived.
+func (a *Archiver) Cutoff() time.Time {
+	if a.retention <= 0 {
+		return time.Time{}
+	}
+	return models.AuditPartitionFor(a.now()).Month.AddDate(0, -a.retention, 0)
+}
+
+// Maintain creates upcoming partitions, then archives and drops expired
+// ones, oldest first. It stops at the first month that fails so archives
+// stay contiguous, and returns the manifests written.
+func (a *Archiver) Maintain() ([]*ArchiveManifest, error) {
+	current := models.AuditPartitionFor(a.now())
+	for i := 0; i <= partitionsAhead; i++ {
+		if err := a.partitions.CreatePartition(models.AuditPartitionFor(current.Month.AddDate(0, i, 0))); err != nil {
+			return nil, fmt.Errorf("failed to create partition: %w", err)
+		}
+	}
+
+	cutoff := a.Cutoff()
+	if cutoff.IsZero() {
+		return nil, nil
+	}
+	partitions, err := a.partitions.ListPartitions()
+	if err != nil {
+		return nil, err
+	}
+
+	written := []*ArchiveManifest{}
+	for _, partition := range partitions {
+		if partition.End().After(cutoff) {
+			break
+		}
+		manifest, err := a.expire(partition)
+		if err != nil {
+			return written, fmt.Errorf("failed to archive %s: %w", partition.Name, err)
+		}
+		if manifest != nil {
+			written = append(written, manifest)
+		}
+	}
+	return written, nil
+}
+
+// expire archives a partition unless it already was, then drops it.
+// Partitions restored for an investigation are kept for restoreHold.
+func (a *Archiver) expire(partition models.AuditPartition) (*ArchiveManifest, error) {
+	record, err := a.records.GetByMonth(partition.Month)
+	if err != nil && !errors.Is(err, models.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	if record != nil {
+		// Already archived: either a drop that failed earlier, or a restore
+		if record.RestoredAt != nil {
+			if a.now().Sub(*record.RestoredAt) < restoreHold {
+				return nil, nil
+			}
+			if err := a.partitions.DropPartition(partition); err != nil {
+				return nil, err
+			}
+			return nil, a.records.ClearRestored(record.ID)
+		}
+		return nil, a.partitions.DropPartition(partition)
+	}
+
+	list := func(afterID, limit int) ([]*models.AuditLog, error) {
+		return a.partitions.ListPartitionAfter(partition, afterID, limit)
+	}
+	if entries, err := list(0, 1); err != nil {
+		return nil, err
+	} else if len(entries) == 0 {
+		// Nothing to keep from an empty month
+		return nil, a.partitions.DropPartition(partition)
+	}
+
+	manifest, err := WriteArchive(a.dir, partition, a.key, list)
+	if err != nil {
+		return nil, err
+	}
+	if err := a.records.Create(manifest.Record()); err != nil {
+		return nil, err
+	}
+	return manifest, a.partitions.DropPartition(partition)
+}
+
+// Run maintains partitions immediately and then every interval until ctx
+// is cancelled
+func (a *Archiver) Run(ctx context.Context, interval time.Duration, logger *log.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		manifests, err := a.Maintain()
+		for _, manifest := range manifests {
+			logger.Printf("Audit log %s archived to %s (%d entries)", manifest.Month, manifest.File, manifest.Count)
+		}
+		if err != nil {
+			logger.Printf("Error maintaining audit log partitions: %v", err)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
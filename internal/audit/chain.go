@@ -0,0 +1,120 @@
+package audit
+
+import (
+	"github.com/theshovonaha/mini-pam/internal/models"
+)
+
+// chainBatchSize is the number of entries fetched per query while walking the chain
+const chainBatchSize = 1000
+
+// ChainReport is the outcome of walking the audit log hash chain
+type ChainReport struct {
+	Valid       bool        `json:"valid"`
+	Checked     int         `json:"checked"`
+	Unchained   int         `json:"unchained"`                  // entries written before the chain was introduced
+	Archived    int         `json:"archived_through,omitempty"` // last archived ID; the walk starts after it
+	LastID      int         `json:"last_id,omitempty"`
+	LastHash    string      `json:"last_hash,omitempty"`
+	FirstBroken *BrokenLink `json:"first_broken,omitempty"`
+}
+
+// BrokenLink identifies the first entry that fails verification
+type BrokenLink struct {
+	ID     int    `json:"id"`
+	Reason string `json:"reason"`
+}
+
+// ChainVerifier checks audit entries one at a time, in ID order
+type ChainVerifier struct {
+	report  ChainReport
+	started bool
+}
+
+// NewChainVerifier creates a verifier positioned at the start of the log
+func NewChainVerifier() *ChainVerifier {
+	return &ChainVerifier{report: ChainReport{Valid: true}}
+}
+
+// Add checks the next entry and reports whether the chain is still intact.
+// Once a broken link is found further entries are ignored.
+func (v *ChainVerifier) Add(entry *models.AuditLog) bool {
+	if !v.report.Valid {
+		return false
+	}
+
+	// Entries from before the chain carry no hash
+	if !v.started && entry.Hash == "" && entry.PrevHash == "" {
+		v.report.Unchained++
+		return true
+	}
+	v.started = true
+
+	switch {
+	case entry.Hash == "":
+		return v.fail(entry.ID, "entry has no hash")
+	case entry.PrevHash != v.report.LastHash:
+		return v.fail(entry.ID, "previous hash does not match the preceding entry; an entry was removed, inserted or reordered")
+	case entry.ComputeHash() != entry.Hash:
+		return v.fail(entry.ID, "hash does not match the entry content; the entry was modified")
+	}
+
+	v.report.Checked++
+	v.report.LastID = entry.ID
+	v.report.LastHash = entry.Hash
+	return true
+}
+
+// Resume positions the verifier after an archived month, whose last hash
+// the first remaining entry must link to
+func (v *ChainVerifier) Resume(archive *models.AuditArchive) {
+	v.report.Archived = archive.LastID
+	v.report.LastID = archive.LastID
+	v.report.LastHash = archive.LastHash
+	v.started = archive.LastHash != ""
+}
+
+// fail records the first broken link
+func (v *ChainVerifier) fail(id int, reason string) bool {
+	v.report.Valid = false
+	v.report.FirstBroken = &BrokenLink{ID: id, Reason: reason}
+	return false
+}
+
+// Report returns the verification result so far
+func (v *ChainVerifier) Report() *ChainReport {
+	report := v.report
+	return &report
+}
+
+// VerifyChain walks the whole audit log through list, which returns entries
+// with IDs greater than afterID in ID order, and stops at the first broken link
+func VerifyChain(list func(afterID, limit int) ([]*models.AuditLog, error)) (*ChainReport, error) {
+	return VerifyChainAfter(nil, list)
+}
+
+// VerifyChainAfter walks the audit log like VerifyChain, starting after the
+// newest archived month when archive is not nil
+func VerifyChainAfter(archive *models.AuditArchive, list func(afterID, limit int) ([]*models.AuditLog, error)) (*ChainReport, error) {
+	verifier := NewChainVerifier()
+
+	afterID := 0
+	if archive != nil {
+		verifier.Resume(archive)
+		afterID = archive.LastID
+	}
+	for {
+		entries, err := list(afterID, chainBatchSize)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			if !verifier.Add(entry) {
+				return verifier.Report(), nil
+			}
+			afterID = entry.ID
+		}
+		if len(entries) < chainBatchSize {
+			return verifier.Report(), nil
+		}
+	}
+}
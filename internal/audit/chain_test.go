@@ -0,0 +1,109 @@
+package audit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/theshovonaha/mini-pam/internal/models"
+)
+
+// buildChain returns unchained legacy entries followed by chained ones, with IDs from 1
+func buildChain(legacy, chained int) []*models.AuditLog {
+	entries := []*models.AuditLog{}
+	prev := ""
+	for i := 1; i <= legacy+chained; i++ {
+		entry := &models.AuditLog{
+			ID:         i,
+			UserID:     1,
+			Action:     "update",
+			Resource:   "user",
+			ResourceID: i,
+			Timestamp:  time.Date(2026, 1, 1, 0, 0, i, 1000, time.UTC),
+			Details:    "User updated",
+			Changes:    models.AuditChanges{"email": {Old: "a@example.com", New: "b@example.com"}},
+		}
+		if i > legacy {
+			entry.PrevHash = prev
+			entry.Hash = entry.ComputeHash()
+			prev = entry.Hash
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// listFrom serves entries the way AuditLogRepository.ListAfter does
+func listFrom(entries []*models.AuditLog) func(afterID, limit int) ([]*models.AuditLog, error) {
+	return func(afterID, limit int) ([]*models.AuditLog, error) {
+		page := []*models.AuditLog{}
+		for _, entry := range entries {
+			if entry.ID > afterID && len(page) < limit {
+				page = append(page, entry)
+			}
+		}
+		return page, nil
+	}
+}
+
+func TestVerifyChain(t *testing.T) {
+	entries := buildChain(2, 2500)
+	report, err := VerifyChain(listFrom(entries))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.Valid || report.Checked != 2500 || report.Unchained != 2 || report.LastID != 2502 {
+		t.Fatalf("intact chain: got %+v", report)
+	}
+
+	tests := []struct {
+		name   string
+		tamper func([]*models.AuditLog) []*models.AuditLog
+		wantID int
+	}{
+		{"edited details", func(e []*models.AuditLog) []*models.AuditLog {
+			e[1500].Details = "nothing to see here"
+			return e
+		}, 1501},
+		{"edited timestamp", func(e []*models.AuditLog) []*models.AuditLog {
+			e[10].Timestamp = e[10].Timestamp.Add(time.Hour)
+			return e
+		}, 11},
+		{"edited changes", func(e []*models.AuditLog) []*models.AuditLog {
+			e[20].Changes = models.AuditChanges{"email": {Old: "a@example.com", New: "c@example.com"}}
+			return e
+		}, 21},
+		{"deleted entry", func(e []*models.AuditLog) []*models.AuditLog {
+			return append(e[:99:99], e[100:]...)
+		}, 101},
+		{"stripped hash", func(e []*models.AuditLog) []*models.AuditLog {
+			e[50].Hash, e[50].PrevHash = "", ""
+			return e
+		}, 51},
+		{"rehashed entry", func(e []*models.AuditLog) []*models.AuditLog {
+			// Recomputing the edited entry's own hash still breaks the next link
+			e[30].Details = "rewritten"
+			e[30].Hash = e[30].ComputeHash()
+			return e
+		}, 32},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			report, err := VerifyChain(listFrom(tt.tamper(buildChain(2, 2500))))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if report.Valid || report.FirstBroken == nil || report.FirstBroken.ID != tt.wantID {
+				t.Errorf("got %+v, first broken %+v; want broken at %d", report, report.FirstBroken, tt.wantID)
+			}
+		})
+	}
+}
+
+func TestComputeHashStableAcrossTimeZones(t *testing.T) {
+	entry := buildChain(0, 1)[0]
+	copied := *entry
+	copied.Timestamp = entry.Timestamp.In(time.FixedZone("UTC+5", 5*3600))
+	if copied.ComputeHash() != entry.Hash {
+		t.Error("hash changed with the timestamp's location")
+	}
+}
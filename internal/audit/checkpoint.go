@@ -0,0 +1,420 @@
+package audit
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"hash"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/theshovonaha/mini-pam/internal/models"
+	"github.com/theshovonaha/mini-pam/internal/objectstore"
+)
+
+// Checkpoint is a signed statement over the audit entries written since the
+// previous checkpoint. Digests chain from one checkpoint to the next, so a
+// later checkpoint also vouches for every earlier entry.
+type Checkpoint struct {
+	Sequence   int       `json:"sequence"`
+	FirstID    int       `json:"first_id"`
+	LastID     int       `json:"last_id"`
+	Count      int       `json:"count"`       // entries covered by this checkpoint
+	TotalCount int       `json:"total_count"` // entries covered by this and every earlier checkpoint
+	PrevDigest string    `json:"prev_digest"`
+	Digest     string    `json:"digest"`
+	CreatedAt  time.Time `json:"created_at"`
+	KeyID      string    `json:"key_id"`
+	Signature  string    `json:"signature"`
+}
+
+// signedPayload is the exact byte string the checkpoint signature covers
+func (c *Checkpoint) signedPayload() []byte {
+	return []byte(fmt.Sprintf("mini-pam audit checkpoint v1\n%d\n%d\n%d\n%d\n%d\n%s\n%s\n%s\n%s\n",
+		c.Sequence, c.FirstID, c.LastID, c.Count, c.TotalCount,
+		c.PrevDigest, c.Digest, c.CreatedAt.UTC().Format(time.RFC3339Nano), c.KeyID))
+}
+
+// Verify checks the checkpoint's signature against key
+func (c *Checkpoint) Verify(key ed25519.PublicKey) error {
+	if c.KeyID != KeyID(key) {
+		return fmt.Errorf("checkpoint %d was signed by key %s, not %s", c.Sequence, c.KeyID, KeyID(key))
+	}
+	signature, err := base64.StdEncoding.DecodeString(c.Signature)
+	if err != nil || !ed25519.Verify(key, c.signedPayload(), signature) {
+		return fmt.Errorf("checkpoint %d has an invalid signature", c.Sequence)
+	}
+	return nil
+}
+
+// KeyID returns a short fingerprint naming a checkpoint signing key
+func KeyID(key ed25519.PublicKey) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:8])
+}
+
+// checkpointKey returns the object key of a checkpoint; the zero padding
+// keeps lexical and sequence order the same
+func checkpointKey(prefix string, sequence int) string {
+	return fmt.Sprintf("%s%010d.json", prefix, sequence)
+}
+
+// LoadCheckpoints reads every checkpoint stored under prefix, ordered by sequence
+func LoadCheckpoints(store objectstore.Store, prefix string) ([]*Checkpoint, error) {
+	keys, err := store.List(prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	checkpoints := []*Checkpoint{}
+	for _, key := range keys {
+		if !strings.HasSuffix(key, ".json") {
+			continue
+		}
+		data, err := store.Get(key)
+		if err != nil {
+			return nil, err
+		}
+		var checkpoint Checkpoint
+		if err := json.Unmarshal(data, &checkpoint); err != nil {
+			return nil, fmt.Errorf("checkpoint %s: %w", key, err)
+		}
+		checkpoints = append(checkpoints, &checkpoint)
+	}
+
+	sort.Slice(checkpoints, func(i, j int) bool { return checkpoints[i].Sequence < checkpoints[j].Sequence })
+	return checkpoints, nil
+}
+
+// entryIterator pages through the audit log in ID order
+type entryIterator struct {
+	list    func(afterID, limit int) ([]*models.AuditLog, error)
+	afterID int
+	page    []*models.AuditLog
+	done    bool
+}
+
+// peek returns the next entry without consuming it, or nil at the end of the log
+func (it *entryIterator) peek() (*models.AuditLog, error) {
+	if len(it.page) == 0 && !it.done {
+		page, err := it.list(it.afterID, chainBatchSize)
+		if err != nil {
+			return nil, err
+		}
+		it.page = page
+		it.done = len(page) < chainBatchSize
+	}
+	if len(it.page) == 0 {
+		return nil, nil
+	}
+	return it.page[0], nil
+}
+
+// next consumes the entry returned by peek
+func (it *entryIterator) next() {
+	it.afterID = it.page[0].ID
+	it.page = it.page[1:]
+}
+
+// digest accumulates entry content hashes on top of the previous checkpoint's
+// digest. Content hashes are recomputed rather than read from the hash
+// column, so rewriting rows and their stored hashes is still detected.
+type digest struct {
+	hash    hash.Hash
+	firstID int
+	lastID  int
+	count   int
+}
+
+func newDigest(prev string) *digest {
+	d := &digest{hash: sha256.New()}
+	d.hash.Write([]byte(prev))
+	return d
+}
+
+func (d *digest) add(entry *models.AuditLog) {
+	if d.count == 0 {
+		d.firstID = entry.ID
+	}
+	d.hash.Write([]byte(entry.ComputeHash()))
+	d.lastID = entry.ID
+	d.count++
+}
+
+func (d *digest) sum() string {
+	return hex.EncodeToString(d.hash.Sum(nil))
+}
+
+// Checkpointer periodically signs the audit entries written since its
+// previous checkpoint and stores the result outside the database
+type Checkpointer struct {
+	store  objectstore.Store
+	prefix string
+	key    ed25519.PrivateKey
+	list   func(afterID, limit int) ([]*models.AuditLog, error)
+
+	mu   sync.Mutex
+	last *Checkpoint
+	init bool
+}
+
+// NewCheckpointer creates a checkpointer that reads entries through list
+// (see AuditLogRepository.ListAfter) and writes checkpoints under prefix
+func NewCheckpointer(store objectstore.Store, prefix string, key ed25519.PrivateKey,
+	list func(afterID, limit int) ([]*models.AuditLog, error)) *Checkpointer {
+	return &Checkpointer{store: store, prefix: prefix, key: key, list: list}
+}
+
+// Checkpoint signs and stores a checkpoint over the entries written since the
+// previous one. It returns nil when there are no new entries.
+func (c *Checkpointer) Checkpoint() (*Checkpoint, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Resume from the newest stored checkpoint, refusing to extend a forged one
+	if !c.init {
+		checkpoints, err := LoadCheckpoints(c.store, c.prefix)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load checkpoints: %w", err)
+		}
+		if n := len(checkpoints); n > 0 {
+			last := checkpoints[n-1]
+			if err := last.Verify(c.key.Public().(ed25519.PublicKey)); err != nil {
+				return nil, err
+			}
+			c.last = last
+		}
+		c.init = true
+	}
+
+	prev := &Checkpoint{}
+	if c.last != nil {
+		prev = c.last
+	}
+
+	d := newDigest(prev.Digest)
+	it := &entryIterator{list: c.list, afterID: prev.LastID}
+	for {
+		entry, err := it.peek()
+		if err != nil {
+			return nil, err
+		}
+		if entry == nil {
+			break
+		}
+		d.add(entry)
+		it.next()
+	}
+	if d.count == 0 {
+		return nil, nil
+	}
+
+	checkpoint := &Checkpoint{
+		Sequence:   prev.Sequence + 1,
+		FirstID:    d.firstID,
+		LastID:     d.lastID,
+		Count:      d.count,
+		TotalCount: prev.TotalCount + d.count,
+		PrevDigest: prev.Digest,
+		Digest:     d.sum(),
+		CreatedAt:  time.Now().UTC(),
+		KeyID:      KeyID(c.key.Public().(ed25519.PublicKey)),
+	}
+	checkpoint.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(c.key, checkpoint.signedPayload()))
+
+	data, err := json.MarshalIndent(checkpoint, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := c.store.Put(checkpointKey(c.prefix, checkpoint.Sequence), data); err != nil {
+		if errors.Is(err, objectstore.ErrExists) {
+			// Another writer took this sequence number; reload before the next attempt
+			c.init = false
+		}
+		return nil, err
+	}
+
+	c.last = checkpoint
+	return checkpoint, nil
+}
+
+// Run writes a checkpoint every interval until ctx is cancelled, and a
+// final one on the way out
+func (c *Checkpointer) Run(ctx context.Context, interval time.Duration, logger *log.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			c.logCheckpoint(logger)
+			return
+		}
+		c.logCheckpoint(logger)
+	}
+}
+
+func (c *Checkpointer) logCheckpoint(logger *log.Logger) {
+	checkpoint, err := c.Checkpoint()
+	if err != nil {
+		logger.Printf("Error writing audit checkpoint: %v", err)
+		return
+	}
+	if checkpoint != nil {
+		logger.Printf("Audit checkpoint %d written covering entries %d-%d", checkpoint.Sequence, checkpoint.FirstID, checkpoint.LastID)
+	}
+}
+
+// CheckpointReport is the outcome of checking stored checkpoints against the database
+type CheckpointReport struct {
+	Valid        bool               `json:"valid"`
+	Checkpoints  int                `json:"checkpoints"`
+	Entries      int                `json:"entries"`
+	Uncovered    int                `json:"uncovered"` // entries written after the last checkpoint
+	FirstFailure *CheckpointFailure `json:"first_failure,omitempty"`
+}
+
+// CheckpointFailure identifies the first checkpoint that fails verification
+type CheckpointFailure struct {
+	Sequence int    `json:"sequence"`
+	Reason   string `json:"reason"`
+}
+
+// VerifyCheckpoints checks each checkpoint's signature and links, then
+// recomputes its digest from the entries returned by list. Any entry that
+// was edited, removed or inserted inside a checkpointed range is reported.
+func VerifyCheckpoints(checkpoints []*Checkpoint, key ed25519.PublicKey,
+	list func(afterID, limit int) ([]*models.AuditLog, error)) (*CheckpointReport, error) {
+	report := &CheckpointReport{Valid: true}
+	fail := func(sequence int, format string, args ...interface{}) (*CheckpointReport, error) {
+		report.Valid = false
+		report.FirstFailure = &CheckpointFailure{Sequence: sequence, Reason: fmt.Sprintf(format, args...)}
+		return report, nil
+	}
+
+	prev := &Checkpoint{}
+	it := &entryIterator{list: list}
+	for i, checkpoint := range checkpoints {
+		if err := checkpoint.Verify(key); err != nil {
+			return fail(checkpoint.Sequence, "%v", err)
+		}
+		if checkpoint.Sequence != i+1 {
+			return fail(checkpoint.Sequence, "expected checkpoint %d; a checkpoint is missing", i+1)
+		}
+		if checkpoint.PrevDigest != prev.Digest {
+			return fail(checkpoint.Sequence, "previous digest does not match checkpoint %d", prev.Sequence)
+		}
+		if checkpoint.TotalCount != prev.TotalCount+checkpoint.Count {
+			return fail(checkpoint.Sequence, "running count %d does not follow %d + %d", checkpoint.TotalCount, prev.TotalCount, checkpoint.Count)
+		}
+
+		d := newDigest(prev.Digest)
+		for {
+			entry, err := it.peek()
+			if err != nil {
+				return nil, err
+			}
+			if entry == nil || entry.ID > checkpoint.LastID {
+				break
+			}
+			d.add(entry)
+			it.next()
+		}
+
+		switch {
+		case d.count != checkpoint.Count:
+			return fail(checkpoint.Sequence, "database has %d entries in %d-%d, checkpoint signed %d; entries were removed or inserted",
+				d.count, checkpoint.FirstID, checkpoint.LastID, checkpoint.Count)
+		case d.firstID != checkpoint.FirstID || d.lastID != checkpoint.LastID:
+			return fail(checkpoint.Sequence, "database entries span %d-%d, checkpoint signed %d-%d", d.firstID, d.lastID, checkpoint.FirstID, checkpoint.LastID)
+		case d.sum() != checkpoint.Digest:
+			return fail(checkpoint.Sequence, "digest mismatch; entries in %d-%d were modified", checkpoint.FirstID, checkpoint.LastID)
+		}
+
+		report.Checkpoints++
+		report.Entries += d.count
+		prev = checkpoint
+	}
+
+	// Count what the next checkpoint will cover
+	for {
+		entry, err := it.peek()
+		if err != nil {
+			return nil, err
+		}
+		if entry == nil {
+			break
+		}
+		report.Uncovered++
+		it.next()
+	}
+	return report, nil
+}
+
+// LoadSigningKey reads an Ed25519 private key from a PKCS#8 PEM file, as
+// written by "openssl genpkey -algorithm ed25519"
+func LoadSigningKey(path string) (ed25519.PrivateKey, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	private, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s: not an Ed25519 private key", path)
+	}
+	return private, nil
+}
+
+// LoadVerifyKey reads an Ed25519 public key from a PKIX PEM file, as written
+// by "openssl pkey -pubout". A private key file is accepted as well.
+func LoadVerifyKey(path string) (ed25519.PublicKey, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+	if block.Type == "PRIVATE KEY" {
+		private, err := LoadSigningKey(path)
+		if err != nil {
+			return nil, err
+		}
+		return private.Public().(ed25519.PublicKey), nil
+	}
+
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	public, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("%s: not an Ed25519 public key", path)
+	}
+	return public, nil
+}
+
+func readPEM(path string) (*pem.Block, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM data found", path)
+	}
+	return block, nil
+}
@@ -0,0 +1,154 @@
+package audit
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/theshovonaha/mini-pam/internal/models"
+	"github.com/theshovonaha/mini-pam/internal/objectstore"
+)
+
+func TestCheckpoints(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	store, err := objectstore.NewDirStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Three checkpoints over a growing log, the last written after a restart
+	entries := buildChain(1, 2499)
+	log := entries[:1200]
+	checkpointer := NewCheckpointer(store, "checkpoints/", private, func(afterID, limit int) ([]*models.AuditLog, error) {
+		return listFrom(log)(afterID, limit)
+	})
+	for _, n := range []int{1200, 2000} {
+		log = entries[:n]
+		if _, err := checkpointer.Checkpoint(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if checkpoint, err := checkpointer.Checkpoint(); err != nil || checkpoint != nil {
+		t.Fatalf("checkpoint without new entries: got %+v, %v", checkpoint, err)
+	}
+
+	log = entries[:2400]
+	restarted := NewCheckpointer(store, "checkpoints/", private, listFrom(log))
+	checkpoint, err := restarted.Checkpoint()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if checkpoint.Sequence != 3 || checkpoint.FirstID != 2001 || checkpoint.TotalCount != 2400 {
+		t.Fatalf("resumed checkpoint: got %+v", checkpoint)
+	}
+
+	checkpoints, err := LoadCheckpoints(store, "checkpoints/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err := VerifyCheckpoints(checkpoints, public, listFrom(entries))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.Valid || report.Checkpoints != 3 || report.Entries != 2400 || report.Uncovered != 100 {
+		t.Fatalf("intact log: got %+v", report)
+	}
+
+	tests := []struct {
+		name         string
+		tamper       func([]*models.AuditLog, []*Checkpoint) ([]*models.AuditLog, []*Checkpoint)
+		wantSequence int
+	}{
+		{"edited entry", func(e []*models.AuditLog, c []*Checkpoint) ([]*models.AuditLog, []*Checkpoint) {
+			e[1500].Details = "nothing to see here"
+			return e, c
+		}, 2},
+		{"rewritten hash chain", func(e []*models.AuditLog, c []*Checkpoint) ([]*models.AuditLog, []*Checkpoint) {
+			// The hash chain alone cannot catch a rewrite that recomputes every hash
+			e[100].Details = "rewritten"
+			prev := e[100].PrevHash
+			for _, entry := range e[100:] {
+				entry.PrevHash = prev
+				entry.Hash = entry.ComputeHash()
+				prev = entry.Hash
+			}
+			if report, _ := VerifyChain(listFrom(e)); !report.Valid {
+				t.Fatal("expected the rewritten chain to verify")
+			}
+			return e, c
+		}, 1},
+		{"deleted entry", func(e []*models.AuditLog, c []*Checkpoint) ([]*models.AuditLog, []*Checkpoint) {
+			return append(e[:2200:2200], e[2201:]...), c
+		}, 3},
+		{"forged checkpoint", func(e []*models.AuditLog, c []*Checkpoint) ([]*models.AuditLog, []*Checkpoint) {
+			c[1].Count--
+			return e, c
+		}, 2},
+		{"missing checkpoint", func(e []*models.AuditLog, c []*Checkpoint) ([]*models.AuditLog, []*Checkpoint) {
+			return e, append(c[:1:1], c[2:]...)
+		}, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checkpoints, err := LoadCheckpoints(store, "checkpoints/")
+			if err != nil {
+				t.Fatal(err)
+			}
+			entries, checkpoints := tt.tamper(buildChain(1, 2499), checkpoints)
+
+			report, err := VerifyCheckpoints(checkpoints, public, listFrom(entries))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if report.Valid || report.FirstFailure.Sequence != tt.wantSequence {
+				t.Errorf("got %+v, first failure %+v; want failure at checkpoint %d", report, report.FirstFailure, tt.wantSequence)
+			}
+		})
+	}
+
+	// A checkpoint signed by another key is rejected, and not extended
+	otherPublic, _, _ := ed25519.GenerateKey(rand.Reader)
+	if report, _ := VerifyCheckpoints(checkpoints, otherPublic, listFrom(entries)); report.Valid {
+		t.Error("checkpoints verified against the wrong key")
+	}
+	var forged Checkpoint
+	data, _ := os.ReadFile(filepath.Join(dir, "checkpoints", "0000000003.json"))
+	json.Unmarshal(data, &forged)
+	forged.Sequence, forged.Digest = 4, "forged"
+	data, _ = json.Marshal(forged)
+	store.Put("checkpoints/0000000004.json", data)
+	if _, err := NewCheckpointer(store, "checkpoints/", private, listFrom(entries)).Checkpoint(); err == nil {
+		t.Error("checkpointer extended a forged checkpoint")
+	}
+}
+
+func TestLoadKeys(t *testing.T) {
+	public, private, _ := ed25519.GenerateKey(rand.Reader)
+	dir := t.TempDir()
+
+	der, _ := x509.MarshalPKCS8PrivateKey(private)
+	privatePath := filepath.Join(dir, "checkpoint.key")
+	os.WriteFile(privatePath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600)
+	der, _ = x509.MarshalPKIXPublicKey(public)
+	publicPath := filepath.Join(dir, "checkpoint.pub")
+	os.WriteFile(publicPath, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0o644)
+
+	loaded, err := LoadSigningKey(privatePath)
+	if err != nil || !loaded.Equal(private) {
+		t.Errorf("LoadSigningKey: %v", err)
+	}
+	for _, path := range []string{publicPath, privatePath} {
+		if key, err := LoadVerifyKey(path); err != nil || !key.Equal(public) {
+			t.Errorf("LoadVerifyKey(%s): %v", filepath.Base(path), err)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+// Package audit builds and processes audit log entries.
+package audit
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+
+	"github.com/theshovonaha/mini-pam/internal/models"
+)
+
+// redacted replaces the values of secret-looking fields in a diff
+const redacted = "[redacted]"
+
+// ignoredFields change on every write and carry no information
+var ignoredFields = map[string]bool{
+	"updated_at": true,
+}
+
+// secretFieldMarkers mark field names whose values are never recorded
+var secretFieldMarkers = []string{"password", "secret", "token", "hash"}
+
+// Diff compares two snapshots of a record through their JSON form and
+// returns the fields that differ. Either snapshot may be nil, for creations
+// and deletions. Fields hidden from JSON never appear, and changes to
+// fields whose names suggest secrets are recorded without their values.
+func Diff(before, after interface{}) (models.AuditChanges, error) {
+	oldFields, err := snapshot(before)
+	if err != nil {
+		return nil, err
+	}
+	newFields, err := snapshot(after)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := models.AuditChanges{}
+	for field := range union(oldFields, newFields) {
+		if ignoredFields[field] {
+			continue
+		}
+
+		oldValue, newValue := oldFields[field], newFields[field]
+		if reflect.DeepEqual(oldValue, newValue) {
+			continue
+		}
+
+		if isSecretField(field) {
+			oldValue, newValue = redact(oldValue), redact(newValue)
+		}
+		changes[field] = models.FieldChange{Old: oldValue, New: newValue}
+	}
+
+	if len(changes) == 0 {
+		return nil, nil
+	}
+	return changes, nil
+}
+
+// snapshot converts a record to a field map using its JSON encoding
+func snapshot(record interface{}) (map[string]interface{}, error) {
+	fields := map[string]interface{}{}
+	if record == nil {
+		return fields, nil
+	}
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		return nil, err
+	}
+	if string(data) == "null" {
+		return fields, nil
+	}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	return fields, nil
+}
+
+// union returns the set of keys present in either map
+func union(a, b map[string]interface{}) map[string]bool {
+	keys := map[string]bool{}
+	for k := range a {
+		keys[k] = true
+	}
+	for k := range b {
+		keys[k] = true
+	}
+	return keys
+}
+
+// isSecretField reports whether a field name suggests a secret value
+func isSecretField(field string) bool {
+	field = strings.ToLower(field)
+	for _, marker := range secretFieldMarkers {
+		if strings.Contains(field, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+// redact hides a value while keeping whether it was set
+func redact(value interface{}) interface{} {
+	if value == nil {
+		return nil
+	}
+	return redacted
+}
@@ -0,0 +1,70 @@
+package audit
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/theshovonaha/mini-pam/internal/models"
+)
+
+func TestDiff(t *testing.T) {
+	before := &models.User{
+		ID:             7,
+		Username:       "alice",
+		Email:          "alice@example.com",
+		HashedPassword: "$2a$12$old",
+		Active:         true,
+		UpdatedAt:      time.Unix(100, 0),
+	}
+	after := *before
+	after.Email = "alice@corp.example.com"
+	after.Active = false
+	after.HashedPassword = "$2a$12$new"
+	after.UpdatedAt = time.Unix(200, 0)
+
+	changes, err := Diff(before, &after)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := models.AuditChanges{
+		"email":  {Old: "alice@example.com", New: "alice@corp.example.com"},
+		"active": {Old: true, New: false},
+	}
+	if !reflect.DeepEqual(changes, want) {
+		t.Errorf("Diff: got %v want %v", changes, want)
+	}
+
+	// Unchanged records produce no diff
+	if changes, err := Diff(before, before); err != nil || changes != nil {
+		t.Errorf("Diff of identical records: got %v, %v", changes, err)
+	}
+}
+
+func TestDiffCreateDeleteAndSecrets(t *testing.T) {
+	type record struct {
+		Name     string `json:"name"`
+		APIToken string `json:"api_token"`
+	}
+
+	var missing *record
+	created, err := Diff(missing, &record{Name: "ci", APIToken: "s3cr3t"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := models.AuditChanges{
+		"name":      {Old: nil, New: "ci"},
+		"api_token": {Old: nil, New: redacted},
+	}
+	if !reflect.DeepEqual(created, want) {
+		t.Errorf("Diff on create: got %v want %v", created, want)
+	}
+
+	deleted, err := Diff(&record{Name: "ci"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deleted) != 2 || deleted["name"].New != nil || deleted["api_token"].Old != redacted {
+		t.Errorf("Diff on delete: got %v", deleted)
+	}
+}
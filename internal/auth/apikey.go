@@ -0,0 +1,165 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/theshovonaha/mini-pam/internal/models"
+)
+
+// apiKeyPrefix marks bearer tokens that are API keys rather than session tokens
+const apiKeyPrefix = "pam_"
+
+// API key operations that scopes can grant
+const (
+	OpCredentialsRead   = "credentials.read"
+	OpCredentialsReveal = "credentials.reveal"
+)
+
+// GenerateAPIKey returns a new key of the form pam_<prefix>_<secret>, the
+// public prefix used to look it up, and the hash to persist for the secret
+func GenerateAPIKey() (key string, prefix string, secretHash string, err error) {
+	raw := make([]byte, 8)
+	if _, err := rand.Read(raw); err != nil {
+		return "", "", "", fmt.Errorf("failed to generate API key: %w", err)
+	}
+	prefix = hex.EncodeToString(raw)
+
+	secret, secretHash, err := GenerateToken()
+	if err != nil {
+		return "", "", "", err
+	}
+
+	return apiKeyPrefix + prefix + "_" + secret, prefix, secretHash, nil
+}
+
+// ParseAPIKey splits a presented API key into its prefix and secret
+func ParseAPIKey(key string) (prefix string, secret string, ok bool) {
+	rest, found := strings.CutPrefix(key, apiKeyPrefix)
+	if !found {
+		return "", "", false
+	}
+	prefix, secret, found = strings.Cut(rest, "_")
+	if !found || prefix == "" || secret == "" {
+		return "", "", false
+	}
+	return prefix, secret, true
+}
+
+// IsAPIKey reports whether a bearer token looks like an API key
+func IsAPIKey(token string) bool {
+	return strings.HasPrefix(token, apiKeyPrefix)
+}
+
+// Scope grants one operation on a set of credentials. Its string form is
+// <operation>:<target> where target is "*", "system/<name>" (every
+// credential on a system) or "credential/<id>".
+type Scope struct {
+	Operation string
+	Kind      string // "*", "system" or "credential"
+	Value     string
+}
+
+// ParseScope parses and validates the string form of a scope
+func ParseScope(s string) (Scope, error) {
+	operation, target, found := strings.Cut(s, ":")
+	if !found {
+		return Scope{}, fmt.Errorf("scope %q must have the form operation:target", s)
+	}
+	if operation != OpCredentialsRead && operation != OpCredentialsReveal {
+		return Scope{}, fmt.Errorf("scope %q has unknown operation %q", s, operation)
+	}
+
+	if target == "*" {
+		return Scope{Operation: operation, Kind: "*"}, nil
+	}
+
+	kind, value, found := strings.Cut(target, "/")
+	if !found || value == "" {
+		return Scope{}, fmt.Errorf("scope %q has invalid target %q", s, target)
+	}
+	switch kind {
+	case "system":
+	case "credential":
+		if _, err := strconv.Atoi(value); err != nil {
+			return Scope{}, fmt.Errorf("scope %q has invalid credential ID", s)
+		}
+	default:
+		return Scope{}, fmt.Errorf("scope %q has unknown target kind %q", s, kind)
+	}
+
+	return Scope{Operation: operation, Kind: kind, Value: value}, nil
+}
+
+// Allows reports whether the scope grants operation on credential
+func (s Scope) Allows(operation string, credential *models.Credential) bool {
+	if s.Operation != operation {
+		return false
+	}
+
+	switch s.Kind {
+	case "*":
+		return true
+	case "system":
+		return credential.System == s.Value
+	case "credential":
+		return strconv.Itoa(credential.ID) == s.Value
+	}
+	return false
+}
+
+// KeyAllows reports whether any of an API key's scopes grants operation on credential
+func KeyAllows(key *models.APIKey, operation string, credential *models.Credential) bool {
+	return ScopesAllow(key.Scopes, operation, credential)
+}
+
+// ScopesAllow reports whether any scope in the list grants operation on credential
+func ScopesAllow(scopes []string, operation string, credential *models.Credential) bool {
+	for _, raw := range scopes {
+		scope, err := ParseScope(raw)
+		if err != nil {
+			continue
+		}
+		if scope.Allows(operation, credential) {
+			return true
+		}
+	}
+	return false
+}
+
+// IPAllowed reports whether ip matches the allowlist; an empty list allows any address
+func IPAllowed(allowlist []string, ip string) bool {
+	if len(allowlist) == 0 {
+		return true
+	}
+
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return false
+	}
+	for _, entry := range allowlist {
+		if _, network, err := net.ParseCIDR(entry); err == nil {
+			if network.Contains(addr) {
+				return true
+			}
+		} else if allowed := net.ParseIP(entry); allowed != nil && allowed.Equal(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateAllowlistEntry checks that entry is an IP address or CIDR block
+func ValidateAllowlistEntry(entry string) error {
+	if _, _, err := net.ParseCIDR(entry); err == nil {
+		return nil
+	}
+	if net.ParseIP(entry) != nil {
+		return nil
+	}
+	return fmt.Errorf("invalid IP address or CIDR %q", entry)
+}
@@ -0,0 +1,71 @@
+package auth
+
+import (
+	"testing"
+
+	"github.com/theshovonaha/mini-pam/internal/models"
+)
+
+func TestGenerateAndParseAPIKey(t *testing.T) {
+	key, prefix, hash, err := GenerateAPIKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gotPrefix, secret, ok := ParseAPIKey(key)
+	if !ok || gotPrefix != prefix {
+		t.Fatalf("ParseAPIKey returned wrong prefix: got %v want %v", gotPrefix, prefix)
+	}
+	if HashToken(secret) != hash {
+		t.Errorf("ParseAPIKey returned a secret that does not match the stored hash")
+	}
+
+	if _, _, ok := ParseAPIKey("not-a-key"); ok {
+		t.Errorf("ParseAPIKey accepted a session token")
+	}
+}
+
+func TestKeyAllows(t *testing.T) {
+	key := &models.APIKey{Scopes: []string{
+		"credentials.reveal:system/db01",
+		"credentials.read:credential/7",
+	}}
+
+	tests := []struct {
+		operation  string
+		credential *models.Credential
+		want       bool
+	}{
+		{OpCredentialsReveal, &models.Credential{ID: 1, System: "db01"}, true},
+		{OpCredentialsReveal, &models.Credential{ID: 2, System: "db02"}, false},
+		{OpCredentialsRead, &models.Credential{ID: 7, System: "db02"}, true},
+		{OpCredentialsReveal, &models.Credential{ID: 7, System: "db02"}, false},
+	}
+
+	for _, tt := range tests {
+		if got := KeyAllows(key, tt.operation, tt.credential); got != tt.want {
+			t.Errorf("KeyAllows(%s, %+v): got %v want %v", tt.operation, tt.credential, got, tt.want)
+		}
+	}
+
+	// Malformed scopes are rejected at creation time
+	for _, raw := range []string{"credentials.reveal", "users.delete:*", "credentials.read:credential/abc"} {
+		if _, err := ParseScope(raw); err == nil {
+			t.Errorf("ParseScope(%q): expected an error", raw)
+		}
+	}
+}
+
+func TestIPAllowed(t *testing.T) {
+	allowlist := []string{"10.0.0.0/8", "192.168.1.10"}
+
+	if !IPAllowed(allowlist, "10.1.2.3") || !IPAllowed(allowlist, "192.168.1.10") {
+		t.Errorf("IPAllowed rejected an allowed address")
+	}
+	if IPAllowed(allowlist, "192.168.1.11") {
+		t.Errorf("IPAllowed accepted an address outside the allowlist")
+	}
+	if !IPAllowed(nil, "203.0.113.5") {
+		t.Errorf("IPAllowed rejected an address with an empty allowlist")
+	}
+}
@@ -0,0 +1,68 @@
+package auth
+
+import (
+	"errors"
+
+	"github.com/theshovonaha/mini-pam/internal/models"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ErrInvalidCredentials is returned when a username/password pair is rejected
+var ErrInvalidCredentials = errors.New("invalid username or password")
+
+// Identity is the result of a successful authentication
+type Identity struct {
+	Username  string
+	Email     string
+	FirstName string
+	LastName  string
+	Source    string // Name of the backend that vouched for the identity
+	Subject   string // The backend's stable identifier for the user; empty for local users
+
+	// Roles are the mini-pam role names the backend grants the user.
+	// ManagedRoles lists every role name the backend is authoritative for;
+	// roles in ManagedRoles but not in Roles are removed on login. Both are
+	// empty for backends that don't manage roles.
+	Roles        []string
+	ManagedRoles []string
+}
+
+// Authenticator verifies a username and password
+type Authenticator interface {
+	Authenticate(username, password string) (*Identity, error)
+}
+
+// LocalAuthenticator checks passwords against the bcrypt hashes in the users table
+type LocalAuthenticator struct {
+	Users *models.UserRepository
+}
+
+// NewLocalAuthenticator creates a new users-table authenticator
+func NewLocalAuthenticator(users *models.UserRepository) *LocalAuthenticator {
+	return &LocalAuthenticator{
+		Users: users,
+	}
+}
+
+// Authenticate implements Authenticator
+func (a *LocalAuthenticator) Authenticate(username, password string) (*Identity, error) {
+	user, err := a.Users.GetByUsername(username)
+	if err != nil {
+		if errors.Is(err, models.ErrRecordNotFound) {
+			return nil, ErrInvalidCredentials
+		}
+		return nil, err
+	}
+
+	if bcrypt.CompareHashAndPassword([]byte(user.HashedPassword), []byte(password)) != nil {
+		return nil, ErrInvalidCredentials
+	}
+
+	return &Identity{
+		Username:  user.Username,
+		Email:     user.Email,
+		FirstName: user.FirstName,
+		LastName:  user.LastName,
+		Source:    "local",
+	}, nil
+}
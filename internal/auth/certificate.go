@@ -0,0 +1,50 @@
+package auth
+
+import (
+	"crypto/x509"
+	"fmt"
+	"strings"
+)
+
+// Certificate identity kinds that can be bound to a service account
+const (
+	CertSubject = "subject"
+	CertDNS     = "dns"
+	CertURI     = "uri"
+	CertEmail   = "email"
+)
+
+// CertificateIdentities lists the identities a client certificate asserts, in
+// the "<kind>:<value>" form used by service account certificate bindings. The
+// subject is rendered as its RFC 2253 distinguished name.
+func CertificateIdentities(cert *x509.Certificate) []string {
+	identities := []string{CertSubject + ":" + cert.Subject.String()}
+	for _, name := range cert.DNSNames {
+		identities = append(identities, CertDNS+":"+strings.ToLower(name))
+	}
+	for _, uri := range cert.URIs {
+		identities = append(identities, CertURI+":"+uri.String())
+	}
+	for _, email := range cert.EmailAddresses {
+		identities = append(identities, CertEmail+":"+strings.ToLower(email))
+	}
+	return identities
+}
+
+// NormalizeCertificateIdentity validates a binding of the form "<kind>:<value>"
+// and returns it in the canonical form produced by CertificateIdentities
+func NormalizeCertificateIdentity(identity string) (string, error) {
+	kind, value, found := strings.Cut(identity, ":")
+	value = strings.TrimSpace(value)
+	if !found || value == "" {
+		return "", fmt.Errorf("certificate identity %q must have the form kind:value", identity)
+	}
+
+	switch kind {
+	case CertSubject, CertURI:
+		return kind + ":" + value, nil
+	case CertDNS, CertEmail:
+		return kind + ":" + strings.ToLower(value), nil
+	}
+	return "", fmt.Errorf("certificate identity %q has unknown kind %q", identity, kind)
+}
@@ -0,0 +1,45 @@
+package auth
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestCertificateIdentities(t *testing.T) {
+	spiffe, _ := url.Parse("spiffe://example.org/ci")
+	cert := &x509.Certificate{
+		Subject:        pkix.Name{CommonName: "ci-runner", Organization: []string{"Example"}},
+		DNSNames:       []string{"CI.example.com"},
+		URIs:           []*url.URL{spiffe},
+		EmailAddresses: []string{"ci@example.com"},
+	}
+
+	want := []string{
+		"subject:CN=ci-runner,O=Example",
+		"dns:ci.example.com",
+		"uri:spiffe://example.org/ci",
+		"email:ci@example.com",
+	}
+	if got := CertificateIdentities(cert); !reflect.DeepEqual(got, want) {
+		t.Errorf("CertificateIdentities: got %v want %v", got, want)
+	}
+
+	// Bindings are normalised to the form CertificateIdentities produces
+	for _, binding := range want {
+		got, err := NormalizeCertificateIdentity(binding)
+		if err != nil || got != binding {
+			t.Errorf("NormalizeCertificateIdentity(%q): got %q, %v", binding, got, err)
+		}
+	}
+	if got, _ := NormalizeCertificateIdentity("dns:CI.Example.com"); got != "dns:ci.example.com" {
+		t.Errorf("NormalizeCertificateIdentity did not lowercase DNS name: %q", got)
+	}
+	for _, bad := range []string{"ci-runner", "ip:10.0.0.1", "dns:"} {
+		if _, err := NormalizeCertificateIdentity(bad); err == nil {
+			t.Errorf("NormalizeCertificateIdentity(%q): expected an error", bad)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package auth
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/theshovonaha/mini-pam/internal/ldap"
+)
+
+// LDAPConfig holds the directory connection and mapping settings
+type LDAPConfig struct {
+	URL            string            // ldap://host:389 or ldaps://host:636
+	BindDN         string            // Service account used to search for users; empty for anonymous
+	BindPassword   string            // Service account password
+	BaseDN         string            // Subtree searched for user entries
+	UserFilter     string            // Filter with a single %s for the escaped username
+	GroupAttribute string            // Attribute listing group DNs, e.g. memberOf
+	GroupRoles     map[string]string // Group DN to mini-pam role name
+	TLSConfig      *tls.Config
+	Timeout        time.Duration
+	Insecure       bool // Skip StartTLS on ldap:// URLs, sending passwords in cleartext
+}
+
+// LDAPAuthenticator verifies passwords by binding to an LDAP directory
+type LDAPAuthenticator struct {
+	config LDAPConfig
+}
+
+// NewLDAPAuthenticator creates a new LDAP bind authenticator
+func NewLDAPAuthenticator(cfg LDAPConfig) *LDAPAuthenticator {
+	// Apply Active Directory friendly defaults
+	if cfg.UserFilter == "" {
+		cfg.UserFilter = "(sAMAccountName=%s)"
+	}
+	if cfg.GroupAttribute == "" {
+		cfg.GroupAttribute = "memberOf"
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 5 * time.Second
+	}
+
+	return &LDAPAuthenticator{
+		config: cfg,
+	}
+}
+
+// Authenticate implements Authenticator
+func (a *LDAPAuthenticator) Authenticate(username, password string) (*Identity, error) {
+	// An empty password would be an unauthenticated bind, which most
+	// directories report as success
+	if username == "" || password == "" {
+		return nil, ErrInvalidCredentials
+	}
+
+	var conn *ldap.Conn
+	var err error
+	if a.config.Insecure {
+		conn, err = ldap.DialInsecure(a.config.URL, a.config.Timeout)
+	} else {
+		conn, err = ldap.Dial(a.config.URL, a.config.TLSConfig, a.config.Timeout)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	// Find the user's entry with the service account
+	if a.config.BindDN != "" {
+		if err := conn.Bind(a.config.BindDN, a.config.BindPassword); err != nil {
+			return nil, fmt.Errorf("ldap service bind failed: %w", err)
+		}
+	}
+
+	entries, err := conn.Search(ldap.SearchRequest{
+		BaseDN:     a.config.BaseDN,
+		Filter:     fmt.Sprintf(a.config.UserFilter, ldap.EscapeFilter(username)),
+		Attributes: []string{"mail", "givenName", "sn", a.config.GroupAttribute},
+		SizeLimit:  2,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("ldap user search failed: %w", err)
+	}
+	if len(entries) != 1 {
+		return nil, ErrInvalidCredentials
+	}
+	entry := entries[0]
+
+	// Verify the password by binding as the user
+	if err := conn.Bind(entry.DN, password); err != nil {
+		if errors.Is(err, ldap.ErrInvalidCredentials) {
+			return nil, ErrInvalidCredentials
+		}
+		return nil, fmt.Errorf("ldap user bind failed: %w", err)
+	}
+
+	// Directories match usernames case-insensitively, so one case names
+	// the account however the user typed it
+	username = strings.ToLower(username)
+	identity := &Identity{
+		Username:     username,
+		Email:        entry.First("mail"),
+		FirstName:    entry.First("givenName"),
+		LastName:     entry.First("sn"),
+		Source:       "ldap",
+		Subject:      username,
+		Roles:        MapGroupsToRoles(entry.Get(a.config.GroupAttribute), a.config.GroupRoles),
+		ManagedRoles: managedRoles(a.config.GroupRoles),
+	}
+
+	return identity, nil
+}
+
+// MapGroupsToRoles returns the sorted, de-duplicated roles granted by the
+// given groups. Group names are compared case-insensitively.
+func MapGroupsToRoles(groups []string, mapping map[string]string) []string {
+	seen := map[string]bool{}
+	for _, group := range groups {
+		for mapped, role := range mapping {
+			if strings.EqualFold(strings.TrimSpace(group), strings.TrimSpace(mapped)) {
+				seen[role] = true
+			}
+		}
+	}
+
+	roles := make([]string, 0, len(seen))
+	for role := range seen {
+		roles = append(roles, role)
+	}
+	sort.Strings(roles)
+	return roles
+}
+
+// managedRoles returns the distinct role names that appear in a mapping
+func managedRoles(mapping map[string]string) []string {
+	return MapGroupsToRoles(keys(mapping), mapping)
+}
+
+// keys returns the keys of a string map
+func keys(m map[string]string) []string {
+	out := make([]string, 0, len(m))
+	for k := range m {
+		out = append(out, k)
+	}
+	return out
+}
@@ -0,0 +1,123 @@
+package auth
+
+import (
+	"crypto/tls"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/theshovonaha/mini-pam/internal/ldap/ldaptest"
+)
+
+func newTestDirectory() *ldaptest.Server {
+	return ldaptest.NewServer(
+		&ldaptest.Entry{
+			DN:       "CN=svc-pam,OU=Service,DC=corp,DC=example",
+			Password: "svc-secret",
+		},
+		&ldaptest.Entry{
+			DN:       "CN=Alice Admin,OU=Users,DC=corp,DC=example",
+			Password: "alice-pw",
+			Attributes: map[string][]string{
+				"sAMAccountName": {"alice"},
+				"mail":           {"alice@corp.example"},
+				"givenName":      {"Alice"},
+				"sn":             {"Admin"},
+				"memberOf": {
+					"CN=PAM Admins,OU=Groups,DC=corp,DC=example",
+					"CN=Everyone,OU=Groups,DC=corp,DC=example",
+				},
+			},
+		},
+	)
+}
+
+func newTestLDAPAuthenticator(directory *ldaptest.Server) *LDAPAuthenticator {
+	return NewLDAPAuthenticator(LDAPConfig{
+		URL:          directory.URL,
+		TLSConfig:    directory.ClientTLS,
+		BindDN:       "CN=svc-pam,OU=Service,DC=corp,DC=example",
+		BindPassword: "svc-secret",
+		BaseDN:       "DC=corp,DC=example",
+		GroupRoles: map[string]string{
+			"cn=pam admins,ou=groups,dc=corp,dc=example": "admin",
+			"CN=PAM Users,OU=Groups,DC=corp,DC=example":  "user",
+		},
+	})
+}
+
+func TestLDAPAuthenticate(t *testing.T) {
+	directory := newTestDirectory()
+	defer directory.Close()
+
+	identity, err := newTestLDAPAuthenticator(directory).Authenticate("alice", "alice-pw")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Check the attributes copied from the directory entry
+	if identity.Email != "alice@corp.example" || identity.FirstName != "Alice" || identity.LastName != "Admin" {
+		t.Errorf("Authenticate returned wrong identity: got %+v", identity)
+	}
+	if identity.Source != "ldap" {
+		t.Errorf("Authenticate returned wrong source: got %v want %v", identity.Source, "ldap")
+	}
+
+	// Check the group-to-role mapping
+	if want := []string{"admin"}; !reflect.DeepEqual(identity.Roles, want) {
+		t.Errorf("Authenticate returned wrong roles: got %v want %v", identity.Roles, want)
+	}
+	if want := []string{"admin", "user"}; !reflect.DeepEqual(identity.ManagedRoles, want) {
+		t.Errorf("Authenticate returned wrong managed roles: got %v want %v", identity.ManagedRoles, want)
+	}
+	// However the username is typed, it names the same account
+	identity, err = newTestLDAPAuthenticator(directory).Authenticate("ALICE", "alice-pw")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if identity.Username != "alice" || identity.Subject != "alice" {
+		t.Errorf("Authenticate returned wrong username: got %v, subject %v", identity.Username, identity.Subject)
+	}
+}
+
+func TestLDAPAuthenticateRejects(t *testing.T) {
+	directory := newTestDirectory()
+	defer directory.Close()
+
+	authenticator := newTestLDAPAuthenticator(directory)
+	tests := []struct {
+		name     string
+		username string
+		password string
+	}{
+		{"wrong password", "alice", "nope"},
+		{"empty password", "alice", ""},
+		{"unknown user", "bob", "alice-pw"},
+		{"filter injection", "*", "alice-pw"},
+	}
+
+	for _, tt := range tests {
+		_, err := authenticator.Authenticate(tt.username, tt.password)
+		if !errors.Is(err, ErrInvalidCredentials) {
+			t.Errorf("%s: Authenticate returned wrong error: got %v want %v", tt.name, err, ErrInvalidCredentials)
+		}
+	}
+}
+
+func TestLDAPRequiresTLS(t *testing.T) {
+	directory := newTestDirectory()
+	defer directory.Close()
+
+	// StartTLS is not skipped when the server's certificate is untrusted
+	authenticator := newTestLDAPAuthenticator(directory)
+	authenticator.config.TLSConfig = &tls.Config{}
+	if _, err := authenticator.Authenticate("alice", "alice-pw"); err == nil || errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("Authenticate with an untrusted certificate returned %v", err)
+	}
+
+	// Plain connections are only made when asked for
+	authenticator.config.Insecure = true
+	if _, err := authenticator.Authenticate("alice", "alice-pw"); err != nil {
+		t.Errorf("Authenticate without TLS returned %v", err)
+	}
+}
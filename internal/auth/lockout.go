@@ -0,0 +1,46 @@
+package auth
+
+import (
+	"time"
+)
+
+// LockoutPolicy controls how failed logins slow down and lock out callers.
+// Failures are counted per account and per source IP; a counter resets once
+// no failure has been seen for Window.
+type LockoutPolicy struct {
+	Threshold   int           // failures before an account is locked
+	IPThreshold int           // failures before a source IP is locked
+	Window      time.Duration // quiet period after which counters reset
+	Duration    time.Duration // how long a lockout lasts
+	BaseDelay   time.Duration // delay enforced after the first failure
+	MaxDelay    time.Duration // cap on the progressive delay
+}
+
+// DefaultLockoutPolicy returns the policy used when none is configured
+func DefaultLockoutPolicy() LockoutPolicy {
+	return LockoutPolicy{
+		Threshold:   5,
+		IPThreshold: 50,
+		Window:      15 * time.Minute,
+		Duration:    15 * time.Minute,
+		BaseDelay:   time.Second,
+		MaxDelay:    30 * time.Second,
+	}
+}
+
+// Delay returns how long a caller must wait after its nth consecutive
+// failure. The delay doubles with every failure up to MaxDelay.
+func (p LockoutPolicy) Delay(failures int) time.Duration {
+	if failures <= 0 || p.BaseDelay <= 0 {
+		return 0
+	}
+
+	delay := p.BaseDelay
+	for i := 1; i < failures; i++ {
+		delay *= 2
+		if delay >= p.MaxDelay {
+			return p.MaxDelay
+		}
+	}
+	return min(delay, p.MaxDelay)
+}
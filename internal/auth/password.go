@@ -0,0 +1,124 @@
+package auth
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// maxPasswordBytes is the longest password bcrypt will hash
+const maxPasswordBytes = 72
+
+// PasswordPolicy describes what a new local password must satisfy
+type PasswordPolicy struct {
+	MinLength    int             // minimum length in characters
+	MinCharClass int             // how many of lower, upper, digit and symbol must appear
+	Banned       map[string]bool // lowercased passwords that are never accepted
+	HistorySize  int             // how many previous passwords may not be reused
+	MaxAge       time.Duration   // age after which a password must be changed; 0 disables
+}
+
+// DefaultPasswordPolicy returns the policy used when none is configured
+func DefaultPasswordPolicy() PasswordPolicy {
+	return PasswordPolicy{
+		MinLength:    12,
+		MinCharClass: 3,
+		HistorySize:  5,
+	}
+}
+
+// PasswordPolicyError lists every rule a proposed password breaks
+type PasswordPolicyError struct {
+	Problems []string
+}
+
+// Error implements the error interface
+func (e *PasswordPolicyError) Error() string {
+	return "password " + strings.Join(e.Problems, "; ")
+}
+
+// Validate checks a proposed password for the user with the given username
+// and email, returning a *PasswordPolicyError describing every violation
+func (p PasswordPolicy) Validate(password, username, email string) error {
+	var problems []string
+
+	if n := len([]rune(password)); n < p.MinLength {
+		problems = append(problems, fmt.Sprintf("must be at least %d characters long", p.MinLength))
+	}
+	if len(password) > maxPasswordBytes {
+		problems = append(problems, fmt.Sprintf("must be at most %d bytes long", maxPasswordBytes))
+	}
+
+	if classes := charClasses(password); classes < p.MinCharClass {
+		problems = append(problems, fmt.Sprintf("must contain at least %d of: lowercase letters, uppercase letters, digits, symbols", p.MinCharClass))
+	}
+
+	lower := strings.ToLower(password)
+	if p.Banned[lower] {
+		problems = append(problems, "is too common")
+	}
+
+	// Short identifiers would match too many legitimate passwords
+	if name := strings.ToLower(username); len(name) >= 3 && strings.Contains(lower, name) {
+		problems = append(problems, "must not contain the username")
+	}
+	if local, _, _ := strings.Cut(strings.ToLower(email), "@"); len(local) >= 3 && strings.Contains(lower, local) {
+		problems = append(problems, "must not contain the email address")
+	}
+
+	if len(problems) > 0 {
+		return &PasswordPolicyError{Problems: problems}
+	}
+	return nil
+}
+
+// Expired reports whether a password last changed at changedAt is past MaxAge
+func (p PasswordPolicy) Expired(changedAt, now time.Time) bool {
+	return p.MaxAge > 0 && now.Sub(changedAt) > p.MaxAge
+}
+
+// charClasses counts how many character classes appear in password
+func charClasses(password string) int {
+	var lower, upper, digit, symbol int
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = 1
+		case unicode.IsUpper(r):
+			upper = 1
+		case unicode.IsDigit(r):
+			digit = 1
+		default:
+			symbol = 1
+		}
+	}
+	return lower + upper + digit + symbol
+}
+
+// LoadBannedPasswords reads a banned password list with one password per
+// line; blank lines and lines starting with # are ignored
+func LoadBannedPasswords(path string) (map[string]bool, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	banned := map[string]bool{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		banned[strings.ToLower(line)] = true
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return banned, nil
+}
@@ -0,0 +1,77 @@
+package auth
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestPasswordPolicyValidate(t *testing.T) {
+	policy := DefaultPasswordPolicy()
+	policy.Banned = map[string]bool{"correcthorse1!": true}
+
+	tests := []struct {
+		password string
+		want     []string
+	}{
+		{"Tr0ub4dor&3xyz", nil},
+		{"Sh0rt!", []string{"must be at least 12 characters long"}},
+		{"alllowercaseletters", []string{"must contain at least 3 of: lowercase letters, uppercase letters, digits, symbols"}},
+		{"CorrectHorse1!", []string{"is too common"}},
+		{"Alice-Secret-99", []string{"must not contain the username"}},
+		{"xX-asmith-Xx-1", []string{"must not contain the email address"}},
+	}
+
+	for _, tt := range tests {
+		err := policy.Validate(tt.password, "alice", "asmith@example.com")
+		if tt.want == nil {
+			if err != nil {
+				t.Errorf("Validate(%q): unexpected error %v", tt.password, err)
+			}
+			continue
+		}
+
+		var policyErr *PasswordPolicyError
+		if !errors.As(err, &policyErr) {
+			t.Errorf("Validate(%q): expected a PasswordPolicyError, got %v", tt.password, err)
+			continue
+		}
+		if !reflect.DeepEqual(policyErr.Problems, tt.want) {
+			t.Errorf("Validate(%q): got %v want %v", tt.password, policyErr.Problems, tt.want)
+		}
+	}
+}
+
+func TestPasswordPolicyExpired(t *testing.T) {
+	now := time.Now()
+	policy := PasswordPolicy{MaxAge: 24 * time.Hour}
+
+	if policy.Expired(now.Add(-time.Hour), now) {
+		t.Errorf("fresh password reported as expired")
+	}
+	if !policy.Expired(now.Add(-48*time.Hour), now) {
+		t.Errorf("old password not reported as expired")
+	}
+	if (PasswordPolicy{}).Expired(now.AddDate(-10, 0, 0), now) {
+		t.Errorf("password expired although MaxAge is disabled")
+	}
+}
+
+func TestLoadBannedPasswords(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "banned.txt")
+	if err := os.WriteFile(path, []byte("# common passwords\nPassword123\n\nletmein\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	banned, err := LoadBannedPasswords(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]bool{"password123": true, "letmein": true}
+	if !reflect.DeepEqual(banned, want) {
+		t.Errorf("LoadBannedPasswords: got %v want %v", banned, want)
+	}
+}
@@ -0,0 +1,54 @@
+package auth
+
+import (
+	"encoding/base32"
+	"testing"
+	"time"
+)
+
+func TestHOTPVectors(t *testing.T) {
+	// RFC 6238 appendix B reference secret, truncated to 6 digits
+	key := []byte("12345678901234567890")
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+
+	for _, tt := range tests {
+		got := hotp(key, uint64(tt.unix/30), 6)
+		if got != tt.want {
+			t.Errorf("hotp at %d returned wrong code: got %v want %v", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	secret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+	now := time.Unix(1111111109, 0)
+
+	// The current code and the adjacent periods are accepted
+	if !ValidateTOTP(secret, "081804", now) {
+		t.Errorf("ValidateTOTP rejected the current code")
+	}
+	if !ValidateTOTP(secret, "081804", now.Add(30*time.Second)) {
+		t.Errorf("ValidateTOTP rejected a code within the allowed skew")
+	}
+
+	// Codes outside the skew window, or malformed, are rejected
+	if ValidateTOTP(secret, "081804", now.Add(5*time.Minute)) {
+		t.Errorf("ValidateTOTP accepted an expired code")
+	}
+	if ValidateTOTP(secret, "81804", now) {
+		t.Errorf("ValidateTOTP accepted a short code")
+	}
+
+	// A code reports the step it was generated for, wherever in the window it is used
+	if step, ok := MatchTOTP(secret, "081804", now.Add(30*time.Second)); !ok || step != 1111111109/30 {
+		t.Errorf("MatchTOTP returned step %d, %v", step, ok)
+	}
+}
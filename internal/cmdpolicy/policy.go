@@ -0,0 +1,120 @@
+// Package cmdpolicy decides whether a command run through the SSH proxy may
+// reach its target, from the regular expression rules that apply to the
+// user and target of the session.
+package cmdpolicy
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/theshovonaha/mini-pam/internal/models"
+)
+
+// rule is a command rule with its pattern compiled
+type rule struct {
+	*models.CommandRule
+	re *regexp.Regexp
+}
+
+// Policy is the compiled set of rules for one session
+type Policy struct {
+	allow  []rule
+	deny   []rule
+	notify []rule
+}
+
+// Decision is the outcome of checking a command
+type Decision struct {
+	Allowed bool
+	// Rule is the deny rule that blocked the command. It is nil when the
+	// command was blocked for matching none of the allow rules.
+	Rule *models.CommandRule
+	// Flagged lists the notify rules the command matched
+	Flagged []*models.CommandRule
+}
+
+// ValidatePattern reports whether pattern is a usable rule expression
+func ValidatePattern(pattern string) error {
+	if strings.TrimSpace(pattern) == "" {
+		return fmt.Errorf("pattern is empty")
+	}
+	_, err := regexp.Compile(pattern)
+	return err
+}
+
+// Compile builds a policy from rules, which should already be the ones that
+// apply to the session. Inactive rules are skipped.
+func Compile(rules []*models.CommandRule) (*Policy, error) {
+	p := &Policy{}
+	for _, r := range rules {
+		if !r.Active {
+			continue
+		}
+		re, err := regexp.Compile(r.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("command rule %d: %w", r.ID, err)
+		}
+
+		compiled := rule{CommandRule: r, re: re}
+		switch r.Action {
+		case models.CommandAllow:
+			p.allow = append(p.allow, compiled)
+		case models.CommandDeny:
+			p.deny = append(p.deny, compiled)
+		case models.CommandNotify:
+			p.notify = append(p.notify, compiled)
+		default:
+			return nil, fmt.Errorf("command rule %d: unknown action %q", r.ID, r.Action)
+		}
+	}
+	return p, nil
+}
+
+// Empty reports whether the policy has no rules, so commands need not be
+// checked at all
+func (p *Policy) Empty() bool {
+	return len(p.allow) == 0 && len(p.deny) == 0 && len(p.notify) == 0
+}
+
+// Normalize trims a command and collapses runs of white space, so that
+// spacing cannot be used to slip past a pattern
+func Normalize(command string) string {
+	return strings.Join(strings.Fields(command), " ")
+}
+
+// Evaluate checks a command. Deny rules win over allow rules; once any
+// allow rule applies, commands matching none of them are blocked too.
+// Blank commands are always allowed.
+func (p *Policy) Evaluate(command string) Decision {
+	command = Normalize(command)
+	if command == "" {
+		return Decision{Allowed: true}
+	}
+
+	decision := Decision{Allowed: true}
+	for _, r := range p.notify {
+		if r.re.MatchString(command) {
+			decision.Flagged = append(decision.Flagged, r.CommandRule)
+		}
+	}
+
+	for _, r := range p.deny {
+		if r.re.MatchString(command) {
+			decision.Allowed = false
+			decision.Rule = r.CommandRule
+			return decision
+		}
+	}
+
+	if len(p.allow) > 0 {
+		decision.Allowed = false
+		for _, r := range p.allow {
+			if r.re.MatchString(command) {
+				decision.Allowed = true
+				break
+			}
+		}
+	}
+	return decision
+}
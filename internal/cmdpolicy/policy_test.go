@@ -0,0 +1,89 @@
+package cmdpolicy
+
+import (
+	"testing"
+
+	"github.com/theshovonaha/mini-pam/internal/models"
+)
+
+func TestEvaluate(t *testing.T) {
+	policy, err := Compile([]*models.CommandRule{
+		{ID: 1, Name: "rm root", Pattern: `^rm\b.*\s-[a-zA-Z]*r[a-zA-Z]*f?\s+/($|\s)`, Action: models.CommandDeny, Active: true},
+		{ID: 2, Name: "power", Pattern: `^(sudo )?(shutdown|reboot|halt|poweroff)\b`, Action: models.CommandDeny, Active: true},
+		{ID: 3, Name: "sudo", Pattern: `^sudo\b`, Action: models.CommandNotify, Active: true},
+		{ID: 4, Name: "disabled", Pattern: `.`, Action: models.CommandDeny, Active: false},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for command, want := range map[string]int{
+		"ls -l":                0,
+		"rm -rf /":             1,
+		"  rm   -rf   /  ":     1,
+		"rm -rf /tmp/build":    0,
+		"sudo shutdown -h now": 2,
+		"reboot":               2,
+		"":                     0,
+	} {
+		decision := policy.Evaluate(command)
+		blockedBy := 0
+		if decision.Rule != nil {
+			blockedBy = decision.Rule.ID
+		}
+		if blockedBy != want || decision.Allowed != (want == 0) {
+			t.Errorf("%q: allowed %v by rule %d, want rule %d", command, decision.Allowed, blockedBy, want)
+		}
+	}
+
+	// Notify rules flag commands whether or not they are blocked
+	if decision := policy.Evaluate("sudo reboot"); len(decision.Flagged) != 1 || decision.Flagged[0].ID != 3 {
+		t.Errorf("flagged %v", decision.Flagged)
+	}
+	if decision := policy.Evaluate("sudo -l"); !decision.Allowed || len(decision.Flagged) != 1 {
+		t.Errorf("sudo -l: %+v", decision)
+	}
+}
+
+func TestEvaluateAllowList(t *testing.T) {
+	policy, err := Compile([]*models.CommandRule{
+		{ID: 1, Pattern: `^systemctl (status|restart) nginx$`, Action: models.CommandAllow, Active: true},
+		{ID: 2, Pattern: `^(ls|cat|tail)\b`, Action: models.CommandAllow, Active: true},
+		{ID: 3, Pattern: `/etc/shadow`, Action: models.CommandDeny, Active: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for command, allowed := range map[string]bool{
+		"systemctl restart nginx": true,
+		"systemctl stop nginx":    false,
+		"tail -f /var/log/syslog": true,
+		"cat /etc/shadow":         false,
+		"vi /etc/hosts":           false,
+		"":                        true,
+	} {
+		decision := policy.Evaluate(command)
+		if decision.Allowed != allowed {
+			t.Errorf("%q: allowed %v, want %v", command, decision.Allowed, allowed)
+		}
+		if command == "vi /etc/hosts" && decision.Rule != nil {
+			t.Errorf("%q: blocked by rule %d, want no rule", command, decision.Rule.ID)
+		}
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	if _, err := Compile([]*models.CommandRule{{ID: 1, Pattern: `(`, Action: models.CommandDeny, Active: true}}); err == nil {
+		t.Error("expected an invalid pattern to fail")
+	}
+	if _, err := Compile([]*models.CommandRule{{ID: 1, Pattern: `x`, Action: "block", Active: true}}); err == nil {
+		t.Error("expected an unknown action to fail")
+	}
+	if policy, _ := Compile(nil); !policy.Empty() {
+		t.Error("expected an empty policy")
+	}
+	if ValidatePattern(" ") == nil || ValidatePattern(`^ls$`) != nil {
+		t.Error("ValidatePattern")
+	}
+}
@@ -0,0 +1,223 @@
+// Package credhealth judges the health of stored credentials: weak and
+// reused secrets, secrets older than rotation policy, credentials nobody
+// uses and credentials past their expiry. Each credential gets a list of
+// findings and a risk score from 0 to 100.
+package credhealth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"math"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/theshovonaha/mini-pam/internal/models"
+)
+
+// Findings
+const (
+	FindingWeak         = "weak"          // Password entropy below policy, or a common password
+	FindingReused       = "reused"        // Same secret as another credential
+	FindingStale        = "stale"         // Secret older than policy
+	FindingNeverRotated = "never_rotated" // Stale, and unchanged since the credential was created
+	FindingUnused       = "unused"        // Not accessed within policy
+	FindingExpired      = "expired"       // Past its expiry date
+)
+
+// Risk levels
+const (
+	RiskNone     = "none"
+	RiskLow      = "low"
+	RiskMedium   = "medium"
+	RiskHigh     = "high"
+	RiskCritical = "critical"
+)
+
+// weights add up to a credential's score, which is capped at 100
+var weights = map[string]int{
+	FindingExpired:      30,
+	FindingReused:       25,
+	FindingWeak:         25,
+	FindingStale:        15,
+	FindingNeverRotated: 5,
+	FindingUnused:       10,
+}
+
+// day is the unit ages are reported in
+const day = 24 * time.Hour
+
+// Policy sets the thresholds credentials are judged by; a zero age or
+// unused limit disables that check
+type Policy struct {
+	MaxSecretAgeDays int             `json:"max_secret_age_days"`
+	MaxUnusedDays    int             `json:"max_unused_days"`
+	MinEntropy       float64         `json:"min_entropy_bits"`
+	Common           map[string]bool `json:"-"` // Lowercased passwords that are always weak
+}
+
+// DefaultPolicy returns the policy used when none is given
+func DefaultPolicy() Policy {
+	return Policy{MaxSecretAgeDays: 90, MaxUnusedDays: 90, MinEntropy: 60}
+}
+
+// Result is the health of one credential. Secrets never appear in it.
+type Result struct {
+	*models.Credential
+	EntropyBits     *float64   `json:"entropy_bits,omitempty"` // Passwords only
+	ReusedWith      []int      `json:"reused_with,omitempty"`  // IDs of credentials with the same secret
+	SecretChangedAt time.Time  `json:"secret_changed_at"`
+	SecretAgeDays   int        `json:"secret_age_days"`
+	LastAccessedAt  *time.Time `json:"last_accessed_at,omitempty"`
+	Findings        []string   `json:"findings"`
+	Score           int        `json:"score"`
+	Risk            string     `json:"risk"`
+}
+
+// Analyze judges every credential against the policy, returning results
+// riskiest first. Reuse is found by comparing secrets under a key made for
+// this call alone, so the comparison values are useless once it returns.
+func Analyze(usages []*models.CredentialUsage, policy Policy, now time.Time) []*Result {
+	key := make([]byte, 32)
+	rand.Read(key)
+
+	// Group credentials by keyed hash of their secret
+	holders := map[string][]int{}
+	digests := make([]string, len(usages))
+	for i, usage := range usages {
+		if usage.Credential.Secret == "" {
+			continue
+		}
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(usage.Credential.Secret))
+		digests[i] = string(mac.Sum(nil))
+		holders[digests[i]] = append(holders[digests[i]], usage.Credential.ID)
+	}
+
+	results := make([]*Result, 0, len(usages))
+	for i, usage := range usages {
+		credential := usage.Credential
+		result := &Result{
+			Credential:      credential,
+			SecretChangedAt: usage.SecretChangedAt,
+			SecretAgeDays:   int(now.Sub(usage.SecretChangedAt) / day),
+			LastAccessedAt:  usage.LastAccessedAt,
+			Findings:        []string{},
+		}
+
+		if credential.Type == "password" {
+			bits := Entropy(credential.Secret)
+			if policy.Common[strings.ToLower(credential.Secret)] {
+				bits = 0
+			}
+			bits = math.Round(bits*10) / 10
+			result.EntropyBits = &bits
+			if bits < policy.MinEntropy {
+				result.Findings = append(result.Findings, FindingWeak)
+			}
+		}
+
+		if digests[i] != "" && len(holders[digests[i]]) > 1 {
+			for _, id := range holders[digests[i]] {
+				if id != credential.ID {
+					result.ReusedWith = append(result.ReusedWith, id)
+				}
+			}
+			result.Findings = append(result.Findings, FindingReused)
+		}
+
+		if policy.MaxSecretAgeDays > 0 && now.Sub(usage.SecretChangedAt) > time.Duration(policy.MaxSecretAgeDays)*day {
+			result.Findings = append(result.Findings, FindingStale)
+			if !usage.SecretChangedAt.After(credential.CreatedAt.Add(time.Second)) {
+				result.Findings = append(result.Findings, FindingNeverRotated)
+			}
+		}
+
+		lastUse := credential.CreatedAt
+		if usage.LastAccessedAt != nil {
+			lastUse = *usage.LastAccessedAt
+		}
+		if policy.MaxUnusedDays > 0 && now.Sub(lastUse) > time.Duration(policy.MaxUnusedDays)*day {
+			result.Findings = append(result.Findings, FindingUnused)
+		}
+
+		if credential.ExpiresAt != nil && !credential.ExpiresAt.After(now) {
+			result.Findings = append(result.Findings, FindingExpired)
+		}
+
+		for _, finding := range result.Findings {
+			result.Score += weights[finding]
+		}
+		result.Score = min(result.Score, 100)
+		result.Risk = risk(result.Score)
+		results = append(results, result)
+	}
+
+	slices.SortStableFunc(results, func(a, b *Result) int { return b.Score - a.Score })
+	return results
+}
+
+// risk names the level of a score
+func risk(score int) string {
+	switch {
+	case score == 0:
+		return RiskNone
+	case score < 25:
+		return RiskLow
+	case score < 50:
+		return RiskMedium
+	case score < 75:
+		return RiskHigh
+	default:
+		return RiskCritical
+	}
+}
+
+// Entropy estimates the bits of entropy in a password as if each character
+// were drawn at random from the classes it uses, except that characters
+// continuing a repeat or a sequence, like "aaa" or "1234", count one bit
+func Entropy(password string) float64 {
+	runes := []rune(password)
+	if len(runes) == 0 {
+		return 0
+	}
+
+	var lower, upper, digit, symbol, other bool
+	for _, r := range runes {
+		switch {
+		case r < unicode.MaxASCII && unicode.IsLower(r):
+			lower = true
+		case r < unicode.MaxASCII && unicode.IsUpper(r):
+			upper = true
+		case r < unicode.MaxASCII && unicode.IsDigit(r):
+			digit = true
+		case r < unicode.MaxASCII:
+			symbol = true
+		default:
+			other = true
+		}
+	}
+	pool := 0
+	for _, class := range []struct {
+		used bool
+		size int
+	}{{lower, 26}, {upper, 26}, {digit, 10}, {symbol, 33}, {other, 100}} {
+		if class.used {
+			pool += class.size
+		}
+	}
+	perChar := math.Log2(float64(pool))
+
+	bits := perChar
+	for i := 1; i < len(runes); i++ {
+		step := runes[i] - runes[i-1]
+		if step >= -1 && step <= 1 {
+			bits++
+			continue
+		}
+		bits += perChar
+	}
+	return bits
+}
@@ -0,0 +1,103 @@
+package credhealth
+
+import (
+	"encoding/json"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/theshovonaha/mini-pam/internal/models"
+)
+
+func TestEntropy(t *testing.T) {
+	tests := []struct {
+		password string
+		min, max float64
+	}{
+		{"", 0, 0},
+		{"aaaaaaaaaaaa", 0, 16},
+		{"abcdefgh1234", 0, 25},
+		{"Summer2026", 40, 60},
+		{"x7#Kq9!vR2$mZp4&", 100, 110},
+	}
+	for _, tt := range tests {
+		if bits := Entropy(tt.password); bits < tt.min || bits > tt.max {
+			t.Errorf("Entropy(%q) = %.1f, want %.0f-%.0f", tt.password, bits, tt.min, tt.max)
+		}
+	}
+}
+
+func TestAnalyze(t *testing.T) {
+	now := time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC)
+	ago := func(days int) time.Time { return now.Add(-time.Duration(days) * day) }
+	accessed := ago(3)
+	expired := ago(1)
+
+	usages := []*models.CredentialUsage{
+		{
+			Credential:      &models.Credential{ID: 1, Type: "password", Secret: "x7#Kq9!vR2$mZp4&", CreatedAt: ago(10)},
+			SecretChangedAt: ago(10),
+			LastAccessedAt:  &accessed,
+		},
+		{
+			Credential:      &models.Credential{ID: 2, Type: "password", Secret: "Summer2026", CreatedAt: ago(400)},
+			SecretChangedAt: ago(400),
+		},
+		{
+			Credential:      &models.Credential{ID: 3, Type: "api_key", Secret: "Summer2026", CreatedAt: ago(400), ExpiresAt: &expired},
+			SecretChangedAt: ago(30),
+			LastAccessedAt:  &accessed,
+		},
+		{
+			Credential:      &models.Credential{ID: 4, Type: "password", Secret: "CorrectHorseBattery!9", CreatedAt: ago(5)},
+			SecretChangedAt: ago(5),
+		},
+	}
+	policy := DefaultPolicy()
+	policy.Common = map[string]bool{"correcthorsebattery!9": true}
+
+	results := Analyze(usages, policy, now)
+	byID := map[int]*Result{}
+	for _, result := range results {
+		byID[result.ID] = result
+	}
+
+	tests := []struct {
+		id       int
+		findings []string
+		score    int
+		risk     string
+	}{
+		{1, []string{}, 0, RiskNone},
+		{2, []string{FindingWeak, FindingReused, FindingStale, FindingNeverRotated, FindingUnused}, 80, RiskCritical},
+		{3, []string{FindingReused, FindingExpired}, 55, RiskHigh},
+		{4, []string{FindingWeak}, 25, RiskMedium},
+	}
+	for _, tt := range tests {
+		result := byID[tt.id]
+		if !slices.Equal(result.Findings, tt.findings) || result.Score != tt.score || result.Risk != tt.risk {
+			t.Errorf("credential %d: %v, score %d, %s; want %v, score %d, %s",
+				tt.id, result.Findings, result.Score, result.Risk, tt.findings, tt.score, tt.risk)
+		}
+	}
+
+	if !slices.Equal(byID[2].ReusedWith, []int{3}) || !slices.Equal(byID[3].ReusedWith, []int{2}) {
+		t.Errorf("reused with %v and %v", byID[2].ReusedWith, byID[3].ReusedWith)
+	}
+	if byID[3].EntropyBits != nil || *byID[4].EntropyBits != 0 {
+		t.Errorf("entropy of api key %v, common password %v", byID[3].EntropyBits, *byID[4].EntropyBits)
+	}
+	if results[0].ID != 2 || results[len(results)-1].ID != 1 {
+		t.Errorf("not sorted by score: first %d, last %d", results[0].ID, results[len(results)-1].ID)
+	}
+
+	// The report never carries secrets
+	body, err := json.Marshal(results)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(body), "Summer2026") {
+		t.Errorf("secret in report: %s", body)
+	}
+}
@@ -0,0 +1,173 @@
+// Package expiry warns the owners of credentials ahead of their expiry,
+// once per warning window.
+package expiry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"time"
+
+	"github.com/theshovonaha/mini-pam/internal/models"
+	"github.com/theshovonaha/mini-pam/internal/notify"
+)
+
+// day is the unit warning windows are counted in
+const day = 24 * time.Hour
+
+// DefaultWindows are the days before expiry at which owners are warned
+var DefaultWindows = []int{30, 7, 1}
+
+// Notice is a warning that a credential expires within a window
+type Notice struct {
+	Credential *models.Credential
+	Owner      *models.User
+	WindowDays int           // The window that triggered the warning
+	Remaining  time.Duration // Time left until the credential expires
+}
+
+// DaysLeft is the remaining time in whole days, rounded up
+func (n *Notice) DaysLeft() int {
+	return DaysLeft(n.Remaining)
+}
+
+// DaysLeft converts the time left until expiry to whole days, rounded up;
+// it is zero or negative once the time has passed
+func DaysLeft(remaining time.Duration) int {
+	days := int(remaining / day)
+	if remaining > 0 && remaining%day != 0 {
+		days++
+	}
+	return days
+}
+
+// Notifier queues messages for users, implemented by notify.Notifier
+type Notifier interface {
+	Notify(userID int, event string, data any) error
+}
+
+// Store is where expiring credentials and sent warnings are tracked,
+// implemented by models.CredentialRepository
+type Store interface {
+	ListExpiring(before time.Time) ([]*models.Credential, error)
+	ClaimExpiryNotice(credentialID, windowDays int, expiresAt time.Time) (bool, error)
+	ReleaseExpiryNotice(credentialID, windowDays int, expiresAt time.Time) error
+}
+
+// Owners looks up the users who own credentials, implemented by models.UserRepository
+type Owners interface {
+	GetByID(id int) (*models.User, error)
+}
+
+// Checker finds credentials entering a warning window and notifies their
+// owners. Each window is announced once per expiry date; a credential
+// that enters several windows between checks gets only the narrowest.
+type Checker struct {
+	store    Store
+	owners   Owners
+	notifier Notifier
+	windows  []int
+	now      func() time.Time
+}
+
+// NewChecker creates a checker warning at the given days before expiry;
+// no windows uses DefaultWindows
+func NewChecker(store Store, owners Owners, notifier Notifier, windows []int) *Checker {
+	if len(windows) == 0 {
+		windows = DefaultWindows
+	}
+	windows = slices.Clone(windows)
+	slices.Sort(windows)
+
+	return &Checker{
+		store:    store,
+		owners:   owners,
+		notifier: notifier,
+		windows:  windows,
+		now:      time.Now,
+	}
+}
+
+// window returns the narrowest window the remaining time falls in
+func (c *Checker) window(remaining time.Duration) int {
+	for _, days := range c.windows {
+		if remaining <= time.Duration(days)*day {
+			return days
+		}
+	}
+	return c.windows[len(c.windows)-1]
+}
+
+// Check queues the warnings that are due, returning how many it queued
+func (c *Checker) Check() (int, error) {
+	now := c.now()
+	credentials, err := c.store.ListExpiring(now.Add(time.Duration(c.windows[len(c.windows)-1]) * day))
+	if err != nil {
+		return 0, err
+	}
+
+	sent := 0
+	var errs []error
+	for _, credential := range credentials {
+		remaining := credential.ExpiresAt.Sub(now)
+		if remaining <= 0 {
+			continue // Already expired; the report lists these
+		}
+		windowDays := c.window(remaining)
+
+		claimed, err := c.store.ClaimExpiryNotice(credential.ID, windowDays, *credential.ExpiresAt)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if !claimed {
+			continue
+		}
+
+		// A warning that could not be queued is tried again next time
+		if err := c.notify(credential, windowDays, remaining); err != nil {
+			errs = append(errs, fmt.Errorf("credential %d: %w", credential.ID, err))
+			if err := c.store.ReleaseExpiryNotice(credential.ID, windowDays, *credential.ExpiresAt); err != nil {
+				errs = append(errs, err)
+			}
+			continue
+		}
+		sent++
+	}
+
+	return sent, errors.Join(errs...)
+}
+
+// notify queues a notice for the credential's owner
+func (c *Checker) notify(credential *models.Credential, windowDays int, remaining time.Duration) error {
+	owner, err := c.owners.GetByID(credential.CreatedBy)
+	if err != nil {
+		return fmt.Errorf("owner %d: %w", credential.CreatedBy, err)
+	}
+	notice := &Notice{Credential: credential, Owner: owner, WindowDays: windowDays, Remaining: remaining}
+	return c.notifier.Notify(owner.ID, notify.EventCredentialExpiring, notice)
+}
+
+// Run checks immediately and then every interval until ctx is cancelled
+func (c *Checker) Run(ctx context.Context, interval time.Duration, logger *log.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		sent, err := c.Check()
+		if sent > 0 {
+			logger.Printf("Queued %d credential expiry warnings", sent)
+		}
+		if err != nil {
+			logger.Printf("Error checking credential expiry: %v", err)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
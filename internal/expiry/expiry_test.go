@@ -0,0 +1,160 @@
+package expiry
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/theshovonaha/mini-pam/internal/models"
+	"github.com/theshovonaha/mini-pam/internal/notify"
+)
+
+type noticeKey struct {
+	credentialID, windowDays int
+	expiresAt                time.Time
+}
+
+type fakeStore struct {
+	credentials []*models.Credential
+	notices     map[noticeKey]bool
+}
+
+func (s *fakeStore) ListExpiring(before time.Time) ([]*models.Credential, error) {
+	var list []*models.Credential
+	for _, credential := range s.credentials {
+		if credential.ExpiresAt != nil && !credential.ExpiresAt.After(before) {
+			list = append(list, credential)
+		}
+	}
+	return list, nil
+}
+
+func (s *fakeStore) ClaimExpiryNotice(credentialID, windowDays int, expiresAt time.Time) (bool, error) {
+	key := noticeKey{credentialID, windowDays, expiresAt}
+	if s.notices[key] {
+		return false, nil
+	}
+	s.notices[key] = true
+	return true, nil
+}
+
+func (s *fakeStore) ReleaseExpiryNotice(credentialID, windowDays int, expiresAt time.Time) error {
+	delete(s.notices, noticeKey{credentialID, windowDays, expiresAt})
+	return nil
+}
+
+type fakeOwners struct{}
+
+func (fakeOwners) GetByID(id int) (*models.User, error) {
+	return &models.User{ID: id, Username: "alice", Email: "alice@example.com"}, nil
+}
+
+type recordingNotifier struct {
+	sent []*Notice
+	err  error
+}
+
+func (n *recordingNotifier) Notify(userID int, event string, data any) error {
+	if n.err != nil {
+		return n.err
+	}
+	n.sent = append(n.sent, data.(*Notice))
+	return nil
+}
+
+func TestCheckerWarnsOncePerWindow(t *testing.T) {
+	now := time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC)
+	at := func(d time.Duration) *time.Time {
+		t := now.Add(d)
+		return &t
+	}
+	store := &fakeStore{
+		credentials: []*models.Credential{
+			{ID: 1, Name: "db", ExpiresAt: at(20 * day)},
+			{ID: 2, Name: "api", ExpiresAt: at(3 * day)},
+			{ID: 3, Name: "old", ExpiresAt: at(-day)},
+			{ID: 4, Name: "later", ExpiresAt: at(60 * day)},
+			{ID: 5, Name: "never"},
+		},
+		notices: map[noticeKey]bool{},
+	}
+	notifier := &recordingNotifier{}
+	checker := NewChecker(store, fakeOwners{}, notifier, nil)
+	checker.now = func() time.Time { return now }
+
+	sent, err := checker.Check()
+	if err != nil || sent != 2 {
+		t.Fatalf("Check() = %d, %v", sent, err)
+	}
+	if notifier.sent[0].WindowDays != 30 || notifier.sent[1].WindowDays != 7 || notifier.sent[1].DaysLeft() != 3 {
+		t.Errorf("sent %+v, %+v", notifier.sent[0], notifier.sent[1])
+	}
+
+	// Nothing new until a credential crosses into the next window
+	if sent, _ := checker.Check(); sent != 0 {
+		t.Errorf("second check sent %d", sent)
+	}
+	checker.now = func() time.Time { return now.Add(2*day + time.Hour) }
+	if sent, _ := checker.Check(); sent != 1 || notifier.sent[2].Credential.ID != 2 || notifier.sent[2].WindowDays != 1 {
+		t.Errorf("third check sent %d", sent)
+	}
+}
+
+func TestCheckerRetriesUnqueued(t *testing.T) {
+	expires := time.Now().Add(5 * day)
+	store := &fakeStore{
+		credentials: []*models.Credential{{ID: 1, Name: "db", ExpiresAt: &expires}},
+		notices:     map[noticeKey]bool{},
+	}
+	notifier := &recordingNotifier{err: errors.New("database down")}
+	checker := NewChecker(store, fakeOwners{}, notifier, []int{7})
+
+	if sent, err := checker.Check(); sent != 0 || err == nil {
+		t.Fatalf("Check() = %d, %v", sent, err)
+	}
+	notifier.err = nil
+	if sent, err := checker.Check(); sent != 1 || err != nil {
+		t.Errorf("retry = %d, %v", sent, err)
+	}
+}
+
+func TestNoticeTemplate(t *testing.T) {
+	templates, err := notify.LoadTemplates("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expires := time.Date(2026, 3, 8, 9, 0, 0, 0, time.UTC)
+	notice := &Notice{
+		Credential: &models.Credential{ID: 9, Name: "grafana", System: "grafana.internal", ExpiresAt: &expires},
+		Owner:      &models.User{Username: "alice"},
+		WindowDays: 7,
+		Remaining:  7 * day,
+	}
+	subject, body, err := templates.Render(notify.EventCredentialExpiring, notice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if subject != `Credential "grafana" expires in 7 days` {
+		t.Errorf("subject %q", subject)
+	}
+	if !strings.HasPrefix(body, "Hello alice,") || !strings.Contains(body, "Expires: Sun, 08 Mar 2026 09:00:00 UTC") {
+		t.Errorf("body %q", body)
+	}
+}
+
+func TestDaysLeft(t *testing.T) {
+	for remaining, want := range map[time.Duration]int{
+		7 * day:           7,
+		6*day + time.Hour: 7,
+		time.Minute:       1,
+		0:                 0,
+		-time.Hour:        0,
+		-25 * time.Hour:   -1,
+	} {
+		if got := DaysLeft(remaining); got != want {
+			t.Errorf("DaysLeft(%v) = %d, want %d", remaining, got, want)
+		}
+	}
+}
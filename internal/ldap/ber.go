@@ -0,0 +1,241 @@
+package ldap
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// BER identifier bytes used by the LDAP protocol
+const (
+	TagBoolean     = 0x01
+	TagInteger     = 0x02
+	TagOctetString = 0x04
+	TagEnumerated  = 0x0a
+	TagSequence    = 0x10 | constructed
+	TagSet         = 0x11 | constructed
+
+	classApplication = 0x40
+	classContext     = 0x80
+	constructed      = 0x20
+)
+
+// maxPacketSize bounds how much a peer can make us allocate for one message
+const maxPacketSize = 1 << 20
+
+// Packet is a decoded BER element. Constructed elements carry Children;
+// primitive elements carry Data.
+type Packet struct {
+	Tag      byte
+	Data     []byte
+	Children []*Packet
+}
+
+// ApplicationTag returns the identifier byte for an [APPLICATION n] element
+func ApplicationTag(n byte, isConstructed bool) byte {
+	if isConstructed {
+		return classApplication | constructed | n
+	}
+	return classApplication | n
+}
+
+// ContextTag returns the identifier byte for a [n] context-specific element
+func ContextTag(n byte, isConstructed bool) byte {
+	if isConstructed {
+		return classContext | constructed | n
+	}
+	return classContext | n
+}
+
+// NewConstructed builds a constructed element from its children
+func NewConstructed(tag byte, children ...*Packet) *Packet {
+	return &Packet{Tag: tag | constructed, Children: children}
+}
+
+// NewString builds a primitive element holding a string
+func NewString(tag byte, value string) *Packet {
+	return &Packet{Tag: tag, Data: []byte(value)}
+}
+
+// NewInt builds a primitive element holding a two's-complement integer
+func NewInt(tag byte, value int) *Packet {
+	var data []byte
+	v := int64(value)
+	for {
+		data = append([]byte{byte(v)}, data...)
+		v >>= 8
+		if (v == 0 && data[0]&0x80 == 0) || (v == -1 && data[0]&0x80 != 0) {
+			break
+		}
+	}
+	return &Packet{Tag: tag, Data: data}
+}
+
+// NewBool builds a primitive BOOLEAN element
+func NewBool(value bool) *Packet {
+	if value {
+		return &Packet{Tag: TagBoolean, Data: []byte{0xff}}
+	}
+	return &Packet{Tag: TagBoolean, Data: []byte{0x00}}
+}
+
+// IsConstructed reports whether the element contains children
+func (p *Packet) IsConstructed() bool {
+	return p.Tag&constructed != 0
+}
+
+// String returns the element's data as a string
+func (p *Packet) String() string {
+	return string(p.Data)
+}
+
+// Int returns the element's data decoded as a two's-complement integer
+func (p *Packet) Int() int {
+	var v int64
+	for i, b := range p.Data {
+		if i == 0 && b&0x80 != 0 {
+			v = -1
+		}
+		v = v<<8 | int64(b)
+	}
+	return int(v)
+}
+
+// Child returns the i-th child, or an empty packet if it does not exist
+func (p *Packet) Child(i int) *Packet {
+	if i < 0 || i >= len(p.Children) {
+		return &Packet{}
+	}
+	return p.Children[i]
+}
+
+// Bytes encodes the element
+func (p *Packet) Bytes() []byte {
+	data := p.Data
+	if p.IsConstructed() {
+		data = nil
+		for _, child := range p.Children {
+			data = append(data, child.Bytes()...)
+		}
+	}
+
+	out := []byte{p.Tag}
+	out = append(out, encodeLength(len(data))...)
+	return append(out, data...)
+}
+
+// ReadPacket reads and decodes a single element from r
+func ReadPacket(r *bufio.Reader) (*Packet, error) {
+	tag, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	if tag&0x1f == 0x1f {
+		return nil, errors.New("ldap: multi-byte tags are not supported")
+	}
+
+	length, err := readLength(r)
+	if err != nil {
+		return nil, err
+	}
+	if length > maxPacketSize {
+		return nil, fmt.Errorf("ldap: packet of %d bytes exceeds limit", length)
+	}
+
+	data := make([]byte, length)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, err
+	}
+
+	return decode(tag, data)
+}
+
+// decode builds a packet from an identifier and its content octets
+func decode(tag byte, data []byte) (*Packet, error) {
+	p := &Packet{Tag: tag}
+	if !p.IsConstructed() {
+		p.Data = data
+		return p, nil
+	}
+
+	for len(data) > 0 {
+		if len(data) < 2 {
+			return nil, errors.New("ldap: truncated element")
+		}
+		childTag := data[0]
+		length, n, err := parseLength(data[1:])
+		if err != nil {
+			return nil, err
+		}
+		start := 1 + n
+		if start+length > len(data) {
+			return nil, errors.New("ldap: truncated element")
+		}
+
+		child, err := decode(childTag, data[start:start+length])
+		if err != nil {
+			return nil, err
+		}
+		p.Children = append(p.Children, child)
+		data = data[start+length:]
+	}
+
+	return p, nil
+}
+
+// encodeLength encodes a definite length in short or long form
+func encodeLength(n int) []byte {
+	if n < 0x80 {
+		return []byte{byte(n)}
+	}
+
+	var digits []byte
+	for v := n; v > 0; v >>= 8 {
+		digits = append([]byte{byte(v)}, digits...)
+	}
+	return append([]byte{0x80 | byte(len(digits))}, digits...)
+}
+
+// readLength reads a definite length from a stream
+func readLength(r *bufio.Reader) (int, error) {
+	first, err := r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	if first&0x80 == 0 {
+		return int(first), nil
+	}
+
+	count := int(first & 0x7f)
+	if count == 0 || count > 4 {
+		return 0, errors.New("ldap: unsupported length encoding")
+	}
+	length := 0
+	for i := 0; i < count; i++ {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		length = length<<8 | int(b)
+	}
+	return length, nil
+}
+
+// parseLength decodes a definite length from a buffer, returning the bytes consumed
+func parseLength(data []byte) (int, int, error) {
+	first := data[0]
+	if first&0x80 == 0 {
+		return int(first), 1, nil
+	}
+
+	count := int(first & 0x7f)
+	if count == 0 || count > 4 || len(data) < 1+count {
+		return 0, 0, errors.New("ldap: unsupported length encoding")
+	}
+	length := 0
+	for _, b := range data[1 : 1+count] {
+		length = length<<8 | int(b)
+	}
+	return length, 1 + count, nil
+}
@@ -0,0 +1,312 @@
+// Package ldap implements the small subset of LDAPv3 (RFC 4511) that
+// mini-pam needs to authenticate users against a directory: simple bind
+// and subtree search.
+package ldap
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Protocol operation numbers ([APPLICATION n])
+const (
+	OpBindRequest      = 0
+	OpBindResponse     = 1
+	OpUnbindRequest    = 2
+	OpSearchRequest    = 3
+	OpSearchResultItem = 4
+	OpSearchResultDone = 5
+	OpExtendedRequest  = 23
+	OpExtendedResponse = 24
+)
+
+// StartTLSOID names the StartTLS extended operation (RFC 4511 section 4.14)
+const StartTLSOID = "1.3.6.1.4.1.1466.20037"
+
+// Result codes we care about
+const (
+	ResultSuccess            = 0
+	ResultNoSuchObject       = 32
+	ResultInvalidCredentials = 49
+)
+
+// ErrInvalidCredentials is returned when a bind is rejected with code 49
+var ErrInvalidCredentials = errors.New("ldap: invalid credentials")
+
+// ResultError is a non-success LDAPResult returned by the server
+type ResultError struct {
+	Code    int
+	Message string
+}
+
+func (e *ResultError) Error() string {
+	return fmt.Sprintf("ldap: result code %d: %s", e.Code, e.Message)
+}
+
+// Entry is a single search result
+type Entry struct {
+	DN         string
+	Attributes map[string][]string
+}
+
+// Get returns the values of an attribute, matching the name case-insensitively
+func (e *Entry) Get(name string) []string {
+	for attr, values := range e.Attributes {
+		if strings.EqualFold(attr, name) {
+			return values
+		}
+	}
+	return nil
+}
+
+// First returns the first value of an attribute, or an empty string
+func (e *Entry) First(name string) string {
+	if values := e.Get(name); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// Conn is a single LDAP connection
+type Conn struct {
+	conn    net.Conn
+	reader  *bufio.Reader
+	msgID   int
+	timeout time.Duration
+}
+
+// Dial connects to an ldaps:// URL, or to an ldap:// URL and upgrades the
+// connection with StartTLS, so binds never send passwords in cleartext
+func Dial(rawURL string, tlsConfig *tls.Config, timeout time.Duration) (*Conn, error) {
+	return dial(rawURL, tlsConfig, timeout, true)
+}
+
+// DialInsecure connects to an ldap:// URL without TLS. Binds on the
+// connection send passwords in cleartext.
+func DialInsecure(rawURL string, timeout time.Duration) (*Conn, error) {
+	return dial(rawURL, nil, timeout, false)
+}
+
+// dial connects to an LDAP URL, upgrading ldap:// connections with StartTLS
+// if startTLS is set
+func dial(rawURL string, tlsConfig *tls.Config, timeout time.Duration, startTLS bool) (*Conn, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("ldap: invalid URL: %w", err)
+	}
+
+	// Verify the server against the host named in the URL
+	if tlsConfig == nil {
+		tlsConfig = &tls.Config{}
+	}
+	if tlsConfig.ServerName == "" {
+		tlsConfig = tlsConfig.Clone()
+		tlsConfig.ServerName = u.Hostname()
+	}
+
+	dialer := &net.Dialer{Timeout: timeout}
+	var conn net.Conn
+	switch u.Scheme {
+	case "ldap":
+		host := u.Host
+		if u.Port() == "" {
+			host = net.JoinHostPort(u.Hostname(), "389")
+		}
+		conn, err = dialer.Dial("tcp", host)
+	case "ldaps":
+		host := u.Host
+		if u.Port() == "" {
+			host = net.JoinHostPort(u.Hostname(), "636")
+		}
+		conn, err = tls.DialWithDialer(dialer, "tcp", host, tlsConfig)
+	default:
+		return nil, fmt.Errorf("ldap: unsupported scheme %q", u.Scheme)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("ldap: failed to connect: %w", err)
+	}
+
+	c := &Conn{conn: conn, reader: bufio.NewReader(conn), timeout: timeout}
+	if u.Scheme == "ldap" && startTLS {
+		if err := c.startTLS(tlsConfig); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// startTLS asks the server to upgrade the connection and completes the TLS
+// handshake
+func (c *Conn) startTLS(tlsConfig *tls.Config) error {
+	op := NewConstructed(ApplicationTag(OpExtendedRequest, true),
+		NewString(ContextTag(0, false), StartTLSOID),
+	)
+	id, err := c.send(op)
+	if err != nil {
+		return err
+	}
+
+	response, err := c.receive(id)
+	if err != nil {
+		return err
+	}
+	if response.Tag != ApplicationTag(OpExtendedResponse, true) {
+		return fmt.Errorf("ldap: unexpected response tag 0x%02x to StartTLS", response.Tag)
+	}
+	if err := resultError(response); err != nil {
+		return fmt.Errorf("ldap: StartTLS refused: %w", err)
+	}
+
+	conn := tls.Client(c.conn, tlsConfig)
+	if c.timeout > 0 {
+		conn.SetDeadline(time.Now().Add(c.timeout))
+	}
+	if err := conn.Handshake(); err != nil {
+		return fmt.Errorf("ldap: StartTLS handshake failed: %w", err)
+	}
+	c.conn = conn
+	c.reader = bufio.NewReader(conn)
+	return nil
+}
+
+// Close sends an unbind request and closes the connection
+func (c *Conn) Close() error {
+	c.send(NewString(ApplicationTag(OpUnbindRequest, false), ""))
+	return c.conn.Close()
+}
+
+// Bind performs a simple bind
+func (c *Conn) Bind(dn, password string) error {
+	op := NewConstructed(ApplicationTag(OpBindRequest, true),
+		NewInt(TagInteger, 3),
+		NewString(TagOctetString, dn),
+		NewString(ContextTag(0, false), password),
+	)
+	id, err := c.send(op)
+	if err != nil {
+		return err
+	}
+
+	response, err := c.receive(id)
+	if err != nil {
+		return err
+	}
+	if response.Tag != ApplicationTag(OpBindResponse, true) {
+		return fmt.Errorf("ldap: unexpected response tag 0x%02x to bind", response.Tag)
+	}
+
+	return resultError(response)
+}
+
+// SearchRequest describes a subtree search
+type SearchRequest struct {
+	BaseDN     string
+	Filter     string
+	Attributes []string
+	SizeLimit  int
+}
+
+// Search runs a subtree search and collects every returned entry
+func (c *Conn) Search(req SearchRequest) ([]*Entry, error) {
+	filter, err := CompileFilter(req.Filter)
+	if err != nil {
+		return nil, err
+	}
+
+	attrs := NewConstructed(TagSequence)
+	for _, attr := range req.Attributes {
+		attrs.Children = append(attrs.Children, NewString(TagOctetString, attr))
+	}
+
+	op := NewConstructed(ApplicationTag(OpSearchRequest, true),
+		NewString(TagOctetString, req.BaseDN),
+		NewInt(TagEnumerated, 2), // wholeSubtree
+		NewInt(TagEnumerated, 0), // neverDerefAliases
+		NewInt(TagInteger, req.SizeLimit),
+		NewInt(TagInteger, int(c.timeout.Seconds())),
+		NewBool(false),
+		filter,
+		attrs,
+	)
+	id, err := c.send(op)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []*Entry
+	for {
+		response, err := c.receive(id)
+		if err != nil {
+			return nil, err
+		}
+
+		switch response.Tag {
+		case ApplicationTag(OpSearchResultItem, true):
+			entry := &Entry{DN: response.Child(0).String(), Attributes: map[string][]string{}}
+			for _, attr := range response.Child(1).Children {
+				name := attr.Child(0).String()
+				for _, value := range attr.Child(1).Children {
+					entry.Attributes[name] = append(entry.Attributes[name], value.String())
+				}
+			}
+			entries = append(entries, entry)
+		case ApplicationTag(OpSearchResultDone, true):
+			if err := resultError(response); err != nil {
+				return nil, err
+			}
+			return entries, nil
+		default:
+			// Ignore referrals and anything else we don't understand
+		}
+	}
+}
+
+// send writes a message carrying op and returns its message ID
+func (c *Conn) send(op *Packet) (int, error) {
+	c.msgID++
+	msg := NewConstructed(TagSequence, NewInt(TagInteger, c.msgID), op)
+
+	if c.timeout > 0 {
+		c.conn.SetDeadline(time.Now().Add(c.timeout))
+	}
+	if _, err := c.conn.Write(msg.Bytes()); err != nil {
+		return 0, fmt.Errorf("ldap: failed to send request: %w", err)
+	}
+	return c.msgID, nil
+}
+
+// receive reads the next message for id and returns its protocol operation
+func (c *Conn) receive(id int) (*Packet, error) {
+	for {
+		msg, err := ReadPacket(c.reader)
+		if err != nil {
+			return nil, fmt.Errorf("ldap: failed to read response: %w", err)
+		}
+		if msg.Tag != TagSequence || len(msg.Children) < 2 {
+			return nil, errors.New("ldap: malformed response")
+		}
+		if msg.Child(0).Int() == id {
+			return msg.Child(1), nil
+		}
+	}
+}
+
+// resultError converts an LDAPResult into an error
+func resultError(p *Packet) error {
+	code := p.Child(0).Int()
+	switch code {
+	case ResultSuccess:
+		return nil
+	case ResultInvalidCredentials:
+		return ErrInvalidCredentials
+	default:
+		return &ResultError{Code: code, Message: p.Child(2).String()}
+	}
+}
@@ -0,0 +1,135 @@
+package ldap
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// Filter choice tags (RFC 4511 section 4.5.1)
+const (
+	FilterAnd      = 0xa0
+	FilterOr       = 0xa1
+	FilterNot      = 0xa2
+	FilterEquality = 0xa3
+	FilterPresent  = 0x87
+)
+
+// EscapeFilter escapes a value for safe substitution into a filter (RFC 4515)
+func EscapeFilter(value string) string {
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		switch c {
+		case '*', '(', ')', '\\', 0:
+			fmt.Fprintf(&b, "\\%02x", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// CompileFilter parses the string form of a search filter. Only the
+// and/or/not, equality and presence forms are supported, which is all
+// mini-pam needs to locate user entries.
+func CompileFilter(filter string) (*Packet, error) {
+	p, rest, err := compileFilter(strings.TrimSpace(filter))
+	if err != nil {
+		return nil, err
+	}
+	if rest != "" {
+		return nil, fmt.Errorf("ldap: unexpected trailing data in filter %q", filter)
+	}
+	return p, nil
+}
+
+// compileFilter parses one parenthesised filter and returns the unconsumed input
+func compileFilter(s string) (*Packet, string, error) {
+	if !strings.HasPrefix(s, "(") {
+		return nil, "", fmt.Errorf("ldap: filter must start with '(': %q", s)
+	}
+	s = s[1:]
+	if s == "" {
+		return nil, "", fmt.Errorf("ldap: unterminated filter")
+	}
+
+	switch s[0] {
+	case '&', '|':
+		tag := byte(FilterAnd)
+		if s[0] == '|' {
+			tag = FilterOr
+		}
+		p := &Packet{Tag: tag}
+		s = s[1:]
+		for strings.HasPrefix(s, "(") {
+			child, rest, err := compileFilter(s)
+			if err != nil {
+				return nil, "", err
+			}
+			p.Children = append(p.Children, child)
+			s = rest
+		}
+		if !strings.HasPrefix(s, ")") {
+			return nil, "", fmt.Errorf("ldap: unterminated filter")
+		}
+		return p, s[1:], nil
+
+	case '!':
+		child, rest, err := compileFilter(s[1:])
+		if err != nil {
+			return nil, "", err
+		}
+		if !strings.HasPrefix(rest, ")") {
+			return nil, "", fmt.Errorf("ldap: unterminated filter")
+		}
+		return &Packet{Tag: FilterNot, Children: []*Packet{child}}, rest[1:], nil
+	}
+
+	end := strings.IndexByte(s, ')')
+	if end < 0 {
+		return nil, "", fmt.Errorf("ldap: unterminated filter")
+	}
+	attr, value, found := strings.Cut(s[:end], "=")
+	if !found || attr == "" {
+		return nil, "", fmt.Errorf("ldap: invalid filter item %q", s[:end])
+	}
+
+	if value == "*" {
+		return &Packet{Tag: FilterPresent, Data: []byte(attr)}, s[end+1:], nil
+	}
+	if strings.Contains(value, "*") {
+		return nil, "", fmt.Errorf("ldap: substring filters are not supported: %q", s[:end])
+	}
+
+	decoded, err := unescapeFilter(value)
+	if err != nil {
+		return nil, "", err
+	}
+	p := &Packet{Tag: FilterEquality, Children: []*Packet{
+		NewString(TagOctetString, attr),
+		NewString(TagOctetString, decoded),
+	}}
+	return p, s[end+1:], nil
+}
+
+// unescapeFilter decodes \XX escapes in a filter assertion value
+func unescapeFilter(value string) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] != '\\' {
+			b.WriteByte(value[i])
+			continue
+		}
+		if i+3 > len(value) {
+			return "", fmt.Errorf("ldap: invalid escape in %q", value)
+		}
+		decoded, err := hex.DecodeString(value[i+1 : i+3])
+		if err != nil {
+			return "", fmt.Errorf("ldap: invalid escape in %q", value)
+		}
+		b.Write(decoded)
+		i += 2
+	}
+	return b.String(), nil
+}
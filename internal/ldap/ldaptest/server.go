@@ -0,0 +1,257 @@
+// Package ldaptest provides an in-process LDAP directory for tests.
+package ldaptest
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"math/big"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/theshovonaha/mini-pam/internal/ldap"
+)
+
+// Entry is a directory entry served by the stub
+type Entry struct {
+	DN         string
+	Password   string
+	Attributes map[string][]string
+}
+
+// Server is a minimal LDAP server supporting simple bind, search and StartTLS
+type Server struct {
+	URL       string
+	ClientTLS *tls.Config // Trusts the server's self-signed certificate
+	listener  net.Listener
+	serverTLS *tls.Config
+	wg        sync.WaitGroup
+
+	mu      sync.Mutex
+	entries []*Entry
+}
+
+// NewServer starts a stub directory on a loopback port
+func NewServer(entries ...*Entry) *Server {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic("ldaptest: failed to listen: " + err.Error())
+	}
+
+	s := &Server{
+		URL:      "ldap://" + listener.Addr().String(),
+		listener: listener,
+		entries:  entries,
+	}
+	s.serverTLS, s.ClientTLS = selfSigned()
+
+	s.wg.Add(1)
+	go s.serve()
+	return s
+}
+
+// Close stops the server
+func (s *Server) Close() {
+	s.listener.Close()
+	s.wg.Wait()
+}
+
+// serve accepts connections until the listener is closed
+func (s *Server) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.handle(conn)
+		}()
+	}
+}
+
+// handle processes requests on one connection
+func (s *Server) handle(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+
+	for {
+		msg, err := ldap.ReadPacket(reader)
+		if err != nil {
+			return
+		}
+		id := msg.Child(0).Int()
+		op := msg.Child(1)
+
+		switch op.Tag {
+		case ldap.ApplicationTag(ldap.OpBindRequest, true):
+			code := s.bind(op.Child(1).String(), op.Child(2).String())
+			writeMessage(conn, id, result(ldap.OpBindResponse, code))
+		case ldap.ApplicationTag(ldap.OpSearchRequest, true):
+			for _, entry := range s.search(op.Child(0).String(), op.Child(6)) {
+				writeMessage(conn, id, entryPacket(entry, op.Child(7)))
+			}
+			writeMessage(conn, id, result(ldap.OpSearchResultDone, ldap.ResultSuccess))
+		case ldap.ApplicationTag(ldap.OpExtendedRequest, true):
+			if op.Child(0).String() != ldap.StartTLSOID {
+				writeMessage(conn, id, result(ldap.OpExtendedResponse, protocolError))
+				continue
+			}
+			writeMessage(conn, id, result(ldap.OpExtendedResponse, ldap.ResultSuccess))
+			tlsConn := tls.Server(conn, s.serverTLS)
+			defer tlsConn.Close()
+			conn, reader = tlsConn, bufio.NewReader(tlsConn)
+		case ldap.ApplicationTag(ldap.OpUnbindRequest, false):
+			return
+		default:
+			return
+		}
+	}
+}
+
+// protocolError is returned for extended operations other than StartTLS
+const protocolError = 2
+
+// selfSigned makes a certificate for the loopback address, returning the
+// server's TLS config and a client config that trusts it
+func selfSigned() (*tls.Config, *tls.Config) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		panic("ldaptest: failed to generate key: " + err.Error())
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		panic("ldaptest: failed to create certificate: " + err.Error())
+	}
+	cert, _ := x509.ParseCertificate(der)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(cert)
+	return &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}},
+		&tls.Config{RootCAs: roots}
+}
+
+// bind checks a simple bind; empty credentials are an anonymous bind
+func (s *Server) bind(dn, password string) int {
+	if dn == "" && password == "" {
+		return ldap.ResultSuccess
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, entry := range s.entries {
+		if strings.EqualFold(entry.DN, dn) && entry.Password != "" && entry.Password == password {
+			return ldap.ResultSuccess
+		}
+	}
+	return ldap.ResultInvalidCredentials
+}
+
+// search returns entries under baseDN matching filter
+func (s *Server) search(baseDN string, filter *ldap.Packet) []*Entry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var matches []*Entry
+	for _, entry := range s.entries {
+		if !strings.HasSuffix(strings.ToLower(entry.DN), strings.ToLower(baseDN)) {
+			continue
+		}
+		if matchFilter(entry, filter) {
+			matches = append(matches, entry)
+		}
+	}
+	return matches
+}
+
+// matchFilter evaluates the filter forms produced by ldap.CompileFilter
+func matchFilter(entry *Entry, filter *ldap.Packet) bool {
+	switch filter.Tag {
+	case ldap.FilterAnd:
+		for _, child := range filter.Children {
+			if !matchFilter(entry, child) {
+				return false
+			}
+		}
+		return true
+	case ldap.FilterOr:
+		for _, child := range filter.Children {
+			if matchFilter(entry, child) {
+				return true
+			}
+		}
+		return false
+	case ldap.FilterNot:
+		return !matchFilter(entry, filter.Child(0))
+	case ldap.FilterPresent:
+		return len(values(entry, filter.String())) > 0
+	case ldap.FilterEquality:
+		for _, value := range values(entry, filter.Child(0).String()) {
+			if strings.EqualFold(value, filter.Child(1).String()) {
+				return true
+			}
+		}
+		return false
+	}
+	return false
+}
+
+// values returns an entry's attribute values, matching the name case-insensitively
+func values(entry *Entry, name string) []string {
+	for attr, vals := range entry.Attributes {
+		if strings.EqualFold(attr, name) {
+			return vals
+		}
+	}
+	return nil
+}
+
+// entryPacket encodes a SearchResultEntry restricted to the requested attributes
+func entryPacket(entry *Entry, requested *ldap.Packet) *ldap.Packet {
+	attrs := ldap.NewConstructed(ldap.TagSequence)
+	for _, name := range requested.Children {
+		vals := ldap.NewConstructed(ldap.TagSet)
+		for _, value := range values(entry, name.String()) {
+			vals.Children = append(vals.Children, ldap.NewString(ldap.TagOctetString, value))
+		}
+		attrs.Children = append(attrs.Children, ldap.NewConstructed(ldap.TagSequence,
+			ldap.NewString(ldap.TagOctetString, name.String()),
+			vals,
+		))
+	}
+
+	return ldap.NewConstructed(ldap.ApplicationTag(ldap.OpSearchResultItem, true),
+		ldap.NewString(ldap.TagOctetString, entry.DN),
+		attrs,
+	)
+}
+
+// result encodes an LDAPResult-shaped response
+func result(op byte, code int) *ldap.Packet {
+	return ldap.NewConstructed(ldap.ApplicationTag(op, true),
+		ldap.NewInt(ldap.TagEnumerated, code),
+		ldap.NewString(ldap.TagOctetString, ""),
+		ldap.NewString(ldap.TagOctetString, ""),
+	)
+}
+
+// writeMessage wraps an operation in an LDAPMessage and writes it
+func writeMessage(conn net.Conn, id int, op *ldap.Packet) {
+	msg := ldap.NewConstructed(ldap.TagSequence, ldap.NewInt(ldap.TagInteger, id), op)
+	conn.Write(msg.Bytes())
+}
@@ -0,0 +1,113 @@
+// Package mail sends plain-text email notifications.
+package mail
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// Message is a plain-text email
+type Message struct {
+	To      []string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers email messages
+type Mailer interface {
+	Send(msg *Message) error
+}
+
+// SMTPConfig holds the settings of an SMTP relay
+type SMTPConfig struct {
+	Addr     string // host:port of the relay
+	From     string // envelope and header sender address
+	Username string // optional; PLAIN auth is used when set
+	Password string
+}
+
+// SMTPMailer sends messages through an SMTP relay, upgrading to TLS with
+// STARTTLS whenever the relay offers it
+type SMTPMailer struct {
+	config SMTPConfig
+}
+
+// NewSMTPMailer creates a new SMTP mailer
+func NewSMTPMailer(cfg SMTPConfig) *SMTPMailer {
+	return &SMTPMailer{
+		config: cfg,
+	}
+}
+
+// Send implements Mailer
+func (m *SMTPMailer) Send(msg *Message) error {
+	if len(msg.To) == 0 {
+		return errors.New("mail: message has no recipients")
+	}
+	for _, addr := range append([]string{m.config.From}, msg.To...) {
+		if strings.ContainsAny(addr, "\r\n") {
+			return fmt.Errorf("mail: invalid address %q", addr)
+		}
+	}
+
+	var auth smtp.Auth
+	if m.config.Username != "" {
+		host, _, err := net.SplitHostPort(m.config.Addr)
+		if err != nil {
+			return fmt.Errorf("mail: invalid relay address: %w", err)
+		}
+		auth = smtp.PlainAuth("", m.config.Username, m.config.Password, host)
+	}
+
+	if err := smtp.SendMail(m.config.Addr, auth, m.config.From, msg.To, m.format(msg)); err != nil {
+		return fmt.Errorf("mail: failed to send: %w", err)
+	}
+	return nil
+}
+
+// format renders msg as an RFC 5322 message
+func (m *SMTPMailer) format(msg *Message) []byte {
+	var buf bytes.Buffer
+	header := func(name, value string) {
+		buf.WriteString(name + ": " + value + "\r\n")
+	}
+
+	header("From", m.config.From)
+	header("To", strings.Join(msg.To, ", "))
+	header("Subject", mime.QEncoding.Encode("utf-8", msg.Subject))
+	header("Date", time.Now().Format(time.RFC1123Z))
+	header("Message-ID", messageID(m.config.From))
+	header("MIME-Version", "1.0")
+	header("Content-Type", "text/plain; charset=utf-8")
+	header("Content-Transfer-Encoding", "8bit")
+	buf.WriteString("\r\n")
+
+	// Normalise line endings; the SMTP client handles dot-stuffing
+	body := strings.ReplaceAll(msg.Body, "\r\n", "\n")
+	buf.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+	if !strings.HasSuffix(body, "\n") {
+		buf.WriteString("\r\n")
+	}
+
+	return buf.Bytes()
+}
+
+// messageID returns a unique Message-ID in the sender's domain
+func messageID(from string) string {
+	domain := "localhost"
+	if _, d, found := strings.Cut(from, "@"); found {
+		domain = strings.TrimSuffix(d, ">")
+	}
+
+	raw := make([]byte, 12)
+	rand.Read(raw)
+	return "<" + hex.EncodeToString(raw) + "@" + domain + ">"
+}
@@ -0,0 +1,42 @@
+package mail
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/theshovonaha/mini-pam/internal/mail/mailtest"
+)
+
+func TestSMTPMailerSend(t *testing.T) {
+	server := mailtest.NewServer()
+	defer server.Close()
+
+	mailer := NewSMTPMailer(SMTPConfig{Addr: server.Addr, From: "pam@example.com"})
+	err := mailer.Send(&Message{
+		To:      []string{"alice@example.com"},
+		Subject: "Password reset",
+		Body:    "Your code is 1234\n.\nThanks",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	messages := server.Messages()
+	if len(messages) != 1 {
+		t.Fatalf("expected 1 message, got %d", len(messages))
+	}
+	msg := messages[0]
+	if msg.From != "pam@example.com" || len(msg.To) != 1 || msg.To[0] != "alice@example.com" {
+		t.Errorf("wrong envelope: from %q to %v", msg.From, msg.To)
+	}
+	if !strings.Contains(msg.Data, "Subject: Password reset\r\n") {
+		t.Errorf("missing subject header:\n%s", msg.Data)
+	}
+	if !strings.HasSuffix(msg.Data, "\r\n\r\nYour code is 1234\r\n.\r\nThanks\r\n") {
+		t.Errorf("body was not preserved:\n%s", msg.Data)
+	}
+
+	if err := mailer.Send(&Message{To: []string{"bob@example.com\r\nRCPT TO:<eve@example.com>"}}); err == nil {
+		t.Errorf("expected an error for a recipient containing CRLF")
+	}
+}
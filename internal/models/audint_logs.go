@@ -2,8 +2,14 @@ package models
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
 	"time"
 
+	"github.com/lib/pq"
 	"github.com/theshovonaha/mini-pam/internal/database"
 )
 
@@ -19,31 +25,139 @@ func NewAuditLogRepository(db *database.Connection) *AuditLogRepository {
 	}
 }
 
-// Create inserts a new audit log entry into the database
-func (r *AuditLogRepository) Create(log *AuditLog) error {
-	query := `
-		INSERT INTO audit_logs (user_id, action, resource, resource_id, ip_address, user_agent, details)
-		VALUES ($1, $2, $3, $4, $5, $6, $7)
-		RETURNING id, timestamp`
+// auditChainLock is the advisory lock key that serializes writers of the
+// audit log hash chain
+const auditChainLock = 0x61756469740001
 
-	// Set a timeout for the query
+// Create appends a new audit log entry to the hash chain. Writers take an
+// advisory lock so that every entry links to the one written before it.
+func (r *AuditLogRepository) Create(log *AuditLog) error {
+	// Set a timeout for the transaction
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
+	tx, err := r.DB.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1)`, auditChainLock); err != nil {
+		return err
+	}
+
+	// Link to the newest entry, or to the newest archived one if every
+	// partition has been archived; unchained rows from before the chain
+	// count as the start
+	err = tx.QueryRowContext(ctx, `
+		SELECT COALESCE(
+			(SELECT COALESCE(hash, '') FROM audit_logs ORDER BY id DESC LIMIT 1),
+			(SELECT last_hash FROM audit_archives ORDER BY last_id DESC LIMIT 1),
+			'')`).Scan(&log.PrevHash)
+	if err != nil {
+		return err
+	}
+
+	// The ID and timestamp are part of the hash, so assign them before inserting
+	err = tx.QueryRowContext(ctx, `SELECT nextval(pg_get_serial_sequence('audit_logs', 'id'))`).Scan(&log.ID)
+	if err != nil {
+		return err
+	}
+	log.Timestamp = time.Now().UTC().Truncate(time.Microsecond)
+	log.Hash = log.ComputeHash()
+
+	query := `
+		INSERT INTO audit_logs (id, user_id, service_account_id, session_id, api_key_id, action, resource, resource_id,
+		                        timestamp, ip_address, user_agent, details, changes, prev_hash, hash)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)`
+
 	// Execute the query
-	err := r.DB.DB.QueryRowContext(
+	_, err = tx.ExecContext(
 		ctx,
 		query,
+		log.ID,
+		nullInt(log.UserID),
+		nullInt(log.ServiceAccountID),
+		nullInt(log.SessionID),
+		nullInt(log.APIKeyID),
+		log.Action,
+		log.Resource,
+		log.ResourceID,
+		log.Timestamp,
+		log.IPAddress,
+		log.UserAgent,
+		log.Details,
+		log.Changes,
+		log.PrevHash,
+		log.Hash,
+	)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// ComputeHash returns the hex SHA-256 of the entry's content and PrevHash.
+// Every stored field is covered, so editing any of them breaks the chain.
+func (log *AuditLog) ComputeHash() string {
+	changes := log.Changes
+	if len(changes) == 0 {
+		changes = nil
+	}
+
+	// A fixed field order keeps the encoding stable; map keys in changes are sorted by encoding/json
+	content, _ := json.Marshal([]interface{}{
+		log.ID,
+		log.PrevHash,
 		log.UserID,
+		log.ServiceAccountID,
+		log.SessionID,
+		log.APIKeyID,
 		log.Action,
 		log.Resource,
 		log.ResourceID,
+		log.Timestamp.UTC().Format(time.RFC3339Nano),
 		log.IPAddress,
 		log.UserAgent,
 		log.Details,
-	).Scan(&log.ID, &log.Timestamp)
+		changes,
+	})
 
-	return err
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+// auditLogColumns is the column list scanned by scanAuditLog
+const auditLogColumns = `id, COALESCE(user_id, 0), COALESCE(service_account_id, 0), COALESCE(session_id, 0),
+		       COALESCE(api_key_id, 0), action, resource, COALESCE(resource_id, 0), timestamp,
+		       COALESCE(ip_address, ''), COALESCE(user_agent, ''), COALESCE(details, ''), changes,
+		       COALESCE(prev_hash, ''), COALESCE(hash, '')`
+
+// scanAuditLog scans a row selected with auditLogColumns
+func scanAuditLog(row interface{ Scan(...interface{}) error }) (*AuditLog, error) {
+	var log AuditLog
+	err := row.Scan(
+		&log.ID,
+		&log.UserID,
+		&log.ServiceAccountID,
+		&log.SessionID,
+		&log.APIKeyID,
+		&log.Action,
+		&log.Resource,
+		&log.ResourceID,
+		&log.Timestamp,
+		&log.IPAddress,
+		&log.UserAgent,
+		&log.Details,
+		&log.Changes,
+		&log.PrevHash,
+		&log.Hash,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &log, nil
 }
 
 // GetByResourceID retrieves audit logs for a specific resource
@@ -53,7 +167,7 @@ func (r *AuditLogRepository) GetByResourceID(resource string, resourceID int, li
 	}
 
 	query := `
-		SELECT id, user_id, action, resource, resource_id, timestamp, ip_address, user_agent, details
+		SELECT ` + auditLogColumns + `
 		FROM audit_logs
 		WHERE resource = $1 AND resource_id = $2
 		ORDER BY timestamp DESC
@@ -73,22 +187,11 @@ func (r *AuditLogRepository) GetByResourceID(resource string, resourceID int, li
 	// Iterate over the rows
 	logs := []*AuditLog{}
 	for rows.Next() {
-		var log AuditLog
-		err := rows.Scan(
-			&log.ID,
-			&log.UserID,
-			&log.Action,
-			&log.Resource,
-			&log.ResourceID,
-			&log.Timestamp,
-			&log.IPAddress,
-			&log.UserAgent,
-			&log.Details,
-		)
+		log, err := scanAuditLog(rows)
 		if err != nil {
 			return nil, err
 		}
-		logs = append(logs, &log)
+		logs = append(logs, log)
 	}
 
 	// Check for errors after iteration
@@ -106,7 +209,7 @@ func (r *AuditLogRepository) GetByUserID(userID int, limit int) ([]*AuditLog, er
 	}
 
 	query := `
-		SELECT id, user_id, action, resource, resource_id, timestamp, ip_address, user_agent, details
+		SELECT ` + auditLogColumns + `
 		FROM audit_logs
 		WHERE user_id = $1
 		ORDER BY timestamp DESC
@@ -126,22 +229,11 @@ func (r *AuditLogRepository) GetByUserID(userID int, limit int) ([]*AuditLog, er
 	// Iterate over the rows
 	logs := []*AuditLog{}
 	for rows.Next() {
-		var log AuditLog
-		err := rows.Scan(
-			&log.ID,
-			&log.UserID,
-			&log.Action,
-			&log.Resource,
-			&log.ResourceID,
-			&log.Timestamp,
-			&log.IPAddress,
-			&log.UserAgent,
-			&log.Details,
-		)
+		log, err := scanAuditLog(rows)
 		if err != nil {
 			return nil, err
 		}
-		logs = append(logs, &log)
+		logs = append(logs, log)
 	}
 
 	// Check for errors after iteration
@@ -166,7 +258,7 @@ func (r *AuditLogRepository) List(page, pageSize int) ([]*AuditLog, error) {
 	offset := (page - 1) * pageSize
 
 	query := `
-		SELECT id, user_id, action, resource, resource_id, timestamp, ip_address, user_agent, details
+		SELECT ` + auditLogColumns + `
 		FROM audit_logs
 		ORDER BY timestamp DESC
 		LIMIT $1 OFFSET $2`
@@ -185,22 +277,310 @@ func (r *AuditLogRepository) List(page, pageSize int) ([]*AuditLog, error) {
 	// Iterate over the rows
 	logs := []*AuditLog{}
 	for rows.Next() {
-		var log AuditLog
-		err := rows.Scan(
-			&log.ID,
-			&log.UserID,
-			&log.Action,
-			&log.Resource,
-			&log.ResourceID,
-			&log.Timestamp,
-			&log.IPAddress,
-			&log.UserAgent,
-			&log.Details,
-		)
+		log, err := scanAuditLog(rows)
+		if err != nil {
+			return nil, err
+		}
+		logs = append(logs, log)
+	}
+
+	// Check for errors after iteration
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return logs, nil
+}
+
+// ListAfter returns up to limit entries with IDs greater than afterID, in
+// chain order. Callers walk the whole log by passing the last ID they saw.
+func (r *AuditLogRepository) ListAfter(afterID, limit int) ([]*AuditLog, error) {
+	query := `
+		SELECT ` + auditLogColumns + `
+		FROM audit_logs
+		WHERE id > $1
+		ORDER BY id
+		LIMIT $2`
+
+	// Set a timeout for the query
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// Execute the query
+	rows, err := r.DB.DB.QueryContext(ctx, query, afterID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	// Iterate over the rows
+	logs := []*AuditLog{}
+	for rows.Next() {
+		log, err := scanAuditLog(rows)
+		if err != nil {
+			return nil, err
+		}
+		logs = append(logs, log)
+	}
+
+	// Check for errors after iteration
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return logs, nil
+}
+
+// AuditLogFilter narrows an audit log query. Zero fields match everything
+// and the remaining conditions are combined with AND.
+type AuditLogFilter struct {
+	From             time.Time // inclusive
+	To               time.Time // exclusive
+	UserID           int
+	ServiceAccountID int
+	Actions          []string
+	Resources        []string
+	ResourceID       int
+	IPNetwork        string // CIDR block; a single address is written as /32 or /128
+	Text             string // case-insensitive substring of details
+}
+
+// AuditLogCursor is the (timestamp, id) position of the last entry of a page
+type AuditLogCursor struct {
+	Timestamp time.Time
+	ID        int
+}
+
+// Query returns up to limit entries matching filter, newest first. Passing
+// the position of the previous page's last entry as after continues from
+// there, which stays fast and stable however deep the caller pages.
+func (r *AuditLogRepository) Query(filter AuditLogFilter, after *AuditLogCursor, limit int) ([]*AuditLog, error) {
+	conditions := []string{}
+	args := []interface{}{}
+	where := func(condition string, arg interface{}) {
+		args = append(args, arg)
+		conditions = append(conditions, strings.ReplaceAll(condition, "?", fmt.Sprintf("$%d", len(args))))
+	}
+
+	if !filter.From.IsZero() {
+		where("timestamp >= ?", filter.From)
+	}
+	if !filter.To.IsZero() {
+		where("timestamp < ?", filter.To)
+	}
+	if filter.UserID != 0 {
+		where("user_id = ?", filter.UserID)
+	}
+	if filter.ServiceAccountID != 0 {
+		where("service_account_id = ?", filter.ServiceAccountID)
+	}
+	if len(filter.Actions) > 0 {
+		where("action = ANY(?)", pq.Array(filter.Actions))
+	}
+	if len(filter.Resources) > 0 {
+		where("resource = ANY(?)", pq.Array(filter.Resources))
+	}
+	if filter.ResourceID != 0 {
+		where("resource_id = ?", filter.ResourceID)
+	}
+	if filter.IPNetwork != "" {
+		// Rows from before addresses were stored without a port are skipped rather than cast
+		where(`CASE WHEN ip_address ~ '^[0-9A-Fa-f:.]+$' THEN ip_address::inet <<= ?::cidr ELSE false END`, filter.IPNetwork)
+	}
+	if filter.Text != "" {
+		escaped := strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(filter.Text)
+		where("details ILIKE ?", "%"+escaped+"%")
+	}
+	if after != nil {
+		args = append(args, after.Timestamp, after.ID)
+		conditions = append(conditions, fmt.Sprintf("(timestamp, id) < ($%d, $%d)", len(args)-1, len(args)))
+	}
+
+	query := `
+		SELECT ` + auditLogColumns + `
+		FROM audit_logs`
+	if len(conditions) > 0 {
+		query += `
+		WHERE ` + strings.Join(conditions, " AND ")
+	}
+	args = append(args, limit)
+	query += fmt.Sprintf(`
+		ORDER BY timestamp DESC, id DESC
+		LIMIT $%d`, len(args))
+
+	// Set a timeout for the query
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// Execute the query
+	rows, err := r.DB.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	// Iterate over the rows
+	logs := []*AuditLog{}
+	for rows.Next() {
+		log, err := scanAuditLog(rows)
+		if err != nil {
+			return nil, err
+		}
+		logs = append(logs, log)
+	}
+
+	// Check for errors after iteration
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return logs, nil
+}
+
+// nullInt maps the zero ID to NULL for nullable foreign keys
+func nullInt(id int) interface{} {
+	if id == 0 {
+		return nil
+	}
+	return id
+}
+
+// nullString stores empty strings as NULL
+func nullString(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+// auditPartitionPrefix names the monthly partitions of audit_logs, which
+// are called audit_logs_pYYYYMM
+const auditPartitionPrefix = "audit_logs_p"
+
+// AuditPartition is one month of the partitioned audit_logs table
+type AuditPartition struct {
+	Name  string
+	Month time.Time // first instant of the month, UTC
+}
+
+// End returns the exclusive upper bound of the partition
+func (p AuditPartition) End() time.Time {
+	return p.Month.AddDate(0, 1, 0)
+}
+
+// AuditPartitionFor returns the partition holding entries written during month
+func AuditPartitionFor(month time.Time) AuditPartition {
+	month = month.UTC()
+	start := time.Date(month.Year(), month.Month(), 1, 0, 0, 0, 0, time.UTC)
+	return AuditPartition{Name: auditPartitionPrefix + start.Format("200601"), Month: start}
+}
+
+// ListPartitions returns the monthly partitions of audit_logs, oldest first
+func (r *AuditLogRepository) ListPartitions() ([]AuditPartition, error) {
+	query := `
+		SELECT child.relname
+		FROM pg_inherits
+		JOIN pg_class parent ON parent.oid = pg_inherits.inhparent
+		JOIN pg_class child ON child.oid = pg_inherits.inhrelid
+		WHERE parent.relname = 'audit_logs'
+		ORDER BY child.relname`
+
+	// Set a timeout for the query
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// Execute the query
+	rows, err := r.DB.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	// Iterate over the rows, skipping partitions not created by us
+	partitions := []AuditPartition{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		suffix, ok := strings.CutPrefix(name, auditPartitionPrefix)
+		if !ok {
+			continue
+		}
+		month, err := time.Parse("200601", suffix)
+		if err != nil {
+			continue
+		}
+		partitions = append(partitions, AuditPartition{Name: name, Month: month})
+	}
+
+	// Check for errors after iteration
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return partitions, nil
+}
+
+// CreatePartition creates the partition for a month if it does not exist yet
+func (r *AuditLogRepository) CreatePartition(partition AuditPartition) error {
+	// Identifiers and bounds cannot be bound as parameters; the name is
+	// derived from the month and the bounds are formatted timestamps
+	query := fmt.Sprintf(`
+		CREATE TABLE IF NOT EXISTS %s PARTITION OF audit_logs
+		FOR VALUES FROM (%s) TO (%s)`,
+		pq.QuoteIdentifier(partition.Name),
+		pq.QuoteLiteral(partition.Month.Format(time.RFC3339)),
+		pq.QuoteLiteral(partition.End().Format(time.RFC3339)),
+	)
+
+	// Set a timeout for the query
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// Execute the query
+	_, err := r.DB.DB.ExecContext(ctx, query)
+	return err
+}
+
+// DropPartition drops a month of audit entries. Callers archive it first.
+func (r *AuditLogRepository) DropPartition(partition AuditPartition) error {
+	query := `DROP TABLE IF EXISTS ` + pq.QuoteIdentifier(partition.Name)
+
+	// Set a timeout for the query
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	// Execute the query
+	_, err := r.DB.DB.ExecContext(ctx, query)
+	return err
+}
+
+// ListPartitionAfter returns up to limit entries of one partition with IDs
+// greater than afterID, in chain order
+func (r *AuditLogRepository) ListPartitionAfter(partition AuditPartition, afterID, limit int) ([]*AuditLog, error) {
+	query := `
+		SELECT ` + auditLogColumns + `
+		FROM audit_logs
+		WHERE timestamp >= $1 AND timestamp < $2 AND id > $3
+		ORDER BY id
+		LIMIT $4`
+
+	// Set a timeout for the query
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// Execute the query
+	rows, err := r.DB.DB.QueryContext(ctx, query, partition.Month, partition.End(), afterID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	// Iterate over the rows
+	logs := []*AuditLog{}
+	for rows.Next() {
+		log, err := scanAuditLog(rows)
 		if err != nil {
 			return nil, err
 		}
-		logs = append(logs, &log)
+		logs = append(logs, log)
 	}
 
 	// Check for errors after iteration
@@ -209,3 +589,54 @@ func (r *AuditLogRepository) List(page, pageSize int) ([]*AuditLog, error) {
 	}
 	return logs, nil
 }
+
+// Import inserts entries read back from an archive exactly as they were
+// stored, hashes included. Entries already present are left alone, so an
+// interrupted import can be run again.
+func (r *AuditLogRepository) Import(logs []*AuditLog) error {
+	// Set a timeout for the transaction
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	tx, err := r.DB.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, `
+		INSERT INTO audit_logs (id, user_id, service_account_id, session_id, api_key_id, action, resource, resource_id,
+		                        timestamp, ip_address, user_agent, details, changes, prev_hash, hash)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)
+		ON CONFLICT (id, timestamp) DO NOTHING`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, log := range logs {
+		_, err := stmt.ExecContext(
+			ctx,
+			log.ID,
+			nullInt(log.UserID),
+			nullInt(log.ServiceAccountID),
+			nullInt(log.SessionID),
+			nullInt(log.APIKeyID),
+			log.Action,
+			log.Resource,
+			log.ResourceID,
+			log.Timestamp,
+			log.IPAddress,
+			log.UserAgent,
+			log.Details,
+			log.Changes,
+			nullString(log.PrevHash),
+			nullString(log.Hash),
+		)
+		if err != nil {
+			return fmt.Errorf("audit log %d: %w", log.ID, err)
+		}
+	}
+
+	return tx.Commit()
+}
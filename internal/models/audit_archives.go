@@ -0,0 +1,199 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/theshovonaha/mini-pam/internal/database"
+)
+
+// AuditArchiveRepository handles database operations related to archived audit log months
+type AuditArchiveRepository struct {
+	DB *database.Connection
+}
+
+// NewAuditArchiveRepository creates a new audit archive repository
+func NewAuditArchiveRepository(db *database.Connection) *AuditArchiveRepository {
+	return &AuditArchiveRepository{
+		DB: db,
+	}
+}
+
+// auditArchiveColumns is the column list scanned by scanAuditArchive
+const auditArchiveColumns = `id, month, file_name, sha256, first_id, last_id, entry_count, last_hash, archived_at, restored_at`
+
+// scanAuditArchive scans a row selected with auditArchiveColumns
+func scanAuditArchive(row interface{ Scan(...interface{}) error }) (*AuditArchive, error) {
+	var archive AuditArchive
+	err := row.Scan(
+		&archive.ID,
+		&archive.Month,
+		&archive.FileName,
+		&archive.SHA256,
+		&archive.FirstID,
+		&archive.LastID,
+		&archive.Count,
+		&archive.LastHash,
+		&archive.ArchivedAt,
+		&archive.RestoredAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	archive.Month = archive.Month.UTC()
+	return &archive, nil
+}
+
+// Create records an archived month. Recording a month again replaces the
+// earlier record, which happens when a restored month is archived anew.
+func (r *AuditArchiveRepository) Create(archive *AuditArchive) error {
+	query := `
+		INSERT INTO audit_archives (month, file_name, sha256, first_id, last_id, entry_count, last_hash)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (month) DO UPDATE
+		SET file_name = EXCLUDED.file_name, sha256 = EXCLUDED.sha256, first_id = EXCLUDED.first_id,
+		    last_id = EXCLUDED.last_id, entry_count = EXCLUDED.entry_count, last_hash = EXCLUDED.last_hash,
+		    archived_at = NOW(), restored_at = NULL
+		RETURNING id, archived_at`
+
+	// Set a timeout for the query
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// Execute the query
+	return r.DB.DB.QueryRowContext(
+		ctx,
+		query,
+		archive.Month.Format("2006-01-02"),
+		archive.FileName,
+		archive.SHA256,
+		archive.FirstID,
+		archive.LastID,
+		archive.Count,
+		archive.LastHash,
+	).Scan(&archive.ID, &archive.ArchivedAt)
+}
+
+// GetByMonth retrieves the archive of the month starting at month
+func (r *AuditArchiveRepository) GetByMonth(month time.Time) (*AuditArchive, error) {
+	query := `
+		SELECT ` + auditArchiveColumns + `
+		FROM audit_archives
+		WHERE month = $1`
+
+	// Set a timeout for the query
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// Execute the query
+	archive, err := scanAuditArchive(r.DB.DB.QueryRowContext(ctx, query, month.Format("2006-01-02")))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, err
+	}
+
+	return archive, nil
+}
+
+// Latest retrieves the archive holding the newest archived entries
+func (r *AuditArchiveRepository) Latest() (*AuditArchive, error) {
+	query := `
+		SELECT ` + auditArchiveColumns + `
+		FROM audit_archives
+		ORDER BY last_id DESC
+		LIMIT 1`
+
+	// Set a timeout for the query
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// Execute the query
+	archive, err := scanAuditArchive(r.DB.DB.QueryRowContext(ctx, query))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, err
+	}
+
+	return archive, nil
+}
+
+// List returns every archived month, oldest first
+func (r *AuditArchiveRepository) List() ([]*AuditArchive, error) {
+	query := `
+		SELECT ` + auditArchiveColumns + `
+		FROM audit_archives
+		ORDER BY month`
+
+	// Set a timeout for the query
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// Execute the query
+	rows, err := r.DB.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	// Iterate over the rows
+	archives := []*AuditArchive{}
+	for rows.Next() {
+		archive, err := scanAuditArchive(rows)
+		if err != nil {
+			return nil, err
+		}
+		archives = append(archives, archive)
+	}
+
+	// Check for errors after iteration
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return archives, nil
+}
+
+// MarkRestored records that an archived month was imported back into the
+// database, creating the record if this database never archived it
+func (r *AuditArchiveRepository) MarkRestored(archive *AuditArchive) error {
+	query := `
+		INSERT INTO audit_archives (month, file_name, sha256, first_id, last_id, entry_count, last_hash, restored_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, NOW())
+		ON CONFLICT (month) DO UPDATE SET restored_at = NOW()
+		RETURNING id, archived_at, restored_at`
+
+	// Set a timeout for the query
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// Execute the query
+	return r.DB.DB.QueryRowContext(
+		ctx,
+		query,
+		archive.Month.Format("2006-01-02"),
+		archive.FileName,
+		archive.SHA256,
+		archive.FirstID,
+		archive.LastID,
+		archive.Count,
+		archive.LastHash,
+	).Scan(&archive.ID, &archive.ArchivedAt, &archive.RestoredAt)
+}
+
+// ClearRestored records that a restored month was dropped again
+func (r *AuditArchiveRepository) ClearRestored(id int) error {
+	query := `UPDATE audit_archives SET restored_at = NULL WHERE id = $1`
+
+	// Set a timeout for the query
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// Execute the query
+	_, err := r.DB.DB.ExecContext(ctx, query, id)
+	return err
+}
@@ -0,0 +1,210 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/theshovonaha/mini-pam/internal/database"
+)
+
+// CommandRuleRepository handles database operations related to SSH command rules
+type CommandRuleRepository struct {
+	DB *database.Connection
+}
+
+// NewCommandRuleRepository creates a new command rule repository
+func NewCommandRuleRepository(db *database.Connection) *CommandRuleRepository {
+	return &CommandRuleRepository{
+		DB: db,
+	}
+}
+
+// commandRuleColumns is the column list scanned by scanCommandRule
+const commandRuleColumns = `id, name, description, pattern, action, COALESCE(role_id, 0), COALESCE(credential_id, 0),
+		       active, COALESCE(created_by, 0), created_at, updated_at`
+
+// scanCommandRule scans a row selected with commandRuleColumns
+func scanCommandRule(row interface{ Scan(...interface{}) error }) (*CommandRule, error) {
+	var rule CommandRule
+	err := row.Scan(
+		&rule.ID,
+		&rule.Name,
+		&rule.Description,
+		&rule.Pattern,
+		&rule.Action,
+		&rule.RoleID,
+		&rule.CredentialID,
+		&rule.Active,
+		&rule.CreatedBy,
+		&rule.CreatedAt,
+		&rule.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &rule, nil
+}
+
+// Create inserts a new command rule into the database
+func (r *CommandRuleRepository) Create(rule *CommandRule) error {
+	query := `
+		INSERT INTO command_rules (name, description, pattern, action, role_id, credential_id, active, created_by)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING id, created_at, updated_at`
+
+	// Set a timeout for the query
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// Execute the query
+	return r.DB.DB.QueryRowContext(
+		ctx,
+		query,
+		rule.Name,
+		rule.Description,
+		rule.Pattern,
+		rule.Action,
+		nullInt(rule.RoleID),
+		nullInt(rule.CredentialID),
+		rule.Active,
+		nullInt(rule.CreatedBy),
+	).Scan(&rule.ID, &rule.CreatedAt, &rule.UpdatedAt)
+}
+
+// GetByID retrieves a command rule by its ID
+func (r *CommandRuleRepository) GetByID(id int) (*CommandRule, error) {
+	query := `
+		SELECT ` + commandRuleColumns + `
+		FROM command_rules
+		WHERE id = $1`
+
+	// Set a timeout for the query
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// Execute the query
+	rule, err := scanCommandRule(r.DB.DB.QueryRowContext(ctx, query, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, err
+	}
+
+	return rule, nil
+}
+
+// List returns all command rules
+func (r *CommandRuleRepository) List() ([]*CommandRule, error) {
+	query := `
+		SELECT ` + commandRuleColumns + `
+		FROM command_rules
+		ORDER BY name, id`
+
+	return r.list(query)
+}
+
+// ListApplicable returns the active rules that apply to a user holding
+// roleIDs connecting to the given credential
+func (r *CommandRuleRepository) ListApplicable(roleIDs []int, credentialID int) ([]*CommandRule, error) {
+	query := `
+		SELECT ` + commandRuleColumns + `
+		FROM command_rules
+		WHERE active
+		  AND (role_id IS NULL OR role_id = ANY($1))
+		  AND (credential_id IS NULL OR credential_id = $2)
+		ORDER BY id`
+
+	return r.list(query, pq.Array(roleIDs), credentialID)
+}
+
+// list runs a query selecting commandRuleColumns
+func (r *CommandRuleRepository) list(query string, args ...interface{}) ([]*CommandRule, error) {
+	// Set a timeout for the query
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// Execute the query
+	rows, err := r.DB.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	// Iterate over the rows
+	rules := []*CommandRule{}
+	for rows.Next() {
+		rule, err := scanCommandRule(rows)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+
+	// Check for errors after iteration
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+// Update saves a command rule's definition and scope
+func (r *CommandRuleRepository) Update(rule *CommandRule) error {
+	query := `
+		UPDATE command_rules
+		SET name = $1, description = $2, pattern = $3, action = $4, role_id = $5, credential_id = $6,
+		    active = $7, updated_at = NOW()
+		WHERE id = $8
+		RETURNING updated_at`
+
+	// Set a timeout for the query
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// Execute the query
+	err := r.DB.DB.QueryRowContext(
+		ctx,
+		query,
+		rule.Name,
+		rule.Description,
+		rule.Pattern,
+		rule.Action,
+		nullInt(rule.RoleID),
+		nullInt(rule.CredentialID),
+		rule.Active,
+		rule.ID,
+	).Scan(&rule.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrRecordNotFound
+	}
+
+	return err
+}
+
+// Delete removes a command rule
+func (r *CommandRuleRepository) Delete(id int) error {
+	query := `DELETE FROM command_rules WHERE id = $1`
+
+	// Set a timeout for the query
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// Execute the query
+	result, err := r.DB.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
@@ -92,7 +92,8 @@ func (r *CredentialRepository) Update(credential *Credential) error {
 	query := `
 		UPDATE credentials
 		SET name = $1, description = $2, type = $3, username = $4, secret = $5, 
-		    system = $6, expires_at = $7, updated_at = NOW()
+		    system = $6, expires_at = $7, updated_at = NOW(),
+		    secret_changed_at = CASE WHEN secret IS DISTINCT FROM $5 THEN NOW() ELSE secret_changed_at END
 		WHERE id = $8
 		RETURNING updated_at`
 
@@ -222,11 +223,62 @@ func (r *CredentialRepository) List(system string, page, pageSize int) ([]*Crede
 	return credentials, nil
 }
 
+// ListByName returns the credentials with the given name. Names are not
+// unique, so callers decide what to do with several matches.
+func (r *CredentialRepository) ListByName(name string) ([]*Credential, error) {
+	query := `
+		SELECT id, name, description, type, username, secret, system, expires_at, created_at, updated_at, created_by
+		FROM credentials
+		WHERE name = $1
+		ORDER BY id`
+
+	// Set a timeout for the query
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// Execute the query
+	rows, err := r.DB.DB.QueryContext(ctx, query, name)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	// Iterate over the rows
+	credentials := []*Credential{}
+	for rows.Next() {
+		var credential Credential
+		err := rows.Scan(
+			&credential.ID,
+			&credential.Name,
+			&credential.Description,
+			&credential.Type,
+			&credential.Username,
+			&credential.Secret,
+			&credential.System,
+			&credential.ExpiresAt,
+			&credential.CreatedAt,
+			&credential.UpdatedAt,
+			&credential.CreatedBy,
+		)
+		if err != nil {
+			return nil, err
+		}
+		credentials = append(credentials, &credential)
+	}
+
+	// Check for errors after iteration
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return credentials, nil
+}
+
 // LogAccess logs an access to a credential
 func (r *CredentialRepository) LogAccess(access *CredentialAccess) error {
 	query := `
-		INSERT INTO credential_access (user_id, credential_id, ip_address, user_agent, reason)
-		VALUES ($1, $2, $3, $4, $5)
+		INSERT INTO credential_access (user_id, service_account_id, credential_id, ip_address, user_agent, reason)
+		VALUES ($1, $2, $3, $4, $5, $6)
 		RETURNING id, accessed_at`
 
 	// Set a timeout for the query
@@ -237,7 +289,8 @@ func (r *CredentialRepository) LogAccess(access *CredentialAccess) error {
 	err := r.DB.DB.QueryRowContext(
 		ctx,
 		query,
-		access.UserID,
+		nullInt(access.UserID),
+		nullInt(access.ServiceAccountID),
 		access.CredentialID,
 		access.IPAddress,
 		access.UserAgent,
@@ -254,7 +307,8 @@ func (r *CredentialRepository) GetAccessHistory(credentialID int, limit int) ([]
 	}
 
 	query := `
-		SELECT id, user_id, credential_id, accessed_at, ip_address, user_agent, reason
+		SELECT id, COALESCE(user_id, 0), COALESCE(service_account_id, 0), credential_id, accessed_at,
+		       COALESCE(ip_address, ''), COALESCE(user_agent, ''), COALESCE(reason, '')
 		FROM credential_access
 		WHERE credential_id = $1
 		ORDER BY accessed_at DESC
@@ -278,6 +332,7 @@ func (r *CredentialRepository) GetAccessHistory(credentialID int, limit int) ([]
 		err := rows.Scan(
 			&access.ID,
 			&access.UserID,
+			&access.ServiceAccountID,
 			&access.CredentialID,
 			&access.AccessedAt,
 			&access.IPAddress,
@@ -297,3 +352,156 @@ func (r *CredentialRepository) GetAccessHistory(credentialID int, limit int) ([]
 
 	return accesses, nil
 }
+
+// ListExpiring returns the credentials expiring before the given time,
+// including those already expired, soonest first
+func (r *CredentialRepository) ListExpiring(before time.Time) ([]*Credential, error) {
+	query := `
+		SELECT id, name, description, type, username, secret, system, expires_at, created_at, updated_at, created_by
+		FROM credentials
+		WHERE expires_at IS NOT NULL AND expires_at <= $1
+		ORDER BY expires_at, id`
+
+	// Set a timeout for the query
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// Execute the query
+	rows, err := r.DB.DB.QueryContext(ctx, query, before)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	// Iterate over the rows
+	credentials := []*Credential{}
+	for rows.Next() {
+		var credential Credential
+		err := rows.Scan(
+			&credential.ID,
+			&credential.Name,
+			&credential.Description,
+			&credential.Type,
+			&credential.Username,
+			&credential.Secret,
+			&credential.System,
+			&credential.ExpiresAt,
+			&credential.CreatedAt,
+			&credential.UpdatedAt,
+			&credential.CreatedBy,
+		)
+		if err != nil {
+			return nil, err
+		}
+		credentials = append(credentials, &credential)
+	}
+
+	// Check for errors after iteration
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return credentials, nil
+}
+
+// ClaimExpiryNotice records that the expiry warning for a window is being
+// sent. It returns false if that warning already went out for this expiry
+// date, so several servers never send it twice.
+func (r *CredentialRepository) ClaimExpiryNotice(credentialID, windowDays int, expiresAt time.Time) (bool, error) {
+	query := `
+		INSERT INTO credential_expiry_notices (credential_id, window_days, expires_at)
+		VALUES ($1, $2, $3)
+		ON CONFLICT DO NOTHING`
+
+	// Set a timeout for the query
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// Execute the query
+	result, err := r.DB.DB.ExecContext(ctx, query, credentialID, windowDays, expiresAt)
+	if err != nil {
+		return false, err
+	}
+
+	// Check if any rows were affected
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rowsAffected == 1, nil
+}
+
+// ReleaseExpiryNotice forgets a claimed warning that could not be
+// delivered, so the next check tries again
+func (r *CredentialRepository) ReleaseExpiryNotice(credentialID, windowDays int, expiresAt time.Time) error {
+	query := `
+		DELETE FROM credential_expiry_notices
+		WHERE credential_id = $1 AND window_days = $2 AND expires_at = $3`
+
+	// Set a timeout for the query
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// Execute the query
+	_, err := r.DB.DB.ExecContext(ctx, query, credentialID, windowDays, expiresAt)
+	return err
+}
+
+// ListUsage returns every credential with when its secret last changed
+// and when it was last accessed, for health reporting
+func (r *CredentialRepository) ListUsage() ([]*CredentialUsage, error) {
+	query := `
+		SELECT c.id, c.name, c.description, c.type, c.username, c.secret, c.system, c.expires_at,
+		       c.created_at, c.updated_at, c.created_by, c.secret_changed_at, a.last_accessed_at
+		FROM credentials c
+		LEFT JOIN (
+			SELECT credential_id, MAX(accessed_at) AS last_accessed_at
+			FROM credential_access
+			GROUP BY credential_id
+		) a ON a.credential_id = c.id
+		ORDER BY c.id`
+
+	// Set a timeout for the query
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// Execute the query
+	rows, err := r.DB.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	// Iterate over the rows
+	usages := []*CredentialUsage{}
+	for rows.Next() {
+		usage := &CredentialUsage{Credential: &Credential{}}
+		err := rows.Scan(
+			&usage.Credential.ID,
+			&usage.Credential.Name,
+			&usage.Credential.Description,
+			&usage.Credential.Type,
+			&usage.Credential.Username,
+			&usage.Credential.Secret,
+			&usage.Credential.System,
+			&usage.Credential.ExpiresAt,
+			&usage.Credential.CreatedAt,
+			&usage.Credential.UpdatedAt,
+			&usage.Credential.CreatedBy,
+			&usage.SecretChangedAt,
+			&usage.LastAccessedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		usages = append(usages, usage)
+	}
+
+	// Check for errors after iteration
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return usages, nil
+}
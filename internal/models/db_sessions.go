@@ -0,0 +1,270 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/theshovonaha/mini-pam/internal/database"
+)
+
+// DBSessionRepository handles database operations related to Postgres proxy
+// sessions and their queries
+type DBSessionRepository struct {
+	DB *database.Connection
+}
+
+// NewDBSessionRepository creates a new database session repository
+func NewDBSessionRepository(db *database.Connection) *DBSessionRepository {
+	return &DBSessionRepository{
+		DB: db,
+	}
+}
+
+// dbSessionColumns lists the columns scanned by scanDBSession
+const dbSessionColumns = `id, user_id, credential_id, target, database_name, target_username, ip_address,
+		       application_name, started_at, ended_at, query_count`
+
+// scanDBSession scans a row selected with dbSessionColumns
+func scanDBSession(row interface{ Scan(...interface{}) error }) (*DBSession, error) {
+	var session DBSession
+	err := row.Scan(
+		&session.ID,
+		&session.UserID,
+		&session.CredentialID,
+		&session.Target,
+		&session.Database,
+		&session.TargetUsername,
+		&session.IPAddress,
+		&session.ApplicationName,
+		&session.StartedAt,
+		&session.EndedAt,
+		&session.QueryCount,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// Create inserts a session as it starts
+func (r *DBSessionRepository) Create(session *DBSession) error {
+	query := `
+		INSERT INTO db_sessions (user_id, credential_id, target, database_name, target_username, ip_address, application_name)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id, started_at`
+
+	// Set a timeout for the query
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// Execute the query
+	return r.DB.DB.QueryRowContext(
+		ctx,
+		query,
+		session.UserID,
+		session.CredentialID,
+		session.Target,
+		session.Database,
+		session.TargetUsername,
+		session.IPAddress,
+		session.ApplicationName,
+	).Scan(&session.ID, &session.StartedAt)
+}
+
+// Finish marks a session ended
+func (r *DBSessionRepository) Finish(id int) error {
+	query := `
+		UPDATE db_sessions
+		SET ended_at = NOW()
+		WHERE id = $1`
+
+	// Set a timeout for the query
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// Execute the query
+	result, err := r.DB.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// AddQuery logs a query sent over a session and counts it
+func (r *DBSessionRepository) AddQuery(q *DBQuery) error {
+	query := `
+		WITH logged AS (
+			INSERT INTO db_queries (session_id, query)
+			VALUES ($1, $2)
+			RETURNING id, executed_at
+		), counted AS (
+			UPDATE db_sessions SET query_count = query_count + 1 WHERE id = $1
+		)
+		SELECT id, executed_at FROM logged`
+
+	// Set a timeout for the query
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// Execute the query
+	return r.DB.DB.QueryRowContext(ctx, query, q.SessionID, q.Query).Scan(&q.ID, &q.ExecutedAt)
+}
+
+// GetByID retrieves a session by its ID
+func (r *DBSessionRepository) GetByID(id int) (*DBSession, error) {
+	query := `
+		SELECT ` + dbSessionColumns + `
+		FROM db_sessions
+		WHERE id = $1`
+
+	// Set a timeout for the query
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// Execute the query
+	session, err := scanDBSession(r.DB.DB.QueryRowContext(ctx, query, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, err
+	}
+
+	return session, nil
+}
+
+// DBSessionFilter narrows a session listing. Zero fields match everything.
+type DBSessionFilter struct {
+	UserID       int
+	CredentialID int
+	Text         string // Substring of a query sent over the session
+}
+
+// List returns a page of sessions matching filter, newest first
+func (r *DBSessionRepository) List(filter DBSessionFilter, page, pageSize int) ([]*DBSession, error) {
+	// Ensure valid pagination parameters
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 {
+		pageSize = 20
+	}
+
+	conditions := []string{}
+	args := []interface{}{}
+	where := func(condition string, arg interface{}) {
+		args = append(args, arg)
+		conditions = append(conditions, strings.ReplaceAll(condition, "?", fmt.Sprintf("$%d", len(args))))
+	}
+
+	if filter.UserID != 0 {
+		where("user_id = ?", filter.UserID)
+	}
+	if filter.CredentialID != 0 {
+		where("credential_id = ?", filter.CredentialID)
+	}
+	if filter.Text != "" {
+		escaped := strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(filter.Text)
+		where("EXISTS (SELECT 1 FROM db_queries q WHERE q.session_id = db_sessions.id AND q.query ILIKE ?)", "%"+escaped+"%")
+	}
+
+	query := `
+		SELECT ` + dbSessionColumns + `
+		FROM db_sessions`
+	if len(conditions) > 0 {
+		query += `
+		WHERE ` + strings.Join(conditions, " AND ")
+	}
+	args = append(args, pageSize, (page-1)*pageSize)
+	query += fmt.Sprintf(`
+		ORDER BY id DESC
+		LIMIT $%d OFFSET $%d`, len(args)-1, len(args))
+
+	// Set a timeout for the query
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// Execute the query
+	rows, err := r.DB.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	// Iterate over the rows
+	sessions := []*DBSession{}
+	for rows.Next() {
+		session, err := scanDBSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+
+	// Check for errors after iteration
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return sessions, nil
+}
+
+// ListQueries returns a page of the queries sent over a session, in the
+// order they were sent
+func (r *DBSessionRepository) ListQueries(sessionID, page, pageSize int) ([]*DBQuery, error) {
+	// Ensure valid pagination parameters
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 {
+		pageSize = 100
+	}
+
+	query := `
+		SELECT id, session_id, query, executed_at
+		FROM db_queries
+		WHERE session_id = $1
+		ORDER BY id
+		LIMIT $2 OFFSET $3`
+
+	// Set a timeout for the query
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// Execute the query
+	rows, err := r.DB.DB.QueryContext(ctx, query, sessionID, pageSize, (page-1)*pageSize)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	// Iterate over the rows
+	queries := []*DBQuery{}
+	for rows.Next() {
+		var q DBQuery
+		if err := rows.Scan(&q.ID, &q.SessionID, &q.Query, &q.ExecutedAt); err != nil {
+			return nil, err
+		}
+		queries = append(queries, &q)
+	}
+
+	// Check for errors after iteration
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return queries, nil
+}
@@ -0,0 +1,180 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/theshovonaha/mini-pam/internal/database"
+)
+
+// Login attempt counter scopes
+const (
+	LoginScopeUser = "user"
+	LoginScopeIP   = "ip"
+
+	// Password reset requests, counted per email address and per source IP
+	LoginScopeResetEmail = "reset_email"
+	LoginScopeResetIP    = "reset_ip"
+)
+
+// LoginAttemptRepository handles database operations related to failed login counters
+type LoginAttemptRepository struct {
+	DB *database.Connection
+}
+
+// NewLoginAttemptRepository creates a new login attempt repository
+func NewLoginAttemptRepository(db *database.Connection) *LoginAttemptRepository {
+	return &LoginAttemptRepository{
+		DB: db,
+	}
+}
+
+// Get retrieves the failure counter for a scope and subject
+func (r *LoginAttemptRepository) Get(scope, subject string) (*LoginAttempt, error) {
+	query := `
+		SELECT scope, subject, failures, first_failure_at, last_failure_at, locked_until
+		FROM login_attempts
+		WHERE scope = $1 AND subject = $2`
+
+	var attempt LoginAttempt
+
+	// Set a timeout for the query
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// Execute the query
+	err := r.DB.DB.QueryRowContext(ctx, query, scope, subject).Scan(
+		&attempt.Scope,
+		&attempt.Subject,
+		&attempt.Failures,
+		&attempt.FirstFailureAt,
+		&attempt.LastFailureAt,
+		&attempt.LockedUntil,
+	)
+
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, err
+	}
+
+	return &attempt, nil
+}
+
+// staleAttempt matches a counter that should restart on the next failure
+const staleAttempt = `(login_attempts.last_failure_at < NOW() - make_interval(secs => $3)
+			OR login_attempts.locked_until < NOW())`
+
+// ErrLoginThrottled is returned when an attempt cannot be reserved because
+// the subject is locked out or already has as many attempts as allowed
+var ErrLoginThrottled = errors.New("login attempts exhausted")
+
+// Reserve atomically counts an attempt before its outcome is known,
+// restarting the counter if the previous failure is older than window or an
+// earlier lockout has expired. Reserved attempts count as failures until
+// released, so concurrent guesses cannot get past limit; a limit of zero or
+// less is no limit. It returns ErrLoginThrottled if the attempt is refused.
+func (r *LoginAttemptRepository) Reserve(scope, subject string, window time.Duration, limit int) (*LoginAttempt, error) {
+	query := `
+		INSERT INTO login_attempts (scope, subject, failures, first_failure_at, last_failure_at)
+		VALUES ($1, $2, 1, NOW(), NOW())
+		ON CONFLICT (scope, subject) DO UPDATE SET
+			failures = CASE WHEN ` + staleAttempt + ` THEN 1 ELSE login_attempts.failures + 1 END,
+			first_failure_at = CASE WHEN ` + staleAttempt + ` THEN NOW() ELSE login_attempts.first_failure_at END,
+			locked_until = CASE WHEN ` + staleAttempt + ` THEN NULL ELSE login_attempts.locked_until END,
+			previous_failure_at = CASE WHEN ` + staleAttempt + ` THEN NULL ELSE login_attempts.last_failure_at END,
+			last_failure_at = NOW()
+		WHERE ` + staleAttempt + `
+			OR (login_attempts.locked_until IS NULL AND ($4 <= 0 OR login_attempts.failures < $4))
+		RETURNING scope, subject, failures, first_failure_at, last_failure_at, locked_until`
+
+	var attempt LoginAttempt
+
+	// Set a timeout for the query
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// Execute the query
+	err := r.DB.DB.QueryRowContext(ctx, query, scope, subject, window.Seconds(), limit).Scan(
+		&attempt.Scope,
+		&attempt.Subject,
+		&attempt.Failures,
+		&attempt.FirstFailureAt,
+		&attempt.LastFailureAt,
+		&attempt.LockedUntil,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrLoginThrottled
+		}
+		return nil, err
+	}
+
+	return &attempt, nil
+}
+
+// Release gives back an attempt counted by Reserve that did not fail,
+// restoring the failure time the reservation replaced so the window is not
+// extended by attempts that succeeded
+func (r *LoginAttemptRepository) Release(scope, subject string) error {
+	query := `
+		UPDATE login_attempts
+		SET failures = GREATEST(failures - 1, 0),
+			last_failure_at = COALESCE(previous_failure_at, last_failure_at),
+			previous_failure_at = NULL
+		WHERE scope = $1 AND subject = $2`
+
+	// Set a timeout for the query
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// Execute the query
+	_, err := r.DB.DB.ExecContext(ctx, query, scope, subject)
+	return err
+}
+
+// Lock locks out a scope and subject until the given time, reporting false
+// if it was already locked out
+func (r *LoginAttemptRepository) Lock(scope, subject string, until time.Time) (bool, error) {
+	query := `
+		UPDATE login_attempts
+		SET locked_until = $1
+		WHERE scope = $2 AND subject = $3
+			AND (locked_until IS NULL OR locked_until < NOW())`
+
+	// Set a timeout for the query
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// Execute the query
+	result, err := r.DB.DB.ExecContext(ctx, query, until, scope, subject)
+	if err != nil {
+		return false, err
+	}
+
+	// Check if any rows were affected
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rowsAffected == 1, nil
+}
+
+// Reset clears the failure counter and any lockout for a scope and subject
+func (r *LoginAttemptRepository) Reset(scope, subject string) error {
+	query := `
+		DELETE FROM login_attempts
+		WHERE scope = $1 AND subject = $2`
+
+	// Set a timeout for the query
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// Execute the query
+	_, err := r.DB.DB.ExecContext(ctx, query, scope, subject)
+	return err
+}
@@ -0,0 +1,119 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/theshovonaha/mini-pam/internal/database"
+)
+
+// MFARepository handles database operations related to second factors
+type MFARepository struct {
+	DB *database.Connection
+}
+
+// NewMFARepository creates a new MFA repository
+func NewMFARepository(db *database.Connection) *MFARepository {
+	return &MFARepository{
+		DB: db,
+	}
+}
+
+// Upsert stores a (not yet enabled) TOTP secret for a user, replacing any previous one
+func (r *MFARepository) Upsert(mfa *UserMFA) error {
+	query := `
+		INSERT INTO user_mfa (user_id, totp_secret, enabled)
+		VALUES ($1, $2, FALSE)
+		ON CONFLICT (user_id) DO UPDATE
+		SET totp_secret = EXCLUDED.totp_secret, enabled = FALSE, last_step = NULL, updated_at = NOW()
+		RETURNING enabled, created_at, updated_at`
+
+	// Set a timeout for the query
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// Execute the query
+	err := r.DB.DB.QueryRowContext(ctx, query, mfa.UserID, mfa.TOTPSecret).Scan(
+		&mfa.Enabled, &mfa.CreatedAt, &mfa.UpdatedAt,
+	)
+
+	return err
+}
+
+// GetByUserID retrieves the second factor enrolled for a user
+func (r *MFARepository) GetByUserID(userID int) (*UserMFA, error) {
+	query := `
+		SELECT user_id, totp_secret, enabled, created_at, updated_at
+		FROM user_mfa
+		WHERE user_id = $1`
+
+	var mfa UserMFA
+
+	// Set a timeout for the query
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// Execute the query
+	err := r.DB.DB.QueryRowContext(ctx, query, userID).Scan(
+		&mfa.UserID,
+		&mfa.TOTPSecret,
+		&mfa.Enabled,
+		&mfa.CreatedAt,
+		&mfa.UpdatedAt,
+	)
+
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, err
+	}
+
+	return &mfa, nil
+}
+
+// Enable marks a user's enrolled TOTP secret as confirmed
+func (r *MFARepository) Enable(userID int) error {
+	query := `
+		UPDATE user_mfa
+		SET enabled = TRUE, updated_at = NOW()
+		WHERE user_id = $1`
+
+	// Set a timeout for the query
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// Execute the query
+	_, err := r.DB.DB.ExecContext(ctx, query, userID)
+	return err
+}
+
+// ConsumeStep records that a TOTP code for step was accepted, reporting
+// false if that step or a later one was already used, so codes cannot be
+// replayed. The check and update are one statement, safe across replicas.
+func (r *MFARepository) ConsumeStep(userID int, step int64) (bool, error) {
+	query := `
+		UPDATE user_mfa
+		SET last_step = $2
+		WHERE user_id = $1 AND (last_step IS NULL OR last_step < $2)`
+
+	// Set a timeout for the query
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// Execute the query
+	result, err := r.DB.DB.ExecContext(ctx, query, userID, step)
+	if err != nil {
+		return false, err
+	}
+
+	// Check if any rows were affected
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rowsAffected == 1, nil
+}
@@ -1,22 +1,36 @@
 package models
 
 import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
 	"time"
 )
 
 // User represents a user in the system
 type User struct {
-	ID             int       `json:"id"`
-	Username       string    `json:"username"`
-	Email          string    `json:"email"`
-	HashedPassword string    `json:"-"` // Never expose password hash
-	FirstName      string    `json:"first_name"`
-	LastName       string    `json:"last_name"`
-	Active         bool      `json:"active"`
-	CreatedAt      time.Time `json:"created_at"`
-	UpdatedAt      time.Time `json:"updated_at"`
+	ID                int       `json:"id"`
+	Username          string    `json:"username"`
+	Email             string    `json:"email"`
+	HashedPassword    string    `json:"-"` // Never expose password hash
+	FirstName         string    `json:"first_name"`
+	LastName          string    `json:"last_name"`
+	Active            bool      `json:"active"`
+	AuthSource        string    `json:"auth_source"` // Backend that vouches for the user: local, ldap, oidc or scim
+	ExternalID        string    `json:"-"`           // The backend's stable identifier for the user; empty for local users
+	PasswordChangedAt time.Time `json:"password_changed_at"`
+	CreatedAt         time.Time `json:"created_at"`
+	UpdatedAt         time.Time `json:"updated_at"`
 }
 
+// Authentication sources
+const (
+	AuthSourceLocal = "local"
+	AuthSourceLDAP  = "ldap"
+	AuthSourceOIDC  = "oidc"
+	AuthSourceSCIM  = "scim"
+)
+
 // Role represents a role that can be assigned to users
 type Role struct {
 	ID          int       `json:"id"`
@@ -35,39 +49,367 @@ type UserRole struct {
 
 // Credential represents a stored privileged credential
 type Credential struct {
+	ID          int        `json:"id"`
+	Name        string     `json:"name"`
+	Description string     `json:"description"`
+	Type        string     `json:"type"` // e.g., "password", "ssh_key", "api_key"
+	Username    string     `json:"username"`
+	Secret      string     `json:"-"` // Encrypted secret, never exposed directly
+	System      string     `json:"system"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"` // Nil when the credential does not expire
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+	CreatedBy   int        `json:"created_by"` // User ID who created this credential
+}
+
+// CredentialUsage is a credential with the history its health is judged by
+type CredentialUsage struct {
+	Credential      *Credential
+	SecretChangedAt time.Time  // When the secret was last set
+	LastAccessedAt  *time.Time // Nil if it was never accessed
+}
+
+// CredentialAccess represents a record of credential access
+type CredentialAccess struct {
+	ID               int       `json:"id"`
+	UserID           int       `json:"user_id,omitempty"`
+	ServiceAccountID int       `json:"service_account_id,omitempty"` // Set instead of UserID for API key access
+	CredentialID     int       `json:"credential_id"`
+	AccessedAt       time.Time `json:"accessed_at"`
+	IPAddress        string    `json:"ip_address"`
+	UserAgent        string    `json:"user_agent"`
+	Reason           string    `json:"reason"`
+}
+
+// AuditLog represents a system audit log entry.
+// UserID or ServiceAccountID identify the actor, with the session or API key
+// they acted through; Resource and ResourceID identify the target.
+type AuditLog struct {
+	ID               int          `json:"id"`
+	UserID           int          `json:"user_id,omitempty"`
+	ServiceAccountID int          `json:"service_account_id,omitempty"`
+	SessionID        int          `json:"session_id,omitempty"`
+	APIKeyID         int          `json:"api_key_id,omitempty"`
+	Action           string       `json:"action"`
+	Resource         string       `json:"resource"`
+	ResourceID       int          `json:"resource_id"`
+	Timestamp        time.Time    `json:"timestamp"`
+	IPAddress        string       `json:"ip_address"`
+	UserAgent        string       `json:"user_agent"`
+	Details          string       `json:"details"`
+	Changes          AuditChanges `json:"changes,omitempty"`
+	PrevHash         string       `json:"prev_hash,omitempty"`
+	Hash             string       `json:"hash,omitempty"`
+}
+
+// FieldChange is the value of one field before and after a change
+type FieldChange struct {
+	Old interface{} `json:"old"`
+	New interface{} `json:"new"`
+}
+
+// AuditChanges maps changed field names to their old and new values
+type AuditChanges map[string]FieldChange
+
+// Value implements driver.Valuer, storing the changes as JSON
+func (c AuditChanges) Value() (driver.Value, error) {
+	if len(c) == 0 {
+		return nil, nil
+	}
+	return json.Marshal(c)
+}
+
+// Scan implements sql.Scanner
+func (c *AuditChanges) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		*c = nil
+		return nil
+	case []byte:
+		return json.Unmarshal(v, c)
+	case string:
+		return json.Unmarshal([]byte(v), c)
+	}
+	return fmt.Errorf("cannot scan %T into AuditChanges", src)
+}
+
+// Session represents an authenticated API session backed by a bearer token
+type Session struct {
+	ID            int        `json:"id"`
+	UserID        int        `json:"user_id"`
+	TokenHash     string     `json:"-"` // SHA-256 of the bearer token, never exposed
+	CreatedAt     time.Time  `json:"created_at"`
+	ExpiresAt     time.Time  `json:"expires_at"`
+	MFAVerifiedAt *time.Time `json:"mfa_verified_at,omitempty"`
+	RevokedAt     *time.Time `json:"revoked_at,omitempty"`
+	IPAddress     string     `json:"ip_address"`
+	UserAgent     string     `json:"user_agent"`
+}
+
+// UserMFA represents a user's enrolled TOTP second factor
+type UserMFA struct {
+	UserID     int       `json:"user_id"`
+	TOTPSecret string    `json:"-"` // Base32 TOTP seed, never exposed after enrollment
+	Enabled    bool      `json:"enabled"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// OIDCLoginState represents an OpenID Connect login awaiting its callback
+type OIDCLoginState struct {
+	ID           int       `json:"id"`
+	StateHash    string    `json:"-"`
+	Nonce        string    `json:"-"`
+	CodeVerifier string    `json:"-"` // PKCE verifier, never leaves the server
+	CreatedAt    time.Time `json:"created_at"`
+	ExpiresAt    time.Time `json:"expires_at"`
+}
+
+// ServiceAccount represents a non-human principal used by automation
+type ServiceAccount struct {
 	ID          int       `json:"id"`
 	Name        string    `json:"name"`
 	Description string    `json:"description"`
-	Type        string    `json:"type"` // e.g., "password", "ssh_key", "api_key"
-	Username    string    `json:"username"`
-	Secret      string    `json:"-"` // Encrypted secret, never exposed directly
-	System      string    `json:"system"`
-	ExpiresAt   time.Time `json:"expires_at"`
+	Active      bool      `json:"active"`
+	CreatedBy   int       `json:"created_by"`
 	CreatedAt   time.Time `json:"created_at"`
 	UpdatedAt   time.Time `json:"updated_at"`
-	CreatedBy   int       `json:"created_by"` // User ID who created this credential
 }
 
-// CredentialAccess represents a record of credential access
-type CredentialAccess struct {
-	ID           int       `json:"id"`
-	UserID       int       `json:"user_id"`
-	CredentialID int       `json:"credential_id"`
-	AccessedAt   time.Time `json:"accessed_at"`
-	IPAddress    string    `json:"ip_address"`
-	UserAgent    string    `json:"user_agent"`
-	Reason       string    `json:"reason"`
+// APIKey represents a scoped credential belonging to a service account
+type APIKey struct {
+	ID               int        `json:"id"`
+	ServiceAccountID int        `json:"service_account_id"`
+	Prefix           string     `json:"prefix"`
+	SecretHash       string     `json:"-"` // SHA-256 of the secret part, never exposed
+	Scopes           []string   `json:"scopes"`
+	AllowedIPs       []string   `json:"allowed_ips"`
+	ExpiresAt        *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt       *time.Time `json:"last_used_at,omitempty"`
+	LastUsedIP       string     `json:"last_used_ip,omitempty"`
+	RevokedAt        *time.Time `json:"revoked_at,omitempty"`
+	RotatedFromID    *int       `json:"rotated_from_id,omitempty"`
+	CreatedAt        time.Time  `json:"created_at"`
 }
 
-// AuditLog represents a system audit log entry
-type AuditLog struct {
+// ServiceAccountCertificate binds a client certificate identity to a service account
+type ServiceAccountCertificate struct {
+	ID               int        `json:"id"`
+	ServiceAccountID int        `json:"service_account_id"`
+	Identity         string     `json:"identity"` // subject:<DN>, dns:<name>, uri:<uri> or email:<address>
+	Scopes           []string   `json:"scopes"`
+	LastUsedAt       *time.Time `json:"last_used_at,omitempty"`
+	CreatedAt        time.Time  `json:"created_at"`
+}
+
+// LoginAttempt tracks consecutive failed logins for an account or source IP
+type LoginAttempt struct {
+	Scope          string     `json:"scope"`   // "user" or "ip"
+	Subject        string     `json:"subject"` // lowercased username or IP address
+	Failures       int        `json:"failures"`
+	FirstFailureAt time.Time  `json:"first_failure_at"`
+	LastFailureAt  time.Time  `json:"last_failure_at"`
+	LockedUntil    *time.Time `json:"locked_until,omitempty"`
+}
+
+// PasswordResetToken is a single-use password reset grant; only its hash is stored
+type PasswordResetToken struct {
+	ID        int        `json:"id"`
+	UserID    int        `json:"user_id"`
+	TokenHash string     `json:"-"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at,omitempty"`
+	IPAddress string     `json:"ip_address"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// Webhook delivery states
+const (
+	WebhookPending   = "pending"
+	WebhookDelivered = "delivered"
+	WebhookFailed    = "failed" // gave up after the maximum number of attempts
+)
+
+// WebhookSubscription sends matching security events to an HTTP endpoint
+type WebhookSubscription struct {
 	ID         int       `json:"id"`
-	UserID     int       `json:"user_id"`
-	Action     string    `json:"action"`
-	Resource   string    `json:"resource"`
-	ResourceID int       `json:"resource_id"`
-	Timestamp  time.Time `json:"timestamp"`
-	IPAddress  string    `json:"ip_address"`
-	UserAgent  string    `json:"user_agent"`
-	Details    string    `json:"details"`
+	Name       string    `json:"name"`
+	URL        string    `json:"url"`
+	Secret     string    `json:"-"` // HMAC key, returned only when the subscription is created
+	EventTypes []string  `json:"event_types"`
+	Active     bool      `json:"active"`
+	CreatedBy  int       `json:"created_by"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// WebhookDelivery is one event queued for, or delivered to, a subscription
+type WebhookDelivery struct {
+	ID             int             `json:"id"`
+	SubscriptionID int             `json:"subscription_id"`
+	EventType      string          `json:"event_type"`
+	Payload        json.RawMessage `json:"payload"`
+	Status         string          `json:"status"`
+	Attempts       int             `json:"attempts"`
+	NextAttemptAt  time.Time       `json:"next_attempt_at"`
+	LastAttemptAt  *time.Time      `json:"last_attempt_at,omitempty"`
+	ResponseStatus int             `json:"response_status,omitempty"`
+	LastError      string          `json:"last_error,omitempty"`
+	ReplayOf       int             `json:"replay_of,omitempty"`
+	CreatedAt      time.Time       `json:"created_at"`
+	DeliveredAt    *time.Time      `json:"delivered_at,omitempty"`
+
+	// Subscription is filled in for deliveries claimed by the dispatcher
+	Subscription *WebhookSubscription `json:"-"`
+}
+
+// AuditArchive records a month of audit entries that was exported to an
+// archive file and dropped from the database
+type AuditArchive struct {
+	ID         int        `json:"id"`
+	Month      time.Time  `json:"month"`
+	FileName   string     `json:"file_name"`
+	SHA256     string     `json:"sha256"`
+	FirstID    int        `json:"first_id"`
+	LastID     int        `json:"last_id"`
+	Count      int        `json:"count"`
+	LastHash   string     `json:"last_hash"`
+	ArchivedAt time.Time  `json:"archived_at"`
+	RestoredAt *time.Time `json:"restored_at,omitempty"` // set while the month is re-imported for investigation
+}
+
+// SSHSession records a connection relayed by the SSH proxy and where its
+// encrypted recording is stored
+type SSHSession struct {
+	ID              int        `json:"id"`
+	UserID          int        `json:"user_id"`
+	CredentialID    int        `json:"credential_id"`
+	Target          string     `json:"target"` // host:port of the target system
+	TargetUsername  string     `json:"target_username"`
+	IPAddress       string     `json:"ip_address"`
+	ClientVersion   string     `json:"client_version"`
+	StartedAt       time.Time  `json:"started_at"`
+	EndedAt         *time.Time `json:"ended_at,omitempty"`
+	RecordingKey    string     `json:"-"` // Object store key, empty when the session was not recorded
+	RecordingSHA256 string     `json:"recording_sha256,omitempty"`
+	RecordingSize   int64      `json:"recording_size,omitempty"`
+	InputRecorded   bool       `json:"input_recorded"`
+	Truncated       bool       `json:"truncated"`
+	OutputText      string     `json:"-"`
+	Snippet         string     `json:"snippet,omitempty"` // Matching output, set by text searches
+	Active          bool       `json:"active"`            // Still relayed by the proxy, set by the server
+}
+
+// Command rule actions
+const (
+	CommandAllow  = "allow"  // Only commands matching an allow rule may run
+	CommandDeny   = "deny"   // Matching commands are blocked
+	CommandNotify = "notify" // Matching commands run but are flagged
+)
+
+// CommandRule is a regular expression checked against the commands users
+// run through the SSH proxy, scoped to a role and/or a target credential
+type CommandRule struct {
+	ID           int       `json:"id"`
+	Name         string    `json:"name"`
+	Description  string    `json:"description"`
+	Pattern      string    `json:"pattern"`
+	Action       string    `json:"action"`
+	RoleID       int       `json:"role_id,omitempty"`       // 0 applies to every user
+	CredentialID int       `json:"credential_id,omitempty"` // 0 applies to every target
+	Active       bool      `json:"active"`
+	CreatedBy    int       `json:"created_by"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// DBSession records a connection relayed by the Postgres proxy
+type DBSession struct {
+	ID              int        `json:"id"`
+	UserID          int        `json:"user_id"`
+	CredentialID    int        `json:"credential_id"`
+	Target          string     `json:"target"` // host:port of the target database server
+	Database        string     `json:"database"`
+	TargetUsername  string     `json:"target_username"`
+	IPAddress       string     `json:"ip_address"`
+	ApplicationName string     `json:"application_name"`
+	StartedAt       time.Time  `json:"started_at"`
+	EndedAt         *time.Time `json:"ended_at,omitempty"`
+	QueryCount      int        `json:"query_count"`
+}
+
+// DBQuery is a query sent over a Postgres proxy session
+type DBQuery struct {
+	ID         int64     `json:"id"`
+	SessionID  int       `json:"session_id"`
+	Query      string    `json:"query"`
+	ExecutedAt time.Time `json:"executed_at"`
+}
+
+// WebSession records a browser session relayed to a web console by the
+// HTTP proxy
+type WebSession struct {
+	ID           int        `json:"id"`
+	UserID       int        `json:"user_id"`
+	CredentialID int        `json:"credential_id"`
+	Target       string     `json:"target"` // Base URL of the console
+	IPAddress    string     `json:"ip_address"`
+	UserAgent    string     `json:"user_agent"`
+	LaunchHash   string     `json:"-"`
+	TokenHash    string     `json:"-"`
+	StartedAt    time.Time  `json:"started_at"`
+	ExpiresAt    time.Time  `json:"expires_at"`
+	LastSeenAt   *time.Time `json:"last_seen_at,omitempty"`
+	EndedAt      *time.Time `json:"ended_at,omitempty"`
+	EndedBy      int        `json:"ended_by,omitempty"`
+	RequestCount int        `json:"request_count"`
+}
+
+// WebRequest is a request relayed over a web session
+type WebRequest struct {
+	ID          int64     `json:"id"`
+	SessionID   int       `json:"session_id"`
+	Method      string    `json:"method"`
+	Path        string    `json:"path"`
+	Status      int       `json:"status"`
+	Bytes       int64     `json:"bytes"`
+	DurationMS  int       `json:"duration_ms"`
+	RequestedAt time.Time `json:"requested_at"`
+}
+
+// Notification delivery states
+const (
+	NotificationPending = "pending"
+	NotificationSent    = "sent"
+	NotificationFailed  = "failed" // gave up after the maximum number of attempts
+)
+
+// Notification is a rendered message queued in the outbox for one channel
+type Notification struct {
+	ID            int        `json:"id"`
+	UserID        int        `json:"user_id"`
+	Event         string     `json:"event"`
+	Channel       string     `json:"channel"`
+	Subject       string     `json:"subject"`
+	Body          string     `json:"body"`
+	Status        string     `json:"status"`
+	Attempts      int        `json:"attempts"`
+	NextAttemptAt time.Time  `json:"next_attempt_at"`
+	LastAttemptAt *time.Time `json:"last_attempt_at,omitempty"`
+	LastError     string     `json:"last_error,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+	SentAt        *time.Time `json:"sent_at,omitempty"`
+
+	// Recipient is filled in for notifications claimed for delivery
+	Recipient *User `json:"-"`
+}
+
+// NotificationPreference lists the channels a user is notified through
+// for an event, or for every event without a preference of its own when
+// Event is "*"
+type NotificationPreference struct {
+	UserID    int       `json:"user_id"`
+	Event     string    `json:"event"`
+	Channels  []string  `json:"channels"`
+	UpdatedAt time.Time `json:"updated_at"`
 }
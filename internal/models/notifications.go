@@ -0,0 +1,320 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/theshovonaha/mini-pam/internal/database"
+)
+
+// NotificationRepository handles database operations related to the
+// notification outbox and users' notification preferences
+type NotificationRepository struct {
+	DB *database.Connection
+}
+
+// NewNotificationRepository creates a new notification repository
+func NewNotificationRepository(db *database.Connection) *NotificationRepository {
+	return &NotificationRepository{
+		DB: db,
+	}
+}
+
+// notificationColumns is the column list scanned by scanNotification
+const notificationColumns = `n.id, n.user_id, n.event, n.channel, n.subject, n.body, n.status, n.attempts,
+		       n.next_attempt_at, n.last_attempt_at, COALESCE(n.last_error, ''), n.created_at, n.sent_at`
+
+// scanNotification scans a row selected with notificationColumns, plus
+// any extra destinations appended by the caller
+func scanNotification(row interface{ Scan(...interface{}) error }, extra ...interface{}) (*Notification, error) {
+	var notification Notification
+	dest := []interface{}{
+		&notification.ID,
+		&notification.UserID,
+		&notification.Event,
+		&notification.Channel,
+		&notification.Subject,
+		&notification.Body,
+		&notification.Status,
+		&notification.Attempts,
+		&notification.NextAttemptAt,
+		&notification.LastAttemptAt,
+		&notification.LastError,
+		&notification.CreatedAt,
+		&notification.SentAt,
+	}
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		return nil, err
+	}
+	return &notification, nil
+}
+
+// Enqueue adds notifications to the outbox, all or none of them
+func (r *NotificationRepository) Enqueue(notifications []*Notification) error {
+	query := `
+		INSERT INTO notification_outbox (user_id, event, channel, subject, body)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, status, next_attempt_at, created_at`
+
+	// Set a timeout for the transaction
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := r.DB.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, n := range notifications {
+		err := tx.QueryRowContext(ctx, query, n.UserID, n.Event, n.Channel, n.Subject, n.Body).
+			Scan(&n.ID, &n.Status, &n.NextAttemptAt, &n.CreatedAt)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// ClaimDue leases up to limit pending notifications that are due, with
+// their recipients, so other servers skip them while they are sent
+func (r *NotificationRepository) ClaimDue(limit int, lease time.Duration) ([]*Notification, error) {
+	query := `
+		WITH due AS (
+			SELECT id
+			FROM notification_outbox
+			WHERE status = 'pending' AND next_attempt_at <= NOW()
+			ORDER BY next_attempt_at, id
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)
+		UPDATE notification_outbox n
+		SET next_attempt_at = NOW() + $2 * INTERVAL '1 second'
+		FROM due, users u
+		WHERE n.id = due.id AND u.id = n.user_id
+		RETURNING ` + notificationColumns + `, u.username, u.email, u.first_name, u.last_name, u.active`
+
+	// Set a timeout for the query
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// Execute the query
+	rows, err := r.DB.DB.QueryContext(ctx, query, limit, lease.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	// Iterate over the rows
+	notifications := []*Notification{}
+	for rows.Next() {
+		user := &User{}
+		notification, err := scanNotification(rows, &user.Username, &user.Email, &user.FirstName, &user.LastName, &user.Active)
+		if err != nil {
+			return nil, err
+		}
+		user.ID = notification.UserID
+		notification.Recipient = user
+		notifications = append(notifications, notification)
+	}
+
+	// Check for errors after iteration
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return notifications, nil
+}
+
+// RecordAttempt stores the outcome of a delivery attempt. A pending status
+// schedules the next attempt at nextAttemptAt.
+func (r *NotificationRepository) RecordAttempt(notification *Notification, nextAttemptAt time.Time) error {
+	query := `
+		UPDATE notification_outbox
+		SET status = $1, attempts = $2, next_attempt_at = $3, last_attempt_at = NOW(), last_error = $4,
+		    sent_at = CASE WHEN $1 = 'sent' THEN NOW() ELSE sent_at END
+		WHERE id = $5
+		RETURNING last_attempt_at, sent_at`
+
+	// Set a timeout for the query
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// Execute the query
+	return r.DB.DB.QueryRowContext(
+		ctx,
+		query,
+		notification.Status,
+		notification.Attempts,
+		nextAttemptAt,
+		nullString(notification.LastError),
+		notification.ID,
+	).Scan(&notification.LastAttemptAt, &notification.SentAt)
+}
+
+// NotificationFilter narrows an outbox listing. Zero fields match everything.
+type NotificationFilter struct {
+	UserID int
+	Status string
+}
+
+// List returns a page of notifications matching filter, newest first
+func (r *NotificationRepository) List(filter NotificationFilter, page, pageSize int) ([]*Notification, error) {
+	// Ensure valid pagination parameters
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 {
+		pageSize = 20
+	}
+
+	conditions := []string{}
+	args := []interface{}{}
+	where := func(condition string, arg interface{}) {
+		args = append(args, arg)
+		conditions = append(conditions, strings.ReplaceAll(condition, "?", fmt.Sprintf("$%d", len(args))))
+	}
+
+	if filter.UserID != 0 {
+		where("n.user_id = ?", filter.UserID)
+	}
+	if filter.Status != "" {
+		where("n.status = ?", filter.Status)
+	}
+
+	query := `
+		SELECT ` + notificationColumns + `
+		FROM notification_outbox n`
+	if len(conditions) > 0 {
+		query += `
+		WHERE ` + strings.Join(conditions, " AND ")
+	}
+	args = append(args, pageSize, (page-1)*pageSize)
+	query += fmt.Sprintf(`
+		ORDER BY n.id DESC
+		LIMIT $%d OFFSET $%d`, len(args)-1, len(args))
+
+	// Set a timeout for the query
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// Execute the query
+	rows, err := r.DB.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	// Iterate over the rows
+	notifications := []*Notification{}
+	for rows.Next() {
+		notification, err := scanNotification(rows)
+		if err != nil {
+			return nil, err
+		}
+		notifications = append(notifications, notification)
+	}
+
+	// Check for errors after iteration
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return notifications, nil
+}
+
+// GetPreferences returns a user's notification preferences
+func (r *NotificationRepository) GetPreferences(userID int) ([]*NotificationPreference, error) {
+	query := `
+		SELECT user_id, event, channels, updated_at
+		FROM notification_preferences
+		WHERE user_id = $1
+		ORDER BY event`
+
+	// Set a timeout for the query
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// Execute the query
+	rows, err := r.DB.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	// Iterate over the rows
+	preferences := []*NotificationPreference{}
+	for rows.Next() {
+		var preference NotificationPreference
+		err := rows.Scan(
+			&preference.UserID,
+			&preference.Event,
+			pq.Array(&preference.Channels),
+			&preference.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		preferences = append(preferences, &preference)
+	}
+
+	// Check for errors after iteration
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return preferences, nil
+}
+
+// SetPreference creates or replaces a user's preference for an event
+func (r *NotificationRepository) SetPreference(preference *NotificationPreference) error {
+	query := `
+		INSERT INTO notification_preferences (user_id, event, channels)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (user_id, event) DO UPDATE SET channels = EXCLUDED.channels, updated_at = NOW()
+		RETURNING updated_at`
+
+	// Set a timeout for the query
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// Execute the query
+	return r.DB.DB.QueryRowContext(
+		ctx,
+		query,
+		preference.UserID,
+		preference.Event,
+		pq.Array(preference.Channels),
+	).Scan(&preference.UpdatedAt)
+}
+
+// DeletePreference removes a user's preference for an event
+func (r *NotificationRepository) DeletePreference(userID int, event string) error {
+	query := `
+		DELETE FROM notification_preferences
+		WHERE user_id = $1 AND event = $2`
+
+	// Set a timeout for the query
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// Execute the query
+	result, err := r.DB.DB.ExecContext(ctx, query, userID, event)
+	if err != nil {
+		return err
+	}
+
+	// Check if any rows were affected
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
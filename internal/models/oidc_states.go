@@ -0,0 +1,94 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/theshovonaha/mini-pam/internal/database"
+)
+
+// OIDCStateRepository handles database operations related to pending OIDC logins
+type OIDCStateRepository struct {
+	DB *database.Connection
+}
+
+// NewOIDCStateRepository creates a new OIDC state repository
+func NewOIDCStateRepository(db *database.Connection) *OIDCStateRepository {
+	return &OIDCStateRepository{
+		DB: db,
+	}
+}
+
+// Create inserts a new pending login
+func (r *OIDCStateRepository) Create(state *OIDCLoginState) error {
+	query := `
+		INSERT INTO oidc_login_states (state_hash, nonce, code_verifier, expires_at)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at`
+
+	// Set a timeout for the query
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// Execute the query
+	err := r.DB.DB.QueryRowContext(
+		ctx,
+		query,
+		state.StateHash,
+		state.Nonce,
+		state.CodeVerifier,
+		state.ExpiresAt,
+	).Scan(&state.ID, &state.CreatedAt)
+
+	return err
+}
+
+// Consume deletes and returns an unexpired pending login, so each state is usable once
+func (r *OIDCStateRepository) Consume(stateHash string) (*OIDCLoginState, error) {
+	query := `
+		DELETE FROM oidc_login_states
+		WHERE state_hash = $1 AND expires_at > NOW()
+		RETURNING id, state_hash, nonce, code_verifier, created_at, expires_at`
+
+	var state OIDCLoginState
+
+	// Set a timeout for the query
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// Execute the query
+	err := r.DB.DB.QueryRowContext(ctx, query, stateHash).Scan(
+		&state.ID,
+		&state.StateHash,
+		&state.Nonce,
+		&state.CodeVerifier,
+		&state.CreatedAt,
+		&state.ExpiresAt,
+	)
+
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, err
+	}
+
+	return &state, nil
+}
+
+// DeleteExpired removes pending logins that were never completed
+func (r *OIDCStateRepository) DeleteExpired() error {
+	query := `
+		DELETE FROM oidc_login_states
+		WHERE expires_at <= NOW()`
+
+	// Set a timeout for the query
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// Execute the query
+	_, err := r.DB.DB.ExecContext(ctx, query)
+	return err
+}
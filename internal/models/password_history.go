@@ -0,0 +1,92 @@
+package models
+
+import (
+	"context"
+	"time"
+
+	"github.com/theshovonaha/mini-pam/internal/database"
+)
+
+// PasswordHistoryRepository handles database operations related to previous password hashes
+type PasswordHistoryRepository struct {
+	DB *database.Connection
+}
+
+// NewPasswordHistoryRepository creates a new password history repository
+func NewPasswordHistoryRepository(db *database.Connection) *PasswordHistoryRepository {
+	return &PasswordHistoryRepository{
+		DB: db,
+	}
+}
+
+// Add records a password hash for a user and discards all but the newest keep entries
+func (r *PasswordHistoryRepository) Add(userID int, hashedPassword string, keep int) error {
+	// Set a timeout for the transaction
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := r.DB.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO password_history (user_id, hashed_password)
+		VALUES ($1, $2)`, userID, hashedPassword)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		DELETE FROM password_history
+		WHERE user_id = $1 AND id NOT IN (
+			SELECT id FROM password_history
+			WHERE user_id = $1
+			ORDER BY created_at DESC, id DESC
+			LIMIT $2
+		)`, userID, keep)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Recent returns a user's newest password hashes, newest first
+func (r *PasswordHistoryRepository) Recent(userID, limit int) ([]string, error) {
+	query := `
+		SELECT hashed_password
+		FROM password_history
+		WHERE user_id = $1
+		ORDER BY created_at DESC, id DESC
+		LIMIT $2`
+
+	// Set a timeout for the query
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// Execute the query
+	rows, err := r.DB.DB.QueryContext(ctx, query, userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	// Iterate over the rows
+	hashes := []string{}
+	for rows.Next() {
+		var hash string
+		if err := rows.Scan(&hash); err != nil {
+			return nil, err
+		}
+		hashes = append(hashes, hash)
+	}
+
+	// Check for errors after iteration
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return hashes, nil
+}
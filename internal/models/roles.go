@@ -40,6 +40,58 @@ func (r *RoleRepository) Create(role *Role) error {
 	return err
 }
 
+// CreateWithMembers inserts a new role and its members in one transaction,
+// so a bad member leaves no role behind. It returns ErrUnknownMember if a
+// user ID does not exist.
+func (r *RoleRepository) CreateWithMembers(role *Role, userIDs []int) error {
+	// Set a timeout for the transaction
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := r.DB.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO roles (name, description)
+		VALUES ($1, $2)
+		RETURNING id, created_at, updated_at`, role.Name, role.Description).Scan(
+		&role.ID, &role.CreatedAt, &role.UpdatedAt,
+	)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return ErrDuplicateKey
+		}
+		return err
+	}
+
+	if err := insertRoleMembers(ctx, tx, role.ID, userIDs); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// insertRoleMembers adds users to a role within a transaction, returning
+// ErrUnknownMember if a user ID does not exist
+func insertRoleMembers(ctx context.Context, tx *sql.Tx, roleID int, userIDs []int) error {
+	for _, userID := range userIDs {
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO user_roles (user_id, role_id)
+			VALUES ($1, $2)
+			ON CONFLICT (user_id, role_id) DO NOTHING`, userID, roleID)
+		if err != nil {
+			if isForeignKeyViolation(err) {
+				return ErrUnknownMember
+			}
+			return err
+		}
+	}
+	return nil
+}
+
 // GetByID retrieves a role by its ID
 func (r *RoleRepository) GetByID(id int) (*Role, error) {
 	query := `
@@ -283,3 +335,95 @@ func (r *RoleRepository) GetUserRoles(userID int) ([]*Role, error) {
 
 	return roles, nil
 }
+
+// GetRoleMembers returns all users assigned to a role
+func (r *RoleRepository) GetRoleMembers(roleID int) ([]*User, error) {
+	query := `
+		SELECT u.id, u.username, u.email, u.hashed_password, u.first_name, u.last_name, u.active, u.auth_source, COALESCE(u.external_id, ''), u.password_changed_at, u.created_at, u.updated_at
+		FROM users u
+		JOIN user_roles ur ON ur.user_id = u.id
+		WHERE ur.role_id = $1
+		ORDER BY u.username`
+
+	// Set a timeout for the query
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// Execute the query
+	rows, err := r.DB.DB.QueryContext(ctx, query, roleID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	// Iterate over the rows
+	users := []*User{}
+	for rows.Next() {
+		var user User
+		err := rows.Scan(
+			&user.ID,
+			&user.Username,
+			&user.Email,
+			&user.HashedPassword,
+			&user.FirstName,
+			&user.LastName,
+			&user.Active,
+			&user.AuthSource,
+			&user.ExternalID,
+			&user.PasswordChangedAt,
+			&user.CreatedAt,
+			&user.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, &user)
+	}
+
+	// Check for errors after iteration
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return users, nil
+}
+
+// UpdateWithMembers updates a role and replaces its full membership in one
+// transaction, so a bad member leaves the role as it was. It returns
+// ErrUnknownMember if a user ID does not exist, ErrDuplicateKey if another
+// role has the name and ErrRecordNotFound if the role is gone.
+func (r *RoleRepository) UpdateWithMembers(role *Role, userIDs []int) error {
+	// Set a timeout for the transaction
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := r.DB.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, `
+		UPDATE roles
+		SET name = $1, description = $2, updated_at = NOW()
+		WHERE id = $3
+		RETURNING updated_at`, role.Name, role.Description, role.ID).Scan(&role.UpdatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrRecordNotFound
+		}
+		if isUniqueViolation(err) {
+			return ErrDuplicateKey
+		}
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM user_roles WHERE role_id = $1`, role.ID); err != nil {
+		return err
+	}
+	if err := insertRoleMembers(ctx, tx, role.ID, userIDs); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
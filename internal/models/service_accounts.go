@@ -0,0 +1,492 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/theshovonaha/mini-pam/internal/database"
+)
+
+// ServiceAccountRepository handles database operations related to service accounts and their API keys
+type ServiceAccountRepository struct {
+	DB *database.Connection
+}
+
+// NewServiceAccountRepository creates a new service account repository
+func NewServiceAccountRepository(db *database.Connection) *ServiceAccountRepository {
+	return &ServiceAccountRepository{
+		DB: db,
+	}
+}
+
+// Create inserts a new service account into the database
+func (r *ServiceAccountRepository) Create(account *ServiceAccount) error {
+	query := `
+		INSERT INTO service_accounts (name, description, active, created_by)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at, updated_at`
+
+	// Set a timeout for the query
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// Execute the query
+	err := r.DB.DB.QueryRowContext(
+		ctx,
+		query,
+		account.Name,
+		account.Description,
+		account.Active,
+		nullInt(account.CreatedBy),
+	).Scan(&account.ID, &account.CreatedAt, &account.UpdatedAt)
+	if isUniqueViolation(err) {
+		return ErrDuplicateKey
+	}
+
+	return err
+}
+
+// GetByID retrieves a service account by its ID
+func (r *ServiceAccountRepository) GetByID(id int) (*ServiceAccount, error) {
+	query := `
+		SELECT id, name, COALESCE(description, ''), active, COALESCE(created_by, 0), created_at, updated_at
+		FROM service_accounts
+		WHERE id = $1`
+
+	var account ServiceAccount
+
+	// Set a timeout for the query
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// Execute the query
+	err := r.DB.DB.QueryRowContext(ctx, query, id).Scan(
+		&account.ID,
+		&account.Name,
+		&account.Description,
+		&account.Active,
+		&account.CreatedBy,
+		&account.CreatedAt,
+		&account.UpdatedAt,
+	)
+
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, err
+	}
+
+	return &account, nil
+}
+
+// List returns all service accounts
+func (r *ServiceAccountRepository) List() ([]*ServiceAccount, error) {
+	query := `
+		SELECT id, name, COALESCE(description, ''), active, COALESCE(created_by, 0), created_at, updated_at
+		FROM service_accounts
+		ORDER BY name`
+
+	// Set a timeout for the query
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// Execute the query
+	rows, err := r.DB.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	// Iterate over the rows
+	accounts := []*ServiceAccount{}
+	for rows.Next() {
+		var account ServiceAccount
+		err := rows.Scan(
+			&account.ID,
+			&account.Name,
+			&account.Description,
+			&account.Active,
+			&account.CreatedBy,
+			&account.CreatedAt,
+			&account.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		accounts = append(accounts, &account)
+	}
+
+	// Check for errors after iteration
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return accounts, nil
+}
+
+// Deactivate disables a service account and revokes all of its keys
+func (r *ServiceAccountRepository) Deactivate(id int) error {
+	// Set a timeout for the transaction
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := r.DB.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `
+		UPDATE service_accounts
+		SET active = FALSE, updated_at = NOW()
+		WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	// Check if any rows were affected
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE api_keys
+		SET revoked_at = NOW()
+		WHERE service_account_id = $1 AND revoked_at IS NULL`, id)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// apiKeyColumns is the column list scanned by scanAPIKey
+const apiKeyColumns = `id, service_account_id, prefix, secret_hash, scopes, allowed_ips, expires_at,
+		       last_used_at, COALESCE(last_used_ip, ''), revoked_at, rotated_from_id, created_at`
+
+// scanAPIKey scans a row selected with apiKeyColumns
+func scanAPIKey(row interface{ Scan(...interface{}) error }) (*APIKey, error) {
+	var key APIKey
+	err := row.Scan(
+		&key.ID,
+		&key.ServiceAccountID,
+		&key.Prefix,
+		&key.SecretHash,
+		pq.Array(&key.Scopes),
+		pq.Array(&key.AllowedIPs),
+		&key.ExpiresAt,
+		&key.LastUsedAt,
+		&key.LastUsedIP,
+		&key.RevokedAt,
+		&key.RotatedFromID,
+		&key.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+// CreateKey inserts a new API key
+func (r *ServiceAccountRepository) CreateKey(key *APIKey) error {
+	query := `
+		INSERT INTO api_keys (service_account_id, prefix, secret_hash, scopes, allowed_ips, expires_at, rotated_from_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id, created_at`
+
+	// Set a timeout for the query
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// Execute the query
+	err := r.DB.DB.QueryRowContext(
+		ctx,
+		query,
+		key.ServiceAccountID,
+		key.Prefix,
+		key.SecretHash,
+		pq.Array(key.Scopes),
+		pq.Array(key.AllowedIPs),
+		key.ExpiresAt,
+		key.RotatedFromID,
+	).Scan(&key.ID, &key.CreatedAt)
+
+	return err
+}
+
+// GetKeyByPrefix retrieves an API key by its public prefix
+func (r *ServiceAccountRepository) GetKeyByPrefix(prefix string) (*APIKey, error) {
+	query := `
+		SELECT ` + apiKeyColumns + `
+		FROM api_keys
+		WHERE prefix = $1`
+
+	// Set a timeout for the query
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// Execute the query
+	key, err := scanAPIKey(r.DB.DB.QueryRowContext(ctx, query, prefix))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, err
+	}
+
+	return key, nil
+}
+
+// GetKey retrieves an API key belonging to a service account
+func (r *ServiceAccountRepository) GetKey(accountID, keyID int) (*APIKey, error) {
+	query := `
+		SELECT ` + apiKeyColumns + `
+		FROM api_keys
+		WHERE service_account_id = $1 AND id = $2`
+
+	// Set a timeout for the query
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// Execute the query
+	key, err := scanAPIKey(r.DB.DB.QueryRowContext(ctx, query, accountID, keyID))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, err
+	}
+
+	return key, nil
+}
+
+// ListKeys returns all keys of a service account, newest first
+func (r *ServiceAccountRepository) ListKeys(accountID int) ([]*APIKey, error) {
+	query := `
+		SELECT ` + apiKeyColumns + `
+		FROM api_keys
+		WHERE service_account_id = $1
+		ORDER BY created_at DESC`
+
+	// Set a timeout for the query
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// Execute the query
+	rows, err := r.DB.DB.QueryContext(ctx, query, accountID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	// Iterate over the rows
+	keys := []*APIKey{}
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	// Check for errors after iteration
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+// RevokeKey revokes a single API key
+func (r *ServiceAccountRepository) RevokeKey(keyID int) error {
+	query := `
+		UPDATE api_keys
+		SET revoked_at = NOW()
+		WHERE id = $1 AND revoked_at IS NULL`
+
+	// Set a timeout for the query
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// Execute the query
+	_, err := r.DB.DB.ExecContext(ctx, query, keyID)
+	return err
+}
+
+// TouchKey records that a key was just used from ip
+func (r *ServiceAccountRepository) TouchKey(keyID int, ip string) error {
+	query := `
+		UPDATE api_keys
+		SET last_used_at = NOW(), last_used_ip = $1
+		WHERE id = $2`
+
+	// Set a timeout for the query
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// Execute the query
+	_, err := r.DB.DB.ExecContext(ctx, query, ip, keyID)
+	return err
+}
+
+// certificateColumns is the column list scanned by scanCertificate
+const certificateColumns = `id, service_account_id, identity, scopes, last_used_at, created_at`
+
+// scanCertificate scans a row selected with certificateColumns
+func scanCertificate(row interface{ Scan(...interface{}) error }) (*ServiceAccountCertificate, error) {
+	var cert ServiceAccountCertificate
+	err := row.Scan(
+		&cert.ID,
+		&cert.ServiceAccountID,
+		&cert.Identity,
+		pq.Array(&cert.Scopes),
+		&cert.LastUsedAt,
+		&cert.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &cert, nil
+}
+
+// CreateCertificate binds a client certificate identity to a service account
+func (r *ServiceAccountRepository) CreateCertificate(cert *ServiceAccountCertificate) error {
+	query := `
+		INSERT INTO service_account_certificates (service_account_id, identity, scopes)
+		VALUES ($1, $2, $3)
+		RETURNING id, created_at`
+
+	// Set a timeout for the query
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// Execute the query
+	err := r.DB.DB.QueryRowContext(
+		ctx,
+		query,
+		cert.ServiceAccountID,
+		cert.Identity,
+		pq.Array(cert.Scopes),
+	).Scan(&cert.ID, &cert.CreatedAt)
+	if isUniqueViolation(err) {
+		return ErrDuplicateKey
+	}
+
+	return err
+}
+
+// GetCertificateByIdentities retrieves the binding matching any of the
+// identities asserted by a client certificate. Subject bindings take
+// precedence over SAN bindings.
+func (r *ServiceAccountRepository) GetCertificateByIdentities(identities []string) (*ServiceAccountCertificate, error) {
+	query := `
+		SELECT ` + certificateColumns + `
+		FROM service_account_certificates
+		WHERE identity = ANY($1)
+		ORDER BY identity LIKE 'subject:%' DESC, id
+		LIMIT 1`
+
+	// Set a timeout for the query
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// Execute the query
+	cert, err := scanCertificate(r.DB.DB.QueryRowContext(ctx, query, pq.Array(identities)))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, err
+	}
+
+	return cert, nil
+}
+
+// ListCertificates returns all certificate bindings of a service account
+func (r *ServiceAccountRepository) ListCertificates(accountID int) ([]*ServiceAccountCertificate, error) {
+	query := `
+		SELECT ` + certificateColumns + `
+		FROM service_account_certificates
+		WHERE service_account_id = $1
+		ORDER BY identity`
+
+	// Set a timeout for the query
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// Execute the query
+	rows, err := r.DB.DB.QueryContext(ctx, query, accountID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	// Iterate over the rows
+	certs := []*ServiceAccountCertificate{}
+	for rows.Next() {
+		cert, err := scanCertificate(rows)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+
+	// Check for errors after iteration
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return certs, nil
+}
+
+// DeleteCertificate removes a certificate binding from a service account
+func (r *ServiceAccountRepository) DeleteCertificate(accountID, certID int) error {
+	query := `
+		DELETE FROM service_account_certificates
+		WHERE service_account_id = $1 AND id = $2`
+
+	// Set a timeout for the query
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// Execute the query
+	result, err := r.DB.DB.ExecContext(ctx, query, accountID, certID)
+	if err != nil {
+		return err
+	}
+
+	// Check if any rows were affected
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// TouchCertificate records that a certificate binding was just used
+func (r *ServiceAccountRepository) TouchCertificate(certID int) error {
+	query := `
+		UPDATE service_account_certificates
+		SET last_used_at = NOW()
+		WHERE id = $1`
+
+	// Set a timeout for the query
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// Execute the query
+	_, err := r.DB.DB.ExecContext(ctx, query, certID)
+	return err
+}
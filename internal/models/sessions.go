@@ -0,0 +1,139 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/theshovonaha/mini-pam/internal/database"
+)
+
+// SessionRepository handles database operations related to sessions
+type SessionRepository struct {
+	DB *database.Connection
+}
+
+// NewSessionRepository creates a new session repository
+func NewSessionRepository(db *database.Connection) *SessionRepository {
+	return &SessionRepository{
+		DB: db,
+	}
+}
+
+// Create inserts a new session into the database
+func (r *SessionRepository) Create(session *Session) error {
+	query := `
+		INSERT INTO sessions (user_id, token_hash, expires_at, mfa_verified_at, ip_address, user_agent)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, created_at`
+
+	// Set a timeout for the query
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// Execute the query
+	err := r.DB.DB.QueryRowContext(
+		ctx,
+		query,
+		session.UserID,
+		session.TokenHash,
+		session.ExpiresAt,
+		session.MFAVerifiedAt,
+		session.IPAddress,
+		session.UserAgent,
+	).Scan(&session.ID, &session.CreatedAt)
+
+	return err
+}
+
+// GetActiveByTokenHash retrieves an unexpired, unrevoked session of an
+// active user by its token hash
+func (r *SessionRepository) GetActiveByTokenHash(tokenHash string) (*Session, error) {
+	query := `
+		SELECT s.id, s.user_id, s.token_hash, s.created_at, s.expires_at, s.mfa_verified_at, s.revoked_at, s.ip_address, s.user_agent
+		FROM sessions s
+		JOIN users u ON u.id = s.user_id
+		WHERE s.token_hash = $1 AND s.revoked_at IS NULL AND s.expires_at > NOW() AND u.active`
+
+	var session Session
+
+	// Set a timeout for the query
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// Execute the query
+	err := r.DB.DB.QueryRowContext(ctx, query, tokenHash).Scan(
+		&session.ID,
+		&session.UserID,
+		&session.TokenHash,
+		&session.CreatedAt,
+		&session.ExpiresAt,
+		&session.MFAVerifiedAt,
+		&session.RevokedAt,
+		&session.IPAddress,
+		&session.UserAgent,
+	)
+
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, err
+	}
+
+	return &session, nil
+}
+
+// MarkMFAVerified records a fresh second-factor assertion on a session
+func (r *SessionRepository) MarkMFAVerified(session *Session) error {
+	query := `
+		UPDATE sessions
+		SET mfa_verified_at = NOW()
+		WHERE id = $1 AND revoked_at IS NULL
+		RETURNING mfa_verified_at`
+
+	// Set a timeout for the query
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// Execute the query
+	err := r.DB.DB.QueryRowContext(ctx, query, session.ID).Scan(&session.MFAVerifiedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrRecordNotFound
+	}
+
+	return err
+}
+
+// Revoke revokes a single session
+func (r *SessionRepository) Revoke(id int) error {
+	query := `
+		UPDATE sessions
+		SET revoked_at = NOW()
+		WHERE id = $1 AND revoked_at IS NULL`
+
+	// Set a timeout for the query
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// Execute the query
+	_, err := r.DB.DB.ExecContext(ctx, query, id)
+	return err
+}
+
+// RevokeAllForUser revokes every active session belonging to a user
+func (r *SessionRepository) RevokeAllForUser(userID int) error {
+	query := `
+		UPDATE sessions
+		SET revoked_at = NOW()
+		WHERE user_id = $1 AND revoked_at IS NULL`
+
+	// Set a timeout for the query
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// Execute the query
+	_, err := r.DB.DB.ExecContext(ctx, query, userID)
+	return err
+}
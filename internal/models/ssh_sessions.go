@@ -0,0 +1,215 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/theshovonaha/mini-pam/internal/database"
+)
+
+// SSHSessionRepository handles database operations related to SSH proxy sessions
+type SSHSessionRepository struct {
+	DB *database.Connection
+}
+
+// NewSSHSessionRepository creates a new SSH session repository
+func NewSSHSessionRepository(db *database.Connection) *SSHSessionRepository {
+	return &SSHSessionRepository{
+		DB: db,
+	}
+}
+
+// sshSessionColumns lists the columns scanned by scanSSHSession; the output
+// text itself is only read where it is needed
+const sshSessionColumns = `id, user_id, credential_id, target, target_username, ip_address, client_version,
+		       started_at, ended_at, COALESCE(recording_key, ''), COALESCE(recording_sha256, ''),
+		       COALESCE(recording_size, 0), input_recorded, truncated`
+
+// scanSSHSession scans a row selected with sshSessionColumns, plus any
+// extra destinations appended by the caller
+func scanSSHSession(row interface{ Scan(...interface{}) error }, extra ...interface{}) (*SSHSession, error) {
+	var session SSHSession
+	dest := []interface{}{
+		&session.ID,
+		&session.UserID,
+		&session.CredentialID,
+		&session.Target,
+		&session.TargetUsername,
+		&session.IPAddress,
+		&session.ClientVersion,
+		&session.StartedAt,
+		&session.EndedAt,
+		&session.RecordingKey,
+		&session.RecordingSHA256,
+		&session.RecordingSize,
+		&session.InputRecorded,
+		&session.Truncated,
+	}
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// Create inserts a session as it starts
+func (r *SSHSessionRepository) Create(session *SSHSession) error {
+	query := `
+		INSERT INTO ssh_sessions (user_id, credential_id, target, target_username, ip_address, client_version)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, started_at`
+
+	// Set a timeout for the query
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// Execute the query
+	return r.DB.DB.QueryRowContext(
+		ctx,
+		query,
+		session.UserID,
+		session.CredentialID,
+		session.Target,
+		session.TargetUsername,
+		session.IPAddress,
+		session.ClientVersion,
+	).Scan(&session.ID, &session.StartedAt)
+}
+
+// Finish marks a session ended and stores its recording details and output text
+func (r *SSHSessionRepository) Finish(session *SSHSession) error {
+	query := `
+		UPDATE ssh_sessions
+		SET ended_at = NOW(), recording_key = $1, recording_sha256 = $2, recording_size = $3,
+		    input_recorded = $4, truncated = $5, output_text = $6
+		WHERE id = $7
+		RETURNING ended_at`
+
+	// Set a timeout for the query
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// Execute the query
+	err := r.DB.DB.QueryRowContext(
+		ctx,
+		query,
+		nullString(session.RecordingKey),
+		nullString(session.RecordingSHA256),
+		session.RecordingSize,
+		session.InputRecorded,
+		session.Truncated,
+		session.OutputText,
+		session.ID,
+	).Scan(&session.EndedAt)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrRecordNotFound
+	}
+	return err
+}
+
+// GetByID retrieves a session by its ID
+func (r *SSHSessionRepository) GetByID(id int) (*SSHSession, error) {
+	query := `
+		SELECT ` + sshSessionColumns + `
+		FROM ssh_sessions
+		WHERE id = $1`
+
+	// Set a timeout for the query
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// Execute the query
+	session, err := scanSSHSession(r.DB.DB.QueryRowContext(ctx, query, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, err
+	}
+
+	return session, nil
+}
+
+// SSHSessionFilter narrows a session listing. Zero fields match everything.
+type SSHSessionFilter struct {
+	UserID       int
+	CredentialID int
+	Text         string // Web search syntax matched against the session output
+}
+
+// List returns a page of sessions matching filter, newest first. Text
+// searches also return a snippet of the matching output.
+func (r *SSHSessionRepository) List(filter SSHSessionFilter, page, pageSize int) ([]*SSHSession, error) {
+	// Ensure valid pagination parameters
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 {
+		pageSize = 20
+	}
+
+	conditions := []string{}
+	args := []interface{}{}
+	where := func(condition string, arg interface{}) {
+		args = append(args, arg)
+		conditions = append(conditions, strings.ReplaceAll(condition, "?", fmt.Sprintf("$%d", len(args))))
+	}
+
+	if filter.UserID != 0 {
+		where("user_id = ?", filter.UserID)
+	}
+	if filter.CredentialID != 0 {
+		where("credential_id = ?", filter.CredentialID)
+	}
+	snippet := `''`
+	if filter.Text != "" {
+		where("output_tsv @@ websearch_to_tsquery('simple', ?)", filter.Text)
+		snippet = fmt.Sprintf(`ts_headline('simple', output_text, websearch_to_tsquery('simple', $%d), 'MaxFragments=2')`, len(args))
+	}
+
+	query := `
+		SELECT ` + sshSessionColumns + `, ` + snippet + `
+		FROM ssh_sessions`
+	if len(conditions) > 0 {
+		query += `
+		WHERE ` + strings.Join(conditions, " AND ")
+	}
+	args = append(args, pageSize, (page-1)*pageSize)
+	query += fmt.Sprintf(`
+		ORDER BY id DESC
+		LIMIT $%d OFFSET $%d`, len(args)-1, len(args))
+
+	// Set a timeout for the query
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// Execute the query
+	rows, err := r.DB.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	// Iterate over the rows
+	sessions := []*SSHSession{}
+	for rows.Next() {
+		var snippet string
+		session, err := scanSSHSession(rows, &snippet)
+		if err != nil {
+			return nil, err
+		}
+		session.Snippet = snippet
+		sessions = append(sessions, session)
+	}
+
+	// Check for errors after iteration
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return sessions, nil
+}
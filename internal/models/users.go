@@ -4,8 +4,10 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"fmt"
 	"time"
 
+	"github.com/lib/pq"
 	"github.com/theshovonaha/mini-pam/internal/database"
 )
 
@@ -13,8 +15,21 @@ import (
 var (
 	ErrRecordNotFound = errors.New("record not found")
 	ErrDuplicateKey   = errors.New("duplicate key value violates unique constraint")
+	ErrUnknownMember  = errors.New("member is not an existing user")
 )
 
+// isUniqueViolation reports whether err is a Postgres unique_violation
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
+
+// isForeignKeyViolation reports whether err is a Postgres foreign_key_violation
+func isForeignKeyViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23503"
+}
+
 // UserRepository handles database operations related to users
 type UserRepository struct {
 	DB *database.Connection
@@ -30,9 +45,13 @@ func NewUserRepository(db *database.Connection) *UserRepository {
 // Create inserts a new user into the database
 func (r *UserRepository) Create(user *User) error {
 	query := `
-		INSERT INTO users (username, email, hashed_password, first_name, last_name, active)
-		VALUES ($1, $2, $3, $4, $5, $6)
-		RETURNING id, created_at, updated_at`
+		INSERT INTO users (username, email, hashed_password, first_name, last_name, active, auth_source, external_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING id, password_changed_at, created_at, updated_at`
+
+	if user.AuthSource == "" {
+		user.AuthSource = AuthSourceLocal
+	}
 
 	// Set a timeout for the query
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
@@ -48,7 +67,12 @@ func (r *UserRepository) Create(user *User) error {
 		user.FirstName,
 		user.LastName,
 		user.Active,
-	).Scan(&user.ID, &user.CreatedAt, &user.UpdatedAt)
+		user.AuthSource,
+		nullString(user.ExternalID),
+	).Scan(&user.ID, &user.PasswordChangedAt, &user.CreatedAt, &user.UpdatedAt)
+	if isUniqueViolation(err) {
+		return ErrDuplicateKey
+	}
 
 	return err
 }
@@ -56,7 +80,7 @@ func (r *UserRepository) Create(user *User) error {
 // GetByID retrieves a user by their ID
 func (r *UserRepository) GetByID(id int) (*User, error) {
 	query := `
-		SELECT id, username, email, hashed_password, first_name, last_name, active, created_at, updated_at
+		SELECT id, username, email, hashed_password, first_name, last_name, active, auth_source, COALESCE(external_id, ''), password_changed_at, created_at, updated_at
 		FROM users
 		WHERE id = $1`
 
@@ -75,6 +99,9 @@ func (r *UserRepository) GetByID(id int) (*User, error) {
 		&user.FirstName,
 		&user.LastName,
 		&user.Active,
+		&user.AuthSource,
+		&user.ExternalID,
+		&user.PasswordChangedAt,
 		&user.CreatedAt,
 		&user.UpdatedAt,
 	)
@@ -92,7 +119,7 @@ func (r *UserRepository) GetByID(id int) (*User, error) {
 // GetByEmail retrieves a user by their email address
 func (r *UserRepository) GetByEmail(email string) (*User, error) {
 	query := `
-		SELECT id, username, email, hashed_password, first_name, last_name, active, created_at, updated_at
+		SELECT id, username, email, hashed_password, first_name, last_name, active, auth_source, COALESCE(external_id, ''), password_changed_at, created_at, updated_at
 		FROM users
 		WHERE email = $1`
 
@@ -111,6 +138,9 @@ func (r *UserRepository) GetByEmail(email string) (*User, error) {
 		&user.FirstName,
 		&user.LastName,
 		&user.Active,
+		&user.AuthSource,
+		&user.ExternalID,
+		&user.PasswordChangedAt,
 		&user.CreatedAt,
 		&user.UpdatedAt,
 	)
@@ -128,7 +158,7 @@ func (r *UserRepository) GetByEmail(email string) (*User, error) {
 // GetByUsername retrieves a user by their username
 func (r *UserRepository) GetByUsername(username string) (*User, error) {
 	query := `
-		SELECT id, username, email, hashed_password, first_name, last_name, active, created_at, updated_at
+		SELECT id, username, email, hashed_password, first_name, last_name, active, auth_source, COALESCE(external_id, ''), password_changed_at, created_at, updated_at
 		FROM users
 		WHERE username = $1`
 
@@ -147,6 +177,49 @@ func (r *UserRepository) GetByUsername(username string) (*User, error) {
 		&user.FirstName,
 		&user.LastName,
 		&user.Active,
+		&user.AuthSource,
+		&user.ExternalID,
+		&user.PasswordChangedAt,
+		&user.CreatedAt,
+		&user.UpdatedAt,
+	)
+
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+// GetByExternalID retrieves a user by the identifier an external
+// authentication source knows them by
+func (r *UserRepository) GetByExternalID(source, externalID string) (*User, error) {
+	query := `
+		SELECT id, username, email, hashed_password, first_name, last_name, active, auth_source, COALESCE(external_id, ''), password_changed_at, created_at, updated_at
+		FROM users
+		WHERE auth_source = $1 AND external_id = $2`
+
+	var user User
+
+	// Set a timeout for the query
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// Execute the query
+	err := r.DB.DB.QueryRowContext(ctx, query, source, externalID).Scan(
+		&user.ID,
+		&user.Username,
+		&user.Email,
+		&user.HashedPassword,
+		&user.FirstName,
+		&user.LastName,
+		&user.Active,
+		&user.AuthSource,
+		&user.ExternalID,
+		&user.PasswordChangedAt,
 		&user.CreatedAt,
 		&user.UpdatedAt,
 	)
@@ -184,6 +257,31 @@ func (r *UserRepository) Update(user *User) error {
 		user.Active,
 		user.ID,
 	).Scan(&user.UpdatedAt)
+	if isUniqueViolation(err) {
+		return ErrDuplicateKey
+	}
+
+	return err
+}
+
+// BindExternal records the authentication source that vouches for a user
+// and its identifier for them
+func (r *UserRepository) BindExternal(user *User) error {
+	query := `
+		UPDATE users
+		SET auth_source = $1, external_id = $2, updated_at = NOW()
+		WHERE id = $3
+		RETURNING updated_at`
+
+	// Set a timeout for the query
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// Execute the query
+	err := r.DB.DB.QueryRowContext(ctx, query, user.AuthSource, nullString(user.ExternalID), user.ID).Scan(&user.UpdatedAt)
+	if isUniqueViolation(err) {
+		return ErrDuplicateKey
+	}
 
 	return err
 }
@@ -192,7 +290,7 @@ func (r *UserRepository) Update(user *User) error {
 func (r *UserRepository) UpdatePassword(id int, hashedPassword string) error {
 	query := `
 		UPDATE users
-		SET hashed_password = $1, updated_at = NOW()
+		SET hashed_password = $1, password_changed_at = NOW(), updated_at = NOW()
 		WHERE id = $2`
 
 	// Set a timeout for the query
@@ -247,7 +345,7 @@ func (r *UserRepository) List(page, pageSize int) ([]*User, error) {
 	offset := (page - 1) * pageSize
 
 	query := `
-		SELECT id, username, email, hashed_password, first_name, last_name, active, created_at, updated_at
+		SELECT id, username, email, hashed_password, first_name, last_name, active, auth_source, COALESCE(external_id, ''), password_changed_at, created_at, updated_at
 		FROM users
 		ORDER BY username
 		LIMIT $1 OFFSET $2`
@@ -275,6 +373,9 @@ func (r *UserRepository) List(page, pageSize int) ([]*User, error) {
 			&user.FirstName,
 			&user.LastName,
 			&user.Active,
+			&user.AuthSource,
+			&user.ExternalID,
+			&user.PasswordChangedAt,
 			&user.CreatedAt,
 			&user.UpdatedAt,
 		)
@@ -291,3 +392,92 @@ func (r *UserRepository) List(page, pageSize int) ([]*User, error) {
 
 	return users, nil
 }
+
+// UserFilter narrows a user search; empty fields are ignored
+type UserFilter struct {
+	Username string
+	Email    string
+	Active   *bool
+}
+
+// Search returns users matching the filter along with the total number of matches
+func (r *UserRepository) Search(filter UserFilter, offset, limit int) ([]*User, int, error) {
+	if offset < 0 {
+		offset = 0
+	}
+	if limit < 1 {
+		limit = 20
+	}
+
+	// Build the WHERE clause from the populated filter fields
+	where := "WHERE TRUE"
+	args := []interface{}{}
+	if filter.Username != "" {
+		args = append(args, filter.Username)
+		where += fmt.Sprintf(" AND LOWER(username) = LOWER($%d)", len(args))
+	}
+	if filter.Email != "" {
+		args = append(args, filter.Email)
+		where += fmt.Sprintf(" AND LOWER(email) = LOWER($%d)", len(args))
+	}
+	if filter.Active != nil {
+		args = append(args, *filter.Active)
+		where += fmt.Sprintf(" AND active = $%d", len(args))
+	}
+
+	// Set a timeout for the query
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// Count all matches for the caller's paging
+	var total int
+	if err := r.DB.DB.QueryRowContext(ctx, "SELECT COUNT(*) FROM users "+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	query := fmt.Sprintf(`
+		SELECT id, username, email, hashed_password, first_name, last_name, active, auth_source, COALESCE(external_id, ''), password_changed_at, created_at, updated_at
+		FROM users
+		%s
+		ORDER BY id
+		LIMIT $%d OFFSET $%d`, where, len(args)+1, len(args)+2)
+	args = append(args, limit, offset)
+
+	// Execute the query
+	rows, err := r.DB.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	// Iterate over the rows
+	users := []*User{}
+	for rows.Next() {
+		var user User
+		err := rows.Scan(
+			&user.ID,
+			&user.Username,
+			&user.Email,
+			&user.HashedPassword,
+			&user.FirstName,
+			&user.LastName,
+			&user.Active,
+			&user.AuthSource,
+			&user.ExternalID,
+			&user.PasswordChangedAt,
+			&user.CreatedAt,
+			&user.UpdatedAt,
+		)
+		if err != nil {
+			return nil, 0, err
+		}
+		users = append(users, &user)
+	}
+
+	// Check for errors after iteration
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	return users, total, nil
+}
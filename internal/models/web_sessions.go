@@ -0,0 +1,337 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/theshovonaha/mini-pam/internal/database"
+)
+
+// WebSessionRepository handles database operations related to web console
+// proxy sessions and their requests
+type WebSessionRepository struct {
+	DB *database.Connection
+}
+
+// NewWebSessionRepository creates a new web session repository
+func NewWebSessionRepository(db *database.Connection) *WebSessionRepository {
+	return &WebSessionRepository{
+		DB: db,
+	}
+}
+
+// webSessionColumns lists the columns scanned by scanWebSession
+const webSessionColumns = `id, user_id, credential_id, target, ip_address, user_agent, started_at, expires_at,
+		       last_seen_at, ended_at, COALESCE(ended_by, 0), request_count`
+
+// scanWebSession scans a row selected with webSessionColumns
+func scanWebSession(row interface{ Scan(...interface{}) error }) (*WebSession, error) {
+	var session WebSession
+	err := row.Scan(
+		&session.ID,
+		&session.UserID,
+		&session.CredentialID,
+		&session.Target,
+		&session.IPAddress,
+		&session.UserAgent,
+		&session.StartedAt,
+		&session.ExpiresAt,
+		&session.LastSeenAt,
+		&session.EndedAt,
+		&session.EndedBy,
+		&session.RequestCount,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// Create inserts a session waiting to be launched with its one-time code
+func (r *WebSessionRepository) Create(session *WebSession) error {
+	query := `
+		INSERT INTO web_sessions (user_id, credential_id, target, ip_address, user_agent, launch_hash, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id, started_at`
+
+	// Set a timeout for the query
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// Execute the query
+	return r.DB.DB.QueryRowContext(
+		ctx,
+		query,
+		session.UserID,
+		session.CredentialID,
+		session.Target,
+		session.IPAddress,
+		session.UserAgent,
+		session.LaunchHash,
+		session.ExpiresAt,
+	).Scan(&session.ID, &session.StartedAt)
+}
+
+// Launch exchanges a session's one-time launch code for the token its
+// browser presents from then on. It fails with ErrRecordNotFound if the
+// code was already used or the session is over.
+func (r *WebSessionRepository) Launch(launchHash, tokenHash string) (*WebSession, error) {
+	query := `
+		UPDATE web_sessions
+		SET launch_hash = NULL, token_hash = $2
+		WHERE launch_hash = $1 AND ended_at IS NULL AND expires_at > NOW()
+		RETURNING ` + webSessionColumns
+
+	// Set a timeout for the query
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// Execute the query
+	session, err := scanWebSession(r.DB.DB.QueryRowContext(ctx, query, launchHash, tokenHash))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, err
+	}
+
+	return session, nil
+}
+
+// GetActiveByTokenHash retrieves a launched session that has neither ended
+// nor expired by the hash of its browser token
+func (r *WebSessionRepository) GetActiveByTokenHash(tokenHash string) (*WebSession, error) {
+	query := `
+		SELECT ` + webSessionColumns + `
+		FROM web_sessions
+		WHERE token_hash = $1 AND ended_at IS NULL AND expires_at > NOW()`
+
+	// Set a timeout for the query
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// Execute the query
+	session, err := scanWebSession(r.DB.DB.QueryRowContext(ctx, query, tokenHash))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, err
+	}
+
+	return session, nil
+}
+
+// GetByID retrieves a session by its ID
+func (r *WebSessionRepository) GetByID(id int) (*WebSession, error) {
+	query := `
+		SELECT ` + webSessionColumns + `
+		FROM web_sessions
+		WHERE id = $1`
+
+	// Set a timeout for the query
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// Execute the query
+	session, err := scanWebSession(r.DB.DB.QueryRowContext(ctx, query, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, err
+	}
+
+	return session, nil
+}
+
+// End marks a session ended by a user, or by the server when endedBy is 0,
+// failing with ErrRecordNotFound if it already was
+func (r *WebSessionRepository) End(id, endedBy int) error {
+	query := `
+		UPDATE web_sessions
+		SET ended_at = NOW(), ended_by = $2, launch_hash = NULL, token_hash = NULL
+		WHERE id = $1 AND ended_at IS NULL`
+
+	// Set a timeout for the query
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// Execute the query
+	result, err := r.DB.DB.ExecContext(ctx, query, id, nullInt(endedBy))
+	if err != nil {
+		return err
+	}
+
+	// Check if any rows were affected
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// AddRequest logs a relayed request and counts it against its session
+func (r *WebSessionRepository) AddRequest(req *WebRequest) error {
+	query := `
+		WITH logged AS (
+			INSERT INTO web_requests (session_id, method, path, status, bytes, duration_ms)
+			VALUES ($1, $2, $3, $4, $5, $6)
+			RETURNING id, requested_at
+		), counted AS (
+			UPDATE web_sessions SET request_count = request_count + 1, last_seen_at = NOW() WHERE id = $1
+		)
+		SELECT id, requested_at FROM logged`
+
+	// Set a timeout for the query
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// Execute the query
+	return r.DB.DB.QueryRowContext(
+		ctx,
+		query,
+		req.SessionID,
+		req.Method,
+		req.Path,
+		req.Status,
+		req.Bytes,
+		req.DurationMS,
+	).Scan(&req.ID, &req.RequestedAt)
+}
+
+// WebSessionFilter narrows a session listing. Zero fields match everything.
+type WebSessionFilter struct {
+	UserID       int
+	CredentialID int
+}
+
+// List returns a page of sessions matching filter, newest first
+func (r *WebSessionRepository) List(filter WebSessionFilter, page, pageSize int) ([]*WebSession, error) {
+	// Ensure valid pagination parameters
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 {
+		pageSize = 20
+	}
+
+	conditions := []string{}
+	args := []interface{}{}
+	where := func(condition string, arg interface{}) {
+		args = append(args, arg)
+		conditions = append(conditions, strings.ReplaceAll(condition, "?", fmt.Sprintf("$%d", len(args))))
+	}
+
+	if filter.UserID != 0 {
+		where("user_id = ?", filter.UserID)
+	}
+	if filter.CredentialID != 0 {
+		where("credential_id = ?", filter.CredentialID)
+	}
+
+	query := `
+		SELECT ` + webSessionColumns + `
+		FROM web_sessions`
+	if len(conditions) > 0 {
+		query += `
+		WHERE ` + strings.Join(conditions, " AND ")
+	}
+	args = append(args, pageSize, (page-1)*pageSize)
+	query += fmt.Sprintf(`
+		ORDER BY id DESC
+		LIMIT $%d OFFSET $%d`, len(args)-1, len(args))
+
+	// Set a timeout for the query
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// Execute the query
+	rows, err := r.DB.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	// Iterate over the rows
+	sessions := []*WebSession{}
+	for rows.Next() {
+		session, err := scanWebSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+
+	// Check for errors after iteration
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return sessions, nil
+}
+
+// ListRequests returns a page of the requests relayed over a session, in
+// the order they were made
+func (r *WebSessionRepository) ListRequests(sessionID, page, pageSize int) ([]*WebRequest, error) {
+	// Ensure valid pagination parameters
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 {
+		pageSize = 100
+	}
+
+	query := `
+		SELECT id, session_id, method, path, status, bytes, duration_ms, requested_at
+		FROM web_requests
+		WHERE session_id = $1
+		ORDER BY id
+		LIMIT $2 OFFSET $3`
+
+	// Set a timeout for the query
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// Execute the query
+	rows, err := r.DB.DB.QueryContext(ctx, query, sessionID, pageSize, (page-1)*pageSize)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	// Iterate over the rows
+	requests := []*WebRequest{}
+	for rows.Next() {
+		var req WebRequest
+		err := rows.Scan(
+			&req.ID,
+			&req.SessionID,
+			&req.Method,
+			&req.Path,
+			&req.Status,
+			&req.Bytes,
+			&req.DurationMS,
+			&req.RequestedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		requests = append(requests, &req)
+	}
+
+	// Check for errors after iteration
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return requests, nil
+}
@@ -0,0 +1,406 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/theshovonaha/mini-pam/internal/database"
+)
+
+// WebhookRepository handles database operations related to webhook subscriptions and deliveries
+type WebhookRepository struct {
+	DB *database.Connection
+}
+
+// NewWebhookRepository creates a new webhook repository
+func NewWebhookRepository(db *database.Connection) *WebhookRepository {
+	return &WebhookRepository{
+		DB: db,
+	}
+}
+
+// webhookSubscriptionColumns is the column list scanned by scanWebhookSubscription
+const webhookSubscriptionColumns = `id, name, url, secret, event_types, active, COALESCE(created_by, 0), created_at, updated_at`
+
+// scanWebhookSubscription scans a row selected with webhookSubscriptionColumns
+func scanWebhookSubscription(row interface{ Scan(...interface{}) error }) (*WebhookSubscription, error) {
+	var subscription WebhookSubscription
+	err := row.Scan(
+		&subscription.ID,
+		&subscription.Name,
+		&subscription.URL,
+		&subscription.Secret,
+		pq.Array(&subscription.EventTypes),
+		&subscription.Active,
+		&subscription.CreatedBy,
+		&subscription.CreatedAt,
+		&subscription.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &subscription, nil
+}
+
+// CreateSubscription inserts a new webhook subscription into the database
+func (r *WebhookRepository) CreateSubscription(subscription *WebhookSubscription) error {
+	query := `
+		INSERT INTO webhook_subscriptions (name, url, secret, event_types, active, created_by)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, created_at, updated_at`
+
+	// Set a timeout for the query
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// Execute the query
+	return r.DB.DB.QueryRowContext(
+		ctx,
+		query,
+		subscription.Name,
+		subscription.URL,
+		subscription.Secret,
+		pq.Array(subscription.EventTypes),
+		subscription.Active,
+		nullInt(subscription.CreatedBy),
+	).Scan(&subscription.ID, &subscription.CreatedAt, &subscription.UpdatedAt)
+}
+
+// GetSubscription retrieves a webhook subscription by its ID
+func (r *WebhookRepository) GetSubscription(id int) (*WebhookSubscription, error) {
+	query := `
+		SELECT ` + webhookSubscriptionColumns + `
+		FROM webhook_subscriptions
+		WHERE id = $1`
+
+	// Set a timeout for the query
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// Execute the query
+	subscription, err := scanWebhookSubscription(r.DB.DB.QueryRowContext(ctx, query, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, err
+	}
+
+	return subscription, nil
+}
+
+// ListSubscriptions returns all webhook subscriptions
+func (r *WebhookRepository) ListSubscriptions() ([]*WebhookSubscription, error) {
+	query := `
+		SELECT ` + webhookSubscriptionColumns + `
+		FROM webhook_subscriptions
+		ORDER BY name, id`
+
+	// Set a timeout for the query
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// Execute the query
+	rows, err := r.DB.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	// Iterate over the rows
+	subscriptions := []*WebhookSubscription{}
+	for rows.Next() {
+		subscription, err := scanWebhookSubscription(rows)
+		if err != nil {
+			return nil, err
+		}
+		subscriptions = append(subscriptions, subscription)
+	}
+
+	// Check for errors after iteration
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return subscriptions, nil
+}
+
+// UpdateSubscription saves a subscription's URL, event types and active flag
+func (r *WebhookRepository) UpdateSubscription(subscription *WebhookSubscription) error {
+	query := `
+		UPDATE webhook_subscriptions
+		SET name = $1, url = $2, event_types = $3, active = $4, updated_at = NOW()
+		WHERE id = $5
+		RETURNING updated_at`
+
+	// Set a timeout for the query
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// Execute the query
+	err := r.DB.DB.QueryRowContext(
+		ctx,
+		query,
+		subscription.Name,
+		subscription.URL,
+		pq.Array(subscription.EventTypes),
+		subscription.Active,
+		subscription.ID,
+	).Scan(&subscription.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrRecordNotFound
+	}
+
+	return err
+}
+
+// DeleteSubscription removes a subscription together with its delivery history
+func (r *WebhookRepository) DeleteSubscription(id int) error {
+	query := `DELETE FROM webhook_subscriptions WHERE id = $1`
+
+	// Set a timeout for the query
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// Execute the query
+	result, err := r.DB.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// Enqueue queues an event for every active subscription listening for its
+// type, or for all events with "*", and returns how many were queued
+func (r *WebhookRepository) Enqueue(eventType string, payload []byte) (int, error) {
+	query := `
+		INSERT INTO webhook_deliveries (subscription_id, event_type, payload)
+		SELECT id, $1, $2
+		FROM webhook_subscriptions
+		WHERE active AND ($1 = ANY(event_types) OR '*' = ANY(event_types))`
+
+	// Set a timeout for the query
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// Execute the query
+	result, err := r.DB.DB.ExecContext(ctx, query, eventType, payload)
+	if err != nil {
+		return 0, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	return int(rowsAffected), err
+}
+
+// webhookDeliveryColumns is the column list scanned by scanWebhookDelivery
+const webhookDeliveryColumns = `d.id, d.subscription_id, d.event_type, d.payload, d.status, d.attempts, d.next_attempt_at,
+		       d.last_attempt_at, COALESCE(d.response_status, 0), COALESCE(d.last_error, ''), COALESCE(d.replay_of, 0),
+		       d.created_at, d.delivered_at`
+
+// scanWebhookDelivery scans a row selected with webhookDeliveryColumns, plus
+// any extra destinations appended by the caller
+func scanWebhookDelivery(row interface{ Scan(...interface{}) error }, extra ...interface{}) (*WebhookDelivery, error) {
+	var delivery WebhookDelivery
+	dest := []interface{}{
+		&delivery.ID,
+		&delivery.SubscriptionID,
+		&delivery.EventType,
+		&delivery.Payload,
+		&delivery.Status,
+		&delivery.Attempts,
+		&delivery.NextAttemptAt,
+		&delivery.LastAttemptAt,
+		&delivery.ResponseStatus,
+		&delivery.LastError,
+		&delivery.ReplayOf,
+		&delivery.CreatedAt,
+		&delivery.DeliveredAt,
+	}
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		return nil, err
+	}
+	return &delivery, nil
+}
+
+// GetDelivery retrieves a delivery by its ID
+func (r *WebhookRepository) GetDelivery(id int) (*WebhookDelivery, error) {
+	query := `
+		SELECT ` + webhookDeliveryColumns + `
+		FROM webhook_deliveries d
+		WHERE d.id = $1`
+
+	// Set a timeout for the query
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// Execute the query
+	delivery, err := scanWebhookDelivery(r.DB.DB.QueryRowContext(ctx, query, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, err
+	}
+
+	return delivery, nil
+}
+
+// ListDeliveries returns a subscription's newest deliveries first, optionally
+// only those in one status
+func (r *WebhookRepository) ListDeliveries(subscriptionID int, status string, limit int) ([]*WebhookDelivery, error) {
+	if limit <= 0 {
+		limit = 50 // Default limit
+	}
+
+	query := `
+		SELECT ` + webhookDeliveryColumns + `
+		FROM webhook_deliveries d
+		WHERE d.subscription_id = $1 AND ($2 = '' OR d.status = $2)
+		ORDER BY d.id DESC
+		LIMIT $3`
+
+	// Set a timeout for the query
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// Execute the query
+	rows, err := r.DB.DB.QueryContext(ctx, query, subscriptionID, status, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	// Iterate over the rows
+	deliveries := []*WebhookDelivery{}
+	for rows.Next() {
+		delivery, err := scanWebhookDelivery(rows)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, delivery)
+	}
+
+	// Check for errors after iteration
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
+
+// Replay queues a fresh copy of a delivery's payload, leaving the original
+// and its history untouched
+func (r *WebhookRepository) Replay(id int) (*WebhookDelivery, error) {
+	query := `
+		INSERT INTO webhook_deliveries (subscription_id, event_type, payload, replay_of)
+		SELECT subscription_id, event_type, payload, id
+		FROM webhook_deliveries
+		WHERE id = $1
+		RETURNING id`
+
+	// Set a timeout for the query
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// Execute the query
+	var replayID int
+	err := r.DB.DB.QueryRowContext(ctx, query, id).Scan(&replayID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, err
+	}
+
+	return r.GetDelivery(replayID)
+}
+
+// ClaimDue locks up to limit pending deliveries that are due, pushes their
+// next attempt out by lease so no other dispatcher picks them up meanwhile,
+// and returns them with their subscription
+func (r *WebhookRepository) ClaimDue(limit int, lease time.Duration) ([]*WebhookDelivery, error) {
+	query := `
+		WITH due AS (
+			SELECT d.id
+			FROM webhook_deliveries d
+			JOIN webhook_subscriptions s ON s.id = d.subscription_id
+			WHERE d.status = 'pending' AND d.next_attempt_at <= NOW() AND s.active
+			ORDER BY d.next_attempt_at, d.id
+			LIMIT $1
+			FOR UPDATE OF d SKIP LOCKED
+		)
+		UPDATE webhook_deliveries d
+		SET next_attempt_at = NOW() + $2 * INTERVAL '1 second'
+		FROM due, webhook_subscriptions s
+		WHERE d.id = due.id AND s.id = d.subscription_id
+		RETURNING ` + webhookDeliveryColumns + `, s.url, s.secret`
+
+	// Set a timeout for the query
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// Execute the query
+	rows, err := r.DB.DB.QueryContext(ctx, query, limit, lease.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	// Iterate over the rows
+	deliveries := []*WebhookDelivery{}
+	for rows.Next() {
+		subscription := &WebhookSubscription{}
+		delivery, err := scanWebhookDelivery(rows, &subscription.URL, &subscription.Secret)
+		if err != nil {
+			return nil, err
+		}
+		subscription.ID = delivery.SubscriptionID
+		delivery.Subscription = subscription
+		deliveries = append(deliveries, delivery)
+	}
+
+	// Check for errors after iteration
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
+
+// RecordAttempt stores the outcome of a delivery attempt. A pending status
+// schedules the next attempt at nextAttemptAt.
+func (r *WebhookRepository) RecordAttempt(delivery *WebhookDelivery, nextAttemptAt time.Time) error {
+	query := `
+		UPDATE webhook_deliveries
+		SET status = $1, attempts = $2, next_attempt_at = $3, last_attempt_at = NOW(),
+		    response_status = $4, last_error = $5,
+		    delivered_at = CASE WHEN $1 = 'delivered' THEN NOW() ELSE delivered_at END
+		WHERE id = $6
+		RETURNING last_attempt_at, delivered_at`
+
+	// Set a timeout for the query
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// Execute the query
+	return r.DB.DB.QueryRowContext(
+		ctx,
+		query,
+		delivery.Status,
+		delivery.Attempts,
+		nextAttemptAt,
+		nullInt(delivery.ResponseStatus),
+		delivery.LastError,
+		delivery.ID,
+	).Scan(&delivery.LastAttemptAt, &delivery.DeliveredAt)
+}
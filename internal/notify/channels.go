@@ -0,0 +1,212 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/theshovonaha/mini-pam/internal/mail"
+	"github.com/theshovonaha/mini-pam/internal/models"
+	"github.com/theshovonaha/mini-pam/internal/webhook"
+)
+
+// Channel delivers rendered notifications to their recipients
+type Channel interface {
+	// Name identifies the channel in preferences and the outbox
+	Name() string
+	Send(ctx context.Context, notification *models.Notification) error
+}
+
+// EmailChannel emails notifications to the recipient's address
+type EmailChannel struct {
+	Mailer mail.Mailer
+}
+
+// Name implements Channel
+func (c *EmailChannel) Name() string {
+	return "email"
+}
+
+// Send implements Channel
+func (c *EmailChannel) Send(ctx context.Context, notification *models.Notification) error {
+	if notification.Recipient.Email == "" {
+		return fmt.Errorf("user %s has no email address", notification.Recipient.Username)
+	}
+	return c.Mailer.Send(&mail.Message{
+		To:      []string{notification.Recipient.Email},
+		Subject: notification.Subject,
+		Body:    notification.Body,
+	})
+}
+
+// WebhookChannel POSTs notifications as JSON to an HTTP endpoint, signed
+// like webhook deliveries when a secret is set
+type WebhookChannel struct {
+	URL    string
+	Secret string
+	client *http.Client
+}
+
+// NewWebhookChannel creates a channel posting to url
+func NewWebhookChannel(url, secret string) *WebhookChannel {
+	return &WebhookChannel{URL: url, Secret: secret, client: newClient()}
+}
+
+// Name implements Channel
+func (c *WebhookChannel) Name() string {
+	return "webhook"
+}
+
+// webhookPayload is the body posted by WebhookChannel
+type webhookPayload struct {
+	ID        int       `json:"id"`
+	Event     string    `json:"event"`
+	Subject   string    `json:"subject"`
+	Body      string    `json:"body"`
+	User      userRef   `json:"user"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type userRef struct {
+	ID       int    `json:"id"`
+	Username string `json:"username"`
+	Email    string `json:"email"`
+}
+
+// Send implements Channel
+func (c *WebhookChannel) Send(ctx context.Context, notification *models.Notification) error {
+	body, err := json.Marshal(webhookPayload{
+		ID:      notification.ID,
+		Event:   notification.Event,
+		Subject: notification.Subject,
+		Body:    notification.Body,
+		User: userRef{
+			ID:       notification.Recipient.ID,
+			Username: notification.Recipient.Username,
+			Email:    notification.Recipient.Email,
+		},
+		CreatedAt: notification.CreatedAt,
+	})
+	if err != nil {
+		return err
+	}
+
+	header := http.Header{}
+	header.Set(webhook.EventHeader, notification.Event)
+	header.Set(webhook.DeliveryHeader, strconv.Itoa(notification.ID))
+	if c.Secret != "" {
+		header.Set(webhook.SignatureHeader, webhook.Sign(c.Secret, time.Now(), body))
+	}
+	return post(ctx, c.client, c.URL, body, header)
+}
+
+// SlackChannel posts notifications to a Slack-compatible incoming webhook,
+// which Mattermost, Rocket.Chat and others accept as well
+type SlackChannel struct {
+	URL    string
+	client *http.Client
+}
+
+// NewSlackChannel creates a channel posting to the incoming webhook url
+func NewSlackChannel(url string) *SlackChannel {
+	return &SlackChannel{URL: url, client: newClient()}
+}
+
+// Name implements Channel
+func (c *SlackChannel) Name() string {
+	return "slack"
+}
+
+// Send implements Channel
+func (c *SlackChannel) Send(ctx context.Context, notification *models.Notification) error {
+	body, err := json.Marshal(map[string]string{
+		"text": "*" + notification.Subject + "*\n" + notification.Body,
+	})
+	if err != nil {
+		return err
+	}
+	return post(ctx, c.client, c.URL, body, nil)
+}
+
+// TeamsChannel posts notifications to a Microsoft Teams incoming webhook
+// as message cards
+type TeamsChannel struct {
+	URL    string
+	client *http.Client
+}
+
+// NewTeamsChannel creates a channel posting to the incoming webhook url
+func NewTeamsChannel(url string) *TeamsChannel {
+	return &TeamsChannel{URL: url, client: newClient()}
+}
+
+// Name implements Channel
+func (c *TeamsChannel) Name() string {
+	return "teams"
+}
+
+// Send implements Channel
+func (c *TeamsChannel) Send(ctx context.Context, notification *models.Notification) error {
+	body, err := json.Marshal(map[string]string{
+		"@type":    "MessageCard",
+		"@context": "https://schema.org/extensions",
+		"summary":  notification.Subject,
+		"title":    notification.Subject,
+		// Card text is markdown, which joins single line breaks
+		"text": strings.ReplaceAll(notification.Body, "\n", "\n\n"),
+	})
+	if err != nil {
+		return err
+	}
+	return post(ctx, c.client, c.URL, body, nil)
+}
+
+// newClient returns the HTTP client of a webhook channel
+func newClient() *http.Client {
+	return &http.Client{
+		Timeout: 10 * time.Second,
+		// Incoming webhook URLs are secrets; don't send the message elsewhere
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// post sends a JSON body, failing unless the endpoint answers 2xx. Errors
+// leave out the URL, which holds the secret of incoming webhooks.
+func post(ctx context.Context, client *http.Client, endpoint string, body []byte, header http.Header) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return errors.New("invalid webhook URL")
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "mini-pam-notify/1.0")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			return fmt.Errorf("webhook request failed: %w", urlErr.Err)
+		}
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		text, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("webhook responded %s: %s", resp.Status, bytes.TrimSpace(text))
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 512))
+	return nil
+}
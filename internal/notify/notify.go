@@ -0,0 +1,251 @@
+// Package notify tells users about events that concern them. Notifications
+// are rendered from per-event templates, queued in a persisted outbox, one
+// entry per channel the user chose, and delivered from there with retries,
+// so they survive restarts and a failing channel does not hold up others.
+package notify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/theshovonaha/mini-pam/internal/models"
+	"github.com/theshovonaha/mini-pam/internal/webhook"
+)
+
+// Events with built-in templates
+const (
+	EventCredentialExpiring = "credential_expiring"
+	EventAccountLocked      = "account_locked"
+)
+
+// AllEvents is the preference event that applies to every event without a
+// preference of its own
+const AllEvents = "*"
+
+// Delivery tuning
+const (
+	sendTimeout    = 30 * time.Second
+	claimBatchSize = 20
+	// claimLease keeps a claimed notification from being picked up again
+	// while it is being sent; it must exceed sendTimeout
+	claimLease  = 2 * time.Minute
+	maxAttempts = 10
+)
+
+// Outbox is the persisted notification queue, implemented by models.NotificationRepository
+type Outbox interface {
+	Enqueue(notifications []*models.Notification) error
+	ClaimDue(limit int, lease time.Duration) ([]*models.Notification, error)
+	RecordAttempt(notification *models.Notification, nextAttemptAt time.Time) error
+}
+
+// Preferences looks up which channels users want, implemented by models.NotificationRepository
+type Preferences interface {
+	GetPreferences(userID int) ([]*models.NotificationPreference, error)
+}
+
+// Notifier queues notifications for users and delivers them
+type Notifier struct {
+	outbox      Outbox
+	preferences Preferences
+	templates   *Templates
+	channels    map[string]Channel
+	names       []string
+	logger      *log.Logger
+	now         func() time.Time
+}
+
+// New creates a notifier delivering through the given channels. Users
+// without preferences are notified through all of them.
+func New(outbox Outbox, preferences Preferences, templates *Templates, channels []Channel, logger *log.Logger) *Notifier {
+	n := &Notifier{
+		outbox:      outbox,
+		preferences: preferences,
+		templates:   templates,
+		channels:    map[string]Channel{},
+		logger:      logger,
+		now:         time.Now,
+	}
+	for _, channel := range channels {
+		n.channels[channel.Name()] = channel
+		n.names = append(n.names, channel.Name())
+	}
+	return n
+}
+
+// Channels lists the names of the configured channels
+func (n *Notifier) Channels() []string {
+	if n == nil {
+		return nil
+	}
+	return slices.Clone(n.names)
+}
+
+// Events lists the events users can set preferences for
+func (n *Notifier) Events() []string {
+	if n == nil {
+		return nil
+	}
+	return n.templates.Events()
+}
+
+// channelsFor returns the channels a user wants for an event: their
+// preference for it, else their default, else every channel
+func (n *Notifier) channelsFor(userID int, event string) ([]string, error) {
+	preferences, err := n.preferences.GetPreferences(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	chosen := n.names
+	if i := slices.IndexFunc(preferences, func(p *models.NotificationPreference) bool { return p.Event == event }); i >= 0 {
+		chosen = preferences[i].Channels
+	} else if i := slices.IndexFunc(preferences, func(p *models.NotificationPreference) bool { return p.Event == AllEvents }); i >= 0 {
+		chosen = preferences[i].Channels
+	}
+
+	// Preferences may name channels that are no longer configured
+	names := []string{}
+	for _, name := range chosen {
+		if _, ok := n.channels[name]; ok {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+// Notify renders an event's template with data and queues the message for
+// each of the user's channels. A nil notifier drops notifications.
+func (n *Notifier) Notify(userID int, event string, data any) error {
+	if n == nil {
+		return nil
+	}
+
+	names, err := n.channelsFor(userID, event)
+	if err != nil {
+		return err
+	}
+	if len(names) == 0 {
+		return nil // The user opted out
+	}
+
+	subject, body, err := n.templates.Render(event, data)
+	if err != nil {
+		return err
+	}
+
+	notifications := []*models.Notification{}
+	for _, name := range names {
+		notifications = append(notifications, &models.Notification{
+			UserID:  userID,
+			Event:   event,
+			Channel: name,
+			Subject: subject,
+			Body:    body,
+		})
+	}
+	return n.outbox.Enqueue(notifications)
+}
+
+// DeliverDue claims and sends due notifications, batch by batch, until
+// none are left, returning how many were attempted
+func (n *Notifier) DeliverDue(ctx context.Context) (int, error) {
+	attempted := 0
+	for ctx.Err() == nil {
+		notifications, err := n.outbox.ClaimDue(claimBatchSize, claimLease)
+		if err != nil {
+			return attempted, err
+		}
+		if len(notifications) == 0 {
+			break
+		}
+
+		// Channels are independent; one slow relay should not hold up the rest
+		var wg sync.WaitGroup
+		for _, notification := range notifications {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				n.attempt(ctx, notification)
+			}()
+		}
+		wg.Wait()
+		attempted += len(notifications)
+	}
+	return attempted, nil
+}
+
+// attempt sends one notification and records the outcome, scheduling a
+// retry with exponential backoff or giving up after maxAttempts
+func (n *Notifier) attempt(ctx context.Context, notification *models.Notification) {
+	err := n.send(ctx, notification)
+	if err != nil && ctx.Err() != nil {
+		// Shutting down; the lease expires and the notification is retried
+		// without counting this attempt
+		return
+	}
+
+	notification.Attempts++
+	notification.LastError = ""
+	next := n.now()
+	switch {
+	case err == nil:
+		notification.Status = models.NotificationSent
+	case notification.Attempts >= maxAttempts || errors.Is(err, errUndeliverable):
+		notification.Status = models.NotificationFailed
+		notification.LastError = err.Error()
+	default:
+		notification.Status = models.NotificationPending
+		notification.LastError = err.Error()
+		next = next.Add(webhook.Backoff(notification.Attempts))
+	}
+
+	if err := n.outbox.RecordAttempt(notification, next); err != nil {
+		n.logger.Printf("Error recording notification %d: %v", notification.ID, err)
+	}
+	if notification.Status == models.NotificationFailed {
+		n.logger.Printf("Notification %d to user %d by %s failed after %d attempts: %s",
+			notification.ID, notification.UserID, notification.Channel, notification.Attempts, notification.LastError)
+	}
+}
+
+// errUndeliverable marks failures that retrying cannot fix
+var errUndeliverable = errors.New("undeliverable")
+
+// send delivers a notification through its channel
+func (n *Notifier) send(ctx context.Context, notification *models.Notification) error {
+	channel, ok := n.channels[notification.Channel]
+	if !ok {
+		return fmt.Errorf("%w: channel %s is not configured", errUndeliverable, notification.Channel)
+	}
+	if notification.Recipient == nil || !notification.Recipient.Active {
+		return fmt.Errorf("%w: user %d is not active", errUndeliverable, notification.UserID)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, sendTimeout)
+	defer cancel()
+	return channel.Send(ctx, notification)
+}
+
+// Run delivers due notifications every interval until ctx is cancelled
+func (n *Notifier) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := n.DeliverDue(ctx); err != nil {
+			n.logger.Printf("Error delivering notifications: %v", err)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
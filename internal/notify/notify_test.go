@@ -0,0 +1,295 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/theshovonaha/mini-pam/internal/mail"
+	"github.com/theshovonaha/mini-pam/internal/mail/mailtest"
+	"github.com/theshovonaha/mini-pam/internal/models"
+	"github.com/theshovonaha/mini-pam/internal/webhook"
+)
+
+// fakeOutbox keeps notifications in memory and hands out every pending one
+// that is due
+type fakeOutbox struct {
+	mu            sync.Mutex
+	notifications []*models.Notification
+	preferences   []*models.NotificationPreference
+	recipient     *models.User
+}
+
+func (o *fakeOutbox) Enqueue(notifications []*models.Notification) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for _, n := range notifications {
+		n.ID = len(o.notifications) + 1
+		n.Status = models.NotificationPending
+		o.notifications = append(o.notifications, n)
+	}
+	return nil
+}
+
+func (o *fakeOutbox) ClaimDue(limit int, lease time.Duration) ([]*models.Notification, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	due := []*models.Notification{}
+	for _, n := range o.notifications {
+		if n.Status == models.NotificationPending && !n.NextAttemptAt.After(time.Now()) && len(due) < limit {
+			n.NextAttemptAt = time.Now().Add(lease)
+			n.Recipient = o.recipient
+			due = append(due, n)
+		}
+	}
+	return due, nil
+}
+
+func (o *fakeOutbox) RecordAttempt(notification *models.Notification, nextAttemptAt time.Time) error {
+	notification.NextAttemptAt = nextAttemptAt
+	return nil
+}
+
+func (o *fakeOutbox) GetPreferences(userID int) ([]*models.NotificationPreference, error) {
+	return o.preferences, nil
+}
+
+type fakeChannel struct {
+	name string
+	err  error
+	sent int
+}
+
+func (c *fakeChannel) Name() string { return c.name }
+
+func (c *fakeChannel) Send(ctx context.Context, notification *models.Notification) error {
+	c.sent++
+	return c.err
+}
+
+func newNotifier(t *testing.T, outbox *fakeOutbox, channels ...Channel) *Notifier {
+	t.Helper()
+	templates, err := LoadTemplates("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return New(outbox, outbox, templates, channels, log.New(io.Discard, "", 0))
+}
+
+func lockedData() map[string]any {
+	return map[string]any{
+		"Username":    "alice",
+		"Failures":    5,
+		"IPAddress":   "192.0.2.7",
+		"LockedUntil": time.Date(2026, 3, 1, 9, 15, 0, 0, time.UTC),
+	}
+}
+
+func TestNotifyFollowsPreferences(t *testing.T) {
+	email, slack, teams := &fakeChannel{name: "email"}, &fakeChannel{name: "slack"}, &fakeChannel{name: "teams"}
+
+	tests := []struct {
+		preferences []*models.NotificationPreference
+		want        []string
+	}{
+		{nil, []string{"email", "slack", "teams"}},
+		{[]*models.NotificationPreference{{Event: AllEvents, Channels: []string{"slack"}}}, []string{"slack"}},
+		{[]*models.NotificationPreference{
+			{Event: EventAccountLocked, Channels: []string{"email", "pager"}},
+			{Event: AllEvents, Channels: []string{"slack"}},
+		}, []string{"email"}},
+		{[]*models.NotificationPreference{{Event: EventAccountLocked, Channels: []string{}}}, nil},
+	}
+	for _, tt := range tests {
+		outbox := &fakeOutbox{preferences: tt.preferences}
+		if err := newNotifier(t, outbox, email, slack, teams).Notify(1, EventAccountLocked, lockedData()); err != nil {
+			t.Fatal(err)
+		}
+
+		var got []string
+		for _, n := range outbox.notifications {
+			got = append(got, n.Channel)
+		}
+		if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("preferences %v queued for %v, want %v", tt.preferences, got, tt.want)
+		}
+	}
+}
+
+func TestNotifyRendersTemplate(t *testing.T) {
+	outbox := &fakeOutbox{}
+	notifier := newNotifier(t, outbox, &fakeChannel{name: "email"})
+
+	if err := notifier.Notify(1, EventAccountLocked, lockedData()); err != nil {
+		t.Fatal(err)
+	}
+	n := outbox.notifications[0]
+	if n.Subject != "Your account alice has been locked" {
+		t.Errorf("subject %q", n.Subject)
+	}
+	if !strings.Contains(n.Body, "after 5 failed logins, the last from 192.0.2.7") ||
+		!strings.Contains(n.Body, "Sun, 01 Mar 2026 09:15:00 UTC") {
+		t.Errorf("body %q", n.Body)
+	}
+
+	// Data missing a field the template uses is an error, not "<no value>"
+	if err := notifier.Notify(1, EventAccountLocked, map[string]any{"Username": "alice"}); err == nil {
+		t.Error("rendered with missing data")
+	}
+	if err := notifier.Notify(1, "no_such_event", nil); err == nil {
+		t.Error("rendered an unknown event")
+	}
+
+	var nilNotifier *Notifier
+	if err := nilNotifier.Notify(1, EventAccountLocked, nil); err != nil {
+		t.Errorf("nil notifier: %v", err)
+	}
+}
+
+func TestTemplateOverrides(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, text string) {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(text), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("account_locked.tmpl", `{{define "subject"}}Locked: {{.Username}}{{end}}{{define "body"}}Call the help desk.{{end}}`)
+	write("break_glass.tmpl", `{{define "subject"}}Break-glass by {{.Username}}{{end}}{{define "body"}}{{.Reason}}{{end}}`)
+
+	templates, err := LoadTemplates(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if events := strings.Join(templates.Events(), ","); events != "account_locked,break_glass,credential_expiring" {
+		t.Errorf("events %s", events)
+	}
+	subject, body, err := templates.Render(EventAccountLocked, lockedData())
+	if err != nil || subject != "Locked: alice" || body != "Call the help desk.\n" {
+		t.Errorf("override rendered %q, %q, %v", subject, body, err)
+	}
+
+	write("broken.tmpl", `{{define "subject"}}no body{{end}}`)
+	if _, err := LoadTemplates(dir); err == nil {
+		t.Error("loaded a template without a body")
+	}
+}
+
+func TestDeliverDueRetries(t *testing.T) {
+	outbox := &fakeOutbox{recipient: &models.User{ID: 1, Username: "alice", Active: true}}
+	slack := &fakeChannel{name: "slack", err: errors.New("503 Service Unavailable")}
+	email := &fakeChannel{name: "email"}
+	notifier := newNotifier(t, outbox, email, slack)
+
+	if err := notifier.Notify(1, EventAccountLocked, lockedData()); err != nil {
+		t.Fatal(err)
+	}
+	if attempted, err := notifier.DeliverDue(context.Background()); attempted != 2 || err != nil {
+		t.Fatalf("DeliverDue() = %d, %v", attempted, err)
+	}
+
+	sent, retry := outbox.notifications[0], outbox.notifications[1]
+	if sent.Status != models.NotificationSent || email.sent != 1 {
+		t.Errorf("email %s after %d sends", sent.Status, email.sent)
+	}
+	if retry.Status != models.NotificationPending || retry.Attempts != 1 || !strings.Contains(retry.LastError, "503") ||
+		time.Until(retry.NextAttemptAt) < 25*time.Second {
+		t.Errorf("slack %s, %d attempts, %q, next in %s", retry.Status, retry.Attempts, retry.LastError, time.Until(retry.NextAttemptAt))
+	}
+
+	// Inactive users are not retried
+	outbox.recipient.Active = false
+	retry.NextAttemptAt = time.Now()
+	notifier.DeliverDue(context.Background())
+	if retry.Status != models.NotificationFailed || slack.sent != 1 {
+		t.Errorf("slack %s after %d sends", retry.Status, slack.sent)
+	}
+}
+
+func TestWebhookChannels(t *testing.T) {
+	var header http.Header
+	var body []byte
+	endpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header
+		body, _ = io.ReadAll(r.Body)
+	}))
+	defer endpoint.Close()
+
+	notification := &models.Notification{
+		ID:        42,
+		Event:     EventAccountLocked,
+		Subject:   "Your account alice has been locked",
+		Body:      "Hello alice,\nCall the help desk.\n",
+		Recipient: &models.User{ID: 1, Username: "alice", Email: "alice@example.com"},
+	}
+	ctx := context.Background()
+
+	if err := NewWebhookChannel(endpoint.URL, "whsec_test").Send(ctx, notification); err != nil {
+		t.Fatal(err)
+	}
+	var payload webhookPayload
+	json.Unmarshal(body, &payload)
+	if payload.ID != 42 || payload.User.Email != "alice@example.com" || header.Get(webhook.EventHeader) != EventAccountLocked {
+		t.Errorf("webhook posted %s with %v", body, header)
+	}
+	if err := webhook.Verify("whsec_test", header.Get(webhook.SignatureHeader), body, time.Minute, time.Now()); err != nil {
+		t.Errorf("signature: %v", err)
+	}
+
+	if err := NewSlackChannel(endpoint.URL).Send(ctx, notification); err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != `{"text":"*Your account alice has been locked*\nHello alice,\nCall the help desk.\n"}` {
+		t.Errorf("slack posted %s", body)
+	}
+
+	if err := NewTeamsChannel(endpoint.URL).Send(ctx, notification); err != nil {
+		t.Fatal(err)
+	}
+	var card map[string]string
+	json.Unmarshal(body, &card)
+	if card["@type"] != "MessageCard" || card["title"] != notification.Subject || card["text"] != "Hello alice,\n\nCall the help desk.\n\n" {
+		t.Errorf("teams posted %s", body)
+	}
+
+	// Failures name the status, never the URL, which holds the webhook's secret
+	endpoint.Config.Handler = http.NotFoundHandler()
+	err := NewSlackChannel(endpoint.URL+"/T000/B000/secret").Send(ctx, notification)
+	if err == nil || !strings.Contains(err.Error(), "404") || strings.Contains(err.Error(), "secret") {
+		t.Errorf("error %v", err)
+	}
+}
+
+func TestEmailChannel(t *testing.T) {
+	relay := mailtest.NewServer()
+	defer relay.Close()
+
+	channel := &EmailChannel{Mailer: mail.NewSMTPMailer(mail.SMTPConfig{Addr: relay.Addr, From: "pam@example.com"})}
+	notification := &models.Notification{
+		Subject:   "Your account alice has been locked",
+		Body:      "Call the help desk.\n",
+		Recipient: &models.User{Username: "alice", Email: "alice@example.com"},
+	}
+	if err := channel.Send(context.Background(), notification); err != nil {
+		t.Fatal(err)
+	}
+
+	messages := relay.Messages()
+	if len(messages) != 1 || messages[0].To[0] != "alice@example.com" || !strings.Contains(messages[0].Data, "Call the help desk.") {
+		t.Errorf("relayed %+v", messages)
+	}
+
+	notification.Recipient.Email = ""
+	if err := channel.Send(context.Background(), notification); err == nil {
+		t.Error("sent to a user without an email address")
+	}
+}
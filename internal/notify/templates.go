@@ -0,0 +1,121 @@
+package notify
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// defaultTemplates holds the built-in message templates, one file per event
+//
+//go:embed templates/*.tmpl
+var defaultTemplates embed.FS
+
+// templateExt is the extension of template files
+const templateExt = ".tmpl"
+
+// funcs are available to every template
+var funcs = template.FuncMap{
+	"date": formatDate,
+}
+
+// formatDate formats a time, or a pointer to one, for people to read
+func formatDate(value any) string {
+	switch t := value.(type) {
+	case time.Time:
+		return t.UTC().Format(time.RFC1123)
+	case *time.Time:
+		if t != nil {
+			return t.UTC().Format(time.RFC1123)
+		}
+	}
+	return "never"
+}
+
+// Templates renders notification messages. Each event has a template file
+// named after it, <event>.tmpl, defining a "subject" and a "body" template
+// executed with the data passed to Notify.
+type Templates struct {
+	events map[string]*template.Template
+}
+
+// LoadTemplates loads the built-in templates, replaced by or extended with
+// the .tmpl files in dir when dir is not empty
+func LoadTemplates(dir string) (*Templates, error) {
+	t := &Templates{events: map[string]*template.Template{}}
+	if err := t.load(defaultTemplates, "templates"); err != nil {
+		return nil, err
+	}
+	if dir != "" {
+		if err := t.load(os.DirFS(dir), "."); err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+// load parses the template files in a directory of fsys
+func (t *Templates) load(fsys fs.FS, dir string) error {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), templateExt) {
+			continue
+		}
+		event := strings.TrimSuffix(entry.Name(), templateExt)
+
+		text, err := fs.ReadFile(fsys, path.Join(dir, entry.Name()))
+		if err != nil {
+			return err
+		}
+		tmpl, err := template.New(event).Funcs(funcs).Option("missingkey=error").Parse(string(text))
+		if err != nil {
+			return fmt.Errorf("template %s: %w", entry.Name(), err)
+		}
+		for _, name := range []string{"subject", "body"} {
+			if tmpl.Lookup(name) == nil {
+				return fmt.Errorf("template %s does not define %q", entry.Name(), name)
+			}
+		}
+		t.events[event] = tmpl
+	}
+	return nil
+}
+
+// Events lists the events that have a template
+func (t *Templates) Events() []string {
+	events := make([]string, 0, len(t.events))
+	for event := range t.events {
+		events = append(events, event)
+	}
+	slices.Sort(events)
+	return events
+}
+
+// Render executes an event's template, returning the subject and body
+func (t *Templates) Render(event string, data any) (string, string, error) {
+	tmpl, ok := t.events[event]
+	if !ok {
+		return "", "", fmt.Errorf("no template for event %q", event)
+	}
+
+	var subject, body bytes.Buffer
+	if err := tmpl.ExecuteTemplate(&subject, "subject", data); err != nil {
+		return "", "", err
+	}
+	if err := tmpl.ExecuteTemplate(&body, "body", data); err != nil {
+		return "", "", err
+	}
+
+	// Subjects become email headers and chat titles; keep them to one line
+	return strings.Join(strings.Fields(subject.String()), " "), strings.TrimSpace(body.String()) + "\n", nil
+}
@@ -0,0 +1,137 @@
+// Package objectstore keeps write-once blobs in a local directory or an
+// S3-compatible bucket, for data that must live outside the database.
+package objectstore
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+var (
+	// ErrExists is returned when writing a key that is already stored
+	ErrExists = errors.New("objectstore: object already exists")
+	// ErrNotFound is returned when reading a key that is not stored
+	ErrNotFound = errors.New("objectstore: object not found")
+)
+
+// Store holds immutable objects addressed by slash-separated keys
+type Store interface {
+	// Put stores data under key, failing with ErrExists if key is taken
+	Put(key string, data []byte) error
+	// Get returns the data stored under key
+	Get(key string) ([]byte, error)
+	// List returns the sorted keys that start with prefix
+	List(prefix string) ([]string, error)
+}
+
+// validKey rejects keys that could escape the store's root
+func validKey(key string) error {
+	if key == "" || strings.HasPrefix(key, "/") || strings.Contains(key, "\\") {
+		return fmt.Errorf("objectstore: invalid key %q", key)
+	}
+	for _, part := range strings.Split(key, "/") {
+		if part == "" || part == "." || part == ".." {
+			return fmt.Errorf("objectstore: invalid key %q", key)
+		}
+	}
+	return nil
+}
+
+// DirStore keeps objects as files below a local directory
+type DirStore struct {
+	root string
+}
+
+// NewDirStore returns a store rooted at dir, creating it if needed
+func NewDirStore(dir string) (*DirStore, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, fmt.Errorf("objectstore: %w", err)
+	}
+	return &DirStore{root: dir}, nil
+}
+
+// Put writes the object to a temporary file and links it into place, so a
+// reader never sees a partial object and an existing one is never replaced
+func (s *DirStore) Put(key string, data []byte) error {
+	if err := validKey(key); err != nil {
+		return err
+	}
+
+	path := filepath.Join(s.root, filepath.FromSlash(key))
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Link(tmp.Name(), path); err != nil {
+		if errors.Is(err, fs.ErrExist) {
+			return ErrExists
+		}
+		return err
+	}
+	return nil
+}
+
+// Get reads the object stored under key
+func (s *DirStore) Get(key string) ([]byte, error) {
+	if err := validKey(key); err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(filepath.Join(s.root, filepath.FromSlash(key)))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	return data, err
+}
+
+// List walks the directory for keys starting with prefix
+func (s *DirStore) List(prefix string) ([]string, error) {
+	keys := []string{}
+	err := filepath.WalkDir(s.root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || strings.HasPrefix(d.Name(), ".tmp-") {
+			return nil
+		}
+
+		rel, err := filepath.Rel(s.root, path)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Strings(keys)
+	return keys, nil
+}
@@ -0,0 +1,165 @@
+// Package objectstoretest provides an in-process S3-compatible server for tests.
+package objectstoretest
+
+import (
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Server is a minimal path-style S3 endpoint supporting PUT, GET and
+// ListObjectsV2 on any bucket. It checks that requests carry a Signature
+// Version 4 authorization header but does not verify the signature.
+type Server struct {
+	URL string
+
+	// MaxKeys limits the keys returned per list page, to exercise pagination
+	MaxKeys int
+
+	server  *httptest.Server
+	mu      sync.Mutex
+	objects map[string][]byte // bucket/key -> data
+}
+
+// NewServer starts a stub object store on a loopback port
+func NewServer() *Server {
+	s := &Server{MaxKeys: 1000, objects: map[string][]byte{}}
+	s.server = httptest.NewServer(http.HandlerFunc(s.handle))
+	s.URL = s.server.URL
+	return s
+}
+
+// Close stops the server
+func (s *Server) Close() {
+	s.server.Close()
+}
+
+// Object returns a stored object, for tests that inspect or tamper with it
+func (s *Server) Object(bucket, key string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, ok := s.objects[bucket+"/"+key]
+	return data, ok
+}
+
+// SetObject replaces a stored object, bypassing write-once semantics
+func (s *Server) SetObject(bucket, key string, data []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.objects[bucket+"/"+key] = data
+}
+
+// DeleteObject removes a stored object
+func (s *Server) DeleteObject(bucket, key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.objects, bucket+"/"+key)
+}
+
+func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=") ||
+		r.Header.Get("X-Amz-Date") == "" || r.Header.Get("X-Amz-Content-Sha256") == "" {
+		writeError(w, http.StatusForbidden, "AccessDenied", "missing signature")
+		return
+	}
+
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	switch {
+	case r.Method == http.MethodGet && key == "" && r.URL.Query().Get("list-type") == "2":
+		s.list(w, bucket, r.URL.Query().Get("prefix"), r.URL.Query().Get("continuation-token"))
+	case r.Method == http.MethodPut && key != "":
+		s.put(w, r, bucket+"/"+key)
+	case r.Method == http.MethodGet && key != "":
+		s.get(w, bucket+"/"+key)
+	default:
+		writeError(w, http.StatusNotImplemented, "NotImplemented", "unsupported request")
+	}
+}
+
+func (s *Server) put(w http.ResponseWriter, r *http.Request, name string) {
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "IncompleteBody", err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, exists := s.objects[name]; exists && r.Header.Get("If-None-Match") == "*" {
+		writeError(w, http.StatusPreconditionFailed, "PreconditionFailed", "object exists")
+		return
+	}
+	s.objects[name] = data
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) get(w http.ResponseWriter, name string) {
+	s.mu.Lock()
+	data, ok := s.objects[name]
+	s.mu.Unlock()
+
+	if !ok {
+		writeError(w, http.StatusNotFound, "NoSuchKey", "object not found")
+		return
+	}
+	w.Write(data)
+}
+
+func (s *Server) list(w http.ResponseWriter, bucket, prefix, token string) {
+	s.mu.Lock()
+	keys := []string{}
+	for name := range s.objects {
+		if key, found := strings.CutPrefix(name, bucket+"/"); found && strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	s.mu.Unlock()
+	sort.Strings(keys)
+
+	// The continuation token is the offset of the next page
+	start, _ := strconv.Atoi(token)
+	if start > len(keys) {
+		start = len(keys)
+	}
+	end := start + s.MaxKeys
+	if end > len(keys) {
+		end = len(keys)
+	}
+
+	type object struct {
+		Key string `xml:"Key"`
+	}
+	result := struct {
+		XMLName               xml.Name `xml:"ListBucketResult"`
+		Name                  string   `xml:"Name"`
+		Prefix                string   `xml:"Prefix"`
+		Contents              []object `xml:"Contents"`
+		IsTruncated           bool     `xml:"IsTruncated"`
+		NextContinuationToken string   `xml:"NextContinuationToken,omitempty"`
+	}{Name: bucket, Prefix: prefix}
+	for _, key := range keys[start:end] {
+		result.Contents = append(result.Contents, object{Key: key})
+	}
+	if end < len(keys) {
+		result.IsTruncated = true
+		result.NextContinuationToken = strconv.Itoa(end)
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	xml.NewEncoder(w).Encode(result)
+}
+
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	xml.NewEncoder(w).Encode(struct {
+		XMLName xml.Name `xml:"Error"`
+		Code    string   `xml:"Code"`
+		Message string   `xml:"Message"`
+	}{Code: code, Message: message})
+}
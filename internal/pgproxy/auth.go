@@ -0,0 +1,205 @@
+package pgproxy
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// scramMechanism is the only SASL mechanism the proxy speaks upstream;
+// channel binding is not used
+const scramMechanism = "SCRAM-SHA-256"
+
+// authenticateUpstream answers the target's authentication requests with
+// the vaulted password until it accepts or refuses the login. Requests for
+// the password in cleartext or as an MD5 hash are refused unless weakAuth.
+func authenticateUpstream(rw io.ReadWriter, username, password string, weakAuth bool) error {
+	var scram *scramClient
+	for {
+		m, err := readMessage(rw)
+		if err != nil {
+			return err
+		}
+		switch m.Type {
+		case 'E':
+			return errors.New(parseError(m.Body))
+		case 'R':
+		default:
+			return fmt.Errorf("unexpected message %q during authentication", m.Type)
+		}
+		if len(m.Body) < 4 {
+			return errors.New("malformed authentication request")
+		}
+
+		code, data := binary.BigEndian.Uint32(m.Body), m.Body[4:]
+		var reply *message
+		switch code {
+		case authOK:
+			return nil
+		case authCleartextPassword:
+			if !weakAuth {
+				return errors.New("target requests a cleartext password without verified TLS")
+			}
+			reply = passwordMessage(password)
+		case authMD5Password:
+			if !weakAuth {
+				return errors.New("target requests an MD5 password without verified TLS")
+			}
+			if len(data) != 4 {
+				return errors.New("malformed MD5 authentication request")
+			}
+			reply = passwordMessage(md5Password(username, password, data))
+		case authSASL:
+			if !hasMechanism(data, scramMechanism) {
+				return errors.New("target offers no supported SASL mechanism")
+			}
+			scram, err = newSCRAMClient(password)
+			if err != nil {
+				return err
+			}
+			first := scram.clientFirst()
+			body := append([]byte(scramMechanism), 0)
+			body = binary.BigEndian.AppendUint32(body, uint32(len(first)))
+			reply = &message{Type: 'p', Body: append(body, first...)}
+		case authSASLContinue:
+			if scram == nil {
+				return errors.New("unexpected SASL continuation")
+			}
+			final, err := scram.clientFinal(string(data))
+			if err != nil {
+				return err
+			}
+			reply = &message{Type: 'p', Body: []byte(final)}
+		case authSASLFinal:
+			if scram == nil {
+				return errors.New("unexpected SASL outcome")
+			}
+			if err := scram.verifyServer(string(data)); err != nil {
+				return err
+			}
+			continue
+		default:
+			return fmt.Errorf("unsupported authentication method %d", code)
+		}
+
+		if _, err := rw.Write(reply.encode()); err != nil {
+			return err
+		}
+	}
+}
+
+// md5Password computes Postgres' MD5 password response
+func md5Password(username, password string, salt []byte) string {
+	inner := md5.Sum([]byte(password + username))
+	outer := md5.Sum(append([]byte(hex.EncodeToString(inner[:])), salt...))
+	return "md5" + hex.EncodeToString(outer[:])
+}
+
+// hasMechanism reports whether a SASL mechanism list names mechanism
+func hasMechanism(list []byte, mechanism string) bool {
+	for _, name := range bytes.Split(list, []byte{0}) {
+		if string(name) == mechanism {
+			return true
+		}
+	}
+	return false
+}
+
+// scramClient runs the client side of SCRAM-SHA-256 (RFC 5802, RFC 7677)
+type scramClient struct {
+	password    string
+	nonce       string
+	firstBare   string
+	serverProof []byte
+}
+
+func newSCRAMClient(password string) (*scramClient, error) {
+	raw := make([]byte, 18)
+	if _, err := rand.Read(raw); err != nil {
+		return nil, err
+	}
+	return &scramClient{password: password, nonce: base64.StdEncoding.EncodeToString(raw)}, nil
+}
+
+// clientFirst returns the client-first-message. Postgres takes the user
+// from the startup message, so the SCRAM username is left empty.
+func (c *scramClient) clientFirst() string {
+	c.firstBare = "n=,r=" + c.nonce
+	return "n,," + c.firstBare
+}
+
+// clientFinal answers the server-first-message with the client proof
+func (c *scramClient) clientFinal(serverFirst string) (string, error) {
+	var nonce, salt string
+	iterations := 0
+	for _, attribute := range strings.Split(serverFirst, ",") {
+		name, value, _ := strings.Cut(attribute, "=")
+		switch name {
+		case "r":
+			nonce = value
+		case "s":
+			salt = value
+		case "i":
+			iterations, _ = strconv.Atoi(value)
+		}
+	}
+	if !strings.HasPrefix(nonce, c.nonce) || len(nonce) == len(c.nonce) {
+		return "", errors.New("SCRAM server nonce does not extend the client nonce")
+	}
+	saltBytes, err := base64.StdEncoding.DecodeString(salt)
+	if err != nil || len(saltBytes) == 0 {
+		return "", errors.New("invalid SCRAM salt")
+	}
+	if iterations < 1 || iterations > 10_000_000 {
+		return "", errors.New("invalid SCRAM iteration count")
+	}
+
+	salted, err := pbkdf2.Key(sha256.New, c.password, saltBytes, iterations, sha256.Size)
+	if err != nil {
+		return "", err
+	}
+	clientKey := hmacSHA256(salted, "Client Key")
+	storedKey := sha256.Sum256(clientKey)
+
+	withoutProof := "c=biws,r=" + nonce
+	authMessage := c.firstBare + "," + serverFirst + "," + withoutProof
+	signature := hmacSHA256(storedKey[:], authMessage)
+	proof := make([]byte, len(clientKey))
+	for i := range clientKey {
+		proof[i] = clientKey[i] ^ signature[i]
+	}
+	c.serverProof = hmacSHA256(hmacSHA256(salted, "Server Key"), authMessage)
+
+	return withoutProof + ",p=" + base64.StdEncoding.EncodeToString(proof), nil
+}
+
+// verifyServer checks the server-final-message, proving the target knew
+// the password too
+func (c *scramClient) verifyServer(serverFinal string) error {
+	value, ok := strings.CutPrefix(serverFinal, "v=")
+	if !ok {
+		return errors.New("SCRAM authentication failed: " + serverFinal)
+	}
+	signature, err := base64.StdEncoding.DecodeString(value)
+	if err != nil || !hmac.Equal(signature, c.serverProof) {
+		return errors.New("SCRAM server signature mismatch")
+	}
+	return nil
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
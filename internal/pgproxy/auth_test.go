@@ -0,0 +1,45 @@
+package pgproxy
+
+import "testing"
+
+func TestMD5Password(t *testing.T) {
+	if got := md5Password("dbuser", "vaulted", []byte{1, 2, 3, 4}); got != "md5c16786055124838c6b3e8e8c87feedc4" {
+		t.Errorf("md5Password = %q", got)
+	}
+}
+
+func TestSCRAMClient(t *testing.T) {
+	// The exchange from RFC 7677, section 3
+	c := &scramClient{password: "pencil", nonce: "rOprNGfwEbeRWgbNEkqO"}
+	c.clientFirst()
+	c.firstBare = "n=user,r=" + c.nonce
+
+	final, err := c.clientFinal("r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "c=biws,r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,p=dHzbZapWIk4jUhN+Ute9ytag9zjfMHgsqmmiz7AndVQ="; final != want {
+		t.Errorf("client final %q, want %q", final, want)
+	}
+	if err := c.verifyServer("v=6rriTRBi23WpRR/wtup+mMhUZUn/dB5nLTJRsjl95G4="); err != nil {
+		t.Errorf("valid server signature refused: %v", err)
+	}
+	if err := c.verifyServer("v=AAAATRBi23WpRR/wtup+mMhUZUn/dB5nLTJRsjl95G4="); err == nil {
+		t.Error("forged server signature accepted")
+	}
+}
+
+func TestSCRAMClientRejectsBadChallenges(t *testing.T) {
+	c := &scramClient{password: "pencil", nonce: "abc"}
+	c.clientFirst()
+	for _, serverFirst := range []string{
+		"r=xyz123,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096", // Nonce not extended
+		"r=abc,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096",    // Nonce not extended either
+		"r=abc123,s=!!,i=4096",
+		"r=abc123,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=0",
+	} {
+		if _, err := c.clientFinal(serverFirst); err == nil {
+			t.Errorf("accepted %q", serverFirst)
+		}
+	}
+}
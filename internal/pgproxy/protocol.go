@@ -0,0 +1,204 @@
+package pgproxy
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Startup packet codes
+const (
+	protocolVersion = 196608 // 3.0
+	sslRequestCode  = 80877103
+	gssRequestCode  = 80877104
+	cancelCode      = 80877102
+)
+
+// Authentication request codes sent in 'R' messages
+const (
+	authOK                = 0
+	authCleartextPassword = 3
+	authMD5Password       = 5
+	authSASL              = 10
+	authSASLContinue      = 11
+	authSASLFinal         = 12
+)
+
+// SQLSTATE codes reported to clients
+const (
+	codeProtocolViolation   = "08P01"
+	codeConnectionFailure   = "08006"
+	codeInvalidPassword     = "28P01"
+	codeInvalidAuthSpec     = "28000"
+	codeFeatureNotSupported = "0A000"
+)
+
+// maxStartupSize bounds startup packets, as Postgres itself does
+const maxStartupSize = 10000
+
+// maxMessageSize bounds the messages the proxy reads whole: authentication
+// exchanges and the queries it logs. Other messages are streamed.
+const maxMessageSize = 64 << 20
+
+// message is a protocol message: a type byte and its body
+type message struct {
+	Type byte
+	Body []byte
+}
+
+// readStartup reads an untyped startup packet, returning its code and the
+// rest of its body
+func readStartup(r io.Reader) (uint32, []byte, error) {
+	var header [8]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return 0, nil, err
+	}
+	length := binary.BigEndian.Uint32(header[:4])
+	if length < 8 || length > maxStartupSize {
+		return 0, nil, errors.New("invalid startup packet length")
+	}
+	body := make([]byte, length-8)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, nil, err
+	}
+	return binary.BigEndian.Uint32(header[4:]), body, nil
+}
+
+// parseParameters decodes the name and value pairs of a startup message
+func parseParameters(body []byte) (map[string]string, error) {
+	params := map[string]string{}
+	for len(body) > 0 && body[0] != 0 {
+		name, rest, ok := cutString(body)
+		if !ok {
+			return nil, errors.New("malformed startup parameters")
+		}
+		value, rest, ok := cutString(rest)
+		if !ok {
+			return nil, errors.New("malformed startup parameters")
+		}
+		params[name] = value
+		body = rest
+	}
+	return params, nil
+}
+
+// startupMessage encodes a protocol 3.0 startup message
+func startupMessage(params map[string]string, order []string) []byte {
+	body := binary.BigEndian.AppendUint32(nil, protocolVersion)
+	for _, name := range order {
+		body = append(append(append(append(body, name...), 0), params[name]...), 0)
+	}
+	body = append(body, 0)
+	return append(binary.BigEndian.AppendUint32(nil, uint32(len(body)+4)), body...)
+}
+
+// cutString splits a NUL terminated string off the front of b
+func cutString(b []byte) (string, []byte, bool) {
+	i := bytes.IndexByte(b, 0)
+	if i < 0 {
+		return "", nil, false
+	}
+	return string(b[:i]), b[i+1:], true
+}
+
+// readHeader reads a message's type and the length of its body
+func readHeader(r io.Reader) (byte, int, error) {
+	var header [5]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return 0, 0, err
+	}
+	length := binary.BigEndian.Uint32(header[1:])
+	if length < 4 || length > 1<<30 {
+		return 0, 0, fmt.Errorf("invalid message length %d", length)
+	}
+	return header[0], int(length - 4), nil
+}
+
+// readMessage reads a whole message of at most maxMessageSize bytes
+func readMessage(r io.Reader) (*message, error) {
+	msgType, length, err := readHeader(r)
+	if err != nil {
+		return nil, err
+	}
+	if length > maxMessageSize {
+		return nil, fmt.Errorf("message of %d bytes is too large", length)
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+	return &message{Type: msgType, Body: body}, nil
+}
+
+// encode returns the message as sent on the wire
+func (m *message) encode() []byte {
+	out := make([]byte, 5, 5+len(m.Body))
+	out[0] = m.Type
+	binary.BigEndian.PutUint32(out[1:], uint32(len(m.Body)+4))
+	return append(out, m.Body...)
+}
+
+// authMessage builds an 'R' authentication request
+func authMessage(code uint32, data []byte) *message {
+	return &message{Type: 'R', Body: append(binary.BigEndian.AppendUint32(nil, code), data...)}
+}
+
+// errorMessage builds a FATAL ErrorResponse
+func errorMessage(code, text string) *message {
+	var body []byte
+	for _, field := range []struct {
+		tag   byte
+		value string
+	}{{'S', "FATAL"}, {'V', "FATAL"}, {'C', code}, {'M', text}} {
+		body = append(append(append(body, field.tag), field.value...), 0)
+	}
+	return &message{Type: 'E', Body: append(body, 0)}
+}
+
+// parseError extracts the message of an ErrorResponse
+func parseError(body []byte) string {
+	fields := map[byte]string{}
+	for len(body) > 1 {
+		tag := body[0]
+		value, rest, ok := cutString(body[1:])
+		if !ok {
+			break
+		}
+		fields[tag] = value
+		body = rest
+	}
+	if fields['C'] != "" {
+		return fmt.Sprintf("%s (SQLSTATE %s)", fields['M'], fields['C'])
+	}
+	return fields['M']
+}
+
+// passwordMessage builds a 'p' message carrying a NUL terminated password
+func passwordMessage(password string) *message {
+	return &message{Type: 'p', Body: append([]byte(password), 0)}
+}
+
+// queryText extracts the SQL of a simple query ('Q') or a Parse ('P')
+// message, or describes a FunctionCall ('F') by the OID of its function
+func queryText(m *message) (string, bool) {
+	switch m.Type {
+	case 'F':
+		if len(m.Body) < 4 {
+			return "", false
+		}
+		return fmt.Sprintf("function call (OID %d)", binary.BigEndian.Uint32(m.Body)), true
+	case 'Q':
+		query, _, ok := cutString(m.Body)
+		return query, ok
+	case 'P':
+		_, rest, ok := cutString(m.Body) // The statement name
+		if !ok {
+			return "", false
+		}
+		query, _, ok := cutString(rest)
+		return query, ok
+	}
+	return "", false
+}
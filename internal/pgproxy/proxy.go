@@ -0,0 +1,606 @@
+// Package pgproxy is a Postgres protocol proxy: clients log in as
+// user+target with their own mini-pam credentials and are relayed to the
+// target database, which the proxy logs into with the vaulted credential.
+// Every query the client sends is passed to the backend before it is
+// forwarded.
+package pgproxy
+
+import (
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/theshovonaha/mini-pam/internal/models"
+)
+
+// Backend authenticates users, authorizes their targets and logs their
+// queries; the server implements it with the same login throttling and
+// grants as the API
+type Backend interface {
+	// Authenticate verifies the password a client sent for username,
+	// returning the user's ID. The returned error is shown to the client.
+	Authenticate(client Client, username, password string) (userID int, err error)
+	// Authorize resolves the target named at login to a credential the user
+	// may use. The returned error is shown to the client.
+	Authorize(client Client, userID int, target string) (*models.Credential, error)
+	// Opened is called once the upstream login succeeded. It may set conn.ID;
+	// an error refuses the connection.
+	Opened(conn *Conn) error
+	// Query is called with every query, and every function call, before it
+	// is forwarded. An error disconnects the client, so none goes unlogged.
+	Query(conn *Conn, query string) error
+	// Closed is called when a relayed connection ends
+	Closed(conn *Conn)
+}
+
+// Client describes a connecting client before it is authenticated
+type Client struct {
+	RemoteAddr      net.Addr
+	ApplicationName string
+}
+
+// Conn is an authenticated client connection relayed to its target
+type Conn struct {
+	ID              int // Assigned by the backend
+	UserID          int
+	Username        string
+	Credential      *models.Credential
+	Addr            string // Upstream host:port
+	Database        string
+	RemoteAddr      net.Addr
+	ApplicationName string
+	StartedAt       time.Time
+	EndedAt         time.Time
+	Err             error // Why the connection ended, nil for a clean close
+
+	close func() error
+}
+
+// Close disconnects the client, ending the relay. The backend's Closed is
+// still called as for any other disconnect.
+func (c *Conn) Close() error {
+	if c.close == nil {
+		return nil
+	}
+	return c.close()
+}
+
+// Config configures the proxy
+type Config struct {
+	// TLS, when set, is offered to clients, and clients that do not ask
+	// for it are refused since their passwords would cross the network in
+	// the clear
+	TLS *tls.Config
+	// UpstreamTLS, when set, is required of targets. Its ServerName defaults
+	// to the target's host.
+	UpstreamTLS *tls.Config
+	// AllowWeakAuth lets targets not reached over verified TLS ask for the
+	// vaulted password in cleartext or as an MD5 hash, which anyone on the
+	// path, or posing as the target, could then read or replay. Without it
+	// such targets must use SCRAM.
+	AllowWeakAuth bool
+	// DialTimeout bounds connecting and logging in to a target
+	DialTimeout time.Duration
+}
+
+// cancelKey identifies a connection in a CancelRequest
+type cancelKey struct {
+	pid, secret uint32
+}
+
+// cancelTarget is where a client's CancelRequest is forwarded to
+type cancelTarget struct {
+	addr string
+	key  cancelKey // The target's own key
+}
+
+// Proxy accepts Postgres clients and relays them to their targets
+type Proxy struct {
+	backend  Backend
+	config   Config
+	logger   *log.Logger
+	mu       sync.Mutex
+	listener net.Listener
+	conns    map[net.Conn]struct{}
+	cancels  map[cancelKey]cancelTarget
+	wg       sync.WaitGroup
+}
+
+// NewProxy creates a proxy authenticating users through backend
+func NewProxy(backend Backend, config Config, logger *log.Logger) (*Proxy, error) {
+	if config.DialTimeout <= 0 {
+		config.DialTimeout = 10 * time.Second
+	}
+
+	return &Proxy{
+		backend: backend,
+		config:  config,
+		logger:  logger,
+		conns:   map[net.Conn]struct{}{},
+		cancels: map[cancelKey]cancelTarget{},
+	}, nil
+}
+
+// ParseLogin splits a login of the form user+target. Usernames may contain
+// '+' themselves, so the target follows the last one.
+func ParseLogin(login string) (username, target string) {
+	i := strings.LastIndex(login, "+")
+	if i < 0 {
+		return login, ""
+	}
+	return login[:i], login[i+1:]
+}
+
+// ParseSystem splits a credential's system, host[:port][/database], into
+// the address to dial, defaulting to the standard Postgres port, and the
+// database to connect to when the client names none
+func ParseSystem(system string) (addr, database string) {
+	system, database, _ = strings.Cut(system, "/")
+	if _, _, err := net.SplitHostPort(system); err == nil {
+		return system, database
+	}
+	return net.JoinHostPort(strings.Trim(system, "[]"), "5432"), database
+}
+
+// ListenAndServe listens on addr and serves clients until Close is called
+func (p *Proxy) ListenAndServe(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return p.Serve(listener)
+}
+
+// Serve accepts clients on listener until Close is called
+func (p *Proxy) Serve(listener net.Listener) error {
+	p.mu.Lock()
+	p.listener = listener
+	p.mu.Unlock()
+
+	for {
+		netConn, err := listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			defer netConn.Close()
+			if !p.track(netConn) {
+				return
+			}
+			defer p.untrack(netConn)
+			p.handle(netConn)
+		}()
+	}
+}
+
+// Close stops accepting clients, disconnects the connected ones and waits
+// for their relays to finish
+func (p *Proxy) Close() error {
+	p.mu.Lock()
+	var err error
+	if p.listener != nil {
+		err = p.listener.Close()
+	}
+	for conn := range p.conns {
+		conn.Close()
+	}
+	p.conns = nil
+	p.mu.Unlock()
+
+	p.wg.Wait()
+	return err
+}
+
+// track registers a live connection so Close can end it, reporting false if
+// the proxy is already closing
+func (p *Proxy) track(conn net.Conn) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.conns == nil {
+		return false
+	}
+	p.conns[conn] = struct{}{}
+	return true
+}
+
+func (p *Proxy) untrack(conn net.Conn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.conns, conn)
+}
+
+// handle runs one client connection: the startup handshake, the client's
+// login, target authorization, the upstream login and then the relay
+func (p *Proxy) handle(netConn net.Conn) {
+	// Unauthenticated clients get a bounded time to log in
+	netConn.SetDeadline(time.Now().Add(time.Minute))
+
+	client, params, err := p.startup(netConn)
+	if err != nil || client == nil {
+		return
+	}
+	fail := func(code, text string) {
+		client.Write(errorMessage(code, text).encode())
+	}
+
+	login := params["user"]
+	if login == "" {
+		fail(codeInvalidAuthSpec, "no user name given")
+		return
+	}
+	username, target := ParseLogin(login)
+	info := Client{RemoteAddr: netConn.RemoteAddr(), ApplicationName: params["application_name"]}
+
+	// Clients log in with their own password, which the proxy asks for in
+	// the clear; it only ever crosses the network encrypted when TLS is on
+	if _, err := client.Write(authMessage(authCleartextPassword, nil).encode()); err != nil {
+		return
+	}
+	msgType, length, err := readHeader(client)
+	if err != nil {
+		return
+	}
+	if msgType != 'p' || length > maxStartupSize {
+		fail(codeProtocolViolation, "expected a password message")
+		return
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(client, body); err != nil {
+		return
+	}
+	password, _, ok := cutString(body)
+	if !ok {
+		fail(codeProtocolViolation, "malformed password message")
+		return
+	}
+	userID, err := p.backend.Authenticate(info, username, password)
+	if err != nil {
+		fail(codeInvalidPassword, err.Error())
+		return
+	}
+
+	if target == "" {
+		fail(codeInvalidAuthSpec, "no target given, connect as "+username+"+<target>")
+		return
+	}
+	credential, err := p.backend.Authorize(info, userID, target)
+	if err != nil {
+		fail(codeInvalidAuthSpec, err.Error())
+		return
+	}
+
+	addr, database := ParseSystem(credential.System)
+	if name := params["database"]; name != "" && name != login {
+		// Clients default the database to their login name, which means none
+		database = name
+	}
+	if database == "" {
+		database = credential.Username
+	}
+	conn := &Conn{
+		UserID:          userID,
+		Username:        username,
+		Credential:      credential,
+		Addr:            addr,
+		Database:        database,
+		RemoteAddr:      netConn.RemoteAddr(),
+		ApplicationName: info.ApplicationName,
+		StartedAt:       time.Now(),
+		close:           netConn.Close,
+	}
+
+	upstream, greeting, err := p.connect(conn, params)
+	if err != nil {
+		p.logger.Printf("Postgres proxy: connecting %s to %s: %v", username, conn.Addr, err)
+		fail(codeConnectionFailure, "failed to connect to target")
+		return
+	}
+	defer upstream.Close()
+	defer p.forgetCancel(greeting.cancel)
+
+	if err := p.backend.Opened(conn); err != nil {
+		p.logger.Printf("Postgres proxy: starting session for %s: %v", username, err)
+		fail(codeConnectionFailure, "failed to start session")
+		return
+	}
+
+	// The client sees the target's greeting as if it had logged in directly
+	out := authMessage(authOK, nil).encode()
+	for _, m := range greeting.messages {
+		out = append(out, m.encode()...)
+	}
+	if _, err := client.Write(out); err != nil {
+		conn.Err = err
+	} else {
+		netConn.SetDeadline(time.Time{})
+		conn.Err = p.relay(conn, client, upstream)
+	}
+	conn.EndedAt = time.Now()
+	p.backend.Closed(conn)
+}
+
+// startup reads the client's startup packets, answering SSL and GSSAPI
+// negotiation and forwarding cancel requests. It returns the connection to
+// use from then on, TLS wrapped if negotiated, and the startup parameters,
+// or a nil connection when there is nothing more to do.
+func (p *Proxy) startup(netConn net.Conn) (net.Conn, map[string]string, error) {
+	conn, secured := netConn, false
+	for {
+		code, body, err := readStartup(conn)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		switch code {
+		case sslRequestCode:
+			if p.config.TLS == nil || secured {
+				if _, err := conn.Write([]byte{'N'}); err != nil {
+					return nil, nil, err
+				}
+				continue
+			}
+			if _, err := conn.Write([]byte{'S'}); err != nil {
+				return nil, nil, err
+			}
+			conn, secured = tls.Server(netConn, p.config.TLS), true
+		case gssRequestCode:
+			if _, err := conn.Write([]byte{'N'}); err != nil {
+				return nil, nil, err
+			}
+		case cancelCode:
+			if len(body) == 8 {
+				p.cancel(cancelKey{binary.BigEndian.Uint32(body), binary.BigEndian.Uint32(body[4:])})
+			}
+			return nil, nil, nil
+		case protocolVersion:
+			if p.config.TLS != nil && !secured {
+				conn.Write(errorMessage(codeInvalidAuthSpec, "SSL is required").encode())
+				return nil, nil, nil
+			}
+			params, err := parseParameters(body)
+			if err != nil {
+				conn.Write(errorMessage(codeProtocolViolation, err.Error()).encode())
+				return nil, nil, err
+			}
+			return conn, params, nil
+		default:
+			conn.Write(errorMessage(codeFeatureNotSupported, fmt.Sprintf("unsupported frontend protocol %d.%d", code>>16, code&0xffff)).encode())
+			return nil, nil, nil
+		}
+	}
+}
+
+// greeting holds what the target sent after a successful login, up to and
+// including ReadyForQuery, for the client
+type greeting struct {
+	messages []*message
+	cancel   cancelKey // The key given to the client
+}
+
+// connect logs in to the target with the vaulted credential, passing on the
+// client's other startup parameters
+func (p *Proxy) connect(conn *Conn, clientParams map[string]string) (net.Conn, *greeting, error) {
+	if conn.Credential.Secret == "" {
+		return nil, nil, fmt.Errorf("credential %d has no password", conn.Credential.ID)
+	}
+
+	upstream, err := p.dial(conn.Addr)
+	if err != nil {
+		return nil, nil, err
+	}
+	upstream.SetDeadline(time.Now().Add(p.config.DialTimeout))
+
+	params := map[string]string{"user": conn.Credential.Username, "database": conn.Database}
+	order := []string{"user", "database"}
+	for name, value := range clientParams {
+		if name != "user" && name != "database" {
+			params[name] = value
+			order = append(order, name)
+		}
+	}
+	slices.Sort(order[2:])
+	if _, err := upstream.Write(startupMessage(params, order)); err != nil {
+		upstream.Close()
+		return nil, nil, err
+	}
+	weakAuth := p.config.AllowWeakAuth || (p.config.UpstreamTLS != nil && !p.config.UpstreamTLS.InsecureSkipVerify)
+	if err := authenticateUpstream(upstream, conn.Credential.Username, conn.Credential.Secret, weakAuth); err != nil {
+		upstream.Close()
+		return nil, nil, err
+	}
+
+	g := &greeting{}
+	abort := func(err error) (net.Conn, *greeting, error) {
+		upstream.Close()
+		p.forgetCancel(g.cancel)
+		return nil, nil, err
+	}
+	for {
+		m, err := readMessage(upstream)
+		if err != nil {
+			return abort(err)
+		}
+		switch m.Type {
+		case 'E':
+			return abort(errors.New(parseError(m.Body)))
+		case 'K':
+			// Clients get a key of the proxy's own, so cancel requests come
+			// here and the target's key is never exposed
+			if len(m.Body) != 8 {
+				return abort(errors.New("malformed backend key data"))
+			}
+			g.cancel = p.rememberCancel(cancelTarget{
+				addr: conn.Addr,
+				key:  cancelKey{binary.BigEndian.Uint32(m.Body), binary.BigEndian.Uint32(m.Body[4:])},
+			})
+			m.Body = binary.BigEndian.AppendUint32(binary.BigEndian.AppendUint32(nil, g.cancel.pid), g.cancel.secret)
+		}
+		g.messages = append(g.messages, m)
+		if m.Type == 'Z' {
+			upstream.SetDeadline(time.Time{})
+			return upstream, g, nil
+		}
+	}
+}
+
+// dial connects to a target, negotiating TLS when configured
+func (p *Proxy) dial(addr string) (net.Conn, error) {
+	conn, err := net.DialTimeout("tcp", addr, p.config.DialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	if p.config.UpstreamTLS == nil {
+		return conn, nil
+	}
+
+	conn.SetDeadline(time.Now().Add(p.config.DialTimeout))
+	request := binary.BigEndian.AppendUint32(binary.BigEndian.AppendUint32(nil, 8), sslRequestCode)
+	reply := make([]byte, 1)
+	if _, err := conn.Write(request); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if reply[0] != 'S' {
+		conn.Close()
+		return nil, errors.New("target does not support SSL")
+	}
+
+	config := p.config.UpstreamTLS.Clone()
+	if config.ServerName == "" {
+		config.ServerName, _, _ = net.SplitHostPort(addr)
+	}
+	tlsConn := tls.Client(conn, config)
+	if err := tlsConn.Handshake(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	return tlsConn, nil
+}
+
+// rememberCancel registers a target's cancel key, returning the key to give
+// the client in its place
+func (p *Proxy) rememberCancel(target cancelTarget) cancelKey {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for {
+		var raw [8]byte
+		rand.Read(raw[:])
+		key := cancelKey{binary.BigEndian.Uint32(raw[:]), binary.BigEndian.Uint32(raw[4:])}
+		if _, taken := p.cancels[key]; !taken {
+			p.cancels[key] = target
+			return key
+		}
+	}
+}
+
+func (p *Proxy) forgetCancel(key cancelKey) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.cancels, key)
+}
+
+// cancel forwards a client's CancelRequest to the target running its
+// query. Unknown keys are ignored, as Postgres does.
+func (p *Proxy) cancel(key cancelKey) {
+	p.mu.Lock()
+	target, ok := p.cancels[key]
+	p.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	conn, err := p.dial(target.addr)
+	if err != nil {
+		p.logger.Printf("Postgres proxy: forwarding cancel request to %s: %v", target.addr, err)
+		return
+	}
+	defer conn.Close()
+	request := binary.BigEndian.AppendUint32(nil, 16)
+	request = binary.BigEndian.AppendUint32(request, cancelCode)
+	request = binary.BigEndian.AppendUint32(request, target.key.pid)
+	request = binary.BigEndian.AppendUint32(request, target.key.secret)
+	conn.Write(request)
+}
+
+// relay copies messages between client and target until either side
+// disconnects. Queries are passed to the backend on their way upstream;
+// everything else is streamed through untouched.
+func (p *Proxy) relay(conn *Conn, client, upstream net.Conn) error {
+	done := make(chan error, 1)
+	go func() {
+		_, err := io.Copy(client, upstream)
+		done <- err
+	}()
+
+	err := p.forward(conn, client, upstream)
+	client.Close()
+	upstream.Close()
+	<-done
+	return err
+}
+
+// forward copies the client's messages upstream, returning nil when the
+// client terminates the session or disconnects
+func (p *Proxy) forward(conn *Conn, client io.Reader, upstream io.Writer) error {
+	for {
+		msgType, length, err := readHeader(client)
+		if err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+
+		if msgType == 'Q' || msgType == 'P' || msgType == 'F' {
+			if length > maxMessageSize {
+				return fmt.Errorf("query of %d bytes is too large to log", length)
+			}
+			m := &message{Type: msgType, Body: make([]byte, length)}
+			if _, err := io.ReadFull(client, m.Body); err != nil {
+				return err
+			}
+			query, ok := queryText(m)
+			if !ok {
+				return fmt.Errorf("malformed %q message", msgType)
+			}
+			if err := p.backend.Query(conn, query); err != nil {
+				return fmt.Errorf("logging query: %w", err)
+			}
+			if _, err := upstream.Write(m.encode()); err != nil {
+				return err
+			}
+			continue
+		}
+
+		header := binary.BigEndian.AppendUint32([]byte{msgType}, uint32(length+4))
+		if _, err := upstream.Write(header); err != nil {
+			return err
+		}
+		if _, err := io.CopyN(upstream, client, int64(length)); err != nil {
+			return err
+		}
+		if msgType == 'X' {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,424 @@
+package pgproxy
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"database/sql"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	_ "github.com/lib/pq"
+	"github.com/theshovonaha/mini-pam/internal/models"
+)
+
+// fakeTarget is a Postgres server accepting user dbuser with password
+// "vaulted". Queries answer with the database and query text; "sleep"
+// waits until the connection is cancelled.
+type fakeTarget struct {
+	addr     string
+	md5      bool
+	mu       sync.Mutex
+	params   []map[string]string
+	canceled chan struct{}
+}
+
+// The target's own cancel key, which clients should never see
+const targetPID, targetSecret = 42, 4242
+
+func startTarget(t *testing.T, md5 bool) *fakeTarget {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	target := &fakeTarget{addr: listener.Addr().String(), md5: md5, canceled: make(chan struct{}, 1)}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				target.serve(conn)
+			}()
+		}
+	}()
+	return target
+}
+
+func (f *fakeTarget) serve(conn net.Conn) {
+	code, body, err := readStartup(conn)
+	if err != nil {
+		return
+	}
+	if code == cancelCode {
+		if binary.BigEndian.Uint32(body) == targetPID && binary.BigEndian.Uint32(body[4:]) == targetSecret {
+			f.canceled <- struct{}{}
+		}
+		return
+	}
+	params, _ := parseParameters(body)
+	f.mu.Lock()
+	f.params = append(f.params, params)
+	f.mu.Unlock()
+
+	salt := []byte{1, 2, 3, 4}
+	want := "vaulted"
+	if f.md5 {
+		conn.Write(authMessage(authMD5Password, salt).encode())
+		want = md5Password("dbuser", "vaulted", salt)
+	} else {
+		conn.Write(authMessage(authCleartextPassword, nil).encode())
+	}
+	m, err := readMessage(conn)
+	if err != nil {
+		return
+	}
+	if password, _, _ := cutString(m.Body); params["user"] != "dbuser" || password != want {
+		conn.Write(errorMessage(codeInvalidPassword, "password authentication failed").encode())
+		return
+	}
+
+	out := authMessage(authOK, nil).encode()
+	out = append(out, (&message{Type: 'S', Body: []byte("server_version\x0014.0\x00")}).encode()...)
+	key := binary.BigEndian.AppendUint32(binary.BigEndian.AppendUint32(nil, targetPID), targetSecret)
+	out = append(out, (&message{Type: 'K', Body: key}).encode()...)
+	out = append(out, (&message{Type: 'Z', Body: []byte{'I'}}).encode()...)
+	conn.Write(out)
+
+	for {
+		m, err := readMessage(conn)
+		if err != nil || m.Type == 'X' {
+			return
+		}
+		query, _ := queryText(m)
+		var reply []byte
+		if query == "sleep" {
+			select {
+			case <-f.canceled:
+				reply = errorMessage("57014", "canceling statement due to user request").encode()
+			case <-time.After(5 * time.Second):
+				reply = errorMessage("57014", "never canceled").encode()
+			}
+		} else {
+			column := append([]byte("result\x00"), make([]byte, 18)...)
+			binary.BigEndian.PutUint32(column[13:], 25) // text
+			binary.BigEndian.PutUint16(column[17:], 0xffff)
+			row := params["database"] + ":" + query
+			data := binary.BigEndian.AppendUint32(binary.BigEndian.AppendUint16(nil, 1), uint32(len(row)))
+			reply = (&message{Type: 'T', Body: append(binary.BigEndian.AppendUint16(nil, 1), column...)}).encode()
+			reply = append(reply, (&message{Type: 'D', Body: append(data, row...)}).encode()...)
+			reply = append(reply, (&message{Type: 'C', Body: []byte("SELECT 1\x00")}).encode()...)
+		}
+		conn.Write(append(reply, (&message{Type: 'Z', Body: []byte{'I'}}).encode()...))
+	}
+}
+
+// fakeBackend grants alice (password "secret") the credentials in targets
+// and logs her queries
+type fakeBackend struct {
+	targets map[string]*models.Credential
+	mu      sync.Mutex
+	queries []string
+	closed  chan *Conn
+}
+
+func (b *fakeBackend) Authenticate(client Client, username, password string) (int, error) {
+	if username != "alice" || password != "secret" {
+		return 0, errors.New("login failed")
+	}
+	return 7, nil
+}
+
+func (b *fakeBackend) Authorize(client Client, userID int, target string) (*models.Credential, error) {
+	credential, ok := b.targets[target]
+	if !ok {
+		return nil, errors.New("credential not found")
+	}
+	return credential, nil
+}
+
+func (b *fakeBackend) Opened(conn *Conn) error {
+	conn.ID = 99
+	return nil
+}
+
+func (b *fakeBackend) Query(conn *Conn, query string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.queries = append(b.queries, query)
+	return nil
+}
+
+func (b *fakeBackend) Closed(conn *Conn) {
+	b.closed <- conn
+}
+
+func startProxy(t *testing.T, backend Backend, config Config) string {
+	t.Helper()
+	proxy, err := NewProxy(backend, config, log.New(io.Discard, "", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go proxy.Serve(listener)
+	t.Cleanup(func() { proxy.Close() })
+	return listener.Addr().String()
+}
+
+func openDB(t *testing.T, proxyAddr, user, password, options string) *sql.DB {
+	t.Helper()
+	host, port, _ := net.SplitHostPort(proxyAddr)
+	db, err := sql.Open("postgres", fmt.Sprintf("host=%s port=%s user=%s password=%s application_name=psql %s", host, port, user, password, options))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestProxyRelaysQueries(t *testing.T) {
+	for _, md5 := range []bool{false, true} {
+		target := startTarget(t, md5)
+		backend := &fakeBackend{
+			targets: map[string]*models.Credential{"pg": {ID: 1, Type: "database", Username: "dbuser", Secret: "vaulted", System: target.addr + "/appdb"}},
+			closed:  make(chan *Conn, 1),
+		}
+		proxyAddr := startProxy(t, backend, Config{AllowWeakAuth: true})
+
+		db := openDB(t, proxyAddr, "alice+pg", "secret", "sslmode=disable")
+		var result string
+		if err := db.QueryRow("SELECT now()").Scan(&result); err != nil {
+			t.Fatal(err)
+		}
+		if result != "appdb:SELECT now()" {
+			t.Errorf("result %q", result)
+		}
+		db.Close()
+
+		conn := <-backend.closed
+		if conn.ID != 99 || conn.UserID != 7 || conn.Username != "alice" || conn.Database != "appdb" || conn.ApplicationName != "psql" || conn.Err != nil {
+			t.Errorf("closed conn %+v", conn)
+		}
+		if len(backend.queries) != 1 || backend.queries[0] != "SELECT now()" {
+			t.Errorf("logged queries %q", backend.queries)
+		}
+
+		// The vaulted login replaces the client's; other parameters pass through
+		params := target.params[0]
+		if params["user"] != "dbuser" || params["database"] != "appdb" || params["application_name"] != "psql" {
+			t.Errorf("target saw parameters %v", params)
+		}
+	}
+}
+
+func TestProxyPassesDatabase(t *testing.T) {
+	target := startTarget(t, false)
+	backend := &fakeBackend{
+		targets: map[string]*models.Credential{"pg": {ID: 1, Type: "database", Username: "dbuser", Secret: "vaulted", System: target.addr}},
+		closed:  make(chan *Conn, 2),
+	}
+	proxyAddr := startProxy(t, backend, Config{AllowWeakAuth: true})
+
+	// Without a database anywhere the target defaults to the credential's user
+	var result string
+	if err := openDB(t, proxyAddr, "alice+pg", "secret", "sslmode=disable").QueryRow("SELECT 1").Scan(&result); err != nil {
+		t.Fatal(err)
+	}
+	if result != "dbuser:SELECT 1" {
+		t.Errorf("result %q", result)
+	}
+	if err := openDB(t, proxyAddr, "alice+pg", "secret", "sslmode=disable dbname=reports").QueryRow("SELECT 1").Scan(&result); err != nil {
+		t.Fatal(err)
+	}
+	if result != "reports:SELECT 1" {
+		t.Errorf("result %q", result)
+	}
+}
+
+func TestProxyRefusals(t *testing.T) {
+	target := startTarget(t, false)
+	backend := &fakeBackend{
+		targets: map[string]*models.Credential{
+			"pg":     {ID: 1, Type: "database", Username: "dbuser", Secret: "vaulted", System: target.addr},
+			"broken": {ID: 2, Type: "database", Username: "dbuser", Secret: "wrong", System: target.addr},
+		},
+		closed: make(chan *Conn, 1),
+	}
+	proxyAddr := startProxy(t, backend, Config{AllowWeakAuth: true})
+
+	for _, tt := range []struct {
+		user, password, want string
+	}{
+		{"alice+pg", "wrong", "login failed"},
+		{"alice", "secret", "no target given, connect as alice+<target>"},
+		{"alice+missing", "secret", "credential not found"},
+		{"alice+broken", "secret", "failed to connect to target"},
+	} {
+		err := openDB(t, proxyAddr, tt.user, tt.password, "sslmode=disable").Ping()
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: got %v, want %q", tt.user, err, tt.want)
+		}
+	}
+	if len(backend.queries) != 0 {
+		t.Errorf("logged queries %q", backend.queries)
+	}
+}
+
+func TestProxyRefusesWeakAuth(t *testing.T) {
+	// Without verified TLS the vaulted password is only given to SCRAM
+	for _, md5 := range []bool{false, true} {
+		target := startTarget(t, md5)
+		backend := &fakeBackend{
+			targets: map[string]*models.Credential{"pg": {ID: 1, Type: "database", Username: "dbuser", Secret: "vaulted", System: target.addr}},
+			closed:  make(chan *Conn, 1),
+		}
+		proxyAddr := startProxy(t, backend, Config{})
+
+		err := openDB(t, proxyAddr, "alice+pg", "secret", "sslmode=disable").Ping()
+		if err == nil || !strings.Contains(err.Error(), "failed to connect to target") {
+			t.Errorf("md5 %v: %v", md5, err)
+		}
+	}
+}
+
+func TestProxyForwardsCancel(t *testing.T) {
+	target := startTarget(t, false)
+	backend := &fakeBackend{
+		targets: map[string]*models.Credential{"pg": {ID: 1, Type: "database", Username: "dbuser", Secret: "vaulted", System: target.addr}},
+		closed:  make(chan *Conn, 1),
+	}
+	proxyAddr := startProxy(t, backend, Config{AllowWeakAuth: true})
+	db := openDB(t, proxyAddr, "alice+pg", "secret", "sslmode=disable")
+	if err := db.Ping(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The client cancels with the proxy's key, which the proxy translates
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := db.ExecContext(ctx, "sleep")
+	if err == nil {
+		t.Fatal("expected the query to be canceled")
+	}
+	if time.Since(start) > 3*time.Second {
+		t.Errorf("cancel request was not forwarded: %v", err)
+	}
+}
+
+func TestProxyRequiresTLS(t *testing.T) {
+	target := startTarget(t, false)
+	backend := &fakeBackend{
+		targets: map[string]*models.Credential{"pg": {ID: 1, Type: "database", Username: "dbuser", Secret: "vaulted", System: target.addr}},
+		closed:  make(chan *Conn, 1),
+	}
+	proxyAddr := startProxy(t, backend, Config{TLS: &tls.Config{Certificates: []tls.Certificate{selfSigned(t)}}, AllowWeakAuth: true})
+
+	if err := openDB(t, proxyAddr, "alice+pg", "secret", "sslmode=disable").Ping(); err == nil || !strings.Contains(err.Error(), "SSL is required") {
+		t.Errorf("plain connection: %v", err)
+	}
+	if err := openDB(t, proxyAddr, "alice+pg", "secret", "sslmode=require").Ping(); err != nil {
+		t.Errorf("TLS connection: %v", err)
+	}
+}
+
+// TestProxyAgainstPostgres relays to a real server, such as the one in
+// docker-compose.yml: PGPROXY_TEST_TARGET=127.0.0.1:5432 go test ./internal/pgproxy
+func TestProxyAgainstPostgres(t *testing.T) {
+	addr := os.Getenv("PGPROXY_TEST_TARGET")
+	if addr == "" {
+		t.Skip("PGPROXY_TEST_TARGET is not set")
+	}
+	backend := &fakeBackend{
+		targets: map[string]*models.Credential{"pg": {ID: 1, Type: "database", Username: "postgres", Secret: "postgres", System: addr + "/securevault"}},
+		closed:  make(chan *Conn, 1),
+	}
+	db := openDB(t, startProxy(t, backend, Config{}), "alice+pg", "secret", "sslmode=disable")
+
+	var user, database string
+	if err := db.QueryRow("SELECT current_user, current_database()").Scan(&user, &database); err != nil {
+		t.Fatal(err)
+	}
+	if user != "postgres" || database != "securevault" {
+		t.Errorf("connected as %s to %s", user, database)
+	}
+	var sum int
+	if err := db.QueryRow("SELECT $1::int + 1", 41).Scan(&sum); err != nil || sum != 42 {
+		t.Errorf("extended query: %d, %v", sum, err)
+	}
+	db.Close()
+	<-backend.closed
+	if len(backend.queries) != 2 || backend.queries[1] != "SELECT $1::int + 1" {
+		t.Errorf("logged queries %q", backend.queries)
+	}
+}
+
+func selfSigned(t *testing.T) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{"localhost"},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestParseSystem(t *testing.T) {
+	for _, tt := range []struct{ system, addr, database string }{
+		{"db.example.com", "db.example.com:5432", ""},
+		{"db.example.com:6432/app", "db.example.com:6432", "app"},
+		{"[::1]/app", "[::1]:5432", "app"},
+	} {
+		if addr, database := ParseSystem(tt.system); addr != tt.addr || database != tt.database {
+			t.Errorf("ParseSystem(%q) = %q, %q", tt.system, addr, database)
+		}
+	}
+}
+
+func TestQueryText(t *testing.T) {
+	for _, tt := range []struct {
+		m     *message
+		query string
+		ok    bool
+	}{
+		{&message{Type: 'Q', Body: []byte("SELECT 1\x00")}, "SELECT 1", true},
+		{&message{Type: 'P', Body: []byte("stmt\x00SELECT $1\x00\x00\x00")}, "SELECT $1", true},
+		{&message{Type: 'F', Body: binary.BigEndian.AppendUint32(nil, 764)}, "function call (OID 764)", true},
+		{&message{Type: 'F', Body: []byte{0, 1}}, "", false},
+		{&message{Type: 'Q', Body: []byte("SELECT 1")}, "", false},
+	} {
+		if query, ok := queryText(tt.m); query != tt.query || ok != tt.ok {
+			t.Errorf("queryText(%c %q) = %q, %v", tt.m.Type, tt.m.Body, query, ok)
+		}
+	}
+}
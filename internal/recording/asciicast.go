@@ -0,0 +1,201 @@
+// Package recording captures relayed terminal sessions as asciicast v2
+// files, which standard players such as asciinema play back, and keeps
+// them encrypted in an object store
+package recording
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// ContentType is the media type of asciicast v2 recordings
+const ContentType = "application/x-asciicast"
+
+// DefaultMaxBytes bounds a recording held in memory; output beyond it is
+// dropped and the recording marked truncated
+const DefaultMaxBytes = 64 << 20
+
+// Header is the first line of an asciicast v2 file
+type Header struct {
+	Version   int               `json:"version"`
+	Width     int               `json:"width"`
+	Height    int               `json:"height"`
+	Timestamp int64             `json:"timestamp"`
+	Duration  float64           `json:"duration,omitempty"`
+	Command   string            `json:"command,omitempty"`
+	Title     string            `json:"title,omitempty"`
+	Env       map[string]string `json:"env,omitempty"`
+}
+
+// Recorder builds an asciicast v2 recording and a plain text transcript of
+// a session's output. It is safe for concurrent use.
+type Recorder struct {
+	mu          sync.Mutex
+	header      Header
+	start       time.Time
+	events      bytes.Buffer
+	text        *Transcript
+	recordInput bool
+	maxBytes    int
+	truncated   bool
+	pending     map[string][]byte // Partial UTF-8 sequences per event type
+	now         func() time.Time
+}
+
+// NewRecorder starts a recording titled title. Keystrokes are only kept
+// when recordInput is set, since they include anything typed at prompts.
+func NewRecorder(title string, recordInput bool, maxBytes int) *Recorder {
+	if maxBytes <= 0 {
+		maxBytes = DefaultMaxBytes
+	}
+	now := time.Now()
+	return &Recorder{
+		header: Header{
+			Version:   2,
+			Width:     80,
+			Height:    24,
+			Timestamp: now.Unix(),
+			Title:     title,
+		},
+		start:       now,
+		text:        NewTranscript(DefaultTranscriptBytes),
+		recordInput: recordInput,
+		maxBytes:    maxBytes,
+		pending:     map[string][]byte{},
+		now:         time.Now,
+	}
+}
+
+// Pty records the terminal the client requested
+func (r *Recorder) Pty(term string, columns, rows int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if columns > 0 && rows > 0 {
+		if r.events.Len() == 0 {
+			r.header.Width, r.header.Height = columns, rows
+		} else {
+			r.event("r", []byte(strconv.Itoa(columns)+"x"+strconv.Itoa(rows)))
+		}
+	}
+	if term != "" {
+		r.header.Env = map[string]string{"TERM": term}
+	}
+}
+
+// Resize records a change of terminal size
+func (r *Recorder) Resize(columns, rows int) {
+	if columns <= 0 || rows <= 0 {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.event("r", []byte(strconv.Itoa(columns)+"x"+strconv.Itoa(rows)))
+}
+
+// Exec records the command of a non-interactive session
+func (r *Recorder) Exec(command string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.header.Command = command
+}
+
+// Output records data written to the client's terminal
+func (r *Recorder) Output(data []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.text.Write(data)
+	r.event("o", data)
+}
+
+// Input records data typed by the client, if input recording is enabled
+func (r *Recorder) Input(data []byte) {
+	if !r.recordInput {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.event("i", data)
+}
+
+// event appends an event line. asciicast stores data as JSON strings, so a
+// multi-byte character split across writes is held back until complete.
+func (r *Recorder) event(kind string, data []byte) {
+	if r.truncated {
+		return
+	}
+
+	data = append(r.pending[kind], data...)
+	cut := len(data)
+	for i := 1; i < utf8.UTFMax && i <= len(data); i++ {
+		if utf8.RuneStart(data[len(data)-i]) {
+			if !utf8.FullRune(data[len(data)-i:]) {
+				cut = len(data) - i
+			}
+			break
+		}
+	}
+	r.pending[kind] = append([]byte(nil), data[cut:]...)
+	if cut == 0 {
+		return
+	}
+
+	elapsed := r.now().Sub(r.start).Seconds()
+	line, err := json.Marshal([]interface{}{roundSeconds(elapsed), kind, string(data[:cut])})
+	if err != nil {
+		return
+	}
+	if r.events.Len()+len(line)+1 > r.maxBytes {
+		r.truncated = true
+		return
+	}
+	r.events.Write(line)
+	r.events.WriteByte('\n')
+}
+
+// roundSeconds keeps event times to the microsecond, as asciinema does
+func roundSeconds(seconds float64) float64 {
+	return float64(int64(seconds*1e6)) / 1e6
+}
+
+// Finish completes the recording, returning the asciicast file and the
+// plain text transcript of its output
+func (r *Recorder) Finish() (*Recording, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	header := r.header
+	header.Duration = roundSeconds(r.now().Sub(r.start).Seconds())
+	line, err := json.Marshal(header)
+	if err != nil {
+		return nil, err
+	}
+
+	var cast bytes.Buffer
+	cast.Grow(len(line) + 1 + r.events.Len())
+	cast.Write(line)
+	cast.WriteByte('\n')
+	cast.Write(r.events.Bytes())
+
+	return &Recording{
+		Cast:          cast.Bytes(),
+		Text:          r.text.String(),
+		InputRecorded: r.recordInput,
+		Truncated:     r.truncated,
+	}, nil
+}
+
+// Recording is a finished session recording
+type Recording struct {
+	Cast          []byte // asciicast v2 file
+	Text          string // Output without terminal control sequences, for search
+	InputRecorded bool
+	Truncated     bool
+}
@@ -0,0 +1,163 @@
+package recording
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/theshovonaha/mini-pam/internal/objectstore"
+)
+
+// parseCast splits an asciicast file into its header and events
+func parseCast(t *testing.T, cast []byte) (Header, [][]interface{}) {
+	t.Helper()
+	scanner := bufio.NewScanner(bytes.NewReader(cast))
+	var header Header
+	events := [][]interface{}{}
+	for first := true; scanner.Scan(); first = false {
+		if first {
+			if err := json.Unmarshal(scanner.Bytes(), &header); err != nil {
+				t.Fatalf("header: %v", err)
+			}
+			continue
+		}
+		var event []interface{}
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil || len(event) != 3 {
+			t.Fatalf("event %q: %v", scanner.Text(), err)
+		}
+		events = append(events, event)
+	}
+	return header, events
+}
+
+func TestRecorder(t *testing.T) {
+	recorder := NewRecorder("alice@web01", false, 0)
+	now := recorder.start
+	recorder.now = func() time.Time { return now }
+
+	recorder.Pty("xterm-256color", 120, 40)
+	now = now.Add(500 * time.Millisecond)
+	recorder.Output([]byte("\x1b[1mhello\x1b[0m \xe2\x82"))
+	recorder.Input([]byte("secret\r"))
+	now = now.Add(time.Second)
+	recorder.Output([]byte("\xac\r\n"))
+	recorder.Resize(100, 30)
+
+	recording, err := recorder.Finish()
+	if err != nil {
+		t.Fatal(err)
+	}
+	header, events := parseCast(t, recording.Cast)
+	if header.Version != 2 || header.Width != 120 || header.Height != 40 || header.Env["TERM"] != "xterm-256color" ||
+		header.Title != "alice@web01" || header.Duration != 1.5 {
+		t.Errorf("header: %+v", header)
+	}
+
+	// The euro sign split across writes is emitted whole, and no input is kept
+	want := [][]interface{}{
+		{0.5, "o", "\x1b[1mhello\x1b[0m "},
+		{1.5, "o", "€\r\n"},
+		{1.5, "r", "100x30"},
+	}
+	if len(events) != len(want) {
+		t.Fatalf("events: %v", events)
+	}
+	for i := range want {
+		for j := range want[i] {
+			if events[i][j] != want[i][j] {
+				t.Errorf("event %d: got %v, want %v", i, events[i], want[i])
+			}
+		}
+	}
+	if recording.Text != "hello €\n" || recording.InputRecorded || recording.Truncated {
+		t.Errorf("recording: %+v", recording)
+	}
+}
+
+func TestRecorderInputAndLimit(t *testing.T) {
+	recorder := NewRecorder("", true, 100)
+	recorder.Input([]byte("ls\r"))
+	recorder.Output([]byte(strings.Repeat("x", 200)))
+	recorder.Output([]byte("more"))
+
+	recording, _ := recorder.Finish()
+	_, events := parseCast(t, recording.Cast)
+	if len(events) != 1 || events[0][1] != "i" || events[0][2] != "ls\r" || !recording.Truncated {
+		t.Errorf("events %v, truncated %v", events, recording.Truncated)
+	}
+	// The transcript has its own limit and keeps going
+	if !strings.HasSuffix(recording.Text, "xmore") {
+		t.Errorf("transcript %q", recording.Text)
+	}
+}
+
+func TestTranscript(t *testing.T) {
+	transcript := NewTranscript(1024)
+	for _, chunk := range []string{
+		"\x1b]0;root@web01: ~\x07root@web01:~# ",
+		"ls -l\x1b[K\r\n",
+		"\x1b[01;34mbin\x1b[0m  etc\r\n",
+		"typo\b\bpo \x1b[",
+		"2Jcleared\x1b]8;;http://x\x1b\\link\x1b]8;;\x1b\\\r\n",
+	} {
+		transcript.Write([]byte(chunk))
+	}
+
+	want := "root@web01:~# ls -l\nbin  etc\ntypo clearedlink\n"
+	if got := transcript.String(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestStore(t *testing.T) {
+	objects, err := objectstore.NewDirStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := bytes.Repeat([]byte{7}, KeySize)
+	store, err := NewStore(objects, "recordings/", key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cast := []byte("{\"version\":2}\n[0.1,\"o\",\"secret output\"]\n")
+	name, sum, err := store.Save(42, cast)
+	if err != nil || name != "recordings/session-0000000042.cast.enc" {
+		t.Fatalf("Save = %q, %v", name, err)
+	}
+
+	sealed, _ := objects.Get(name)
+	if bytes.Contains(sealed, []byte("secret output")) {
+		t.Error("recording stored in plaintext")
+	}
+	if got, err := store.Load(name, sum); err != nil || !bytes.Equal(got, cast) {
+		t.Fatalf("Load = %q, %v", got, err)
+	}
+	if _, err := store.Load(name, strings.Repeat("0", 64)); err == nil {
+		t.Error("expected a checksum mismatch")
+	}
+
+	other, _ := NewStore(objects, "recordings/", bytes.Repeat([]byte{8}, KeySize))
+	if _, err := other.Load(name, sum); err == nil {
+		t.Error("expected decryption with the wrong key to fail")
+	}
+
+	// A recording copied under another session's key does not decrypt
+	objects.Put("recordings/session-0000000043.cast.enc", sealed)
+	if _, err := store.Load("recordings/session-0000000043.cast.enc", sum); err == nil {
+		t.Error("expected a moved recording to fail")
+	}
+}
+
+func TestParseKey(t *testing.T) {
+	hexKey := strings.Repeat("ab", KeySize)
+	if key, err := ParseKey(hexKey); err != nil || key[0] != 0xab {
+		t.Errorf("hex key: %v", err)
+	}
+	if _, err := ParseKey("c2hvcnQ="); err == nil {
+		t.Error("expected a short key to be rejected")
+	}
+}
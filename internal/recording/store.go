@@ -0,0 +1,112 @@
+package recording
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/theshovonaha/mini-pam/internal/objectstore"
+)
+
+// KeySize is the length of the AES-256 key recordings are encrypted with
+const KeySize = 32
+
+// magic prefixes encrypted recordings so the format can evolve
+var magic = []byte("mpcast1\n")
+
+// LoadKey reads a recording key file holding 32 bytes as hex or base64
+func LoadKey(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseKey(strings.TrimSpace(string(data)))
+}
+
+// ParseKey decodes a 32 byte key given as hex or base64
+func ParseKey(encoded string) ([]byte, error) {
+	if key, err := hex.DecodeString(encoded); err == nil && len(key) == KeySize {
+		return key, nil
+	}
+	if key, err := base64.StdEncoding.DecodeString(encoded); err == nil && len(key) == KeySize {
+		return key, nil
+	}
+	return nil, fmt.Errorf("recording: key must be %d bytes in hex or base64", KeySize)
+}
+
+// Store keeps recordings encrypted with AES-256-GCM in an object store
+type Store struct {
+	objects objectstore.Store
+	prefix  string
+	aead    cipher.AEAD
+}
+
+// NewStore returns a store writing below prefix in objects
+func NewStore(objects objectstore.Store, prefix string, key []byte) (*Store, error) {
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("recording: key must be %d bytes", KeySize)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &Store{objects: objects, prefix: prefix, aead: aead}, nil
+}
+
+// Save encrypts and stores the recording of session id, returning the
+// object key and the SHA-256 of the plaintext for integrity checks
+func (s *Store) Save(id int, cast []byte) (key, sum string, err error) {
+	key = fmt.Sprintf("%ssession-%010d.cast.enc", s.prefix, id)
+
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", "", err
+	}
+
+	// The key is bound as additional data so a recording cannot be passed
+	// off as another session's by renaming the object
+	sealed := append(append([]byte(nil), magic...), nonce...)
+	sealed = s.aead.Seal(sealed, nonce, cast, []byte(key))
+	if err := s.objects.Put(key, sealed); err != nil {
+		return "", "", err
+	}
+
+	digest := sha256.Sum256(cast)
+	return key, hex.EncodeToString(digest[:]), nil
+}
+
+// Load fetches and decrypts a recording, checking it against sum
+func (s *Store) Load(key, sum string) ([]byte, error) {
+	sealed, err := s.objects.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.HasPrefix(sealed, magic) || len(sealed) < len(magic)+s.aead.NonceSize() {
+		return nil, errors.New("recording: not an encrypted recording")
+	}
+	sealed = sealed[len(magic):]
+
+	nonce, ciphertext := sealed[:s.aead.NonceSize()], sealed[s.aead.NonceSize():]
+	cast, err := s.aead.Open(nil, nonce, ciphertext, []byte(key))
+	if err != nil {
+		return nil, errors.New("recording: decryption failed")
+	}
+
+	digest := sha256.Sum256(cast)
+	if hex.EncodeToString(digest[:]) != sum {
+		return nil, errors.New("recording: checksum mismatch")
+	}
+	return cast, nil
+}
@@ -0,0 +1,97 @@
+package recording
+
+import (
+	"bytes"
+	"strings"
+)
+
+// DefaultTranscriptBytes bounds the searchable text kept per session
+const DefaultTranscriptBytes = 1 << 20
+
+// Escape sequence parser states
+const (
+	stateText = iota
+	stateEscape
+	stateCSI
+	stateString       // OSC, DCS and friends, ended by BEL or ST
+	stateStringEscape // ESC seen inside a string, expecting '\'
+)
+
+// Transcript reduces terminal output to plain text by dropping escape
+// sequences and control characters, for indexing rather than display
+type Transcript struct {
+	buf   bytes.Buffer
+	state int
+	max   int
+}
+
+// NewTranscript returns a transcript keeping at most max bytes of text
+func NewTranscript(max int) *Transcript {
+	return &Transcript{max: max}
+}
+
+// Write feeds terminal output; sequences may be split across writes
+func (t *Transcript) Write(p []byte) (int, error) {
+	for _, c := range p {
+		switch t.state {
+		case stateEscape:
+			switch c {
+			case '[':
+				t.state = stateCSI
+			case ']', 'P', 'X', '^', '_':
+				t.state = stateString
+			default:
+				// Two-byte sequences such as ESC 7 or ESC =; character set
+				// selections leave one harmless printable byte behind
+				t.state = stateText
+			}
+		case stateCSI:
+			// Parameters and intermediates run until a final byte
+			if c >= 0x40 && c <= 0x7e {
+				t.state = stateText
+			}
+		case stateString:
+			switch c {
+			case 0x07:
+				t.state = stateText
+			case 0x1b:
+				t.state = stateStringEscape
+			}
+		case stateStringEscape:
+			if c == '\\' {
+				t.state = stateText
+			} else {
+				t.state = stateString
+			}
+		default:
+			t.text(c)
+		}
+	}
+	return len(p), nil
+}
+
+// text handles a byte outside any escape sequence
+func (t *Transcript) text(c byte) {
+	switch {
+	case c == 0x1b:
+		t.state = stateEscape
+	case c == '\b':
+		if n := t.buf.Len(); n > 0 && t.buf.Bytes()[n-1] != '\n' {
+			t.buf.Truncate(n - 1)
+		}
+	case c == '\n' || c == '\t' || c >= 0x20 && c != 0x7f:
+		if t.buf.Len() < t.max {
+			t.buf.WriteByte(c)
+		}
+	}
+	// Carriage returns and other control characters carry no text
+}
+
+// String returns the text so far, with trailing spaces trimmed per line
+func (t *Transcript) String() string {
+	lines := strings.Split(t.buf.String(), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " \t")
+	}
+	return strings.ToValidUTF8(strings.Join(lines, "\n"), "")
+}
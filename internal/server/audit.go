@@ -0,0 +1,71 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/theshovonaha/mini-pam/internal/audit"
+	"github.com/theshovonaha/mini-pam/internal/models"
+	"github.com/theshovonaha/mini-pam/internal/siem"
+	"github.com/theshovonaha/mini-pam/internal/webhook"
+)
+
+// audit records an audit entry. The actor, the session or API key it acted
+// through and the request metadata are taken from the request; handlers
+// only describe the action and its target. Entries for unauthenticated
+// requests keep whatever UserID the handler attributed them to.
+func (s *Server) audit(r *http.Request, entry *models.AuditLog) {
+	if principal := s.contextGetPrincipal(r); principal != nil {
+		entry.UserID = principal.UserID
+		entry.ServiceAccountID = principal.ServiceAccountID
+		if principal.Session != nil {
+			entry.SessionID = principal.Session.ID
+		}
+		if principal.APIKey != nil {
+			entry.APIKeyID = principal.APIKey.ID
+		}
+	}
+	entry.IPAddress = clientIP(r)
+	entry.UserAgent = r.UserAgent()
+
+	s.record(entry)
+}
+
+// record persists a complete audit entry and publishes it to the SIEM and
+// webhook subscribers. Callers outside an HTTP request, such as the SSH
+// proxy, fill in the actor and source themselves.
+func (s *Server) record(entry *models.AuditLog) {
+	if err := s.models.AuditLogs.Create(entry); err != nil {
+		s.logger.Printf("Error creating audit log: %v", err)
+		return
+	}
+	s.config.Events.Emit(siem.FromAuditLog(entry))
+	if event, ok := webhook.FromAuditLog(entry); ok {
+		s.enqueueWebhook(event)
+	}
+}
+
+// enqueueWebhook queues an event for every subscription listening for it.
+// Failures are logged rather than surfaced; the action itself succeeded.
+func (s *Server) enqueueWebhook(event *webhook.Event) {
+	payload, err := event.Payload()
+	if err != nil {
+		s.logger.Printf("Error encoding webhook event: %v", err)
+		return
+	}
+	if _, err := s.models.Webhooks.Enqueue(event.Type, payload); err != nil {
+		s.logger.Printf("Error queueing webhook event %s: %v", event.Type, err)
+	}
+}
+
+// auditChange records an audit entry together with the fields that differ
+// between two snapshots of the target; before is nil for creations and
+// after is nil for deletions
+func (s *Server) auditChange(r *http.Request, entry *models.AuditLog, before, after interface{}) {
+	changes, err := audit.Diff(before, after)
+	if err != nil {
+		s.logger.Printf("Error computing audit diff: %v", err)
+	}
+	entry.Changes = changes
+
+	s.audit(r, entry)
+}
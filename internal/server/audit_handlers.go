@@ -0,0 +1,339 @@
+package server
+
+import (
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/theshovonaha/mini-pam/internal/audit"
+	"github.com/theshovonaha/mini-pam/internal/models"
+)
+
+// auditExportBatch is the number of entries fetched per query while exporting
+const auditExportBatch = 1000
+
+// AuditLogPage is one page of an audit log query
+type AuditLogPage struct {
+	Entries    []*models.AuditLog `json:"entries"`
+	NextCursor string             `json:"next_cursor,omitempty"`
+}
+
+// handleListAuditLogs returns a handler for querying the audit log
+func (s *Server) handleListAuditLogs() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		filter, err := parseAuditLogFilter(r.URL.Query())
+		if err != nil {
+			s.respondError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		s.serveAuditLogs(w, r, filter)
+	}
+}
+
+// handleGetUserAuditLogs returns a handler for the audit entries of one actor
+func (s *Server) handleGetUserAuditLogs() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		filter, err := parseAuditLogFilter(r.URL.Query())
+		if err != nil {
+			s.respondError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		filter.UserID, _ = strconv.Atoi(mux.Vars(r)["id"])
+		s.serveAuditLogs(w, r, filter)
+	}
+}
+
+// handleGetResourceAuditLogs returns a handler for the audit entries about one resource
+func (s *Server) handleGetResourceAuditLogs() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		filter, err := parseAuditLogFilter(r.URL.Query())
+		if err != nil {
+			s.respondError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		vars := mux.Vars(r)
+		filter.Resources = []string{vars["resource"]}
+		filter.ResourceID, _ = strconv.Atoi(vars["id"])
+		s.serveAuditLogs(w, r, filter)
+	}
+}
+
+// serveAuditLogs answers an audit log query with one JSON page, or with
+// every matching entry when a CSV or JSON lines export is requested
+func (s *Server) serveAuditLogs(w http.ResponseWriter, r *http.Request, filter models.AuditLogFilter) {
+	query := r.URL.Query()
+
+	var after *models.AuditLogCursor
+	if cursor := query.Get("cursor"); cursor != "" {
+		var err error
+		if after, err = decodeAuditCursor(cursor); err != nil {
+			s.respondError(w, http.StatusBadRequest, "Invalid cursor")
+			return
+		}
+	}
+
+	switch format := query.Get("format"); format {
+	case "", "json":
+	case "csv", "jsonl":
+		s.exportAuditLogs(w, r, filter, after, format)
+		return
+	default:
+		s.respondError(w, http.StatusBadRequest, "format must be json, csv or jsonl")
+		return
+	}
+
+	limit := 50
+	if limitStr := query.Get("limit"); limitStr != "" {
+		if l, err := strconv.Atoi(limitStr); err == nil && l > 0 && l <= 500 {
+			limit = l
+		}
+	}
+
+	entries, err := s.models.AuditLogs.Query(filter, after, limit)
+	if err != nil {
+		s.logger.Printf("Error querying audit logs: %v", err)
+		s.respondError(w, http.StatusInternalServerError, "Failed to query audit logs")
+		return
+	}
+
+	page := AuditLogPage{Entries: entries}
+	if len(entries) == limit {
+		page.NextCursor = encodeAuditCursor(entries[len(entries)-1])
+	}
+	s.respondJSON(w, http.StatusOK, page)
+}
+
+// exportAuditLogs streams every matching entry, fetching them in keyset
+// batches so memory use stays flat however large the range is
+func (s *Server) exportAuditLogs(w http.ResponseWriter, r *http.Request, filter models.AuditLogFilter,
+	after *models.AuditLogCursor, format string) {
+	// Create an audit log entry
+	s.audit(r, &models.AuditLog{
+		Action:   "export",
+		Resource: "audit_log",
+		Details:  "Audit log exported as " + format + ": " + r.URL.RawQuery,
+	})
+
+	// Large exports outlive the server's write timeout
+	rc := http.NewResponseController(w)
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		s.logger.Printf("Error extending export deadline: %v", err)
+	}
+
+	var write func(*models.AuditLog) error
+	var finish func() error
+	switch format {
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="audit-logs.csv"`)
+		writer := csv.NewWriter(w)
+		writer.Write(auditCSVHeader)
+		write = func(entry *models.AuditLog) error { return writer.Write(auditCSVRecord(entry)) }
+		finish = func() error { writer.Flush(); return writer.Error() }
+	default:
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Header().Set("Content-Disposition", `attachment; filename="audit-logs.jsonl"`)
+		encoder := json.NewEncoder(w)
+		write = func(entry *models.AuditLog) error { return encoder.Encode(entry) }
+		finish = func() error { return nil }
+	}
+	w.WriteHeader(http.StatusOK)
+
+	for {
+		entries, err := s.models.AuditLogs.Query(filter, after, auditExportBatch)
+		if err != nil {
+			// The status is already sent; a truncated file is all we can signal
+			s.logger.Printf("Error exporting audit logs: %v", err)
+			return
+		}
+		for _, entry := range entries {
+			if err := write(entry); err != nil {
+				s.logger.Printf("Error writing audit log export: %v", err)
+				return
+			}
+		}
+		if err := finish(); err != nil {
+			s.logger.Printf("Error writing audit log export: %v", err)
+			return
+		}
+		rc.Flush()
+
+		if len(entries) < auditExportBatch {
+			return
+		}
+		last := entries[len(entries)-1]
+		after = &models.AuditLogCursor{Timestamp: last.Timestamp, ID: last.ID}
+	}
+}
+
+// auditCSVHeader names the columns written by auditCSVRecord
+var auditCSVHeader = []string{
+	"id", "timestamp", "user_id", "service_account_id", "session_id", "api_key_id", "action",
+	"resource", "resource_id", "ip_address", "user_agent", "details", "changes", "hash",
+}
+
+// auditCSVRecord formats an entry as a CSV row, with changes as JSON
+func auditCSVRecord(entry *models.AuditLog) []string {
+	changes := ""
+	if len(entry.Changes) > 0 {
+		data, _ := json.Marshal(entry.Changes)
+		changes = string(data)
+	}
+	optional := func(id int) string {
+		if id == 0 {
+			return ""
+		}
+		return strconv.Itoa(id)
+	}
+
+	return []string{
+		strconv.Itoa(entry.ID),
+		entry.Timestamp.UTC().Format(time.RFC3339Nano),
+		optional(entry.UserID),
+		optional(entry.ServiceAccountID),
+		optional(entry.SessionID),
+		optional(entry.APIKeyID),
+		entry.Action,
+		entry.Resource,
+		optional(entry.ResourceID),
+		entry.IPAddress,
+		csvSafe(entry.UserAgent),
+		csvSafe(entry.Details),
+		changes,
+		entry.Hash,
+	}
+}
+
+// csvSafe keeps spreadsheet applications from evaluating user-controlled
+// text as a formula
+func csvSafe(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
+
+// parseAuditLogFilter reads the filter parameters shared by the audit log endpoints
+func parseAuditLogFilter(query url.Values) (models.AuditLogFilter, error) {
+	filter := models.AuditLogFilter{
+		Actions:   splitList(query.Get("action")),
+		Resources: splitList(query.Get("resource")),
+		Text:      query.Get("q"),
+	}
+
+	for name, dst := range map[string]*time.Time{"from": &filter.From, "to": &filter.To} {
+		if value := query.Get(name); value != "" {
+			t, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return filter, fmt.Errorf("%s must be an RFC 3339 timestamp", name)
+			}
+			*dst = t
+		}
+	}
+
+	for name, dst := range map[string]*int{
+		"user_id":            &filter.UserID,
+		"service_account_id": &filter.ServiceAccountID,
+		"resource_id":        &filter.ResourceID,
+	} {
+		if value := query.Get(name); value != "" {
+			id, err := strconv.Atoi(value)
+			if err != nil || id <= 0 {
+				return filter, fmt.Errorf("%s must be a positive integer", name)
+			}
+			*dst = id
+		}
+	}
+
+	if ip := query.Get("ip"); ip != "" {
+		if !strings.Contains(ip, "/") {
+			if addr := net.ParseIP(ip); addr != nil && addr.To4() != nil {
+				ip += "/32"
+			} else {
+				ip += "/128"
+			}
+		}
+		_, network, err := net.ParseCIDR(ip)
+		if err != nil {
+			return filter, errors.New("ip must be an IP address or CIDR block")
+		}
+		filter.IPNetwork = network.String()
+	}
+
+	return filter, nil
+}
+
+// splitList splits a comma-separated parameter, dropping empty items
+func splitList(value string) []string {
+	items := []string{}
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// encodeAuditCursor returns the opaque cursor that continues after entry
+func encodeAuditCursor(entry *models.AuditLog) string {
+	raw := entry.Timestamp.UTC().Format(time.RFC3339Nano) + "|" + strconv.Itoa(entry.ID)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// decodeAuditCursor parses a cursor produced by encodeAuditCursor
+func decodeAuditCursor(cursor string) (*models.AuditLogCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, err
+	}
+	timestamp, id, found := strings.Cut(string(raw), "|")
+	if !found {
+		return nil, errors.New("malformed cursor")
+	}
+
+	t, err := time.Parse(time.RFC3339Nano, timestamp)
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(id)
+	if err != nil {
+		return nil, err
+	}
+	return &models.AuditLogCursor{Timestamp: t, ID: n}, nil
+}
+
+// handleVerifyAuditChain returns a handler that walks the audit log hash
+// chain and reports the first broken link. Archived months are no longer in
+// the database; the walk starts from the newest archive's last hash.
+func (s *Server) handleVerifyAuditChain() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		archive, err := s.models.AuditArchives.Latest()
+		if err != nil && !errors.Is(err, models.ErrRecordNotFound) {
+			s.logger.Printf("Error getting audit archives: %v", err)
+			s.respondError(w, http.StatusInternalServerError, "Failed to verify audit log")
+			return
+		}
+
+		report, err := audit.VerifyChainAfter(archive, s.models.AuditLogs.ListAfter)
+		if err != nil {
+			s.logger.Printf("Error verifying audit chain: %v", err)
+			s.respondError(w, http.StatusInternalServerError, "Failed to verify audit log")
+			return
+		}
+
+		if !report.Valid {
+			s.logger.Printf("Audit chain broken at entry %d: %s", report.FirstBroken.ID, report.FirstBroken.Reason)
+		}
+
+		s.respondJSON(w, http.StatusOK, report)
+	}
+}
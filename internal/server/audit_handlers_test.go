@@ -0,0 +1,88 @@
+package server
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/theshovonaha/mini-pam/internal/models"
+)
+
+func TestParseAuditLogFilter(t *testing.T) {
+	query, _ := url.ParseQuery("from=2026-01-01T00:00:00Z&to=2026-02-01T00:00:00Z&action=login_failed,+lockout" +
+		"&resource=user&resource_id=7&user_id=3&ip=10.1.2.3/8&q=50%25+off")
+	filter, err := parseAuditLogFilter(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := models.AuditLogFilter{
+		From:       time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC),
+		To:         time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC),
+		UserID:     3,
+		Actions:    []string{"login_failed", "lockout"},
+		Resources:  []string{"user"},
+		ResourceID: 7,
+		IPNetwork:  "10.0.0.0/8",
+		Text:       "50% off",
+	}
+	if !reflect.DeepEqual(filter, want) {
+		t.Errorf("got %+v\nwant %+v", filter, want)
+	}
+
+	for ip, network := range map[string]string{"192.0.2.7": "192.0.2.7/32", "2001:db8::1": "2001:db8::1/128"} {
+		filter, err := parseAuditLogFilter(url.Values{"ip": {ip}})
+		if err != nil || filter.IPNetwork != network {
+			t.Errorf("ip=%s: got %q, %v want %q", ip, filter.IPNetwork, err, network)
+		}
+	}
+
+	for _, raw := range []string{"from=yesterday", "user_id=abc", "resource_id=-1", "ip=not-an-ip", "ip=10.0.0.0/33"} {
+		query, _ := url.ParseQuery(raw)
+		if _, err := parseAuditLogFilter(query); err == nil {
+			t.Errorf("%s: expected an error", raw)
+		}
+	}
+}
+
+func TestAuditCursor(t *testing.T) {
+	entry := &models.AuditLog{ID: 42, Timestamp: time.Date(2026, 3, 4, 5, 6, 7, 123456000, time.UTC)}
+	cursor, err := decodeAuditCursor(encodeAuditCursor(entry))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cursor.ID != 42 || !cursor.Timestamp.Equal(entry.Timestamp) {
+		t.Errorf("round trip: got %+v", cursor)
+	}
+
+	for _, bad := range []string{"!!!", "bm9waXBl", "MjAyNnwx"} {
+		if _, err := decodeAuditCursor(bad); err == nil {
+			t.Errorf("decodeAuditCursor(%q): expected an error", bad)
+		}
+	}
+}
+
+func TestAuditCSVRecord(t *testing.T) {
+	record := auditCSVRecord(&models.AuditLog{
+		ID:        1,
+		UserID:    2,
+		Action:    "update",
+		Resource:  "user",
+		Timestamp: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC),
+		Details:   "=HYPERLINK(\"http://evil\")",
+		Changes:   models.AuditChanges{"active": {Old: true, New: false}},
+	})
+	if len(record) != len(auditCSVHeader) {
+		t.Fatalf("record has %d columns, header %d", len(record), len(auditCSVHeader))
+	}
+	if record[3] != "" || record[8] != "" {
+		t.Errorf("unset IDs should be empty: %q", record)
+	}
+	if record[11] != "'=HYPERLINK(\"http://evil\")" {
+		t.Errorf("formula not neutralised: %q", record[11])
+	}
+	if record[12] != `{"active":{"old":true,"new":false}}` {
+		t.Errorf("changes: got %s", record[12])
+	}
+}
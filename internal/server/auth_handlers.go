@@ -0,0 +1,526 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/theshovonaha/mini-pam/internal/auth"
+	"github.com/theshovonaha/mini-pam/internal/models"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// LoginRequest represents the request body for logging in
+type LoginRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// LoginResponse represents a successfully issued bearer token
+type LoginResponse struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// ChangePasswordRequest represents the request body for changing one's own password
+type ChangePasswordRequest struct {
+	Username        string `json:"username"`
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+// StepUpRequest represents the request body for a step-up verification
+type StepUpRequest struct {
+	Code string `json:"code"`
+}
+
+// EnrollMFARequest represents the request body for enrolling a second factor
+type EnrollMFARequest struct {
+	Password string `json:"password"`
+}
+
+// handleLogin returns a handler that exchanges a username and password for a token
+func (s *Server) handleLogin() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Parse the request body
+		var req LoginRequest
+		if err := s.readJSON(w, r, &req); err != nil {
+			s.respondError(w, http.StatusBadRequest, "Invalid request payload")
+			return
+		}
+
+		if req.Username == "" || req.Password == "" {
+			s.respondError(w, http.StatusBadRequest, "Username and password are required")
+			return
+		}
+
+		// Refuse attempts while the account or source address is throttled
+		subject, ip := loginSubject(req.Username), clientIP(r)
+		if !s.reserveLoginAttempt(w, r, subject, ip) {
+			return
+		}
+
+		// Verify the password with the configured backend
+		identity, err := s.authenticator.Authenticate(req.Username, req.Password)
+		if err != nil {
+			if errors.Is(err, auth.ErrInvalidCredentials) {
+				s.recordLoginFailure(r, subject, ip, "invalid credentials")
+				s.respondError(w, http.StatusUnauthorized, "Invalid username or password")
+			} else {
+				s.releaseLoginAttempt(subject, ip)
+				s.logger.Printf("Error authenticating user: %v", err)
+				s.respondError(w, http.StatusInternalServerError, "Failed to log in")
+			}
+			return
+		}
+
+		// A successful password check clears the account's failure counter
+		s.clearLoginFailures(subject, ip)
+
+		// Local passwords past their maximum age must be changed before use
+		if identity.Source == "local" && s.config.PasswordPolicy.MaxAge > 0 {
+			user, err := s.models.Users.GetByUsername(identity.Username)
+			if err != nil {
+				s.logger.Printf("Error getting user: %v", err)
+				s.respondError(w, http.StatusInternalServerError, "Failed to log in")
+				return
+			}
+			if s.config.PasswordPolicy.Expired(user.PasswordChangedAt, time.Now()) {
+				s.respondJSON(w, http.StatusForbidden, PasswordExpiredResponse{
+					Error:     "Password has expired and must be changed",
+					Code:      "password_expired",
+					ChangeURL: "/api/v1/auth/change-password",
+				})
+				return
+			}
+		}
+
+		s.completeLogin(w, r, identity)
+	}
+}
+
+// handleChangePassword returns a handler that replaces a local user's password.
+// It authenticates with the current password rather than a token, so users
+// whose password has expired can still change it.
+func (s *Server) handleChangePassword() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Parse the request body
+		var req ChangePasswordRequest
+		if err := s.readJSON(w, r, &req); err != nil {
+			s.respondError(w, http.StatusBadRequest, "Invalid request payload")
+			return
+		}
+
+		if req.Username == "" || req.CurrentPassword == "" || req.NewPassword == "" {
+			s.respondError(w, http.StatusBadRequest, "Username, current password and new password are required")
+			return
+		}
+
+		// The current password is guessable here too, so share the login throttle
+		subject, ip := loginSubject(req.Username), clientIP(r)
+		if !s.reserveLoginAttempt(w, r, subject, ip) {
+			return
+		}
+
+		identity, err := s.authenticator.Authenticate(req.Username, req.CurrentPassword)
+		if err != nil {
+			if errors.Is(err, auth.ErrInvalidCredentials) {
+				s.recordLoginFailure(r, subject, ip, "invalid current password")
+				s.respondError(w, http.StatusUnauthorized, "Invalid username or password")
+			} else {
+				s.releaseLoginAttempt(subject, ip)
+				s.logger.Printf("Error authenticating user: %v", err)
+				s.respondError(w, http.StatusInternalServerError, "Failed to change password")
+			}
+			return
+		}
+		s.clearLoginFailures(subject, ip)
+		if identity.Source != "local" {
+			s.respondError(w, http.StatusBadRequest, "Password is managed by "+identity.Source)
+			return
+		}
+
+		user, err := s.models.Users.GetByUsername(identity.Username)
+		if err != nil {
+			s.logger.Printf("Error getting user: %v", err)
+			s.respondError(w, http.StatusInternalServerError, "Failed to change password")
+			return
+		}
+
+		// Enforce the password policy
+		if err := s.checkNewPassword(user, req.NewPassword); err != nil {
+			s.respondPasswordError(w, err)
+			return
+		}
+
+		if err := s.setPassword(user.ID, req.NewPassword); err != nil {
+			s.logger.Printf("Error updating password: %v", err)
+			s.respondError(w, http.StatusInternalServerError, "Failed to change password")
+			return
+		}
+
+		// Tokens issued under the old password stop working
+		if err := s.models.Sessions.RevokeAllForUser(user.ID); err != nil {
+			s.logger.Printf("Error revoking sessions: %v", err)
+		}
+
+		// Create an audit log entry
+		s.audit(r, &models.AuditLog{
+			UserID:     user.ID,
+			Action:     "password_change",
+			Resource:   "user",
+			ResourceID: user.ID,
+			Details:    "Password changed by user",
+		})
+
+		s.respondJSON(w, http.StatusOK, map[string]string{"message": "Password changed successfully"})
+	}
+}
+
+// completeLogin maps an authenticated identity to a user and issues a session token
+func (s *Server) completeLogin(w http.ResponseWriter, r *http.Request, identity *auth.Identity) {
+	user, err := s.resolveLoginUser(identity)
+	if err != nil {
+		if errors.Is(err, errIdentityConflict) {
+			s.auditLoginEvent(r, 0, "login_denied", fmt.Sprintf("%s identity %q matches an account %s does not manage",
+				identity.Source, identity.Username, identity.Source))
+			s.respondError(w, http.StatusForbidden, "This account cannot sign in through "+identity.Source)
+		} else {
+			s.logger.Printf("Error resolving user: %v", err)
+			s.respondError(w, http.StatusInternalServerError, "Failed to log in")
+		}
+		return
+	}
+	if !user.Active {
+		s.respondError(w, http.StatusUnauthorized, "Invalid username or password")
+		return
+	}
+
+	if err := s.syncRoles(user.ID, identity); err != nil {
+		s.logger.Printf("Error syncing roles: %v", err)
+		s.respondError(w, http.StatusInternalServerError, "Failed to log in")
+		return
+	}
+
+	// Issue a new session token
+	token, hash, err := auth.GenerateToken()
+	if err != nil {
+		s.logger.Printf("Error generating token: %v", err)
+		s.respondError(w, http.StatusInternalServerError, "Failed to log in")
+		return
+	}
+
+	session := &models.Session{
+		UserID:    user.ID,
+		TokenHash: hash,
+		ExpiresAt: time.Now().Add(s.config.SessionTTL),
+		IPAddress: r.RemoteAddr,
+		UserAgent: r.UserAgent(),
+	}
+	if err := s.models.Sessions.Create(session); err != nil {
+		s.logger.Printf("Error creating session: %v", err)
+		s.respondError(w, http.StatusInternalServerError, "Failed to log in")
+		return
+	}
+
+	// Create an audit log entry
+	s.audit(r, &models.AuditLog{
+		UserID:     user.ID,
+		Action:     "login",
+		Resource:   "session",
+		ResourceID: session.ID,
+		Details:    "User logged in via " + identity.Source,
+	})
+
+	s.respondJSON(w, http.StatusOK, LoginResponse{Token: token, ExpiresAt: session.ExpiresAt})
+}
+
+// errIdentityConflict refuses an external login whose username belongs to
+// an account that backend does not vouch for
+var errIdentityConflict = errors.New("account is managed by another authentication source")
+
+// resolveLoginUser returns the users row for an authenticated identity,
+// creating it on first login for externally managed identities. External
+// identities are matched on the backend's identifier for them, never on
+// username alone, so an identity provider cannot claim a local account.
+func (s *Server) resolveLoginUser(identity *auth.Identity) (*models.User, error) {
+	if identity.Source == models.AuthSourceLocal {
+		return s.models.Users.GetByUsername(identity.Username)
+	}
+
+	user, err := s.models.Users.GetByExternalID(identity.Source, identity.Subject)
+	if err == nil || !errors.Is(err, models.ErrRecordNotFound) {
+		return user, err
+	}
+
+	// A first login may only claim an unbound account that this backend,
+	// or SCIM provisioning on its behalf, already manages
+	user, err = s.models.Users.GetByUsername(identity.Username)
+	switch {
+	case errors.Is(err, models.ErrRecordNotFound):
+		return s.provisionUser(identity)
+	case err != nil:
+		return nil, err
+	case user.ExternalID != "" || (user.AuthSource != identity.Source && user.AuthSource != models.AuthSourceSCIM):
+		return nil, errIdentityConflict
+	}
+
+	user.AuthSource, user.ExternalID = identity.Source, identity.Subject
+	if err := s.models.Users.BindExternal(user); err != nil {
+		return nil, err
+	}
+
+	s.logger.Printf("Bound user %s to %s", user.Username, identity.Source)
+	return user, nil
+}
+
+// provisionUser creates the users row for an external identity's first login
+func (s *Server) provisionUser(identity *auth.Identity) (*models.User, error) {
+	hashedPassword, err := placeholderPasswordHash()
+	if err != nil {
+		return nil, err
+	}
+
+	email := identity.Email
+	if email == "" {
+		email = identity.Username + "@" + identity.Source + ".invalid"
+	}
+
+	user := &models.User{
+		Username:       identity.Username,
+		Email:          email,
+		HashedPassword: hashedPassword,
+		FirstName:      identity.FirstName,
+		LastName:       identity.LastName,
+		Active:         true,
+		AuthSource:     identity.Source,
+		ExternalID:     identity.Subject,
+	}
+	if err := s.models.Users.Create(user); err != nil {
+		return nil, err
+	}
+
+	s.logger.Printf("Provisioned user %s from %s", user.Username, identity.Source)
+	return user, nil
+}
+
+// placeholderPasswordHash returns an unguessable bcrypt hash for users who
+// never log in with a local password (externally managed identities)
+func placeholderPasswordHash() (string, error) {
+	_, placeholder, err := auth.GenerateToken()
+	if err != nil {
+		return "", err
+	}
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(placeholder), 12)
+	if err != nil {
+		return "", err
+	}
+	return string(hashedPassword), nil
+}
+
+// syncRoles brings user_roles in line with the roles granted by the identity backend
+func (s *Server) syncRoles(userID int, identity *auth.Identity) error {
+	if len(identity.ManagedRoles) == 0 {
+		return nil
+	}
+
+	granted := map[string]bool{}
+	for _, name := range identity.Roles {
+		granted[name] = true
+	}
+
+	for _, name := range identity.ManagedRoles {
+		role, err := s.models.Roles.GetByName(name)
+		if err != nil {
+			if errors.Is(err, models.ErrRecordNotFound) {
+				s.logger.Printf("Skipping unknown mapped role %q", name)
+				continue
+			}
+			return err
+		}
+
+		if granted[name] {
+			err = s.models.Roles.AssignRoleToUser(userID, role.ID)
+		} else {
+			err = s.models.Roles.RemoveRoleFromUser(userID, role.ID)
+			if errors.Is(err, models.ErrRecordNotFound) {
+				err = nil
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// handleEnrollMFA returns a handler that issues a new TOTP secret for the
+// current user. The password must be entered again, so a stolen token alone
+// cannot enroll a factor of its own and step up with it.
+func (s *Server) handleEnrollMFA() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		session := s.contextGetSession(r)
+
+		// Parse the request body
+		var req EnrollMFARequest
+		if err := s.readJSON(w, r, &req); err != nil || req.Password == "" {
+			s.respondError(w, http.StatusBadRequest, "The account password is required")
+			return
+		}
+
+		// Refuse to silently replace a factor that is already in use
+		existing, err := s.models.MFA.GetByUserID(session.UserID)
+		if err != nil && !errors.Is(err, models.ErrRecordNotFound) {
+			s.logger.Printf("Error getting MFA enrollment: %v", err)
+			s.respondError(w, http.StatusInternalServerError, "Failed to enroll MFA")
+			return
+		}
+		if existing != nil && existing.Enabled {
+			s.respondError(w, http.StatusConflict, "MFA is already enrolled")
+			return
+		}
+
+		user, err := s.models.Users.GetByID(session.UserID)
+		if err != nil {
+			s.logger.Printf("Error getting user: %v", err)
+			s.respondError(w, http.StatusInternalServerError, "Failed to enroll MFA")
+			return
+		}
+
+		// The password is guessable here too, so share the login throttle
+		subject, ip := loginSubject(user.Username), clientIP(r)
+		if !s.reserveLoginAttempt(w, r, subject, ip) {
+			return
+		}
+		if _, err := s.authenticator.Authenticate(user.Username, req.Password); err != nil {
+			if errors.Is(err, auth.ErrInvalidCredentials) {
+				s.recordLoginFailure(r, subject, ip, "invalid password for MFA enrollment")
+				s.respondError(w, http.StatusUnauthorized, "Invalid password")
+			} else {
+				s.releaseLoginAttempt(subject, ip)
+				s.logger.Printf("Error authenticating user: %v", err)
+				s.respondError(w, http.StatusInternalServerError, "Failed to enroll MFA")
+			}
+			return
+		}
+		s.clearLoginFailures(subject, ip)
+
+		secret, err := auth.GenerateTOTPSecret()
+		if err != nil {
+			s.logger.Printf("Error generating TOTP secret: %v", err)
+			s.respondError(w, http.StatusInternalServerError, "Failed to enroll MFA")
+			return
+		}
+
+		if err := s.models.MFA.Upsert(&models.UserMFA{UserID: user.ID, TOTPSecret: secret}); err != nil {
+			s.logger.Printf("Error saving MFA enrollment: %v", err)
+			s.respondError(w, http.StatusInternalServerError, "Failed to enroll MFA")
+			return
+		}
+
+		// The secret is only ever shown here; the first step-up confirms it
+		s.respondJSON(w, http.StatusCreated, map[string]string{
+			"secret":      secret,
+			"otpauth_uri": auth.TOTPURI(s.config.MFAIssuer, user.Username, secret),
+		})
+	}
+}
+
+// handleStepUp returns a handler that verifies a TOTP code and upgrades the current token
+func (s *Server) handleStepUp() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		session := s.contextGetSession(r)
+
+		// Parse the request body
+		var req StepUpRequest
+		if err := s.readJSON(w, r, &req); err != nil || req.Code == "" {
+			s.respondError(w, http.StatusBadRequest, "A verification code is required")
+			return
+		}
+
+		mfa, err := s.models.MFA.GetByUserID(session.UserID)
+		if err != nil {
+			if errors.Is(err, models.ErrRecordNotFound) {
+				s.respondError(w, http.StatusPreconditionFailed, "No MFA factor enrolled")
+			} else {
+				s.logger.Printf("Error getting MFA enrollment: %v", err)
+				s.respondError(w, http.StatusInternalServerError, "Failed to verify code")
+			}
+			return
+		}
+
+		user, err := s.models.Users.GetByID(session.UserID)
+		if err != nil {
+			s.logger.Printf("Error getting user: %v", err)
+			s.respondError(w, http.StatusInternalServerError, "Failed to verify code")
+			return
+		}
+
+		// Codes are short, so wrong ones count against the login throttle
+		subject, ip := loginSubject(user.Username), clientIP(r)
+		if !s.reserveLoginAttempt(w, r, subject, ip) {
+			return
+		}
+
+		valid, err := s.verifyTOTP(mfa, req.Code)
+		if err != nil {
+			s.releaseLoginAttempt(subject, ip)
+			s.logger.Printf("Error verifying TOTP code: %v", err)
+			s.respondError(w, http.StatusInternalServerError, "Failed to verify code")
+			return
+		}
+		if !valid {
+			s.audit(r, &models.AuditLog{
+				Action:     "step_up_failed",
+				Resource:   "session",
+				ResourceID: session.ID,
+				Details:    "Invalid step-up code",
+			})
+			s.recordLoginFailure(r, subject, ip, "invalid step-up code")
+
+			s.respondError(w, http.StatusUnauthorized, "Invalid verification code")
+			return
+		}
+		s.clearLoginFailures(subject, ip)
+
+		// The first successful verification confirms a pending enrollment
+		if !mfa.Enabled {
+			if err := s.models.MFA.Enable(mfa.UserID); err != nil {
+				s.logger.Printf("Error enabling MFA: %v", err)
+				s.respondError(w, http.StatusInternalServerError, "Failed to verify code")
+				return
+			}
+		}
+
+		if err := s.models.Sessions.MarkMFAVerified(session); err != nil {
+			s.logger.Printf("Error updating session: %v", err)
+			s.respondError(w, http.StatusInternalServerError, "Failed to verify code")
+			return
+		}
+
+		// Create an audit log entry
+		s.audit(r, &models.AuditLog{
+			Action:     "step_up",
+			Resource:   "session",
+			ResourceID: session.ID,
+			Details:    "Step-up MFA verified",
+		})
+
+		s.respondJSON(w, http.StatusOK, map[string]time.Time{
+			"mfa_verified_at": *session.MFAVerifiedAt,
+			"mfa_expires_at":  session.MFAVerifiedAt.Add(s.config.MFAFreshness),
+		})
+	}
+}
+
+// verifyTOTP checks a code against a user's factor and consumes its time
+// step, so a code that was seen once cannot be replayed within its window
+func (s *Server) verifyTOTP(mfa *models.UserMFA, code string) (bool, error) {
+	step, ok := auth.MatchTOTP(mfa.TOTPSecret, code, time.Now())
+	if !ok {
+		return false, nil
+	}
+	return s.models.MFA.ConsumeStep(mfa.UserID, step)
+}
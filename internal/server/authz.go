@@ -0,0 +1,43 @@
+package server
+
+import (
+	"github.com/theshovonaha/mini-pam/internal/auth"
+	"github.com/theshovonaha/mini-pam/internal/models"
+)
+
+// adminRoleName is the built-in role with full system access
+const adminRoleName = "admin"
+
+// isAdmin reports whether the user holds the admin role
+func (s *Server) isAdmin(userID int) (bool, error) {
+	roles, err := s.models.Roles.GetUserRoles(userID)
+	if err != nil {
+		return false, err
+	}
+
+	for _, role := range roles {
+		if role.Name == adminRoleName {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// canAccessCredential reports whether a principal may perform operation on a
+// credential. API keys and client certificates are limited to their scopes;
+// users must own the credential or be an administrator.
+func (s *Server) canAccessCredential(principal *Principal, operation string, credential *models.Credential) (bool, error) {
+	if principal.APIKey != nil {
+		return auth.KeyAllows(principal.APIKey, operation, credential), nil
+	}
+	if principal.Certificate != nil {
+		return auth.ScopesAllow(principal.Certificate.Scopes, operation, credential), nil
+	}
+
+	if credential.CreatedBy == principal.UserID {
+		return true, nil
+	}
+
+	return s.isAdmin(principal.UserID)
+}
@@ -0,0 +1,218 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/theshovonaha/mini-pam/internal/cmdpolicy"
+	"github.com/theshovonaha/mini-pam/internal/models"
+)
+
+// CommandRuleRequest represents the request body for creating or updating a command rule
+type CommandRuleRequest struct {
+	Name         string `json:"name"`
+	Description  string `json:"description"`
+	Pattern      string `json:"pattern"`
+	Action       string `json:"action"`
+	RoleID       int    `json:"role_id"`
+	CredentialID int    `json:"credential_id"`
+	Active       *bool  `json:"active"`
+}
+
+// validateCommandRule checks the request, returning a message for the client
+// if it is invalid
+func (s *Server) validateCommandRule(req *CommandRuleRequest) string {
+	if req.Name == "" {
+		return "Name is required"
+	}
+	if err := cmdpolicy.ValidatePattern(req.Pattern); err != nil {
+		return "Invalid pattern: " + err.Error()
+	}
+	switch req.Action {
+	case models.CommandAllow, models.CommandDeny, models.CommandNotify:
+	default:
+		return "Action must be allow, deny or notify"
+	}
+
+	if req.RoleID != 0 {
+		if _, err := s.models.Roles.GetByID(req.RoleID); err != nil {
+			return "Role not found"
+		}
+	}
+	if req.CredentialID != 0 {
+		if _, err := s.models.Credentials.GetByID(req.CredentialID); err != nil {
+			return "Credential not found"
+		}
+	}
+	return ""
+}
+
+// handleListCommandRules returns a handler for listing SSH command rules
+func (s *Server) handleListCommandRules() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rules, err := s.models.CommandRules.List()
+		if err != nil {
+			s.logger.Printf("Error listing command rules: %v", err)
+			s.respondError(w, http.StatusInternalServerError, "Failed to list command rules")
+			return
+		}
+
+		s.respondJSON(w, http.StatusOK, rules)
+	}
+}
+
+// handleCreateCommandRule returns a handler for creating an SSH command rule.
+// Rules apply to sessions opened after they are created.
+func (s *Server) handleCreateCommandRule() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Parse the request body
+		var req CommandRuleRequest
+		if err := s.readJSON(w, r, &req); err != nil {
+			s.respondError(w, http.StatusBadRequest, "Invalid request payload")
+			return
+		}
+		if msg := s.validateCommandRule(&req); msg != "" {
+			s.respondError(w, http.StatusBadRequest, msg)
+			return
+		}
+
+		rule := &models.CommandRule{
+			Name:         req.Name,
+			Description:  req.Description,
+			Pattern:      req.Pattern,
+			Action:       req.Action,
+			RoleID:       req.RoleID,
+			CredentialID: req.CredentialID,
+			Active:       req.Active == nil || *req.Active,
+			CreatedBy:    s.contextGetPrincipal(r).UserID,
+		}
+		if err := s.models.CommandRules.Create(rule); err != nil {
+			s.logger.Printf("Error creating command rule: %v", err)
+			s.respondError(w, http.StatusInternalServerError, "Failed to create command rule")
+			return
+		}
+
+		// Create an audit log entry
+		s.auditChange(r, &models.AuditLog{
+			Action:     "create",
+			Resource:   "command_rule",
+			ResourceID: rule.ID,
+			Details:    "Command rule created: " + rule.Name,
+		}, nil, rule)
+
+		s.respondJSON(w, http.StatusCreated, rule)
+	}
+}
+
+// loadCommandRule resolves the {id} path variable, responding on failure
+func (s *Server) loadCommandRule(w http.ResponseWriter, r *http.Request) *models.CommandRule {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		s.respondError(w, http.StatusBadRequest, "Invalid command rule ID")
+		return nil
+	}
+
+	rule, err := s.models.CommandRules.GetByID(id)
+	if err != nil {
+		if errors.Is(err, models.ErrRecordNotFound) {
+			s.respondError(w, http.StatusNotFound, "Command rule not found")
+		} else {
+			s.logger.Printf("Error getting command rule: %v", err)
+			s.respondError(w, http.StatusInternalServerError, "Failed to get command rule")
+		}
+		return nil
+	}
+	return rule
+}
+
+// handleGetCommandRule returns a handler for getting a command rule by ID
+func (s *Server) handleGetCommandRule() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rule := s.loadCommandRule(w, r)
+		if rule == nil {
+			return
+		}
+
+		s.respondJSON(w, http.StatusOK, rule)
+	}
+}
+
+// handleUpdateCommandRule returns a handler for changing a command rule's
+// pattern, action, scope or state
+func (s *Server) handleUpdateCommandRule() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rule := s.loadCommandRule(w, r)
+		if rule == nil {
+			return
+		}
+
+		// Parse the request body
+		var req CommandRuleRequest
+		if err := s.readJSON(w, r, &req); err != nil {
+			s.respondError(w, http.StatusBadRequest, "Invalid request payload")
+			return
+		}
+		if msg := s.validateCommandRule(&req); msg != "" {
+			s.respondError(w, http.StatusBadRequest, msg)
+			return
+		}
+
+		before := *rule
+		rule.Name = req.Name
+		rule.Description = req.Description
+		rule.Pattern = req.Pattern
+		rule.Action = req.Action
+		rule.RoleID = req.RoleID
+		rule.CredentialID = req.CredentialID
+		if req.Active != nil {
+			rule.Active = *req.Active
+		}
+		if err := s.models.CommandRules.Update(rule); err != nil {
+			s.logger.Printf("Error updating command rule: %v", err)
+			s.respondError(w, http.StatusInternalServerError, "Failed to update command rule")
+			return
+		}
+
+		// Create an audit log entry
+		s.auditChange(r, &models.AuditLog{
+			Action:     "update",
+			Resource:   "command_rule",
+			ResourceID: rule.ID,
+			Details:    "Command rule updated: " + rule.Name,
+		}, &before, rule)
+
+		s.respondJSON(w, http.StatusOK, rule)
+	}
+}
+
+// handleDeleteCommandRule returns a handler for deleting a command rule
+func (s *Server) handleDeleteCommandRule() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rule := s.loadCommandRule(w, r)
+		if rule == nil {
+			return
+		}
+
+		if err := s.models.CommandRules.Delete(rule.ID); err != nil {
+			if errors.Is(err, models.ErrRecordNotFound) {
+				s.respondError(w, http.StatusNotFound, "Command rule not found")
+			} else {
+				s.logger.Printf("Error deleting command rule: %v", err)
+				s.respondError(w, http.StatusInternalServerError, "Failed to delete command rule")
+			}
+			return
+		}
+
+		// Create an audit log entry
+		s.auditChange(r, &models.AuditLog{
+			Action:     "delete",
+			Resource:   "command_rule",
+			ResourceID: rule.ID,
+			Details:    "Command rule deleted: " + rule.Name,
+		}, rule, nil)
+
+		s.respondJSON(w, http.StatusOK, map[string]string{"message": "Command rule deleted successfully"})
+	}
+}
@@ -0,0 +1,53 @@
+package server
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/theshovonaha/mini-pam/internal/models"
+)
+
+// contextKey is a private type for request context keys
+type contextKey string
+
+const principalContextKey = contextKey("principal")
+
+// Principal is the authenticated caller: a user holding a session, or a
+// service account presenting an API key or a client certificate
+type Principal struct {
+	UserID           int
+	ServiceAccountID int
+	Session          *models.Session
+	APIKey           *models.APIKey
+	Certificate      *models.ServiceAccountCertificate
+}
+
+// contextSetPrincipal returns a copy of the request carrying the authenticated principal
+func (s *Server) contextSetPrincipal(r *http.Request, principal *Principal) *http.Request {
+	ctx := context.WithValue(r.Context(), principalContextKey, principal)
+	return r.WithContext(ctx)
+}
+
+// contextGetPrincipal returns the authenticated principal, or nil for anonymous requests
+func (s *Server) contextGetPrincipal(r *http.Request) *Principal {
+	principal, ok := r.Context().Value(principalContextKey).(*Principal)
+	if !ok {
+		return nil
+	}
+	return principal
+}
+
+// contextSetSession returns a copy of the request carrying a user session
+func (s *Server) contextSetSession(r *http.Request, session *models.Session) *http.Request {
+	return s.contextSetPrincipal(r, &Principal{UserID: session.UserID, Session: session})
+}
+
+// contextGetSession returns the authenticated user session, or nil for
+// anonymous and API key requests
+func (s *Server) contextGetSession(r *http.Request) *models.Session {
+	principal := s.contextGetPrincipal(r)
+	if principal == nil {
+		return nil
+	}
+	return principal.Session
+}
@@ -0,0 +1,150 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/theshovonaha/mini-pam/internal/auth"
+	"github.com/theshovonaha/mini-pam/internal/expiry"
+	"github.com/theshovonaha/mini-pam/internal/models"
+	"github.com/theshovonaha/mini-pam/internal/siem"
+	"github.com/theshovonaha/mini-pam/internal/webhook"
+)
+
+// RevealResponse carries a credential's secret back to an authorized caller
+type RevealResponse struct {
+	ID       int    `json:"id"`
+	Username string `json:"username"`
+	System   string `json:"system"`
+	Secret   string `json:"secret"`
+}
+
+// handleRevealCredential returns a handler for reading a credential's secret
+func (s *Server) handleRevealCredential() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		principal := s.contextGetPrincipal(r)
+
+		// Parse the credential ID from the URL
+		vars := mux.Vars(r)
+		id, err := strconv.Atoi(vars["id"])
+		if err != nil {
+			s.respondError(w, http.StatusBadRequest, "Invalid credential ID")
+			return
+		}
+
+		credential, err := s.models.Credentials.GetByID(id)
+		if err != nil {
+			if errors.Is(err, models.ErrRecordNotFound) {
+				s.respondError(w, http.StatusNotFound, "Credential not found")
+			} else {
+				s.logger.Printf("Error getting credential: %v", err)
+				s.respondError(w, http.StatusInternalServerError, "Failed to get credential")
+			}
+			return
+		}
+
+		allowed, err := s.canAccessCredential(principal, auth.OpCredentialsReveal, credential)
+		if err != nil {
+			s.logger.Printf("Error checking credential access: %v", err)
+			s.respondError(w, http.StatusInternalServerError, "Failed to get credential")
+			return
+		}
+		if !allowed {
+			s.respondError(w, http.StatusForbidden, "Access to this credential is not permitted")
+			return
+		}
+
+		// Record the access before handing out the secret
+		access := &models.CredentialAccess{
+			UserID:           principal.UserID,
+			ServiceAccountID: principal.ServiceAccountID,
+			CredentialID:     credential.ID,
+			IPAddress:        clientIP(r),
+			UserAgent:        r.UserAgent(),
+			Reason:           r.URL.Query().Get("reason"),
+		}
+		if err := s.recordCredentialAccess(access); err != nil {
+			s.logger.Printf("Error logging credential access: %v", err)
+			s.respondError(w, http.StatusInternalServerError, "Failed to get credential")
+			return
+		}
+
+		s.respondJSON(w, http.StatusOK, RevealResponse{
+			ID:       credential.ID,
+			Username: credential.Username,
+			System:   credential.System,
+			Secret:   credential.Secret,
+		})
+	}
+}
+
+// recordCredentialAccess logs a use of a vaulted credential, whether its
+// secret was revealed or handed to a target by a proxy, and forwards it to
+// SIEM sinks and webhook subscribers. Nothing is forwarded if logging fails.
+func (s *Server) recordCredentialAccess(access *models.CredentialAccess) error {
+	if err := s.models.Credentials.LogAccess(access); err != nil {
+		return err
+	}
+	s.config.Events.Emit(siem.FromCredentialAccess(access))
+	s.enqueueWebhook(webhook.FromCredentialAccess(access))
+	return nil
+}
+
+// ExpiringCredential is a credential listed in the expiry report
+type ExpiringCredential struct {
+	*models.Credential
+	DaysLeft int  `json:"days_left"` // Whole days left, rounded up; negative once expired
+	Expired  bool `json:"expired"`
+}
+
+// handleListExpiringCredentials returns a handler reporting the credentials
+// the caller can see that expire within ?days= (30 by default), including
+// those already expired, soonest first
+func (s *Server) handleListExpiringCredentials() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		principal := s.contextGetPrincipal(r)
+
+		days := 30
+		if daysStr := r.URL.Query().Get("days"); daysStr != "" {
+			d, err := strconv.Atoi(daysStr)
+			if err != nil || d < 0 || d > 3650 {
+				s.respondError(w, http.StatusBadRequest, "days must be between 0 and 3650")
+				return
+			}
+			days = d
+		}
+
+		now := time.Now()
+		credentials, err := s.models.Credentials.ListExpiring(now.AddDate(0, 0, days))
+		if err != nil {
+			s.logger.Printf("Error listing expiring credentials: %v", err)
+			s.respondError(w, http.StatusInternalServerError, "Failed to list credentials")
+			return
+		}
+
+		report := []ExpiringCredential{}
+		for _, credential := range credentials {
+			allowed, err := s.canAccessCredential(principal, auth.OpCredentialsRead, credential)
+			if err != nil {
+				s.logger.Printf("Error checking credential access: %v", err)
+				s.respondError(w, http.StatusInternalServerError, "Failed to list credentials")
+				return
+			}
+			if !allowed {
+				continue
+			}
+
+			left := credential.ExpiresAt.Sub(now)
+			report = append(report, ExpiringCredential{
+				Credential: credential,
+				DaysLeft:   expiry.DaysLeft(left),
+				Expired:    left <= 0,
+			})
+		}
+
+		s.respondJSON(w, http.StatusOK, report)
+	}
+}
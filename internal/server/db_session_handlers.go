@@ -0,0 +1,126 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/theshovonaha/mini-pam/internal/models"
+)
+
+// handleListDBSessions returns a handler listing Postgres proxy sessions,
+// optionally filtered by user, credential or the text of their queries
+func (s *Server) handleListDBSessions() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		filter := models.DBSessionFilter{Text: query.Get("q")}
+		for name, dst := range map[string]*int{
+			"user_id":       &filter.UserID,
+			"credential_id": &filter.CredentialID,
+		} {
+			if value := query.Get(name); value != "" {
+				id, err := strconv.Atoi(value)
+				if err != nil || id <= 0 {
+					s.respondError(w, http.StatusBadRequest, name+" must be a positive integer")
+					return
+				}
+				*dst = id
+			}
+		}
+
+		// Parse query parameters for pagination
+		page := 1
+		pageSize := 20
+
+		if pageStr := query.Get("page"); pageStr != "" {
+			if p, err := strconv.Atoi(pageStr); err == nil && p > 0 {
+				page = p
+			}
+		}
+
+		if pageSizeStr := query.Get("page_size"); pageSizeStr != "" {
+			if ps, err := strconv.Atoi(pageSizeStr); err == nil && ps > 0 && ps <= 100 {
+				pageSize = ps
+			}
+		}
+
+		sessions, err := s.models.DBSessions.List(filter, page, pageSize)
+		if err != nil {
+			s.logger.Printf("Error listing Postgres sessions: %v", err)
+			s.respondError(w, http.StatusInternalServerError, "Failed to list sessions")
+			return
+		}
+
+		s.respondJSON(w, http.StatusOK, sessions)
+	}
+}
+
+// loadDBSession resolves the {id} path variable, responding on failure
+func (s *Server) loadDBSession(w http.ResponseWriter, r *http.Request) *models.DBSession {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		s.respondError(w, http.StatusBadRequest, "Invalid session ID")
+		return nil
+	}
+
+	session, err := s.models.DBSessions.GetByID(id)
+	if err != nil {
+		if errors.Is(err, models.ErrRecordNotFound) {
+			s.respondError(w, http.StatusNotFound, "Session not found")
+		} else {
+			s.logger.Printf("Error getting Postgres session: %v", err)
+			s.respondError(w, http.StatusInternalServerError, "Failed to get session")
+		}
+		return nil
+	}
+	return session
+}
+
+// handleGetDBSession returns a handler for getting a Postgres session by ID
+func (s *Server) handleGetDBSession() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		session := s.loadDBSession(w, r)
+		if session == nil {
+			return
+		}
+
+		s.respondJSON(w, http.StatusOK, session)
+	}
+}
+
+// handleListDBSessionQueries returns a handler listing the queries sent over
+// a Postgres session, oldest first
+func (s *Server) handleListDBSessionQueries() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		session := s.loadDBSession(w, r)
+		if session == nil {
+			return
+		}
+
+		// Parse query parameters for pagination
+		page := 1
+		pageSize := 100
+
+		if pageStr := r.URL.Query().Get("page"); pageStr != "" {
+			if p, err := strconv.Atoi(pageStr); err == nil && p > 0 {
+				page = p
+			}
+		}
+
+		if pageSizeStr := r.URL.Query().Get("page_size"); pageSizeStr != "" {
+			if ps, err := strconv.Atoi(pageSizeStr); err == nil && ps > 0 && ps <= 1000 {
+				pageSize = ps
+			}
+		}
+
+		queries, err := s.models.DBSessions.ListQueries(session.ID, page, pageSize)
+		if err != nil {
+			s.logger.Printf("Error listing queries of Postgres session %d: %v", session.ID, err)
+			s.respondError(w, http.StatusInternalServerError, "Failed to list queries")
+			return
+		}
+
+		s.respondJSON(w, http.StatusOK, queries)
+	}
+}
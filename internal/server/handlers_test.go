@@ -8,14 +8,14 @@ import (
 	"os"
 	"testing"
 
-	"github.com/theshovonsaha/miniPam/internal/database"
+	"github.com/theshovonaha/mini-pam/internal/database"
 )
 
 func TestHealthHandler(t *testing.T) {
 	// Create a new server for testing
 	logger := log.New(os.Stdout, "", log.Ldate|log.Ltime)
 	db := &database.Connection{} // Add a nil database connection for testing
-	srv := NewServer("test", logger, db)
+	srv := NewServer(Config{Environment: "test"}, logger, db)
 
 	// Create a request to the health endpoint
 	req, err := http.NewRequest("GET", "/api/v1/health", nil)
@@ -55,7 +55,7 @@ func TestVersionHandler(t *testing.T) {
 	// Create a new server for testing
 	logger := log.New(os.Stdout, "", log.Ldate|log.Ltime)
 	db := &database.Connection{} // Add a nil or mock database connection
-	srv := NewServer("test", logger, db)
+	srv := NewServer(Config{Environment: "test"}, logger, db)
 
 	// Create a request to the version endpoint
 	req, err := http.NewRequest("GET", "/api/v1/version", nil)
@@ -2,6 +2,7 @@ package server
 
 import (
 	"encoding/json"
+	"net"
 	"net/http"
 )
 
@@ -10,6 +11,28 @@ type ErrorResponse struct {
 	Error string `json:"error"`
 }
 
+// MFARequiredResponse is returned when a sensitive operation needs a fresh step-up
+type MFARequiredResponse struct {
+	Error         string `json:"error"`
+	Code          string `json:"code"`
+	StepUpURL     string `json:"step_up_url"`
+	MaxAgeSeconds int    `json:"max_age_seconds"`
+}
+
+// PasswordPolicyResponse is returned when a proposed password is rejected
+type PasswordPolicyResponse struct {
+	Error    string   `json:"error"`
+	Code     string   `json:"code"`
+	Problems []string `json:"problems"`
+}
+
+// PasswordExpiredResponse is returned at login when a password is past its maximum age
+type PasswordExpiredResponse struct {
+	Error     string `json:"error"`
+	Code      string `json:"code"`
+	ChangeURL string `json:"change_url"`
+}
+
 // respondError is a helper for sending error responses
 func (s *Server) respondError(w http.ResponseWriter, status int, message string) {
 	s.respondJSON(w, status, ErrorResponse{Error: message})
@@ -38,3 +61,12 @@ func (s *Server) readJSON(w http.ResponseWriter, r *http.Request, dst interface{
 
 	return nil
 }
+
+// clientIP returns the IP address of the remote end of the request
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,225 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/theshovonaha/mini-pam/internal/models"
+	"github.com/theshovonaha/mini-pam/internal/notify"
+)
+
+// loginSubject normalises a username for failure counting, so that unknown
+// usernames are throttled exactly like real ones
+func loginSubject(username string) string {
+	return strings.ToLower(strings.TrimSpace(username))
+}
+
+// loginRetryAfter returns how long the caller must wait before the next login
+// attempt for username from ip, or zero if it may proceed now
+func (s *Server) loginRetryAfter(username, ip string) (time.Duration, error) {
+	policy := s.config.Lockout
+	now := time.Now()
+	var wait time.Duration
+
+	for _, scope := range []struct{ name, subject string }{
+		{models.LoginScopeUser, username},
+		{models.LoginScopeIP, ip},
+	} {
+		attempt, err := s.models.LoginAttempts.Get(scope.name, scope.subject)
+		if err != nil {
+			if errors.Is(err, models.ErrRecordNotFound) {
+				continue
+			}
+			return 0, err
+		}
+
+		if attempt.LockedUntil != nil && now.Before(*attempt.LockedUntil) {
+			wait = max(wait, attempt.LockedUntil.Sub(now))
+			continue
+		}
+
+		// Progressive delays apply per account only; a shared NAT address
+		// should not slow everyone behind it down
+		if scope.name == models.LoginScopeUser && now.Sub(attempt.LastFailureAt) < policy.Window {
+			next := attempt.LastFailureAt.Add(policy.Delay(attempt.Failures))
+			wait = max(wait, next.Sub(now))
+		}
+	}
+
+	return wait, nil
+}
+
+// reserveLogin reserves an attempt against the account and source address
+// before a password or code is checked, counting it as a failure until it is
+// released, so concurrent guesses cannot get past the lockout threshold. It
+// returns how long the caller must wait instead if either is throttled.
+func (s *Server) reserveLogin(username, ip string) (time.Duration, error) {
+	wait, err := s.loginRetryAfter(username, ip)
+	if err != nil || wait > 0 {
+		return wait, err
+	}
+
+	policy := s.config.Lockout
+	if _, err := s.models.LoginAttempts.Reserve(models.LoginScopeUser, username, policy.Window, policy.Threshold); err != nil {
+		return s.reservationRefused(username, ip, err)
+	}
+	if _, err := s.models.LoginAttempts.Reserve(models.LoginScopeIP, ip, policy.Window, policy.IPThreshold); err != nil {
+		if err := s.models.LoginAttempts.Release(models.LoginScopeUser, username); err != nil {
+			s.logger.Printf("Error releasing login attempt: %v", err)
+		}
+		return s.reservationRefused(username, ip, err)
+	}
+
+	return 0, nil
+}
+
+// reservationRefused turns a failed reservation into a wait, which is at
+// least a second when other attempts in flight hold every remaining one
+func (s *Server) reservationRefused(username, ip string, err error) (time.Duration, error) {
+	if !errors.Is(err, models.ErrLoginThrottled) {
+		return 0, err
+	}
+	wait, err := s.loginRetryAfter(username, ip)
+	return max(wait, time.Second), err
+}
+
+// reserveLoginAttempt reserves a password or code attempt, responding with
+// 429 and reporting false if the account or source address must wait first.
+// The caller settles the attempt with recordLoginFailure, clearLoginFailures
+// or releaseLoginAttempt.
+func (s *Server) reserveLoginAttempt(w http.ResponseWriter, r *http.Request, username, ip string) bool {
+	wait, err := s.reserveLogin(username, ip)
+	if err != nil {
+		s.logger.Printf("Error checking login attempts: %v", err)
+		s.respondError(w, http.StatusInternalServerError, "Failed to check login attempts")
+		return false
+	}
+	if wait <= 0 {
+		return true
+	}
+
+	s.auditLoginEvent(r, 0, "login_blocked", fmt.Sprintf("Throttled login for %q from %s", username, ip))
+	w.Header().Set("Retry-After", strconv.Itoa(int(wait.Round(time.Second).Seconds())+1))
+	s.respondError(w, http.StatusTooManyRequests, "Too many failed login attempts, try again later")
+	return false
+}
+
+// releaseLoginAttempt gives back a reserved attempt whose outcome was
+// neither success nor failure, such as a backend error
+func (s *Server) releaseLoginAttempt(username, ip string) {
+	for _, scope := range []struct{ name, subject string }{
+		{models.LoginScopeUser, username},
+		{models.LoginScopeIP, ip},
+	} {
+		if err := s.models.LoginAttempts.Release(scope.name, scope.subject); err != nil {
+			s.logger.Printf("Error releasing login attempt: %v", err)
+		}
+	}
+}
+
+// clearLoginFailures settles a reserved attempt that succeeded, clearing the
+// account's failure counter and giving the source address its attempt back
+func (s *Server) clearLoginFailures(username, ip string) {
+	if err := s.models.LoginAttempts.Reset(models.LoginScopeUser, username); err != nil {
+		s.logger.Printf("Error resetting login attempts: %v", err)
+	}
+	if err := s.models.LoginAttempts.Release(models.LoginScopeIP, ip); err != nil {
+		s.logger.Printf("Error releasing login attempt: %v", err)
+	}
+}
+
+// recordLoginFailure settles a reserved attempt that failed, locking the
+// account or source IP out once its threshold is reached, and audits it
+func (s *Server) recordLoginFailure(r *http.Request, username, ip, reason string) {
+	for _, entry := range s.countLoginFailure(username, ip, reason) {
+		s.audit(r, entry)
+	}
+}
+
+// countLoginFailure settles a failed attempt, already counted when it was
+// reserved, and applies any lockout, returning the audit entries describing
+// what happened for the caller to record
+func (s *Server) countLoginFailure(username, ip, reason string) []*models.AuditLog {
+	policy := s.config.Lockout
+
+	// Attribute the failure to the account if it exists
+	var userID int
+	if user, err := s.models.Users.GetByUsername(username); err == nil {
+		userID = user.ID
+	}
+
+	entries := []*models.AuditLog{
+		loginEvent(userID, "login_failed", fmt.Sprintf("Failed login for %q from %s: %s", username, ip, reason)),
+	}
+
+	for _, scope := range []struct {
+		name, subject string
+		threshold     int
+	}{
+		{models.LoginScopeUser, username, policy.Threshold},
+		{models.LoginScopeIP, ip, policy.IPThreshold},
+	} {
+		attempt, err := s.models.LoginAttempts.Get(scope.name, scope.subject)
+		if err != nil {
+			s.logger.Printf("Error getting login attempts: %v", err)
+			continue
+		}
+		if scope.threshold <= 0 || attempt.Failures < scope.threshold {
+			continue
+		}
+
+		// Concurrent failures may all reach the threshold; only the first locks
+		until := time.Now().Add(policy.Duration)
+		locked, err := s.models.LoginAttempts.Lock(scope.name, scope.subject, until)
+		if err != nil {
+			s.logger.Printf("Error locking out %s %s: %v", scope.name, scope.subject, err)
+			continue
+		}
+		if !locked {
+			continue
+		}
+
+		if scope.name == models.LoginScopeUser && userID != 0 {
+			s.notifyLockout(userID, username, ip, attempt.Failures, until)
+		}
+
+		entries = append(entries, loginEvent(userID, "lockout", fmt.Sprintf("Locked out %s %q after %d failed logins until %s",
+			scope.name, scope.subject, attempt.Failures, until.UTC().Format(time.RFC3339))))
+	}
+
+	return entries
+}
+
+// auditLoginEvent records a login related audit entry against a user, if known
+func (s *Server) auditLoginEvent(r *http.Request, userID int, action, details string) {
+	s.audit(r, loginEvent(userID, action, details))
+}
+
+// loginEvent describes a login related event against a user, if known
+func loginEvent(userID int, action, details string) *models.AuditLog {
+	return &models.AuditLog{
+		UserID:     userID,
+		Action:     action,
+		Resource:   "user",
+		ResourceID: userID,
+		Details:    details,
+	}
+}
+
+// notifyLockout tells a user their account was locked, so they can raise
+// the alarm if the failed logins were not theirs
+func (s *Server) notifyLockout(userID int, username, ip string, failures int, until time.Time) {
+	err := s.config.Notifier.Notify(userID, notify.EventAccountLocked, map[string]any{
+		"Username":    username,
+		"Failures":    failures,
+		"IPAddress":   ip,
+		"LockedUntil": until,
+	})
+	if err != nil {
+		s.logger.Printf("Error notifying user %d of lockout: %v", userID, err)
+	}
+}
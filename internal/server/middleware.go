@@ -1,10 +1,16 @@
 package server
 
 import (
+	"crypto/subtle"
+	"errors"
 	"net/http"
 	"runtime/debug"
+	"strings"
 	"time"
 
+	"github.com/gorilla/mux"
+	"github.com/theshovonaha/mini-pam/internal/auth"
+	"github.com/theshovonaha/mini-pam/internal/models"
 	"golang.org/x/time/rate"
 )
 
@@ -43,18 +49,208 @@ func (s *Server) recoverPanicMiddleware(next http.Handler) http.Handler {
 	})
 }
 
+// authMiddleware resolves the bearer token to an active session or API key,
+// falling back to a verified client certificate when no token is presented
 func (s *Server) authMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		token := r.Header.Get("Authorization")
-		if token == "" {
+		header := r.Header.Get("Authorization")
+		if header == "" {
+			if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+				s.authenticateCertificate(w, r, next)
+				return
+			}
 			s.respondError(w, http.StatusUnauthorized, "Missing authorization token")
 			return
 		}
-		// Validate JWT token here
-		next.ServeHTTP(w, r)
+
+		token, found := strings.CutPrefix(header, "Bearer ")
+		if !found || token == "" {
+			s.respondError(w, http.StatusUnauthorized, "Invalid authorization header")
+			return
+		}
+
+		if auth.IsAPIKey(token) {
+			s.authenticateAPIKey(w, r, token, next)
+			return
+		}
+
+		// Look up the session by the hash of the presented token
+		session, err := s.models.Sessions.GetActiveByTokenHash(auth.HashToken(token))
+		if err != nil {
+			if errors.Is(err, models.ErrRecordNotFound) {
+				s.respondError(w, http.StatusUnauthorized, "Invalid or expired token")
+			} else {
+				s.logger.Printf("Error validating token: %v", err)
+				s.respondError(w, http.StatusInternalServerError, "Failed to validate token")
+			}
+			return
+		}
+
+		next.ServeHTTP(w, s.contextSetSession(r, session))
 	})
 }
 
+// authenticateAPIKey validates an API key and, if the matched route accepts
+// API keys, continues with a service account principal
+func (s *Server) authenticateAPIKey(w http.ResponseWriter, r *http.Request, token string, next http.Handler) {
+	prefix, secret, ok := auth.ParseAPIKey(token)
+	if !ok {
+		s.respondError(w, http.StatusUnauthorized, "Invalid API key")
+		return
+	}
+
+	key, err := s.models.ServiceAccounts.GetKeyByPrefix(prefix)
+	if err != nil {
+		if errors.Is(err, models.ErrRecordNotFound) {
+			s.respondError(w, http.StatusUnauthorized, "Invalid API key")
+		} else {
+			s.logger.Printf("Error validating API key: %v", err)
+			s.respondError(w, http.StatusInternalServerError, "Failed to validate API key")
+		}
+		return
+	}
+
+	if subtle.ConstantTimeCompare([]byte(auth.HashToken(secret)), []byte(key.SecretHash)) != 1 ||
+		key.RevokedAt != nil || (key.ExpiresAt != nil && time.Now().After(*key.ExpiresAt)) {
+		s.respondError(w, http.StatusUnauthorized, "Invalid or expired API key")
+		return
+	}
+
+	ip := clientIP(r)
+	if !auth.IPAllowed(key.AllowedIPs, ip) {
+		s.respondError(w, http.StatusForbidden, "API key is not allowed from this address")
+		return
+	}
+
+	account, err := s.models.ServiceAccounts.GetByID(key.ServiceAccountID)
+	if err != nil && !errors.Is(err, models.ErrRecordNotFound) {
+		s.logger.Printf("Error loading service account: %v", err)
+		s.respondError(w, http.StatusInternalServerError, "Failed to validate API key")
+		return
+	}
+	if err != nil || !account.Active {
+		s.respondError(w, http.StatusUnauthorized, "Invalid or expired API key")
+		return
+	}
+
+	// API keys are only accepted on routes that opted in with allowServiceAccount
+	if _, allowed := s.serviceAccountRoutes[mux.CurrentRoute(r)]; !allowed {
+		s.respondError(w, http.StatusForbidden, "This endpoint does not accept API keys")
+		return
+	}
+
+	// Only keys that were let through count as used
+	if err := s.models.ServiceAccounts.TouchKey(key.ID, ip); err != nil {
+		s.logger.Printf("Error recording API key use: %v", err)
+	}
+
+	next.ServeHTTP(w, s.contextSetPrincipal(r, &Principal{ServiceAccountID: account.ID, APIKey: key}))
+}
+
+// authenticateCertificate maps a verified client certificate to a service
+// account binding and, if the matched route accepts service accounts,
+// continues with a service account principal
+func (s *Server) authenticateCertificate(w http.ResponseWriter, r *http.Request, next http.Handler) {
+	identities := auth.CertificateIdentities(r.TLS.VerifiedChains[0][0])
+
+	cert, err := s.models.ServiceAccounts.GetCertificateByIdentities(identities)
+	if err != nil {
+		if errors.Is(err, models.ErrRecordNotFound) {
+			s.respondError(w, http.StatusUnauthorized, "Client certificate is not bound to a service account")
+		} else {
+			s.logger.Printf("Error validating client certificate: %v", err)
+			s.respondError(w, http.StatusInternalServerError, "Failed to validate client certificate")
+		}
+		return
+	}
+
+	account, err := s.models.ServiceAccounts.GetByID(cert.ServiceAccountID)
+	if err != nil && !errors.Is(err, models.ErrRecordNotFound) {
+		s.logger.Printf("Error loading service account: %v", err)
+		s.respondError(w, http.StatusInternalServerError, "Failed to validate client certificate")
+		return
+	}
+	if err != nil || !account.Active {
+		s.respondError(w, http.StatusUnauthorized, "Client certificate is not bound to a service account")
+		return
+	}
+
+	// Certificates are only accepted on routes that opted in with allowServiceAccount
+	if _, allowed := s.serviceAccountRoutes[mux.CurrentRoute(r)]; !allowed {
+		s.respondError(w, http.StatusForbidden, "This endpoint does not accept client certificates")
+		return
+	}
+
+	// Only certificates that were let through count as used
+	if err := s.models.ServiceAccounts.TouchCertificate(cert.ID); err != nil {
+		s.logger.Printf("Error recording certificate use: %v", err)
+	}
+
+	next.ServeHTTP(w, s.contextSetPrincipal(r, &Principal{ServiceAccountID: account.ID, Certificate: cert}))
+}
+
+// allowServiceAccount marks a route as usable by service accounts, with an
+// API key or a client certificate. The handler is still responsible for
+// checking the principal's scopes against the target resource.
+func (s *Server) allowServiceAccount(route *mux.Route) {
+	s.serviceAccountRoutes[route] = true
+}
+
+// requireMFA wraps a sensitive handler so it only runs after a recent step-up
+func (s *Server) requireMFA(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		principal := s.contextGetPrincipal(r)
+		if principal == nil {
+			s.respondError(w, http.StatusUnauthorized, "Authentication required")
+			return
+		}
+
+		// Service accounts cannot step up; their scopes gate access instead
+		if principal.ServiceAccountID != 0 {
+			next(w, r)
+			return
+		}
+
+		session := principal.Session
+
+		if session.MFAVerifiedAt == nil || time.Since(*session.MFAVerifiedAt) > s.config.MFAFreshness {
+			s.respondJSON(w, http.StatusForbidden, MFARequiredResponse{
+				Error:         "Recent multi-factor verification required",
+				Code:          "mfa_required",
+				StepUpURL:     "/api/v1/auth/step-up",
+				MaxAgeSeconds: int(s.config.MFAFreshness.Seconds()),
+			})
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+// requireAdmin wraps a handler so only users holding the admin role can call it
+func (s *Server) requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		principal := s.contextGetPrincipal(r)
+		if principal == nil || principal.UserID == 0 {
+			s.respondError(w, http.StatusForbidden, "Administrator access required")
+			return
+		}
+
+		admin, err := s.isAdmin(principal.UserID)
+		if err != nil {
+			s.logger.Printf("Error checking admin role: %v", err)
+			s.respondError(w, http.StatusInternalServerError, "Failed to check permissions")
+			return
+		}
+		if !admin {
+			s.respondError(w, http.StatusForbidden, "Administrator access required")
+			return
+		}
+
+		next(w, r)
+	}
+}
+
 func (s *Server) rateLimitMiddleware(next http.Handler) http.Handler {
 	limiter := rate.NewLimiter(rate.Every(1*time.Second), 10)
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -0,0 +1,62 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/theshovonaha/mini-pam/internal/database"
+	"github.com/theshovonaha/mini-pam/internal/models"
+)
+
+func TestRequireMFA(t *testing.T) {
+	// Create a new server for testing
+	logger := log.New(os.Stdout, "", log.Ldate|log.Ltime)
+	db := &database.Connection{}
+	srv := NewServer(Config{Environment: "test", MFAFreshness: 5 * time.Minute}, logger, db)
+
+	handler := srv.requireMFA(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	stale := time.Now().Add(-10 * time.Minute)
+	fresh := time.Now().Add(-1 * time.Minute)
+	tests := []struct {
+		name       string
+		verifiedAt *time.Time
+		want       int
+	}{
+		{"never verified", nil, http.StatusForbidden},
+		{"stale verification", &stale, http.StatusForbidden},
+		{"fresh verification", &fresh, http.StatusNoContent},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest("DELETE", "/api/v1/users/1", nil)
+		req = srv.contextSetSession(req, &models.Session{ID: 1, UserID: 1, MFAVerifiedAt: tt.verifiedAt})
+
+		rr := httptest.NewRecorder()
+		handler(rr, req)
+
+		// Check the status code
+		if rr.Code != tt.want {
+			t.Errorf("%s: handler returned wrong status code: got %v want %v", tt.name, rr.Code, tt.want)
+			continue
+		}
+
+		// Check that rejections carry the structured error code
+		if tt.want == http.StatusForbidden {
+			var response MFARequiredResponse
+			if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+				t.Fatal(err)
+			}
+			if response.Code != "mfa_required" {
+				t.Errorf("%s: handler returned wrong code: got %v want %v", tt.name, response.Code, "mfa_required")
+			}
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/theshovonaha/mini-pam/internal/models"
+	"github.com/theshovonaha/mini-pam/internal/notify"
+)
+
+// NotificationPreferencesResponse lists a user's preferences along with the
+// channels and events they can choose from
+type NotificationPreferencesResponse struct {
+	Channels    []string                         `json:"channels"`
+	Events      []string                         `json:"events"`
+	Preferences []*models.NotificationPreference `json:"preferences"`
+}
+
+// NotificationPreferenceRequest represents the request body for setting a preference
+type NotificationPreferenceRequest struct {
+	Channels []string `json:"channels"`
+}
+
+// notificationEvent resolves the {event} path variable, responding if it is unknown
+func (s *Server) notificationEvent(w http.ResponseWriter, r *http.Request) (string, bool) {
+	event := mux.Vars(r)["event"]
+	if event != notify.AllEvents && !slices.Contains(s.config.Notifier.Events(), event) {
+		s.respondError(w, http.StatusNotFound, "Unknown notification event: "+event)
+		return "", false
+	}
+	return event, true
+}
+
+// handleGetNotificationPreferences returns a handler for reading the
+// caller's notification preferences
+func (s *Server) handleGetNotificationPreferences() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		principal := s.contextGetPrincipal(r)
+
+		preferences, err := s.models.Notifications.GetPreferences(principal.UserID)
+		if err != nil {
+			s.logger.Printf("Error getting notification preferences: %v", err)
+			s.respondError(w, http.StatusInternalServerError, "Failed to get preferences")
+			return
+		}
+
+		s.respondJSON(w, http.StatusOK, NotificationPreferencesResponse{
+			Channels:    s.config.Notifier.Channels(),
+			Events:      append([]string{notify.AllEvents}, s.config.Notifier.Events()...),
+			Preferences: preferences,
+		})
+	}
+}
+
+// handleSetNotificationPreference returns a handler that sets the channels
+// the caller is notified through for an event; an empty list opts out
+func (s *Server) handleSetNotificationPreference() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		principal := s.contextGetPrincipal(r)
+		event, ok := s.notificationEvent(w, r)
+		if !ok {
+			return
+		}
+
+		// Parse the request body
+		var req NotificationPreferenceRequest
+		if err := s.readJSON(w, r, &req); err != nil || req.Channels == nil {
+			s.respondError(w, http.StatusBadRequest, "Invalid request payload")
+			return
+		}
+		for _, channel := range req.Channels {
+			if !slices.Contains(s.config.Notifier.Channels(), channel) {
+				s.respondError(w, http.StatusBadRequest, "Unknown notification channel: "+channel)
+				return
+			}
+		}
+
+		preference := &models.NotificationPreference{
+			UserID:   principal.UserID,
+			Event:    event,
+			Channels: slices.Compact(slices.Sorted(slices.Values(req.Channels))),
+		}
+		if err := s.models.Notifications.SetPreference(preference); err != nil {
+			s.logger.Printf("Error setting notification preference: %v", err)
+			s.respondError(w, http.StatusInternalServerError, "Failed to set preference")
+			return
+		}
+
+		s.respondJSON(w, http.StatusOK, preference)
+	}
+}
+
+// handleDeleteNotificationPreference returns a handler that removes the
+// caller's preference for an event, falling back to their default
+func (s *Server) handleDeleteNotificationPreference() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		principal := s.contextGetPrincipal(r)
+		event, ok := s.notificationEvent(w, r)
+		if !ok {
+			return
+		}
+
+		if err := s.models.Notifications.DeletePreference(principal.UserID, event); err != nil {
+			if errors.Is(err, models.ErrRecordNotFound) {
+				s.respondError(w, http.StatusNotFound, "No preference set for this event")
+			} else {
+				s.logger.Printf("Error deleting notification preference: %v", err)
+				s.respondError(w, http.StatusInternalServerError, "Failed to delete preference")
+			}
+			return
+		}
+
+		s.respondJSON(w, http.StatusOK, map[string]string{"message": "Preference deleted"})
+	}
+}
+
+// handleListNotifications returns a handler listing the notification
+// outbox, optionally filtered by user or status
+func (s *Server) handleListNotifications() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		var filter models.NotificationFilter
+		if value := query.Get("user_id"); value != "" {
+			id, err := strconv.Atoi(value)
+			if err != nil || id <= 0 {
+				s.respondError(w, http.StatusBadRequest, "user_id must be a positive integer")
+				return
+			}
+			filter.UserID = id
+		}
+		switch status := query.Get("status"); status {
+		case "", models.NotificationPending, models.NotificationSent, models.NotificationFailed:
+			filter.Status = status
+		default:
+			s.respondError(w, http.StatusBadRequest, "status must be pending, sent or failed")
+			return
+		}
+
+		// Parse query parameters for pagination
+		page := 1
+		pageSize := 20
+
+		if pageStr := query.Get("page"); pageStr != "" {
+			if p, err := strconv.Atoi(pageStr); err == nil && p > 0 {
+				page = p
+			}
+		}
+
+		if pageSizeStr := query.Get("page_size"); pageSizeStr != "" {
+			if ps, err := strconv.Atoi(pageSizeStr); err == nil && ps > 0 && ps <= 100 {
+				pageSize = ps
+			}
+		}
+
+		notifications, err := s.models.Notifications.List(filter, page, pageSize)
+		if err != nil {
+			s.logger.Printf("Error listing notifications: %v", err)
+			s.respondError(w, http.StatusInternalServerError, "Failed to list notifications")
+			return
+		}
+
+		s.respondJSON(w, http.StatusOK, notifications)
+	}
+}
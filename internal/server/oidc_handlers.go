@@ -0,0 +1,77 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/theshovonaha/mini-pam/internal/auth"
+	"github.com/theshovonaha/mini-pam/internal/models"
+)
+
+// oidcStateTTL bounds how long a user may take at the identity provider
+const oidcStateTTL = 10 * time.Minute
+
+// handleOIDCLogin returns a handler that redirects the browser to the identity provider
+func (s *Server) handleOIDCLogin() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		login, err := s.config.OIDC.Begin()
+		if err != nil {
+			s.logger.Printf("Error starting OIDC login: %v", err)
+			s.respondError(w, http.StatusBadGateway, "Identity provider unavailable")
+			return
+		}
+
+		// Remember the nonce and PKCE verifier until the callback arrives
+		state := &models.OIDCLoginState{
+			StateHash:    auth.HashToken(login.State),
+			Nonce:        login.Nonce,
+			CodeVerifier: login.CodeVerifier,
+			ExpiresAt:    time.Now().Add(oidcStateTTL),
+		}
+		if err := s.models.OIDCStates.Create(state); err != nil {
+			s.logger.Printf("Error saving OIDC state: %v", err)
+			s.respondError(w, http.StatusInternalServerError, "Failed to start login")
+			return
+		}
+
+		http.Redirect(w, r, login.RedirectURL, http.StatusFound)
+	}
+}
+
+// handleOIDCCallback returns a handler that completes an authorization-code login
+func (s *Server) handleOIDCCallback() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		if errParam := query.Get("error"); errParam != "" {
+			s.respondError(w, http.StatusUnauthorized, "Identity provider returned an error: "+errParam)
+			return
+		}
+
+		code, stateParam := query.Get("code"), query.Get("state")
+		if code == "" || stateParam == "" {
+			s.respondError(w, http.StatusBadRequest, "Missing code or state")
+			return
+		}
+
+		state, err := s.models.OIDCStates.Consume(auth.HashToken(stateParam))
+		if err != nil {
+			if errors.Is(err, models.ErrRecordNotFound) {
+				s.respondError(w, http.StatusBadRequest, "Unknown or expired login state")
+			} else {
+				s.logger.Printf("Error loading OIDC state: %v", err)
+				s.respondError(w, http.StatusInternalServerError, "Failed to log in")
+			}
+			return
+		}
+
+		identity, err := s.config.OIDC.Exchange(code, state.CodeVerifier, state.Nonce)
+		if err != nil {
+			s.logger.Printf("Error completing OIDC login: %v", err)
+			s.respondError(w, http.StatusUnauthorized, "Identity provider login failed")
+			return
+		}
+
+		s.completeLogin(w, r, identity)
+	}
+}
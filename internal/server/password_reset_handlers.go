@@ -0,0 +1,247 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/theshovonaha/mini-pam/internal/auth"
+	"github.com/theshovonaha/mini-pam/internal/mail"
+	"github.com/theshovonaha/mini-pam/internal/models"
+)
+
+// PasswordResetRequest represents the request body for starting a password reset
+type PasswordResetRequest struct {
+	Email string `json:"email"`
+}
+
+// PasswordResetConfirmRequest represents the request body for completing a password reset
+type PasswordResetConfirmRequest struct {
+	Token       string `json:"token"`
+	NewPassword string `json:"new_password"`
+}
+
+// Password reset requests allowed per email address and per source IP
+// within resetWindow
+const (
+	resetWindow    = time.Hour
+	resetsPerEmail = 3
+	resetsPerIP    = 20
+)
+
+// handleRequestPasswordReset returns a handler that emails a single-use reset
+// token. It answers the same way whether or not the address is known, and
+// whatever goes wrong, so it cannot be used to discover accounts.
+func (s *Server) handleRequestPasswordReset() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Parse the request body
+		var req PasswordResetRequest
+		if err := s.readJSON(w, r, &req); err != nil || req.Email == "" {
+			s.respondError(w, http.StatusBadRequest, "An email address is required")
+			return
+		}
+
+		accepted := map[string]string{"message": "If the address belongs to an account, a reset link has been sent"}
+
+		if !s.allowPasswordReset(r, req.Email) {
+			s.respondJSON(w, http.StatusAccepted, accepted)
+			return
+		}
+
+		user, err := s.models.Users.GetByEmail(req.Email)
+		if err != nil {
+			if !errors.Is(err, models.ErrRecordNotFound) {
+				s.logger.Printf("Error getting user: %v", err)
+			}
+			s.respondJSON(w, http.StatusAccepted, accepted)
+			return
+		}
+		if !user.Active {
+			s.respondJSON(w, http.StatusAccepted, accepted)
+			return
+		}
+
+		// Passwords kept by a directory or identity provider are reset there
+		if user.AuthSource != models.AuthSourceLocal {
+			s.auditLoginEvent(r, user.ID, "password_reset_denied", "Password is managed by "+user.AuthSource)
+			s.respondJSON(w, http.StatusAccepted, accepted)
+			return
+		}
+
+		// Only the newest token is ever valid
+		if err := s.models.PasswordResets.InvalidateForUser(user.ID); err != nil {
+			s.logger.Printf("Error invalidating reset tokens: %v", err)
+			s.respondJSON(w, http.StatusAccepted, accepted)
+			return
+		}
+
+		token, hash, err := auth.GenerateToken()
+		if err != nil {
+			s.logger.Printf("Error generating token: %v", err)
+			s.respondJSON(w, http.StatusAccepted, accepted)
+			return
+		}
+
+		reset := &models.PasswordResetToken{
+			UserID:    user.ID,
+			TokenHash: hash,
+			ExpiresAt: time.Now().Add(s.config.PasswordResetTTL),
+			IPAddress: clientIP(r),
+		}
+		if err := s.models.PasswordResets.Create(reset); err != nil {
+			s.logger.Printf("Error creating reset token: %v", err)
+			s.respondJSON(w, http.StatusAccepted, accepted)
+			return
+		}
+
+		// Deliver in the background so response times don't depend on the relay
+		msg := passwordResetMessage(user, token, s.config.PasswordResetURL, reset.ExpiresAt)
+		go func() {
+			if err := s.config.Mailer.Send(msg); err != nil {
+				s.logger.Printf("Error sending password reset email: %v", err)
+			}
+		}()
+
+		// Create an audit log entry
+		s.audit(r, &models.AuditLog{
+			UserID:     user.ID,
+			Action:     "password_reset_requested",
+			Resource:   "user",
+			ResourceID: user.ID,
+			Details:    "Password reset email sent",
+		})
+
+		s.respondJSON(w, http.StatusAccepted, accepted)
+	}
+}
+
+// allowPasswordReset counts a reset request against the email address and
+// the source IP, reporting false once either has made too many requests
+// within resetWindow, so the endpoint cannot be used to flood a mailbox
+func (s *Server) allowPasswordReset(r *http.Request, email string) bool {
+	ip := clientIP(r)
+	for _, scope := range []struct {
+		name, subject string
+		limit         int
+	}{
+		{models.LoginScopeResetEmail, strings.ToLower(strings.TrimSpace(email)), resetsPerEmail},
+		{models.LoginScopeResetIP, ip, resetsPerIP},
+	} {
+		if _, err := s.models.LoginAttempts.Reserve(scope.name, scope.subject, resetWindow, scope.limit); err != nil {
+			if errors.Is(err, models.ErrLoginThrottled) {
+				s.auditLoginEvent(r, 0, "password_reset_blocked", fmt.Sprintf("Throttled password reset for %q from %s", email, ip))
+			} else {
+				s.logger.Printf("Error counting password reset requests: %v", err)
+			}
+			return false
+		}
+	}
+	return true
+}
+
+// passwordResetMessage renders the reset email. The token is embedded in
+// resetURL when one is configured, otherwise it is shown on its own.
+func passwordResetMessage(user *models.User, token, resetURL string, expiresAt time.Time) *mail.Message {
+	instructions := "Reset token: " + token
+	if link, err := url.Parse(resetURL); err == nil && resetURL != "" {
+		query := link.Query()
+		query.Set("token", token)
+		link.RawQuery = query.Encode()
+		instructions = "Reset your password: " + link.String()
+	}
+
+	return &mail.Message{
+		To:      []string{user.Email},
+		Subject: "mini-pam password reset",
+		Body: fmt.Sprintf("A password reset was requested for the mini-pam account %q.\n\n"+
+			"%s\n\n"+
+			"This can be used once and expires at %s.\n"+
+			"If you did not request a reset, ignore this email; your password has not changed.\n",
+			user.Username, instructions, expiresAt.UTC().Format(time.RFC1123)),
+	}
+}
+
+// handleConfirmPasswordReset returns a handler that redeems a reset token for a new password
+func (s *Server) handleConfirmPasswordReset() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Parse the request body
+		var req PasswordResetConfirmRequest
+		if err := s.readJSON(w, r, &req); err != nil {
+			s.respondError(w, http.StatusBadRequest, "Invalid request payload")
+			return
+		}
+		if req.Token == "" || req.NewPassword == "" {
+			s.respondError(w, http.StatusBadRequest, "Token and new password are required")
+			return
+		}
+
+		reset, err := s.models.PasswordResets.GetValid(auth.HashToken(req.Token))
+		if err != nil {
+			if errors.Is(err, models.ErrRecordNotFound) {
+				s.respondError(w, http.StatusBadRequest, "Invalid or expired reset token")
+			} else {
+				s.logger.Printf("Error getting reset token: %v", err)
+				s.respondError(w, http.StatusInternalServerError, "Failed to reset password")
+			}
+			return
+		}
+
+		user, err := s.models.Users.GetByID(reset.UserID)
+		if err != nil {
+			s.logger.Printf("Error getting user: %v", err)
+			s.respondError(w, http.StatusInternalServerError, "Failed to reset password")
+			return
+		}
+		if !user.Active || user.AuthSource != models.AuthSourceLocal {
+			s.respondError(w, http.StatusBadRequest, "Invalid or expired reset token")
+			return
+		}
+
+		// Check the policy first so a rejected password doesn't burn the token
+		if err := s.checkNewPassword(user, req.NewPassword); err != nil {
+			s.respondPasswordError(w, err)
+			return
+		}
+
+		if err := s.models.PasswordResets.Consume(reset.ID); err != nil {
+			if errors.Is(err, models.ErrRecordNotFound) {
+				s.respondError(w, http.StatusBadRequest, "Invalid or expired reset token")
+			} else {
+				s.logger.Printf("Error consuming reset token: %v", err)
+				s.respondError(w, http.StatusInternalServerError, "Failed to reset password")
+			}
+			return
+		}
+
+		if err := s.setPassword(user.ID, req.NewPassword); err != nil {
+			s.logger.Printf("Error updating password: %v", err)
+			s.respondError(w, http.StatusInternalServerError, "Failed to reset password")
+			return
+		}
+
+		// Whoever held the old password or an old token loses access
+		if err := s.models.Sessions.RevokeAllForUser(user.ID); err != nil {
+			s.logger.Printf("Error revoking sessions: %v", err)
+		}
+		if err := s.models.PasswordResets.InvalidateForUser(user.ID); err != nil {
+			s.logger.Printf("Error invalidating reset tokens: %v", err)
+		}
+		if err := s.models.LoginAttempts.Reset(models.LoginScopeUser, loginSubject(user.Username)); err != nil {
+			s.logger.Printf("Error resetting login attempts: %v", err)
+		}
+
+		// Create an audit log entry
+		s.audit(r, &models.AuditLog{
+			UserID:     user.ID,
+			Action:     "password_reset",
+			Resource:   "user",
+			ResourceID: user.ID,
+			Details:    "Password reset with emailed token; sessions revoked",
+		})
+
+		s.respondJSON(w, http.StatusOK, map[string]string{"message": "Password reset successfully"})
+	}
+}
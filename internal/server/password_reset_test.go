@@ -0,0 +1,42 @@
+package server
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/theshovonaha/mini-pam/internal/mail"
+	"github.com/theshovonaha/mini-pam/internal/mail/mailtest"
+	"github.com/theshovonaha/mini-pam/internal/models"
+)
+
+func TestPasswordResetMessage(t *testing.T) {
+	smtp := mailtest.NewServer()
+	defer smtp.Close()
+
+	user := &models.User{Username: "alice", Email: "alice@example.com"}
+	expires := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	msg := passwordResetMessage(user, "tok/en+1", "https://pam.example.com/reset?lang=en", expires)
+	if err := mail.NewSMTPMailer(mail.SMTPConfig{Addr: smtp.Addr, From: "pam@example.com"}).Send(msg); err != nil {
+		t.Fatal(err)
+	}
+
+	messages := smtp.Messages()
+	if len(messages) != 1 || messages[0].To[0] != "alice@example.com" {
+		t.Fatalf("expected one message to alice, got %+v", messages)
+	}
+	data := messages[0].Data
+	if !strings.Contains(data, "https://pam.example.com/reset?lang=en&token=tok%2Fen%2B1") {
+		t.Errorf("reset link missing or not escaped:\n%s", data)
+	}
+	if !strings.Contains(data, "Wed, 02 Jan 2030 03:04:05 UTC") {
+		t.Errorf("expiry missing:\n%s", data)
+	}
+
+	// Without a reset page the bare token is sent
+	msg = passwordResetMessage(user, "abc123", "", expires)
+	if !strings.Contains(msg.Body, "Reset token: abc123") {
+		t.Errorf("bare token missing:\n%s", msg.Body)
+	}
+}
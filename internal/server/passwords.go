@@ -0,0 +1,102 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/theshovonaha/mini-pam/internal/auth"
+	"github.com/theshovonaha/mini-pam/internal/models"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// checkNewPassword applies the password policy to a proposed password and,
+// for existing users, rejects reuse of recent passwords. Violations are
+// returned as a *auth.PasswordPolicyError.
+func (s *Server) checkNewPassword(user *models.User, password string) error {
+	policy := s.config.PasswordPolicy
+
+	var problems []string
+	var policyErr *auth.PasswordPolicyError
+	if err := policy.Validate(password, user.Username, user.Email); errors.As(err, &policyErr) {
+		problems = policyErr.Problems
+	}
+
+	if user.ID != 0 && policy.HistorySize > 0 {
+		reused, err := s.passwordReused(user, password)
+		if err != nil {
+			return err
+		}
+		if reused {
+			problems = append(problems, fmt.Sprintf("must not match any of the last %d passwords", policy.HistorySize))
+		}
+	}
+
+	if len(problems) > 0 {
+		return &auth.PasswordPolicyError{Problems: problems}
+	}
+	return nil
+}
+
+// passwordReused reports whether password matches the user's current password
+// or one of the passwords kept in their history
+func (s *Server) passwordReused(user *models.User, password string) (bool, error) {
+	hashes, err := s.models.PasswordHistory.Recent(user.ID, s.config.PasswordPolicy.HistorySize)
+	if err != nil {
+		return false, err
+	}
+
+	for _, hash := range append([]string{user.HashedPassword}, hashes...) {
+		if bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// hashPassword returns the bcrypt hash stored for a password
+func hashPassword(password string) (string, error) {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), 12)
+	if err != nil {
+		return "", err
+	}
+	return string(hashedPassword), nil
+}
+
+// setPassword hashes and stores a new password that already passed checkNewPassword
+func (s *Server) setPassword(userID int, password string) error {
+	hashedPassword, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	if err := s.models.Users.UpdatePassword(userID, hashedPassword); err != nil {
+		return err
+	}
+
+	return s.recordPasswordHistory(userID, hashedPassword)
+}
+
+// recordPasswordHistory remembers a newly set password hash for reuse checks
+func (s *Server) recordPasswordHistory(userID int, hashedPassword string) error {
+	if s.config.PasswordPolicy.HistorySize <= 0 {
+		return nil
+	}
+	return s.models.PasswordHistory.Add(userID, hashedPassword, s.config.PasswordPolicy.HistorySize)
+}
+
+// respondPasswordError reports a rejected password, or an internal error
+func (s *Server) respondPasswordError(w http.ResponseWriter, err error) {
+	var policyErr *auth.PasswordPolicyError
+	if errors.As(err, &policyErr) {
+		s.respondJSON(w, http.StatusBadRequest, PasswordPolicyResponse{
+			Error:    "Password does not meet the password policy",
+			Code:     "password_policy",
+			Problems: policyErr.Problems,
+		})
+		return
+	}
+
+	s.logger.Printf("Error checking password: %v", err)
+	s.respondError(w, http.StatusInternalServerError, "Failed to check password")
+}
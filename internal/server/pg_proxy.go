@@ -0,0 +1,152 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/theshovonaha/mini-pam/internal/models"
+	"github.com/theshovonaha/mini-pam/internal/pgproxy"
+)
+
+// pgBackend authenticates and authorizes Postgres proxy users against the
+// same accounts, login throttling and credential grants as the API, and
+// logs their sessions and queries
+type pgBackend struct {
+	s *Server
+}
+
+// PGBackend returns the backend the Postgres proxy authenticates users through
+func (s *Server) PGBackend() pgproxy.Backend {
+	return &pgBackend{s: s}
+}
+
+// pgClient describes a Postgres proxy client
+func pgClient(client pgproxy.Client) proxyClient {
+	return proxyClient{
+		Protocol: "Postgres",
+		Prefix:   "db",
+		IP:       addrIP(client.RemoteAddr),
+		Agent:    client.ApplicationName,
+	}
+}
+
+// Authenticate verifies a Postgres login password, sharing the API's
+// throttle. Postgres clients send a single password, so users with a second
+// factor append their current code to it as password:code.
+func (b *pgBackend) Authenticate(client pgproxy.Client, username, password string) (int, error) {
+	// Whether a code is expected depends on the account, never on the shape
+	// of the password, which may contain colons itself
+	code := ""
+	if user, err := b.s.models.Users.GetByUsername(username); err == nil {
+		enrolled, err := b.s.mfaEnrolled(user.ID)
+		if err != nil {
+			return 0, errProxyLoginFailed
+		}
+		if i := strings.LastIndex(password, ":"); enrolled && i >= 0 {
+			password, code = password[:i], password[i+1:]
+		}
+	} else if !errors.Is(err, models.ErrRecordNotFound) {
+		b.s.logger.Printf("Error getting Postgres user: %v", err)
+		return 0, errProxyLoginFailed
+	}
+
+	userID, needMFA, err := b.s.proxyAuthenticate(pgClient(client), username, password)
+	if errors.Is(err, errPasswordExpired) {
+		return 0, errors.New("your password has expired and must be changed before connecting")
+	}
+	if err != nil {
+		return 0, err
+	}
+	if needMFA {
+		// Only someone who knows the password learns that a code is missing
+		if code == "" {
+			return 0, errors.New("a verification code is required, send your password as password:code")
+		}
+		if err := b.s.proxyVerifyCode(pgClient(client), username, userID, code); err != nil {
+			return 0, err
+		}
+	}
+	return userID, nil
+}
+
+// Authorize resolves a target, given as a credential ID or a unique name, to
+// a database or password credential the user may reveal
+func (b *pgBackend) Authorize(client pgproxy.Client, userID int, target string) (*models.Credential, error) {
+	return b.s.proxyAuthorize(pgClient(client), userID, target, "database", "password")
+}
+
+// Opened records the session and the use of the credential once the target
+// accepted it
+func (b *pgBackend) Opened(conn *pgproxy.Conn) error {
+	s := b.s
+
+	session := &models.DBSession{
+		UserID:          conn.UserID,
+		CredentialID:    conn.Credential.ID,
+		Target:          conn.Addr,
+		Database:        conn.Database,
+		TargetUsername:  conn.Credential.Username,
+		IPAddress:       addrIP(conn.RemoteAddr),
+		ApplicationName: conn.ApplicationName,
+	}
+	if err := s.models.DBSessions.Create(session); err != nil {
+		return err
+	}
+	conn.ID = session.ID
+
+	access := &models.CredentialAccess{
+		UserID:       conn.UserID,
+		CredentialID: conn.Credential.ID,
+		IPAddress:    session.IPAddress,
+		UserAgent:    conn.ApplicationName,
+		Reason:       fmt.Sprintf("Postgres session %d to %s/%s", session.ID, conn.Addr, conn.Database),
+	}
+	if err := s.recordCredentialAccess(access); err != nil {
+		s.logger.Printf("Error logging credential access: %v", err)
+	}
+
+	// Create an audit log entry
+	s.record(&models.AuditLog{
+		UserID:     conn.UserID,
+		Action:     "db_connect",
+		Resource:   "credential",
+		ResourceID: conn.Credential.ID,
+		Details:    fmt.Sprintf("Postgres session %d opened to %s/%s as %s", session.ID, conn.Addr, conn.Database, conn.Credential.Username),
+		IPAddress:  session.IPAddress,
+		UserAgent:  conn.ApplicationName,
+	})
+	return nil
+}
+
+// Query logs a query before the proxy forwards it. A query that cannot be
+// logged ends the session.
+func (b *pgBackend) Query(conn *pgproxy.Conn, query string) error {
+	return b.s.models.DBSessions.AddQuery(&models.DBQuery{SessionID: conn.ID, Query: query})
+}
+
+// Closed records the end of a session
+func (b *pgBackend) Closed(conn *pgproxy.Conn) {
+	s := b.s
+
+	if err := s.models.DBSessions.Finish(conn.ID); err != nil {
+		s.logger.Printf("Error finishing Postgres session %d: %v", conn.ID, err)
+	}
+
+	details := fmt.Sprintf("Postgres session %d to %s/%s closed after %s", conn.ID, conn.Addr, conn.Database, conn.EndedAt.Sub(conn.StartedAt).Round(time.Second))
+	if conn.Err != nil {
+		details += ": " + conn.Err.Error()
+	}
+
+	// Create an audit log entry
+	s.record(&models.AuditLog{
+		UserID:     conn.UserID,
+		Action:     "db_disconnect",
+		Resource:   "credential",
+		ResourceID: conn.Credential.ID,
+		Details:    details,
+		IPAddress:  addrIP(conn.RemoteAddr),
+		UserAgent:  conn.ApplicationName,
+	})
+}
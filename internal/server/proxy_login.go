@@ -0,0 +1,219 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/theshovonaha/mini-pam/internal/auth"
+	"github.com/theshovonaha/mini-pam/internal/models"
+	"golang.org/x/crypto/ssh"
+)
+
+// errProxyLoginFailed is the only reason protocol proxy clients are given
+// for a failed login, whatever the cause
+var errProxyLoginFailed = errors.New("login failed")
+
+// errPasswordExpired refuses proxy logins with an expired local password
+var errPasswordExpired = errors.New("password expired")
+
+// proxyClient describes a client of one of the protocol proxies, the SSH
+// bastion or the Postgres proxy, for throttling and audit entries
+type proxyClient struct {
+	Protocol string // Names the proxy in audit details, e.g. "SSH"
+	Prefix   string // Prefixes proxy specific audit actions, e.g. "ssh"
+	IP       string
+	Agent    string // Client version or application name
+}
+
+// addrIP returns the IP address of a proxy client's remote address
+func addrIP(addr net.Addr) string {
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
+
+// recordProxy completes an audit entry with the proxy client's address and
+// version and records it
+func (s *Server) recordProxy(client proxyClient, entry *models.AuditLog) {
+	entry.IPAddress = client.IP
+	entry.UserAgent = client.Agent
+	s.record(entry)
+}
+
+// proxyAuthenticate verifies a proxy login password, sharing the API's
+// throttle, and reports whether the user must verify a second factor too.
+// Expired local passwords fail with errPasswordExpired, since they can only
+// be changed through the API.
+func (s *Server) proxyAuthenticate(client proxyClient, username, password string) (int, bool, error) {
+	// Refuse attempts while the account or source address is throttled
+	subject := loginSubject(username)
+	if !s.reserveProxyLogin(client, subject) {
+		return 0, false, errProxyLoginFailed
+	}
+
+	// Verify the password with the configured backend
+	identity, err := s.authenticator.Authenticate(username, password)
+	if err != nil {
+		if errors.Is(err, auth.ErrInvalidCredentials) {
+			for _, entry := range s.countLoginFailure(subject, client.IP, "invalid "+client.Protocol+" credentials") {
+				s.recordProxy(client, entry)
+			}
+		} else {
+			s.releaseLoginAttempt(subject, client.IP)
+			s.logger.Printf("Error authenticating %s user: %v", client.Protocol, err)
+		}
+		return 0, false, errProxyLoginFailed
+	}
+
+	// A successful password check clears the account's failure counter
+	s.clearLoginFailures(subject, client.IP)
+
+	user, err := s.resolveLoginUser(identity)
+	if err != nil {
+		if errors.Is(err, errIdentityConflict) {
+			s.recordProxy(client, loginEvent(0, "login_denied", fmt.Sprintf("%s identity %q matches an account %s does not manage",
+				identity.Source, identity.Username, identity.Source)))
+		} else {
+			s.logger.Printf("Error resolving %s user: %v", client.Protocol, err)
+		}
+		return 0, false, errProxyLoginFailed
+	}
+	if !user.Active {
+		return 0, false, errProxyLoginFailed
+	}
+	if err := s.syncRoles(user.ID, identity); err != nil {
+		s.logger.Printf("Error syncing roles: %v", err)
+		return 0, false, errProxyLoginFailed
+	}
+
+	if identity.Source == "local" && s.config.PasswordPolicy.Expired(user.PasswordChangedAt, time.Now()) {
+		return 0, false, errPasswordExpired
+	}
+
+	// Users with an enrolled second factor must enter a code as well
+	enrolled, err := s.mfaEnrolled(user.ID)
+	if err != nil {
+		return 0, false, errProxyLoginFailed
+	}
+	return user.ID, enrolled, nil
+}
+
+// mfaEnrolled reports whether a user has an enabled second factor
+func (s *Server) mfaEnrolled(userID int) (bool, error) {
+	mfa, err := s.models.MFA.GetByUserID(userID)
+	if err != nil && !errors.Is(err, models.ErrRecordNotFound) {
+		s.logger.Printf("Error getting MFA enrollment: %v", err)
+		return false, err
+	}
+	return mfa != nil && mfa.Enabled, nil
+}
+
+// proxyVerifyCode checks a proxy user's TOTP code. Wrong codes count as
+// failed logins so the code cannot be guessed either.
+func (s *Server) proxyVerifyCode(client proxyClient, username string, userID int, code string) error {
+	mfa, err := s.models.MFA.GetByUserID(userID)
+	if err != nil {
+		s.logger.Printf("Error getting MFA enrollment: %v", err)
+		return errProxyLoginFailed
+	}
+	subject := loginSubject(username)
+	if !s.reserveProxyLogin(client, subject) {
+		return errProxyLoginFailed
+	}
+	valid, err := s.verifyTOTP(mfa, code)
+	if err != nil {
+		s.releaseLoginAttempt(subject, client.IP)
+		s.logger.Printf("Error verifying TOTP code: %v", err)
+		return errProxyLoginFailed
+	}
+	if !valid {
+		for _, entry := range s.countLoginFailure(subject, client.IP, "invalid "+client.Protocol+" verification code") {
+			s.recordProxy(client, entry)
+		}
+		return errProxyLoginFailed
+	}
+
+	s.clearLoginFailures(subject, client.IP)
+	return nil
+}
+
+// reserveProxyLogin reserves a proxy password or code attempt, reporting
+// false and auditing the refusal if the account or source address must wait
+func (s *Server) reserveProxyLogin(client proxyClient, subject string) bool {
+	wait, err := s.reserveLogin(subject, client.IP)
+	if err != nil {
+		s.logger.Printf("Error checking login attempts: %v", err)
+		return false
+	}
+	if wait > 0 {
+		s.recordProxy(client, loginEvent(0, "login_blocked", fmt.Sprintf("Throttled %s login for %q from %s", client.Protocol, subject, client.IP)))
+		return false
+	}
+	return true
+}
+
+// proxyAuthorize resolves a target, given as a credential ID or a unique
+// name, to a credential of one of types the user may reveal. Its errors
+// are meant to be shown to the user.
+func (s *Server) proxyAuthorize(client proxyClient, userID int, target string, types ...string) (*models.Credential, error) {
+	var credential *models.Credential
+	if id, err := strconv.Atoi(target); err == nil {
+		credential, err = s.models.Credentials.GetByID(id)
+		if err != nil && !errors.Is(err, models.ErrRecordNotFound) {
+			s.logger.Printf("Error getting credential: %v", err)
+			return nil, errors.New("failed to look up target")
+		}
+	} else {
+		credentials, err := s.models.Credentials.ListByName(target)
+		if err != nil {
+			s.logger.Printf("Error getting credential: %v", err)
+			return nil, errors.New("failed to look up target")
+		}
+		if len(credentials) > 1 {
+			return nil, fmt.Errorf("several credentials are named %q, connect with the credential ID instead", target)
+		}
+		if len(credentials) == 1 {
+			credential = credentials[0]
+		}
+	}
+	if credential == nil {
+		return nil, errors.New("credential not found")
+	}
+
+	allowed, err := s.canAccessCredential(&Principal{UserID: userID}, auth.OpCredentialsReveal, credential)
+	if err != nil {
+		s.logger.Printf("Error checking credential access: %v", err)
+		return nil, errors.New("failed to look up target")
+	}
+	if !allowed {
+		s.recordProxy(client, &models.AuditLog{
+			UserID:     userID,
+			Action:     client.Prefix + "_denied",
+			Resource:   "credential",
+			ResourceID: credential.ID,
+			Details:    client.Protocol + " access to credential " + credential.Name + " denied",
+		})
+		return nil, errors.New("access to this credential is not permitted")
+	}
+	for _, credentialType := range types {
+		if credential.Type == credentialType {
+			return credential, nil
+		}
+	}
+	return nil, fmt.Errorf("a %s credential cannot be used for %s", credential.Type, client.Protocol)
+}
+
+// sshClient describes an SSH proxy client
+func sshClient(conn ssh.ConnMetadata) proxyClient {
+	return proxyClient{
+		Protocol: "SSH",
+		Prefix:   "ssh",
+		IP:       addrIP(conn.RemoteAddr()),
+		Agent:    string(conn.ClientVersion()),
+	}
+}
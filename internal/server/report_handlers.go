@@ -0,0 +1,160 @@
+package server
+
+import (
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/theshovonaha/mini-pam/internal/credhealth"
+	"github.com/theshovonaha/mini-pam/internal/models"
+)
+
+// CredentialHealthReport is the JSON form of the credential health report
+type CredentialHealthReport struct {
+	GeneratedAt time.Time             `json:"generated_at"`
+	Policy      credhealth.Policy     `json:"policy"`
+	Summary     CredentialHealthTally `json:"summary"`
+	Credentials []*credhealth.Result  `json:"credentials"`
+}
+
+// CredentialHealthTally counts credentials by risk level and by finding
+type CredentialHealthTally struct {
+	Total    int            `json:"total"`
+	Risk     map[string]int `json:"risk"`
+	Findings map[string]int `json:"findings"`
+}
+
+// handleCredentialHealthReport returns a handler that judges every stored
+// credential against the health policy, riskiest first, as JSON or CSV
+func (s *Server) handleCredentialHealthReport() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+
+		// Parse the policy, starting from the defaults
+		policy := credhealth.DefaultPolicy()
+		policy.Common = s.config.PasswordPolicy.Banned
+		for name, days := range map[string]*int{
+			"max_age_days": &policy.MaxSecretAgeDays,
+			"unused_days":  &policy.MaxUnusedDays,
+		} {
+			if value := query.Get(name); value != "" {
+				d, err := strconv.Atoi(value)
+				if err != nil || d < 0 || d > 3650 {
+					s.respondError(w, http.StatusBadRequest, name+" must be between 0 and 3650")
+					return
+				}
+				*days = d
+			}
+		}
+		if value := query.Get("min_entropy"); value != "" {
+			bits, err := strconv.ParseFloat(value, 64)
+			if err != nil || bits < 0 || bits > 256 {
+				s.respondError(w, http.StatusBadRequest, "min_entropy must be between 0 and 256")
+				return
+			}
+			policy.MinEntropy = bits
+		}
+
+		format := query.Get("format")
+		if format != "" && format != "json" && format != "csv" {
+			s.respondError(w, http.StatusBadRequest, "format must be json or csv")
+			return
+		}
+
+		usages, err := s.models.Credentials.ListUsage()
+		if err != nil {
+			s.logger.Printf("Error listing credential usage: %v", err)
+			s.respondError(w, http.StatusInternalServerError, "Failed to build credential health report")
+			return
+		}
+		now := time.Now()
+		results := credhealth.Analyze(usages, policy, now)
+
+		// Create an audit log entry
+		s.audit(r, &models.AuditLog{
+			Action:   "report",
+			Resource: "credential_health",
+			Details:  fmt.Sprintf("Credential health report of %d credentials: %s", len(results), r.URL.RawQuery),
+		})
+
+		if format == "csv" {
+			w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+			w.Header().Set("Content-Disposition", `attachment; filename="credential-health.csv"`)
+			w.WriteHeader(http.StatusOK)
+			writer := csv.NewWriter(w)
+			writer.Write(credentialHealthCSVHeader)
+			for _, result := range results {
+				writer.Write(credentialHealthCSVRecord(result))
+			}
+			writer.Flush()
+			if err := writer.Error(); err != nil {
+				s.logger.Printf("Error writing credential health report: %v", err)
+			}
+			return
+		}
+
+		report := CredentialHealthReport{
+			GeneratedAt: now,
+			Policy:      policy,
+			Summary: CredentialHealthTally{
+				Total:    len(results),
+				Risk:     map[string]int{},
+				Findings: map[string]int{},
+			},
+			Credentials: results,
+		}
+		for _, result := range results {
+			report.Summary.Risk[result.Risk]++
+			for _, finding := range result.Findings {
+				report.Summary.Findings[finding]++
+			}
+		}
+		s.respondJSON(w, http.StatusOK, report)
+	}
+}
+
+// credentialHealthCSVHeader names the columns written by credentialHealthCSVRecord
+var credentialHealthCSVHeader = []string{
+	"id", "name", "type", "system", "username", "created_by", "risk", "score", "findings",
+	"entropy_bits", "reused_with", "secret_changed_at", "secret_age_days", "last_accessed_at", "expires_at",
+}
+
+// credentialHealthCSVRecord formats a result as a CSV row, with lists
+// separated by semicolons
+func credentialHealthCSVRecord(result *credhealth.Result) []string {
+	optionalTime := func(t *time.Time) string {
+		if t == nil {
+			return ""
+		}
+		return t.UTC().Format(time.RFC3339)
+	}
+	entropy := ""
+	if result.EntropyBits != nil {
+		entropy = strconv.FormatFloat(*result.EntropyBits, 'f', 1, 64)
+	}
+	reused := make([]string, len(result.ReusedWith))
+	for i, id := range result.ReusedWith {
+		reused[i] = strconv.Itoa(id)
+	}
+
+	return []string{
+		strconv.Itoa(result.ID),
+		csvSafe(result.Name),
+		csvSafe(result.Type),
+		csvSafe(result.System),
+		csvSafe(result.Username),
+		strconv.Itoa(result.CreatedBy),
+		result.Risk,
+		strconv.Itoa(result.Score),
+		strings.Join(result.Findings, ";"),
+		entropy,
+		strings.Join(reused, ";"),
+		result.SecretChangedAt.UTC().Format(time.RFC3339),
+		strconv.Itoa(result.SecretAgeDays),
+		optionalTime(result.LastAccessedAt),
+		optionalTime(result.ExpiresAt),
+	}
+}
@@ -0,0 +1,125 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/theshovonaha/mini-pam/internal/models"
+)
+
+// handleGetUserRoles returns a handler for listing the roles assigned to a user
+func (s *Server) handleGetUserRoles() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Parse the user ID from the URL
+		vars := mux.Vars(r)
+		id, err := strconv.Atoi(vars["id"])
+		if err != nil {
+			s.respondError(w, http.StatusBadRequest, "Invalid user ID")
+			return
+		}
+
+		roles, err := s.models.Roles.GetUserRoles(id)
+		if err != nil {
+			s.logger.Printf("Error getting user roles: %v", err)
+			s.respondError(w, http.StatusInternalServerError, "Failed to get user roles")
+			return
+		}
+
+		s.respondJSON(w, http.StatusOK, roles)
+	}
+}
+
+// handleAssignRole returns a handler for assigning a role to a user
+func (s *Server) handleAssignRole() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Parse the user and role IDs from the URL
+		vars := mux.Vars(r)
+		userID, err := strconv.Atoi(vars["id"])
+		if err != nil {
+			s.respondError(w, http.StatusBadRequest, "Invalid user ID")
+			return
+		}
+		roleID, err := strconv.Atoi(vars["roleId"])
+		if err != nil {
+			s.respondError(w, http.StatusBadRequest, "Invalid role ID")
+			return
+		}
+
+		// Make sure both sides of the assignment exist
+		if _, err := s.models.Users.GetByID(userID); err != nil {
+			if errors.Is(err, models.ErrRecordNotFound) {
+				s.respondError(w, http.StatusNotFound, "User not found")
+			} else {
+				s.logger.Printf("Error getting user: %v", err)
+				s.respondError(w, http.StatusInternalServerError, "Failed to assign role")
+			}
+			return
+		}
+		role, err := s.models.Roles.GetByID(roleID)
+		if err != nil {
+			if errors.Is(err, models.ErrRecordNotFound) {
+				s.respondError(w, http.StatusNotFound, "Role not found")
+			} else {
+				s.logger.Printf("Error getting role: %v", err)
+				s.respondError(w, http.StatusInternalServerError, "Failed to assign role")
+			}
+			return
+		}
+
+		if err := s.models.Roles.AssignRoleToUser(userID, roleID); err != nil {
+			s.logger.Printf("Error assigning role: %v", err)
+			s.respondError(w, http.StatusInternalServerError, "Failed to assign role")
+			return
+		}
+
+		// Create an audit log entry
+		s.audit(r, &models.AuditLog{
+			Action:     "assign_role",
+			Resource:   "user",
+			ResourceID: userID,
+			Details:    "Role assigned: " + role.Name,
+		})
+
+		s.respondJSON(w, http.StatusOK, map[string]string{"message": "Role assigned successfully"})
+	}
+}
+
+// handleRemoveRole returns a handler for removing a role from a user
+func (s *Server) handleRemoveRole() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Parse the user and role IDs from the URL
+		vars := mux.Vars(r)
+		userID, err := strconv.Atoi(vars["id"])
+		if err != nil {
+			s.respondError(w, http.StatusBadRequest, "Invalid user ID")
+			return
+		}
+		roleID, err := strconv.Atoi(vars["roleId"])
+		if err != nil {
+			s.respondError(w, http.StatusBadRequest, "Invalid role ID")
+			return
+		}
+
+		if err := s.models.Roles.RemoveRoleFromUser(userID, roleID); err != nil {
+			if errors.Is(err, models.ErrRecordNotFound) {
+				s.respondError(w, http.StatusNotFound, "Role assignment not found")
+			} else {
+				s.logger.Printf("Error removing role: %v", err)
+				s.respondError(w, http.StatusInternalServerError, "Failed to remove role")
+			}
+			return
+		}
+
+		// Create an audit log entry
+		s.audit(r, &models.AuditLog{
+			Action:     "remove_role",
+			Resource:   "user",
+			ResourceID: userID,
+			Details:    "Role removed: " + strconv.Itoa(roleID),
+		})
+
+		s.respondJSON(w, http.StatusOK, map[string]string{"message": "Role removed successfully"})
+	}
+}
@@ -0,0 +1,492 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/theshovonaha/mini-pam/internal/models"
+)
+
+// SCIM schema URNs (RFC 7643, RFC 7644)
+const (
+	scimUserSchema  = "urn:ietf:params:scim:schemas:core:2.0:User"
+	scimGroupSchema = "urn:ietf:params:scim:schemas:core:2.0:Group"
+	scimListSchema  = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	scimErrorSchema = "urn:ietf:params:scim:api:messages:2.0:Error"
+)
+
+// SCIMName is the name sub-attribute of a SCIM user
+type SCIMName struct {
+	GivenName  string `json:"givenName,omitempty"`
+	FamilyName string `json:"familyName,omitempty"`
+}
+
+// SCIMEmail is a single email address of a SCIM user
+type SCIMEmail struct {
+	Value   string `json:"value"`
+	Type    string `json:"type,omitempty"`
+	Primary bool   `json:"primary,omitempty"`
+}
+
+// SCIMMember references a user from a group, or a group from a user
+type SCIMMember struct {
+	Value   string `json:"value"`
+	Display string `json:"display,omitempty"`
+}
+
+// SCIMMeta carries resource metadata
+type SCIMMeta struct {
+	ResourceType string    `json:"resourceType"`
+	Created      time.Time `json:"created"`
+	LastModified time.Time `json:"lastModified"`
+	Location     string    `json:"location"`
+}
+
+// SCIMUser is the SCIM representation of a mini-pam user
+type SCIMUser struct {
+	Schemas  []string     `json:"schemas"`
+	ID       string       `json:"id,omitempty"`
+	UserName string       `json:"userName"`
+	Name     *SCIMName    `json:"name,omitempty"`
+	Emails   []SCIMEmail  `json:"emails,omitempty"`
+	Active   *bool        `json:"active,omitempty"`
+	Password string       `json:"password,omitempty"` // Write-only
+	Groups   []SCIMMember `json:"groups,omitempty"`
+	Meta     *SCIMMeta    `json:"meta,omitempty"`
+}
+
+// SCIMGroup is the SCIM representation of a mini-pam role
+type SCIMGroup struct {
+	Schemas     []string     `json:"schemas"`
+	ID          string       `json:"id,omitempty"`
+	DisplayName string       `json:"displayName"`
+	Members     []SCIMMember `json:"members"`
+	Meta        *SCIMMeta    `json:"meta,omitempty"`
+}
+
+// SCIMListResponse wraps a page of resources
+type SCIMListResponse struct {
+	Schemas      []string    `json:"schemas"`
+	TotalResults int         `json:"totalResults"`
+	StartIndex   int         `json:"startIndex"`
+	ItemsPerPage int         `json:"itemsPerPage"`
+	Resources    interface{} `json:"Resources"`
+}
+
+// SCIMPatchRequest is a PATCH request body
+type SCIMPatchRequest struct {
+	Schemas    []string          `json:"schemas"`
+	Operations []SCIMPatchOpItem `json:"Operations"`
+}
+
+// SCIMPatchOpItem is a single PATCH operation
+type SCIMPatchOpItem struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	Value json.RawMessage `json:"value"`
+}
+
+// SCIMError is the SCIM error response body
+type SCIMError struct {
+	Schemas  []string `json:"schemas"`
+	Status   string   `json:"status"`
+	ScimType string   `json:"scimType,omitempty"`
+	Detail   string   `json:"detail"`
+}
+
+// scimBadRequest is a client error carrying a SCIM scimType
+type scimBadRequest struct {
+	scimType string
+	detail   string
+}
+
+func (e *scimBadRequest) Error() string {
+	return e.detail
+}
+
+// respondSCIM sends a SCIM JSON response
+func (s *Server) respondSCIM(w http.ResponseWriter, status int, data interface{}) {
+	w.Header().Set("Content-Type", "application/scim+json")
+	w.WriteHeader(status)
+
+	if err := json.NewEncoder(w).Encode(data); err != nil {
+		s.logger.Printf("Error encoding SCIM response: %v", err)
+	}
+}
+
+// respondSCIMError sends a SCIM error response
+func (s *Server) respondSCIMError(w http.ResponseWriter, status int, scimType, detail string) {
+	s.respondSCIM(w, status, SCIMError{
+		Schemas:  []string{scimErrorSchema},
+		Status:   strconv.Itoa(status),
+		ScimType: scimType,
+		Detail:   detail,
+	})
+}
+
+// readSCIM decodes a SCIM request body. Unknown attributes are tolerated
+// because identity providers routinely send extension schemas.
+func (s *Server) readSCIM(w http.ResponseWriter, r *http.Request, dst interface{}) error {
+	r.Body = http.MaxBytesReader(w, r.Body, 1048576) // 1MB
+	return json.NewDecoder(r.Body).Decode(dst)
+}
+
+// scimUserFromModel converts a user (and its roles) into a SCIM resource
+func scimUserFromModel(user *models.User, roles []*models.Role) SCIMUser {
+	active := user.Active
+	resource := SCIMUser{
+		Schemas:  []string{scimUserSchema},
+		ID:       strconv.Itoa(user.ID),
+		UserName: user.Username,
+		Name:     &SCIMName{GivenName: user.FirstName, FamilyName: user.LastName},
+		Emails:   []SCIMEmail{{Value: user.Email, Type: "work", Primary: true}},
+		Active:   &active,
+		Meta: &SCIMMeta{
+			ResourceType: "User",
+			Created:      user.CreatedAt,
+			LastModified: user.UpdatedAt,
+			Location:     "/scim/v2/Users/" + strconv.Itoa(user.ID),
+		},
+	}
+
+	for _, role := range roles {
+		resource.Groups = append(resource.Groups, SCIMMember{Value: strconv.Itoa(role.ID), Display: role.Name})
+	}
+
+	return resource
+}
+
+// scimGroupFromModel converts a role (and its members) into a SCIM resource
+func scimGroupFromModel(role *models.Role, members []*models.User) SCIMGroup {
+	resource := SCIMGroup{
+		Schemas:     []string{scimGroupSchema},
+		ID:          strconv.Itoa(role.ID),
+		DisplayName: role.Name,
+		Members:     []SCIMMember{},
+		Meta: &SCIMMeta{
+			ResourceType: "Group",
+			Created:      role.CreatedAt,
+			LastModified: role.UpdatedAt,
+			Location:     "/scim/v2/Groups/" + strconv.Itoa(role.ID),
+		},
+	}
+
+	for _, user := range members {
+		resource.Members = append(resource.Members, SCIMMember{Value: strconv.Itoa(user.ID), Display: user.Username})
+	}
+
+	return resource
+}
+
+// applySCIMUser copies the writable attributes of a SCIM user onto a model
+func applySCIMUser(user *models.User, resource *SCIMUser) {
+	user.Username = resource.UserName
+	user.FirstName, user.LastName = "", ""
+	if resource.Name != nil {
+		user.FirstName = resource.Name.GivenName
+		user.LastName = resource.Name.FamilyName
+	}
+	if email := primaryEmail(resource.Emails); email != "" {
+		user.Email = email
+	}
+	user.Active = resource.Active == nil || *resource.Active
+}
+
+// primaryEmail picks the primary address, falling back to the first one
+func primaryEmail(emails []SCIMEmail) string {
+	for _, email := range emails {
+		if email.Primary {
+			return email.Value
+		}
+	}
+	if len(emails) > 0 {
+		return emails[0].Value
+	}
+	return ""
+}
+
+// scimFilterPattern matches the single-comparison filters identity providers send
+var scimFilterPattern = regexp.MustCompile(`^\s*([A-Za-z][\w.]*)\s+(?i:eq)\s+(.+?)\s*$`)
+
+// parseSCIMFilter splits an `attribute eq value` filter. Only equality is
+// supported, which covers the lookups provisioning clients perform.
+func parseSCIMFilter(filter string) (attr string, value string, err error) {
+	match := scimFilterPattern.FindStringSubmatch(filter)
+	if match == nil {
+		return "", "", &scimBadRequest{"invalidFilter", "Only 'attribute eq value' filters are supported"}
+	}
+
+	value = match[2]
+	if unquoted, err := strconv.Unquote(value); err == nil {
+		value = unquoted
+	}
+	return strings.ToLower(match[1]), value, nil
+}
+
+// userFilterFromSCIM translates a SCIM filter into a repository filter
+func userFilterFromSCIM(filter string) (models.UserFilter, error) {
+	var out models.UserFilter
+	if filter == "" {
+		return out, nil
+	}
+
+	attr, value, err := parseSCIMFilter(filter)
+	if err != nil {
+		return out, err
+	}
+
+	switch attr {
+	case "username":
+		out.Username = value
+	case "emails", "emails.value":
+		out.Email = value
+	case "active":
+		active, err := strconv.ParseBool(value)
+		if err != nil {
+			return out, &scimBadRequest{"invalidFilter", "active must be true or false"}
+		}
+		out.Active = &active
+	default:
+		return out, &scimBadRequest{"invalidFilter", fmt.Sprintf("Filtering on %q is not supported", attr)}
+	}
+	return out, nil
+}
+
+// applyUserPatch applies PATCH operations to a user, returning any new password
+func applyUserPatch(user *models.User, ops []SCIMPatchOpItem) (string, error) {
+	var password string
+
+	for _, op := range ops {
+		switch strings.ToLower(op.Op) {
+		case "add", "replace":
+			if op.Path == "" {
+				// The value is a partial resource: apply each attribute in turn
+				var attrs map[string]json.RawMessage
+				if err := json.Unmarshal(op.Value, &attrs); err != nil {
+					return "", &scimBadRequest{"invalidValue", "Patch value must be an object when no path is given"}
+				}
+				for path, value := range attrs {
+					if err := setUserAttribute(user, path, value, &password); err != nil {
+						return "", err
+					}
+				}
+				continue
+			}
+			if err := setUserAttribute(user, op.Path, op.Value, &password); err != nil {
+				return "", err
+			}
+
+		case "remove":
+			switch strings.ToLower(op.Path) {
+			case "name.givenname":
+				user.FirstName = ""
+			case "name.familyname":
+				user.LastName = ""
+			case "name":
+				user.FirstName, user.LastName = "", ""
+			default:
+				return "", &scimBadRequest{"mutability", fmt.Sprintf("Attribute %q cannot be removed", op.Path)}
+			}
+
+		default:
+			return "", &scimBadRequest{"invalidSyntax", fmt.Sprintf("Unsupported patch operation %q", op.Op)}
+		}
+	}
+
+	return password, nil
+}
+
+// setUserAttribute sets one attribute addressed by a SCIM path. Attributes
+// mini-pam does not store are ignored so providers can send full profiles.
+func setUserAttribute(user *models.User, path string, value json.RawMessage, password *string) error {
+	lower := strings.ToLower(path)
+	switch {
+	case lower == "username":
+		return decodeSCIMValue(value, &user.Username)
+	case lower == "active":
+		return decodeSCIMBool(value, &user.Active)
+	case lower == "name.givenname":
+		return decodeSCIMValue(value, &user.FirstName)
+	case lower == "name.familyname":
+		return decodeSCIMValue(value, &user.LastName)
+	case lower == "name":
+		var name SCIMName
+		if err := decodeSCIMValue(value, &name); err != nil {
+			return err
+		}
+		user.FirstName, user.LastName = name.GivenName, name.FamilyName
+	case lower == "emails":
+		var emails []SCIMEmail
+		if err := decodeSCIMValue(value, &emails); err != nil {
+			return err
+		}
+		if email := primaryEmail(emails); email != "" {
+			user.Email = email
+		}
+	case strings.HasPrefix(lower, "emails[") && strings.HasSuffix(lower, "].value"):
+		return decodeSCIMValue(value, &user.Email)
+	case lower == "password":
+		return decodeSCIMValue(value, password)
+	}
+	return nil
+}
+
+// decodeSCIMValue decodes a patch value into dst
+func decodeSCIMValue(value json.RawMessage, dst interface{}) error {
+	if err := json.Unmarshal(value, dst); err != nil {
+		return &scimBadRequest{"invalidValue", "Patch value has the wrong type"}
+	}
+	return nil
+}
+
+// decodeSCIMBool accepts a JSON boolean or the "True"/"False" strings some providers send
+func decodeSCIMBool(value json.RawMessage, dst *bool) error {
+	if err := json.Unmarshal(value, dst); err == nil {
+		return nil
+	}
+
+	var text string
+	if err := json.Unmarshal(value, &text); err == nil {
+		if parsed, err := strconv.ParseBool(text); err == nil {
+			*dst = parsed
+			return nil
+		}
+	}
+	return &scimBadRequest{"invalidValue", "Expected a boolean value"}
+}
+
+// scimMemberPathPattern matches `members[value eq "42"]`
+var scimMemberPathPattern = regexp.MustCompile(`^(?i:members)\[\s*(?i:value)\s+(?i:eq)\s+"?([^"\]]+)"?\s*\]$`)
+
+// applyGroupPatch applies PATCH operations to a role name and member set
+func applyGroupPatch(name *string, members map[int]bool, ops []SCIMPatchOpItem) error {
+	for _, op := range ops {
+		lowerOp := strings.ToLower(op.Op)
+		if lowerOp != "add" && lowerOp != "replace" && lowerOp != "remove" {
+			return &scimBadRequest{"invalidSyntax", fmt.Sprintf("Unsupported patch operation %q", op.Op)}
+		}
+
+		// A path of members[value eq "x"] always removes a single member
+		if match := scimMemberPathPattern.FindStringSubmatch(op.Path); match != nil && lowerOp == "remove" {
+			id, err := strconv.Atoi(match[1])
+			if err != nil {
+				return &scimBadRequest{"invalidPath", "Member value must be a user ID"}
+			}
+			delete(members, id)
+			continue
+		}
+
+		switch strings.ToLower(op.Path) {
+		case "":
+			if lowerOp == "remove" {
+				return &scimBadRequest{"noTarget", "Remove requires a path"}
+			}
+			var group SCIMGroup
+			if err := decodeSCIMValue(op.Value, &group); err != nil {
+				return err
+			}
+			if group.DisplayName != "" {
+				*name = group.DisplayName
+			}
+			if group.Members != nil {
+				if lowerOp == "replace" {
+					clearMembers(members)
+				}
+				if err := addMembers(members, group.Members); err != nil {
+					return err
+				}
+			}
+
+		case "displayname":
+			if lowerOp == "remove" {
+				return &scimBadRequest{"mutability", "displayName cannot be removed"}
+			}
+			if err := decodeSCIMValue(op.Value, name); err != nil {
+				return err
+			}
+
+		case "members":
+			var values []SCIMMember
+			if len(op.Value) > 0 {
+				if err := decodeSCIMValue(op.Value, &values); err != nil {
+					return err
+				}
+			}
+
+			switch lowerOp {
+			case "replace":
+				clearMembers(members)
+				if err := addMembers(members, values); err != nil {
+					return err
+				}
+			case "add":
+				if err := addMembers(members, values); err != nil {
+					return err
+				}
+			case "remove":
+				if len(values) == 0 {
+					clearMembers(members)
+				}
+				for _, v := range values {
+					id, err := strconv.Atoi(v.Value)
+					if err != nil {
+						return &scimBadRequest{"invalidValue", "Member value must be a user ID"}
+					}
+					delete(members, id)
+				}
+			}
+
+		default:
+			return &scimBadRequest{"invalidPath", fmt.Sprintf("Unsupported path %q", op.Path)}
+		}
+	}
+
+	return nil
+}
+
+// addMembers adds SCIM member references to a member set
+func addMembers(members map[int]bool, values []SCIMMember) error {
+	for _, v := range values {
+		id, err := strconv.Atoi(v.Value)
+		if err != nil {
+			return &scimBadRequest{"invalidValue", "Member value must be a user ID"}
+		}
+		members[id] = true
+	}
+	return nil
+}
+
+// clearMembers empties a member set
+func clearMembers(members map[int]bool) {
+	for id := range members {
+		delete(members, id)
+	}
+}
+
+// scimPaging parses startIndex and count (1-based, RFC 7644 section 3.4.2.4)
+func scimPaging(r *http.Request) (startIndex, count int) {
+	startIndex, count = 1, 100
+	if v, err := strconv.Atoi(r.URL.Query().Get("startIndex")); err == nil && v > 0 {
+		startIndex = v
+	}
+	if v, err := strconv.Atoi(r.URL.Query().Get("count")); err == nil && v >= 0 && v <= 1000 {
+		count = v
+	}
+	return startIndex, count
+}
+
+// respondSCIMBadRequest sends a 400 for an error raised while parsing SCIM input
+func (s *Server) respondSCIMBadRequest(w http.ResponseWriter, err error) {
+	var badRequest *scimBadRequest
+	if errors.As(err, &badRequest) {
+		s.respondSCIMError(w, http.StatusBadRequest, badRequest.scimType, badRequest.detail)
+		return
+	}
+	s.respondSCIMError(w, http.StatusBadRequest, "invalidValue", err.Error())
+}
@@ -0,0 +1,649 @@
+package server
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/theshovonaha/mini-pam/internal/audit"
+	"github.com/theshovonaha/mini-pam/internal/auth"
+	"github.com/theshovonaha/mini-pam/internal/models"
+)
+
+// scimAuthMiddleware accepts only the dedicated SCIM bearer token
+func (s *Server) scimAuthMiddleware(next http.Handler) http.Handler {
+	expected := auth.HashToken(s.config.SCIMToken)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !found || subtle.ConstantTimeCompare([]byte(auth.HashToken(token)), []byte(expected)) != 1 {
+			s.respondSCIMError(w, http.StatusUnauthorized, "", "Invalid SCIM bearer token")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// scimAudit records a provisioning change made by the SCIM client, with the
+// difference between the before and after snapshots of the target
+func (s *Server) scimAudit(r *http.Request, action, resource string, resourceID int, details string, before, after interface{}) {
+	s.auditChange(r, &models.AuditLog{
+		Action:     action,
+		Resource:   resource,
+		ResourceID: resourceID,
+		Details:    "SCIM: " + details,
+	}, before, after)
+}
+
+// scimUserResponse loads a user's roles and sends the SCIM representation
+func (s *Server) scimUserResponse(w http.ResponseWriter, status int, user *models.User) {
+	roles, err := s.models.Roles.GetUserRoles(user.ID)
+	if err != nil {
+		s.logger.Printf("Error getting user roles: %v", err)
+		s.respondSCIMError(w, http.StatusInternalServerError, "", "Failed to load user")
+		return
+	}
+	s.respondSCIM(w, status, scimUserFromModel(user, roles))
+}
+
+// scimLoadUser resolves the {id} path variable to a user, responding on failure
+func (s *Server) scimLoadUser(w http.ResponseWriter, r *http.Request) *models.User {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		s.respondSCIMError(w, http.StatusNotFound, "", "User not found")
+		return nil
+	}
+
+	user, err := s.models.Users.GetByID(id)
+	if err != nil {
+		if errors.Is(err, models.ErrRecordNotFound) {
+			s.respondSCIMError(w, http.StatusNotFound, "", "User not found")
+		} else {
+			s.logger.Printf("Error getting user: %v", err)
+			s.respondSCIMError(w, http.StatusInternalServerError, "", "Failed to load user")
+		}
+		return nil
+	}
+	return user
+}
+
+// scimSaveUser persists a user and optional new password, responding on failure
+func (s *Server) scimSaveUser(w http.ResponseWriter, user *models.User, password string) bool {
+	if user.Username == "" || user.Email == "" {
+		s.respondSCIMError(w, http.StatusBadRequest, "invalidValue", "userName and an email address are required")
+		return false
+	}
+	if password != "" && !s.scimCheckPassword(w, user, password) {
+		return false
+	}
+
+	if err := s.models.Users.Update(user); err != nil {
+		if errors.Is(err, models.ErrDuplicateKey) {
+			s.respondSCIMError(w, http.StatusConflict, "uniqueness", "userName or email already in use")
+		} else {
+			s.logger.Printf("Error updating user: %v", err)
+			s.respondSCIMError(w, http.StatusInternalServerError, "", "Failed to update user")
+		}
+		return false
+	}
+
+	if password != "" {
+		if err := s.setPassword(user.ID, password); err != nil {
+			s.logger.Printf("Error updating password: %v", err)
+			s.respondSCIMError(w, http.StatusInternalServerError, "", "Failed to update password")
+			return false
+		}
+	}
+
+	return true
+}
+
+// scimCheckPassword applies the password policy, responding on failure
+func (s *Server) scimCheckPassword(w http.ResponseWriter, user *models.User, password string) bool {
+	err := s.checkNewPassword(user, password)
+	if err == nil {
+		return true
+	}
+
+	var policyErr *auth.PasswordPolicyError
+	if errors.As(err, &policyErr) {
+		s.respondSCIMError(w, http.StatusBadRequest, "invalidValue", policyErr.Error())
+	} else {
+		s.logger.Printf("Error checking password: %v", err)
+		s.respondSCIMError(w, http.StatusInternalServerError, "", "Failed to check password")
+	}
+	return false
+}
+
+// handleSCIMListUsers returns a handler for filtered user listing
+func (s *Server) handleSCIMListUsers() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		filter, err := userFilterFromSCIM(r.URL.Query().Get("filter"))
+		if err != nil {
+			s.respondSCIMBadRequest(w, err)
+			return
+		}
+
+		startIndex, count := scimPaging(r)
+		users, total, err := s.models.Users.Search(filter, startIndex-1, count)
+		if err != nil {
+			s.logger.Printf("Error searching users: %v", err)
+			s.respondSCIMError(w, http.StatusInternalServerError, "", "Failed to list users")
+			return
+		}
+		if count == 0 {
+			users = nil
+		}
+
+		resources := []SCIMUser{}
+		for _, user := range users {
+			roles, err := s.models.Roles.GetUserRoles(user.ID)
+			if err != nil {
+				s.logger.Printf("Error getting user roles: %v", err)
+				s.respondSCIMError(w, http.StatusInternalServerError, "", "Failed to list users")
+				return
+			}
+			resources = append(resources, scimUserFromModel(user, roles))
+		}
+
+		s.respondSCIM(w, http.StatusOK, SCIMListResponse{
+			Schemas:      []string{scimListSchema},
+			TotalResults: total,
+			StartIndex:   startIndex,
+			ItemsPerPage: len(resources),
+			Resources:    resources,
+		})
+	}
+}
+
+// handleSCIMCreateUser returns a handler for provisioning a user
+func (s *Server) handleSCIMCreateUser() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var resource SCIMUser
+		if err := s.readSCIM(w, r, &resource); err != nil {
+			s.respondSCIMError(w, http.StatusBadRequest, "invalidSyntax", "Invalid request payload")
+			return
+		}
+		if resource.UserName == "" {
+			s.respondSCIMError(w, http.StatusBadRequest, "invalidValue", "userName is required")
+			return
+		}
+
+		user := &models.User{AuthSource: models.AuthSourceSCIM}
+		applySCIMUser(user, &resource)
+		if user.Email == "" {
+			user.Email = user.Username + "@scim.invalid"
+		}
+
+		// Users provisioned without a password can only sign in through SSO
+		var hashedPassword string
+		var err error
+		if resource.Password != "" {
+			if !s.scimCheckPassword(w, user, resource.Password) {
+				return
+			}
+			hashedPassword, err = hashPassword(resource.Password)
+		} else {
+			hashedPassword, err = placeholderPasswordHash()
+		}
+		if err != nil {
+			s.logger.Printf("Error hashing password: %v", err)
+			s.respondSCIMError(w, http.StatusInternalServerError, "", "Failed to create user")
+			return
+		}
+		user.HashedPassword = hashedPassword
+
+		if err := s.models.Users.Create(user); err != nil {
+			if errors.Is(err, models.ErrDuplicateKey) {
+				s.respondSCIMError(w, http.StatusConflict, "uniqueness", "userName or email already in use")
+			} else {
+				s.logger.Printf("Error creating user: %v", err)
+				s.respondSCIMError(w, http.StatusInternalServerError, "", "Failed to create user")
+			}
+			return
+		}
+
+		if resource.Password != "" {
+			if err := s.recordPasswordHistory(user.ID, hashedPassword); err != nil {
+				s.logger.Printf("Error recording password history: %v", err)
+			}
+		}
+
+		s.scimAudit(r, "create", "user", user.ID, "User provisioned", nil, user)
+		s.scimUserResponse(w, http.StatusCreated, user)
+	}
+}
+
+// handleSCIMGetUser returns a handler for fetching a single user
+func (s *Server) handleSCIMGetUser() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := s.scimLoadUser(w, r)
+		if user == nil {
+			return
+		}
+		s.scimUserResponse(w, http.StatusOK, user)
+	}
+}
+
+// handleSCIMReplaceUser returns a handler for replacing a user (PUT)
+func (s *Server) handleSCIMReplaceUser() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := s.scimLoadUser(w, r)
+		if user == nil {
+			return
+		}
+
+		var resource SCIMUser
+		if err := s.readSCIM(w, r, &resource); err != nil {
+			s.respondSCIMError(w, http.StatusBadRequest, "invalidSyntax", "Invalid request payload")
+			return
+		}
+
+		before := *user
+		applySCIMUser(user, &resource)
+		if !s.scimSaveUser(w, user, resource.Password) {
+			return
+		}
+		if before.Active && !user.Active {
+			s.revokeSessions(user.ID)
+		}
+
+		s.scimAudit(r, "update", "user", user.ID, "User replaced", &before, user)
+		s.scimUserResponse(w, http.StatusOK, user)
+	}
+}
+
+// handleSCIMPatchUser returns a handler for partially updating a user
+func (s *Server) handleSCIMPatchUser() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := s.scimLoadUser(w, r)
+		if user == nil {
+			return
+		}
+
+		var req SCIMPatchRequest
+		if err := s.readSCIM(w, r, &req); err != nil {
+			s.respondSCIMError(w, http.StatusBadRequest, "invalidSyntax", "Invalid request payload")
+			return
+		}
+
+		before := *user
+		password, err := applyUserPatch(user, req.Operations)
+		if err != nil {
+			s.respondSCIMBadRequest(w, err)
+			return
+		}
+		if !s.scimSaveUser(w, user, password) {
+			return
+		}
+
+		details := "User patched"
+		if before.Active && !user.Active {
+			details = "User deactivated"
+			s.revokeSessions(user.ID)
+		}
+		s.scimAudit(r, "update", "user", user.ID, details, &before, user)
+		s.scimUserResponse(w, http.StatusOK, user)
+	}
+}
+
+// handleSCIMDeleteUser returns a handler that deprovisions a user. The row is
+// kept (inactive) so audit history and credential ownership stay intact.
+func (s *Server) handleSCIMDeleteUser() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := s.scimLoadUser(w, r)
+		if user == nil {
+			return
+		}
+
+		before := *user
+		user.Active = false
+		if err := s.models.Users.Update(user); err != nil {
+			s.logger.Printf("Error deactivating user: %v", err)
+			s.respondSCIMError(w, http.StatusInternalServerError, "", "Failed to deprovision user")
+			return
+		}
+
+		// Existing tokens must stop working immediately
+		s.revokeSessions(user.ID)
+
+		s.scimAudit(r, "deactivate", "user", user.ID, "User deprovisioned", &before, user)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// scimLoadGroup resolves the {id} path variable to a role, responding on failure
+func (s *Server) scimLoadGroup(w http.ResponseWriter, r *http.Request) *models.Role {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		s.respondSCIMError(w, http.StatusNotFound, "", "Group not found")
+		return nil
+	}
+
+	role, err := s.models.Roles.GetByID(id)
+	if err != nil {
+		if errors.Is(err, models.ErrRecordNotFound) {
+			s.respondSCIMError(w, http.StatusNotFound, "", "Group not found")
+		} else {
+			s.logger.Printf("Error getting role: %v", err)
+			s.respondSCIMError(w, http.StatusInternalServerError, "", "Failed to load group")
+		}
+		return nil
+	}
+	return role
+}
+
+// scimGroupResponse loads a role's members and sends the SCIM representation
+func (s *Server) scimGroupResponse(w http.ResponseWriter, status int, role *models.Role) {
+	members, err := s.models.Roles.GetRoleMembers(role.ID)
+	if err != nil {
+		s.logger.Printf("Error getting role members: %v", err)
+		s.respondSCIMError(w, http.StatusInternalServerError, "", "Failed to load group")
+		return
+	}
+	s.respondSCIM(w, status, scimGroupFromModel(role, members))
+}
+
+// scimSaveGroup persists a role's name and membership, responding on failure
+func (s *Server) scimSaveGroup(w http.ResponseWriter, before, role *models.Role, members map[int]bool) bool {
+	if role.Name == "" {
+		s.respondSCIMError(w, http.StatusBadRequest, "invalidValue", "displayName is required")
+		return false
+	}
+
+	// Administrators are found by role name, so that name must stay with the built-in role
+	if role.Name != before.Name && (before.Name == adminRoleName || role.Name == adminRoleName) {
+		s.respondSCIMError(w, http.StatusBadRequest, "mutability", "The built-in admin role cannot be renamed")
+		return false
+	}
+
+	// Save the role and its members together, so a bad member changes nothing
+	if err := s.models.Roles.UpdateWithMembers(role, memberIDs(members)); err != nil {
+		switch err {
+		case models.ErrUnknownMember:
+			s.respondSCIMError(w, http.StatusBadRequest, "invalidValue", "Group members must be existing user IDs")
+		case models.ErrDuplicateKey:
+			s.respondSCIMError(w, http.StatusConflict, "uniqueness", "A group with that displayName already exists")
+		case models.ErrRecordNotFound:
+			s.respondSCIMError(w, http.StatusNotFound, "", "Group not found")
+		default:
+			s.logger.Printf("Error updating role: %v", err)
+			s.respondSCIMError(w, http.StatusInternalServerError, "", "Failed to update group")
+		}
+		return false
+	}
+
+	return true
+}
+
+// scimGroupMembers loads the member set of a role, responding on failure
+func (s *Server) scimGroupMembers(w http.ResponseWriter, role *models.Role) (map[int]bool, bool) {
+	current, err := s.models.Roles.GetRoleMembers(role.ID)
+	if err != nil {
+		s.logger.Printf("Error getting role members: %v", err)
+		s.respondSCIMError(w, http.StatusInternalServerError, "", "Failed to update group")
+		return nil, false
+	}
+	members := map[int]bool{}
+	for _, user := range current {
+		members[user.ID] = true
+	}
+	return members, true
+}
+
+// scimAuditGroup records a provisioning change to a group. Roles carry no
+// members, so since joining a group can grant access, the users added and
+// removed are recorded with the role's own changes.
+func (s *Server) scimAuditGroup(r *http.Request, action, details string, before, after *models.Role, oldMembers, newMembers map[int]bool) {
+	changes, err := audit.Diff(before, after)
+	if err != nil {
+		s.logger.Printf("Error computing audit diff: %v", err)
+	}
+
+	var added, removed []int
+	for _, id := range memberIDs(newMembers) {
+		if !oldMembers[id] {
+			added = append(added, id)
+		}
+	}
+	for _, id := range memberIDs(oldMembers) {
+		if !newMembers[id] {
+			removed = append(removed, id)
+		}
+	}
+	if len(added) > 0 || len(removed) > 0 {
+		if changes == nil {
+			changes = models.AuditChanges{}
+		}
+		if len(added) > 0 {
+			changes["members_added"] = models.FieldChange{New: added}
+		}
+		if len(removed) > 0 {
+			changes["members_removed"] = models.FieldChange{Old: removed}
+		}
+		details += fmt.Sprintf(" (%d members added, %d removed)", len(added), len(removed))
+	}
+
+	s.audit(r, &models.AuditLog{
+		Action:     action,
+		Resource:   "role",
+		ResourceID: after.ID,
+		Details:    "SCIM: " + details,
+		Changes:    changes,
+	})
+}
+
+// memberIDs returns the user IDs in a member set, in order
+func memberIDs(members map[int]bool) []int {
+	userIDs := make([]int, 0, len(members))
+	for id := range members {
+		userIDs = append(userIDs, id)
+	}
+	sort.Ints(userIDs)
+	return userIDs
+}
+
+// handleSCIMListGroups returns a handler for filtered group listing
+func (s *Server) handleSCIMListGroups() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var displayName string
+		if filter := r.URL.Query().Get("filter"); filter != "" {
+			attr, value, err := parseSCIMFilter(filter)
+			if err == nil && attr != "displayname" {
+				err = &scimBadRequest{"invalidFilter", "Only displayName filters are supported for groups"}
+			}
+			if err != nil {
+				s.respondSCIMBadRequest(w, err)
+				return
+			}
+			displayName = value
+		}
+
+		roles, err := s.models.Roles.List()
+		if err != nil {
+			s.logger.Printf("Error listing roles: %v", err)
+			s.respondSCIMError(w, http.StatusInternalServerError, "", "Failed to list groups")
+			return
+		}
+
+		// Roles are few, so filter and page in memory
+		matched := []*models.Role{}
+		for _, role := range roles {
+			if displayName == "" || strings.EqualFold(role.Name, displayName) {
+				matched = append(matched, role)
+			}
+		}
+
+		startIndex, count := scimPaging(r)
+		resources := []SCIMGroup{}
+		for i := startIndex - 1; i < len(matched) && len(resources) < count; i++ {
+			members, err := s.models.Roles.GetRoleMembers(matched[i].ID)
+			if err != nil {
+				s.logger.Printf("Error getting role members: %v", err)
+				s.respondSCIMError(w, http.StatusInternalServerError, "", "Failed to list groups")
+				return
+			}
+			resources = append(resources, scimGroupFromModel(matched[i], members))
+		}
+
+		s.respondSCIM(w, http.StatusOK, SCIMListResponse{
+			Schemas:      []string{scimListSchema},
+			TotalResults: len(matched),
+			StartIndex:   startIndex,
+			ItemsPerPage: len(resources),
+			Resources:    resources,
+		})
+	}
+}
+
+// handleSCIMCreateGroup returns a handler for provisioning a group as a role
+func (s *Server) handleSCIMCreateGroup() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var resource SCIMGroup
+		if err := s.readSCIM(w, r, &resource); err != nil {
+			s.respondSCIMError(w, http.StatusBadRequest, "invalidSyntax", "Invalid request payload")
+			return
+		}
+		if resource.DisplayName == "" {
+			s.respondSCIMError(w, http.StatusBadRequest, "invalidValue", "displayName is required")
+			return
+		}
+
+		if _, err := s.models.Roles.GetByName(resource.DisplayName); err == nil {
+			s.respondSCIMError(w, http.StatusConflict, "uniqueness", "A group with that displayName already exists")
+			return
+		}
+
+		members := map[int]bool{}
+		if err := addMembers(members, resource.Members); err != nil {
+			s.respondSCIMBadRequest(w, err)
+			return
+		}
+
+		// Create the role and its members together, so a bad member leaves no role behind
+		role := &models.Role{Name: resource.DisplayName, Description: "Provisioned via SCIM"}
+		if err := s.models.Roles.CreateWithMembers(role, memberIDs(members)); err != nil {
+			switch err {
+			case models.ErrUnknownMember:
+				s.respondSCIMError(w, http.StatusBadRequest, "invalidValue", "Group members must be existing user IDs")
+			case models.ErrDuplicateKey:
+				s.respondSCIMError(w, http.StatusConflict, "uniqueness", "A group with that displayName already exists")
+			default:
+				s.logger.Printf("Error creating role: %v", err)
+				s.respondSCIMError(w, http.StatusInternalServerError, "", "Failed to create group")
+			}
+			return
+		}
+
+		s.scimAuditGroup(r, "create", "Group provisioned: "+role.Name, nil, role, nil, members)
+		s.scimGroupResponse(w, http.StatusCreated, role)
+	}
+}
+
+// handleSCIMGetGroup returns a handler for fetching a single group
+func (s *Server) handleSCIMGetGroup() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		role := s.scimLoadGroup(w, r)
+		if role == nil {
+			return
+		}
+		s.scimGroupResponse(w, http.StatusOK, role)
+	}
+}
+
+// handleSCIMReplaceGroup returns a handler for replacing a group (PUT)
+func (s *Server) handleSCIMReplaceGroup() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		role := s.scimLoadGroup(w, r)
+		if role == nil {
+			return
+		}
+
+		var resource SCIMGroup
+		if err := s.readSCIM(w, r, &resource); err != nil {
+			s.respondSCIMError(w, http.StatusBadRequest, "invalidSyntax", "Invalid request payload")
+			return
+		}
+
+		members := map[int]bool{}
+		if err := addMembers(members, resource.Members); err != nil {
+			s.respondSCIMBadRequest(w, err)
+			return
+		}
+		previous, ok := s.scimGroupMembers(w, role)
+		if !ok {
+			return
+		}
+		before := *role
+		role.Name = resource.DisplayName
+		if !s.scimSaveGroup(w, &before, role, members) {
+			return
+		}
+
+		s.scimAuditGroup(r, "update", "Group replaced: "+role.Name, &before, role, previous, members)
+		s.scimGroupResponse(w, http.StatusOK, role)
+	}
+}
+
+// handleSCIMPatchGroup returns a handler for partially updating a group
+func (s *Server) handleSCIMPatchGroup() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		role := s.scimLoadGroup(w, r)
+		if role == nil {
+			return
+		}
+
+		var req SCIMPatchRequest
+		if err := s.readSCIM(w, r, &req); err != nil {
+			s.respondSCIMError(w, http.StatusBadRequest, "invalidSyntax", "Invalid request payload")
+			return
+		}
+
+		previous, ok := s.scimGroupMembers(w, role)
+		if !ok {
+			return
+		}
+		members := maps.Clone(previous)
+
+		before := *role
+		if err := applyGroupPatch(&role.Name, members, req.Operations); err != nil {
+			s.respondSCIMBadRequest(w, err)
+			return
+		}
+		if !s.scimSaveGroup(w, &before, role, members) {
+			return
+		}
+
+		s.scimAuditGroup(r, "update", "Group patched: "+role.Name, &before, role, previous, members)
+		s.scimGroupResponse(w, http.StatusOK, role)
+	}
+}
+
+// handleSCIMDeleteGroup returns a handler for removing a group and its role
+func (s *Server) handleSCIMDeleteGroup() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		role := s.scimLoadGroup(w, r)
+		if role == nil {
+			return
+		}
+
+		if role.Name == adminRoleName {
+			s.respondSCIMError(w, http.StatusBadRequest, "mutability", "The built-in admin role cannot be deleted")
+			return
+		}
+
+		if err := s.models.Roles.Delete(role.ID); err != nil {
+			s.logger.Printf("Error deleting role: %v", err)
+			s.respondSCIMError(w, http.StatusInternalServerError, "", "Failed to delete group")
+			return
+		}
+
+		s.scimAudit(r, "delete", "role", role.ID, "Group deleted: "+role.Name, role, nil)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,111 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/theshovonaha/mini-pam/internal/database"
+	"github.com/theshovonaha/mini-pam/internal/models"
+)
+
+func TestSCIMRequiresToken(t *testing.T) {
+	// Create a new server for testing
+	logger := log.New(os.Stdout, "", log.Ldate|log.Ltime)
+	db := &database.Connection{}
+	srv := NewServer(Config{Environment: "test", SCIMToken: "scim-secret"}, logger, db)
+
+	// Session tokens and wrong tokens are both refused
+	for _, header := range []string{"", "Bearer wrong", "scim-secret"} {
+		req := httptest.NewRequest("GET", "/scim/v2/Users", nil)
+		if header != "" {
+			req.Header.Set("Authorization", header)
+		}
+
+		rr := httptest.NewRecorder()
+		srv.Routes().ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusUnauthorized {
+			t.Errorf("header %q: handler returned wrong status code: got %v want %v", header, rr.Code, http.StatusUnauthorized)
+		}
+	}
+}
+
+func TestUserFilterFromSCIM(t *testing.T) {
+	filter, err := userFilterFromSCIM(`userName eq "alice@example.com"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filter.Username != "alice@example.com" {
+		t.Errorf("wrong username filter: got %v want %v", filter.Username, "alice@example.com")
+	}
+
+	filter, err = userFilterFromSCIM(`active EQ false`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filter.Active == nil || *filter.Active {
+		t.Errorf("wrong active filter: got %v want %v", filter.Active, false)
+	}
+
+	// Unsupported operators and attributes are client errors
+	for _, f := range []string{`userName co "ali"`, `title eq "x"`} {
+		if _, err := userFilterFromSCIM(f); err == nil {
+			t.Errorf("filter %q: expected an error", f)
+		}
+	}
+}
+
+func TestApplyUserPatch(t *testing.T) {
+	user := &models.User{Username: "alice", Email: "alice@example.com", FirstName: "Alice", Active: true}
+
+	// Shapes sent by common identity providers
+	var ops []SCIMPatchOpItem
+	body := `[
+		{"op": "Replace", "path": "active", "value": "False"},
+		{"op": "replace", "path": "emails[type eq \"work\"].value", "value": "alice@corp.example"},
+		{"op": "add", "value": {"name.familyName": "Admin", "title": "ignored"}},
+		{"op": "remove", "path": "name.givenName"}
+	]`
+	if err := json.Unmarshal([]byte(body), &ops); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := applyUserPatch(user, ops); err != nil {
+		t.Fatal(err)
+	}
+	if user.Active {
+		t.Errorf("user was not deactivated")
+	}
+	if user.Email != "alice@corp.example" || user.LastName != "Admin" || user.FirstName != "" {
+		t.Errorf("user patched incorrectly: got %+v", user)
+	}
+}
+
+func TestApplyGroupPatch(t *testing.T) {
+	name := "operators"
+	members := map[int]bool{1: true, 2: true}
+
+	var ops []SCIMPatchOpItem
+	body := `[
+		{"op": "add", "path": "members", "value": [{"value": "3"}]},
+		{"op": "remove", "path": "members[value eq \"1\"]"},
+		{"op": "replace", "path": "displayName", "value": "db-operators"}
+	]`
+	if err := json.Unmarshal([]byte(body), &ops); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := applyGroupPatch(&name, members, ops); err != nil {
+		t.Fatal(err)
+	}
+	if name != "db-operators" {
+		t.Errorf("wrong group name: got %v want %v", name, "db-operators")
+	}
+	if len(members) != 2 || !members[2] || !members[3] {
+		t.Errorf("wrong group members: got %v want %v", members, map[int]bool{2: true, 3: true})
+	}
+}
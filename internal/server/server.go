@@ -1,21 +1,78 @@
 package server
 
 import (
+	"crypto/tls"
 	"log"
 	"net/http"
+	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/theshovonaha/mini-pam/internal/auth"
 	"github.com/theshovonaha/mini-pam/internal/database"
+	"github.com/theshovonaha/mini-pam/internal/mail"
 	"github.com/theshovonaha/mini-pam/internal/models"
+	"github.com/theshovonaha/mini-pam/internal/notify"
+	"github.com/theshovonaha/mini-pam/internal/recording"
+	"github.com/theshovonaha/mini-pam/internal/siem"
 )
 
+// Config holds the server configuration
+type Config struct {
+	Environment  string
+	SessionTTL   time.Duration // Lifetime of a bearer token issued at login
+	MFAFreshness time.Duration // How recent a step-up must be for sensitive operations
+	MFAIssuer    string        // Issuer shown in authenticator apps
+
+	// Authenticator verifies login passwords; nil uses the users table
+	Authenticator auth.Authenticator
+
+	// OIDC enables single sign-on through an OpenID Connect provider when set
+	OIDC *auth.OIDCProvider
+
+	// SCIMToken is the bearer token for /scim/v2; empty disables SCIM
+	SCIMToken string
+
+	// Lockout throttles password guessing; the zero value uses the defaults
+	Lockout auth.LockoutPolicy
+
+	// PasswordPolicy applies to local passwords; a zero MinLength uses the defaults
+	PasswordPolicy auth.PasswordPolicy
+
+	// Mailer delivers password reset emails; nil disables self-service reset
+	Mailer           mail.Mailer
+	PasswordResetTTL time.Duration // How long an emailed reset token stays valid
+	PasswordResetURL string        // Page that receives the token as ?token=; empty sends the bare token
+
+	// Events forwards audit and credential access events to SIEM sinks; nil disables forwarding
+	Events *siem.Pipeline
+
+	// Recordings stores encrypted SSH session recordings; nil disables recording
+	Recordings  *recording.Store
+	RecordInput bool // Record keystrokes as well as output
+
+	// Notifier tells users about events that concern them; nil disables notifications
+	Notifier *notify.Notifier
+
+	// Web console sessions, each console served on a host of its own as
+	// <credential ID>.<WebProxyDomain>; an empty domain disables them
+	WebProxyDomain string        // Parent domain of console hosts, with the port browsers use if not the default
+	WebProxyTTL    time.Duration // How long a web session lasts once created
+	WebProxyTLS    *tls.Config   // Client TLS settings for reaching consoles; nil uses the system roots
+}
+
 // Server is our API server
 type Server struct {
-	environment string
-	logger      *log.Logger
-	router      *mux.Router
-	db          *database.Connection
-	models      Models
+	config        Config
+	environment   string
+	authenticator auth.Authenticator
+	logger        *log.Logger
+	router        *mux.Router
+	db            *database.Connection
+	models        Models
+	ssh           *sshBackend
+	webTransport  http.RoundTripper
+
+	serviceAccountRoutes map[*mux.Route]bool
 }
 
 // Models holds all the repository instances
@@ -24,15 +81,64 @@ type Models struct {
 	Roles       *models.RoleRepository
 	Credentials *models.CredentialRepository
 	AuditLogs   *models.AuditLogRepository
+	Sessions    *models.SessionRepository
+	MFA         *models.MFARepository
+	OIDCStates  *models.OIDCStateRepository
+
+	ServiceAccounts *models.ServiceAccountRepository
+	LoginAttempts   *models.LoginAttemptRepository
+	PasswordHistory *models.PasswordHistoryRepository
+	PasswordResets  *models.PasswordResetRepository
+	Webhooks        *models.WebhookRepository
+	AuditArchives   *models.AuditArchiveRepository
+	SSHSessions     *models.SSHSessionRepository
+	CommandRules    *models.CommandRuleRepository
+	DBSessions      *models.DBSessionRepository
+	WebSessions     *models.WebSessionRepository
+	Notifications   *models.NotificationRepository
 }
 
 // NewServer creates a new server instance
-func NewServer(environment string, logger *log.Logger, db *database.Connection) *Server {
+func NewServer(cfg Config, logger *log.Logger, db *database.Connection) *Server {
+	// Apply defaults for unset durations
+	if cfg.SessionTTL <= 0 {
+		cfg.SessionTTL = 8 * time.Hour
+	}
+	if cfg.MFAFreshness <= 0 {
+		cfg.MFAFreshness = 5 * time.Minute
+	}
+	if cfg.MFAIssuer == "" {
+		cfg.MFAIssuer = "mini-pam"
+	}
+	if cfg.Lockout == (auth.LockoutPolicy{}) {
+		cfg.Lockout = auth.DefaultLockoutPolicy()
+	}
+	// Default the password policy field by field, so setting one rule does not drop the rest
+	defaultPolicy := auth.DefaultPasswordPolicy()
+	if cfg.PasswordPolicy.MinLength <= 0 {
+		cfg.PasswordPolicy.MinLength = defaultPolicy.MinLength
+	}
+	if cfg.PasswordPolicy.MinCharClass <= 0 {
+		cfg.PasswordPolicy.MinCharClass = defaultPolicy.MinCharClass
+	}
+	if cfg.PasswordPolicy.HistorySize <= 0 {
+		cfg.PasswordPolicy.HistorySize = defaultPolicy.HistorySize
+	}
+	if cfg.PasswordResetTTL <= 0 {
+		cfg.PasswordResetTTL = 30 * time.Minute
+	}
+	if cfg.WebProxyTTL <= 0 {
+		cfg.WebProxyTTL = time.Hour
+	}
+
 	s := &Server{
-		environment: environment,
+		config:      cfg,
+		environment: cfg.Environment,
 		logger:      logger,
 		router:      mux.NewRouter(),
 		db:          db,
+
+		serviceAccountRoutes: map[*mux.Route]bool{},
 	}
 
 	// Initialize repositories
@@ -41,6 +147,31 @@ func NewServer(environment string, logger *log.Logger, db *database.Connection)
 		Roles:       models.NewRoleRepository(db),
 		Credentials: models.NewCredentialRepository(db),
 		AuditLogs:   models.NewAuditLogRepository(db),
+		Sessions:    models.NewSessionRepository(db),
+		MFA:         models.NewMFARepository(db),
+		OIDCStates:  models.NewOIDCStateRepository(db),
+
+		ServiceAccounts: models.NewServiceAccountRepository(db),
+		LoginAttempts:   models.NewLoginAttemptRepository(db),
+		PasswordHistory: models.NewPasswordHistoryRepository(db),
+		PasswordResets:  models.NewPasswordResetRepository(db),
+		Webhooks:        models.NewWebhookRepository(db),
+		AuditArchives:   models.NewAuditArchiveRepository(db),
+		SSHSessions:     models.NewSSHSessionRepository(db),
+		CommandRules:    models.NewCommandRuleRepository(db),
+		DBSessions:      models.NewDBSessionRepository(db),
+		WebSessions:     models.NewWebSessionRepository(db),
+		Notifications:   models.NewNotificationRepository(db),
+	}
+	s.ssh = newSSHBackend(s)
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = cfg.WebProxyTLS
+	s.webTransport = transport
+
+	s.authenticator = cfg.Authenticator
+	if s.authenticator == nil {
+		s.authenticator = auth.NewLocalAuthenticator(s.models.Users)
 	}
 
 	return s
@@ -57,12 +188,37 @@ func (s *Server) Routes() http.Handler {
 	// Version info endpoint
 	v1.HandleFunc("/version", s.handleVersion()).Methods("GET")
 
-	// User routes
-	v1.HandleFunc("/users", s.handleListUsers()).Methods("GET")
-	v1.HandleFunc("/users", s.handleCreateUser()).Methods("POST")
-	v1.HandleFunc("/users/{id:[0-9]+}", s.handleGetUser()).Methods("GET")
-	v1.HandleFunc("/users/{id:[0-9]+}", s.handleUpdateUser()).Methods("PUT")
-	v1.HandleFunc("/users/{id:[0-9]+}", s.handleDeleteUser()).Methods("DELETE")
+	// Login endpoint (issues bearer tokens)
+	v1.HandleFunc("/auth/login", s.handleLogin()).Methods("POST")
+	v1.HandleFunc("/auth/change-password", s.handleChangePassword()).Methods("POST")
+
+	// Self-service password reset endpoints
+	if s.config.Mailer != nil {
+		v1.HandleFunc("/auth/password-reset", s.handleRequestPasswordReset()).Methods("POST")
+		v1.HandleFunc("/auth/password-reset/confirm", s.handleConfirmPasswordReset()).Methods("POST")
+	}
+
+	// Single sign-on endpoints
+	if s.config.OIDC != nil {
+		v1.HandleFunc("/auth/oidc/login", s.handleOIDCLogin()).Methods("GET")
+		v1.HandleFunc("/auth/oidc/callback", s.handleOIDCCallback()).Methods("GET")
+	}
+
+	// Everything below requires a valid bearer token
+	protected := v1.NewRoute().Subrouter()
+	protected.Use(s.authMiddleware)
+
+	// MFA routes
+	protected.HandleFunc("/auth/mfa/enroll", s.handleEnrollMFA()).Methods("POST")
+	protected.HandleFunc("/auth/step-up", s.handleStepUp()).Methods("POST")
+
+	// User routes (changes to accounts are for administrators, with MFA where they grant or take away access)
+	protected.HandleFunc("/users", s.handleListUsers()).Methods("GET")
+	protected.HandleFunc("/users", s.requireAdmin(s.handleCreateUser())).Methods("POST")
+	protected.HandleFunc("/users/{id:[0-9]+}", s.handleGetUser()).Methods("GET")
+	protected.HandleFunc("/users/{id:[0-9]+}", s.requireAdmin(s.requireMFA(s.handleUpdateUser()))).Methods("PUT")
+	protected.HandleFunc("/users/{id:[0-9]+}", s.requireAdmin(s.requireMFA(s.handleDeleteUser()))).Methods("DELETE")
+	protected.HandleFunc("/users/{id:[0-9]+}/unlock", s.requireAdmin(s.handleUnlockUser())).Methods("POST")
 
 	// // Role routes
 	// v1.HandleFunc("/roles", s.handleListRoles()).Methods("GET")
@@ -70,9 +226,9 @@ func (s *Server) Routes() http.Handler {
 	// v1.HandleFunc("/roles/{id:[0-9]+}", s.handleGetRole()).Methods("GET")
 	// v1.HandleFunc("/roles/{id:[0-9]+}", s.handleUpdateRole()).Methods("PUT")
 	// v1.HandleFunc("/roles/{id:[0-9]+}", s.handleDeleteRole()).Methods("DELETE")
-	// v1.HandleFunc("/users/{id:[0-9]+}/roles", s.handleGetUserRoles()).Methods("GET")
-	// v1.HandleFunc("/users/{id:[0-9]+}/roles/{roleId:[0-9]+}", s.handleAssignRole()).Methods("POST")
-	// v1.HandleFunc("/users/{id:[0-9]+}/roles/{roleId:[0-9]+}", s.handleRemoveRole()).Methods("DELETE")
+	protected.HandleFunc("/users/{id:[0-9]+}/roles", s.requireAdmin(s.handleGetUserRoles())).Methods("GET")
+	protected.HandleFunc("/users/{id:[0-9]+}/roles/{roleId:[0-9]+}", s.requireAdmin(s.requireMFA(s.handleAssignRole()))).Methods("POST")
+	protected.HandleFunc("/users/{id:[0-9]+}/roles/{roleId:[0-9]+}", s.requireAdmin(s.requireMFA(s.handleRemoveRole()))).Methods("DELETE")
 
 	// // Credential routes
 	// v1.HandleFunc("/credentials", s.handleListCredentials()).Methods("GET")
@@ -80,20 +236,117 @@ func (s *Server) Routes() http.Handler {
 	// v1.HandleFunc("/credentials/{id:[0-9]+}", s.handleGetCredential()).Methods("GET")
 	// v1.HandleFunc("/credentials/{id:[0-9]+}", s.handleUpdateCredential()).Methods("PUT")
 	// v1.HandleFunc("/credentials/{id:[0-9]+}", s.handleDeleteCredential()).Methods("DELETE")
+	s.allowServiceAccount(protected.HandleFunc("/credentials/expiring", s.handleListExpiringCredentials()).Methods("GET"))
+	s.allowServiceAccount(protected.HandleFunc("/credentials/{id:[0-9]+}/secret", s.requireMFA(s.handleRevealCredential())).Methods("GET"))
 	// v1.HandleFunc("/credentials/{id:[0-9]+}/access", s.handleGetCredentialAccessHistory()).Methods("GET")
 	// v1.HandleFunc("/credentials/{id:[0-9]+}/access", s.handleLogCredentialAccess()).Methods("POST")
 
-	// // Audit log routes
-	// v1.HandleFunc("/audit-logs", s.handleListAuditLogs()).Methods("GET")
-	// v1.HandleFunc("/audit-logs/users/{id:[0-9]+}", s.handleGetUserAuditLogs()).Methods("GET")
-	// v1.HandleFunc("/audit-logs/resources/{resource}/{id:[0-9]+}", s.handleGetResourceAuditLogs()).Methods("GET")
+	// Service account routes
+	protected.HandleFunc("/service-accounts", s.requireAdmin(s.handleListServiceAccounts())).Methods("GET")
+	protected.HandleFunc("/service-accounts", s.requireAdmin(s.handleCreateServiceAccount())).Methods("POST")
+	protected.HandleFunc("/service-accounts/{id:[0-9]+}", s.requireAdmin(s.handleGetServiceAccount())).Methods("GET")
+	protected.HandleFunc("/service-accounts/{id:[0-9]+}", s.requireAdmin(s.handleDeactivateServiceAccount())).Methods("DELETE")
+	protected.HandleFunc("/service-accounts/{id:[0-9]+}/keys", s.requireAdmin(s.handleListAPIKeys())).Methods("GET")
+	protected.HandleFunc("/service-accounts/{id:[0-9]+}/keys", s.requireAdmin(s.requireMFA(s.handleCreateAPIKey()))).Methods("POST")
+	protected.HandleFunc("/service-accounts/{id:[0-9]+}/keys/{keyId:[0-9]+}/rotate", s.requireAdmin(s.requireMFA(s.handleRotateAPIKey()))).Methods("POST")
+	protected.HandleFunc("/service-accounts/{id:[0-9]+}/keys/{keyId:[0-9]+}", s.requireAdmin(s.handleRevokeAPIKey())).Methods("DELETE")
+	protected.HandleFunc("/service-accounts/{id:[0-9]+}/certificates", s.requireAdmin(s.handleListCertificateBindings())).Methods("GET")
+	protected.HandleFunc("/service-accounts/{id:[0-9]+}/certificates", s.requireAdmin(s.requireMFA(s.handleCreateCertificateBinding()))).Methods("POST")
+	protected.HandleFunc("/service-accounts/{id:[0-9]+}/certificates/{certId:[0-9]+}", s.requireAdmin(s.handleDeleteCertificateBinding())).Methods("DELETE")
+
+	// Audit log routes
+	protected.HandleFunc("/audit-logs", s.requireAdmin(s.handleListAuditLogs())).Methods("GET")
+	protected.HandleFunc("/audit-logs/users/{id:[0-9]+}", s.requireAdmin(s.handleGetUserAuditLogs())).Methods("GET")
+	protected.HandleFunc("/audit-logs/resources/{resource}/{id:[0-9]+}", s.requireAdmin(s.handleGetResourceAuditLogs())).Methods("GET")
+	protected.HandleFunc("/audit-logs/verify", s.requireAdmin(s.handleVerifyAuditChain())).Methods("GET")
+
+	// Report routes (the health report maps every weak and reused secret, so it needs MFA)
+	protected.HandleFunc("/reports/credential-health", s.requireAdmin(s.requireMFA(s.handleCredentialHealthReport()))).Methods("GET")
+
+	// Webhook routes
+	protected.HandleFunc("/webhooks", s.requireAdmin(s.handleListWebhooks())).Methods("GET")
+	protected.HandleFunc("/webhooks", s.requireAdmin(s.requireMFA(s.handleCreateWebhook()))).Methods("POST")
+	protected.HandleFunc("/webhooks/event-types", s.requireAdmin(s.handleListWebhookEventTypes())).Methods("GET")
+	protected.HandleFunc("/webhooks/{id:[0-9]+}", s.requireAdmin(s.handleGetWebhook())).Methods("GET")
+	protected.HandleFunc("/webhooks/{id:[0-9]+}", s.requireAdmin(s.requireMFA(s.handleUpdateWebhook()))).Methods("PUT")
+	protected.HandleFunc("/webhooks/{id:[0-9]+}", s.requireAdmin(s.handleDeleteWebhook())).Methods("DELETE")
+	protected.HandleFunc("/webhooks/{id:[0-9]+}/deliveries", s.requireAdmin(s.handleListWebhookDeliveries())).Methods("GET")
+	protected.HandleFunc("/webhooks/{id:[0-9]+}/deliveries/{deliveryId:[0-9]+}/replay", s.requireAdmin(s.handleReplayWebhookDelivery())).Methods("POST")
+
+	// SSH session routes
+	protected.HandleFunc("/sessions", s.requireAdmin(s.handleListSSHSessions())).Methods("GET")
+	protected.HandleFunc("/sessions/{id:[0-9]+}", s.requireAdmin(s.handleGetSSHSession())).Methods("GET")
+	protected.HandleFunc("/sessions/{id:[0-9]+}/recording", s.requireAdmin(s.requireMFA(s.handleGetSSHSessionRecording()))).Methods("GET")
+	protected.HandleFunc("/sessions/{id:[0-9]+}/watch", s.requireAdmin(s.requireMFA(s.handleWatchSSHSession()))).Methods("GET")
+	protected.HandleFunc("/sessions/{id:[0-9]+}/terminate", s.requireAdmin(s.handleTerminateSSHSession())).Methods("POST")
+
+	// Postgres session routes (query text can hold sensitive values, like recordings)
+	protected.HandleFunc("/db-sessions", s.requireAdmin(s.handleListDBSessions())).Methods("GET")
+	protected.HandleFunc("/db-sessions/{id:[0-9]+}", s.requireAdmin(s.handleGetDBSession())).Methods("GET")
+	protected.HandleFunc("/db-sessions/{id:[0-9]+}/queries", s.requireAdmin(s.requireMFA(s.handleListDBSessionQueries()))).Methods("GET")
+
+	// Web session routes (consoles themselves are served on hosts of their own, outside the API;
+	// opening one uses the credential as revealing it would, so it needs MFA too)
+	if s.config.WebProxyDomain != "" {
+		protected.HandleFunc("/credentials/{id:[0-9]+}/web-sessions", s.requireMFA(s.handleCreateWebSession())).Methods("POST")
+	}
+	protected.HandleFunc("/web-sessions", s.requireAdmin(s.handleListWebSessions())).Methods("GET")
+	protected.HandleFunc("/web-sessions/{id:[0-9]+}", s.requireAdmin(s.handleGetWebSession())).Methods("GET")
+	protected.HandleFunc("/web-sessions/{id:[0-9]+}/requests", s.requireAdmin(s.handleListWebSessionRequests())).Methods("GET")
+	protected.HandleFunc("/web-sessions/{id:[0-9]+}", s.handleEndWebSession()).Methods("DELETE")
+
+	// Notification routes
+	if s.config.Notifier != nil {
+		protected.HandleFunc("/notifications/preferences", s.handleGetNotificationPreferences()).Methods("GET")
+		protected.HandleFunc("/notifications/preferences/{event}", s.handleSetNotificationPreference()).Methods("PUT")
+		protected.HandleFunc("/notifications/preferences/{event}", s.handleDeleteNotificationPreference()).Methods("DELETE")
+		protected.HandleFunc("/notifications", s.requireAdmin(s.handleListNotifications())).Methods("GET")
+	}
+
+	// Command rule routes (changes decide what SSH proxy users may run, so they need MFA)
+	protected.HandleFunc("/command-rules", s.requireAdmin(s.handleListCommandRules())).Methods("GET")
+	protected.HandleFunc("/command-rules", s.requireAdmin(s.requireMFA(s.handleCreateCommandRule()))).Methods("POST")
+	protected.HandleFunc("/command-rules/{id:[0-9]+}", s.requireAdmin(s.handleGetCommandRule())).Methods("GET")
+	protected.HandleFunc("/command-rules/{id:[0-9]+}", s.requireAdmin(s.requireMFA(s.handleUpdateCommandRule()))).Methods("PUT")
+	protected.HandleFunc("/command-rules/{id:[0-9]+}", s.requireAdmin(s.requireMFA(s.handleDeleteCommandRule()))).Methods("DELETE")
+
+	// SCIM provisioning routes (separate bearer token, outside /api/v1)
+	if s.config.SCIMToken != "" {
+		scim := s.router.PathPrefix("/scim/v2").Subrouter()
+		scim.Use(s.scimAuthMiddleware)
+		scim.HandleFunc("/Users", s.handleSCIMListUsers()).Methods("GET")
+		scim.HandleFunc("/Users", s.handleSCIMCreateUser()).Methods("POST")
+		scim.HandleFunc("/Users/{id}", s.handleSCIMGetUser()).Methods("GET")
+		scim.HandleFunc("/Users/{id}", s.handleSCIMReplaceUser()).Methods("PUT")
+		scim.HandleFunc("/Users/{id}", s.handleSCIMPatchUser()).Methods("PATCH")
+		scim.HandleFunc("/Users/{id}", s.handleSCIMDeleteUser()).Methods("DELETE")
+		scim.HandleFunc("/Groups", s.handleSCIMListGroups()).Methods("GET")
+		scim.HandleFunc("/Groups", s.handleSCIMCreateGroup()).Methods("POST")
+		scim.HandleFunc("/Groups/{id}", s.handleSCIMGetGroup()).Methods("GET")
+		scim.HandleFunc("/Groups/{id}", s.handleSCIMReplaceGroup()).Methods("PUT")
+		scim.HandleFunc("/Groups/{id}", s.handleSCIMPatchGroup()).Methods("PATCH")
+		scim.HandleFunc("/Groups/{id}", s.handleSCIMDeleteGroup()).Methods("DELETE")
+	}
 
 	// Add middleware (order matters)
 	s.router.Use(s.corsMiddleware)
 	s.router.Use(s.rateLimitMiddleware)
-	s.router.Use(s.authMiddleware)
 	s.router.Use(s.loggingMiddleware)
 	s.router.Use(s.recoverPanicMiddleware)
 
-	return s.router
+	// Web consoles are told apart by host and get their own router: a
+	// console page loads many assets, which the API rate limit would
+	// throttle, and answers for its own CORS
+	web := mux.NewRouter()
+	web.PathPrefix("/").Handler(s.handleWebProxy())
+	web.Use(s.loggingMiddleware)
+	web.Use(s.recoverPanicMiddleware)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := s.webConsoleID(r.Host); ok {
+			web.ServeHTTP(w, r)
+			return
+		}
+		s.router.ServeHTTP(w, r)
+	})
 }
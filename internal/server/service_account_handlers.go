@@ -0,0 +1,475 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/theshovonaha/mini-pam/internal/auth"
+	"github.com/theshovonaha/mini-pam/internal/models"
+)
+
+// ServiceAccountRequest represents the request body for creating a service account
+type ServiceAccountRequest struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+// APIKeyRequest represents the request body for issuing an API key
+type APIKeyRequest struct {
+	Scopes     []string   `json:"scopes"`
+	AllowedIPs []string   `json:"allowed_ips"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+}
+
+// APIKeyResponse carries a newly issued key; the key itself is shown only once
+type APIKeyResponse struct {
+	Key    string         `json:"key"`
+	APIKey *models.APIKey `json:"api_key"`
+}
+
+// handleListServiceAccounts returns a handler for listing service accounts
+func (s *Server) handleListServiceAccounts() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		accounts, err := s.models.ServiceAccounts.List()
+		if err != nil {
+			s.logger.Printf("Error listing service accounts: %v", err)
+			s.respondError(w, http.StatusInternalServerError, "Failed to list service accounts")
+			return
+		}
+
+		s.respondJSON(w, http.StatusOK, accounts)
+	}
+}
+
+// handleCreateServiceAccount returns a handler for creating a service account
+func (s *Server) handleCreateServiceAccount() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Parse the request body
+		var req ServiceAccountRequest
+		if err := s.readJSON(w, r, &req); err != nil {
+			s.respondError(w, http.StatusBadRequest, "Invalid request payload")
+			return
+		}
+		if req.Name == "" {
+			s.respondError(w, http.StatusBadRequest, "Name is required")
+			return
+		}
+
+		account := &models.ServiceAccount{
+			Name:        req.Name,
+			Description: req.Description,
+			Active:      true,
+			CreatedBy:   s.contextGetPrincipal(r).UserID,
+		}
+		if err := s.models.ServiceAccounts.Create(account); err != nil {
+			if errors.Is(err, models.ErrDuplicateKey) {
+				s.respondError(w, http.StatusConflict, "A service account with that name already exists")
+			} else {
+				s.logger.Printf("Error creating service account: %v", err)
+				s.respondError(w, http.StatusInternalServerError, "Failed to create service account")
+			}
+			return
+		}
+
+		// Create an audit log entry
+		s.auditChange(r, &models.AuditLog{
+			Action:     "create",
+			Resource:   "service_account",
+			ResourceID: account.ID,
+			Details:    "Service account created: " + account.Name,
+		}, nil, account)
+
+		s.respondJSON(w, http.StatusCreated, account)
+	}
+}
+
+// loadServiceAccount resolves the {id} path variable, responding on failure
+func (s *Server) loadServiceAccount(w http.ResponseWriter, r *http.Request) *models.ServiceAccount {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		s.respondError(w, http.StatusBadRequest, "Invalid service account ID")
+		return nil
+	}
+
+	account, err := s.models.ServiceAccounts.GetByID(id)
+	if err != nil {
+		if errors.Is(err, models.ErrRecordNotFound) {
+			s.respondError(w, http.StatusNotFound, "Service account not found")
+		} else {
+			s.logger.Printf("Error getting service account: %v", err)
+			s.respondError(w, http.StatusInternalServerError, "Failed to get service account")
+		}
+		return nil
+	}
+	return account
+}
+
+// handleGetServiceAccount returns a handler for getting a service account by ID
+func (s *Server) handleGetServiceAccount() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		account := s.loadServiceAccount(w, r)
+		if account == nil {
+			return
+		}
+
+		s.respondJSON(w, http.StatusOK, account)
+	}
+}
+
+// handleDeactivateServiceAccount returns a handler that disables a service account and its keys
+func (s *Server) handleDeactivateServiceAccount() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		account := s.loadServiceAccount(w, r)
+		if account == nil {
+			return
+		}
+
+		if err := s.models.ServiceAccounts.Deactivate(account.ID); err != nil {
+			s.logger.Printf("Error deactivating service account: %v", err)
+			s.respondError(w, http.StatusInternalServerError, "Failed to deactivate service account")
+			return
+		}
+		before := *account
+		account.Active = false
+
+		// Create an audit log entry
+		s.auditChange(r, &models.AuditLog{
+			Action:     "deactivate",
+			Resource:   "service_account",
+			ResourceID: account.ID,
+			Details:    "Service account deactivated and keys revoked: " + account.Name,
+		}, &before, account)
+
+		s.respondJSON(w, http.StatusOK, map[string]string{"message": "Service account deactivated successfully"})
+	}
+}
+
+// handleListAPIKeys returns a handler for listing a service account's keys
+func (s *Server) handleListAPIKeys() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		account := s.loadServiceAccount(w, r)
+		if account == nil {
+			return
+		}
+
+		keys, err := s.models.ServiceAccounts.ListKeys(account.ID)
+		if err != nil {
+			s.logger.Printf("Error listing API keys: %v", err)
+			s.respondError(w, http.StatusInternalServerError, "Failed to list API keys")
+			return
+		}
+
+		s.respondJSON(w, http.StatusOK, keys)
+	}
+}
+
+// issueAPIKey generates and stores a key from a template, returning the plaintext key
+func (s *Server) issueAPIKey(key *models.APIKey) (string, error) {
+	plaintext, prefix, secretHash, err := auth.GenerateAPIKey()
+	if err != nil {
+		return "", err
+	}
+
+	key.Prefix = prefix
+	key.SecretHash = secretHash
+	if err := s.models.ServiceAccounts.CreateKey(key); err != nil {
+		return "", err
+	}
+
+	return plaintext, nil
+}
+
+// handleCreateAPIKey returns a handler for issuing a new API key
+func (s *Server) handleCreateAPIKey() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		account := s.loadServiceAccount(w, r)
+		if account == nil {
+			return
+		}
+		if !account.Active {
+			s.respondError(w, http.StatusConflict, "Service account is deactivated")
+			return
+		}
+
+		// Parse the request body
+		var req APIKeyRequest
+		if err := s.readJSON(w, r, &req); err != nil {
+			s.respondError(w, http.StatusBadRequest, "Invalid request payload")
+			return
+		}
+
+		// Validate scopes, allowlist and expiry up front
+		if len(req.Scopes) == 0 {
+			s.respondError(w, http.StatusBadRequest, "At least one scope is required")
+			return
+		}
+		for _, scope := range req.Scopes {
+			if _, err := auth.ParseScope(scope); err != nil {
+				s.respondError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+		}
+		for _, entry := range req.AllowedIPs {
+			if err := auth.ValidateAllowlistEntry(entry); err != nil {
+				s.respondError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+		}
+		if req.ExpiresAt != nil && req.ExpiresAt.Before(time.Now()) {
+			s.respondError(w, http.StatusBadRequest, "Expiry must be in the future")
+			return
+		}
+
+		key := &models.APIKey{
+			ServiceAccountID: account.ID,
+			Scopes:           req.Scopes,
+			AllowedIPs:       req.AllowedIPs,
+			ExpiresAt:        req.ExpiresAt,
+		}
+		if key.AllowedIPs == nil {
+			key.AllowedIPs = []string{}
+		}
+		plaintext, err := s.issueAPIKey(key)
+		if err != nil {
+			s.logger.Printf("Error creating API key: %v", err)
+			s.respondError(w, http.StatusInternalServerError, "Failed to create API key")
+			return
+		}
+
+		// Create an audit log entry
+		s.auditChange(r, &models.AuditLog{
+			Action:     "create",
+			Resource:   "api_key",
+			ResourceID: key.ID,
+			Details:    "API key " + key.Prefix + " issued for service account " + account.Name,
+		}, nil, key)
+
+		s.respondJSON(w, http.StatusCreated, APIKeyResponse{Key: plaintext, APIKey: key})
+	}
+}
+
+// loadAPIKey resolves the {keyId} path variable for a service account, responding on failure
+func (s *Server) loadAPIKey(w http.ResponseWriter, r *http.Request, account *models.ServiceAccount) *models.APIKey {
+	keyID, err := strconv.Atoi(mux.Vars(r)["keyId"])
+	if err != nil {
+		s.respondError(w, http.StatusBadRequest, "Invalid API key ID")
+		return nil
+	}
+
+	key, err := s.models.ServiceAccounts.GetKey(account.ID, keyID)
+	if err != nil {
+		if errors.Is(err, models.ErrRecordNotFound) {
+			s.respondError(w, http.StatusNotFound, "API key not found")
+		} else {
+			s.logger.Printf("Error getting API key: %v", err)
+			s.respondError(w, http.StatusInternalServerError, "Failed to get API key")
+		}
+		return nil
+	}
+	return key
+}
+
+// handleRotateAPIKey returns a handler that replaces a key with a new one carrying the same grants
+func (s *Server) handleRotateAPIKey() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		account := s.loadServiceAccount(w, r)
+		if account == nil {
+			return
+		}
+		key := s.loadAPIKey(w, r, account)
+		if key == nil {
+			return
+		}
+		if key.RevokedAt != nil {
+			s.respondError(w, http.StatusConflict, "API key is already revoked")
+			return
+		}
+
+		replacement := &models.APIKey{
+			ServiceAccountID: account.ID,
+			Scopes:           key.Scopes,
+			AllowedIPs:       key.AllowedIPs,
+			ExpiresAt:        key.ExpiresAt,
+			RotatedFromID:    &key.ID,
+		}
+		plaintext, err := s.issueAPIKey(replacement)
+		if err != nil {
+			s.logger.Printf("Error creating API key: %v", err)
+			s.respondError(w, http.StatusInternalServerError, "Failed to rotate API key")
+			return
+		}
+
+		if err := s.models.ServiceAccounts.RevokeKey(key.ID); err != nil {
+			s.logger.Printf("Error revoking API key: %v", err)
+			s.respondError(w, http.StatusInternalServerError, "Failed to rotate API key")
+			return
+		}
+
+		// Create an audit log entry
+		s.audit(r, &models.AuditLog{
+			Action:     "rotate",
+			Resource:   "api_key",
+			ResourceID: replacement.ID,
+			Details:    "API key " + key.Prefix + " rotated to " + replacement.Prefix,
+		})
+
+		s.respondJSON(w, http.StatusCreated, APIKeyResponse{Key: plaintext, APIKey: replacement})
+	}
+}
+
+// handleRevokeAPIKey returns a handler that revokes a key
+func (s *Server) handleRevokeAPIKey() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		account := s.loadServiceAccount(w, r)
+		if account == nil {
+			return
+		}
+		key := s.loadAPIKey(w, r, account)
+		if key == nil {
+			return
+		}
+
+		if err := s.models.ServiceAccounts.RevokeKey(key.ID); err != nil {
+			s.logger.Printf("Error revoking API key: %v", err)
+			s.respondError(w, http.StatusInternalServerError, "Failed to revoke API key")
+			return
+		}
+
+		// Create an audit log entry
+		s.audit(r, &models.AuditLog{
+			Action:     "revoke",
+			Resource:   "api_key",
+			ResourceID: key.ID,
+			Details:    "API key " + key.Prefix + " revoked",
+		})
+
+		s.respondJSON(w, http.StatusOK, map[string]string{"message": "API key revoked successfully"})
+	}
+}
+
+// CertificateBindingRequest represents the request body for binding a client certificate
+type CertificateBindingRequest struct {
+	Identity string   `json:"identity"`
+	Scopes   []string `json:"scopes"`
+}
+
+// handleListCertificateBindings returns a handler for listing a service account's certificate bindings
+func (s *Server) handleListCertificateBindings() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		account := s.loadServiceAccount(w, r)
+		if account == nil {
+			return
+		}
+
+		certs, err := s.models.ServiceAccounts.ListCertificates(account.ID)
+		if err != nil {
+			s.logger.Printf("Error listing certificate bindings: %v", err)
+			s.respondError(w, http.StatusInternalServerError, "Failed to list certificate bindings")
+			return
+		}
+
+		s.respondJSON(w, http.StatusOK, certs)
+	}
+}
+
+// handleCreateCertificateBinding returns a handler that maps a client
+// certificate subject or SAN to a service account
+func (s *Server) handleCreateCertificateBinding() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		account := s.loadServiceAccount(w, r)
+		if account == nil {
+			return
+		}
+		if !account.Active {
+			s.respondError(w, http.StatusConflict, "Service account is deactivated")
+			return
+		}
+
+		// Parse the request body
+		var req CertificateBindingRequest
+		if err := s.readJSON(w, r, &req); err != nil {
+			s.respondError(w, http.StatusBadRequest, "Invalid request payload")
+			return
+		}
+
+		identity, err := auth.NormalizeCertificateIdentity(req.Identity)
+		if err != nil {
+			s.respondError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if len(req.Scopes) == 0 {
+			s.respondError(w, http.StatusBadRequest, "At least one scope is required")
+			return
+		}
+		for _, scope := range req.Scopes {
+			if _, err := auth.ParseScope(scope); err != nil {
+				s.respondError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+		}
+
+		cert := &models.ServiceAccountCertificate{
+			ServiceAccountID: account.ID,
+			Identity:         identity,
+			Scopes:           req.Scopes,
+		}
+		if err := s.models.ServiceAccounts.CreateCertificate(cert); err != nil {
+			if errors.Is(err, models.ErrDuplicateKey) {
+				s.respondError(w, http.StatusConflict, "That certificate identity is already bound")
+			} else {
+				s.logger.Printf("Error creating certificate binding: %v", err)
+				s.respondError(w, http.StatusInternalServerError, "Failed to create certificate binding")
+			}
+			return
+		}
+
+		// Create an audit log entry
+		s.audit(r, &models.AuditLog{
+			Action:     "create",
+			Resource:   "service_account_certificate",
+			ResourceID: cert.ID,
+			Details:    "Certificate " + cert.Identity + " bound to service account " + account.Name,
+		})
+
+		s.respondJSON(w, http.StatusCreated, cert)
+	}
+}
+
+// handleDeleteCertificateBinding returns a handler that removes a certificate binding
+func (s *Server) handleDeleteCertificateBinding() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		account := s.loadServiceAccount(w, r)
+		if account == nil {
+			return
+		}
+
+		certID, err := strconv.Atoi(mux.Vars(r)["certId"])
+		if err != nil {
+			s.respondError(w, http.StatusBadRequest, "Invalid certificate binding ID")
+			return
+		}
+
+		if err := s.models.ServiceAccounts.DeleteCertificate(account.ID, certID); err != nil {
+			if errors.Is(err, models.ErrRecordNotFound) {
+				s.respondError(w, http.StatusNotFound, "Certificate binding not found")
+			} else {
+				s.logger.Printf("Error deleting certificate binding: %v", err)
+				s.respondError(w, http.StatusInternalServerError, "Failed to delete certificate binding")
+			}
+			return
+		}
+
+		// Create an audit log entry
+		s.audit(r, &models.AuditLog{
+			Action:     "delete",
+			Resource:   "service_account_certificate",
+			ResourceID: certID,
+			Details:    "Certificate binding removed from service account " + account.Name,
+		})
+
+		s.respondJSON(w, http.StatusOK, map[string]string{"message": "Certificate binding deleted successfully"})
+	}
+}
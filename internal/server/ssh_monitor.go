@@ -0,0 +1,209 @@
+package server
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/theshovonaha/mini-pam/internal/models"
+	"github.com/theshovonaha/mini-pam/internal/recording"
+	"github.com/theshovonaha/mini-pam/internal/sshproxy"
+)
+
+// watcherBuffer is how many events a watcher may fall behind by before it
+// is dropped; a slow observer must never hold up the session itself
+const watcherBuffer = 256
+
+// sshWatchEvent is a change in a live session's terminal sent to watchers:
+// output, a new terminal size or the command of an exec session
+type sshWatchEvent struct {
+	Output  []byte
+	Columns int
+	Rows    int
+	Exec    string
+}
+
+// sshWatcher receives the events of one live session until the session
+// ends or the watcher falls behind, when events is closed
+type sshWatcher struct {
+	events chan sshWatchEvent
+	lagged bool
+}
+
+// liveSSHSession taps a relayed connection, feeding its recorder and the
+// operators watching it
+type liveSSHSession struct {
+	conn          *sshproxy.Conn
+	recorder      *recording.Recorder // nil when recording is not configured
+	inputRecorded bool
+
+	mu           sync.Mutex
+	term         string
+	columns      int
+	rows         int
+	watchers     map[*sshWatcher]struct{}
+	ended        bool
+	terminatedBy int    // The user who terminated the session, if any
+	reason       string // Why it was terminated
+}
+
+func newLiveSSHSession(conn *sshproxy.Conn, recorder *recording.Recorder, inputRecorded bool) *liveSSHSession {
+	return &liveSSHSession{
+		conn:          conn,
+		recorder:      recorder,
+		inputRecorded: inputRecorded,
+		watchers:      map[*sshWatcher]struct{}{},
+	}
+}
+
+// model describes the session as its database row would
+func (l *liveSSHSession) model() *models.SSHSession {
+	return &models.SSHSession{
+		ID:             l.conn.ID,
+		UserID:         l.conn.UserID,
+		CredentialID:   l.conn.Credential.ID,
+		Target:         l.conn.Addr,
+		TargetUsername: l.conn.Credential.Username,
+		IPAddress:      addrIP(l.conn.RemoteAddr),
+		ClientVersion:  l.conn.ClientVersion,
+		StartedAt:      l.conn.StartedAt,
+		InputRecorded:  l.inputRecorded,
+		Active:         true,
+	}
+}
+
+// Pty implements sshproxy.Tap
+func (l *liveSSHSession) Pty(term string, columns, rows int) {
+	if l.recorder != nil {
+		l.recorder.Pty(term, columns, rows)
+	}
+	l.mu.Lock()
+	l.term = term
+	l.mu.Unlock()
+	l.Resize(columns, rows)
+}
+
+// Resize implements sshproxy.Tap
+func (l *liveSSHSession) Resize(columns, rows int) {
+	if l.recorder != nil {
+		l.recorder.Resize(columns, rows)
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.columns, l.rows = columns, rows
+	l.broadcast(sshWatchEvent{Columns: columns, Rows: rows})
+}
+
+// Exec implements sshproxy.Tap
+func (l *liveSSHSession) Exec(command string) {
+	if l.recorder != nil {
+		l.recorder.Exec(command)
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.broadcast(sshWatchEvent{Exec: command})
+}
+
+// Output implements sshproxy.Tap
+func (l *liveSSHSession) Output(data []byte) {
+	if l.recorder != nil {
+		l.recorder.Output(data)
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.watchers) > 0 {
+		l.broadcast(sshWatchEvent{Output: append([]byte(nil), data...)})
+	}
+}
+
+// Input implements sshproxy.Tap. Input is recorded when configured but not
+// shown to watchers; what the user types is echoed in the output anyway,
+// except for passwords.
+func (l *liveSSHSession) Input(data []byte) {
+	if l.recorder != nil {
+		l.recorder.Input(data)
+	}
+}
+
+// broadcast sends event to every watcher, dropping those that fell behind.
+// The caller holds l.mu.
+func (l *liveSSHSession) broadcast(event sshWatchEvent) {
+	for watcher := range l.watchers {
+		select {
+		case watcher.events <- event:
+		default:
+			watcher.lagged = true
+			close(watcher.events)
+			delete(l.watchers, watcher)
+		}
+	}
+}
+
+// watch subscribes to the session's events, returning the terminal as it is
+// now. It returns nil if the session already ended.
+func (l *liveSSHSession) watch() (watcher *sshWatcher, term string, columns, rows int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.ended {
+		return nil, "", 0, 0
+	}
+	watcher = &sshWatcher{events: make(chan sshWatchEvent, watcherBuffer)}
+	l.watchers[watcher] = struct{}{}
+	return watcher, l.term, l.columns, l.rows
+}
+
+// unwatch unsubscribes a watcher that stopped on its own
+func (l *liveSSHSession) unwatch(watcher *sshWatcher) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, ok := l.watchers[watcher]; ok {
+		close(watcher.events)
+		delete(l.watchers, watcher)
+	}
+}
+
+// end releases the watchers once the session closed
+func (l *liveSSHSession) end() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.ended = true
+	for watcher := range l.watchers {
+		close(watcher.events)
+		delete(l.watchers, watcher)
+	}
+}
+
+// terminate disconnects the session on behalf of userID
+func (l *liveSSHSession) terminate(userID int, reason string) {
+	l.mu.Lock()
+	if l.terminatedBy == 0 {
+		l.terminatedBy, l.reason = userID, reason
+	}
+	l.mu.Unlock()
+	l.conn.Close()
+}
+
+// termination reports who terminated the session and why, if anyone did
+func (l *liveSSHSession) termination() (userID int, reason string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.terminatedBy, l.reason
+}
+
+// liveSessions returns the sessions currently relayed, newest first
+func (b *sshBackend) liveSessions() []*liveSSHSession {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	sessions := make([]*liveSSHSession, 0, len(b.live))
+	for _, live := range b.live {
+		sessions = append(sessions, live)
+	}
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].conn.ID > sessions[j].conn.ID })
+	return sessions
+}
+
+// liveSession returns the session with the given ID if it is still relayed
+func (b *sshBackend) liveSession(id int) *liveSSHSession {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.live[id]
+}
@@ -0,0 +1,105 @@
+package server
+
+import (
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/theshovonaha/mini-pam/internal/models"
+	"github.com/theshovonaha/mini-pam/internal/recording"
+	"github.com/theshovonaha/mini-pam/internal/sshproxy"
+)
+
+func newTestLiveSession(id int) *liveSSHSession {
+	conn := &sshproxy.Conn{
+		ID:         id,
+		UserID:     7,
+		Credential: &models.Credential{ID: 3, Username: "root"},
+		Addr:       "web01:22",
+		RemoteAddr: &net.TCPAddr{IP: net.IPv4(10, 0, 0, 8), Port: 50122},
+	}
+	return newLiveSSHSession(conn, recording.NewRecorder("root@web01:22", false, 0), false)
+}
+
+func TestLiveSSHSessionWatch(t *testing.T) {
+	live := newTestLiveSession(1)
+	live.Pty("xterm", 80, 24)
+
+	watcher, term, columns, rows := live.watch()
+	if watcher == nil || term != "xterm" || columns != 80 || rows != 24 {
+		t.Fatalf("watch = %v, %q, %dx%d", watcher, term, columns, rows)
+	}
+
+	output := []byte("uptime\r\n")
+	live.Output(output)
+	output[0] = 'X' // The proxy reuses its buffers
+	live.Input([]byte("secret\r"))
+	live.Resize(100, 30)
+	live.end()
+
+	events := []sshWatchEvent{}
+	for event := range watcher.events {
+		events = append(events, event)
+	}
+	want := []sshWatchEvent{{Output: []byte("uptime\r\n")}, {Columns: 100, Rows: 30}}
+	if !reflect.DeepEqual(events, want) {
+		t.Errorf("events %+v, want %+v", events, want)
+	}
+
+	// The recorder saw the same session
+	finished, err := live.recorder.Finish()
+	if err != nil || !strings.Contains(string(finished.Cast), "uptime") {
+		t.Errorf("recording %q, %v", finished.Cast, err)
+	}
+
+	if watcher, _, _, _ := live.watch(); watcher != nil {
+		t.Error("expected an ended session not to be watchable")
+	}
+}
+
+func TestLiveSSHSessionDropsSlowWatchers(t *testing.T) {
+	live := newTestLiveSession(1)
+	slow, _, _, _ := live.watch()
+	for i := 0; i <= watcherBuffer; i++ {
+		live.Output([]byte("x"))
+	}
+
+	// The session kept going and the watcher learns it fell behind
+	received := 0
+	for range slow.events {
+		received++
+	}
+	if received != watcherBuffer || !slow.lagged {
+		t.Errorf("received %d events, lagged %v", received, slow.lagged)
+	}
+}
+
+func TestLiveSSHSessionTerminate(t *testing.T) {
+	live := newTestLiveSession(1)
+	live.terminate(2, "suspicious activity")
+	live.terminate(5, "")
+
+	// The first operator to terminate the session is the one recorded
+	if userID, reason := live.termination(); userID != 2 || reason != "suspicious activity" {
+		t.Errorf("termination = %d, %q", userID, reason)
+	}
+}
+
+func TestLiveSSHSessions(t *testing.T) {
+	backend := newSSHBackend(nil)
+	for _, id := range []int{4, 9, 6} {
+		backend.live[id] = newTestLiveSession(id)
+	}
+
+	ids := []int{}
+	for _, live := range backend.liveSessions() {
+		ids = append(ids, live.model().ID)
+	}
+	if !reflect.DeepEqual(ids, []int{9, 6, 4}) || backend.liveSessions()[0].model().IPAddress != "10.0.0.8" {
+		t.Errorf("live sessions %v", ids)
+	}
+	if backend.liveSession(6) == nil || backend.liveSession(5) != nil {
+		t.Error("liveSession returned the wrong session")
+	}
+}
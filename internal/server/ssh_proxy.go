@@ -0,0 +1,250 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/theshovonaha/mini-pam/internal/cmdpolicy"
+	"github.com/theshovonaha/mini-pam/internal/models"
+	"github.com/theshovonaha/mini-pam/internal/recording"
+	"github.com/theshovonaha/mini-pam/internal/sshproxy"
+	"golang.org/x/crypto/ssh"
+)
+
+// sshBackend authenticates and authorizes SSH proxy users against the same
+// accounts, login throttling and credential grants as the API, and records
+// the sessions it lets through, keeping those still running for operators
+// to watch and terminate
+type sshBackend struct {
+	s *Server
+
+	mu   sync.Mutex
+	live map[int]*liveSSHSession // Keyed by session ID
+}
+
+func newSSHBackend(s *Server) *sshBackend {
+	return &sshBackend{s: s, live: map[int]*liveSSHSession{}}
+}
+
+// SSHBackend returns the backend the SSH proxy authenticates users through
+func (s *Server) SSHBackend() sshproxy.Backend {
+	return s.ssh
+}
+
+// Authenticate verifies an SSH login password, sharing the API's throttle
+func (b *sshBackend) Authenticate(conn ssh.ConnMetadata, username, password string) (int, bool, error) {
+	userID, needMFA, err := b.s.proxyAuthenticate(sshClient(conn), username, password)
+	if errors.Is(err, errPasswordExpired) {
+		return 0, false, &ssh.BannerError{
+			Err:     errProxyLoginFailed,
+			Message: "Your password has expired and must be changed before connecting.\n",
+		}
+	}
+	return userID, needMFA, err
+}
+
+// VerifyCode checks an SSH user's TOTP code
+func (b *sshBackend) VerifyCode(conn ssh.ConnMetadata, userID int, code string) error {
+	username, _ := sshproxy.ParseLogin(conn.User())
+	return b.s.proxyVerifyCode(sshClient(conn), username, userID, code)
+}
+
+// Authorize resolves a target, given as a credential ID or a unique name, to
+// a password or SSH key credential the user may reveal
+func (b *sshBackend) Authorize(conn ssh.ConnMetadata, userID int, target string) (*models.Credential, error) {
+	return b.s.proxyAuthorize(sshClient(conn), userID, target, "password", "ssh_key")
+}
+
+// Opened records the session and the use of the credential once the target
+// accepted it, starts recording and registers the session as live
+func (b *sshBackend) Opened(conn *sshproxy.Conn) (sshproxy.Tap, error) {
+	s := b.s
+
+	// Command rules are fixed for the life of the session; a policy that
+	// cannot be loaded refuses it rather than leaving it unfiltered
+	policy, err := b.commandPolicy(conn.UserID, conn.Credential.ID)
+	if err != nil {
+		return nil, err
+	}
+	if !policy.Empty() {
+		conn.Filter = &sshCommandFilter{s: s, conn: conn, policy: policy}
+	}
+
+	session := &models.SSHSession{
+		UserID:         conn.UserID,
+		CredentialID:   conn.Credential.ID,
+		Target:         conn.Addr,
+		TargetUsername: conn.Credential.Username,
+		IPAddress:      addrIP(conn.RemoteAddr),
+		ClientVersion:  conn.ClientVersion,
+	}
+	if err := s.models.SSHSessions.Create(session); err != nil {
+		return nil, err
+	}
+	conn.ID = session.ID
+
+	access := &models.CredentialAccess{
+		UserID:       conn.UserID,
+		CredentialID: conn.Credential.ID,
+		IPAddress:    session.IPAddress,
+		UserAgent:    conn.ClientVersion,
+		Reason:       fmt.Sprintf("SSH session %d to %s", session.ID, conn.Addr),
+	}
+	if err := s.recordCredentialAccess(access); err != nil {
+		s.logger.Printf("Error logging credential access: %v", err)
+	}
+
+	// Create an audit log entry
+	s.record(&models.AuditLog{
+		UserID:     conn.UserID,
+		Action:     "ssh_connect",
+		Resource:   "credential",
+		ResourceID: conn.Credential.ID,
+		Details:    fmt.Sprintf("SSH session %d opened to %s as %s", session.ID, conn.Addr, conn.Credential.Username),
+		IPAddress:  session.IPAddress,
+		UserAgent:  conn.ClientVersion,
+	})
+
+	var live *liveSSHSession
+	if s.config.Recordings != nil {
+		recorder := recording.NewRecorder(conn.Credential.Username+"@"+conn.Addr, s.config.RecordInput, 0)
+		live = newLiveSSHSession(conn, recorder, s.config.RecordInput)
+	} else {
+		live = newLiveSSHSession(conn, nil, false)
+	}
+	b.mu.Lock()
+	b.live[session.ID] = live
+	b.mu.Unlock()
+	return live, nil
+}
+
+// Closed stores the session's recording and records its end
+func (b *sshBackend) Closed(conn *sshproxy.Conn) {
+	s := b.s
+
+	b.mu.Lock()
+	live := b.live[conn.ID]
+	delete(b.live, conn.ID)
+	b.mu.Unlock()
+	live.end()
+
+	session := &models.SSHSession{ID: conn.ID}
+	if live.recorder != nil {
+		b.saveRecording(session, live.recorder)
+	}
+	if err := s.models.SSHSessions.Finish(session); err != nil {
+		s.logger.Printf("Error finishing SSH session %d: %v", conn.ID, err)
+	}
+
+	details := fmt.Sprintf("SSH session %d to %s closed after %s", conn.ID, conn.Addr, conn.EndedAt.Sub(conn.StartedAt).Round(time.Second))
+	if terminatedBy, reason := live.termination(); terminatedBy != 0 {
+		details += fmt.Sprintf(", terminated by user %d", terminatedBy)
+		if reason != "" {
+			details += ": " + reason
+		}
+	} else if conn.Err != nil {
+		details += ": " + conn.Err.Error()
+	}
+
+	// Create an audit log entry
+	s.record(&models.AuditLog{
+		UserID:     conn.UserID,
+		Action:     "ssh_disconnect",
+		Resource:   "credential",
+		ResourceID: conn.Credential.ID,
+		Details:    details,
+		IPAddress:  addrIP(conn.RemoteAddr),
+		UserAgent:  conn.ClientVersion,
+	})
+}
+
+// saveRecording encrypts and stores a finished recording, filling in the
+// session's recording details. The output text is kept for search even if
+// the recording itself could not be stored.
+func (b *sshBackend) saveRecording(session *models.SSHSession, recorder *recording.Recorder) {
+	s := b.s
+
+	finished, err := recorder.Finish()
+	if err != nil {
+		s.logger.Printf("Error finishing recording of SSH session %d: %v", session.ID, err)
+		return
+	}
+	session.OutputText = finished.Text
+	session.InputRecorded = finished.InputRecorded
+	session.Truncated = finished.Truncated
+
+	key, sum, err := s.config.Recordings.Save(session.ID, finished.Cast)
+	if err != nil {
+		s.logger.Printf("Error storing recording of SSH session %d: %v", session.ID, err)
+		return
+	}
+	session.RecordingKey = key
+	session.RecordingSHA256 = sum
+	session.RecordingSize = int64(len(finished.Cast))
+}
+
+// commandPolicy compiles the command rules applying to a user's session on
+// a credential
+func (b *sshBackend) commandPolicy(userID, credentialID int) (*cmdpolicy.Policy, error) {
+	roles, err := b.s.models.Roles.GetUserRoles(userID)
+	if err != nil {
+		return nil, err
+	}
+	roleIDs := make([]int, len(roles))
+	for i, role := range roles {
+		roleIDs[i] = role.ID
+	}
+
+	rules, err := b.s.models.CommandRules.ListApplicable(roleIDs, credentialID)
+	if err != nil {
+		return nil, err
+	}
+	return cmdpolicy.Compile(rules)
+}
+
+// sshCommandFilter checks the commands of a session against its command
+// rules, recording every rule hit in the audit log
+type sshCommandFilter struct {
+	s      *Server
+	conn   *sshproxy.Conn
+	policy *cmdpolicy.Policy
+}
+
+// Check implements sshproxy.Filter
+func (f *sshCommandFilter) Check(command string) error {
+	decision := f.policy.Evaluate(command)
+	command = cmdpolicy.Normalize(command)
+
+	for _, rule := range decision.Flagged {
+		f.record("command_flagged", fmt.Sprintf("Command %q in SSH session %d to %s matched rule %d (%s)",
+			command, f.conn.ID, f.conn.Addr, rule.ID, rule.Name))
+	}
+	if decision.Allowed {
+		return nil
+	}
+
+	if decision.Rule != nil {
+		f.record("command_blocked", fmt.Sprintf("Command %q in SSH session %d to %s blocked by rule %d (%s)",
+			command, f.conn.ID, f.conn.Addr, decision.Rule.ID, decision.Rule.Name))
+		return fmt.Errorf("command blocked by policy rule %q", decision.Rule.Name)
+	}
+	f.record("command_blocked", fmt.Sprintf("Command %q in SSH session %d to %s blocked: not on the allow list",
+		command, f.conn.ID, f.conn.Addr))
+	return errors.New("command not permitted by policy")
+}
+
+// record creates the audit entry of a rule hit
+func (f *sshCommandFilter) record(action, details string) {
+	// Create an audit log entry
+	f.s.record(&models.AuditLog{
+		UserID:     f.conn.UserID,
+		Action:     action,
+		Resource:   "ssh_session",
+		ResourceID: f.conn.ID,
+		Details:    details,
+		IPAddress:  addrIP(f.conn.RemoteAddr),
+		UserAgent:  f.conn.ClientVersion,
+	})
+}
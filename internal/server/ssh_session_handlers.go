@@ -0,0 +1,314 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/theshovonaha/mini-pam/internal/models"
+	"github.com/theshovonaha/mini-pam/internal/recording"
+	"github.com/theshovonaha/mini-pam/internal/websocket"
+)
+
+// handleListSSHSessions returns a handler listing SSH proxy sessions, optionally
+// filtered by user, credential or a search of their output. With active=true
+// it lists the sessions the proxy is relaying right now instead.
+func (s *Server) handleListSSHSessions() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		filter := models.SSHSessionFilter{Text: query.Get("q")}
+		for name, dst := range map[string]*int{
+			"user_id":       &filter.UserID,
+			"credential_id": &filter.CredentialID,
+		} {
+			if value := query.Get(name); value != "" {
+				id, err := strconv.Atoi(value)
+				if err != nil || id <= 0 {
+					s.respondError(w, http.StatusBadRequest, name+" must be a positive integer")
+					return
+				}
+				*dst = id
+			}
+		}
+
+		if active, _ := strconv.ParseBool(query.Get("active")); active {
+			// Output is only indexed once a session ends
+			if filter.Text != "" {
+				s.respondError(w, http.StatusBadRequest, "Active sessions cannot be searched")
+				return
+			}
+			sessions := []*models.SSHSession{}
+			for _, live := range s.ssh.liveSessions() {
+				session := live.model()
+				if (filter.UserID == 0 || session.UserID == filter.UserID) &&
+					(filter.CredentialID == 0 || session.CredentialID == filter.CredentialID) {
+					sessions = append(sessions, session)
+				}
+			}
+			s.respondJSON(w, http.StatusOK, sessions)
+			return
+		}
+
+		// Parse query parameters for pagination
+		page := 1
+		pageSize := 20
+
+		if pageStr := query.Get("page"); pageStr != "" {
+			if p, err := strconv.Atoi(pageStr); err == nil && p > 0 {
+				page = p
+			}
+		}
+
+		if pageSizeStr := query.Get("page_size"); pageSizeStr != "" {
+			if ps, err := strconv.Atoi(pageSizeStr); err == nil && ps > 0 && ps <= 100 {
+				pageSize = ps
+			}
+		}
+
+		sessions, err := s.models.SSHSessions.List(filter, page, pageSize)
+		if err != nil {
+			s.logger.Printf("Error listing SSH sessions: %v", err)
+			s.respondError(w, http.StatusInternalServerError, "Failed to list sessions")
+			return
+		}
+
+		s.respondJSON(w, http.StatusOK, sessions)
+	}
+}
+
+// loadSSHSession resolves the {id} path variable, responding on failure
+func (s *Server) loadSSHSession(w http.ResponseWriter, r *http.Request) *models.SSHSession {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		s.respondError(w, http.StatusBadRequest, "Invalid session ID")
+		return nil
+	}
+
+	session, err := s.models.SSHSessions.GetByID(id)
+	if err != nil {
+		if errors.Is(err, models.ErrRecordNotFound) {
+			s.respondError(w, http.StatusNotFound, "Session not found")
+		} else {
+			s.logger.Printf("Error getting SSH session: %v", err)
+			s.respondError(w, http.StatusInternalServerError, "Failed to get session")
+		}
+		return nil
+	}
+	session.Active = s.ssh.liveSession(id) != nil
+	return session
+}
+
+// loadLiveSSHSession resolves the {id} path variable to a session the proxy
+// is still relaying, responding on failure
+func (s *Server) loadLiveSSHSession(w http.ResponseWriter, r *http.Request) *liveSSHSession {
+	session := s.loadSSHSession(w, r)
+	if session == nil {
+		return nil
+	}
+	live := s.ssh.liveSession(session.ID)
+	if live == nil {
+		s.respondError(w, http.StatusConflict, "Session is not active")
+		return nil
+	}
+	return live
+}
+
+// handleGetSSHSession returns a handler for getting an SSH session by ID
+func (s *Server) handleGetSSHSession() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		session := s.loadSSHSession(w, r)
+		if session == nil {
+			return
+		}
+
+		s.respondJSON(w, http.StatusOK, session)
+	}
+}
+
+// handleGetSSHSessionRecording returns a handler that decrypts a session's
+// recording and serves it as an asciicast v2 file for standard players
+func (s *Server) handleGetSSHSessionRecording() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		session := s.loadSSHSession(w, r)
+		if session == nil {
+			return
+		}
+		if session.RecordingKey == "" {
+			s.respondError(w, http.StatusNotFound, "Session has no recording")
+			return
+		}
+		if s.config.Recordings == nil {
+			s.respondError(w, http.StatusServiceUnavailable, "Session recordings are not configured")
+			return
+		}
+
+		cast, err := s.config.Recordings.Load(session.RecordingKey, session.RecordingSHA256)
+		if err != nil {
+			s.logger.Printf("Error loading recording of SSH session %d: %v", session.ID, err)
+			s.respondError(w, http.StatusInternalServerError, "Failed to load recording")
+			return
+		}
+
+		// Create an audit log entry
+		s.audit(r, &models.AuditLog{
+			Action:     "view_recording",
+			Resource:   "ssh_session",
+			ResourceID: session.ID,
+			Details:    fmt.Sprintf("Recording of SSH session %d to %s viewed", session.ID, session.Target),
+		})
+
+		w.Header().Set("Content-Type", recording.ContentType)
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`inline; filename="session-%d.cast"`, session.ID))
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(http.StatusOK)
+		w.Write(cast)
+	}
+}
+
+// sshWatchMessage is a text message on a watch stream. Terminal output is
+// sent as binary messages in between.
+type sshWatchMessage struct {
+	Type    string `json:"type"` // session, resize, exec, lagged or end
+	ID      int    `json:"id,omitempty"`
+	UserID  int    `json:"user_id,omitempty"`
+	Target  string `json:"target,omitempty"`
+	Term    string `json:"term,omitempty"`
+	Columns int    `json:"columns,omitempty"`
+	Rows    int    `json:"rows,omitempty"`
+	Command string `json:"command,omitempty"`
+}
+
+// handleWatchSSHSession returns a handler streaming a live session's terminal
+// to the caller over a WebSocket. A session message describes the terminal
+// first; output follows as binary messages, ready to be written to a
+// terminal emulator, interleaved with resize and exec messages. The stream
+// ends with an end message when the session closes, or a lagged message if
+// the watcher could not keep up.
+func (s *Server) handleWatchSSHSession() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		live := s.loadLiveSSHSession(w, r)
+		if live == nil {
+			return
+		}
+		watcher, term, columns, rows := live.watch()
+		if watcher == nil {
+			s.respondError(w, http.StatusConflict, "Session is not active")
+			return
+		}
+		defer live.unwatch(watcher)
+
+		conn, err := websocket.Upgrade(w, r)
+		if err != nil {
+			return
+		}
+		defer conn.Close(websocket.CloseNormal, "")
+
+		// Create an audit log entry
+		s.audit(r, &models.AuditLog{
+			Action:     "watch_session",
+			Resource:   "ssh_session",
+			ResourceID: live.conn.ID,
+			Details:    fmt.Sprintf("Live SSH session %d of user %d to %s watched", live.conn.ID, live.conn.UserID, live.conn.Addr),
+		})
+
+		send := func(message sshWatchMessage) error {
+			data, err := json.Marshal(message)
+			if err != nil {
+				return err
+			}
+			return conn.WriteMessage(websocket.OpText, data)
+		}
+		if err := send(sshWatchMessage{
+			Type:    "session",
+			ID:      live.conn.ID,
+			UserID:  live.conn.UserID,
+			Target:  live.conn.Addr,
+			Term:    term,
+			Columns: columns,
+			Rows:    rows,
+		}); err != nil {
+			return
+		}
+
+		// Watchers only listen; reading notices when they go away
+		gone := make(chan struct{})
+		go func() {
+			defer close(gone)
+			for {
+				if _, _, err := conn.ReadMessage(); err != nil {
+					return
+				}
+			}
+		}()
+
+		for {
+			select {
+			case <-gone:
+				return
+			case event, ok := <-watcher.events:
+				if !ok {
+					if watcher.lagged {
+						send(sshWatchMessage{Type: "lagged"})
+						conn.Close(websocket.ClosePolicyViolation, "watcher fell behind")
+					} else {
+						send(sshWatchMessage{Type: "end"})
+					}
+					return
+				}
+
+				switch {
+				case event.Output != nil:
+					err = conn.WriteMessage(websocket.OpBinary, event.Output)
+				case event.Exec != "":
+					err = send(sshWatchMessage{Type: "exec", Command: event.Exec})
+				default:
+					err = send(sshWatchMessage{Type: "resize", Columns: event.Columns, Rows: event.Rows})
+				}
+				if err != nil {
+					return
+				}
+			}
+		}
+	}
+}
+
+// handleTerminateSSHSession returns a handler that disconnects a live session
+func (s *Server) handleTerminateSSHSession() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Reason string `json:"reason"`
+		}
+		if r.ContentLength != 0 {
+			if err := s.readJSON(w, r, &req); err != nil {
+				s.respondError(w, http.StatusBadRequest, "Invalid request body")
+				return
+			}
+		}
+
+		live := s.loadLiveSSHSession(w, r)
+		if live == nil {
+			return
+		}
+
+		principal := s.contextGetPrincipal(r)
+		live.terminate(principal.UserID, req.Reason)
+
+		details := fmt.Sprintf("Live SSH session %d of user %d to %s terminated", live.conn.ID, live.conn.UserID, live.conn.Addr)
+		if req.Reason != "" {
+			details += ": " + req.Reason
+		}
+
+		// Create an audit log entry
+		s.audit(r, &models.AuditLog{
+			Action:     "terminate_session",
+			Resource:   "ssh_session",
+			ResourceID: live.conn.ID,
+			Details:    details,
+		})
+
+		s.respondJSON(w, http.StatusOK, map[string]string{"message": "Session terminated"})
+	}
+}
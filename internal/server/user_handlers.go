@@ -8,7 +8,6 @@ import (
 
 	"github.com/gorilla/mux"
 	"github.com/theshovonaha/mini-pam/internal/models"
-	"golang.org/x/crypto/bcrypt"
 )
 
 // UserRequest represents the request body for creating or updating a user
@@ -69,23 +68,29 @@ func (s *Server) handleCreateUser() http.HandlerFunc {
 			return
 		}
 
+		// Create the user model
+		user := &models.User{
+			Username:  req.Username,
+			Email:     req.Email,
+			FirstName: req.FirstName,
+			LastName:  req.LastName,
+			Active:    req.Active,
+		}
+
+		// Enforce the password policy
+		if err := s.checkNewPassword(user, req.Password); err != nil {
+			s.respondPasswordError(w, err)
+			return
+		}
+
 		// Hash the password
-		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), 12)
+		hashedPassword, err := hashPassword(req.Password)
 		if err != nil {
 			s.logger.Printf("Error hashing password: %v", err)
 			s.respondError(w, http.StatusInternalServerError, "Failed to create user")
 			return
 		}
-
-		// Create the user model
-		user := &models.User{
-			Username:       req.Username,
-			Email:          req.Email,
-			HashedPassword: string(hashedPassword),
-			FirstName:      req.FirstName,
-			LastName:       req.LastName,
-			Active:         req.Active,
-		}
+		user.HashedPassword = hashedPassword
 
 		// Save the user to the database
 		err = s.models.Users.Create(user)
@@ -95,19 +100,17 @@ func (s *Server) handleCreateUser() http.HandlerFunc {
 			return
 		}
 
+		if err := s.recordPasswordHistory(user.ID, hashedPassword); err != nil {
+			s.logger.Printf("Error recording password history: %v", err)
+		}
+
 		// Create an audit log entry
-		auditLog := &models.AuditLog{
-			UserID:     user.ID,
+		s.auditChange(r, &models.AuditLog{
 			Action:     "create",
 			Resource:   "user",
 			ResourceID: user.ID,
-			IPAddress:  r.RemoteAddr,
-			UserAgent:  r.UserAgent(),
 			Details:    "User created",
-		}
-		if err := s.models.AuditLogs.Create(auditLog); err != nil {
-			s.logger.Printf("Error creating audit log: %v", err)
-		}
+		}, nil, user)
 
 		// Return the created user
 		s.respondJSON(w, http.StatusCreated, user)
@@ -173,12 +176,21 @@ func (s *Server) handleUpdateUser() http.HandlerFunc {
 		}
 
 		// Update the user fields
+		before := *user
 		user.Username = req.Username
 		user.Email = req.Email
 		user.FirstName = req.FirstName
 		user.LastName = req.LastName
 		user.Active = req.Active
 
+		// Enforce the password policy before changing anything
+		if req.Password != "" {
+			if err := s.checkNewPassword(user, req.Password); err != nil {
+				s.respondPasswordError(w, err)
+				return
+			}
+		}
+
 		// Update the user in the database
 		err = s.models.Users.Update(user)
 		if err != nil {
@@ -189,14 +201,7 @@ func (s *Server) handleUpdateUser() http.HandlerFunc {
 
 		// If a password was provided, update it
 		if req.Password != "" {
-			hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), 12)
-			if err != nil {
-				s.logger.Printf("Error hashing password: %v", err)
-				s.respondError(w, http.StatusInternalServerError, "Failed to update password")
-				return
-			}
-
-			err = s.models.Users.UpdatePassword(user.ID, string(hashedPassword))
+			err = s.setPassword(user.ID, req.Password)
 			if err != nil {
 				s.logger.Printf("Error updating password: %v", err)
 				s.respondError(w, http.StatusInternalServerError, "Failed to update password")
@@ -204,19 +209,22 @@ func (s *Server) handleUpdateUser() http.HandlerFunc {
 			}
 		}
 
+		// Existing tokens of a deactivated user must stop working immediately
+		if before.Active && !user.Active {
+			s.revokeSessions(user.ID)
+		}
+
 		// Create an audit log entry
-		auditLog := &models.AuditLog{
-			UserID:     user.ID,
+		details := "User updated"
+		if req.Password != "" {
+			details = "User updated; password changed"
+		}
+		s.auditChange(r, &models.AuditLog{
 			Action:     "update",
 			Resource:   "user",
 			ResourceID: user.ID,
-			IPAddress:  r.RemoteAddr,
-			UserAgent:  r.UserAgent(),
-			Details:    "User updated",
-		}
-		if err := s.models.AuditLogs.Create(auditLog); err != nil {
-			s.logger.Printf("Error creating audit log: %v", err)
-		}
+			Details:    details,
+		}, &before, user)
 
 		// Return the updated user
 		s.respondJSON(w, http.StatusOK, user)
@@ -234,6 +242,18 @@ func (s *Server) handleDeleteUser() http.HandlerFunc {
 			return
 		}
 
+		// Load the user so the audit entry records what was deleted
+		user, err := s.models.Users.GetByID(id)
+		if err != nil {
+			if errors.Is(err, models.ErrRecordNotFound) {
+				s.respondError(w, http.StatusNotFound, "User not found")
+			} else {
+				s.logger.Printf("Error getting user: %v", err)
+				s.respondError(w, http.StatusInternalServerError, "Failed to delete user")
+			}
+			return
+		}
+
 		// Delete the user from the database
 		err = s.models.Users.Delete(id)
 		if err != nil {
@@ -247,19 +267,62 @@ func (s *Server) handleDeleteUser() http.HandlerFunc {
 		}
 
 		// Create an audit log entry
-		auditLog := &models.AuditLog{
+		s.auditChange(r, &models.AuditLog{
 			Action:     "delete",
 			Resource:   "user",
 			ResourceID: id,
-			IPAddress:  r.RemoteAddr,
-			UserAgent:  r.UserAgent(),
 			Details:    "User deleted",
-		}
-		if err := s.models.AuditLogs.Create(auditLog); err != nil {
-			s.logger.Printf("Error creating audit log: %v", err)
-		}
+		}, user, nil)
 
 		// Return a success message
 		s.respondJSON(w, http.StatusOK, map[string]string{"message": "User deleted successfully"})
 	}
 }
+
+// handleUnlockUser returns a handler that clears a user's failed login counter and lockout
+func (s *Server) handleUnlockUser() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Parse the user ID from the URL
+		vars := mux.Vars(r)
+		id, err := strconv.Atoi(vars["id"])
+		if err != nil {
+			s.respondError(w, http.StatusBadRequest, "Invalid user ID")
+			return
+		}
+
+		user, err := s.models.Users.GetByID(id)
+		if err != nil {
+			if errors.Is(err, models.ErrRecordNotFound) {
+				s.respondError(w, http.StatusNotFound, "User not found")
+			} else {
+				s.logger.Printf("Error getting user: %v", err)
+				s.respondError(w, http.StatusInternalServerError, "Failed to unlock user")
+			}
+			return
+		}
+
+		if err := s.models.LoginAttempts.Reset(models.LoginScopeUser, loginSubject(user.Username)); err != nil {
+			s.logger.Printf("Error resetting login attempts: %v", err)
+			s.respondError(w, http.StatusInternalServerError, "Failed to unlock user")
+			return
+		}
+
+		// Create an audit log entry
+		s.audit(r, &models.AuditLog{
+			Action:     "unlock",
+			Resource:   "user",
+			ResourceID: user.ID,
+			Details:    "User unlocked: " + user.Username,
+		})
+
+		s.respondJSON(w, http.StatusOK, map[string]string{"message": "User unlocked successfully"})
+	}
+}
+
+// revokeSessions stops every bearer token of a user from working, so a
+// deactivation takes effect at once rather than when the tokens expire
+func (s *Server) revokeSessions(userID int) {
+	if err := s.models.Sessions.RevokeAllForUser(userID); err != nil {
+		s.logger.Printf("Error revoking sessions of user %d: %v", userID, err)
+	}
+}
@@ -0,0 +1,539 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/theshovonaha/mini-pam/internal/auth"
+	"github.com/theshovonaha/mini-pam/internal/models"
+	"github.com/theshovonaha/mini-pam/internal/webproxy"
+)
+
+// webSessionCookie carries a browser's web session token, set host-only on
+// the origin of the one console it opens
+const webSessionCookie = "pam_web_session"
+
+// WebSessionResponse is returned when a web session is created. The launch
+// URL works once and sets the session's cookie in the browser that opens it.
+type WebSessionResponse struct {
+	Session   *models.WebSession `json:"session"`
+	LaunchURL string             `json:"launch_url"`
+}
+
+// webInjection returns how a credential logs into its console: passwords
+// as basic auth, API keys in the header named by the credential's username
+// or as a bearer token when it has none
+func webInjection(credential *models.Credential) (webproxy.Injection, bool) {
+	switch credential.Type {
+	case "password":
+		return webproxy.BasicAuth(credential.Username, credential.Secret), true
+	case "api_key":
+		return webproxy.APIKey(credential.Username, credential.Secret), true
+	}
+	return webproxy.Injection{}, false
+}
+
+// webConsoleHost returns the host a credential's console is served on
+func (s *Server) webConsoleHost(credentialID int) string {
+	return fmt.Sprintf("%d.%s", credentialID, s.config.WebProxyDomain)
+}
+
+// webConsoleID returns the credential whose console is served on host, if
+// host is a console's. Ports are ignored.
+func (s *Server) webConsoleID(host string) (int, bool) {
+	if s.config.WebProxyDomain == "" {
+		return 0, false
+	}
+	label, ok := strings.CutSuffix(strings.ToLower(hostname(host)), "."+strings.ToLower(hostname(s.config.WebProxyDomain)))
+	if !ok {
+		return 0, false
+	}
+	id, err := strconv.Atoi(label)
+	if err != nil || id <= 0 || strconv.Itoa(id) != label {
+		return 0, false
+	}
+	return id, true
+}
+
+// hostname strips any port from a host
+func hostname(host string) string {
+	if name, _, err := net.SplitHostPort(host); err == nil {
+		return name
+	}
+	return host
+}
+
+// webRequestAllowed applies a resource isolation policy to a console's
+// requests: requests from any other origin, sibling consoles and the API's
+// included, are refused unless they are plain top-level navigations, such
+// as following the launch link
+func webRequestAllowed(r *http.Request) bool {
+	switch r.Header.Get("Sec-Fetch-Site") {
+	case "", "same-origin", "none":
+	default:
+		if (r.Method != http.MethodGet && r.Method != http.MethodHead) || r.Header.Get("Sec-Fetch-Mode") != "navigate" {
+			return false
+		}
+		switch r.Header.Get("Sec-Fetch-Dest") {
+		case "iframe", "frame", "object", "embed":
+			return false
+		}
+	}
+
+	// Browsers without fetch metadata still send the origin of scripted and form requests
+	if origin := r.Header.Get("Origin"); origin != "" {
+		u, err := url.Parse(origin)
+		if err != nil || !strings.EqualFold(u.Host, r.Host) {
+			return false
+		}
+	}
+	return true
+}
+
+// handleCreateWebSession returns a handler that opens a web session to the
+// console of a credential the user may reveal, without revealing it
+func (s *Server) handleCreateWebSession() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		principal := s.contextGetPrincipal(r)
+		if principal.UserID == 0 {
+			s.respondError(w, http.StatusForbidden, "Web sessions are only available to users")
+			return
+		}
+
+		// Parse the credential ID from the URL
+		id, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			s.respondError(w, http.StatusBadRequest, "Invalid credential ID")
+			return
+		}
+
+		credential, err := s.models.Credentials.GetByID(id)
+		if err != nil {
+			if errors.Is(err, models.ErrRecordNotFound) {
+				s.respondError(w, http.StatusNotFound, "Credential not found")
+			} else {
+				s.logger.Printf("Error getting credential: %v", err)
+				s.respondError(w, http.StatusInternalServerError, "Failed to get credential")
+			}
+			return
+		}
+
+		allowed, err := s.canAccessCredential(principal, auth.OpCredentialsReveal, credential)
+		if err != nil {
+			s.logger.Printf("Error checking credential access: %v", err)
+			s.respondError(w, http.StatusInternalServerError, "Failed to get credential")
+			return
+		}
+		if !allowed {
+			s.respondError(w, http.StatusForbidden, "Access to this credential is not permitted")
+			return
+		}
+
+		if _, ok := webInjection(credential); !ok {
+			s.respondError(w, http.StatusBadRequest, "A "+credential.Type+" credential cannot be used for web consoles")
+			return
+		}
+		target, err := webproxy.ParseURL(credential.System)
+		if err != nil {
+			s.respondError(w, http.StatusBadRequest, "Credential system is not a web console URL: "+err.Error())
+			return
+		}
+
+		launch, launchHash, err := auth.GenerateToken()
+		if err != nil {
+			s.logger.Printf("Error generating launch code: %v", err)
+			s.respondError(w, http.StatusInternalServerError, "Failed to create web session")
+			return
+		}
+		session := &models.WebSession{
+			UserID:       principal.UserID,
+			CredentialID: credential.ID,
+			Target:       target.String(),
+			IPAddress:    clientIP(r),
+			UserAgent:    r.UserAgent(),
+			LaunchHash:   launchHash,
+			ExpiresAt:    time.Now().Add(s.config.WebProxyTTL),
+		}
+		if err := s.models.WebSessions.Create(session); err != nil {
+			s.logger.Printf("Error creating web session: %v", err)
+			s.respondError(w, http.StatusInternalServerError, "Failed to create web session")
+			return
+		}
+
+		access := &models.CredentialAccess{
+			UserID:       principal.UserID,
+			CredentialID: credential.ID,
+			IPAddress:    session.IPAddress,
+			UserAgent:    session.UserAgent,
+			Reason:       fmt.Sprintf("Web session %d to %s", session.ID, session.Target),
+		}
+		if err := s.recordCredentialAccess(access); err != nil {
+			s.logger.Printf("Error logging credential access: %v", err)
+		}
+
+		// Create an audit log entry
+		s.audit(r, &models.AuditLog{
+			Action:     "web_connect",
+			Resource:   "credential",
+			ResourceID: credential.ID,
+			Details:    fmt.Sprintf("Web session %d opened to %s", session.ID, session.Target),
+		})
+
+		scheme := "http"
+		if r.TLS != nil {
+			scheme = "https"
+		}
+		s.respondJSON(w, http.StatusCreated, WebSessionResponse{
+			Session:   session,
+			LaunchURL: scheme + "://" + s.webConsoleHost(credential.ID) + "/?launch=" + launch,
+		})
+	}
+}
+
+// webResponseRecorder counts what the proxy sends the browser, for the
+// request log
+type webResponseRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (w *webResponseRecorder) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *webResponseRecorder) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(p)
+	w.bytes += int64(n)
+	return n, err
+}
+
+// Unwrap lets the reverse proxy flush and hijack the underlying connection
+func (w *webResponseRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// handleWebProxy returns a handler relaying a browser's web session to its
+// console, which is served at the root of a host of its own so that its
+// scripts cannot reach the API or other consoles. Browsers arrive with a
+// one-time launch code, which is exchanged for a cookie; every request after
+// that is checked against the session and the user's grant, and logged.
+func (s *Server) handleWebProxy() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := s.webConsoleID(r.Host)
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		if !webRequestAllowed(r) {
+			http.Error(w, "Cross-origin requests to this console are not permitted", http.StatusForbidden)
+			return
+		}
+
+		// Exchange a launch code for the session cookie and drop it from the URL
+		if launch := r.URL.Query().Get("launch"); launch != "" {
+			s.launchWebSession(w, r, id, launch)
+			return
+		}
+
+		cookie, err := r.Cookie(webSessionCookie)
+		if err != nil {
+			http.Error(w, "No web session, open this console from mini-pam", http.StatusUnauthorized)
+			return
+		}
+		session, err := s.models.WebSessions.GetActiveByTokenHash(auth.HashToken(cookie.Value))
+		if err != nil || session.CredentialID != id {
+			if err != nil && !errors.Is(err, models.ErrRecordNotFound) {
+				s.logger.Printf("Error getting web session: %v", err)
+			}
+			http.Error(w, "Web session expired, open this console from mini-pam again", http.StatusUnauthorized)
+			return
+		}
+
+		// Grants and the user's account are checked on every request, so
+		// revoking either ends the session
+		user, err := s.models.Users.GetByID(session.UserID)
+		if err != nil && !errors.Is(err, models.ErrRecordNotFound) {
+			s.logger.Printf("Error getting user: %v", err)
+			http.Error(w, "Failed to check access", http.StatusInternalServerError)
+			return
+		}
+		credential, err := s.models.Credentials.GetByID(id)
+		if err != nil {
+			if !errors.Is(err, models.ErrRecordNotFound) {
+				s.logger.Printf("Error getting credential: %v", err)
+			}
+			http.Error(w, "Credential not available", http.StatusBadGateway)
+			return
+		}
+		allowed, err := s.canAccessCredential(&Principal{UserID: session.UserID}, auth.OpCredentialsReveal, credential)
+		if err != nil {
+			s.logger.Printf("Error checking credential access: %v", err)
+			http.Error(w, "Failed to check access", http.StatusInternalServerError)
+			return
+		}
+		inject, ok := webInjection(credential)
+		target, err := webproxy.ParseURL(credential.System)
+		active := user != nil && user.Active
+		if !active || !allowed || !ok || err != nil {
+			if err := s.models.WebSessions.End(session.ID, 0); err != nil {
+				s.logger.Printf("Error ending web session %d: %v", session.ID, err)
+			}
+			reason := "access to the credential was withdrawn"
+			if !active {
+				reason = "the user was deactivated"
+			}
+			s.record(&models.AuditLog{
+				UserID:     session.UserID,
+				Action:     "web_disconnect",
+				Resource:   "credential",
+				ResourceID: credential.ID,
+				Details:    fmt.Sprintf("Web session %d to %s ended: %s", session.ID, session.Target, reason),
+				IPAddress:  clientIP(r),
+				UserAgent:  r.UserAgent(),
+			})
+			http.Error(w, "Access to this console is no longer permitted", http.StatusForbidden)
+			return
+		}
+
+		handler := webproxy.New(webproxy.Target{
+			URL:       target,
+			Inject:    inject,
+			Secrets:   []string{credential.Secret},
+			Cookie:    webSessionCookie,
+			Transport: s.webTransport,
+			ErrorLog:  s.logger,
+		})
+		recorder := &webResponseRecorder{ResponseWriter: w}
+		start := time.Now()
+		handler.ServeHTTP(recorder, r)
+
+		path := r.URL.Path
+		if r.URL.RawQuery != "" {
+			path += "?" + r.URL.RawQuery
+		}
+		if recorder.status == 0 {
+			recorder.status = http.StatusSwitchingProtocols // Hijacked for an upgrade
+		}
+		err = s.models.WebSessions.AddRequest(&models.WebRequest{
+			SessionID:  session.ID,
+			Method:     r.Method,
+			Path:       path,
+			Status:     recorder.status,
+			Bytes:      recorder.bytes,
+			DurationMS: int(time.Since(start).Milliseconds()),
+		})
+		if err != nil {
+			s.logger.Printf("Error logging request of web session %d: %v", session.ID, err)
+		}
+	}
+}
+
+// launchWebSession sets a launched session's cookie and sends the browser
+// on to the page it asked for
+func (s *Server) launchWebSession(w http.ResponseWriter, r *http.Request, credentialID int, launch string) {
+	token, tokenHash, err := auth.GenerateToken()
+	if err != nil {
+		s.logger.Printf("Error generating web session token: %v", err)
+		http.Error(w, "Failed to start web session", http.StatusInternalServerError)
+		return
+	}
+	session, err := s.models.WebSessions.Launch(auth.HashToken(launch), tokenHash)
+	if err != nil || session.CredentialID != credentialID {
+		if err != nil && !errors.Is(err, models.ErrRecordNotFound) {
+			s.logger.Printf("Error launching web session: %v", err)
+		}
+		http.Error(w, "Invalid or used launch link, open this console from mini-pam again", http.StatusUnauthorized)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     webSessionCookie,
+		Value:    token,
+		Path:     "/",
+		Expires:  session.ExpiresAt,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	target := *r.URL
+	query := target.Query()
+	query.Del("launch")
+	target.RawQuery = query.Encode()
+	http.Redirect(w, r, target.String(), http.StatusSeeOther)
+}
+
+// handleListWebSessions returns a handler listing web console sessions,
+// optionally filtered by user or credential
+func (s *Server) handleListWebSessions() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		var filter models.WebSessionFilter
+		for name, dst := range map[string]*int{
+			"user_id":       &filter.UserID,
+			"credential_id": &filter.CredentialID,
+		} {
+			if value := query.Get(name); value != "" {
+				id, err := strconv.Atoi(value)
+				if err != nil || id <= 0 {
+					s.respondError(w, http.StatusBadRequest, name+" must be a positive integer")
+					return
+				}
+				*dst = id
+			}
+		}
+
+		// Parse query parameters for pagination
+		page := 1
+		pageSize := 20
+
+		if pageStr := query.Get("page"); pageStr != "" {
+			if p, err := strconv.Atoi(pageStr); err == nil && p > 0 {
+				page = p
+			}
+		}
+
+		if pageSizeStr := query.Get("page_size"); pageSizeStr != "" {
+			if ps, err := strconv.Atoi(pageSizeStr); err == nil && ps > 0 && ps <= 100 {
+				pageSize = ps
+			}
+		}
+
+		sessions, err := s.models.WebSessions.List(filter, page, pageSize)
+		if err != nil {
+			s.logger.Printf("Error listing web sessions: %v", err)
+			s.respondError(w, http.StatusInternalServerError, "Failed to list sessions")
+			return
+		}
+
+		s.respondJSON(w, http.StatusOK, sessions)
+	}
+}
+
+// loadWebSession resolves the {id} path variable, responding on failure
+func (s *Server) loadWebSession(w http.ResponseWriter, r *http.Request) *models.WebSession {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		s.respondError(w, http.StatusBadRequest, "Invalid session ID")
+		return nil
+	}
+
+	session, err := s.models.WebSessions.GetByID(id)
+	if err != nil {
+		if errors.Is(err, models.ErrRecordNotFound) {
+			s.respondError(w, http.StatusNotFound, "Session not found")
+		} else {
+			s.logger.Printf("Error getting web session: %v", err)
+			s.respondError(w, http.StatusInternalServerError, "Failed to get session")
+		}
+		return nil
+	}
+	return session
+}
+
+// handleGetWebSession returns a handler for getting a web session by ID
+func (s *Server) handleGetWebSession() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		session := s.loadWebSession(w, r)
+		if session == nil {
+			return
+		}
+
+		s.respondJSON(w, http.StatusOK, session)
+	}
+}
+
+// handleListWebSessionRequests returns a handler listing the requests
+// relayed over a web session, oldest first
+func (s *Server) handleListWebSessionRequests() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		session := s.loadWebSession(w, r)
+		if session == nil {
+			return
+		}
+
+		// Parse query parameters for pagination
+		page := 1
+		pageSize := 100
+
+		if pageStr := r.URL.Query().Get("page"); pageStr != "" {
+			if p, err := strconv.Atoi(pageStr); err == nil && p > 0 {
+				page = p
+			}
+		}
+
+		if pageSizeStr := r.URL.Query().Get("page_size"); pageSizeStr != "" {
+			if ps, err := strconv.Atoi(pageSizeStr); err == nil && ps > 0 && ps <= 1000 {
+				pageSize = ps
+			}
+		}
+
+		requests, err := s.models.WebSessions.ListRequests(session.ID, page, pageSize)
+		if err != nil {
+			s.logger.Printf("Error listing requests of web session %d: %v", session.ID, err)
+			s.respondError(w, http.StatusInternalServerError, "Failed to list requests")
+			return
+		}
+
+		s.respondJSON(w, http.StatusOK, requests)
+	}
+}
+
+// handleEndWebSession returns a handler that ends a web session; users may
+// end their own, administrators anyone's
+func (s *Server) handleEndWebSession() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		principal := s.contextGetPrincipal(r)
+		session := s.loadWebSession(w, r)
+		if session == nil {
+			return
+		}
+
+		if session.UserID != principal.UserID {
+			admin, err := s.isAdmin(principal.UserID)
+			if err != nil {
+				s.logger.Printf("Error checking admin role: %v", err)
+				s.respondError(w, http.StatusInternalServerError, "Failed to check permissions")
+				return
+			}
+			if !admin {
+				s.respondError(w, http.StatusForbidden, "Only the session's user or an administrator can end it")
+				return
+			}
+		}
+
+		if err := s.models.WebSessions.End(session.ID, principal.UserID); err != nil {
+			if errors.Is(err, models.ErrRecordNotFound) {
+				s.respondError(w, http.StatusConflict, "Session has already ended")
+			} else {
+				s.logger.Printf("Error ending web session %d: %v", session.ID, err)
+				s.respondError(w, http.StatusInternalServerError, "Failed to end session")
+			}
+			return
+		}
+
+		// Create an audit log entry
+		s.audit(r, &models.AuditLog{
+			Action:     "web_disconnect",
+			Resource:   "credential",
+			ResourceID: session.CredentialID,
+			Details:    fmt.Sprintf("Web session %d to %s ended after %d requests", session.ID, session.Target, session.RequestCount),
+		})
+
+		s.respondJSON(w, http.StatusOK, map[string]string{"message": "Web session ended"})
+	}
+}
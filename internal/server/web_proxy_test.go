@@ -0,0 +1,76 @@
+package server
+
+import (
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/theshovonaha/mini-pam/internal/database"
+	"github.com/theshovonaha/mini-pam/internal/models"
+)
+
+func TestWebProxyRouting(t *testing.T) {
+	logger := log.New(os.Stdout, "", log.Ldate|log.Ltime)
+	srv := NewServer(Config{Environment: "test", WebProxyDomain: "consoles.example.com:8443"}, logger, &database.Connection{})
+	handler := srv.Routes()
+
+	tests := []struct {
+		method, host, path string
+		header             map[string]string
+		status             int
+	}{
+		{"GET", "7.consoles.example.com:8443", "/dashboard", nil, http.StatusUnauthorized}, // No session cookie
+		{"GET", "7.Consoles.Example.com", "/", nil, http.StatusUnauthorized},
+		{"GET", "07.consoles.example.com:8443", "/", nil, http.StatusNotFound}, // Not a console, so the API's
+		{"GET", "pam.example.com", "/proxy/7/", nil, http.StatusNotFound},
+
+		// Other origins, sibling consoles included, may only navigate to a console
+		{"GET", "7.consoles.example.com:8443", "/", map[string]string{
+			"Sec-Fetch-Site": "cross-site", "Sec-Fetch-Mode": "navigate", "Sec-Fetch-Dest": "document",
+		}, http.StatusUnauthorized},
+		{"GET", "7.consoles.example.com:8443", "/api/users", map[string]string{
+			"Sec-Fetch-Site": "same-site", "Sec-Fetch-Mode": "cors", "Sec-Fetch-Dest": "empty",
+		}, http.StatusForbidden},
+		{"GET", "7.consoles.example.com:8443", "/", map[string]string{
+			"Sec-Fetch-Site": "cross-site", "Sec-Fetch-Mode": "navigate", "Sec-Fetch-Dest": "iframe",
+		}, http.StatusForbidden},
+		{"POST", "7.consoles.example.com:8443", "/settings", map[string]string{
+			"Origin": "https://8.consoles.example.com:8443",
+		}, http.StatusForbidden},
+		{"POST", "7.consoles.example.com:8443", "/settings", map[string]string{
+			"Origin": "https://7.consoles.example.com:8443", "Sec-Fetch-Site": "same-origin",
+		}, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.method, tt.path, nil)
+		r.Host = tt.host
+		for name, value := range tt.header {
+			r.Header.Set(name, value)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, r)
+		if rr.Code != tt.status {
+			t.Errorf("%s %s%s %v = %d, want %d", tt.method, tt.host, tt.path, tt.header, rr.Code, tt.status)
+		}
+		// Consoles answer for their own CORS
+		if origin := rr.Header().Get("Access-Control-Allow-Origin"); origin != "" && tt.status != http.StatusNotFound {
+			t.Errorf("%s %s%s allowed origin %q", tt.method, tt.host, tt.path, origin)
+		}
+	}
+}
+
+func TestWebInjection(t *testing.T) {
+	inject, ok := webInjection(&models.Credential{Type: "password", Username: "admin", Secret: "hunter22"})
+	if !ok || inject.Header != "Authorization" || inject.Value != "Basic YWRtaW46aHVudGVyMjI=" {
+		t.Errorf("password injection %+v, %v", inject, ok)
+	}
+	inject, ok = webInjection(&models.Credential{Type: "api_key", Username: "x-api-key", Secret: "k"})
+	if !ok || inject.Header != "X-Api-Key" || inject.Value != "k" {
+		t.Errorf("api key injection %+v, %v", inject, ok)
+	}
+	if _, ok := webInjection(&models.Credential{Type: "ssh_key"}); ok {
+		t.Error("ssh key accepted for web consoles")
+	}
+}
@@ -0,0 +1,303 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/theshovonaha/mini-pam/internal/models"
+	"github.com/theshovonaha/mini-pam/internal/webhook"
+)
+
+// WebhookRequest represents the request body for creating or updating a webhook subscription
+type WebhookRequest struct {
+	Name       string   `json:"name"`
+	URL        string   `json:"url"`
+	EventTypes []string `json:"event_types"`
+	Active     *bool    `json:"active"`
+}
+
+// WebhookResponse carries a new subscription; the signing secret is shown only once
+type WebhookResponse struct {
+	Secret       string                      `json:"secret"`
+	Subscription *models.WebhookSubscription `json:"subscription"`
+}
+
+// validate checks the request, returning a message for the client if it is invalid
+func (req *WebhookRequest) validate() string {
+	if req.Name == "" {
+		return "Name is required"
+	}
+	u, err := url.Parse(req.URL)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return "URL must be an absolute http or https URL"
+	}
+	if len(req.EventTypes) == 0 {
+		return "At least one event type is required"
+	}
+	for _, eventType := range req.EventTypes {
+		if !webhook.ValidEventType(eventType) {
+			return "Unknown event type: " + eventType
+		}
+	}
+	return ""
+}
+
+// handleListWebhookEventTypes returns a handler listing the event types subscriptions can filter on
+func (s *Server) handleListWebhookEventTypes() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.respondJSON(w, http.StatusOK, webhook.EventTypes())
+	}
+}
+
+// handleListWebhooks returns a handler for listing webhook subscriptions
+func (s *Server) handleListWebhooks() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		subscriptions, err := s.models.Webhooks.ListSubscriptions()
+		if err != nil {
+			s.logger.Printf("Error listing webhooks: %v", err)
+			s.respondError(w, http.StatusInternalServerError, "Failed to list webhooks")
+			return
+		}
+
+		s.respondJSON(w, http.StatusOK, subscriptions)
+	}
+}
+
+// handleCreateWebhook returns a handler for creating a webhook subscription
+func (s *Server) handleCreateWebhook() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Parse the request body
+		var req WebhookRequest
+		if err := s.readJSON(w, r, &req); err != nil {
+			s.respondError(w, http.StatusBadRequest, "Invalid request payload")
+			return
+		}
+		if msg := req.validate(); msg != "" {
+			s.respondError(w, http.StatusBadRequest, msg)
+			return
+		}
+
+		secret, err := webhook.GenerateSecret()
+		if err != nil {
+			s.logger.Printf("Error generating webhook secret: %v", err)
+			s.respondError(w, http.StatusInternalServerError, "Failed to create webhook")
+			return
+		}
+
+		subscription := &models.WebhookSubscription{
+			Name:       req.Name,
+			URL:        req.URL,
+			Secret:     secret,
+			EventTypes: req.EventTypes,
+			Active:     req.Active == nil || *req.Active,
+			CreatedBy:  s.contextGetPrincipal(r).UserID,
+		}
+		if err := s.models.Webhooks.CreateSubscription(subscription); err != nil {
+			s.logger.Printf("Error creating webhook: %v", err)
+			s.respondError(w, http.StatusInternalServerError, "Failed to create webhook")
+			return
+		}
+
+		// Create an audit log entry
+		s.auditChange(r, &models.AuditLog{
+			Action:     "create",
+			Resource:   "webhook",
+			ResourceID: subscription.ID,
+			Details:    "Webhook created: " + subscription.Name,
+		}, nil, subscription)
+
+		s.respondJSON(w, http.StatusCreated, WebhookResponse{Secret: secret, Subscription: subscription})
+	}
+}
+
+// loadWebhook resolves the {id} path variable, responding on failure
+func (s *Server) loadWebhook(w http.ResponseWriter, r *http.Request) *models.WebhookSubscription {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		s.respondError(w, http.StatusBadRequest, "Invalid webhook ID")
+		return nil
+	}
+
+	subscription, err := s.models.Webhooks.GetSubscription(id)
+	if err != nil {
+		if errors.Is(err, models.ErrRecordNotFound) {
+			s.respondError(w, http.StatusNotFound, "Webhook not found")
+		} else {
+			s.logger.Printf("Error getting webhook: %v", err)
+			s.respondError(w, http.StatusInternalServerError, "Failed to get webhook")
+		}
+		return nil
+	}
+	return subscription
+}
+
+// handleGetWebhook returns a handler for getting a webhook subscription by ID
+func (s *Server) handleGetWebhook() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		subscription := s.loadWebhook(w, r)
+		if subscription == nil {
+			return
+		}
+
+		s.respondJSON(w, http.StatusOK, subscription)
+	}
+}
+
+// handleUpdateWebhook returns a handler for changing a subscription's target, filters or state
+func (s *Server) handleUpdateWebhook() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		subscription := s.loadWebhook(w, r)
+		if subscription == nil {
+			return
+		}
+
+		// Parse the request body
+		var req WebhookRequest
+		if err := s.readJSON(w, r, &req); err != nil {
+			s.respondError(w, http.StatusBadRequest, "Invalid request payload")
+			return
+		}
+		if msg := req.validate(); msg != "" {
+			s.respondError(w, http.StatusBadRequest, msg)
+			return
+		}
+
+		before := *subscription
+		subscription.Name = req.Name
+		subscription.URL = req.URL
+		subscription.EventTypes = req.EventTypes
+		if req.Active != nil {
+			subscription.Active = *req.Active
+		}
+		if err := s.models.Webhooks.UpdateSubscription(subscription); err != nil {
+			s.logger.Printf("Error updating webhook: %v", err)
+			s.respondError(w, http.StatusInternalServerError, "Failed to update webhook")
+			return
+		}
+
+		// Create an audit log entry
+		s.auditChange(r, &models.AuditLog{
+			Action:     "update",
+			Resource:   "webhook",
+			ResourceID: subscription.ID,
+			Details:    "Webhook updated: " + subscription.Name,
+		}, &before, subscription)
+
+		s.respondJSON(w, http.StatusOK, subscription)
+	}
+}
+
+// handleDeleteWebhook returns a handler for deleting a subscription and its delivery history
+func (s *Server) handleDeleteWebhook() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		subscription := s.loadWebhook(w, r)
+		if subscription == nil {
+			return
+		}
+
+		if err := s.models.Webhooks.DeleteSubscription(subscription.ID); err != nil {
+			if errors.Is(err, models.ErrRecordNotFound) {
+				s.respondError(w, http.StatusNotFound, "Webhook not found")
+			} else {
+				s.logger.Printf("Error deleting webhook: %v", err)
+				s.respondError(w, http.StatusInternalServerError, "Failed to delete webhook")
+			}
+			return
+		}
+
+		// Create an audit log entry
+		s.auditChange(r, &models.AuditLog{
+			Action:     "delete",
+			Resource:   "webhook",
+			ResourceID: subscription.ID,
+			Details:    "Webhook deleted: " + subscription.Name,
+		}, subscription, nil)
+
+		s.respondJSON(w, http.StatusOK, map[string]string{"message": "Webhook deleted successfully"})
+	}
+}
+
+// handleListWebhookDeliveries returns a handler for a subscription's delivery history,
+// optionally filtered by status
+func (s *Server) handleListWebhookDeliveries() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		subscription := s.loadWebhook(w, r)
+		if subscription == nil {
+			return
+		}
+
+		query := r.URL.Query()
+		status := query.Get("status")
+		switch status {
+		case "", models.WebhookPending, models.WebhookDelivered, models.WebhookFailed:
+		default:
+			s.respondError(w, http.StatusBadRequest, "Invalid status")
+			return
+		}
+		limit := 50
+		if limitStr := query.Get("limit"); limitStr != "" {
+			if l, err := strconv.Atoi(limitStr); err == nil && l > 0 && l <= 500 {
+				limit = l
+			}
+		}
+
+		deliveries, err := s.models.Webhooks.ListDeliveries(subscription.ID, status, limit)
+		if err != nil {
+			s.logger.Printf("Error listing webhook deliveries: %v", err)
+			s.respondError(w, http.StatusInternalServerError, "Failed to list webhook deliveries")
+			return
+		}
+
+		s.respondJSON(w, http.StatusOK, deliveries)
+	}
+}
+
+// handleReplayWebhookDelivery returns a handler that queues a delivery's payload again
+func (s *Server) handleReplayWebhookDelivery() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		subscription := s.loadWebhook(w, r)
+		if subscription == nil {
+			return
+		}
+		deliveryID, err := strconv.Atoi(mux.Vars(r)["deliveryId"])
+		if err != nil {
+			s.respondError(w, http.StatusBadRequest, "Invalid delivery ID")
+			return
+		}
+
+		// The delivery must belong to the subscription named in the path
+		original, err := s.models.Webhooks.GetDelivery(deliveryID)
+		if err == nil && original.SubscriptionID != subscription.ID {
+			err = models.ErrRecordNotFound
+		}
+		if err != nil {
+			if errors.Is(err, models.ErrRecordNotFound) {
+				s.respondError(w, http.StatusNotFound, "Delivery not found")
+			} else {
+				s.logger.Printf("Error getting webhook delivery: %v", err)
+				s.respondError(w, http.StatusInternalServerError, "Failed to replay delivery")
+			}
+			return
+		}
+
+		replay, err := s.models.Webhooks.Replay(original.ID)
+		if err != nil {
+			s.logger.Printf("Error replaying webhook delivery: %v", err)
+			s.respondError(w, http.StatusInternalServerError, "Failed to replay delivery")
+			return
+		}
+
+		// Create an audit log entry
+		s.audit(r, &models.AuditLog{
+			Action:     "replay",
+			Resource:   "webhook",
+			ResourceID: subscription.ID,
+			Details:    "Webhook delivery " + strconv.Itoa(original.ID) + " replayed as " + strconv.Itoa(replay.ID),
+		})
+
+		s.respondJSON(w, http.StatusAccepted, replay)
+	}
+}
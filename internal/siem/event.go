@@ -0,0 +1,107 @@
+// Package siem forwards audit events to security information and event
+// management systems as RFC 5424 syslog or ArcSight CEF messages.
+package siem
+
+import (
+	"time"
+
+	"github.com/theshovonaha/mini-pam/internal/models"
+)
+
+// Event kinds
+const (
+	KindAudit            = "audit"
+	KindCredentialAccess = "credential_access"
+)
+
+// Severity levels shared by both formats
+const (
+	SeverityInfo = iota
+	SeverityWarning
+	SeverityAlert
+)
+
+// alertActions are audit actions reported at alert severity
+var alertActions = map[string]bool{
+	"lockout":       true,
+	"login_blocked": true,
+}
+
+// warningActions are audit actions reported at warning severity
+var warningActions = map[string]bool{
+	"login_failed":   true,
+	"step_up_failed": true,
+	"delete":         true,
+	"revoke":         true,
+	"export":         true,
+}
+
+// Event is an audit record in the shape forwarded to sinks
+type Event struct {
+	Kind             string
+	ID               int
+	Time             time.Time
+	Action           string
+	Resource         string
+	ResourceID       int
+	UserID           int
+	ServiceAccountID int
+	SessionID        int
+	APIKeyID         int
+	IPAddress        string
+	UserAgent        string
+	Message          string
+	Severity         int
+}
+
+// FromAuditLog converts a stored audit log entry
+func FromAuditLog(log *models.AuditLog) *Event {
+	severity := SeverityInfo
+	switch {
+	case alertActions[log.Action]:
+		severity = SeverityAlert
+	case warningActions[log.Action]:
+		severity = SeverityWarning
+	}
+
+	return &Event{
+		Kind:             KindAudit,
+		ID:               log.ID,
+		Time:             log.Timestamp,
+		Action:           log.Action,
+		Resource:         log.Resource,
+		ResourceID:       log.ResourceID,
+		UserID:           log.UserID,
+		ServiceAccountID: log.ServiceAccountID,
+		SessionID:        log.SessionID,
+		APIKeyID:         log.APIKeyID,
+		IPAddress:        log.IPAddress,
+		UserAgent:        log.UserAgent,
+		Message:          log.Details,
+		Severity:         severity,
+	}
+}
+
+// FromCredentialAccess converts a stored credential access record; every
+// secret reveal is reported at warning severity
+func FromCredentialAccess(access *models.CredentialAccess) *Event {
+	message := "Credential secret revealed"
+	if access.Reason != "" {
+		message += ": " + access.Reason
+	}
+
+	return &Event{
+		Kind:             KindCredentialAccess,
+		ID:               access.ID,
+		Time:             access.AccessedAt,
+		Action:           "reveal",
+		Resource:         "credential",
+		ResourceID:       access.CredentialID,
+		UserID:           access.UserID,
+		ServiceAccountID: access.ServiceAccountID,
+		IPAddress:        access.IPAddress,
+		UserAgent:        access.UserAgent,
+		Message:          message,
+		Severity:         SeverityWarning,
+	}
+}
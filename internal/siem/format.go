@@ -0,0 +1,192 @@
+package siem
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Format selects how events are rendered inside the syslog frame
+type Format string
+
+const (
+	// FormatRFC5424 carries event fields as RFC 5424 structured data
+	FormatRFC5424 Format = "rfc5424"
+	// FormatCEF carries an ArcSight Common Event Format record as the message
+	FormatCEF Format = "cef"
+)
+
+// sdID names our structured data element. 32473 is the enterprise number
+// RFC 5612 reserves for documentation; it keeps the ID well-formed without
+// claiming a registered number.
+const sdID = "mini-pam@32473"
+
+// facilityAuthPriv is the syslog facility for security and authorization messages
+const facilityAuthPriv = 10
+
+// Product identification used in CEF headers
+const (
+	cefVendor  = "mini-pam"
+	cefProduct = "mini-pam"
+	cefVersion = "1.0"
+)
+
+// Header identifies the sending host and process in syslog messages
+type Header struct {
+	Hostname string
+	AppName  string
+	ProcID   int
+}
+
+// syslogSeverity maps event severity to syslog severity levels
+func syslogSeverity(severity int) int {
+	switch severity {
+	case SeverityAlert:
+		return 1 // alert
+	case SeverityWarning:
+		return 4 // warning
+	}
+	return 6 // informational
+}
+
+// cefSeverity maps event severity to the 0-10 CEF scale
+func cefSeverity(severity int) int {
+	switch severity {
+	case SeverityAlert:
+		return 9
+	case SeverityWarning:
+		return 6
+	}
+	return 3
+}
+
+// Render returns the complete RFC 5424 syslog message for an event
+func (f Format) Render(e *Event, h Header) []byte {
+	sd, msg := "-", ""
+	switch f {
+	case FormatCEF:
+		msg = CEF(e)
+	default:
+		sd, msg = structuredData(e), e.Message
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "<%d>1 %s %s %s %s %s %s",
+		facilityAuthPriv*8+syslogSeverity(e.Severity),
+		e.Time.UTC().Format("2006-01-02T15:04:05.000000Z07:00"),
+		headerField(h.Hostname, 255),
+		headerField(h.AppName, 48),
+		headerField(strconv.Itoa(h.ProcID), 128),
+		headerField(e.Action, 32),
+		sd,
+	)
+	if msg != "" {
+		b.WriteString(" " + msg)
+	}
+	return []byte(b.String())
+}
+
+// headerField restricts a syslog header field to printable ASCII without
+// spaces, truncated to max, with "-" for empty values
+func headerField(value string, max int) string {
+	var b strings.Builder
+	for _, c := range []byte(value) {
+		if c >= 33 && c <= 126 && b.Len() < max {
+			b.WriteByte(c)
+		}
+	}
+	if b.Len() == 0 {
+		return "-"
+	}
+	return b.String()
+}
+
+// structuredData renders the event fields as one SD-ELEMENT
+func structuredData(e *Event) string {
+	var b strings.Builder
+	b.WriteString("[" + sdID)
+	param := func(name, value string) {
+		b.WriteString(" " + name + `="` + sdEscaper.Replace(value) + `"`)
+	}
+	id := func(name string, value int) {
+		if value != 0 {
+			param(name, strconv.Itoa(value))
+		}
+	}
+
+	param("kind", e.Kind)
+	id("id", e.ID)
+	param("action", e.Action)
+	param("resource", e.Resource)
+	id("resourceId", e.ResourceID)
+	id("userId", e.UserID)
+	id("serviceAccountId", e.ServiceAccountID)
+	id("sessionId", e.SessionID)
+	id("apiKeyId", e.APIKeyID)
+	if e.IPAddress != "" {
+		param("ip", e.IPAddress)
+	}
+	if e.UserAgent != "" {
+		param("userAgent", e.UserAgent)
+	}
+	b.WriteString("]")
+	return b.String()
+}
+
+// sdEscaper escapes PARAM-VALUE characters as RFC 5424 section 6.3.3 requires
+var sdEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`)
+
+// CEF renders an event as an ArcSight Common Event Format record
+func CEF(e *Event) string {
+	var ext []string
+	field := func(key, value string) {
+		if value != "" {
+			ext = append(ext, key+"="+cefExtensionEscaper.Replace(value))
+		}
+	}
+	number := func(key string, value int) {
+		if value != 0 {
+			ext = append(ext, key+"="+strconv.Itoa(value))
+		}
+	}
+
+	number("rt", int(e.Time.UnixMilli()))
+	field("cat", e.Kind)
+	field("act", e.Action)
+	number("externalId", e.ID)
+	number("suid", e.UserID)
+	field("src", e.IPAddress)
+	field("requestClientApplication", e.UserAgent)
+	field("cs1Label", "resource")
+	field("cs1", e.Resource)
+	if e.ResourceID != 0 {
+		field("cn1Label", "resourceId")
+		number("cn1", e.ResourceID)
+	}
+	if e.ServiceAccountID != 0 {
+		field("cn2Label", "serviceAccountId")
+		number("cn2", e.ServiceAccountID)
+	}
+	if e.APIKeyID != 0 {
+		field("cn3Label", "apiKeyId")
+		number("cn3", e.APIKeyID)
+	}
+	field("msg", e.Message)
+
+	return fmt.Sprintf("CEF:0|%s|%s|%s|%s|%s|%d|%s",
+		cefHeaderEscaper.Replace(cefVendor),
+		cefHeaderEscaper.Replace(cefProduct),
+		cefHeaderEscaper.Replace(cefVersion),
+		cefHeaderEscaper.Replace(e.Kind+":"+e.Action),
+		cefHeaderEscaper.Replace(e.Action+" "+e.Resource),
+		cefSeverity(e.Severity),
+		strings.Join(ext, " "),
+	)
+}
+
+// CEF escaping rules differ between the pipe-delimited header and the
+// key=value extension
+var (
+	cefHeaderEscaper    = strings.NewReplacer(`\`, `\\`, "|", `\|`, "\n", " ", "\r", " ")
+	cefExtensionEscaper = strings.NewReplacer(`\`, `\\`, "=", `\=`, "\n", `\n`, "\r", `\r`)
+)
@@ -0,0 +1,211 @@
+package siem
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"log"
+	"math/big"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/theshovonaha/mini-pam/internal/models"
+	"github.com/theshovonaha/mini-pam/internal/siem/siemtest"
+)
+
+var testHeader = Header{Hostname: "pam01", AppName: "mini-pam", ProcID: 42}
+
+func testEvent() *Event {
+	return FromAuditLog(&models.AuditLog{
+		ID:         7,
+		UserID:     3,
+		SessionID:  9,
+		Action:     "login_failed",
+		Resource:   "user",
+		ResourceID: 3,
+		Timestamp:  time.Date(2026, 5, 1, 12, 30, 0, 123456000, time.UTC),
+		IPAddress:  "192.0.2.10",
+		UserAgent:  `curl/8.0 "test"]`,
+		Details:    "Invalid password for user=alice",
+	})
+}
+
+func TestRenderRFC5424(t *testing.T) {
+	got := string(FormatRFC5424.Render(testEvent(), testHeader))
+	want := `<84>1 2026-05-01T12:30:00.123456Z pam01 mini-pam 42 login_failed ` +
+		`[mini-pam@32473 kind="audit" id="7" action="login_failed" resource="user" resourceId="3" userId="3" sessionId="9" ` +
+		`ip="192.0.2.10" userAgent="curl/8.0 \"test\"\]"] Invalid password for user=alice`
+	if got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestRenderCEF(t *testing.T) {
+	e := FromCredentialAccess(&models.CredentialAccess{
+		ID:               5,
+		ServiceAccountID: 2,
+		CredentialID:     11,
+		AccessedAt:       time.UnixMilli(1777638600000),
+		IPAddress:        "198.51.100.4",
+		Reason:           "deploy|rollout\nticket=OPS-1",
+	})
+	got := string(FormatCEF.Render(e, testHeader))
+	want := `<84>1 2026-05-01T12:30:00.000000Z pam01 mini-pam 42 reveal - ` +
+		`CEF:0|mini-pam|mini-pam|1.0|credential_access:reveal|reveal credential|6|` +
+		`rt=1777638600000 cat=credential_access act=reveal externalId=5 src=198.51.100.4 cs1Label=resource cs1=credential ` +
+		`cn1Label=resourceId cn1=11 cn2Label=serviceAccountId cn2=2 msg=Credential secret revealed: deploy|rollout\nticket\=OPS-1`
+	if got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestParseSinkURL(t *testing.T) {
+	config, err := ParseSinkURL("cef+tls://siem.example.com:6514")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Format != FormatCEF || config.Network != "tls" || config.Addr != "siem.example.com:6514" {
+		t.Errorf("got %+v", config)
+	}
+
+	for _, raw := range []string{"udp://siem:514", "json+udp://siem:514", "rfc5424+http://siem:514", "rfc5424+udp://siem"} {
+		if _, err := ParseSinkURL(raw); err == nil {
+			t.Errorf("ParseSinkURL(%q): expected an error", raw)
+		}
+	}
+}
+
+// selfSignedTLS returns a server config and a client config trusting it
+func selfSignedTLS(t *testing.T) (server, client *tls.Config) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "siem"},
+		DNSNames:     []string{"siem.test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+
+	server = &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}
+	client = &tls.Config{RootCAs: pool, ServerName: "siem.test"}
+	return server, client
+}
+
+func TestPipelineDelivery(t *testing.T) {
+	serverTLS, clientTLS := selfSignedTLS(t)
+	logger := log.New(io.Discard, "", 0)
+
+	for _, network := range []string{"udp", "tcp", "tls"} {
+		t.Run(network, func(t *testing.T) {
+			var listenerTLS *tls.Config
+			if network == "tls" {
+				listenerTLS = serverTLS
+			}
+			server := siemtest.NewServer(network, listenerTLS)
+			defer server.Close()
+
+			pipeline := NewPipeline([]SinkConfig{
+				{Format: FormatRFC5424, Network: network, Addr: server.Addr, TLS: clientTLS},
+				{Format: FormatCEF, Network: network, Addr: server.Addr, TLS: clientTLS},
+			}, logger)
+			pipeline.Emit(testEvent())
+			pipeline.Close(5 * time.Second)
+
+			seen := map[bool]bool{}
+			for len(seen) < 2 {
+				select {
+				case msg := <-server.Received():
+					seen[strings.Contains(msg, "CEF:0|")] = true
+				case <-time.After(5 * time.Second):
+					t.Fatalf("received %d of 2 messages", len(seen))
+				}
+			}
+		})
+	}
+}
+
+func TestPipelineRetriesWithoutBlocking(t *testing.T) {
+	// Reserve an address, then leave it closed so the first attempts fail
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+
+	pipeline := NewPipeline([]SinkConfig{{Format: FormatRFC5424, Network: "tcp", Addr: addr, BufferSize: 10}},
+		log.New(io.Discard, "", 0))
+
+	start := time.Now()
+	for i := 0; i < 1000; i++ {
+		pipeline.Emit(testEvent())
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("Emit blocked for %v while the sink was down", elapsed)
+	}
+
+	// Let the first delivery attempt fail, then bring the receiver up;
+	// buffered events arrive once the retry succeeds
+	time.Sleep(200 * time.Millisecond)
+	listener, err = net.Listen("tcp", addr)
+	if err != nil {
+		t.Skipf("could not reclaim %s: %v", addr, err)
+	}
+	defer listener.Close()
+
+	received := make(chan int, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		reader := bufio.NewReader(conn)
+		count := 0
+		for {
+			conn.SetReadDeadline(time.Now().Add(500 * time.Millisecond))
+			length, err := reader.ReadString(' ')
+			if err != nil {
+				received <- count
+				return
+			}
+			n, _ := strconv.Atoi(strings.TrimSpace(length))
+			if _, err := io.CopyN(io.Discard, reader, int64(n)); err != nil {
+				received <- count
+				return
+			}
+			count++
+		}
+	}()
+
+	pipeline.Close(10 * time.Second)
+	select {
+	case count := <-received:
+		// The event being retried plus a full buffer
+		if count < 10 {
+			t.Errorf("received %d events after recovery, want at least 10", count)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("no events delivered after the sink recovered")
+	}
+}
@@ -0,0 +1,151 @@
+// Package siemtest provides an in-process syslog receiver for tests.
+package siemtest
+
+import (
+	"bufio"
+	"crypto/tls"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+)
+
+// Server receives syslog messages over UDP, or over TCP or TLS with
+// octet-counting framing, and records them
+type Server struct {
+	Addr string
+
+	packet   net.PacketConn
+	listener net.Listener
+	wg       sync.WaitGroup
+
+	mu       sync.Mutex
+	conns    map[net.Conn]bool
+	messages []string
+	received chan string
+}
+
+// NewServer starts a receiver on a loopback port. network is "udp" or
+// "tcp"; a non-nil tlsConfig turns the TCP listener into a TLS one.
+func NewServer(network string, tlsConfig *tls.Config) *Server {
+	s := &Server{conns: map[net.Conn]bool{}, received: make(chan string, 1000)}
+
+	if network == "udp" {
+		packet, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			panic("siemtest: failed to listen: " + err.Error())
+		}
+		s.packet = packet
+		s.Addr = packet.LocalAddr().String()
+		s.wg.Add(1)
+		go s.servePackets()
+		return s
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic("siemtest: failed to listen: " + err.Error())
+	}
+	if tlsConfig != nil {
+		listener = tls.NewListener(listener, tlsConfig)
+	}
+	s.listener = listener
+	s.Addr = listener.Addr().String()
+	s.wg.Add(1)
+	go s.serveStreams()
+	return s
+}
+
+// Close stops the receiver and drops open connections
+func (s *Server) Close() {
+	if s.packet != nil {
+		s.packet.Close()
+	} else {
+		s.listener.Close()
+		s.mu.Lock()
+		for conn := range s.conns {
+			conn.Close()
+		}
+		s.mu.Unlock()
+	}
+	s.wg.Wait()
+}
+
+// Messages returns every message received so far
+func (s *Server) Messages() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.messages...)
+}
+
+// Received delivers each message as it arrives
+func (s *Server) Received() <-chan string {
+	return s.received
+}
+
+func (s *Server) record(msg string) {
+	s.mu.Lock()
+	s.messages = append(s.messages, msg)
+	s.mu.Unlock()
+
+	select {
+	case s.received <- msg:
+	default:
+	}
+}
+
+func (s *Server) servePackets() {
+	defer s.wg.Done()
+	buf := make([]byte, 65535)
+	for {
+		n, _, err := s.packet.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		s.record(string(buf[:n]))
+	}
+}
+
+func (s *Server) serveStreams() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.conns[conn] = true
+		s.mu.Unlock()
+
+		s.wg.Add(1)
+		go s.serveConn(conn)
+	}
+}
+
+// serveConn reads "MSG-LEN SP SYSLOG-MSG" frames until the connection closes
+func (s *Server) serveConn(conn net.Conn) {
+	defer s.wg.Done()
+	defer func() {
+		conn.Close()
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+	}()
+
+	reader := bufio.NewReader(conn)
+	for {
+		length, err := reader.ReadString(' ')
+		if err != nil {
+			return
+		}
+		n, err := strconv.Atoi(length[:len(length)-1])
+		if err != nil || n <= 0 {
+			return
+		}
+		msg := make([]byte, n)
+		if _, err := io.ReadFull(reader, msg); err != nil {
+			return
+		}
+		s.record(string(msg))
+	}
+}
@@ -0,0 +1,269 @@
+package siem
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Delivery tuning
+const (
+	defaultBufferSize = 10000
+	dialTimeout       = 5 * time.Second
+	writeTimeout      = 5 * time.Second
+	minRetryDelay     = time.Second
+	maxRetryDelay     = time.Minute
+)
+
+// SinkConfig describes one syslog destination
+type SinkConfig struct {
+	Format     Format
+	Network    string // "udp", "tcp" or "tls"
+	Addr       string // host:port
+	TLS        *tls.Config
+	BufferSize int // events held while the destination is unreachable
+}
+
+// ParseSinkURL parses a destination written as format+network://host:port,
+// for example rfc5424+udp://siem:514 or cef+tls://siem:6514
+func ParseSinkURL(raw string) (SinkConfig, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return SinkConfig{}, fmt.Errorf("invalid sink %q: %w", raw, err)
+	}
+
+	format, network, found := strings.Cut(u.Scheme, "+")
+	if !found {
+		return SinkConfig{}, fmt.Errorf("sink %q must have the form format+network://host:port", raw)
+	}
+	switch Format(format) {
+	case FormatRFC5424, FormatCEF:
+	default:
+		return SinkConfig{}, fmt.Errorf("sink %q has unknown format %q (rfc5424 or cef)", raw, format)
+	}
+	switch network {
+	case "udp", "tcp", "tls":
+	default:
+		return SinkConfig{}, fmt.Errorf("sink %q has unknown transport %q (udp, tcp or tls)", raw, network)
+	}
+	if _, _, err := net.SplitHostPort(u.Host); err != nil {
+		return SinkConfig{}, fmt.Errorf("sink %q must include host and port", raw)
+	}
+
+	return SinkConfig{Format: Format(format), Network: network, Addr: u.Host}, nil
+}
+
+// Sink delivers events to one destination from a background goroutine.
+// Events wait in a bounded buffer while the destination is down and are
+// dropped, never blocking the caller, once the buffer is full.
+type Sink struct {
+	config SinkConfig
+	header Header
+	logger *log.Logger
+
+	queue chan *Event
+	stop  chan struct{}
+	done  chan struct{}
+
+	conn    net.Conn
+	failing bool
+	dropped atomic.Int64
+}
+
+// newSink starts the delivery goroutine for a destination
+func newSink(config SinkConfig, header Header, logger *log.Logger) *Sink {
+	if config.BufferSize <= 0 {
+		config.BufferSize = defaultBufferSize
+	}
+	if config.Network == "tls" {
+		tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+		if config.TLS != nil {
+			tlsConfig = config.TLS.Clone()
+		}
+		if tlsConfig.ServerName == "" {
+			tlsConfig.ServerName, _, _ = net.SplitHostPort(config.Addr)
+		}
+		config.TLS = tlsConfig
+	}
+
+	s := &Sink{
+		config: config,
+		header: header,
+		logger: logger,
+		queue:  make(chan *Event, config.BufferSize),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	go s.run()
+	return s
+}
+
+// name identifies the sink in log messages
+func (s *Sink) name() string {
+	return string(s.config.Format) + "+" + s.config.Network + "://" + s.config.Addr
+}
+
+// enqueue buffers an event without blocking
+func (s *Sink) enqueue(e *Event) {
+	select {
+	case s.queue <- e:
+	default:
+		if dropped := s.dropped.Add(1); dropped == 1 || dropped%1000 == 0 {
+			s.logger.Printf("SIEM sink %s buffer full, %d events dropped", s.name(), dropped)
+		}
+	}
+}
+
+// run delivers queued events in order until the queue is closed
+func (s *Sink) run() {
+	defer close(s.done)
+	defer func() {
+		if s.conn != nil {
+			s.conn.Close()
+		}
+	}()
+
+	for e := range s.queue {
+		if !s.deliver(s.config.Format.Render(e, s.header)) {
+			return
+		}
+	}
+}
+
+// deliver retries a message with exponential backoff until it is written,
+// returning false if the sink is stopped first
+func (s *Sink) deliver(msg []byte) bool {
+	delay := minRetryDelay
+	for {
+		err := s.send(msg)
+		if err == nil {
+			if s.failing {
+				s.logger.Printf("SIEM sink %s recovered", s.name())
+				s.failing = false
+			}
+			return true
+		}
+
+		// Log once per outage rather than once per attempt
+		if !s.failing {
+			s.logger.Printf("SIEM sink %s unavailable, retrying: %v", s.name(), err)
+			s.failing = true
+		}
+
+		select {
+		case <-time.After(delay):
+		case <-s.stop:
+			return false
+		}
+		delay *= 2
+		if delay > maxRetryDelay {
+			delay = maxRetryDelay
+		}
+	}
+}
+
+// send writes one message, connecting first if needed. Stream transports
+// use octet-counting framing (RFC 6587, RFC 5425); UDP sends one datagram.
+func (s *Sink) send(msg []byte) error {
+	if s.conn == nil {
+		var err error
+		switch s.config.Network {
+		case "tls":
+			s.conn, err = tls.DialWithDialer(&net.Dialer{Timeout: dialTimeout}, "tcp", s.config.Addr, s.config.TLS)
+		default:
+			s.conn, err = net.DialTimeout(s.config.Network, s.config.Addr, dialTimeout)
+		}
+		if err != nil {
+			s.conn = nil
+			return err
+		}
+	}
+
+	frame := msg
+	if s.config.Network != "udp" {
+		frame = append([]byte(fmt.Sprintf("%d ", len(msg))), msg...)
+	}
+
+	s.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	if _, err := s.conn.Write(frame); err != nil {
+		s.conn.Close()
+		s.conn = nil
+		return err
+	}
+	return nil
+}
+
+// Pipeline fans events out to every configured sink
+type Pipeline struct {
+	sinks []*Sink
+
+	mu     sync.RWMutex
+	closed bool
+}
+
+// NewPipeline starts a sink for each destination
+func NewPipeline(configs []SinkConfig, logger *log.Logger) *Pipeline {
+	hostname, _ := os.Hostname()
+	header := Header{Hostname: hostname, AppName: "mini-pam", ProcID: os.Getpid()}
+
+	p := &Pipeline{}
+	for _, config := range configs {
+		p.sinks = append(p.sinks, newSink(config, header, logger))
+	}
+	return p
+}
+
+// Emit queues an event for every sink. It never blocks, and is a no-op on
+// a nil or closed pipeline.
+func (p *Pipeline) Emit(e *Event) {
+	if p == nil {
+		return
+	}
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.closed {
+		return
+	}
+	for _, sink := range p.sinks {
+		sink.enqueue(e)
+	}
+}
+
+// Close stops accepting events and waits up to timeout for buffered
+// events to be delivered; anything still undelivered is dropped
+func (p *Pipeline) Close(timeout time.Duration) {
+	if p == nil {
+		return
+	}
+
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return
+	}
+	p.closed = true
+	for _, sink := range p.sinks {
+		close(sink.queue)
+	}
+	p.mu.Unlock()
+
+	deadline := time.After(timeout)
+	for _, sink := range p.sinks {
+		select {
+		case <-sink.done:
+		case <-deadline:
+			for _, sink := range p.sinks {
+				close(sink.stop)
+			}
+			return
+		}
+	}
+}
@@ -0,0 +1,252 @@
+package sshproxy
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"sync"
+	"unicode/utf8"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Filter checks the commands run over a connection before they reach the
+// target: exec requests, subsystems (as "subsystem <name>") and the lines
+// typed into shells. A non-nil error blocks the command and is shown to
+// the user.
+type Filter interface {
+	Check(command string) error
+}
+
+// maxCommandBytes bounds the line kept while a command is typed
+const maxCommandBytes = 16 << 10
+
+// errOpaqueLine blocks lines the proxy could not follow as they were typed
+var errOpaqueLine = errors.New("line editing, history and completion keys cannot be used in this session; type commands out in full")
+
+// Bracketed paste markers, sent by terminals around pasted text
+var (
+	pasteStart = []byte("\x1b[200~")
+	pasteEnd   = []byte("\x1b[201~")
+)
+
+// commandInput sits between a shell session's client and target and
+// rebuilds the lines typed, checking each with the filter as it is entered.
+//
+// With a terminal, keystrokes pass through as they are typed, since the
+// target echoes them, and only the line ending is held back: a blocked line
+// is discarded with an interrupt (^C) instead. Keys that edit the line in
+// ways the proxy cannot follow, such as arrows, history and completion,
+// leave the line opaque and it is blocked when entered. Without a terminal,
+// whole lines are held back until they are checked.
+//
+// Everything read from the terminal is checked, including answers to
+// password prompts; filtering is meant for restricted operators who run
+// commands rather than full-screen programs.
+type commandInput struct {
+	filter   Filter
+	upstream io.Writer
+	client   ssh.Channel
+
+	mu     sync.Mutex
+	shell  bool // Only shells are filtered; exec'd commands read raw input
+	pty    bool
+	line   []byte
+	opaque bool   // The line was edited in ways the proxy cannot follow
+	escape []byte // A partial escape sequence
+}
+
+// observe notes the session requests that decide how input is filtered
+func (c *commandInput) observe(req *ssh.Request) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch req.Type {
+	case "pty-req":
+		c.pty = true
+	case "shell":
+		c.shell = true
+	}
+}
+
+// Write passes client input upstream, checking the commands in it
+func (c *commandInput) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.filter == nil || !c.shell {
+		return c.upstream.Write(p)
+	}
+	if c.pty {
+		return len(p), c.writeTerminal(p)
+	}
+	return len(p), c.writeLines(p)
+}
+
+// Flush checks and passes on a last line the client sent without a newline
+func (c *commandInput) Flush() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.filter == nil || !c.shell || c.pty || (len(c.line) == 0 && !c.opaque) {
+		return nil
+	}
+	command, err := c.enter()
+	if err != nil {
+		c.block(err)
+		return nil
+	}
+	_, err = io.WriteString(c.upstream, command)
+	return err
+}
+
+// writeTerminal follows keystrokes typed at a terminal
+func (c *commandInput) writeTerminal(p []byte) error {
+	out := make([]byte, 0, len(p))
+	for _, b := range p {
+		if len(c.escape) > 0 || b == 0x1b {
+			c.escapeByte(b)
+			out = append(out, b)
+			continue
+		}
+
+		switch b {
+		case '\r', '\n':
+			if _, blocked := c.enter(); blocked != nil {
+				// The interrupt makes the shell drop the line it echoed
+				out = append(out, 0x03)
+				if _, err := c.upstream.Write(out); err != nil {
+					return err
+				}
+				out = out[:0]
+				c.block(blocked)
+				continue
+			}
+		case 0x7f, 0x08: // Backspace
+			if len(c.line) > 0 {
+				_, size := utf8.DecodeLastRune(c.line)
+				c.line = c.line[:len(c.line)-size]
+			}
+		case 0x03, 0x15: // Interrupt and kill line
+			c.line, c.opaque = c.line[:0], false
+		case 0x17: // Delete the previous word
+			c.line = bytes.TrimRight(c.line, " ")
+			c.line = c.line[:bytes.LastIndexByte(c.line, ' ')+1]
+		case 0x04, 0x0c: // End of input and clear screen leave the line alone
+		default:
+			if b < 0x20 {
+				c.opaque = true
+			} else {
+				c.add(b)
+			}
+		}
+		out = append(out, b)
+	}
+
+	_, err := c.upstream.Write(out)
+	return err
+}
+
+// escapeByte collects an escape sequence. Sequences other than bracketed
+// paste markers move the cursor or recall text, so the line turns opaque.
+func (c *commandInput) escapeByte(b byte) {
+	c.escape = append(c.escape, b)
+
+	n := len(c.escape)
+	complete := false
+	switch {
+	case n < 2:
+	case c.escape[1] == '[':
+		complete = n > 2 && b >= 0x40 && b <= 0x7e
+	case c.escape[1] == 'O':
+		complete = n == 3
+	default:
+		complete = true
+	}
+	if !complete && n < 32 {
+		return
+	}
+
+	if !bytes.Equal(c.escape, pasteStart) && !bytes.Equal(c.escape, pasteEnd) {
+		c.opaque = true
+	}
+	c.escape = c.escape[:0]
+}
+
+// writeLines holds back input without a terminal until each line is checked
+func (c *commandInput) writeLines(p []byte) error {
+	for len(p) > 0 {
+		i := bytes.IndexByte(p, '\n')
+		if i < 0 {
+			for _, b := range p {
+				c.add(b)
+			}
+			return nil
+		}
+		for _, b := range p[:i] {
+			c.add(b)
+		}
+		p = p[i+1:]
+
+		command, err := c.enter()
+		if err != nil {
+			c.block(err)
+			continue
+		}
+		if _, err := io.WriteString(c.upstream, command+"\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// add appends a byte to the line, which turns opaque once it is too long to
+// be checked
+func (c *commandInput) add(b byte) {
+	if len(c.line) >= maxCommandBytes {
+		c.opaque = true
+		return
+	}
+	c.line = append(c.line, b)
+}
+
+// enter checks the line being entered and starts a new one
+func (c *commandInput) enter() (string, error) {
+	command, opaque := string(bytes.TrimSuffix(c.line, []byte("\r"))), c.opaque
+	c.line, c.opaque = c.line[:0], false
+
+	if opaque {
+		return command, errOpaqueLine
+	}
+	return command, c.filter.Check(command)
+}
+
+// block tells the client why its command did not run
+func (c *commandInput) block(err error) {
+	if c.pty {
+		io.WriteString(c.client, "\r\nmini-pam: "+err.Error()+"\r\n")
+	} else {
+		io.WriteString(c.client.Stderr(), "mini-pam: "+err.Error()+"\n")
+	}
+}
+
+// checkRequest applies the filter to exec and subsystem requests
+func checkRequest(filter Filter, req *ssh.Request) error {
+	if filter == nil {
+		return nil
+	}
+	switch req.Type {
+	case "exec":
+		var exec struct{ Command string }
+		if err := ssh.Unmarshal(req.Payload, &exec); err != nil {
+			return errors.New("malformed exec request")
+		}
+		return filter.Check(exec.Command)
+	case "subsystem":
+		var subsystem struct{ Name string }
+		if err := ssh.Unmarshal(req.Payload, &subsystem); err != nil {
+			return errors.New("malformed subsystem request")
+		}
+		return filter.Check("subsystem " + subsystem.Name)
+	}
+	return nil
+}
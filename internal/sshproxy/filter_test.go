@@ -0,0 +1,152 @@
+package sshproxy
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/theshovonaha/mini-pam/internal/models"
+	"golang.org/x/crypto/ssh"
+)
+
+// denyFilter blocks commands containing any of its words and logs the
+// commands it checks
+type denyFilter struct {
+	mu      sync.Mutex
+	words   []string
+	checked []string
+}
+
+func (f *denyFilter) Check(command string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.checked = append(f.checked, command)
+	for _, word := range f.words {
+		if strings.Contains(command, word) {
+			return errors.New("command blocked by policy")
+		}
+	}
+	return nil
+}
+
+// fakeChannel collects what is written to the client
+type fakeChannel struct {
+	ssh.Channel
+	stdout, stderr bytes.Buffer
+}
+
+func (c *fakeChannel) Write(p []byte) (int, error) { return c.stdout.Write(p) }
+func (c *fakeChannel) Stderr() io.ReadWriter     { return &c.stderr }
+
+func newCommandInput(filter Filter, requests ...string) (*commandInput, *bytes.Buffer, *fakeChannel) {
+	upstream, client := &bytes.Buffer{}, &fakeChannel{}
+	input := &commandInput{filter: filter, upstream: upstream, client: client}
+	for _, request := range requests {
+		input.observe(&ssh.Request{Type: request})
+	}
+	return input, upstream, client
+}
+
+func TestCommandInputTerminal(t *testing.T) {
+	filter := &denyFilter{words: []string{"shutdown"}}
+	input, upstream, client := newCommandInput(filter, "pty-req", "shell")
+
+	// Keystrokes pass as typed; a blocked line is interrupted instead of entered
+	for _, keys := range []string{"ls", " -l\r", "shutx\x7fdown", " -h now\r", "\x1b[200~uptime\x1b[201~\r"} {
+		input.Write([]byte(keys))
+	}
+	if want := "ls -l\rshutx\x7fdown -h now\x03\x1b[200~uptime\x1b[201~\r"; upstream.String() != want {
+		t.Errorf("upstream got %q, want %q", upstream.String(), want)
+	}
+	if want := []string{"ls -l", "shutdown -h now", "uptime"}; strings.Join(filter.checked, "|") != strings.Join(want, "|") {
+		t.Errorf("checked %q, want %q", filter.checked, want)
+	}
+	if !strings.Contains(client.stdout.String(), "mini-pam: command blocked by policy") {
+		t.Errorf("client was told %q", client.stdout.String())
+	}
+
+	// A recalled or completed line cannot be checked, until it is cleared
+	upstream.Reset()
+	input.Write([]byte("\x1b[A\r"))
+	input.Write([]byte("shut\t\x15w\x17who\r"))
+	if want := "\x1b[A\x03shut\t\x15w\x17who\r"; upstream.String() != want {
+		t.Errorf("upstream got %q, want %q", upstream.String(), want)
+	}
+	if !strings.Contains(client.stdout.String(), errOpaqueLine.Error()) {
+		t.Errorf("client was told %q", client.stdout.String())
+	}
+	if last := filter.checked[len(filter.checked)-1]; last != "who" {
+		t.Errorf("last checked %q", last)
+	}
+}
+
+func TestCommandInputLines(t *testing.T) {
+	filter := &denyFilter{words: []string{"rm -rf"}}
+	input, upstream, client := newCommandInput(filter, "shell")
+
+	input.Write([]byte("echo one\nrm -rf /\nech"))
+	input.Write([]byte("o two\necho three"))
+	input.Flush()
+
+	// Lines are held until checked and blocked ones never reach the target
+	if want := "echo one\necho two\necho three"; upstream.String() != want {
+		t.Errorf("upstream got %q, want %q", upstream.String(), want)
+	}
+	if client.stderr.String() != "mini-pam: command blocked by policy\n" {
+		t.Errorf("client stderr %q", client.stderr.String())
+	}
+}
+
+func TestCommandInputPassesExecInput(t *testing.T) {
+	// Input to an exec'd command is data, not commands
+	input, upstream, _ := newCommandInput(&denyFilter{words: []string{"rm"}}, "exec")
+	input.Write([]byte("rm -rf /\n"))
+	if upstream.String() != "rm -rf /\n" {
+		t.Errorf("upstream got %q", upstream.String())
+	}
+}
+
+func TestProxyFiltersExec(t *testing.T) {
+	targetAddr, targetKey := startTarget(t, newSigner(t).PublicKey())
+	filter := &denyFilter{words: []string{"reboot", "sftp"}}
+	backend := &fakeBackend{
+		targets: map[string]*models.Credential{"web": {ID: 1, Type: "password", Username: "root", Secret: "vaulted", System: targetAddr}},
+		closed:  make(chan *Conn, 1),
+		filter:  filter,
+	}
+	proxyAddr, proxyKey := startProxy(t, backend, targetKey)
+
+	client, err := dialProxy(proxyAddr, proxyKey, "alice+web", ssh.Password("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	session, _ := client.NewSession()
+	var stdout bytes.Buffer
+	session.Stdout = &stdout
+	session.Run("uptime")
+	if stdout.String() != "root ran uptime\n" {
+		t.Errorf("allowed command output %q", stdout.String())
+	}
+
+	session, _ = client.NewSession()
+	var stderr bytes.Buffer
+	session.Stderr = &stderr
+	if err := session.Start("sudo reboot"); err == nil {
+		t.Error("expected the blocked command to fail")
+	}
+	session.Close()
+
+	session, _ = client.NewSession()
+	if err := session.RequestSubsystem("sftp"); err == nil {
+		t.Error("expected the blocked subsystem to fail")
+	}
+
+	if want := []string{"uptime", "sudo reboot", "subsystem sftp"}; strings.Join(filter.checked, "|") != strings.Join(want, "|") {
+		t.Errorf("checked %q, want %q", filter.checked, want)
+	}
+}
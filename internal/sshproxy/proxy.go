@@ -0,0 +1,356 @@
+// Package sshproxy is an SSH bastion: users log in as user+target with their
+// own mini-pam credentials and are relayed to the target system, which the
+// proxy logs into with the vaulted credential so the user never sees it
+package sshproxy
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/theshovonaha/mini-pam/internal/models"
+	"golang.org/x/crypto/ssh"
+)
+
+// Permission extensions carrying the authenticated login to the relay
+const (
+	extUserID = "pam-user-id"
+	extTarget = "pam-target"
+)
+
+// Backend authenticates users and authorizes their targets; the server
+// implements it with the same login throttling and grants as the API
+type Backend interface {
+	// Authenticate verifies a password for username, returning the user's ID
+	// and whether a second factor must be verified before access is granted
+	Authenticate(conn ssh.ConnMetadata, username, password string) (userID int, needMFA bool, err error)
+	// VerifyCode checks a one-time code for a user who passed Authenticate
+	VerifyCode(conn ssh.ConnMetadata, userID int, code string) error
+	// Authorize resolves the target named at login to a credential the user
+	// may use. The returned error is shown to the user.
+	Authorize(conn ssh.ConnMetadata, userID int, target string) (*models.Credential, error)
+	// Opened is called once the upstream connection is established. It may
+	// set conn.ID and conn.Filter and return a tap observing the sessions;
+	// an error refuses the connection.
+	Opened(conn *Conn) (Tap, error)
+	// Closed is called when a relayed connection ends
+	Closed(conn *Conn)
+}
+
+// Tap observes the sessions relayed over a connection, for recording and
+// monitoring. Its methods may be called from several goroutines.
+type Tap interface {
+	// Pty is called when the client requests a terminal
+	Pty(term string, columns, rows int)
+	// Resize is called when the client's terminal changes size
+	Resize(columns, rows int)
+	// Exec is called with the command or subsystem of a non-interactive session
+	Exec(command string)
+	// Output receives everything the target writes to the client
+	Output(data []byte)
+	// Input receives everything the client sends to the target
+	Input(data []byte)
+}
+
+// Conn is an authenticated client connection relayed to its target
+type Conn struct {
+	ID            int // Assigned by the backend
+	UserID        int
+	Username      string
+	Credential    *models.Credential
+	Addr          string // Upstream host:port
+	RemoteAddr    net.Addr
+	ClientVersion string
+	StartedAt     time.Time
+	EndedAt       time.Time
+	Err           error  // Why the connection ended, nil for a clean close
+	Filter        Filter // Set by the backend to restrict commands
+
+	close func() error
+}
+
+// Close disconnects the client, ending the relay. The backend's Closed is
+// still called as for any other disconnect.
+func (c *Conn) Close() error {
+	if c.close == nil {
+		return nil
+	}
+	return c.close()
+}
+
+// Config configures the proxy
+type Config struct {
+	// HostKey identifies the proxy to its clients
+	HostKey ssh.Signer
+	// HostKeyCallback verifies target host keys, typically built from a
+	// known_hosts file. It is required; targets are never trusted blindly.
+	HostKeyCallback ssh.HostKeyCallback
+	// DialTimeout bounds connecting and logging in to a target
+	DialTimeout time.Duration
+}
+
+// Proxy accepts SSH clients and relays them to their targets
+type Proxy struct {
+	backend  Backend
+	config   Config
+	server   *ssh.ServerConfig
+	logger   *log.Logger
+	mu       sync.Mutex
+	listener net.Listener
+	conns    map[*ssh.ServerConn]struct{}
+	wg       sync.WaitGroup
+}
+
+// NewProxy creates a proxy authenticating users through backend
+func NewProxy(backend Backend, config Config, logger *log.Logger) (*Proxy, error) {
+	if config.HostKey == nil {
+		return nil, errors.New("sshproxy: a host key is required")
+	}
+	if config.HostKeyCallback == nil {
+		return nil, errors.New("sshproxy: a host key callback is required")
+	}
+	if config.DialTimeout <= 0 {
+		config.DialTimeout = 10 * time.Second
+	}
+
+	p := &Proxy{
+		backend: backend,
+		config:  config,
+		logger:  logger,
+		conns:   map[*ssh.ServerConn]struct{}{},
+	}
+	p.server = &ssh.ServerConfig{
+		PasswordCallback: p.authenticate,
+		ServerVersion:    "SSH-2.0-mini-pam",
+	}
+	p.server.AddHostKey(config.HostKey)
+	return p, nil
+}
+
+// ParseLogin splits an SSH login of the form user+target. Usernames may
+// contain '+' themselves, so the target follows the last one.
+func ParseLogin(login string) (username, target string) {
+	i := strings.LastIndex(login, "+")
+	if i < 0 {
+		return login, ""
+	}
+	return login[:i], login[i+1:]
+}
+
+// authenticate checks the password half of a login, asking for a one-time
+// code next when the user has a second factor
+func (p *Proxy) authenticate(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+	username, target := ParseLogin(conn.User())
+	userID, needMFA, err := p.backend.Authenticate(conn, username, string(password))
+	if err != nil {
+		return nil, err
+	}
+
+	permissions := &ssh.Permissions{Extensions: map[string]string{
+		extUserID: strconv.Itoa(userID),
+		extTarget: target,
+	}}
+	if !needMFA {
+		return permissions, nil
+	}
+
+	return nil, &ssh.PartialSuccessError{Next: ssh.ServerAuthCallbacks{
+		KeyboardInteractiveCallback: func(conn ssh.ConnMetadata, client ssh.KeyboardInteractiveChallenge) (*ssh.Permissions, error) {
+			answers, err := client("", "Enter the code from your authenticator app.", []string{"Verification code: "}, []bool{false})
+			if err != nil {
+				return nil, err
+			}
+			if len(answers) != 1 {
+				return nil, errors.New("sshproxy: expected one answer")
+			}
+			if err := p.backend.VerifyCode(conn, userID, strings.TrimSpace(answers[0])); err != nil {
+				return nil, err
+			}
+			return permissions, nil
+		},
+	}}
+}
+
+// ListenAndServe listens on addr and serves clients until Close is called
+func (p *Proxy) ListenAndServe(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return p.Serve(listener)
+}
+
+// Serve accepts clients on listener until Close is called
+func (p *Proxy) Serve(listener net.Listener) error {
+	p.mu.Lock()
+	p.listener = listener
+	p.mu.Unlock()
+
+	for {
+		netConn, err := listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			p.handle(netConn)
+		}()
+	}
+}
+
+// Close stops accepting clients, disconnects the connected ones and waits
+// for their relays to finish
+func (p *Proxy) Close() error {
+	p.mu.Lock()
+	var err error
+	if p.listener != nil {
+		err = p.listener.Close()
+	}
+	for conn := range p.conns {
+		conn.Close()
+	}
+	p.conns = nil
+	p.mu.Unlock()
+
+	p.wg.Wait()
+	return err
+}
+
+// handle runs one client connection: the handshake, target authorization,
+// the upstream login and then the relay
+func (p *Proxy) handle(netConn net.Conn) {
+	// Unauthenticated clients get a bounded time to log in
+	netConn.SetDeadline(time.Now().Add(time.Minute))
+	serverConn, chans, reqs, err := ssh.NewServerConn(netConn, p.server)
+	if err != nil {
+		netConn.Close()
+		return
+	}
+	netConn.SetDeadline(time.Time{})
+	defer serverConn.Close()
+	go ssh.DiscardRequests(reqs)
+
+	if !p.track(serverConn) {
+		return
+	}
+	defer p.untrack(serverConn)
+
+	username, _ := ParseLogin(serverConn.User())
+	userID, _ := strconv.Atoi(serverConn.Permissions.Extensions[extUserID])
+	target := serverConn.Permissions.Extensions[extTarget]
+	if target == "" {
+		rejectChannels(chans, ssh.Prohibited, "no target given, connect as "+username+"+<target>")
+		return
+	}
+
+	credential, err := p.backend.Authorize(serverConn, userID, target)
+	if err != nil {
+		rejectChannels(chans, ssh.Prohibited, err.Error())
+		return
+	}
+
+	conn := &Conn{
+		UserID:        userID,
+		Username:      username,
+		Credential:    credential,
+		Addr:          TargetAddr(credential.System),
+		RemoteAddr:    serverConn.RemoteAddr(),
+		ClientVersion: string(serverConn.ClientVersion()),
+		StartedAt:     time.Now(),
+		close:         serverConn.Close,
+	}
+
+	upstream, err := p.dial(conn)
+	if err != nil {
+		p.logger.Printf("SSH proxy: connecting %s to %s: %v", username, conn.Addr, err)
+		rejectChannels(chans, ssh.ConnectionFailed, "failed to connect to target")
+		return
+	}
+	defer upstream.Close()
+
+	tap, err := p.backend.Opened(conn)
+	if err != nil {
+		p.logger.Printf("SSH proxy: starting session for %s: %v", username, err)
+		rejectChannels(chans, ssh.ResourceShortage, "failed to start session")
+		return
+	}
+	conn.Err = relay(serverConn, chans, upstream, tap, conn.Filter)
+	conn.EndedAt = time.Now()
+	p.backend.Closed(conn)
+}
+
+// track registers a live connection so Close can end it, reporting false if
+// the proxy is already closing
+func (p *Proxy) track(conn *ssh.ServerConn) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.conns == nil {
+		return false
+	}
+	p.conns[conn] = struct{}{}
+	return true
+}
+
+func (p *Proxy) untrack(conn *ssh.ServerConn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.conns, conn)
+}
+
+// dial logs in to the target with the vaulted credential
+func (p *Proxy) dial(conn *Conn) (*ssh.Client, error) {
+	auth, err := upstreamAuth(conn.Credential)
+	if err != nil {
+		return nil, err
+	}
+
+	return ssh.Dial("tcp", conn.Addr, &ssh.ClientConfig{
+		User:            conn.Credential.Username,
+		Auth:            []ssh.AuthMethod{auth},
+		HostKeyCallback: p.config.HostKeyCallback,
+		Timeout:         p.config.DialTimeout,
+	})
+}
+
+// upstreamAuth turns a credential's secret into an SSH auth method
+func upstreamAuth(credential *models.Credential) (ssh.AuthMethod, error) {
+	switch credential.Type {
+	case "ssh_key":
+		signer, err := ssh.ParsePrivateKey([]byte(credential.Secret))
+		if err != nil {
+			return nil, fmt.Errorf("invalid private key in credential %d: %w", credential.ID, err)
+		}
+		return ssh.PublicKeys(signer), nil
+	case "password":
+		return ssh.Password(credential.Secret), nil
+	default:
+		return nil, fmt.Errorf("credential %d of type %s cannot be used for SSH", credential.ID, credential.Type)
+	}
+}
+
+// TargetAddr returns the host:port to reach a credential's system on,
+// defaulting to the standard SSH port
+func TargetAddr(system string) string {
+	if _, _, err := net.SplitHostPort(system); err == nil {
+		return system
+	}
+	return net.JoinHostPort(strings.Trim(system, "[]"), "22")
+}
+
+// rejectChannels refuses every channel a client opens, so it sees why it
+// was not relayed, until the client disconnects
+func rejectChannels(chans <-chan ssh.NewChannel, reason ssh.RejectionReason, message string) {
+	for newChannel := range chans {
+		newChannel.Reject(reason, message)
+	}
+}
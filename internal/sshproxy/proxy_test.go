@@ -0,0 +1,451 @@
+package sshproxy
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/theshovonaha/mini-pam/internal/models"
+	"golang.org/x/crypto/ssh"
+)
+
+func newSigner(t *testing.T) ssh.Signer {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer
+}
+
+// startTarget runs an SSH server accepting password "vaulted" for root and
+// the given client key for deploy. Exec requests answer with the login and
+// command and exit with status 3; shells echo their input.
+func startTarget(t *testing.T, clientKey ssh.PublicKey) (string, ssh.PublicKey) {
+	t.Helper()
+	hostKey := newSigner(t)
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			if conn.User() == "root" && string(password) == "vaulted" {
+				return nil, nil
+			}
+			return nil, errors.New("denied")
+		},
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if conn.User() == "deploy" && bytes.Equal(key.Marshal(), clientKey.Marshal()) {
+				return nil, nil
+			}
+			return nil, errors.New("denied")
+		},
+	}
+	config.AddHostKey(hostKey)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			netConn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				conn, chans, reqs, err := ssh.NewServerConn(netConn, config)
+				if err != nil {
+					return
+				}
+				go ssh.DiscardRequests(reqs)
+				for newChannel := range chans {
+					channel, requests, _ := newChannel.Accept()
+					go serveTargetSession(conn.User(), channel, requests)
+				}
+			}()
+		}
+	}()
+	return listener.Addr().String(), hostKey.PublicKey()
+}
+
+func serveTargetSession(user string, channel ssh.Channel, requests <-chan *ssh.Request) {
+	defer channel.Close()
+	for req := range requests {
+		switch req.Type {
+		case "exec":
+			var exec struct{ Command string }
+			ssh.Unmarshal(req.Payload, &exec)
+			req.Reply(true, nil)
+			io.WriteString(channel, user+" ran "+exec.Command+"\n")
+			io.WriteString(channel.Stderr(), "warning\n")
+			channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{3}))
+			return
+		case "shell":
+			req.Reply(true, nil)
+			io.Copy(channel, channel)
+			channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{0}))
+			return
+		default:
+			req.Reply(req.Type == "pty-req" || req.Type == "env", nil)
+		}
+	}
+}
+
+// fakeBackend grants alice (password "secret", MFA code "123456" when mfa is
+// set) the credentials in targets
+type fakeBackend struct {
+	mfa     bool
+	targets map[string]*models.Credential
+	mu      sync.Mutex
+	opened  []*Conn
+	closed  chan *Conn
+	tap     Tap
+	filter  Filter
+}
+
+// fakeTap logs what it observes
+type fakeTap struct {
+	mu     sync.Mutex
+	events []string
+	output bytes.Buffer
+	input  bytes.Buffer
+}
+
+func (t *fakeTap) log(event string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.events = append(t.events, event)
+}
+
+func (t *fakeTap) Pty(term string, columns, rows int) {
+	t.log(fmt.Sprintf("pty %s %dx%d", term, columns, rows))
+}
+func (t *fakeTap) Resize(columns, rows int) { t.log(fmt.Sprintf("resize %dx%d", columns, rows)) }
+func (t *fakeTap) Exec(command string)      { t.log("exec " + command) }
+
+func (t *fakeTap) Output(data []byte) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.output.Write(data)
+}
+
+func (t *fakeTap) Input(data []byte) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.input.Write(data)
+}
+
+func (b *fakeBackend) Authenticate(conn ssh.ConnMetadata, username, password string) (int, bool, error) {
+	if username != "alice" || password != "secret" {
+		return 0, false, errors.New("invalid credentials")
+	}
+	return 7, b.mfa, nil
+}
+
+func (b *fakeBackend) VerifyCode(conn ssh.ConnMetadata, userID int, code string) error {
+	if code != "123456" {
+		return errors.New("invalid code")
+	}
+	return nil
+}
+
+func (b *fakeBackend) Authorize(conn ssh.ConnMetadata, userID int, target string) (*models.Credential, error) {
+	if credential, ok := b.targets[target]; ok {
+		return credential, nil
+	}
+	return nil, errors.New("access to " + target + " denied")
+}
+
+func (b *fakeBackend) Opened(conn *Conn) (Tap, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.opened = append(b.opened, conn)
+	conn.ID = len(b.opened)
+	conn.Filter = b.filter
+	return b.tap, nil
+}
+
+func (b *fakeBackend) Closed(conn *Conn) {
+	b.closed <- conn
+}
+
+// startProxy serves a proxy trusting only the given target host key
+func startProxy(t *testing.T, backend Backend, targetKey ssh.PublicKey) (string, ssh.PublicKey) {
+	t.Helper()
+	hostKey := newSigner(t)
+	proxy, err := NewProxy(backend, Config{
+		HostKey:         hostKey,
+		HostKeyCallback: ssh.FixedHostKey(targetKey),
+	}, log.New(io.Discard, "", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go proxy.Serve(listener)
+	t.Cleanup(func() { proxy.Close() })
+	return listener.Addr().String(), hostKey.PublicKey()
+}
+
+func dialProxy(addr string, hostKey ssh.PublicKey, login string, auth ...ssh.AuthMethod) (*ssh.Client, error) {
+	return ssh.Dial("tcp", addr, &ssh.ClientConfig{
+		User:            login,
+		Auth:            auth,
+		HostKeyCallback: ssh.FixedHostKey(hostKey),
+	})
+}
+
+func TestProxyInjectsCredentials(t *testing.T) {
+	// The deploy credential holds an OpenSSH private key
+	_, private, _ := ed25519.GenerateKey(rand.Reader)
+	block, err := ssh.MarshalPrivateKey(private, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	deployKey, _ := ssh.NewSignerFromKey(private)
+
+	targetAddr, targetKey := startTarget(t, deployKey.PublicKey())
+	backend := &fakeBackend{
+		targets: map[string]*models.Credential{
+			"web":    {ID: 1, Type: "password", Username: "root", Secret: "vaulted", System: targetAddr},
+			"deploy": {ID: 2, Type: "ssh_key", Username: "deploy", Secret: string(pem.EncodeToMemory(block)), System: targetAddr},
+		},
+		closed: make(chan *Conn, 4),
+	}
+	proxyAddr, proxyKey := startProxy(t, backend, targetKey)
+
+	for target, want := range map[string]string{"web": "root ran uptime\n", "deploy": "deploy ran uptime\n"} {
+		client, err := dialProxy(proxyAddr, proxyKey, "alice+"+target, ssh.Password("secret"))
+		if err != nil {
+			t.Fatalf("%s: dial: %v", target, err)
+		}
+		session, err := client.NewSession()
+		if err != nil {
+			t.Fatalf("%s: session: %v", target, err)
+		}
+		var stdout, stderr bytes.Buffer
+		session.Stdout, session.Stderr = &stdout, &stderr
+		err = session.Run("uptime")
+		var exitErr *ssh.ExitError
+		if !errors.As(err, &exitErr) || exitErr.ExitStatus() != 3 {
+			t.Errorf("%s: expected exit status 3, got %v", target, err)
+		}
+		if stdout.String() != want || stderr.String() != "warning\n" {
+			t.Errorf("%s: got stdout %q, stderr %q", target, stdout.String(), stderr.String())
+		}
+		client.Close()
+
+		conn := <-backend.closed
+		if conn.UserID != 7 || conn.Username != "alice" || conn.Credential.ID != backend.targets[target].ID || conn.Addr != targetAddr {
+			t.Errorf("%s: closed connection %+v", target, conn)
+		}
+	}
+	if len(backend.opened) != 2 {
+		t.Errorf("expected 2 opened connections, got %d", len(backend.opened))
+	}
+}
+
+func TestProxyInteractiveShell(t *testing.T) {
+	targetAddr, targetKey := startTarget(t, newSigner(t).PublicKey())
+	tap := &fakeTap{}
+	backend := &fakeBackend{
+		mfa:     true,
+		targets: map[string]*models.Credential{"web": {ID: 1, Type: "password", Username: "root", Secret: "vaulted", System: targetAddr}},
+		closed:  make(chan *Conn, 1),
+		tap:     tap,
+	}
+	proxyAddr, proxyKey := startProxy(t, backend, targetKey)
+
+	// The password alone is not enough once MFA is enrolled
+	if _, err := dialProxy(proxyAddr, proxyKey, "alice+web", ssh.Password("secret")); err == nil {
+		t.Fatal("expected login without a code to fail")
+	}
+
+	answer := func(code string) ssh.AuthMethod {
+		return ssh.KeyboardInteractive(func(name, instruction string, questions []string, echos []bool) ([]string, error) {
+			return []string{code}, nil
+		})
+	}
+	if _, err := dialProxy(proxyAddr, proxyKey, "alice+web", ssh.Password("secret"), answer("000000")); err == nil {
+		t.Fatal("expected login with a wrong code to fail")
+	}
+	client, err := dialProxy(proxyAddr, proxyKey, "alice+web", ssh.Password("secret"), answer("123456"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	session, err := client.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := session.RequestPty("xterm", 24, 80, ssh.TerminalModes{}); err != nil {
+		t.Fatalf("pty request: %v", err)
+	}
+	var stdout bytes.Buffer
+	session.Stdout = &stdout
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := session.Shell(); err != nil {
+		t.Fatal(err)
+	}
+	if err := session.WindowChange(40, 100); err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(stdin, "echo hello\n")
+	stdin.Close()
+	if err := session.Wait(); err != nil {
+		t.Fatalf("shell: %v", err)
+	}
+	if stdout.String() != "echo hello\n" {
+		t.Errorf("shell output %q", stdout.String())
+	}
+
+	// The tap saw the terminal and both directions of the session
+	client.Close()
+	<-backend.closed
+	if want := []string{"pty xterm 80x24", "resize 100x40"}; !reflect.DeepEqual(tap.events, want) {
+		t.Errorf("tap events %q, want %q", tap.events, want)
+	}
+	if tap.output.String() != "echo hello\n" || tap.input.String() != "echo hello\n" {
+		t.Errorf("tap output %q, input %q", tap.output.String(), tap.input.String())
+	}
+}
+
+func TestProxyConnClose(t *testing.T) {
+	targetAddr, targetKey := startTarget(t, newSigner(t).PublicKey())
+	backend := &fakeBackend{
+		targets: map[string]*models.Credential{"web": {ID: 1, Type: "password", Username: "root", Secret: "vaulted", System: targetAddr}},
+		closed:  make(chan *Conn, 1),
+	}
+	proxyAddr, proxyKey := startProxy(t, backend, targetKey)
+
+	client, err := dialProxy(proxyAddr, proxyKey, "alice+web", ssh.Password("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	session, err := client.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := session.Shell(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Closing the connection from the backend's side ends the running shell
+	backend.mu.Lock()
+	conn := backend.opened[0]
+	backend.mu.Unlock()
+	conn.Close()
+	if err := session.Wait(); err == nil {
+		t.Error("expected the shell to end without an exit status")
+	}
+	if closed := <-backend.closed; closed != conn || closed.EndedAt.IsZero() {
+		t.Errorf("closed connection %+v", closed)
+	}
+}
+
+func TestProxyRefusals(t *testing.T) {
+	targetAddr, targetKey := startTarget(t, newSigner(t).PublicKey())
+	backend := &fakeBackend{
+		targets: map[string]*models.Credential{
+			"web": {ID: 1, Type: "password", Username: "root", Secret: "vaulted", System: targetAddr},
+		},
+		closed: make(chan *Conn, 1),
+	}
+	proxyAddr, proxyKey := startProxy(t, backend, targetKey)
+
+	if _, err := dialProxy(proxyAddr, proxyKey, "alice+web", ssh.Password("wrong")); err == nil {
+		t.Error("expected a wrong password to fail")
+	}
+
+	for login, want := range map[string]string{
+		"alice":       "no target given",
+		"alice+db":    "access to db denied",
+		"alice+other": "access to other denied",
+	} {
+		client, err := dialProxy(proxyAddr, proxyKey, login, ssh.Password("secret"))
+		if err != nil {
+			t.Fatalf("%s: dial: %v", login, err)
+		}
+		if _, err := client.NewSession(); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: expected %q, got %v", login, want, err)
+		}
+		client.Close()
+	}
+
+	// Port forwarding is refused even to an authorized target
+	client, err := dialProxy(proxyAddr, proxyKey, "alice+web", ssh.Password("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Dial("tcp", targetAddr); err == nil {
+		t.Error("expected port forwarding to be refused")
+	}
+	client.Close()
+}
+
+func TestProxyVerifiesTargetHostKey(t *testing.T) {
+	targetAddr, _ := startTarget(t, newSigner(t).PublicKey())
+	backend := &fakeBackend{
+		targets: map[string]*models.Credential{"web": {ID: 1, Type: "password", Username: "root", Secret: "vaulted", System: targetAddr}},
+		closed:  make(chan *Conn, 1),
+	}
+	// The proxy expects a different key than the target presents
+	proxyAddr, proxyKey := startProxy(t, backend, newSigner(t).PublicKey())
+
+	client, err := dialProxy(proxyAddr, proxyKey, "alice+web", ssh.Password("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if _, err := client.NewSession(); err == nil || !strings.Contains(err.Error(), "failed to connect to target") {
+		t.Errorf("expected the unknown target key to be rejected, got %v", err)
+	}
+}
+
+func TestTargetAddr(t *testing.T) {
+	for system, want := range map[string]string{
+		"db01.internal":      "db01.internal:22",
+		"db01.internal:2200": "db01.internal:2200",
+		"10.0.0.5":           "10.0.0.5:22",
+		"::1":                "[::1]:22",
+		"[::1]:2222":         "[::1]:2222",
+	} {
+		if got := TargetAddr(system); got != want {
+			t.Errorf("TargetAddr(%q) = %q, want %q", system, got, want)
+		}
+	}
+}
+
+func TestParseLogin(t *testing.T) {
+	for login, want := range map[string][2]string{
+		"alice+web01":           {"alice", "web01"},
+		"alice":                 {"alice", ""},
+		"alice+ops@corp+db-prd": {"alice+ops@corp", "db-prd"},
+	} {
+		if username, target := ParseLogin(login); username != want[0] || target != want[1] {
+			t.Errorf("ParseLogin(%q) = %q, %q", login, username, target)
+		}
+	}
+}
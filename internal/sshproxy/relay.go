@@ -0,0 +1,183 @@
+package sshproxy
+
+import (
+	"io"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Session channel requests that would expose the proxy or the target's
+// network to the client are refused rather than forwarded
+var refusedRequests = map[string]bool{
+	"auth-agent-req@openssh.com": true,
+	"x11-req":                    true,
+}
+
+// relay forwards the client's session channels to upstream until either
+// side disconnects. Only interactive sessions are relayed: port forwarding,
+// X11 and agent forwarding are refused. A non-nil filter checks commands.
+func relay(client *ssh.ServerConn, chans <-chan ssh.NewChannel, upstream *ssh.Client, tap Tap, filter Filter) error {
+	if tap == nil {
+		tap = nopTap{}
+	}
+
+	// Closing either connection ends the other
+	go func() {
+		upstream.Wait()
+		client.Close()
+	}()
+
+	var wg sync.WaitGroup
+	for newChannel := range chans {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.Prohibited, newChannel.ChannelType()+" channels are not permitted")
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			relaySession(newChannel, upstream, tap, filter)
+		}()
+	}
+	wg.Wait()
+
+	upstream.Close()
+	err := client.Wait()
+	if err == io.EOF {
+		return nil
+	}
+	return err
+}
+
+// relaySession opens a matching session on upstream and copies data and
+// requests between the two channels until the upstream side closes
+func relaySession(newChannel ssh.NewChannel, upstream *ssh.Client, tap Tap, filter Filter) {
+	upChannel, upRequests, err := upstream.OpenChannel("session", newChannel.ExtraData())
+	if err != nil {
+		if openErr, ok := err.(*ssh.OpenChannelError); ok {
+			newChannel.Reject(openErr.Reason, openErr.Message)
+		} else {
+			newChannel.Reject(ssh.ConnectionFailed, "failed to open session on target")
+		}
+		return
+	}
+	defer upChannel.Close()
+
+	channel, requests, err := newChannel.Accept()
+	if err != nil {
+		return
+	}
+	defer channel.Close()
+
+	// Client input and requests flow upstream; the client closing its
+	// channel closes the upstream one. A request waiting for the target's
+	// reply holds replying, so the channel is not closed under it.
+	var replying sync.Mutex
+	input := &commandInput{filter: filter, upstream: upChannel, client: channel}
+	go func() {
+		io.Copy(input, io.TeeReader(channel, tapWriter(tap.Input)))
+		input.Flush()
+		upChannel.CloseWrite()
+	}()
+	go func() {
+		for req := range requests {
+			replying.Lock()
+			if refusedRequests[req.Type] {
+				reply(req, false)
+			} else if err := checkRequest(filter, req); err != nil {
+				io.WriteString(channel.Stderr(), "mini-pam: "+err.Error()+"\r\n")
+				reply(req, false)
+			} else {
+				observeRequest(tap, req)
+				input.observe(req)
+				forwardRequest(req, upChannel)
+			}
+			replying.Unlock()
+		}
+		upChannel.Close()
+	}()
+
+	// Output flows back. Upstream requests (exit-status above all) are all
+	// delivered by the time upRequests closes, which happens only once the
+	// upstream channel is closed, so the client sees them before its close.
+	var output sync.WaitGroup
+	output.Add(2)
+	go func() {
+		defer output.Done()
+		io.Copy(channel, io.TeeReader(upChannel, tapWriter(tap.Output)))
+	}()
+	go func() {
+		defer output.Done()
+		io.Copy(channel.Stderr(), io.TeeReader(upChannel.Stderr(), tapWriter(tap.Output)))
+	}()
+	for req := range upRequests {
+		forwardRequest(req, channel)
+	}
+	output.Wait()
+
+	replying.Lock()
+	defer replying.Unlock()
+	channel.CloseWrite()
+}
+
+// forwardRequest replays a channel request onto dst and relays the reply
+func forwardRequest(req *ssh.Request, dst ssh.Channel) {
+	ok, err := dst.SendRequest(req.Type, req.WantReply, req.Payload)
+	reply(req, ok && err == nil)
+}
+
+// reply answers a request if the sender waits for an answer
+func reply(req *ssh.Request, ok bool) {
+	if req.WantReply {
+		req.Reply(ok, nil)
+	}
+}
+
+// observeRequest tells tap about the session requests it cares for
+func observeRequest(tap Tap, req *ssh.Request) {
+	switch req.Type {
+	case "pty-req":
+		var pty struct {
+			Term                         string
+			Columns, Rows, Width, Height uint32
+			Modes                        string
+		}
+		if ssh.Unmarshal(req.Payload, &pty) == nil {
+			tap.Pty(pty.Term, int(pty.Columns), int(pty.Rows))
+		}
+	case "window-change":
+		var size struct{ Columns, Rows, Width, Height uint32 }
+		if ssh.Unmarshal(req.Payload, &size) == nil {
+			tap.Resize(int(size.Columns), int(size.Rows))
+		}
+	case "exec":
+		var exec struct{ Command string }
+		if ssh.Unmarshal(req.Payload, &exec) == nil {
+			tap.Exec(exec.Command)
+		}
+	case "subsystem":
+		var subsystem struct{ Name string }
+		if ssh.Unmarshal(req.Payload, &subsystem) == nil {
+			tap.Exec("subsystem " + subsystem.Name)
+		}
+	}
+}
+
+// tapWriter adapts a tap method to io.Writer for io.TeeReader
+type tapWriter func(data []byte)
+
+func (w tapWriter) Write(p []byte) (int, error) {
+	w(p)
+	return len(p), nil
+}
+
+// nopTap is used when the backend does not observe sessions
+type nopTap struct{}
+
+func (nopTap) Pty(term string, columns, rows int) {}
+func (nopTap) Resize(columns, rows int)           {}
+func (nopTap) Exec(command string)                {}
+func (nopTap) Output(data []byte)                 {}
+func (nopTap) Input(data []byte)                  {}
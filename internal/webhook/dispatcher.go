@@ -0,0 +1,184 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/theshovonaha/mini-pam/internal/models"
+)
+
+// Delivery tuning
+const (
+	requestTimeout = 10 * time.Second
+	claimBatchSize = 20
+	// claimLease keeps a claimed delivery from being picked up again while
+	// it is in flight; it must exceed requestTimeout
+	claimLease   = time.Minute
+	minBackoff   = 30 * time.Second
+	maxBackoff   = 6 * time.Hour
+	maxAttempts  = 12
+	maxErrorBody = 512
+)
+
+// Queue is the durable delivery queue, implemented by models.WebhookRepository
+type Queue interface {
+	ClaimDue(limit int, lease time.Duration) ([]*models.WebhookDelivery, error)
+	RecordAttempt(delivery *models.WebhookDelivery, nextAttemptAt time.Time) error
+}
+
+// Backoff returns the wait before the attempt following attempts failed
+// ones: 30s, 1m, 2m and so on, capped at 6h
+func Backoff(attempts int) time.Duration {
+	delay := minBackoff
+	for i := 1; i < attempts && delay < maxBackoff; i++ {
+		delay *= 2
+	}
+	return min(delay, maxBackoff)
+}
+
+// Dispatcher sends due deliveries from the queue to their subscribers
+type Dispatcher struct {
+	queue  Queue
+	client *http.Client
+	logger *log.Logger
+	now    func() time.Time
+}
+
+// NewDispatcher creates a dispatcher for the queue
+func NewDispatcher(queue Queue, logger *log.Logger) *Dispatcher {
+	return &Dispatcher{
+		queue: queue,
+		client: &http.Client{
+			Timeout: requestTimeout,
+			// A redirect would send the signed payload somewhere the
+			// subscriber did not register; treat it as a failure instead
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		logger: logger,
+		now:    time.Now,
+	}
+}
+
+// Run polls the queue every interval until ctx is cancelled
+func (d *Dispatcher) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := d.DispatchDue(ctx); err != nil {
+			d.logger.Printf("Error dispatching webhooks: %v", err)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// DispatchDue claims and attempts due deliveries, batch by batch, until
+// none are left, returning how many were attempted
+func (d *Dispatcher) DispatchDue(ctx context.Context) (int, error) {
+	attempted := 0
+	for ctx.Err() == nil {
+		deliveries, err := d.queue.ClaimDue(claimBatchSize, claimLease)
+		if err != nil {
+			return attempted, err
+		}
+		if len(deliveries) == 0 {
+			break
+		}
+
+		// Subscribers are independent; one slow endpoint should not hold
+		// up the rest of the batch
+		var wg sync.WaitGroup
+		for _, delivery := range deliveries {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				d.attempt(ctx, delivery)
+			}()
+		}
+		wg.Wait()
+		attempted += len(deliveries)
+	}
+	return attempted, nil
+}
+
+// attempt sends one delivery and records the outcome, scheduling a retry
+// with exponential backoff or giving up after maxAttempts
+func (d *Dispatcher) attempt(ctx context.Context, delivery *models.WebhookDelivery) {
+	status, err := d.send(ctx, delivery)
+	if err != nil && ctx.Err() != nil {
+		// Shutting down; the lease expires and the delivery is retried
+		// without counting this attempt
+		return
+	}
+
+	delivery.Attempts++
+	delivery.ResponseStatus = status
+	delivery.LastError = ""
+	next := d.now()
+	switch {
+	case err == nil:
+		delivery.Status = models.WebhookDelivered
+	case delivery.Attempts >= maxAttempts:
+		delivery.Status = models.WebhookFailed
+		delivery.LastError = err.Error()
+	default:
+		delivery.Status = models.WebhookPending
+		delivery.LastError = err.Error()
+		next = next.Add(Backoff(delivery.Attempts))
+	}
+
+	if err := d.queue.RecordAttempt(delivery, next); err != nil {
+		d.logger.Printf("Error recording webhook delivery %d: %v", delivery.ID, err)
+	}
+	if delivery.Status == models.WebhookFailed {
+		d.logger.Printf("Webhook delivery %d to subscription %d failed after %d attempts: %s",
+			delivery.ID, delivery.SubscriptionID, delivery.Attempts, delivery.LastError)
+	}
+}
+
+// send POSTs the signed payload, returning the response status and an
+// error unless the subscriber answered 2xx
+func (d *Dispatcher) send(ctx context.Context, delivery *models.WebhookDelivery) (int, error) {
+	subscription := delivery.Subscription
+	if subscription == nil {
+		return 0, errors.New("delivery has no subscription")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, subscription.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "mini-pam-webhooks/1.0")
+	req.Header.Set(EventHeader, delivery.EventType)
+	req.Header.Set(DeliveryHeader, strconv.Itoa(delivery.ID))
+	req.Header.Set(SignatureHeader, Sign(subscription.Secret, d.now(), delivery.Payload))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+		return resp.StatusCode, fmt.Errorf("subscriber responded %s: %s", resp.Status, bytes.TrimSpace(body))
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, maxErrorBody))
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,155 @@
+// Package webhook delivers security events to subscribed HTTP endpoints as
+// HMAC-SHA256 signed JSON, retrying from a durable queue in Postgres.
+package webhook
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/theshovonaha/mini-pam/internal/models"
+)
+
+// Event types subscribers can filter on; "*" subscribes to all of them
+const (
+	EventLoginFailed               = "login.failed"
+	EventLoginBlocked              = "login.blocked"
+	EventLoginLockedOut            = "login.locked_out"
+	EventUserCreated               = "user.created"
+	EventUserDeactivated           = "user.deactivated"
+	EventUserDeleted               = "user.deleted"
+	EventRoleAssigned              = "role.assigned"
+	EventRoleRemoved               = "role.removed"
+	EventPasswordReset             = "password.reset"
+	EventServiceAccountDeactivated = "service_account.deactivated"
+	EventAPIKeyCreated             = "api_key.created"
+	EventAPIKeyRevoked             = "api_key.revoked"
+	EventCredentialRevealed        = "credential.revealed"
+	EventSSHCommandBlocked         = "ssh.command_blocked"
+	EventSSHCommandFlagged         = "ssh.command_flagged"
+	AllEvents                      = "*"
+)
+
+// auditEventTypes maps audit action and resource pairs to event types
+var auditEventTypes = map[[2]string]string{
+	{"login_failed", "user"}:           EventLoginFailed,
+	{"login_blocked", "user"}:          EventLoginBlocked,
+	{"lockout", "user"}:                EventLoginLockedOut,
+	{"create", "user"}:                 EventUserCreated,
+	{"deactivate", "user"}:             EventUserDeactivated,
+	{"delete", "user"}:                 EventUserDeleted,
+	{"assign_role", "user"}:            EventRoleAssigned,
+	{"remove_role", "user"}:            EventRoleRemoved,
+	{"password_reset", "user"}:         EventPasswordReset,
+	{"deactivate", "service_account"}:  EventServiceAccountDeactivated,
+	{"create", "api_key"}:              EventAPIKeyCreated,
+	{"revoke", "api_key"}:              EventAPIKeyRevoked,
+	{"command_blocked", "ssh_session"}: EventSSHCommandBlocked,
+	{"command_flagged", "ssh_session"}: EventSSHCommandFlagged,
+}
+
+// EventTypes returns every event type, sorted
+func EventTypes() []string {
+	types := []string{EventCredentialRevealed}
+	for _, eventType := range auditEventTypes {
+		types = append(types, eventType)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// ValidEventType reports whether subscribers may filter on eventType
+func ValidEventType(eventType string) bool {
+	if eventType == AllEvents || eventType == EventCredentialRevealed {
+		return true
+	}
+	for _, known := range auditEventTypes {
+		if known == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// Event is the JSON document POSTed to subscribers
+type Event struct {
+	ID         string    `json:"id"`
+	Type       string    `json:"type"`
+	OccurredAt time.Time `json:"occurred_at"`
+	Data       EventData `json:"data"`
+}
+
+// EventData describes what happened, and who did it, in the shape of an audit entry
+type EventData struct {
+	Action           string              `json:"action"`
+	Resource         string              `json:"resource"`
+	ResourceID       int                 `json:"resource_id,omitempty"`
+	UserID           int                 `json:"user_id,omitempty"`
+	ServiceAccountID int                 `json:"service_account_id,omitempty"`
+	SessionID        int                 `json:"session_id,omitempty"`
+	APIKeyID         int                 `json:"api_key_id,omitempty"`
+	IPAddress        string              `json:"ip_address,omitempty"`
+	UserAgent        string              `json:"user_agent,omitempty"`
+	Details          string              `json:"details,omitempty"`
+	Changes          models.AuditChanges `json:"changes,omitempty"`
+}
+
+// FromAuditLog returns the event for a stored audit entry, or false if
+// the entry is not one subscribers can receive
+func FromAuditLog(log *models.AuditLog) (*Event, bool) {
+	eventType, ok := auditEventTypes[[2]string{log.Action, log.Resource}]
+
+	// An update that clears the active flag deactivates the user
+	if !ok && log.Action == "update" && log.Resource == "user" {
+		if change, changed := log.Changes["active"]; changed && change.New == false {
+			eventType, ok = EventUserDeactivated, true
+		}
+	}
+	if !ok {
+		return nil, false
+	}
+
+	return &Event{
+		ID:         "audit-" + strconv.Itoa(log.ID),
+		Type:       eventType,
+		OccurredAt: log.Timestamp.UTC(),
+		Data: EventData{
+			Action:           log.Action,
+			Resource:         log.Resource,
+			ResourceID:       log.ResourceID,
+			UserID:           log.UserID,
+			ServiceAccountID: log.ServiceAccountID,
+			SessionID:        log.SessionID,
+			APIKeyID:         log.APIKeyID,
+			IPAddress:        log.IPAddress,
+			UserAgent:        log.UserAgent,
+			Details:          log.Details,
+			Changes:          log.Changes,
+		},
+	}, true
+}
+
+// FromCredentialAccess returns the event for a credential secret reveal
+func FromCredentialAccess(access *models.CredentialAccess) *Event {
+	return &Event{
+		ID:         "credential_access-" + strconv.Itoa(access.ID),
+		Type:       EventCredentialRevealed,
+		OccurredAt: access.AccessedAt.UTC(),
+		Data: EventData{
+			Action:           "reveal",
+			Resource:         "credential",
+			ResourceID:       access.CredentialID,
+			UserID:           access.UserID,
+			ServiceAccountID: access.ServiceAccountID,
+			IPAddress:        access.IPAddress,
+			UserAgent:        access.UserAgent,
+			Details:          access.Reason,
+		},
+	}
+}
+
+// Payload encodes the event as it is stored and delivered
+func (e *Event) Payload() ([]byte, error) {
+	return json.Marshal(e)
+}
@@ -0,0 +1,169 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/theshovonaha/mini-pam/internal/models"
+)
+
+func TestSignAndVerify(t *testing.T) {
+	now := time.Unix(1777638600, 0)
+	body := []byte(`{"type":"credential.revealed"}`)
+
+	header := Sign("whsec_test", now, body)
+	if header != "t=1777638600,v1="+mac("whsec_test", "1777638600", body) {
+		t.Fatalf("unexpected header %q", header)
+	}
+	if err := Verify("whsec_test", header, body, DefaultTolerance, now.Add(time.Minute)); err != nil {
+		t.Errorf("Verify: %v", err)
+	}
+
+	for name, check := range map[string]func() error{
+		"wrong secret":  func() error { return Verify("whsec_other", header, body, DefaultTolerance, now) },
+		"modified body": func() error { return Verify("whsec_test", header, []byte(`{}`), DefaultTolerance, now) },
+		"stale":         func() error { return Verify("whsec_test", header, body, DefaultTolerance, now.Add(time.Hour)) },
+		"malformed":     func() error { return Verify("whsec_test", "v1=abc", body, DefaultTolerance, now) },
+	} {
+		if check() == nil {
+			t.Errorf("%s: expected verification to fail", name)
+		}
+	}
+}
+
+func TestFromAuditLog(t *testing.T) {
+	tests := []struct {
+		log  models.AuditLog
+		want string
+	}{
+		{models.AuditLog{Action: "login_failed", Resource: "user"}, EventLoginFailed},
+		{models.AuditLog{Action: "assign_role", Resource: "user"}, EventRoleAssigned},
+		{models.AuditLog{Action: "deactivate", Resource: "user"}, EventUserDeactivated},
+		{models.AuditLog{Action: "update", Resource: "user", Changes: models.AuditChanges{
+			"active": {Old: true, New: false},
+		}}, EventUserDeactivated},
+		{models.AuditLog{Action: "update", Resource: "user", Changes: models.AuditChanges{
+			"email": {Old: "a@example.com", New: "b@example.com"},
+		}}, ""},
+		{models.AuditLog{Action: "login", Resource: "session"}, ""},
+	}
+
+	for _, tt := range tests {
+		event, ok := FromAuditLog(&tt.log)
+		got := ""
+		if ok {
+			got = event.Type
+		}
+		if got != tt.want {
+			t.Errorf("%s %s: got %q, want %q", tt.log.Action, tt.log.Resource, got, tt.want)
+		}
+		if ok && !ValidEventType(got) {
+			t.Errorf("%s is not a valid event type", got)
+		}
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	for attempts, want := range map[int]time.Duration{
+		1:  30 * time.Second,
+		2:  time.Minute,
+		5:  8 * time.Minute,
+		10: 256 * time.Minute,
+		11: 6 * time.Hour,
+		40: 6 * time.Hour,
+	} {
+		if got := Backoff(attempts); got != want {
+			t.Errorf("Backoff(%d) = %v, want %v", attempts, got, want)
+		}
+	}
+}
+
+// memoryQueue is an in-memory Queue recording every attempt
+type memoryQueue struct {
+	mu       sync.Mutex
+	due      []*models.WebhookDelivery
+	recorded map[int]time.Time
+}
+
+func (q *memoryQueue) ClaimDue(limit int, lease time.Duration) ([]*models.WebhookDelivery, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	n := min(limit, len(q.due))
+	claimed := q.due[:n]
+	q.due = q.due[n:]
+	return claimed, nil
+}
+
+func (q *memoryQueue) RecordAttempt(delivery *models.WebhookDelivery, nextAttemptAt time.Time) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.recorded[delivery.ID] = nextAttemptAt
+	return nil
+}
+
+func TestDispatchDue(t *testing.T) {
+	var mu sync.Mutex
+	received := map[string]string{}
+	subscriber := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if err := Verify("whsec_good", r.Header.Get(SignatureHeader), body, DefaultTolerance, time.Now()); err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		mu.Lock()
+		received[r.Header.Get(DeliveryHeader)] = r.Header.Get(EventHeader)
+		mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer subscriber.Close()
+
+	redirecting := httptest.NewServer(http.RedirectHandler(subscriber.URL, http.StatusFound))
+	defer redirecting.Close()
+
+	payload, _ := json.Marshal(FromCredentialAccess(&models.CredentialAccess{ID: 1, CredentialID: 2}))
+	delivery := func(id int, url, secret string, attempts int) *models.WebhookDelivery {
+		return &models.WebhookDelivery{
+			ID: id, SubscriptionID: id, EventType: EventCredentialRevealed, Payload: payload,
+			Status: models.WebhookPending, Attempts: attempts,
+			Subscription: &models.WebhookSubscription{ID: id, URL: url, Secret: secret},
+		}
+	}
+	good := delivery(1, subscriber.URL, "whsec_good", 0)
+	badSecret := delivery(2, subscriber.URL, "whsec_bad", 0)
+	redirected := delivery(3, redirecting.URL, "whsec_good", 0)
+	exhausted := delivery(4, subscriber.URL, "whsec_bad", maxAttempts-1)
+
+	queue := &memoryQueue{
+		due:      []*models.WebhookDelivery{good, badSecret, redirected, exhausted},
+		recorded: map[int]time.Time{},
+	}
+	now := time.Now()
+	dispatcher := NewDispatcher(queue, log.New(io.Discard, "", 0))
+	dispatcher.now = func() time.Time { return now }
+
+	attempted, err := dispatcher.DispatchDue(context.Background())
+	if err != nil || attempted != 4 {
+		t.Fatalf("DispatchDue = %d, %v", attempted, err)
+	}
+
+	if good.Status != models.WebhookDelivered || good.ResponseStatus != http.StatusNoContent || received["1"] != EventCredentialRevealed {
+		t.Errorf("good delivery: %+v", good)
+	}
+	if badSecret.Status != models.WebhookPending || badSecret.ResponseStatus != http.StatusUnauthorized ||
+		!queue.recorded[2].Equal(now.Add(minBackoff)) {
+		t.Errorf("rejected delivery: %+v, next attempt %v", badSecret, queue.recorded[2])
+	}
+	if redirected.Status != models.WebhookPending || redirected.ResponseStatus != http.StatusFound || len(received) != 1 {
+		t.Errorf("redirect was followed: %+v", redirected)
+	}
+	if exhausted.Status != models.WebhookFailed || exhausted.Attempts != maxAttempts || exhausted.LastError == "" {
+		t.Errorf("exhausted delivery: %+v", exhausted)
+	}
+}
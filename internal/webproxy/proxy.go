@@ -0,0 +1,197 @@
+// Package webproxy relays browsers to internal web consoles, each served at
+// the root of an origin of its own, logging into the console with a vaulted
+// credential that never reaches the browser: it is added to requests on
+// their way upstream and masked wherever the console echoes it back.
+// Consoles are not mounted under path prefixes, since consoles sharing an
+// origin could script each other.
+package webproxy
+
+import (
+	"encoding/base64"
+	"errors"
+	"log"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+)
+
+// Injection is the header carrying the credential upstream
+type Injection struct {
+	Header string
+	Value  string
+}
+
+// BasicAuth injects a username and password as HTTP basic authentication
+func BasicAuth(username, password string) Injection {
+	return Injection{
+		Header: "Authorization",
+		Value:  "Basic " + base64.StdEncoding.EncodeToString([]byte(username+":"+password)),
+	}
+}
+
+// APIKey injects a key in the named header, or as a bearer token in the
+// Authorization header when header is empty
+func APIKey(header, key string) Injection {
+	if header == "" {
+		return Injection{Header: "Authorization", Value: "Bearer " + key}
+	}
+	return Injection{Header: http.CanonicalHeaderKey(header), Value: key}
+}
+
+// Target describes a console and how to log into it
+type Target struct {
+	// URL is the console's base URL
+	URL *url.URL
+	// Inject carries the credential upstream
+	Inject Injection
+	// Secrets are masked in response headers and bodies. Secrets shorter
+	// than four bytes are not masked, since they would mangle whole pages.
+	Secrets []string
+	// Cookie names the proxy's own cookie, which is kept from the console
+	Cookie string
+	// Transport reaches the console; nil uses http.DefaultTransport
+	Transport http.RoundTripper
+	// ErrorLog receives errors reaching the console; nil uses the log package
+	ErrorLog *log.Logger
+}
+
+// ParseURL parses a credential's system as a console URL, defaulting to
+// HTTPS when no scheme is given
+func ParseURL(system string) (*url.URL, error) {
+	if !strings.Contains(system, "://") {
+		system = "https://" + system
+	}
+	u, err := url.Parse(system)
+	if err != nil {
+		return nil, err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, errors.New("system is not an HTTP or HTTPS URL")
+	}
+	if u.User != nil || u.RawQuery != "" || u.Fragment != "" {
+		return nil, errors.New("system URL must not carry credentials, a query or a fragment")
+	}
+	return u, nil
+}
+
+// New returns a handler relaying requests at the root of its host to the
+// console
+func New(target Target) http.Handler {
+	base := strings.TrimSuffix(target.URL.Path, "/")
+
+	var secrets [][]byte
+	for _, secret := range append([]string{target.Inject.Value}, target.Secrets...) {
+		if len(secret) >= 4 {
+			secrets = append(secrets, []byte(secret))
+		}
+	}
+
+	return &httputil.ReverseProxy{
+		Transport: target.Transport,
+		ErrorLog:  target.ErrorLog,
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			// SetURL joins the request's path onto the console's path
+			pr.SetURL(target.URL)
+			pr.SetXForwarded()
+
+			// Whatever the browser sent for authentication is replaced by the credential
+			pr.Out.Header.Del("Authorization")
+			pr.Out.Header.Del("Proxy-Authorization")
+			pr.Out.Header.Set(target.Inject.Header, target.Inject.Value)
+			if target.Cookie != "" {
+				stripCookie(pr.Out.Header, target.Cookie)
+			}
+
+			// Let the transport negotiate compression so bodies can be scanned
+			pr.Out.Header.Del("Accept-Encoding")
+		},
+		ModifyResponse: func(resp *http.Response) error {
+			rewriteLocation(resp, target.URL, base)
+			rewriteCookies(resp, base)
+			if len(secrets) == 0 {
+				return nil
+			}
+			for name, values := range resp.Header {
+				for i, value := range values {
+					values[i] = string(redactAll([]byte(value), secrets))
+				}
+				resp.Header[name] = values
+			}
+			if resp.StatusCode != http.StatusSwitchingProtocols {
+				resp.Body = newRedactor(resp.Body, secrets)
+				resp.Header.Del("Content-Length")
+				resp.ContentLength = -1
+			}
+			return nil
+		},
+	}
+}
+
+// stripCookie removes the named cookie from a request's Cookie headers
+func stripCookie(header http.Header, name string) {
+	var kept []string
+	for _, line := range header.Values("Cookie") {
+		for _, pair := range strings.Split(line, ";") {
+			if cookieName, _, _ := strings.Cut(strings.TrimSpace(pair), "="); cookieName != name {
+				kept = append(kept, strings.TrimSpace(pair))
+			}
+		}
+	}
+	header.Del("Cookie")
+	if len(kept) > 0 {
+		header.Set("Cookie", strings.Join(kept, "; "))
+	}
+}
+
+// rewriteLocation maps redirects within the console onto the proxy's host
+func rewriteLocation(resp *http.Response, console *url.URL, base string) {
+	location := resp.Header.Get("Location")
+	if location == "" {
+		return
+	}
+	u, err := url.Parse(location)
+	if err != nil {
+		return
+	}
+	if u.IsAbs() && (u.Scheme != console.Scheme || u.Host != console.Host) {
+		return
+	}
+	if !u.IsAbs() && !strings.HasPrefix(u.Path, "/") {
+		return // Relative to the current page, which stays on the proxy's host
+	}
+	if u.Path != base && !strings.HasPrefix(u.Path, base+"/") {
+		return
+	}
+
+	u.Path = strings.TrimPrefix(u.Path, base)
+	if u.Path == "" {
+		u.Path = "/"
+	}
+	u.RawPath = ""
+	u.Scheme, u.Host = "", ""
+	resp.Header.Set("Location", u.String())
+}
+
+// rewriteCookies maps the console's cookies from its base path onto the
+// root of the proxy's host
+func rewriteCookies(resp *http.Response, base string) {
+	lines := resp.Header.Values("Set-Cookie")
+	if len(lines) == 0 {
+		return
+	}
+	resp.Header.Del("Set-Cookie")
+	for _, line := range lines {
+		cookie, err := http.ParseSetCookie(line)
+		if err != nil {
+			continue
+		}
+		path := strings.TrimPrefix(cookie.Path, base)
+		if !strings.HasPrefix(path, "/") {
+			path = "/" + path
+		}
+		cookie.Path = path
+		cookie.Domain = ""
+		resp.Header.Add("Set-Cookie", cookie.String())
+	}
+}
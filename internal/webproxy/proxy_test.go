@@ -0,0 +1,125 @@
+package webproxy
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+// startConsole runs a console under /admin that reports what it received
+// and echoes the credential back, as settings pages do
+func startConsole(t *testing.T) *url.URL {
+	t.Helper()
+	console := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/admin/login":
+			http.Redirect(w, r, "/admin/home", http.StatusFound)
+		case "/admin/elsewhere":
+			http.Redirect(w, r, "https://example.com/admin/", http.StatusFound)
+		default:
+			http.SetCookie(w, &http.Cookie{Name: "console", Value: "1", Path: "/admin/", Domain: "internal"})
+			w.Header().Set("X-Api-Key-Echo", r.Header.Get("X-Api-Key"))
+			io.WriteString(w, "path="+r.URL.Path+" query="+r.URL.RawQuery+
+				" auth="+r.Header.Get("Authorization")+" key="+r.Header.Get("X-Api-Key")+
+				" cookie="+r.Header.Get("Cookie"))
+		}
+	}))
+	t.Cleanup(console.Close)
+
+	u, _ := url.Parse(console.URL + "/admin/")
+	return u
+}
+
+func serve(handler http.Handler, path string, header http.Header) *http.Response {
+	r := httptest.NewRequest("GET", path, nil)
+	for name, values := range header {
+		r.Header[name] = values
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	return w.Result()
+}
+
+func TestProxyInjectsAndRedacts(t *testing.T) {
+	handler := New(Target{
+		URL:     startConsole(t),
+		Inject:  APIKey("x-api-key", "s3cr3t-key"),
+		Secrets: []string{"s3cr3t-key"},
+		Cookie:  "pam_proxy",
+	})
+
+	resp := serve(handler, "/users/a%2Fb?page=2", http.Header{
+		"Authorization": {"Bearer mini-pam-token"},
+		"Cookie":        {"pam_proxy=abc; theme=dark"},
+	})
+	body, _ := io.ReadAll(resp.Body)
+
+	// The console sees its own path, the credential and none of the proxy's tokens
+	want := "path=/admin/users/a/b query=page=2 auth= key=[REDACTED] cookie=theme=dark"
+	if string(body) != want {
+		t.Errorf("body %q, want %q", body, want)
+	}
+	if resp.Header.Get("X-Api-Key-Echo") != "[REDACTED]" {
+		t.Errorf("echoed header %q", resp.Header.Get("X-Api-Key-Echo"))
+	}
+	if cookie := resp.Header.Get("Set-Cookie"); cookie != "console=1; Path=/" {
+		t.Errorf("set cookie %q", cookie)
+	}
+}
+
+func TestProxyBasicAuth(t *testing.T) {
+	handler := New(Target{URL: startConsole(t), Inject: BasicAuth("admin", "hunter22")})
+	body, _ := io.ReadAll(serve(handler, "/", nil).Body)
+	if !strings.Contains(string(body), "auth=[REDACTED]") || strings.Contains(string(body), "YWRtaW46aHVudGVyMjI") {
+		t.Errorf("body %q", body)
+	}
+}
+
+func TestProxyRewritesRedirects(t *testing.T) {
+	handler := New(Target{URL: startConsole(t), Inject: APIKey("", "token")})
+
+	if location := serve(handler, "/login", nil).Header.Get("Location"); location != "/home" {
+		t.Errorf("console redirect to %q", location)
+	}
+	if location := serve(handler, "/elsewhere", nil).Header.Get("Location"); location != "https://example.com/admin/" {
+		t.Errorf("external redirect to %q", location)
+	}
+}
+
+func TestRedactorAcrossReads(t *testing.T) {
+	secrets := [][]byte{[]byte("topsecret")}
+	body := "a topsecret b topsecret"
+	r := newRedactor(io.NopCloser(iotest.OneByteReader(strings.NewReader(body))), secrets)
+	got, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "a [REDACTED] b [REDACTED]" {
+		t.Errorf("redacted %q", got)
+	}
+	if !bytes.Equal(redactAll([]byte("no match"), secrets), []byte("no match")) {
+		t.Error("redactAll changed text without secrets")
+	}
+}
+
+func TestParseURL(t *testing.T) {
+	for system, want := range map[string]string{
+		"console.internal:8443":      "https://console.internal:8443",
+		"http://grafana.internal/ui": "http://grafana.internal/ui",
+	} {
+		u, err := ParseURL(system)
+		if err != nil || u.String() != want {
+			t.Errorf("ParseURL(%q) = %v, %v", system, u, err)
+		}
+	}
+	for _, system := range []string{"ftp://files", "https://user:pw@console", "https://console/?a=1", "https://"} {
+		if _, err := ParseURL(system); err == nil {
+			t.Errorf("ParseURL(%q) accepted", system)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package webproxy
+
+import (
+	"bytes"
+	"io"
+)
+
+// mask replaces secrets found in responses
+var mask = []byte("[REDACTED]")
+
+// redactAll masks every occurrence of the secrets in b
+func redactAll(b []byte, secrets [][]byte) []byte {
+	for _, secret := range secrets {
+		b = bytes.ReplaceAll(b, secret, mask)
+	}
+	return b
+}
+
+// redactor masks secrets in a response body as it streams. It holds back
+// the last few bytes of what it has read, which may be the start of a
+// secret completed by the next read.
+type redactor struct {
+	body    io.ReadCloser
+	secrets [][]byte
+	keep    int
+	pending []byte // Read but possibly part of a secret
+	ready   []byte // Safe to hand out
+	err     error
+}
+
+func newRedactor(body io.ReadCloser, secrets [][]byte) *redactor {
+	keep := 0
+	for _, secret := range secrets {
+		keep = max(keep, len(secret)-1)
+	}
+	return &redactor{body: body, secrets: secrets, keep: keep}
+}
+
+func (r *redactor) Read(p []byte) (int, error) {
+	for len(r.ready) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+
+		chunk := make([]byte, 32<<10)
+		n, err := r.body.Read(chunk)
+		r.err = err
+		r.pending = redactAll(append(r.pending, chunk[:n]...), r.secrets)
+
+		cut := len(r.pending)
+		if r.err == nil {
+			cut = max(0, cut-r.keep)
+		}
+		r.ready = r.pending[:cut]
+		r.pending = append([]byte(nil), r.pending[cut:]...)
+	}
+
+	n := copy(p, r.ready)
+	r.ready = r.ready[n:]
+	return n, nil
+}
+
+func (r *redactor) Close() error {
+	return r.body.Close()
+}
@@ -0,0 +1,283 @@
+// Package websocket implements the server side of the WebSocket protocol
+// (RFC 6455) as far as mini-pam needs it: upgrading a request, streaming
+// messages to the client and noticing when it goes away.
+package websocket
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Frame opcodes
+const (
+	OpContinuation = 0x0
+	OpText         = 0x1
+	OpBinary       = 0x2
+	OpClose        = 0x8
+	OpPing         = 0x9
+	OpPong         = 0xa
+)
+
+// Close status codes
+const (
+	CloseNormal          = 1000
+	CloseGoingAway       = 1001
+	CloseProtocolError   = 1002
+	ClosePolicyViolation = 1008
+	CloseTooBig          = 1009
+	CloseInternalError   = 1011
+)
+
+// acceptGUID is appended to the client's key to prove the handshake was
+// understood as a WebSocket one
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// MaxMessageSize bounds the messages read from clients
+const MaxMessageSize = 64 << 10
+
+// writeTimeout bounds each write so a stalled client cannot block its writer
+const writeTimeout = 10 * time.Second
+
+// ErrClosed is returned by ReadMessage once the client closed the connection
+var ErrClosed = errors.New("websocket: connection closed")
+
+// Conn is an upgraded server-side WebSocket connection. Writes may be made
+// from several goroutines; reads must come from one.
+type Conn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+
+	mu     sync.Mutex // Serializes writes
+	closed bool
+}
+
+// Upgrade completes the WebSocket handshake for r, responding with an error
+// status when r is not a valid upgrade request. Cross-origin requests are
+// refused, so a page elsewhere cannot ride on a browser's client certificate.
+func Upgrade(w http.ResponseWriter, r *http.Request) (*Conn, error) {
+	fail := func(status int, message string) (*Conn, error) {
+		http.Error(w, message, status)
+		return nil, errors.New("websocket: " + message)
+	}
+
+	if r.Method != http.MethodGet {
+		return fail(http.StatusMethodNotAllowed, "upgrade requests must use GET")
+	}
+	if !headerContains(r.Header, "Connection", "upgrade") || !headerContains(r.Header, "Upgrade", "websocket") {
+		return fail(http.StatusBadRequest, "not a websocket upgrade request")
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		return fail(http.StatusUpgradeRequired, "unsupported websocket version")
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if decoded, err := base64.StdEncoding.DecodeString(key); err != nil || len(decoded) != 16 {
+		return fail(http.StatusBadRequest, "invalid Sec-WebSocket-Key")
+	}
+	if origin := r.Header.Get("Origin"); origin != "" {
+		u, err := url.Parse(origin)
+		if err != nil || !strings.EqualFold(u.Host, r.Host) {
+			return fail(http.StatusForbidden, "cross-origin websocket requests are not allowed")
+		}
+	}
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		return fail(http.StatusInternalServerError, "connection cannot be upgraded")
+	}
+	netConn, buffered, err := hijacker.Hijack()
+	if err != nil {
+		return fail(http.StatusInternalServerError, "connection cannot be upgraded")
+	}
+
+	// The HTTP server's deadlines no longer apply to the long-lived stream
+	netConn.SetDeadline(time.Time{})
+
+	digest := sha1.Sum([]byte(key + acceptGUID))
+	response := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(digest[:]) + "\r\n\r\n"
+	netConn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	if _, err := netConn.Write([]byte(response)); err != nil {
+		netConn.Close()
+		return nil, err
+	}
+
+	return &Conn{conn: netConn, reader: buffered.Reader}, nil
+}
+
+// headerContains reports whether a comma separated header holds token
+func headerContains(header http.Header, name, token string) bool {
+	for _, value := range header.Values(name) {
+		for _, field := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(field), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// WriteMessage sends data as a single unfragmented message
+func (c *Conn) WriteMessage(opcode int, data []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return ErrClosed
+	}
+	return c.writeFrame(opcode, data)
+}
+
+// writeFrame writes one final frame. Server frames are never masked.
+func (c *Conn) writeFrame(opcode int, data []byte) error {
+	header := make([]byte, 2, 10)
+	header[0] = 0x80 | byte(opcode)
+	switch {
+	case len(data) < 126:
+		header[1] = byte(len(data))
+	case len(data) <= 0xffff:
+		header[1] = 126
+		header = binary.BigEndian.AppendUint16(header, uint16(len(data)))
+	default:
+		header[1] = 127
+		header = binary.BigEndian.AppendUint64(header, uint64(len(data)))
+	}
+
+	c.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	if _, err := c.conn.Write(append(header, data...)); err != nil {
+		return err
+	}
+	return nil
+}
+
+// ReadMessage returns the next data message from the client, answering
+// pings along the way. It returns ErrClosed once the client closes.
+func (c *Conn) ReadMessage() (opcode int, data []byte, err error) {
+	for {
+		final, op, payload, err := c.readFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+
+		switch op {
+		case OpPing:
+			if err := c.WriteMessage(OpPong, payload); err != nil {
+				return 0, nil, err
+			}
+			continue
+		case OpPong:
+			continue
+		case OpClose:
+			c.Close(CloseNormal, "")
+			return 0, nil, ErrClosed
+		case OpText, OpBinary:
+			if opcode != 0 {
+				return 0, nil, c.protocolError("new message inside a fragmented one")
+			}
+			opcode = op
+		case OpContinuation:
+			if opcode == 0 {
+				return 0, nil, c.protocolError("continuation without a message")
+			}
+		default:
+			return 0, nil, c.protocolError(fmt.Sprintf("unknown opcode %d", op))
+		}
+
+		if len(data)+len(payload) > MaxMessageSize {
+			c.Close(CloseTooBig, "message too big")
+			return 0, nil, errors.New("websocket: message too big")
+		}
+		data = append(data, payload...)
+		if final {
+			return opcode, data, nil
+		}
+	}
+}
+
+// readFrame reads and unmasks one frame
+func (c *Conn) readFrame() (final bool, opcode int, payload []byte, err error) {
+	var header [2]byte
+	if _, err := io.ReadFull(c.reader, header[:]); err != nil {
+		return false, 0, nil, err
+	}
+	final = header[0]&0x80 != 0
+	opcode = int(header[0] & 0x0f)
+	if header[0]&0x70 != 0 {
+		return false, 0, nil, c.protocolError("reserved bits set")
+	}
+	if header[1]&0x80 == 0 {
+		return false, 0, nil, c.protocolError("client frames must be masked")
+	}
+
+	length := uint64(header[1] & 0x7f)
+	switch length {
+	case 126:
+		var extended [2]byte
+		if _, err := io.ReadFull(c.reader, extended[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(extended[:]))
+	case 127:
+		var extended [8]byte
+		if _, err := io.ReadFull(c.reader, extended[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(extended[:])
+	}
+	if opcode >= OpClose && (length > 125 || !final) {
+		return false, 0, nil, c.protocolError("invalid control frame")
+	}
+	if length > MaxMessageSize {
+		c.Close(CloseTooBig, "message too big")
+		return false, 0, nil, errors.New("websocket: message too big")
+	}
+
+	var mask [4]byte
+	if _, err := io.ReadFull(c.reader, mask[:]); err != nil {
+		return false, 0, nil, err
+	}
+	payload = make([]byte, length)
+	if _, err := io.ReadFull(c.reader, payload); err != nil {
+		return false, 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return final, opcode, payload, nil
+}
+
+// protocolError closes the connection for a misbehaving client
+func (c *Conn) protocolError(message string) error {
+	c.Close(CloseProtocolError, message)
+	return errors.New("websocket: " + message)
+}
+
+// Close sends a close frame with code and reason and closes the connection.
+// Closing an already closed connection does nothing.
+func (c *Conn) Close(code int, reason string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+
+	payload := binary.BigEndian.AppendUint16(nil, uint16(code))
+	if len(reason) > 123 {
+		reason = reason[:123]
+	}
+	c.writeFrame(OpClose, append(payload, reason...))
+	return c.conn.Close()
+}
@@ -0,0 +1,144 @@
+package websocket
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// dial opens a raw connection to server and sends a handshake with the
+// given extra headers, returning the connection and the response
+func dial(t *testing.T, server *httptest.Server, headers string) (net.Conn, *bufio.Reader, *http.Response) {
+	t.Helper()
+	conn, err := net.Dial("tcp", server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	io.WriteString(conn, "GET / HTTP/1.1\r\nHost: "+server.Listener.Addr().String()+"\r\n"+
+		"Connection: keep-alive, Upgrade\r\nUpgrade: websocket\r\nSec-WebSocket-Version: 13\r\n"+
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n"+headers+"\r\n")
+	reader := bufio.NewReader(conn)
+	response, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn, reader, response
+}
+
+// writeClientFrame writes a final masked frame as a client must
+func writeClientFrame(conn net.Conn, opcode int, data []byte) {
+	mask := []byte{1, 2, 3, 4}
+	frame := []byte{0x80 | byte(opcode), 0x80 | byte(len(data))}
+	frame = append(frame, mask...)
+	for i, b := range data {
+		frame = append(frame, b^mask[i%4])
+	}
+	conn.Write(frame)
+}
+
+// readServerFrame reads one unmasked frame
+func readServerFrame(t *testing.T, reader *bufio.Reader) (int, []byte) {
+	t.Helper()
+	var header [2]byte
+	if _, err := io.ReadFull(reader, header[:]); err != nil {
+		t.Fatal(err)
+	}
+	length := int(header[1] & 0x7f)
+	if length == 126 {
+		var extended [2]byte
+		io.ReadFull(reader, extended[:])
+		length = int(binary.BigEndian.Uint16(extended[:]))
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(reader, payload); err != nil {
+		t.Fatal(err)
+	}
+	return int(header[0] & 0x0f), payload
+}
+
+func TestConn(t *testing.T) {
+	received := make(chan string, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := Upgrade(w, r)
+		if err != nil {
+			return
+		}
+		conn.WriteMessage(OpText, []byte("hello"))
+		conn.WriteMessage(OpBinary, []byte(strings.Repeat("x", 300)))
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			received <- err.Error()
+			return
+		}
+		received <- string(data)
+		if _, _, err := conn.ReadMessage(); err != ErrClosed {
+			t.Errorf("expected ErrClosed, got %v", err)
+		}
+	}))
+	defer server.Close()
+
+	conn, reader, response := dial(t, server, "")
+	if response.StatusCode != http.StatusSwitchingProtocols ||
+		response.Header.Get("Sec-WebSocket-Accept") != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("handshake: %v %v", response.Status, response.Header)
+	}
+
+	if op, data := readServerFrame(t, reader); op != OpText || string(data) != "hello" {
+		t.Errorf("first frame: %d %q", op, data)
+	}
+	if op, data := readServerFrame(t, reader); op != OpBinary || len(data) != 300 {
+		t.Errorf("second frame: %d, %d bytes", op, len(data))
+	}
+
+	// Pings are answered while the server waits for a message
+	writeClientFrame(conn, OpPing, []byte("p"))
+	if op, data := readServerFrame(t, reader); op != OpPong || string(data) != "p" {
+		t.Errorf("pong: %d %q", op, data)
+	}
+	writeClientFrame(conn, OpText, []byte("from client"))
+	if got := <-received; got != "from client" {
+		t.Errorf("server received %q", got)
+	}
+
+	// Closing is echoed with a normal status
+	writeClientFrame(conn, OpClose, binary.BigEndian.AppendUint16(nil, CloseNormal))
+	if op, data := readServerFrame(t, reader); op != OpClose || binary.BigEndian.Uint16(data) != CloseNormal {
+		t.Errorf("close: %d %v", op, data)
+	}
+}
+
+func TestUpgradeRefusals(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if conn, err := Upgrade(w, r); err == nil {
+			conn.Close(CloseNormal, "")
+		}
+	}))
+	defer server.Close()
+
+	// A page on another site cannot open the stream
+	_, _, response := dial(t, server, "Origin: https://evil.example\r\n")
+	if response.StatusCode != http.StatusForbidden {
+		t.Errorf("cross-origin: got %s", response.Status)
+	}
+	_, _, response = dial(t, server, "Origin: http://"+server.Listener.Addr().String()+"\r\n")
+	if response.StatusCode != http.StatusSwitchingProtocols {
+		t.Errorf("same origin: got %s", response.Status)
+	}
+
+	// Plain requests are not upgraded
+	plain, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain.Body.Close()
+	if plain.StatusCode != http.StatusBadRequest {
+		t.Errorf("plain request: got %s", plain.Status)
+	}
+}
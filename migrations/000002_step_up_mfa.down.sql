@@ -0,0 +1,3 @@
+-- Drop tables in reverse order to respect foreign key constraints
+DROP TABLE IF EXISTS user_mfa;
+DROP TABLE IF EXISTS sessions;
@@ -0,0 +1,7 @@
+-- Restore credential_access to user-only access
+DELETE FROM credential_access WHERE user_id IS NULL;
+ALTER TABLE credential_access DROP COLUMN IF EXISTS service_account_id;
+ALTER TABLE credential_access ALTER COLUMN user_id SET NOT NULL;
+-- Drop tables in reverse order to respect foreign key constraints
+DROP TABLE IF EXISTS api_keys;
+DROP TABLE IF EXISTS service_accounts;
@@ -0,0 +1,2 @@
+-- Drop service_account_certificates table
+DROP TABLE IF EXISTS service_account_certificates;
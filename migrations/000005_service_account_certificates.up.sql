@@ -0,0 +1,11 @@
+-- Create service_account_certificates table (mTLS client identity bindings)
+CREATE TABLE IF NOT EXISTS service_account_certificates (
+    id SERIAL PRIMARY KEY,
+    service_account_id INTEGER NOT NULL REFERENCES service_accounts(id) ON DELETE CASCADE,
+    identity VARCHAR(1024) NOT NULL UNIQUE,
+    scopes TEXT[] NOT NULL DEFAULT '{}',
+    last_used_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+-- Create indexes
+CREATE INDEX IF NOT EXISTS idx_service_account_certificates_service_account_id ON service_account_certificates(service_account_id);
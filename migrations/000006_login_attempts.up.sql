@@ -0,0 +1,10 @@
+-- Create login_attempts table (failed login counters shared by all replicas)
+CREATE TABLE IF NOT EXISTS login_attempts (
+    scope VARCHAR(16) NOT NULL,
+    subject VARCHAR(255) NOT NULL,
+    failures INTEGER NOT NULL DEFAULT 0,
+    first_failure_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    last_failure_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    locked_until TIMESTAMP WITH TIME ZONE,
+    PRIMARY KEY (scope, subject)
+);
@@ -0,0 +1,3 @@
+-- Drop password_history table and password age tracking
+DROP TABLE IF EXISTS password_history;
+ALTER TABLE users DROP COLUMN IF EXISTS password_changed_at;
@@ -0,0 +1,8 @@
+-- Drop actor and change tracking columns from audit_logs
+DROP INDEX IF EXISTS idx_audit_logs_resource;
+ALTER TABLE audit_logs DROP COLUMN IF EXISTS changes;
+ALTER TABLE audit_logs DROP COLUMN IF EXISTS api_key_id;
+ALTER TABLE audit_logs DROP COLUMN IF EXISTS session_id;
+ALTER TABLE audit_logs DROP COLUMN IF EXISTS service_account_id;
+ALTER TABLE audit_logs ADD CONSTRAINT audit_logs_user_id_fkey
+    FOREIGN KEY (user_id) REFERENCES users(id) NOT VALID;
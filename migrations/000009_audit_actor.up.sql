@@ -0,0 +1,11 @@
+-- Record the acting service account, session and API key, and field changes.
+-- These are plain IDs rather than foreign keys so audit rows never change
+-- when the referenced rows are deleted.
+ALTER TABLE audit_logs ADD COLUMN IF NOT EXISTS service_account_id INTEGER;
+ALTER TABLE audit_logs ADD COLUMN IF NOT EXISTS session_id INTEGER;
+ALTER TABLE audit_logs ADD COLUMN IF NOT EXISTS api_key_id INTEGER;
+ALTER TABLE audit_logs ADD COLUMN IF NOT EXISTS changes JSONB;
+-- user_id now names the actor; keep the history of users that are deleted
+ALTER TABLE audit_logs DROP CONSTRAINT IF EXISTS audit_logs_user_id_fkey;
+-- Create indexes
+CREATE INDEX IF NOT EXISTS idx_audit_logs_resource ON audit_logs(resource, resource_id);
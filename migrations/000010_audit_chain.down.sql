@@ -0,0 +1,3 @@
+-- Drop the audit_logs hash chain
+ALTER TABLE audit_logs DROP COLUMN IF EXISTS hash;
+ALTER TABLE audit_logs DROP COLUMN IF EXISTS prev_hash;
@@ -0,0 +1,5 @@
+-- Chain audit_logs rows: each row stores the hash of the previous row and a
+-- hash over its own content and prev_hash. Rows written before this
+-- migration stay unchained.
+ALTER TABLE audit_logs ADD COLUMN IF NOT EXISTS prev_hash VARCHAR(64);
+ALTER TABLE audit_logs ADD COLUMN IF NOT EXISTS hash VARCHAR(64);
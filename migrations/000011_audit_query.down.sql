@@ -0,0 +1,3 @@
+-- Drop audit_logs query indexes
+DROP INDEX IF EXISTS idx_audit_logs_action;
+DROP INDEX IF EXISTS idx_audit_logs_timestamp_id;
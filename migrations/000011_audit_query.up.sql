@@ -0,0 +1,3 @@
+-- Support keyset pagination of audit_logs on (timestamp, id)
+CREATE INDEX IF NOT EXISTS idx_audit_logs_timestamp_id ON audit_logs(timestamp DESC, id DESC);
+CREATE INDEX IF NOT EXISTS idx_audit_logs_action ON audit_logs(action);
@@ -0,0 +1,41 @@
+-- Fold the audit_logs partitions back into a single table. Archived months
+-- stay in their archive files.
+DROP TABLE IF EXISTS audit_archives;
+
+ALTER TABLE audit_logs RENAME TO audit_logs_partitioned;
+ALTER SEQUENCE audit_logs_id_seq OWNED BY NONE;
+DROP INDEX IF EXISTS idx_audit_logs_user_id;
+DROP INDEX IF EXISTS idx_audit_logs_resource;
+DROP INDEX IF EXISTS idx_audit_logs_timestamp_id;
+DROP INDEX IF EXISTS idx_audit_logs_action;
+
+CREATE TABLE audit_logs (
+    id INTEGER PRIMARY KEY DEFAULT nextval('audit_logs_id_seq'),
+    user_id INTEGER,
+    action VARCHAR(50) NOT NULL,
+    resource VARCHAR(100) NOT NULL,
+    resource_id INTEGER,
+    timestamp TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    ip_address VARCHAR(45),
+    user_agent TEXT,
+    details TEXT,
+    service_account_id INTEGER,
+    session_id INTEGER,
+    api_key_id INTEGER,
+    changes JSONB,
+    prev_hash VARCHAR(64),
+    hash VARCHAR(64)
+);
+ALTER SEQUENCE audit_logs_id_seq OWNED BY audit_logs.id;
+
+INSERT INTO audit_logs SELECT id, user_id, action, resource, resource_id, timestamp, ip_address, user_agent, details,
+                              service_account_id, session_id, api_key_id, changes, prev_hash, hash
+FROM audit_logs_partitioned;
+DROP TABLE audit_logs_partitioned;
+
+-- Create indexes
+CREATE INDEX IF NOT EXISTS idx_audit_logs_user_id ON audit_logs(user_id);
+CREATE INDEX IF NOT EXISTS idx_audit_logs_timestamp ON audit_logs(timestamp);
+CREATE INDEX IF NOT EXISTS idx_audit_logs_resource ON audit_logs(resource, resource_id);
+CREATE INDEX IF NOT EXISTS idx_audit_logs_timestamp_id ON audit_logs(timestamp DESC, id DESC);
+CREATE INDEX IF NOT EXISTS idx_audit_logs_action ON audit_logs(action);
@@ -0,0 +1,78 @@
+-- Rebuild audit_logs as a table partitioned by month on timestamp, so old
+-- months can be archived and dropped whole. Partitions are named
+-- audit_logs_pYYYYMM; the server creates upcoming ones ahead of time.
+-- The primary key of a partitioned table must include the partition key.
+ALTER TABLE audit_logs RENAME TO audit_logs_unpartitioned;
+ALTER TABLE audit_logs_unpartitioned RENAME CONSTRAINT audit_logs_pkey TO audit_logs_unpartitioned_pkey;
+ALTER SEQUENCE audit_logs_id_seq OWNED BY NONE;
+DROP INDEX IF EXISTS idx_audit_logs_user_id;
+DROP INDEX IF EXISTS idx_audit_logs_timestamp;
+DROP INDEX IF EXISTS idx_audit_logs_resource;
+DROP INDEX IF EXISTS idx_audit_logs_timestamp_id;
+DROP INDEX IF EXISTS idx_audit_logs_action;
+
+CREATE TABLE audit_logs (
+    id INTEGER NOT NULL DEFAULT nextval('audit_logs_id_seq'),
+    user_id INTEGER,
+    action VARCHAR(50) NOT NULL,
+    resource VARCHAR(100) NOT NULL,
+    resource_id INTEGER,
+    timestamp TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    ip_address VARCHAR(45),
+    user_agent TEXT,
+    details TEXT,
+    service_account_id INTEGER,
+    session_id INTEGER,
+    api_key_id INTEGER,
+    changes JSONB,
+    prev_hash VARCHAR(64),
+    hash VARCHAR(64),
+    PRIMARY KEY (id, timestamp)
+) PARTITION BY RANGE (timestamp);
+ALTER SEQUENCE audit_logs_id_seq OWNED BY audit_logs.id;
+
+-- Create a partition for every month from the oldest entry through the next one
+DO $$
+DECLARE
+    month TIMESTAMP WITH TIME ZONE;
+BEGIN
+    SELECT date_trunc('month', COALESCE(MIN(timestamp), NOW()) AT TIME ZONE 'UTC') AT TIME ZONE 'UTC'
+    INTO month
+    FROM audit_logs_unpartitioned;
+    WHILE month <= NOW() + INTERVAL '1 month' LOOP
+        EXECUTE format(
+            'CREATE TABLE IF NOT EXISTS %I PARTITION OF audit_logs FOR VALUES FROM (%L) TO (%L)',
+            'audit_logs_p' || to_char(month AT TIME ZONE 'UTC', 'YYYYMM'),
+            month,
+            (month AT TIME ZONE 'UTC' + INTERVAL '1 month') AT TIME ZONE 'UTC'
+        );
+        month := (month AT TIME ZONE 'UTC' + INTERVAL '1 month') AT TIME ZONE 'UTC';
+    END LOOP;
+END $$;
+
+INSERT INTO audit_logs (id, user_id, action, resource, resource_id, timestamp, ip_address, user_agent, details,
+                        service_account_id, session_id, api_key_id, changes, prev_hash, hash)
+SELECT id, user_id, action, resource, resource_id, timestamp, ip_address, user_agent, details,
+       service_account_id, session_id, api_key_id, changes, prev_hash, hash
+FROM audit_logs_unpartitioned;
+DROP TABLE audit_logs_unpartitioned;
+
+-- Create indexes (created on every partition)
+CREATE INDEX IF NOT EXISTS idx_audit_logs_user_id ON audit_logs(user_id);
+CREATE INDEX IF NOT EXISTS idx_audit_logs_resource ON audit_logs(resource, resource_id);
+CREATE INDEX IF NOT EXISTS idx_audit_logs_timestamp_id ON audit_logs(timestamp DESC, id DESC);
+CREATE INDEX IF NOT EXISTS idx_audit_logs_action ON audit_logs(action);
+
+-- Create audit_archives table (one row per month exported and dropped)
+CREATE TABLE IF NOT EXISTS audit_archives (
+    id SERIAL PRIMARY KEY,
+    month DATE NOT NULL UNIQUE,
+    file_name TEXT NOT NULL,
+    sha256 VARCHAR(64) NOT NULL,
+    first_id INTEGER NOT NULL,
+    last_id INTEGER NOT NULL,
+    entry_count INTEGER NOT NULL,
+    last_hash VARCHAR(64) NOT NULL DEFAULT '',
+    archived_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    restored_at TIMESTAMP WITH TIME ZONE
+);
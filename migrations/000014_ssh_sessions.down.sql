@@ -0,0 +1,2 @@
+-- Drop SSH session table
+DROP TABLE IF EXISTS ssh_sessions;
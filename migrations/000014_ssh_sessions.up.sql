@@ -0,0 +1,24 @@
+-- Create ssh_sessions table (one row per connection relayed by the SSH proxy)
+CREATE TABLE IF NOT EXISTS ssh_sessions (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id),
+    credential_id INTEGER NOT NULL REFERENCES credentials(id),
+    target VARCHAR(255) NOT NULL,
+    target_username VARCHAR(255) NOT NULL,
+    ip_address VARCHAR(45) NOT NULL,
+    client_version VARCHAR(255) NOT NULL DEFAULT '',
+    started_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    ended_at TIMESTAMP WITH TIME ZONE,
+    recording_key VARCHAR(255),
+    recording_sha256 CHAR(64),
+    recording_size BIGINT,
+    input_recorded BOOLEAN NOT NULL DEFAULT FALSE,
+    truncated BOOLEAN NOT NULL DEFAULT FALSE,
+    -- Session output without terminal control sequences, for search
+    output_text TEXT NOT NULL DEFAULT '',
+    output_tsv TSVECTOR GENERATED ALWAYS AS (to_tsvector('simple', output_text)) STORED
+);
+-- Create indexes
+CREATE INDEX IF NOT EXISTS idx_ssh_sessions_user_id ON ssh_sessions(user_id, id DESC);
+CREATE INDEX IF NOT EXISTS idx_ssh_sessions_credential_id ON ssh_sessions(credential_id, id DESC);
+CREATE INDEX IF NOT EXISTS idx_ssh_sessions_output ON ssh_sessions USING GIN(output_tsv);
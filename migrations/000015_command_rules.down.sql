@@ -0,0 +1,2 @@
+-- Drop command_rules table
+DROP TABLE IF EXISTS command_rules;
@@ -0,0 +1,19 @@
+-- Create command_rules table (regular expressions checked against the
+-- commands run through the SSH proxy; rules without a role or credential
+-- apply to every user or target)
+CREATE TABLE IF NOT EXISTS command_rules (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(100) NOT NULL,
+    description TEXT NOT NULL DEFAULT '',
+    pattern TEXT NOT NULL,
+    action VARCHAR(10) NOT NULL CHECK (action IN ('allow', 'deny', 'notify')),
+    role_id INTEGER REFERENCES roles(id) ON DELETE CASCADE,
+    credential_id INTEGER REFERENCES credentials(id) ON DELETE CASCADE,
+    active BOOLEAN NOT NULL DEFAULT TRUE,
+    created_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+-- Create indexes
+CREATE INDEX IF NOT EXISTS idx_command_rules_role_id ON command_rules(role_id);
+CREATE INDEX IF NOT EXISTS idx_command_rules_credential_id ON command_rules(credential_id);
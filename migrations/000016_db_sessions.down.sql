@@ -0,0 +1,3 @@
+-- Drop database session tables
+DROP TABLE IF EXISTS db_queries;
+DROP TABLE IF EXISTS db_sessions;
@@ -0,0 +1,27 @@
+-- Create db_sessions table (one row per connection relayed by the Postgres proxy)
+CREATE TABLE IF NOT EXISTS db_sessions (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id),
+    credential_id INTEGER NOT NULL REFERENCES credentials(id),
+    target VARCHAR(255) NOT NULL,
+    database_name VARCHAR(255) NOT NULL,
+    target_username VARCHAR(255) NOT NULL,
+    ip_address VARCHAR(45) NOT NULL,
+    application_name VARCHAR(255) NOT NULL DEFAULT '',
+    started_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    ended_at TIMESTAMP WITH TIME ZONE,
+    query_count INTEGER NOT NULL DEFAULT 0
+);
+
+-- Create db_queries table (every query sent over a session, in order)
+CREATE TABLE IF NOT EXISTS db_queries (
+    id BIGSERIAL PRIMARY KEY,
+    session_id INTEGER NOT NULL REFERENCES db_sessions(id) ON DELETE CASCADE,
+    query TEXT NOT NULL,
+    executed_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+-- Create indexes
+CREATE INDEX IF NOT EXISTS idx_db_sessions_user_id ON db_sessions(user_id, id DESC);
+CREATE INDEX IF NOT EXISTS idx_db_sessions_credential_id ON db_sessions(credential_id, id DESC);
+CREATE INDEX IF NOT EXISTS idx_db_queries_session_id ON db_queries(session_id, id);
@@ -0,0 +1,3 @@
+-- Drop web session tables
+DROP TABLE IF EXISTS web_requests;
+DROP TABLE IF EXISTS web_sessions;
@@ -0,0 +1,35 @@
+-- Create web_sessions table (one row per browser session relayed to a web console)
+CREATE TABLE IF NOT EXISTS web_sessions (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id),
+    credential_id INTEGER NOT NULL REFERENCES credentials(id),
+    target VARCHAR(255) NOT NULL,
+    ip_address VARCHAR(45) NOT NULL,
+    user_agent TEXT NOT NULL DEFAULT '',
+    -- The one-time code that sets the browser's cookie, then the cookie's token
+    launch_hash VARCHAR(64) UNIQUE,
+    token_hash VARCHAR(64) UNIQUE,
+    started_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    last_seen_at TIMESTAMP WITH TIME ZONE,
+    ended_at TIMESTAMP WITH TIME ZONE,
+    ended_by INTEGER REFERENCES users(id),
+    request_count INTEGER NOT NULL DEFAULT 0
+);
+
+-- Create web_requests table (every request relayed over a session)
+CREATE TABLE IF NOT EXISTS web_requests (
+    id BIGSERIAL PRIMARY KEY,
+    session_id INTEGER NOT NULL REFERENCES web_sessions(id) ON DELETE CASCADE,
+    method VARCHAR(16) NOT NULL,
+    path TEXT NOT NULL,
+    status INTEGER NOT NULL,
+    bytes BIGINT NOT NULL DEFAULT 0,
+    duration_ms INTEGER NOT NULL DEFAULT 0,
+    requested_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+-- Create indexes
+CREATE INDEX IF NOT EXISTS idx_web_sessions_user_id ON web_sessions(user_id, id DESC);
+CREATE INDEX IF NOT EXISTS idx_web_sessions_credential_id ON web_sessions(credential_id, id DESC);
+CREATE INDEX IF NOT EXISTS idx_web_requests_session_id ON web_requests(session_id, id);
@@ -0,0 +1,3 @@
+-- Drop credential expiry tracking (cleared zero expiry times stay NULL)
+DROP INDEX IF EXISTS idx_credentials_expires_at;
+DROP TABLE IF EXISTS credential_expiry_notices;
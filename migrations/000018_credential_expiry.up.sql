@@ -0,0 +1,15 @@
+-- Credentials saved without an expiry were stored with Go's zero time
+UPDATE credentials SET expires_at = NULL WHERE expires_at = '0001-01-01 00:00:00+00';
+
+-- Create credential_expiry_notices table (one row per warning sent, so each
+-- window is announced once per expiry date and a new date starts over)
+CREATE TABLE IF NOT EXISTS credential_expiry_notices (
+    credential_id INTEGER NOT NULL REFERENCES credentials(id) ON DELETE CASCADE,
+    window_days INTEGER NOT NULL,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    notified_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (credential_id, window_days, expires_at)
+);
+
+-- Create indexes
+CREATE INDEX IF NOT EXISTS idx_credentials_expires_at ON credentials(expires_at) WHERE expires_at IS NOT NULL;
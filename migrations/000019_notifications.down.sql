@@ -0,0 +1,3 @@
+-- Drop notification tables
+DROP TABLE IF EXISTS notification_outbox;
+DROP TABLE IF EXISTS notification_preferences;
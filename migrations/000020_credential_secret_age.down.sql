@@ -0,0 +1,2 @@
+-- Drop secret age tracking
+ALTER TABLE credentials DROP COLUMN IF EXISTS secret_changed_at;
@@ -0,0 +1,7 @@
+-- Track when each credential's secret last changed, so its age can be
+-- checked against rotation policy. Earlier changes were not recorded; the
+-- last update is the best bound available for existing credentials.
+ALTER TABLE credentials ADD COLUMN IF NOT EXISTS secret_changed_at TIMESTAMP WITH TIME ZONE;
+UPDATE credentials SET secret_changed_at = updated_at WHERE secret_changed_at IS NULL;
+ALTER TABLE credentials ALTER COLUMN secret_changed_at SET DEFAULT NOW();
+ALTER TABLE credentials ALTER COLUMN secret_changed_at SET NOT NULL;
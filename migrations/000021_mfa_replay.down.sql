@@ -0,0 +1,2 @@
+-- Drop TOTP replay tracking
+ALTER TABLE user_mfa DROP COLUMN IF EXISTS last_step;
@@ -0,0 +1,3 @@
+-- Remember the last TOTP time step accepted for each user, so a code
+-- cannot be used twice within its validity window
+ALTER TABLE user_mfa ADD COLUMN IF NOT EXISTS last_step BIGINT;
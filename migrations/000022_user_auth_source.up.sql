@@ -0,0 +1,24 @@
+-- Record which backend vouches for each account and the backend's stable
+-- identifier for it (OIDC issuer and subject, LDAP username), so external
+-- logins return to the account they created rather than to whichever
+-- account shares the username
+ALTER TABLE users ADD COLUMN IF NOT EXISTS auth_source VARCHAR(16) NOT NULL DEFAULT 'local';
+ALTER TABLE users ADD COLUMN IF NOT EXISTS external_id VARCHAR(512);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_external_id ON users(auth_source, external_id) WHERE external_id IS NOT NULL;
+-- Earlier accounts were not labelled. Those provisioned by SCIM, or that
+-- have only ever logged in through one external backend, belong to it and
+-- are bound to their identifier on their next login; the rest stay local.
+UPDATE users SET auth_source = 'scim'
+WHERE id IN (
+    SELECT resource_id FROM audit_logs
+    WHERE action = 'create' AND resource = 'user' AND details = 'User provisioned'
+);
+UPDATE users u SET auth_source = logins.source
+FROM (
+    SELECT user_id, MIN(substring(details from 'via (\w+)$')) AS source
+    FROM audit_logs
+    WHERE action = 'login' AND resource = 'session'
+    GROUP BY user_id
+    HAVING COUNT(DISTINCT details) = 1
+) logins
+WHERE u.id = logins.user_id AND logins.source IN ('ldap', 'oidc');
@@ -0,0 +1,2 @@
+-- Drop the failure time saved by attempt reservations
+ALTER TABLE login_attempts DROP COLUMN IF EXISTS previous_failure_at;
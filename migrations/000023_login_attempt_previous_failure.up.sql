@@ -0,0 +1,3 @@
+-- Remember the failure time an attempt reservation replaced, so releasing
+-- an attempt that did not fail does not extend the counting window
+ALTER TABLE login_attempts ADD COLUMN IF NOT EXISTS previous_failure_at TIMESTAMP WITH TIME ZONE;